@@ -0,0 +1,79 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Access logging.
+
+package http
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// An AccessLogEntry describes a request a Server has answered, for
+// Server.AccessLog.
+type AccessLogEntry struct {
+	Request   *Request
+	Status    int           // status code of the response
+	Written   int64         // bytes of response body written
+	Start     time.Time     // when the request was read
+	Duration  time.Duration // until the response was finished
+	RequestID string        // see Server.RequestID
+	TraceID   string        // trace ID of the request's TraceContext, in hex
+	Geo       *GeoInfo      // where the client is, see Server.GeoIP
+}
+
+// String formats e in the Combined Log Format, followed by the
+// duration in seconds and, if there are, the request and trace IDs and
+// where the client is.
+func (e *AccessLogEntry) String() string {
+	r := e.Request
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	user := "-"
+	if r.URL != nil && r.URL.User != nil && r.URL.User.Username() != "" {
+		user = r.URL.User.Username()
+	}
+	s := fmt.Sprintf("%s - %s [%s] %q %d %d %q %q %.3f",
+		host, user, e.Start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method+" "+r.RequestURI+" "+r.Proto, e.Status, e.Written,
+		r.Referer(), r.UserAgent(), e.Duration.Seconds())
+	if e.RequestID != "" {
+		s += " id=" + e.RequestID
+	}
+	if e.TraceID != "" {
+		s += " trace=" + e.TraceID
+	}
+	if e.Geo != nil {
+		if g := e.Geo.String(); g != "" {
+			s += " " + g
+		}
+	}
+	return s
+}
+
+// logAccess reports the request answered by w, read at start, to the
+// Server's AccessLog.
+func (c *conn) logAccess(w *response, start time.Time) {
+	fn := c.server.AccessLog
+	if fn == nil {
+		return
+	}
+	e := &AccessLogEntry{
+		Request:   w.req,
+		Status:    w.status,
+		Written:   w.written,
+		Start:     start,
+		Duration:  time.Since(start),
+		RequestID: RequestIDFromContext(w.req.Context()),
+		Geo:       GeoFromContext(w.req.Context()),
+	}
+	if tc, ok := TraceContextFromContext(w.req.Context()); ok {
+		e.TraceID = tc.TraceIDString()
+	}
+	fn(e)
+}
@@ -0,0 +1,424 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Minimal ACME (RFC 8555) client used by AutoCertManager.
+
+package http
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// acmeError is an ACME problem document (RFC 8555, Section 6.7).
+type acmeError struct {
+	StatusCode int    `json:"-"`
+	Type       string `json:"type"`
+	Detail     string `json:"detail"`
+}
+
+func (e *acmeError) Error() string {
+	return fmt.Sprintf("acme: %d %s: %s", e.StatusCode, e.Type, e.Detail)
+}
+
+func (e *acmeError) badNonce() bool {
+	return e.Type == "urn:ietf:params:acme:error:badNonce"
+}
+
+type acmeDirectory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeOrder struct {
+	URL            string     `json:"-"`
+	Status         string     `json:"status"`
+	Authorizations []string   `json:"authorizations"`
+	Finalize       string     `json:"finalize"`
+	Certificate    string     `json:"certificate"`
+	Error          *acmeError `json:"error"`
+}
+
+type acmeChallenge struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+}
+
+type acmeAuthorization struct {
+	Status     string          `json:"status"`
+	Identifier acmeIdentifier  `json:"identifier"`
+	Challenges []acmeChallenge `json:"challenges"`
+}
+
+// acmeClient talks to a single ACME server on behalf of a single
+// account. It is safe for concurrent use.
+type acmeClient struct {
+	hc     *Client
+	dirURL string
+	key    *ecdsa.PrivateKey // P-256 account key
+
+	dirOnce sync.Once
+	dir     acmeDirectory
+	dirErr  error
+
+	mu     sync.Mutex
+	kid    string // account URL, once registered
+	nonces []string
+}
+
+func b64enc(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (c *acmeClient) client() *Client {
+	if c.hc != nil {
+		return c.hc
+	}
+	return DefaultClient
+}
+
+func (c *acmeClient) directory() (*acmeDirectory, error) {
+	c.dirOnce.Do(func() {
+		res, err := c.client().Get(c.dirURL)
+		if err != nil {
+			c.dirErr = err
+			return
+		}
+		defer res.Body.Close()
+		c.addNonce(res.Header)
+		if res.StatusCode != StatusOK {
+			c.dirErr = responseACMEError(res)
+			return
+		}
+		c.dirErr = json.NewDecoder(res.Body).Decode(&c.dir)
+	})
+	return &c.dir, c.dirErr
+}
+
+func (c *acmeClient) addNonce(h Header) {
+	if v := h.Get("Replay-Nonce"); v != "" {
+		c.mu.Lock()
+		c.nonces = append(c.nonces, v)
+		c.mu.Unlock()
+	}
+}
+
+func (c *acmeClient) nonce() (string, error) {
+	c.mu.Lock()
+	if n := len(c.nonces); n > 0 {
+		v := c.nonces[n-1]
+		c.nonces = c.nonces[:n-1]
+		c.mu.Unlock()
+		return v, nil
+	}
+	c.mu.Unlock()
+	dir, err := c.directory()
+	if err != nil {
+		return "", err
+	}
+	res, err := c.client().Head(dir.NewNonce)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	if v := res.Header.Get("Replay-Nonce"); v != "" {
+		return v, nil
+	}
+	return "", errors.New("acme: server did not return a nonce")
+}
+
+// jwk returns the JSON Web Key of the account key, with its members
+// in the lexicographic order required for thumbprints (RFC 7638).
+func (c *acmeClient) jwk() string {
+	pub := c.key.PublicKey
+	return fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`,
+		b64enc(padBytes(pub.X, 32)), b64enc(padBytes(pub.Y, 32)))
+}
+
+// thumbprint returns the base64url-encoded JWK thumbprint of the
+// account key, as used in key authorizations.
+func (c *acmeClient) thumbprint() string {
+	sum := sha256.Sum256([]byte(c.jwk()))
+	return b64enc(sum[:])
+}
+
+func (c *acmeClient) keyAuthorization(token string) string {
+	return token + "." + c.thumbprint()
+}
+
+func padBytes(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// post sends a JWS-signed POST of payload to url. A nil payload
+// sends a POST-as-GET request. The caller must close the response
+// body. Non-2xx responses are returned as *acmeError.
+func (c *acmeClient) post(url string, payload interface{}) (*Response, error) {
+	for attempt := 0; ; attempt++ {
+		res, err := c.postOnce(url, payload)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode/100 == 2 {
+			return res, nil
+		}
+		aerr := responseACMEError(res)
+		res.Body.Close()
+		if e, ok := aerr.(*acmeError); ok && e.badNonce() && attempt < 2 {
+			continue
+		}
+		return nil, aerr
+	}
+}
+
+func (c *acmeClient) postOnce(url string, payload interface{}) (*Response, error) {
+	nonce, err := c.nonce()
+	if err != nil {
+		return nil, err
+	}
+	var body []byte
+	if payload != nil {
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	c.mu.Lock()
+	kid := c.kid
+	c.mu.Unlock()
+	var key string
+	if kid != "" {
+		key = fmt.Sprintf(`"kid":%q`, kid)
+	} else {
+		key = `"jwk":` + c.jwk()
+	}
+	prot := b64enc([]byte(fmt.Sprintf(`{"alg":"ES256",%s,"nonce":%q,"url":%q}`, key, nonce, url)))
+	pay := b64enc(body)
+	sum := sha256.Sum256([]byte(prot + "." + pay))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, sum[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := b64enc(append(padBytes(r, 32), padBytes(s, 32)...))
+	jws := fmt.Sprintf(`{"protected":%q,"payload":%q,"signature":%q}`, prot, pay, sig)
+
+	req, err := NewRequest("POST", url, strings.NewReader(jws))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/jose+json")
+	res, err := c.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	c.addNonce(res.Header)
+	return res, nil
+}
+
+func responseACMEError(res *Response) error {
+	e := &acmeError{StatusCode: res.StatusCode}
+	b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if json.Unmarshal(b, e) != nil || e.Type == "" {
+		e.Detail = string(bytes.TrimSpace(b))
+	}
+	return e
+}
+
+func (c *acmeClient) postJSON(url string, payload, v interface{}) (*Response, error) {
+	res, err := c.post(url, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			return nil, fmt.Errorf("acme: decoding response from %s: %v", url, err)
+		}
+	}
+	return res, nil
+}
+
+// register creates or looks up the account for c's key, agreeing to
+// the server's terms of service.
+func (c *acmeClient) register(email string) error {
+	c.mu.Lock()
+	registered := c.kid != ""
+	c.mu.Unlock()
+	if registered {
+		return nil
+	}
+	dir, err := c.directory()
+	if err != nil {
+		return err
+	}
+	acct := map[string]interface{}{"termsOfServiceAgreed": true}
+	if email != "" {
+		acct["contact"] = []string{"mailto:" + email}
+	}
+	res, err := c.postJSON(dir.NewAccount, acct, nil)
+	if err != nil {
+		return err
+	}
+	kid := res.Header.Get("Location")
+	if kid == "" {
+		return errors.New("acme: account response has no Location")
+	}
+	c.mu.Lock()
+	c.kid = kid
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *acmeClient) newOrder(domain string) (*acmeOrder, error) {
+	dir, err := c.directory()
+	if err != nil {
+		return nil, err
+	}
+	o := new(acmeOrder)
+	req := map[string]interface{}{
+		"identifiers": []acmeIdentifier{{Type: "dns", Value: domain}},
+	}
+	res, err := c.postJSON(dir.NewOrder, req, o)
+	if err != nil {
+		return nil, err
+	}
+	o.URL = res.Header.Get("Location")
+	return o, nil
+}
+
+func (c *acmeClient) authorization(url string) (*acmeAuthorization, error) {
+	a := new(acmeAuthorization)
+	if _, err := c.postJSON(url, nil, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// acceptChallenge tells the server that the challenge response is
+// ready to be validated.
+func (c *acmeClient) acceptChallenge(ch *acmeChallenge) error {
+	_, err := c.postJSON(ch.URL, struct{}{}, nil)
+	return err
+}
+
+// acmePollInterval is how long to wait between polls of pending
+// authorizations and orders when the server sends no Retry-After.
+var acmePollInterval = 1 * time.Second
+
+// acmePollTimeout bounds how long a single authorization or order may
+// remain pending.
+const acmePollTimeout = 2 * time.Minute
+
+func retryAfter(h Header) time.Duration {
+	if v := h.Get("Retry-After"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return time.Duration(n) * time.Second
+		}
+		if t, err := ParseTime(v); err == nil {
+			return t.Sub(time.Now())
+		}
+	}
+	return acmePollInterval
+}
+
+// waitAuthorization polls url until the authorization is no longer
+// pending.
+func (c *acmeClient) waitAuthorization(url string) error {
+	deadline := time.Now().Add(acmePollTimeout)
+	for {
+		a := new(acmeAuthorization)
+		res, err := c.postJSON(url, nil, a)
+		if err != nil {
+			return err
+		}
+		switch a.Status {
+		case "valid":
+			return nil
+		case "pending", "processing":
+		default:
+			return fmt.Errorf("acme: authorization for %s is %s", a.Identifier.Value, a.Status)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("acme: timeout waiting for authorization of %s", a.Identifier.Value)
+		}
+		time.Sleep(retryAfter(res.Header))
+	}
+}
+
+// finalize submits the DER-encoded CSR for the order and waits for
+// the certificate to be issued. It returns the PEM certificate chain.
+func (c *acmeClient) finalize(o *acmeOrder, csr []byte) ([][]byte, error) {
+	req := map[string]string{"csr": b64enc(csr)}
+	if _, err := c.postJSON(o.Finalize, req, o); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(acmePollTimeout)
+	for o.Status != "valid" {
+		if o.Status != "pending" && o.Status != "processing" && o.Status != "ready" {
+			if o.Error != nil {
+				return nil, o.Error
+			}
+			return nil, fmt.Errorf("acme: order is %s", o.Status)
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("acme: timeout waiting for certificate issuance")
+		}
+		res, err := c.postJSON(o.URL, nil, o)
+		if err != nil {
+			return nil, err
+		}
+		if o.Status != "valid" {
+			time.Sleep(retryAfter(res.Header))
+		}
+	}
+	res, err := c.post(o.Certificate, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	var chain [][]byte
+	for {
+		var p *pem.Block
+		p, b = pem.Decode(b)
+		if p == nil {
+			break
+		}
+		if p.Type == "CERTIFICATE" {
+			chain = append(chain, p.Bytes)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("acme: no certificates in issued chain")
+	}
+	return chain, nil
+}
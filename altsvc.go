@@ -0,0 +1,96 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Alt-Svc advertisement (RFC 7838).
+
+package http
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An AltService is an alternative service advertised in Alt-Svc
+// headers, where clients may reach the same origin, for example over
+// HTTP/3. See Server.AltSvc.
+type AltService struct {
+	// Protocol is the ALPN protocol ID of the service, such as
+	// "h2" or "h3".
+	Protocol string
+
+	// Host and Port are where the service listens. An empty Host
+	// is the host of the request.
+	Host string
+	Port int
+
+	// MaxAge is how long clients may remember the service. If
+	// zero, they may for 24 hours.
+	MaxAge time.Duration
+}
+
+// String returns s as an Alt-Svc header element, such as
+// `h3=":443"; ma=3600`.
+func (s AltService) String() string {
+	v := s.Protocol + `="` + net.JoinHostPort(s.Host, strconv.Itoa(s.Port)) + `"`
+	if s.MaxAge > 0 {
+		v += "; ma=" + strconv.FormatInt(int64(s.MaxAge/time.Second), 10)
+	}
+	return v
+}
+
+// quicAltSvcMaxAge is the MaxAge of the services of the listeners
+// served by ServeQUIC.
+const quicAltSvcMaxAge = 24 * time.Hour
+
+// altSvc returns the Alt-Svc header advertised on replies to requests
+// for host, a Request.Host, or "" if there is none.
+func (srv *Server) altSvc(host string) string {
+	services := srv.AltSvc
+	if len(srv.AltSvcHosts) > 0 {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if s, ok := srv.AltSvcHosts[strings.ToLower(host)]; ok {
+			services = s
+			if len(s) == 0 {
+				return ""
+			}
+		}
+	}
+	if len(services) == 0 {
+		v, _ := srv.quicAltSvc.Load().(string)
+		return v
+	}
+	return joinAltServices(services)
+}
+
+// joinAltServices returns the Alt-Svc header advertising services.
+func joinAltServices(services []AltService) string {
+	alts := make([]string, len(services))
+	for i, s := range services {
+		alts[i] = s.String()
+	}
+	return strings.Join(alts, ", ")
+}
+
+// updateQUICAltSvc sets the Alt-Svc header advertising the listeners
+// served by ServeQUIC, as they're added or removed. srv.mu must be
+// held.
+func (srv *Server) updateQUICAltSvc() {
+	var ports []int
+	for l := range srv.quic {
+		if a, ok := l.Addr().(*net.UDPAddr); ok {
+			ports = append(ports, a.Port)
+		}
+	}
+	sort.Ints(ports)
+	services := make([]AltService, len(ports))
+	for i, p := range ports {
+		services[i] = AltService{Protocol: "h3", Port: p, MaxAge: quicAltSvcMaxAge}
+	}
+	srv.quicAltSvc.Store(joinAltServices(services))
+}
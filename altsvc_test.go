@@ -0,0 +1,65 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	. "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAltServiceString(t *testing.T) {
+	tests := []struct {
+		s    AltService
+		want string
+	}{
+		{AltService{Protocol: "h3", Port: 443}, `h3=":443"`},
+		{AltService{Protocol: "h2", Host: "alt.example.com", Port: 8443, MaxAge: time.Hour}, `h2="alt.example.com:8443"; ma=3600`},
+		{AltService{Protocol: "h2", Host: "2001:db8::1", Port: 443}, `h2="[2001:db8::1]:443"`},
+	}
+	for _, tt := range tests {
+		if got := tt.s.String(); got != tt.want {
+			t.Errorf("%#v.String() = %q; want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestServerAltSvcConfig(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	ts.Config.AltSvc = []AltService{
+		{Protocol: "h3", Port: 443, MaxAge: time.Hour},
+		{Protocol: "h2", Host: "h2.example.com", Port: 443},
+	}
+	ts.Config.AltSvcHosts = map[string][]AltService{
+		"other.example.com": {{Protocol: "h3", Host: "eu.example.com", Port: 8443}},
+		"plain.example.com": nil,
+	}
+	ts.Start()
+	defer ts.Close()
+
+	tests := []struct {
+		host, want string
+	}{
+		{"", `h3=":443"; ma=3600, h2="h2.example.com:443"`},
+		{"Other.example.com:80", `h3="eu.example.com:8443"`},
+		{"plain.example.com", ""},
+	}
+	for _, tt := range tests {
+		req, _ := NewRequest("GET", ts.URL, nil)
+		if tt.host != "" {
+			req.Host = tt.host
+		}
+		res, err := DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if got := res.Header.Get("Alt-Svc"); got != tt.want {
+			t.Errorf("Host %q: Alt-Svc = %q; want %q", tt.host, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,638 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Automatic TLS certificate management using ACME.
+
+package http
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LetsEncryptURL is the directory URL of the Let's Encrypt
+// production ACME server.
+const LetsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+// acmeALPNProto is the ALPN protocol of TLS-ALPN-01 challenges
+// (RFC 8737).
+const acmeALPNProto = "acme-tls/1"
+
+// idPeACMEIdentifier is the id-pe-acmeIdentifier certificate
+// extension of TLS-ALPN-01 challenge certificates.
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// ErrCacheMiss is returned by a CertCache's Get method when the
+// requested key isn't present.
+var ErrCacheMiss = errors.New("http: certificate cache miss")
+
+// A CertCache stores certificates and account keys obtained by an
+// AutoCertManager so they survive process restarts. The data is
+// sensitive: it contains private keys.
+type CertCache interface {
+	// Get returns the data stored under key, or ErrCacheMiss.
+	Get(key string) ([]byte, error)
+
+	// Put stores data under key.
+	Put(key string, data []byte) error
+
+	// Delete removes the data stored under key. Deleting a
+	// missing key is not an error.
+	Delete(key string) error
+}
+
+// DirCache implements CertCache using a directory on the local file
+// system. The directory is created with 0700 permissions if needed.
+type DirCache string
+
+func (d DirCache) Get(key string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filepath.Join(string(d), key))
+	if os.IsNotExist(err) {
+		return nil, ErrCacheMiss
+	}
+	return b, err
+}
+
+func (d DirCache) Put(key string, data []byte) error {
+	if err := os.MkdirAll(string(d), 0700); err != nil {
+		return err
+	}
+	name := filepath.Join(string(d), key)
+	tmp := name + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func (d DirCache) Delete(key string) error {
+	err := os.Remove(filepath.Join(string(d), key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// HostWhitelist returns a policy for AutoCertManager.HostPolicy that
+// permits only the given host names. Names are compared
+// case-insensitively.
+func HostWhitelist(hosts ...string) func(host string) error {
+	allowed := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		allowed[strings.ToLower(strings.TrimSuffix(h, "."))] = true
+	}
+	return func(host string) error {
+		if !allowed[strings.ToLower(strings.TrimSuffix(host, "."))] {
+			return fmt.Errorf("http: autocert: host %q not configured", host)
+		}
+		return nil
+	}
+}
+
+// DefaultRenewBefore is the default value of
+// AutoCertManager.RenewBefore.
+const DefaultRenewBefore = 30 * 24 * time.Hour
+
+// An AutoCertManager obtains and renews TLS certificates from an
+// ACME certificate authority such as Let's Encrypt. Certificates are
+// obtained on demand, the first time a client connects with a given
+// server name, and renewed in the background before they expire.
+//
+// The manager answers TLS-ALPN-01 challenges through GetCertificate,
+// which requires the TLS listener to advertise the "acme-tls/1"
+// protocol (see TLSConfig). HTTP-01 challenges are answered only if
+// the handler returned by HTTPHandler is being served on port 80.
+//
+// By agreeing to use an AutoCertManager, the user accepts the
+// certificate authority's terms of service.
+type AutoCertManager struct {
+	// DirectoryURL is the ACME directory of the certificate
+	// authority. If empty, LetsEncryptURL is used.
+	DirectoryURL string
+
+	// Email optionally specifies a contact address for the ACME
+	// account, used by the CA to warn about expiring certificates.
+	Email string
+
+	// HostPolicy controls which server names certificates are
+	// requested for. It is consulted before contacting the CA. If
+	// nil, any name is permitted, which allows clients to make the
+	// manager request arbitrary certificates; use HostWhitelist.
+	HostPolicy func(host string) error
+
+	// Cache optionally stores obtained certificates and the
+	// account key. Without a cache, certificates are requested
+	// again after every restart, which can run into the CA's
+	// rate limits.
+	Cache CertCache
+
+	// RenewBefore is how long before expiry certificates are
+	// renewed. If zero, DefaultRenewBefore is used.
+	RenewBefore time.Duration
+
+	// Client is used to talk to the ACME server. If nil,
+	// DefaultClient is used.
+	Client *Client
+
+	acmeMu sync.Mutex // guards acme
+	acme   *acmeClient
+
+	mu         sync.Mutex                  // guards the following
+	certs      map[string]*autoCert        // by server name
+	httpTokens map[string]string           // HTTP-01 token => key authorization
+	alpnCerts  map[string]*tls.Certificate // server name => TLS-ALPN-01 certificate
+	tryHTTP01  bool                        // HTTPHandler has been called
+}
+
+// autoCert is the state of one server name's certificate.
+type autoCert struct {
+	sync.Mutex // held while obtaining the first certificate
+	cert       *tls.Certificate
+	renewal    *time.Timer
+}
+
+const acmeAccountKey = "acme_account+key"
+
+func (m *AutoCertManager) renewBefore() time.Duration {
+	if m.RenewBefore > 0 {
+		return m.RenewBefore
+	}
+	return DefaultRenewBefore
+}
+
+// TLSConfig returns a TLS configuration that obtains certificates
+// from m and supports TLS-ALPN-01 challenges.
+func (m *AutoCertManager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     []string{"http/1.1", acmeALPNProto},
+	}
+}
+
+// GetCertificate implements the tls.Config.GetCertificate hook. It
+// returns the certificate for hello.ServerName, obtaining one first
+// if necessary, or the challenge certificate if the client is an
+// ACME TLS-ALPN-01 validator.
+func (m *AutoCertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" {
+		return nil, errors.New("http: autocert: missing server name")
+	}
+	if strings.ContainsAny(name, `/\`) {
+		return nil, errors.New("http: autocert: invalid server name")
+	}
+	for _, proto := range hello.SupportedProtos {
+		if proto != acmeALPNProto {
+			continue
+		}
+		m.mu.Lock()
+		cert := m.alpnCerts[name]
+		m.mu.Unlock()
+		if cert == nil {
+			return nil, fmt.Errorf("http: autocert: no pending challenge for %q", name)
+		}
+		return cert, nil
+	}
+	if m.HostPolicy != nil {
+		if err := m.HostPolicy(name); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	if m.certs == nil {
+		m.certs = make(map[string]*autoCert)
+	}
+	ac, ok := m.certs[name]
+	if !ok {
+		ac = new(autoCert)
+		m.certs[name] = ac
+	}
+	m.mu.Unlock()
+
+	ac.Lock()
+	defer ac.Unlock()
+	if ac.cert != nil && time.Now().Before(ac.cert.Leaf.NotAfter) {
+		return ac.cert, nil
+	}
+	if cert, err := m.cachedCert(name); err == nil && time.Now().Before(cert.Leaf.NotAfter) {
+		ac.cert = cert
+		m.scheduleRenewal(name, ac)
+		return cert, nil
+	}
+	cert, err := m.obtain(name)
+	if err != nil {
+		return nil, err
+	}
+	ac.cert = cert
+	m.scheduleRenewal(name, ac)
+	return cert, nil
+}
+
+// HTTPHandler returns a handler that answers ACME HTTP-01 challenges
+// and passes all other requests to fallback. If fallback is nil,
+// other GET and HEAD requests are redirected to https and the rest
+// get a 400 Bad Request.
+//
+// Calling HTTPHandler enables HTTP-01 challenges, which are tried
+// after TLS-ALPN-01. The handler must be served on port 80.
+func (m *AutoCertManager) HTTPHandler(fallback Handler) Handler {
+	m.mu.Lock()
+	m.tryHTTP01 = true
+	m.mu.Unlock()
+	const prefix = "/.well-known/acme-challenge/"
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			if fallback != nil {
+				fallback.ServeHTTP(w, r)
+				return
+			}
+			if r.Method != "GET" && r.Method != "HEAD" {
+				respondError(w, r, StatusBadRequest, "Use HTTPS", errRedirectHTTPS)
+				return
+			}
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			Redirect(w, r, "https://"+host+r.URL.RequestURI(), StatusFound)
+			return
+		}
+		m.mu.Lock()
+		keyAuth, ok := m.httpTokens[r.URL.Path[len(prefix):]]
+		m.mu.Unlock()
+		if !ok {
+			NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(keyAuth))
+	})
+}
+
+func (m *AutoCertManager) scheduleRenewal(name string, ac *autoCert) {
+	if ac.renewal != nil {
+		ac.renewal.Stop()
+	}
+	d := ac.cert.Leaf.NotAfter.Sub(time.Now()) - m.renewBefore()
+	ac.renewal = time.AfterFunc(d, func() { m.renew(name, ac) })
+}
+
+// renewRetry is how long to wait before retrying a failed renewal.
+const renewRetry = time.Hour
+
+func (m *AutoCertManager) renew(name string, ac *autoCert) {
+	cert, err := m.obtain(name)
+	ac.Lock()
+	defer ac.Unlock()
+	if err != nil {
+		log.Printf("http: autocert: renewing certificate for %s: %v", name, err)
+		ac.renewal = time.AfterFunc(renewRetry, func() { m.renew(name, ac) })
+		return
+	}
+	ac.cert = cert
+	m.scheduleRenewal(name, ac)
+}
+
+func (m *AutoCertManager) challengeTypes() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	types := []string{"tls-alpn-01"}
+	if m.tryHTTP01 {
+		types = append(types, "http-01")
+	}
+	return types
+}
+
+// obtain requests a new certificate for name from the CA, trying
+// each supported challenge type in turn, and caches it.
+func (m *AutoCertManager) obtain(name string) (*tls.Certificate, error) {
+	client, err := m.acmeClient()
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, typ := range m.challengeTypes() {
+		var cert *tls.Certificate
+		cert, lastErr = m.obtainWith(client, name, typ)
+		if lastErr == nil {
+			m.cachePut(name, cert)
+			return cert, nil
+		}
+	}
+	return nil, lastErr
+}
+
+func (m *AutoCertManager) obtainWith(client *acmeClient, name, challengeType string) (*tls.Certificate, error) {
+	order, err := client.newOrder(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, zurl := range order.Authorizations {
+		if err := m.authorize(client, zurl, challengeType); err != nil {
+			return nil, err
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: name},
+		DNSNames: []string{name},
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := client.finalize(order, csr)
+	if err != nil {
+		return nil, err
+	}
+	return certFromChain(name, chain, key)
+}
+
+func (m *AutoCertManager) authorize(client *acmeClient, zurl, challengeType string) error {
+	z, err := client.authorization(zurl)
+	if err != nil {
+		return err
+	}
+	if z.Status == "valid" {
+		return nil
+	}
+	var chal *acmeChallenge
+	for i := range z.Challenges {
+		if z.Challenges[i].Type == challengeType {
+			chal = &z.Challenges[i]
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("http: autocert: CA offered no %s challenge for %s", challengeType, z.Identifier.Value)
+	}
+	keyAuth := client.keyAuthorization(chal.Token)
+	domain := z.Identifier.Value
+
+	m.mu.Lock()
+	switch challengeType {
+	case "tls-alpn-01":
+		cert, err := tlsALPNCert(domain, keyAuth)
+		if err != nil {
+			m.mu.Unlock()
+			return err
+		}
+		if m.alpnCerts == nil {
+			m.alpnCerts = make(map[string]*tls.Certificate)
+		}
+		m.alpnCerts[domain] = cert
+		defer func() {
+			m.mu.Lock()
+			delete(m.alpnCerts, domain)
+			m.mu.Unlock()
+		}()
+	case "http-01":
+		if m.httpTokens == nil {
+			m.httpTokens = make(map[string]string)
+		}
+		m.httpTokens[chal.Token] = keyAuth
+		defer func() {
+			m.mu.Lock()
+			delete(m.httpTokens, chal.Token)
+			m.mu.Unlock()
+		}()
+	}
+	m.mu.Unlock()
+
+	if err := client.acceptChallenge(chal); err != nil {
+		return err
+	}
+	return client.waitAuthorization(zurl)
+}
+
+// tlsALPNCert returns a self-signed TLS-ALPN-01 challenge certificate
+// for domain (RFC 8737, Section 3).
+func tlsALPNCert(domain, keyAuth string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(keyAuth))
+	ext, err := asn1.Marshal(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: domain},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		DNSNames:              []string{domain},
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		ExtraExtensions: []pkix.Extension{
+			{Id: idPeACMEIdentifier, Critical: true, Value: ext},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// certFromChain assembles a tls.Certificate from a DER chain, leaf
+// first, checking that the leaf is valid for name and matches key.
+func certFromChain(name string, chain [][]byte, key *ecdsa.PrivateKey) (*tls.Certificate, error) {
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := leaf.VerifyHostname(name); err != nil {
+		return nil, err
+	}
+	pub, ok := leaf.PublicKey.(*ecdsa.PublicKey)
+	if !ok || pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+		return nil, errors.New("http: autocert: certificate does not match private key")
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return nil, errors.New("http: autocert: certificate has expired")
+	}
+	return &tls.Certificate{Certificate: chain, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// acmeClient returns the manager's ACME client, loading or creating
+// and registering the account key on first use.
+func (m *AutoCertManager) acmeClient() (*acmeClient, error) {
+	m.acmeMu.Lock()
+	defer m.acmeMu.Unlock()
+	if m.acme != nil {
+		return m.acme, nil
+	}
+	key, err := m.accountKey()
+	if err != nil {
+		return nil, err
+	}
+	dirURL := m.DirectoryURL
+	if dirURL == "" {
+		dirURL = LetsEncryptURL
+	}
+	client := &acmeClient{hc: m.Client, dirURL: dirURL, key: key}
+	if err := client.register(m.Email); err != nil {
+		return nil, err
+	}
+	m.acme = client
+	return client, nil
+}
+
+func (m *AutoCertManager) accountKey() (*ecdsa.PrivateKey, error) {
+	if m.Cache != nil {
+		if b, err := m.Cache.Get(acmeAccountKey); err == nil {
+			if p, _ := pem.Decode(b); p != nil {
+				return x509.ParseECPrivateKey(p.Bytes)
+			}
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if m.Cache != nil {
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		m.Cache.Put(acmeAccountKey, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	}
+	return key, nil
+}
+
+// cachedCert loads the certificate for name from the cache. The
+// cache entry holds the PEM private key followed by the chain.
+func (m *AutoCertManager) cachedCert(name string) (*tls.Certificate, error) {
+	if m.Cache == nil {
+		return nil, ErrCacheMiss
+	}
+	b, err := m.Cache.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	var key *ecdsa.PrivateKey
+	var chain [][]byte
+	for {
+		var p *pem.Block
+		p, b = pem.Decode(b)
+		if p == nil {
+			break
+		}
+		switch p.Type {
+		case "EC PRIVATE KEY":
+			if key, err = x509.ParseECPrivateKey(p.Bytes); err != nil {
+				return nil, err
+			}
+		case "CERTIFICATE":
+			chain = append(chain, p.Bytes)
+		}
+	}
+	if key == nil || len(chain) == 0 {
+		return nil, errors.New("http: autocert: malformed cache entry for " + name)
+	}
+	return certFromChain(name, chain, key)
+}
+
+func (m *AutoCertManager) cachePut(name string, cert *tls.Certificate) {
+	if m.Cache == nil {
+		return
+	}
+	der, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	for _, c := range cert.Certificate {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: c})
+	}
+	if err := m.Cache.Put(name, buf.Bytes()); err != nil {
+		log.Printf("http: autocert: caching certificate for %s: %v", name, err)
+	}
+}
+
+// ListenAndServeAutoTLS listens on the TCP network address srv.Addr
+// and serves HTTPS using certificates obtained automatically from an
+// ACME certificate authority for the given domains. See ServeAutoTLS.
+//
+// If srv.Addr is blank, ":https" is used.
+func (srv *Server) ListenAndServeAutoTLS(domains ...string) error {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":https"
+	}
+	l, err := srv.listen("tcp", addr, false)
+	if err != nil {
+		return err
+	}
+	return srv.ServeAutoTLS(l, domains...)
+}
+
+// ServeAutoTLS accepts incoming TLS connections on the Listener l,
+// which may wrap its connections (to consume a PROXY protocol header,
+// for example), using certificates managed by srv.AutoCert. If
+// srv.AutoCert is nil, a manager using Let's Encrypt without a cache
+// is created.
+//
+// If any domains are given, certificates are only requested for
+// those names, in addition to any AutoCert.HostPolicy. Settings from
+// srv.TLSConfig other than certificates are preserved.
+func (srv *Server) ServeAutoTLS(l net.Listener, domains ...string) error {
+	m := srv.AutoCert
+	if m == nil {
+		m = &AutoCertManager{}
+	}
+	getCert := m.GetCertificate
+	if len(domains) > 0 {
+		allowed := HostWhitelist(domains...)
+		getCert = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if err := allowed(strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))); err != nil {
+				return nil, err
+			}
+			return m.GetCertificate(hello)
+		}
+	}
+	config := m.TLSConfig()
+	if srv.TLSConfig != nil {
+		config = srv.TLSConfig.Clone()
+		if config.NextProtos == nil {
+			config.NextProtos = []string{"http/1.1"}
+		}
+		config.NextProtos = append(append([]string(nil), config.NextProtos...), acmeALPNProto)
+		config.Certificates = nil
+	}
+	config.NextProtos = srv.nextProtos(config.NextProtos)
+	config.GetCertificate = getCert
+	srv.setClientAuth(config)
+	srv.setOCSPStapling(config)
+	srv.trackTLSConfig(config, true)
+	defer srv.trackTLSConfig(config, false)
+	return srv.Serve(tls.NewListener(l, config))
+}
@@ -0,0 +1,396 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	. "net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeACME is a minimal ACME certificate authority. It verifies
+// request signatures and validates challenges by calling directly
+// into the AutoCertManager under test.
+type fakeACME struct {
+	t          *testing.T
+	ts         *httptest.Server
+	challenges []string // challenge types to offer
+	m          *AutoCertManager
+	http01     Handler
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+
+	mu      sync.Mutex
+	nonce   int
+	jwk     map[string]string // account key
+	orders  []*fakeOrder
+	invalid []string // challenge validation failures
+}
+
+type fakeOrder struct {
+	domain string
+	status string // of the authorization
+	token  string
+	cert   []byte // DER
+}
+
+func newFakeACME(t *testing.T, challenges ...string) *fakeACME {
+	ca := &fakeACME{t: t, challenges: challenges}
+	var err error
+	ca.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake ACME CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &ca.caKey.PublicKey, ca.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.caCert, _ = x509.ParseCertificate(der)
+	ca.ts = httptest.NewServer(HandlerFunc(ca.serve))
+	return ca
+}
+
+func (ca *fakeACME) numOrders() int {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	return len(ca.orders)
+}
+
+func b64dec(s string) []byte {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func (ca *fakeACME) thumbprint() string {
+	j := ca.jwk
+	sum := sha256.Sum256([]byte(fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, j["crv"], j["kty"], j["x"], j["y"])))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// readJWS verifies the request's signature and returns the decoded
+// payload.
+func (ca *fakeACME) readJWS(r *Request) []byte {
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		ca.t.Fatalf("decoding JWS: %v", err)
+	}
+	var prot struct {
+		Alg, Nonce, URL, Kid string
+		JWK                  map[string]string
+	}
+	if err := json.Unmarshal(b64dec(jws.Protected), &prot); err != nil {
+		ca.t.Fatalf("decoding protected header: %v", err)
+	}
+	if prot.URL != ca.ts.URL+r.URL.Path {
+		ca.t.Errorf("JWS url = %q; want %q", prot.URL, ca.ts.URL+r.URL.Path)
+	}
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if prot.JWK != nil {
+		ca.jwk = prot.JWK
+	} else if prot.Kid != ca.ts.URL+"/account/1" {
+		ca.t.Errorf("JWS kid = %q", prot.Kid)
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(b64dec(ca.jwk["x"])),
+		Y:     new(big.Int).SetBytes(b64dec(ca.jwk["y"])),
+	}
+	sig := b64dec(jws.Signature)
+	sum := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if len(sig) != 64 || !ecdsa.Verify(pub, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		ca.t.Errorf("bad JWS signature on %s", r.URL.Path)
+	}
+	return b64dec(jws.Payload)
+}
+
+func (ca *fakeACME) serve(w ResponseWriter, r *Request) {
+	ca.mu.Lock()
+	ca.nonce++
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce%d", ca.nonce))
+	ca.mu.Unlock()
+
+	base := ca.ts.URL
+	var id int
+	switch {
+	case r.URL.Path == "/dir":
+		fmt.Fprintf(w, `{"newNonce":%q,"newAccount":%q,"newOrder":%q}`, base+"/nonce", base+"/account", base+"/order")
+		return
+	case r.URL.Path == "/nonce":
+		return
+	case r.URL.Path == "/account":
+		ca.readJWS(r)
+		w.Header().Set("Location", base+"/account/1")
+		w.WriteHeader(StatusCreated)
+		w.Write([]byte(`{"status":"valid"}`))
+		return
+	case r.URL.Path == "/order":
+		var req struct {
+			Identifiers []struct{ Type, Value string }
+		}
+		json.Unmarshal(ca.readJWS(r), &req)
+		ca.mu.Lock()
+		ca.orders = append(ca.orders, &fakeOrder{
+			domain: req.Identifiers[0].Value,
+			status: "pending",
+			token:  fmt.Sprintf("token%d", len(ca.orders)),
+		})
+		id = len(ca.orders) - 1
+		ca.mu.Unlock()
+		w.Header().Set("Location", fmt.Sprintf("%s/order/%d", base, id))
+		w.WriteHeader(StatusCreated)
+		ca.writeOrder(w, id)
+		return
+	}
+
+	var kind, typ string
+	elem := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	fmt.Sscan(elem[1], &id)
+	kind = elem[0]
+	if len(elem) > 2 {
+		typ = elem[2]
+	}
+	payload := ca.readJWS(r)
+	ca.mu.Lock()
+	o := ca.orders[id]
+	ca.mu.Unlock()
+	switch kind {
+	case "order":
+		ca.writeOrder(w, id)
+	case "authz":
+		var chals []string
+		for _, c := range ca.challenges {
+			chals = append(chals, fmt.Sprintf(`{"type":%q,"url":"%s/chal/%d/%s","token":%q}`, c, base, id, c, o.token))
+		}
+		ca.mu.Lock()
+		status := o.status
+		ca.mu.Unlock()
+		fmt.Fprintf(w, `{"status":%q,"identifier":{"type":"dns","value":%q},"challenges":[%s]}`,
+			status, o.domain, strings.Join(chals, ","))
+	case "chal":
+		err := ca.validate(o, typ)
+		ca.mu.Lock()
+		if err != nil {
+			ca.invalid = append(ca.invalid, err.Error())
+			o.status = "invalid"
+		} else {
+			o.status = "valid"
+		}
+		ca.mu.Unlock()
+		w.Write([]byte(`{}`))
+	case "finalize":
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		csr, err := x509.ParseCertificateRequest(b64dec(req.CSR))
+		if err != nil {
+			ca.t.Fatalf("parsing CSR: %v", err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(id + 2)),
+			Subject:      pkix.Name{CommonName: csr.DNSNames[0]},
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.caCert, csr.PublicKey, ca.caKey)
+		if err != nil {
+			ca.t.Fatal(err)
+		}
+		ca.mu.Lock()
+		o.cert = der
+		ca.mu.Unlock()
+		ca.writeOrder(w, id)
+	case "cert":
+		ca.mu.Lock()
+		der := o.cert
+		ca.mu.Unlock()
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: ca.caCert.Raw})
+	default:
+		NotFound(w, r)
+	}
+}
+
+func (ca *fakeACME) writeOrder(w ResponseWriter, id int) {
+	ca.mu.Lock()
+	o := ca.orders[id]
+	status, cert := "pending", ""
+	if o.status == "valid" {
+		status = "ready"
+	}
+	if o.cert != nil {
+		status, cert = "valid", fmt.Sprintf("%s/cert/%d", ca.ts.URL, id)
+	}
+	ca.mu.Unlock()
+	fmt.Fprintf(w, `{"status":%q,"authorizations":["%s/authz/%d"],"finalize":"%s/finalize/%d","certificate":%q}`,
+		status, ca.ts.URL, id, ca.ts.URL, id, cert)
+}
+
+func (ca *fakeACME) validate(o *fakeOrder, typ string) error {
+	ca.mu.Lock()
+	keyAuth := o.token + "." + ca.thumbprint()
+	ca.mu.Unlock()
+	switch typ {
+	case "http-01":
+		rec := httptest.NewRecorder()
+		req, _ := NewRequest("GET", "http://"+o.domain+"/.well-known/acme-challenge/"+o.token, nil)
+		ca.http01.ServeHTTP(rec, req)
+		if got := rec.Body.String(); got != keyAuth {
+			return fmt.Errorf("http-01 response = %q; want %q", got, keyAuth)
+		}
+	case "tls-alpn-01":
+		cert, err := ca.m.GetCertificate(&tls.ClientHelloInfo{
+			ServerName:      o.domain,
+			SupportedProtos: []string{"acme-tls/1"},
+		})
+		if err != nil {
+			return err
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+		want := sha256.Sum256([]byte(keyAuth))
+		for _, ext := range leaf.Extensions {
+			if ext.Id.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}) {
+				var got []byte
+				if _, err := asn1.Unmarshal(ext.Value, &got); err != nil || !ext.Critical || !bytes.Equal(got, want[:]) {
+					return fmt.Errorf("bad acmeIdentifier extension %x", ext.Value)
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("no acmeIdentifier extension in challenge certificate")
+	}
+	return nil
+}
+
+func testAutoCert(t *testing.T, challenge string) {
+	defer afterTest(t)
+	ca := newFakeACME(t, challenge)
+	defer ca.ts.Close()
+
+	dir, err := ioutil.TempDir("", "autocert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca.m = &AutoCertManager{
+		DirectoryURL: ca.ts.URL + "/dir",
+		HostPolicy:   HostWhitelist("example.org"),
+		Cache:        DirCache(dir),
+	}
+	if challenge == "http-01" {
+		ca.http01 = ca.m.HTTPHandler(nil)
+	}
+	hello := &tls.ClientHelloInfo{ServerName: "Example.org"}
+	cert, err := ca.m.GetCertificate(hello)
+	if err != nil {
+		t.Fatalf("GetCertificate: %v (validation failures: %q)", err, ca.invalid)
+	}
+	if cert.Leaf == nil || len(cert.Leaf.DNSNames) != 1 || cert.Leaf.DNSNames[0] != "example.org" {
+		t.Fatalf("got certificate for %v; want example.org", cert.Leaf)
+	}
+	if len(cert.Certificate) != 2 {
+		t.Errorf("chain length = %d; want 2", len(cert.Certificate))
+	}
+	if len(ca.invalid) > 0 {
+		t.Errorf("validation failures: %q", ca.invalid)
+	}
+	orders := ca.numOrders()
+
+	again, err := ca.m.GetCertificate(hello)
+	if err != nil || again != cert {
+		t.Errorf("second GetCertificate = %p, %v; want cached %p", again, err, cert)
+	}
+	if _, err := ca.m.GetCertificate(&tls.ClientHelloInfo{ServerName: "evil.example"}); err == nil {
+		t.Error("GetCertificate for host outside policy succeeded")
+	}
+
+	// A new manager sharing the cache must not contact the CA.
+	m2 := &AutoCertManager{DirectoryURL: ca.ts.URL + "/dir", Cache: DirCache(dir)}
+	cached, err := m2.GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cached.Certificate[0], cert.Certificate[0]) {
+		t.Error("certificate loaded from cache differs from issued one")
+	}
+	if n := ca.numOrders(); n != orders {
+		t.Errorf("CA saw %d more orders after issuance; want 0", n-orders)
+	}
+}
+
+func TestAutoCertHTTP01(t *testing.T)    { testAutoCert(t, "http-01") }
+func TestAutoCertTLSALPN01(t *testing.T) { testAutoCert(t, "tls-alpn-01") }
+
+func TestHostWhitelist(t *testing.T) {
+	policy := HostWhitelist("Example.org", "www.example.org.")
+	for _, host := range []string{"example.org", "EXAMPLE.ORG", "www.Example.org", "example.org."} {
+		if err := policy(host); err != nil {
+			t.Errorf("%q: %v; want allowed", host, err)
+		}
+	}
+	for _, host := range []string{"example.com", "sub.example.org", ""} {
+		if err := policy(host); err == nil {
+			t.Errorf("%q allowed", host)
+		}
+	}
+}
+
+func TestAutoCertHTTPHandlerRedirect(t *testing.T) {
+	m := new(AutoCertManager)
+	h := m.HTTPHandler(nil)
+	req, _ := NewRequest("GET", "http://example.org:80/foo?bar=1", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != StatusFound {
+		t.Errorf("status = %d; want %d", rec.Code, StatusFound)
+	}
+	if g, e := rec.HeaderMap.Get("Location"), "https://example.org/foo?bar=1"; g != e {
+		t.Errorf("Location = %q; want %q", g, e)
+	}
+
+	req, _ = NewRequest("GET", "http://example.org/.well-known/acme-challenge/nope", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != StatusNotFound {
+		t.Errorf("unknown token status = %d; want 404", rec.Code)
+	}
+}
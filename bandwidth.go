@@ -0,0 +1,173 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Connection bandwidth limiting.
+
+package http
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultBandwidthBurst is the default number of bytes a
+// BandwidthLimiter lets through at once.
+const DefaultBandwidthBurst = 32 << 10
+
+// A BandwidthLimiter limits the rate at which a Server reads requests
+// from and writes responses to its connections, in bytes per second,
+// using token buckets for each connection and for all of them
+// together. The limits apply beneath HTTP, to the bytes of the
+// connection, headers and chunking included, but not to hijacked
+// connections.
+//
+// A BandwidthLimiter may be shared by several Servers, which then
+// share the overall rates. Its fields must not be changed once it is
+// in use.
+type BandwidthLimiter struct {
+	// ConnReadRate and ConnWriteRate, if positive, limit the rate
+	// of each connection.
+	ConnReadRate  float64
+	ConnWriteRate float64
+
+	// ReadRate and WriteRate, if positive, limit the rate of all
+	// connections together.
+	ReadRate  float64
+	WriteRate float64
+
+	// Burst is the number of bytes that may be transferred at once
+	// beyond the rates. If zero, DefaultBandwidthBurst is used.
+	Burst int
+
+	// ConnRates optionally returns the per-connection rates of a
+	// connection from a client, instead of ConnReadRate and
+	// ConnWriteRate, for example to throttle known heavy clients
+	// harder or exempt internal ones. The client's address is the
+	// source address of the connection's PROXY protocol header, if
+	// any, and its remote address otherwise.
+	ConnRates func(client net.Addr) (read, write float64)
+
+	mu          sync.Mutex // guards read and write
+	read, write tokenBucket
+}
+
+func (bl *BandwidthLimiter) burst() int {
+	if bl.Burst > 0 {
+		return bl.Burst
+	}
+	return DefaultBandwidthBurst
+}
+
+// limit returns the reader and writer of rwc, read from and written
+// to at the rates of bl.
+func (bl *BandwidthLimiter) limit(rwc net.Conn, line *ProxyLine) (io.Reader, io.Writer) {
+	read, write := bl.ConnReadRate, bl.ConnWriteRate
+	if bl.ConnRates != nil {
+		client := rwc.RemoteAddr()
+		if line != nil && line.Source != nil {
+			client = line.Source
+		}
+		read, write = bl.ConnRates(client)
+	}
+	var r io.Reader = rwc
+	var w io.Writer = rwc
+	if read > 0 || bl.ReadRate > 0 {
+		r = &limitedReader{r: rwc, bl: bl, rate: read}
+	}
+	if write > 0 || bl.WriteRate > 0 {
+		w = &limitedWriter{w: rwc, bl: bl, rate: write}
+	}
+	return r, w
+}
+
+// wait takes n bytes from the bucket b of a connection with rate and
+// from the overall bucket of bl, returning how long to wait for both
+// to be repaid.
+func (bl *BandwidthLimiter) wait(mu *sync.Mutex, b *tokenBucket, rate float64, write bool, n int) time.Duration {
+	now := time.Now()
+	burst := bl.burst()
+	var d time.Duration
+	if rate > 0 {
+		mu.Lock()
+		d = b.takeN(now, rate, burst, n)
+		mu.Unlock()
+	}
+	all, allRate := &bl.read, bl.ReadRate
+	if write {
+		all, allRate = &bl.write, bl.WriteRate
+	}
+	if allRate > 0 {
+		bl.mu.Lock()
+		if d2 := all.takeN(now, allRate, burst, n); d2 > d {
+			d = d2
+		}
+		bl.mu.Unlock()
+	}
+	return d
+}
+
+// takeN refills b up to burst at rate tokens per second as of now and
+// takes n tokens, going into debt if there are too few, and returns
+// how long until the debt is repaid.
+func (b *tokenBucket) takeN(now time.Time, rate float64, burst, n int) time.Duration {
+	b.refill(now, rate, burst)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// limitedReader reads from r at the rate of a connection, sleeping
+// after each read for the bytes read.
+type limitedReader struct {
+	r    io.Reader
+	bl   *BandwidthLimiter
+	rate float64
+
+	mu     sync.Mutex // guards bucket; reads may run in the background
+	bucket tokenBucket
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if burst := lr.bl.burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		time.Sleep(lr.bl.wait(&lr.mu, &lr.bucket, lr.rate, false, n))
+	}
+	return n, err
+}
+
+// limitedWriter writes to w at the rate of a connection, sleeping
+// before each write of at most a burst.
+type limitedWriter struct {
+	w    io.Writer
+	bl   *BandwidthLimiter
+	rate float64
+
+	mu     sync.Mutex // guards bucket
+	bucket tokenBucket
+}
+
+func (lw *limitedWriter) Write(p []byte) (n int, err error) {
+	burst := lw.bl.burst()
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > burst {
+			chunk = chunk[:burst]
+		}
+		time.Sleep(lw.bl.wait(&lw.mu, &lw.bucket, lw.rate, true, len(chunk)))
+		m, err := lw.w.Write(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerBandwidth(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	defer afterTest(t)
+	const size = 64 << 10
+	body := strings.Repeat("x", size)
+	download := func(bl *BandwidthLimiter, client *net.TCPAddr) time.Duration {
+		ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+			w.Write([]byte(body))
+		}))
+		ts.Config.Bandwidth = bl
+		var c *Client
+		if client != nil {
+			ts.StartProxy()
+			c = ts.ProxyClient(client)
+		} else {
+			ts.Start()
+			c = &Client{Transport: &Transport{DisableKeepAlives: true}}
+		}
+		defer ts.Close()
+		start := time.Now()
+		res, err := c.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil || len(b) != size {
+			t.Fatalf("read %d bytes, %v", len(b), err)
+		}
+		return time.Since(start)
+	}
+
+	// 64KB at 128KB/s with a 16KB burst takes about 375ms.
+	if d := download(&BandwidthLimiter{ConnWriteRate: 128 << 10, Burst: 16 << 10}, nil); d < 250*time.Millisecond {
+		t.Errorf("throttled download took %v; want at least 250ms", d)
+	}
+	if d := download(&BandwidthLimiter{WriteRate: 128 << 10, Burst: 16 << 10}, nil); d < 250*time.Millisecond {
+		t.Errorf("download throttled server-wide took %v; want at least 250ms", d)
+	}
+
+	// ConnRates sees the client's address from the PROXY header.
+	rates := func(client net.Addr) (read, write float64) {
+		if client.(*net.TCPAddr).IP.Equal(net.ParseIP("203.0.113.1")) {
+			return 0, 128 << 10
+		}
+		return 0, 0
+	}
+	bl := &BandwidthLimiter{ConnRates: rates, Burst: 16 << 10}
+	if d := download(bl, &net.TCPAddr{IP: net.ParseIP("203.0.113.1"), Port: 1}); d < 250*time.Millisecond {
+		t.Errorf("download of a throttled client took %v; want at least 250ms", d)
+	}
+	if d := download(bl, &net.TCPAddr{IP: net.ParseIP("203.0.113.2"), Port: 1}); d > 200*time.Millisecond {
+		t.Errorf("download of an unthrottled client took %v", d)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Bearer token authentication, RFC 6750.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Errors a TokenValidator returns to have a BearerAuthHandler reject a
+// token with the matching error code of RFC 6750.
+var (
+	ErrInvalidToken      = errors.New("http: invalid bearer token")
+	ErrInsufficientScope = errors.New("http: bearer token has insufficient scope")
+)
+
+var principalKey = &contextKey{"principal"}
+
+// A TokenValidator validates the bearer tokens of requests.
+type TokenValidator interface {
+	// ValidateToken returns the principal, such as a user or
+	// client, that token of r authenticates. It returns
+	// ErrInsufficientScope if the token is valid but doesn't grant
+	// access to r, and ErrInvalidToken, or any other error, if the
+	// token isn't valid.
+	ValidateToken(r *Request, token string) (principal interface{}, err error)
+}
+
+// The TokenValidatorFunc type is an adapter to allow the use of
+// ordinary functions as token validators.
+type TokenValidatorFunc func(r *Request, token string) (interface{}, error)
+
+// ValidateToken calls f(r, token).
+func (f TokenValidatorFunc) ValidateToken(r *Request, token string) (interface{}, error) {
+	return f(r, token)
+}
+
+// A BearerAuthHandler requires the requests of the handler it wraps to
+// carry a bearer token in their Authorization header, which its
+// Validator accepts, and gives the handler the principal the token
+// authenticates, which Principal returns.
+//
+// Requests without a token are answered with 401 Unauthorized, those
+// with a token the Validator rejects with 401 Unauthorized, or 403
+// Forbidden for ErrInsufficientScope, each with a WWW-Authenticate
+// challenge.
+type BearerAuthHandler struct {
+	// Handler is the handler protected.
+	Handler Handler
+
+	// Validator validates tokens.
+	Validator TokenValidator
+
+	// Realm and Scope, if not empty, are sent in challenges: the
+	// realm of the tokens, and the space-separated scopes a token
+	// requires.
+	Realm, Scope string
+}
+
+// BearerToken returns the bearer token of r's Authorization header,
+// or "" if it has none.
+func BearerToken(r *Request) string {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(auth[len(prefix):])
+}
+
+// Principal returns the principal of r, authenticated by a
+// BearerAuthHandler, or nil.
+func Principal(r *Request) interface{} {
+	return r.Context().Value(principalKey)
+}
+
+func (h *BearerAuthHandler) ServeHTTP(w ResponseWriter, r *Request) {
+	token := BearerToken(r)
+	if token == "" {
+		h.challenge(w, StatusUnauthorized, "", "")
+		return
+	}
+	principal, err := h.Validator.ValidateToken(r, token)
+	switch {
+	case err == ErrInsufficientScope:
+		h.challenge(w, StatusForbidden, "insufficient_scope", err.Error())
+		return
+	case err != nil:
+		// Other errors may tell more than clients should know.
+		h.challenge(w, StatusUnauthorized, "invalid_token", ErrInvalidToken.Error())
+		return
+	}
+	h.Handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey, principal)))
+}
+
+// challenge answers with code and a Bearer challenge with the error
+// code, if any, of RFC 6750, Section 3.1.
+func (h *BearerAuthHandler) challenge(w ResponseWriter, code int, errCode, desc string) {
+	var params []string
+	if h.Realm != "" {
+		params = append(params, "realm="+strconv.Quote(h.Realm))
+	}
+	if h.Scope != "" {
+		params = append(params, "scope="+strconv.Quote(h.Scope))
+	}
+	if errCode != "" {
+		params = append(params, `error="`+errCode+`"`,
+			"error_description="+strconv.Quote(strings.TrimPrefix(desc, "http: ")))
+	}
+	v := "Bearer"
+	if len(params) > 0 {
+		v += " " + strings.Join(params, ", ")
+	}
+	w.Header().Set("WWW-Authenticate", v)
+	Error(w, StatusText(code), code)
+}
@@ -0,0 +1,61 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"errors"
+	"fmt"
+	. "net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBearerAuthHandler(t *testing.T) {
+	h := &BearerAuthHandler{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			fmt.Fprintf(w, "hello %v", Principal(r))
+		}),
+		Validator: TokenValidatorFunc(func(r *Request, token string) (interface{}, error) {
+			switch token {
+			case "alice-token":
+				return "alice", nil
+			case "guest-token":
+				return nil, ErrInsufficientScope
+			case "revoked-token":
+				return nil, errors.New("token revoked")
+			}
+			return nil, ErrInvalidToken
+		}),
+		Realm: "api",
+		Scope: "read",
+	}
+	tests := []struct {
+		auth      string
+		code      int
+		challenge string
+	}{
+		{"", 401, `Bearer realm="api", scope="read"`},
+		{"Basic YWxpY2U6cHc=", 401, `Bearer realm="api", scope="read"`},
+		{"Bearer alice-token", 200, ""},
+		{"bearer  alice-token ", 200, ""},
+		{"Bearer guest-token", 403, `Bearer realm="api", scope="read", error="insufficient_scope", error_description="bearer token has insufficient scope"`},
+		{"Bearer nope", 401, `Bearer realm="api", scope="read", error="invalid_token", error_description="invalid bearer token"`},
+		{"Bearer revoked-token", 401, `Bearer realm="api", scope="read", error="invalid_token", error_description="invalid bearer token"`},
+	}
+	for _, tt := range tests {
+		req, _ := NewRequest("GET", "http://example.com/", nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.code || rec.Header().Get("Www-Authenticate") != tt.challenge {
+			t.Errorf("Authorization %q: %d, challenge %q; want %d, %q", tt.auth, rec.Code, rec.Header().Get("Www-Authenticate"), tt.code, tt.challenge)
+		}
+		if tt.code == 200 && rec.Body.String() != "hello alice" {
+			t.Errorf("Authorization %q: body %q; want principal alice", tt.auth, rec.Body.String())
+		}
+	}
+}
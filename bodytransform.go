@@ -0,0 +1,211 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Streaming transformation of message bodies.
+
+package http
+
+import (
+	"io"
+	"sync"
+)
+
+// A BodyTransformer transforms the bodies of messages as they stream,
+// such as to recompress them or to scrub personal data out of them,
+// without holding them whole. Transformers are set on a Transport,
+// for the requests it sends and the responses it receives, or on a
+// Server, for the requests it receives and the responses it sends.
+//
+// Transformed bodies have no known length: their Content-Length
+// header is dropped, and the messages sent with them are chunked.
+type BodyTransformer interface {
+	// TransformReader returns the body of a request or response
+	// with header h transformed as it is read from body, or nil to
+	// leave the body as it is. It may change h to match, such as its
+	// Content-Type; on a Server, changes to the header of a request
+	// are seen by the handler, and on a Transport, those to the
+	// header of a request are sent and those to the header of a
+	// response are returned. On a Server, body should not be read
+	// until the reader returned is, so that clients expecting 100
+	// Continue are told to send it only once the handler reads it.
+	TransformReader(h Header, body io.Reader) io.Reader
+
+	// TransformWriter returns a writer transforming what is written
+	// to it, the body of a response with header h that a Server
+	// sends, into w, or nil to leave the body as it is. It may
+	// change h, which isn't written yet. The writer is closed once
+	// the handler has returned, to write the end of the body, and
+	// flushed, if it has a Flush method, whenever the handler
+	// flushes the response.
+	TransformWriter(h Header, w io.Writer) io.WriteCloser
+}
+
+// BodyTransformerFuncs adapts functions to a BodyTransformer. A nil
+// field leaves the bodies it would transform as they are.
+type BodyTransformerFuncs struct {
+	Reader func(h Header, body io.Reader) io.Reader
+	Writer func(h Header, w io.Writer) io.WriteCloser
+}
+
+func (f BodyTransformerFuncs) TransformReader(h Header, body io.Reader) io.Reader {
+	if f.Reader == nil {
+		return nil
+	}
+	return f.Reader(h, body)
+}
+
+func (f BodyTransformerFuncs) TransformWriter(h Header, w io.Writer) io.WriteCloser {
+	if f.Writer == nil {
+		return nil
+	}
+	return f.Writer(h, w)
+}
+
+// transformReader returns body, of a message with header h,
+// transformed by each of ts in turn, or nil if none transforms it.
+func transformReader(ts []BodyTransformer, h Header, body io.Reader) io.Reader {
+	var out io.Reader
+	for _, bt := range ts {
+		if r := bt.TransformReader(h, body); r != nil {
+			body, out = r, r
+		}
+	}
+	if out != nil {
+		h.Del("Content-Length")
+	}
+	return out
+}
+
+// A transformedBody is a body read through BodyTransformers. Its done
+// function, if any, is called once it is read to the end or closed.
+type transformedBody struct {
+	io.Reader
+	body io.Closer // the original body
+	done func()
+	once sync.Once
+}
+
+func (b *transformedBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *transformedBody) Close() error {
+	b.finish()
+	return b.body.Close()
+}
+
+func (b *transformedBody) finish() {
+	if b.done != nil {
+		b.once.Do(b.done)
+	}
+}
+
+// transformRequestBody transforms the body of req, which a Server
+// received, for its handler.
+func (srv *Server) transformRequestBody(req *Request) {
+	if len(srv.BodyTransformers) == 0 || req.ContentLength == 0 {
+		return
+	}
+	if r := transformReader(srv.BodyTransformers, req.Header, req.Body); r != nil {
+		req.Body = &transformedBody{Reader: r, body: req.Body}
+		req.ContentLength = -1
+	}
+}
+
+// transformWriter sets up the BodyTransformers of the Server the
+// response w is sent by, as its header is written.
+func (w *response) transformWriter() {
+	ts := w.conn.server.BodyTransformers
+	if len(ts) == 0 || w.req.Method == "HEAD" || !bodyAllowedForStatus(w.status) {
+		return
+	}
+	// The first transformer gets what the handler writes, so the
+	// chain is built from the last, which writes to the connection.
+	var dst io.Writer = w.w
+	for i := len(ts) - 1; i >= 0; i-- {
+		if tw := ts[i].TransformWriter(w.handlerHeader, dst); tw != nil {
+			w.transform = append(w.transform, tw)
+			dst = tw
+		}
+	}
+	if len(w.transform) > 0 {
+		w.handlerHeader.Del("Content-Length")
+	}
+}
+
+// closeTransform closes the writers of a transformed response, from
+// the first, so that each, writing its end, reaches the next.
+func (w *response) closeTransform() {
+	for i := len(w.transform) - 1; i >= 0; i-- {
+		w.transform[i].Close()
+	}
+	w.transform = nil
+}
+
+// flushTransform flushes the writers of a transformed response that
+// can be.
+func (w *response) flushTransform() {
+	for i := len(w.transform) - 1; i >= 0; i-- {
+		switch f := w.transform[i].(type) {
+		case interface{ Flush() error }:
+			f.Flush()
+		case Flusher:
+			f.Flush()
+		}
+	}
+}
+
+// transformRoundTrip makes the round trip of req on pc with the
+// BodyTransformers of t applied to the bodies of req and its response.
+func (t *Transport) transformRoundTrip(pc *persistConn, req *Request) (*Response, error) {
+	treq := &transportRequest{Request: req}
+	if req.Body != nil {
+		h := req.Header.clone()
+		if r := transformReader(t.BodyTransformers, h, req.Body); r != nil {
+			r2 := new(Request)
+			*r2 = *req
+			r2.Header = h
+			r2.Body = &transformedBody{Reader: r, body: req.Body}
+			r2.ContentLength = -1
+			r2.GetBody = nil
+			treq.Request = r2
+			t.reqMu.Lock()
+			if t.transformed == nil {
+				t.transformed = make(map[*Request]*Request)
+			}
+			t.transformed[req] = r2
+			t.reqMu.Unlock()
+		}
+	}
+	untrack := func() {
+		if treq.Request != req {
+			t.reqMu.Lock()
+			delete(t.transformed, req)
+			t.reqMu.Unlock()
+		}
+	}
+	resp, err := pc.roundTrip(treq)
+	if err != nil {
+		untrack()
+		return nil, err
+	}
+	resp.Request = req
+	body := &transformedBody{Reader: resp.Body, body: resp.Body, done: untrack}
+	var r io.Reader
+	if req.Method != "HEAD" {
+		r = transformReader(t.BodyTransformers, resp.Header, resp.Body)
+	}
+	if r != nil {
+		body.Reader = r
+		resp.ContentLength = -1
+	} else if treq.Request == req {
+		return resp, nil
+	}
+	resp.Body = body
+	return resp, nil
+}
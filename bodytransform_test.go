@@ -0,0 +1,236 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// upperReader upper-cases what it reads.
+type upperReader struct{ r io.Reader }
+
+func (u upperReader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	copy(p, bytes.ToUpper(p[:n]))
+	return n, err
+}
+
+// scrubWriter masks the digits written through it.
+type scrubWriter struct{ w io.Writer }
+
+func (s scrubWriter) Write(p []byte) (int, error) {
+	return s.w.Write(bytes.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return '#'
+		}
+		return r
+	}, p))
+}
+
+func (s scrubWriter) Close() error { return nil }
+
+var upper = BodyTransformerFuncs{
+	Reader: func(h Header, body io.Reader) io.Reader {
+		h.Set("X-Upper", "1")
+		return upperReader{body}
+	},
+}
+
+var scrub = BodyTransformerFuncs{
+	Writer: func(h Header, w io.Writer) io.WriteCloser {
+		if !strings.HasPrefix(h.Get("Content-Type"), "text/") {
+			return nil
+		}
+		return scrubWriter{w}
+	},
+}
+
+var gzipResponses = BodyTransformerFuncs{
+	Writer: func(h Header, w io.Writer) io.WriteCloser {
+		h.Set("Content-Encoding", "gzip")
+		return gzip.NewWriter(w)
+	},
+}
+
+func TestServerBodyTransformers(t *testing.T) {
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", "99")
+		io.WriteString(w, r.Header.Get("X-Upper")+" "+string(b)+" card 4111-1111")
+	}))
+	ts.Config.BodyTransformers = []BodyTransformer{upper, scrub, gzipResponses}
+	ts.Start()
+	defer ts.Close()
+
+	c := &Client{Transport: &Transport{DisableCompression: true}}
+	res, err := c.Post(ts.URL, "text/plain", strings.NewReader("hello 42"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ce := res.Header.Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("Content-Encoding = %q; want gzip", ce)
+	}
+	zr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# HELLO ## card ####-####"; string(b) != want {
+		t.Errorf("body %q; want %q", b, want)
+	}
+}
+
+func TestServerBodyTransformerStreams(t *testing.T) {
+	next := make(chan bool)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "line 1\n")
+		w.(Flusher).Flush()
+		<-next
+		io.WriteString(w, "line 2\n")
+	}))
+	ts.Config.BodyTransformers = []BodyTransformer{scrub}
+	ts.Start()
+	defer ts.Close()
+
+	res, err := Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	br := bufio.NewReader(res.Body)
+	if line, err := br.ReadString('\n'); err != nil || line != "line #\n" {
+		t.Fatalf("first line %q, %v; want it before the handler returns", line, err)
+	}
+	close(next)
+	if rest, _ := ioutil.ReadAll(br); string(rest) != "line #\n" {
+		t.Errorf("second line %q", rest)
+	}
+}
+
+// A client expecting 100 Continue whose request is answered without
+// its body being read is not told to send it.
+func TestServerBodyTransformerExpectContinue(t *testing.T) {
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.WriteHeader(StatusUnauthorized)
+	}))
+	identity := BodyTransformerFuncs{
+		Reader: func(h Header, body io.Reader) io.Reader { return body },
+	}
+	ts.Config.BodyTransformers = []BodyTransformer{identity}
+	ts.Start()
+	defer ts.Close()
+
+	c, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(c, "POST / HTTP/1.1\r\nHost: foo\r\nExpect: 100-continue\r\nContent-Length: 10\r\n\r\n")
+	res, err := ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != StatusUnauthorized {
+		t.Errorf("status %d; want 401", res.StatusCode)
+	}
+}
+
+// upperEndWriter upper-cases what is written through it, ending it
+// with "<END>".
+type upperEndWriter struct{ w io.Writer }
+
+func (u upperEndWriter) Write(p []byte) (int, error) { return u.w.Write(bytes.ToUpper(p)) }
+
+func (u upperEndWriter) Close() error {
+	_, err := io.WriteString(u.w, "<END>")
+	return err
+}
+
+// Files served with sendfile go through the transformers too, on
+// connections without chunking.
+func TestServerBodyTransformerServeFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "transform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("hello world")
+	f.Close()
+
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		ServeFile(w, r, f.Name())
+	}))
+	ts.Config.BodyTransformers = []BodyTransformer{BodyTransformerFuncs{
+		Writer: func(h Header, w io.Writer) io.WriteCloser { return upperEndWriter{w} },
+	}}
+	ts.Start()
+	defer ts.Close()
+
+	for _, proto := range []string{"HTTP/1.0", "HTTP/1.1"} {
+		c, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		io.WriteString(c, "GET / "+proto+"\r\nHost: foo\r\nConnection: close\r\n\r\n")
+		res, err := ReadResponse(bufio.NewReader(c), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		c.Close()
+		if want := "HELLO WORLD<END>"; string(b) != want || err != nil {
+			t.Errorf("%s: body %q, %v; want %q", proto, b, err, want)
+		}
+	}
+}
+
+func TestTransportBodyTransformers(t *testing.T) {
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Request", r.Header.Get("X-Upper")+" "+string(b)+" "+r.Header.Get("Content-Length"))
+		io.WriteString(w, "response")
+	}))
+	defer ts.Close()
+	tr := &Transport{BodyTransformers: []BodyTransformer{upper}}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+
+	req, _ := NewRequest("POST", ts.URL, strings.NewReader("request"))
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if got := res.Header.Get("X-Request"); got != "1 REQUEST" {
+		t.Errorf("server got %q; want the request transformed, without Content-Length", got)
+	}
+	if string(b) != "RESPONSE" || res.Header.Get("X-Upper") != "1" || res.ContentLength != -1 || res.Request != req {
+		t.Errorf("response %q, X-Upper %q, ContentLength %d, Request %p; want RESPONSE, 1, -1, %p",
+			b, res.Header.Get("X-Upper"), res.ContentLength, res.Request, req)
+	}
+	if req.Header.Get("X-Upper") != "" {
+		t.Error("the header of the caller's request was changed")
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// SNI-based selection among multiple TLS certificates.
+
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A CertStore holds TLS certificates for several host names and
+// selects one for each connection by the server name the client
+// requested (SNI). This lets a single Server terminate TLS for many
+// domains. Certificates loaded from files and directories can be
+// re-read with Reload or Watch without restarting the server.
+//
+// A certificate is selected for every DNS name it contains, or for
+// its subject common name if it has none. Wildcard names such as
+// "*.example.com" match a single leftmost label. If several
+// certificates cover the same name, the one added first wins. Clients
+// that send no server name, or one no certificate covers, get the
+// first certificate added.
+//
+// The zero value is an empty store ready to use. A CertStore is safe
+// for concurrent use.
+type CertStore struct {
+	mu      sync.RWMutex
+	sources []certSource
+	set     *certSet
+}
+
+// certSource is one Add, AddFile or AddDir call, replayed by Reload.
+type certSource struct {
+	cert              *tls.Certificate // Add
+	certFile, keyFile string           // AddFile
+	dir               string           // AddDir
+}
+
+// certSet is an immutable index of loaded certificates.
+type certSet struct {
+	list  []*tls.Certificate
+	names map[string]*tls.Certificate // lower-case name or "*.domain"
+}
+
+// LoadCertDir returns a CertStore holding the certificates in dir.
+// See CertStore.AddDir.
+func LoadCertDir(dir string) (*CertStore, error) {
+	s := new(CertStore)
+	if err := s.AddDir(dir); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Add adds cert to the store. If cert.Leaf is nil it is parsed from
+// the first certificate in the chain.
+func (s *CertStore) Add(cert tls.Certificate) error {
+	if err := setLeaf(&cert); err != nil {
+		return err
+	}
+	return s.add(certSource{cert: &cert})
+}
+
+// AddFile loads a certificate chain and matching private key from a
+// pair of PEM files, as tls.LoadX509KeyPair does, and adds them to the
+// store. The files are read again by Reload.
+func (s *CertStore) AddFile(certFile, keyFile string) error {
+	return s.add(certSource{certFile: certFile, keyFile: keyFile})
+}
+
+// AddDir adds every certificate found in dir. A file named name.crt
+// is paired with the private key in name.key; a file named name.pem
+// must hold both the certificate chain and its key. Other files are
+// ignored. Files are loaded in lexical order, and the directory is
+// read again by Reload.
+func (s *CertStore) AddDir(dir string) error {
+	return s.add(certSource{dir: dir})
+}
+
+func (s *CertStore) add(src certSource) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sources := append(s.sources[:len(s.sources):len(s.sources)], src)
+	set, err := loadCertSet(sources)
+	if err != nil {
+		return err
+	}
+	s.sources, s.set = sources, set
+	return nil
+}
+
+// Reload reads all certificate files and directories added to the
+// store again and atomically replaces the certificates being served.
+// Connections already established are not affected. If any file fails
+// to load, Reload returns the error and the store keeps serving the
+// certificates it had.
+func (s *CertStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set, err := loadCertSet(s.sources)
+	if err != nil {
+		return err
+	}
+	s.set = set
+	return nil
+}
+
+// Watch polls the store's certificate files and directories every
+// interval and calls Reload when any of them changes. Reload errors
+// are logged and the previous certificates stay in use, so a renewal
+// tool may write a certificate and its key in two steps. Calling the
+// returned function stops the watch.
+func (s *CertStore) Watch(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	last := s.fingerprint()
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+			fp := s.fingerprint()
+			if fp == last {
+				continue
+			}
+			if err := s.Reload(); err != nil {
+				log.Printf("http: reloading certificates: %v", err)
+				continue
+			}
+			last = fp
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// fingerprint summarizes the names, sizes and modification times of
+// the store's files, for Watch to detect changes.
+func (s *CertStore) fingerprint() string {
+	s.mu.RLock()
+	sources := s.sources
+	s.mu.RUnlock()
+	var b []byte
+	stat := func(name string) {
+		if fi, err := os.Stat(name); err == nil {
+			b = append(b, fmt.Sprintf("%s %d %d\n", name, fi.Size(), fi.ModTime().UnixNano())...)
+		}
+	}
+	for _, src := range sources {
+		switch {
+		case src.dir != "":
+			names, _ := filepath.Glob(filepath.Join(src.dir, "*"))
+			for _, name := range names {
+				stat(name)
+			}
+		case src.certFile != "":
+			stat(src.certFile)
+			stat(src.keyFile)
+		}
+	}
+	return string(b)
+}
+
+// GetCertificate implements the tls.Config.GetCertificate hook.
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	set := s.set
+	s.mu.RUnlock()
+	if set == nil || len(set.list) == 0 {
+		return nil, errors.New("http: no certificates in CertStore")
+	}
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := set.names[name]; ok {
+		return cert, nil
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		if cert, ok := set.names["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	return set.list[0], nil
+}
+
+// TLSConfig returns a TLS configuration that selects certificates
+// from s.
+func (s *CertStore) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: s.GetCertificate,
+		NextProtos:     []string{"http/1.1"},
+	}
+}
+
+func loadCertSet(sources []certSource) (*certSet, error) {
+	set := &certSet{names: make(map[string]*tls.Certificate)}
+	for _, src := range sources {
+		switch {
+		case src.cert != nil:
+			set.add(src.cert)
+		case src.dir != "":
+			if err := set.addDir(src.dir); err != nil {
+				return nil, err
+			}
+		default:
+			if err := set.addFile(src.certFile, src.keyFile); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return set, nil
+}
+
+func (set *certSet) addDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var err error
+		switch filepath.Ext(name) {
+		case ".crt":
+			keyFile := strings.TrimSuffix(name, ".crt") + ".key"
+			err = set.addFile(filepath.Join(dir, name), filepath.Join(dir, keyFile))
+		case ".pem":
+			err = set.addFile(filepath.Join(dir, name), filepath.Join(dir, name))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (set *certSet) addFile(certFile, keyFile string) error {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("http: loading %s: %v", certFile, err)
+	}
+	if err := setLeaf(&cert); err != nil {
+		return fmt.Errorf("http: loading %s: %v", certFile, err)
+	}
+	set.add(&cert)
+	return nil
+}
+
+func (set *certSet) add(cert *tls.Certificate) {
+	set.list = append(set.list, cert)
+	names := cert.Leaf.DNSNames
+	if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+		names = []string{cert.Leaf.Subject.CommonName}
+	}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if _, dup := set.names[name]; !dup {
+			set.names[name] = cert
+		}
+	}
+}
+
+func setLeaf(cert *tls.Certificate) error {
+	if cert.Leaf != nil {
+		return nil
+	}
+	if len(cert.Certificate) == 0 {
+		return errors.New("http: certificate has no chain")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	cert.Leaf = leaf
+	return nil
+}
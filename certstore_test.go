@@ -0,0 +1,271 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	. "net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testCA issues ECDSA certificates for TLS tests. The RSA certificate
+// built into httptest cannot be used with current crypto/x509.
+type testCA struct {
+	key    *ecdsa.PrivateKey
+	cert   *x509.Certificate
+	serial int64
+	ocsp   []string // OCSP responders named in issued certificates
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{key: key, cert: cert, serial: 1}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	p := x509.NewCertPool()
+	p.AddCert(ca.cert)
+	return p
+}
+
+// issue returns a certificate for the given DNS names, and its chain
+// and key PEM-encoded.
+func (ca *testCA) issue(t *testing.T, names ...string) (cert tls.Certificate, certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		OCSPServer:   ca.ocsp,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+	cert, err = tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, certPEM, keyPEM
+}
+
+func writeFile(t *testing.T, name string, data ...[]byte) {
+	var b []byte
+	for _, d := range data {
+		b = append(b, d...)
+	}
+	if err := ioutil.WriteFile(name, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servedSerial returns the serial number of the certificate s picks
+// for serverName.
+func servedSerial(t *testing.T, s *CertStore, serverName string) int64 {
+	cert, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	if err != nil {
+		t.Fatalf("GetCertificate(%q): %v", serverName, err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+func TestCertStoreSNI(t *testing.T) {
+	ca := newTestCA(t)
+	dir, err := ioutil.TempDir("", "certstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, aCert, aKey := ca.issue(t, "a.example")                // serial 2
+	_, bCert, bKey := ca.issue(t, "b.example", "*.b.example") // serial 3
+	writeFile(t, filepath.Join(dir, "a.crt"), aCert)
+	writeFile(t, filepath.Join(dir, "a.key"), aKey)
+	writeFile(t, filepath.Join(dir, "b.pem"), bCert, bKey)
+	writeFile(t, filepath.Join(dir, "README"), []byte("not a certificate"))
+
+	s, err := LoadCertDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := ca.issue(t, "a.example", "c.example") // serial 4
+	if err := s.Add(other); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		serverName string
+		want       int64
+	}{
+		{"a.example", 2},
+		{"A.Example.", 2},
+		{"b.example", 3},
+		{"www.b.example", 3},
+		{"x.www.b.example", 2},
+		{"c.example", 4},
+		{"unknown.example", 2},
+		{"", 2},
+	}
+	for _, tt := range tests {
+		if got := servedSerial(t, s, tt.serverName); got != tt.want {
+			t.Errorf("server name %q: got certificate %d; want %d", tt.serverName, got, tt.want)
+		}
+	}
+
+	// Rotate a.example and add a new domain.
+	_, aCert, aKey = ca.issue(t, "a.example")  // serial 5
+	_, dCert, dKey := ca.issue(t, "d.example") // serial 6
+	writeFile(t, filepath.Join(dir, "a.crt"), aCert)
+	writeFile(t, filepath.Join(dir, "a.key"), aKey)
+	writeFile(t, filepath.Join(dir, "d.pem"), dCert, dKey)
+	if got := servedSerial(t, s, "a.example"); got != 2 {
+		t.Errorf("before Reload, got certificate %d; want 2", got)
+	}
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := servedSerial(t, s, "a.example"); got != 5 {
+		t.Errorf("after Reload, got certificate %d; want 5", got)
+	}
+	if got := servedSerial(t, s, "d.example"); got != 6 {
+		t.Errorf("after Reload, got certificate %d for d.example; want 6", got)
+	}
+
+	// A half-written pair fails to load and keeps the old certificates.
+	writeFile(t, filepath.Join(dir, "a.key"), dKey)
+	if err := s.Reload(); err == nil {
+		t.Error("Reload with mismatched key succeeded")
+	}
+	if got := servedSerial(t, s, "a.example"); got != 5 {
+		t.Errorf("after failed Reload, got certificate %d; want 5", got)
+	}
+}
+
+func TestCertStoreEmpty(t *testing.T) {
+	var s CertStore
+	if _, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.example"}); err == nil {
+		t.Error("GetCertificate on empty store succeeded")
+	}
+	if _, err := LoadCertDir(filepath.Join(os.TempDir(), "does-not-exist-certstore")); err == nil {
+		t.Error("LoadCertDir on missing directory succeeded")
+	}
+}
+
+func TestCertStoreWatch(t *testing.T) {
+	ca := newTestCA(t)
+	dir, err := ioutil.TempDir("", "certstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_, c, k := ca.issue(t, "a.example") // serial 2
+	writeFile(t, certFile, c)
+	writeFile(t, keyFile, k)
+
+	var s CertStore
+	if err := s.AddFile(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	stop := s.Watch(10 * time.Millisecond)
+	defer stop()
+
+	_, c, k = ca.issue(t, "a.example") // serial 3
+	writeFile(t, certFile, c)
+	writeFile(t, keyFile, k)
+	deadline := time.Now().Add(5 * time.Second)
+	for servedSerial(t, &s, "a.example") != 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for Watch to reload the certificate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCertStoreServeTLS(t *testing.T) {
+	defer afterTest(t)
+	ca := newTestCA(t)
+	var s CertStore
+	for _, name := range []string{"a.example", "b.example"} {
+		cert, _, _ := ca.issue(t, name)
+		if err := s.Add(cert); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sni atomic.Value
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		sni.Store(r.TLS.ServerName)
+	})}
+	go srv.Serve(tls.NewListener(ln, s.TLSConfig()))
+	defer ln.Close()
+
+	for _, name := range []string{"a.example", "b.example"} {
+		tr := &Transport{TLSClientConfig: &tls.Config{ServerName: name, RootCAs: ca.pool()}}
+		c := &Client{Transport: tr}
+		res, err := c.Get("https://" + ln.Addr().String() + "/")
+		if err != nil {
+			t.Fatalf("Get with server name %s: %v", name, err)
+		}
+		// Verification against name succeeding shows the right
+		// certificate was served.
+		res.Body.Close()
+		if got := sni.Load(); got != name {
+			t.Errorf("Request.TLS.ServerName = %v; want %s", got, name)
+		}
+		tr.CloseIdleConnections()
+	}
+}
@@ -132,9 +132,13 @@ func RequestFromMap(params map[string]string) (*http.Request, error) {
 	}
 
 	// Request.RemoteAddr has its port set by Go's standard http
-	// server, so we do here too. We don't have one, though, so we
-	// use a dummy one.
-	r.RemoteAddr = net.JoinHostPort(params["REMOTE_ADDR"], "0")
+	// server, so we do here too. If we don't have one, we use a
+	// dummy one.
+	port := params["REMOTE_PORT"]
+	if port == "" {
+		port = "0"
+	}
+	r.RemoteAddr = net.JoinHostPort(params["REMOTE_ADDR"], port)
 
 	return r, nil
 }
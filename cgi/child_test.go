@@ -88,6 +88,23 @@ func TestRequestWithTLS(t *testing.T) {
 	}
 }
 
+func TestRequestWithRemotePort(t *testing.T) {
+	env := map[string]string{
+		"SERVER_PROTOCOL": "HTTP/1.1",
+		"REQUEST_METHOD":  "GET",
+		"REQUEST_URI":     "/",
+		"REMOTE_ADDR":     "2001:db8::1",
+		"REMOTE_PORT":     "4321",
+	}
+	req, err := RequestFromMap(env)
+	if err != nil {
+		t.Fatalf("RequestFromMap: %v", err)
+	}
+	if e, g := "[2001:db8::1]:4321", req.RemoteAddr; e != g {
+		t.Errorf("RemoteAddr: got %q; want %q", g, e)
+	}
+}
+
 func TestRequestWithoutHost(t *testing.T) {
 	env := map[string]string{
 		"SERVER_PROTOCOL": "HTTP/1.1",
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -128,11 +129,15 @@ func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		"PATH_INFO=" + pathInfo,
 		"SCRIPT_NAME=" + root,
 		"SCRIPT_FILENAME=" + h.Path,
-		"REMOTE_ADDR=" + req.RemoteAddr,
-		"REMOTE_HOST=" + req.RemoteAddr,
 		"SERVER_PORT=" + port,
 	}
 
+	remoteIP, remotePort := remoteAddr(req)
+	env = append(env, "REMOTE_ADDR="+remoteIP, "REMOTE_HOST="+remoteIP)
+	if remotePort != "" {
+		env = append(env, "REMOTE_PORT="+remotePort)
+	}
+
 	if req.TLS != nil {
 		env = append(env, "HTTPS=on")
 	}
@@ -328,11 +333,27 @@ func (h *Handler) handleInternalRedirect(rw http.ResponseWriter, req *http.Reque
 		Header:     make(http.Header),
 		Host:       url.Host,
 		RemoteAddr: req.RemoteAddr,
+		ProxyLine:  req.ProxyLine,
 		TLS:        req.TLS,
 	}
 	h.PathLocationHandler.ServeHTTP(rw, newReq)
 }
 
+// remoteAddr returns the IP address and port of the client of req: the
+// source address of its PROXY protocol header, if the server read one,
+// and its RemoteAddr otherwise. The port is "" if unknown.
+func remoteAddr(req *http.Request) (ip, port string) {
+	addr := req.RemoteAddr
+	if req.ProxyLine != nil && req.ProxyLine.Source != nil {
+		addr = req.ProxyLine.Source.String()
+	}
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, ""
+	}
+	return ip, port
+}
+
 func upperCaseAndUnderscore(r rune) rune {
 	switch {
 	case r >= 'a' && r <= 'z':
@@ -34,8 +34,11 @@ func newRequest(httpreq string) *http.Request {
 }
 
 func runCgiTest(t *testing.T, h *Handler, httpreq string, expectedMap map[string]string) *httptest.ResponseRecorder {
+	return runCgiRequest(t, h, newRequest(httpreq), expectedMap)
+}
+
+func runCgiRequest(t *testing.T, h *Handler, req *http.Request, expectedMap map[string]string) *httptest.ResponseRecorder {
 	rw := httptest.NewRecorder()
-	req := newRequest(httpreq)
 	h.ServeHTTP(rw, req)
 
 	// Make a map to hold the test map that the CGI returns.
@@ -144,6 +147,36 @@ func TestCGIBasicGetAbsPath(t *testing.T) {
 	runCgiTest(t, h, "GET /test.cgi?foo=bar&a=b HTTP/1.0\nHost: example.com\n\n", expectedMap)
 }
 
+func TestCGIRemoteAddr(t *testing.T) {
+	check(t)
+	h := &Handler{
+		Path: "testdata/test.cgi",
+		Root: "/test.cgi",
+	}
+	req := newRequest("GET /test.cgi HTTP/1.0\nHost: example.com\n\n")
+	req.RemoteAddr = "10.0.0.1:5000"
+	runCgiRequest(t, h, req, map[string]string{
+		"env-REMOTE_ADDR": "10.0.0.1",
+		"env-REMOTE_HOST": "10.0.0.1",
+		"env-REMOTE_PORT": "5000",
+	})
+
+	// Behind a proxy, the script sees the client of the PROXY
+	// protocol header.
+	req = newRequest("GET /test.cgi HTTP/1.0\nHost: example.com\n\n")
+	req.RemoteAddr = "10.0.0.1:5000"
+	req.ProxyLine = &http.ProxyLine{
+		Version:     1,
+		Source:      &net.TCPAddr{IP: net.ParseIP("192.0.2.8"), Port: 6000},
+		Destination: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 80},
+	}
+	runCgiRequest(t, h, req, map[string]string{
+		"env-REMOTE_ADDR": "192.0.2.8",
+		"env-REMOTE_HOST": "192.0.2.8",
+		"env-REMOTE_PORT": "6000",
+	})
+}
+
 func TestPathInfo(t *testing.T) {
 	check(t)
 	h := &Handler{
@@ -0,0 +1,261 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Transport circuit breaking.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by a Transport's RoundTrip for requests to
+// a host whose circuit its CircuitBreaker has opened.
+var ErrCircuitOpen = errors.New("http: circuit breaker open")
+
+// A CircuitState is the state of a host's circuit.
+type CircuitState int
+
+const (
+	// CircuitClosed lets requests through, counting their failures.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen fails requests at once with ErrCircuitOpen.
+	CircuitOpen
+
+	// CircuitHalfOpen lets a few probe requests through, closing
+	// the circuit if they succeed and opening it again if not.
+	CircuitHalfOpen
+)
+
+var circuitStateNames = []string{"closed", "open", "half-open"}
+
+func (s CircuitState) String() string {
+	if s >= 0 && int(s) < len(circuitStateNames) {
+		return circuitStateNames[s]
+	}
+	return "CircuitState(" + strconv.Itoa(int(s)) + ")"
+}
+
+// A CircuitBreaker stops a Transport sending requests to a host while
+// too many of them fail, so that a dead server doesn't tie up the
+// connection pool and the goroutines of callers waiting on it. Each
+// host, as host:port, has its own circuit.
+//
+// A circuit opens when, within a Window, at least MinRequests requests
+// were made and FailureRate of them failed. After OpenTimeout, it lets
+// HalfOpenProbes requests through at a time, and closes once that
+// many succeed in a row, or opens again at the first failure.
+//
+// A CircuitBreaker may be shared by several Transports. Its fields
+// must not be changed once it is in use.
+type CircuitBreaker struct {
+	// FailureRate is the fraction of requests failing, from 0 to 1,
+	// at which a circuit opens. If zero, 0.5 is used.
+	FailureRate float64
+
+	// MinRequests is the fewest requests in a Window for its
+	// failures to open a circuit. If zero, 10 is used.
+	MinRequests int
+
+	// Window is how long requests are counted for. If zero, 10
+	// seconds is used.
+	Window time.Duration
+
+	// OpenTimeout is how long a circuit stays open before letting
+	// probes through. If zero, 5 seconds is used.
+	OpenTimeout time.Duration
+
+	// HalfOpenProbes is the number of probe requests let through at
+	// once, and of successes closing the circuit, when half-open.
+	// If zero, 1 is used.
+	HalfOpenProbes int
+
+	// IsFailure optionally reports whether a round trip failed. If
+	// nil, errors and 5xx responses are failures, except those of
+	// requests whose context was canceled. Requests whose context's
+	// deadline passed count as failures, as of a host too slow.
+	IsFailure func(req *Request, res *Response, err error) bool
+
+	// OnStateChange, if non-nil, is called when the circuit of host
+	// changes state, such as to log it.
+	OnStateChange func(host string, from, to CircuitState)
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+}
+
+// A circuit is the state of the circuit of a host.
+type circuit struct {
+	state     CircuitState
+	start     time.Time // of the window when closed, or when opened
+	requests  int       // in the window when closed
+	failures  int       // in the window when closed
+	probes    int       // in flight when half-open
+	successes int       // of probes in a row when half-open
+}
+
+func (cb *CircuitBreaker) failureRate() float64 {
+	if cb.FailureRate <= 0 {
+		return 0.5
+	}
+	return cb.FailureRate
+}
+
+func (cb *CircuitBreaker) minRequests() int {
+	if cb.MinRequests <= 0 {
+		return 10
+	}
+	return cb.MinRequests
+}
+
+func (cb *CircuitBreaker) window() time.Duration {
+	if cb.Window <= 0 {
+		return 10 * time.Second
+	}
+	return cb.Window
+}
+
+func (cb *CircuitBreaker) openTimeout() time.Duration {
+	if cb.OpenTimeout <= 0 {
+		return 5 * time.Second
+	}
+	return cb.OpenTimeout
+}
+
+func (cb *CircuitBreaker) halfOpenProbes() int {
+	if cb.HalfOpenProbes <= 0 {
+		return 1
+	}
+	return cb.HalfOpenProbes
+}
+
+// State returns the state of the circuit of host, a host:port.
+func (cb *CircuitBreaker) State(host string) CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	c := cb.hosts[host]
+	if c == nil {
+		return CircuitClosed
+	}
+	if c.state == CircuitOpen && time.Since(c.start) >= cb.openTimeout() {
+		return CircuitHalfOpen
+	}
+	return c.state
+}
+
+// allow reports whether a request may be sent to host, returning
+// ErrCircuitOpen if not. If it may, done must be called with the
+// outcome of its round trip.
+func (cb *CircuitBreaker) allow(host string) (done func(req *Request, res *Response, err error), err error) {
+	now := time.Now()
+	var notify func()
+	defer func() { cb.notify(notify) }()
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.hosts == nil {
+		cb.hosts = make(map[string]*circuit)
+	}
+	c := cb.hosts[host]
+	if c == nil {
+		c = &circuit{start: now}
+		cb.hosts[host] = c
+	}
+	switch c.state {
+	case CircuitClosed:
+		if now.Sub(c.start) >= cb.window() {
+			c.start, c.requests, c.failures = now, 0, 0
+		}
+		c.requests++
+	case CircuitOpen:
+		if now.Sub(c.start) < cb.openTimeout() {
+			return nil, ErrCircuitOpen
+		}
+		notify = cb.setStateLocked(host, c, CircuitHalfOpen)
+		fallthrough
+	case CircuitHalfOpen:
+		if c.probes >= cb.halfOpenProbes() {
+			return nil, ErrCircuitOpen
+		}
+		c.probes++
+	}
+	state := c.state
+	return func(req *Request, res *Response, err error) {
+		failed, counted := cb.failed(req, res, err)
+		cb.done(host, c, state, failed, counted)
+	}, nil
+}
+
+// failed reports whether a round trip failed, and whether it counts
+// either way: one canceled by the caller tells nothing of the host,
+// unlike one that ran out of time.
+func (cb *CircuitBreaker) failed(req *Request, res *Response, err error) (failed, counted bool) {
+	if cb.IsFailure != nil {
+		return cb.IsFailure(req, res, err), true
+	}
+	if req.Context().Err() == context.Canceled {
+		return false, false
+	}
+	return err != nil || res.StatusCode >= 500, true
+}
+
+// done records the outcome of a request to host let through when c
+// was in state.
+func (cb *CircuitBreaker) done(host string, c *circuit, state CircuitState, failed, counted bool) {
+	var notify func()
+	defer func() { cb.notify(notify) }()
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if state == CircuitHalfOpen {
+		c.probes--
+		if c.state != CircuitHalfOpen || !counted {
+			return // another probe decided already
+		}
+		if failed {
+			c.start = time.Now()
+			notify = cb.setStateLocked(host, c, CircuitOpen)
+		} else if c.successes++; c.successes >= cb.halfOpenProbes() {
+			c.start, c.requests, c.failures = time.Now(), 0, 0
+			notify = cb.setStateLocked(host, c, CircuitClosed)
+		}
+		return
+	}
+	switch {
+	case c.state != CircuitClosed:
+		return
+	case !counted:
+		if c.requests > 0 {
+			c.requests--
+		}
+		return
+	case !failed:
+		return
+	}
+	c.failures++
+	if c.requests >= cb.minRequests() && float64(c.failures) >= cb.failureRate()*float64(c.requests) {
+		c.start = time.Now()
+		notify = cb.setStateLocked(host, c, CircuitOpen)
+	}
+}
+
+// setStateLocked changes the state of c, the circuit of host,
+// returning the call to OnStateChange to make once cb.mu is released.
+func (cb *CircuitBreaker) setStateLocked(host string, c *circuit, to CircuitState) (notify func()) {
+	from := c.state
+	c.state, c.successes = to, 0
+	if cb.OnStateChange == nil {
+		return nil
+	}
+	return func() { cb.OnStateChange(host, from, to) }
+}
+
+func (cb *CircuitBreaker) notify(fn func()) {
+	if fn != nil {
+		fn()
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"context"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransportCircuitBreaker(t *testing.T) {
+	defer afterTest(t)
+	var hits int32
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		atomic.AddInt32(&hits, 1)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(500)
+		}
+	}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	var mu sync.Mutex
+	var changes []string
+	var cb *CircuitBreaker
+	cb = &CircuitBreaker{
+		MinRequests: 4,
+		Window:      time.Minute,
+		OpenTimeout: 50 * time.Millisecond,
+		OnStateChange: func(h string, from, to CircuitState) {
+			if h != host {
+				t.Errorf("OnStateChange host %q; want %q", h, host)
+			}
+			if cb.State(h) != to {
+				t.Errorf("OnStateChange to %v while State is %v", to, cb.State(h))
+			}
+			mu.Lock()
+			changes = append(changes, from.String()+">"+to.String())
+			mu.Unlock()
+		},
+	}
+	tr := &Transport{CircuitBreaker: cb}
+	defer tr.CloseIdleConnections()
+	get := func(path string) (int, error) {
+		req, _ := NewRequest("GET", ts.URL+path, nil)
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			return 0, err
+		}
+		res.Body.Close()
+		return res.StatusCode, nil
+	}
+
+	for _, path := range []string{"/ok", "/fail", "/ok", "/fail"} {
+		if _, err := get(path); err != nil {
+			t.Fatalf("%s while closed: %v", path, err)
+		}
+	}
+	if s := cb.State(host); s != CircuitOpen {
+		t.Fatalf("state after half the requests failed: %v; want open", s)
+	}
+	before := atomic.LoadInt32(&hits)
+	if _, err := get("/ok"); err != ErrCircuitOpen {
+		t.Errorf("request while open: %v; want ErrCircuitOpen", err)
+	}
+	if n := atomic.LoadInt32(&hits) - before; n != 0 {
+		t.Errorf("%d requests reached the server while open", n)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if s := cb.State(host); s != CircuitHalfOpen {
+		t.Errorf("state after OpenTimeout: %v; want half-open", s)
+	}
+	if code, err := get("/fail"); code != 500 || err != nil {
+		t.Errorf("probe: %d, %v", code, err)
+	}
+	if _, err := get("/ok"); err != ErrCircuitOpen {
+		t.Errorf("request after failed probe: %v; want ErrCircuitOpen", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if code, err := get("/ok"); code != 200 || err != nil {
+		t.Errorf("probe: %d, %v", code, err)
+	}
+	if s := cb.State(host); s != CircuitClosed {
+		t.Errorf("state after successful probe: %v; want closed", s)
+	}
+
+	mu.Lock()
+	got := strings.Join(changes, " ")
+	mu.Unlock()
+	if want := "closed>open open>half-open half-open>open open>half-open half-open>closed"; got != want {
+		t.Errorf("state changes:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestTransportCircuitBreakerDialFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close() // refuses connections
+
+	// The request and its two retries open the circuit.
+	cb := &CircuitBreaker{MinRequests: 3}
+	tr := &Transport{CircuitBreaker: cb, Retry: &RetryPolicy{MinBackoff: time.Millisecond}}
+	req, _ := NewRequest("GET", "http://"+addr+"/", nil)
+	if _, err := tr.RoundTrip(req); err == nil || err == ErrCircuitOpen {
+		t.Fatalf("first request: %v; want a dial error", err)
+	}
+	if _, err := tr.RoundTrip(req); err != ErrCircuitOpen {
+		t.Errorf("second request: %v; want ErrCircuitOpen", err)
+	}
+	if _, err := (&Client{Transport: tr}).Get("http://" + addr + "/"); err == nil || err.(*url.Error).Err != ErrCircuitOpen {
+		t.Errorf("Client.Get: %v; want ErrCircuitOpen", err)
+	}
+}
+
+// Requests timing out count as failures, unlike those the caller
+// cancels.
+func TestTransportCircuitBreakerContext(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(500)
+	}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	cb := &CircuitBreaker{MinRequests: 2}
+	tr := &Transport{CircuitBreaker: cb}
+	defer tr.CloseIdleConnections()
+	get := func(ctx context.Context) {
+		req, _ := NewRequest("GET", ts.URL, nil)
+		if res, err := tr.RoundTrip(req.WithContext(ctx)); err == nil {
+			res.Body.Close()
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		get(ctx)
+	}
+	if s := cb.State(host); s != CircuitClosed {
+		t.Fatalf("state after canceled requests: %v; want closed", s)
+	}
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		get(ctx)
+		cancel()
+	}
+	if s := cb.State(host); s != CircuitOpen {
+		t.Errorf("state after timed out requests: %v; want open", s)
+	}
+}
+
+func TestCircuitStateString(t *testing.T) {
+	if s := CircuitState(7).String(); s != "CircuitState(7)" {
+		t.Errorf("String of unknown state = %q", s)
+	}
+}
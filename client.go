@@ -10,13 +10,17 @@
 package http
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 )
 
 // A Client is an HTTP client. Its zero value (DefaultClient) is a
@@ -52,6 +56,35 @@ type Client struct {
 	// If Jar is nil, cookies are not sent in requests and ignored
 	// in responses.
 	Jar CookieJar
+
+	// ForwardHeaders, if true, copies the headers of the initial
+	// request onto the requests following its redirects.
+	ForwardHeaders bool
+
+	// StripAuthCrossOrigin, together with ForwardHeaders, keeps
+	// the Authorization, Cookie and Proxy-Authorization headers from
+	// being copied onto requests redirected to an origin (scheme,
+	// host and port) other than that of the initial request, so
+	// that credentials aren't disclosed to another site.
+	StripAuthCrossOrigin bool
+
+	// MaxRedirectBytes, if positive, caps the total size of the
+	// response bodies of the redirects followed for a request. The
+	// Client reads and discards them, letting their connections be
+	// reused, and fails the request if they are larger.
+	MaxRedirectBytes int64
+
+	// Timeout specifies a time limit for requests made by this
+	// Client. The timeout includes connection time, any
+	// redirects, and reading the response body. The timer keeps
+	// running after Get, Head, Post, or Do return and will
+	// interrupt reading of the Response.Body.
+	//
+	// A Timeout of zero means no timeout.
+	//
+	// The Client's Transport must support the CancelRequest
+	// method, as Transport does, for Timeout to be used.
+	Timeout time.Duration
 }
 
 // DefaultClient is the default Client and is used by Get, Head, and Post.
@@ -131,7 +164,18 @@ func (c *Client) Do(req *Request) (resp *Response, err error) {
 	if req.Method == "POST" || req.Method == "PUT" {
 		return c.doFollowingRedirects(req, shouldRedirectPost)
 	}
-	return c.send(req)
+	rt, err := c.startTimer(req)
+	if err != nil {
+		return nil, err
+	}
+	if req, err = rt.setRequest(req); err != nil {
+		return nil, rt.stop(err)
+	}
+	if resp, err = c.send(req); err != nil {
+		return nil, rt.stop(err)
+	}
+	resp.Body = rt.body(resp.Body)
+	return resp, nil
 }
 
 // send issues an HTTP request.
@@ -189,7 +233,7 @@ func basicAuth(username, password string) string {
 // automatically redirect.
 func shouldRedirectGet(statusCode int) bool {
 	switch statusCode {
-	case StatusMovedPermanently, StatusFound, StatusSeeOther, StatusTemporaryRedirect:
+	case StatusMovedPermanently, StatusFound, StatusSeeOther, StatusTemporaryRedirect, StatusPermanentRedirect:
 		return true
 	}
 	return false
@@ -199,12 +243,18 @@ func shouldRedirectGet(statusCode int) bool {
 // automatically redirect.
 func shouldRedirectPost(statusCode int) bool {
 	switch statusCode {
-	case StatusFound, StatusSeeOther:
+	case StatusFound, StatusSeeOther, StatusTemporaryRedirect, StatusPermanentRedirect:
 		return true
 	}
 	return false
 }
 
+// redirectKeepsBody reports whether a redirect with the status code
+// is followed with the same method and body, rather than a GET.
+func redirectKeepsBody(statusCode int) bool {
+	return statusCode == StatusTemporaryRedirect || statusCode == StatusPermanentRedirect
+}
+
 // Get issues a GET to the specified URL.  If the response is one of the following
 // redirect codes, Get follows the redirect, up to a maximum of 10 redirects:
 //
@@ -212,6 +262,7 @@ func shouldRedirectPost(statusCode int) bool {
 //    302 (Found)
 //    303 (See Other)
 //    307 (Temporary Redirect)
+//    308 (Permanent Redirect)
 //
 // An error is returned if there were too many redirects or if there
 // was an HTTP protocol error. A non-2xx response doesn't cause an
@@ -233,6 +284,7 @@ func Get(url string) (resp *Response, err error) {
 //    302 (Found)
 //    303 (See Other)
 //    307 (Temporary Redirect)
+//    308 (Permanent Redirect)
 //
 // An error is returned if the Client's CheckRedirect function fails
 // or if there was an HTTP protocol error. A non-2xx response doesn't
@@ -259,15 +311,22 @@ func (c *Client) doFollowingRedirects(ireq *Request, shouldRedirect func(int) bo
 	if ireq.URL == nil {
 		return nil, errors.New("http: nil Request.URL")
 	}
+	rt, err := c.startTimer(ireq)
+	if err != nil {
+		return nil, err
+	}
 
 	req := ireq
 	urlStr := "" // next relative or absolute URL to fetch (after first request)
 	redirectFailed := false
+	redirectBytes := c.MaxRedirectBytes
 	for redirect := 0; ; redirect++ {
 		if redirect != 0 {
+			prev := resp
+			keepBody := redirectKeepsBody(prev.StatusCode)
 			req = new(Request)
 			req.Method = ireq.Method
-			if ireq.Method == "POST" || ireq.Method == "PUT" {
+			if !keepBody && (ireq.Method == "POST" || ireq.Method == "PUT") {
 				req.Method = "GET"
 			}
 			req.Header = make(Header)
@@ -275,6 +334,21 @@ func (c *Client) doFollowingRedirects(ireq *Request, shouldRedirect func(int) bo
 			if err != nil {
 				break
 			}
+			req.Response = prev
+			if c.ForwardHeaders {
+				c.copyRedirectHeaders(req, ireq)
+			}
+			if keepBody && ireq.Body != nil {
+				// Send the body again.
+				if req.Body, err = ireq.GetBody(); err != nil {
+					break
+				}
+				req.GetBody = ireq.GetBody
+				req.ContentLength = ireq.ContentLength
+				if ct := ireq.Header.Get("Content-Type"); ct != "" {
+					req.Header.Set("Content-Type", ct)
+				}
+			}
 			if len(via) > 0 {
 				// Add the Referer header.
 				lastReq := via[len(via)-1]
@@ -291,12 +365,35 @@ func (c *Client) doFollowingRedirects(ireq *Request, shouldRedirect func(int) bo
 		}
 
 		urlStr = req.URL.String()
+		if req, err = rt.setRequest(req); err != nil {
+			break
+		}
 		if resp, err = c.send(req); err != nil {
 			break
 		}
 
-		if shouldRedirect(resp.StatusCode) {
+		// A redirect needing a body that can't be sent again is
+		// returned instead of followed.
+		if shouldRedirect(resp.StatusCode) &&
+			!(redirectKeepsBody(resp.StatusCode) && ireq.Body != nil && ireq.GetBody == nil) {
+			if c.MaxRedirectBytes > 0 {
+				var n int64
+				n, err = io.CopyN(ioutil.Discard, resp.Body, redirectBytes+1)
+				if redirectBytes -= n; redirectBytes < 0 {
+					resp.Body.Close()
+					resp = nil
+					err = errRedirectBytes
+					break
+				}
+				if err == io.EOF {
+					err = nil
+				}
+			}
 			resp.Body.Close()
+			if err != nil {
+				resp = nil
+				break
+			}
 			if urlStr = resp.Header.Get("Location"); urlStr == "" {
 				err = errors.New(fmt.Sprintf("%d response missing Location header", resp.StatusCode))
 				break
@@ -305,9 +402,11 @@ func (c *Client) doFollowingRedirects(ireq *Request, shouldRedirect func(int) bo
 			via = append(via, req)
 			continue
 		}
+		resp.Body = rt.body(resp.Body)
 		return
 	}
 
+	err = rt.stop(err)
 	method := ireq.Method
 	urlErr := &url.Error{
 		Op:  method[0:1] + strings.ToLower(method[1:]),
@@ -328,6 +427,151 @@ func (c *Client) doFollowingRedirects(ireq *Request, shouldRedirect func(int) bo
 	return nil, urlErr
 }
 
+var errClientTimeout = &timeoutError{"http: Client.Timeout exceeded"}
+
+// A requestTimer enforces a Client's Timeout, canceling the request
+// in flight when it fires. Its context, that of the requests it
+// times, is canceled then too, so that dials and waits for a
+// connection, which CancelRequest can't reach, are given up.
+type requestTimer struct {
+	timer  *time.Timer
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	req   *Request // in flight
+	fired bool
+}
+
+// startTimer starts the timer of the Client's Timeout for req, or
+// returns a nil *requestTimer if the Client has none.
+func (c *Client) startTimer(req *Request) (*requestTimer, error) {
+	if c.Timeout <= 0 {
+		return nil, nil
+	}
+	t := c.Transport
+	if t == nil {
+		t = DefaultTransport
+	}
+	tr, ok := t.(interface {
+		CancelRequest(*Request)
+	})
+	if !ok {
+		return nil, fmt.Errorf("http: Client Transport of type %T doesn't support CancelRequest; Timeout not supported", t)
+	}
+	rt := new(requestTimer)
+	rt.ctx, rt.cancel = context.WithCancel(req.Context())
+	rt.timer = time.AfterFunc(c.Timeout, func() {
+		rt.mu.Lock()
+		rt.fired = true
+		req := rt.req
+		rt.mu.Unlock()
+		rt.cancel()
+		if req != nil {
+			tr.CancelRequest(req)
+		}
+	})
+	return rt, nil
+}
+
+// setRequest returns req, the initial request or a redirect, with the
+// context of rt, and makes it the request canceled when rt fires. It
+// fails if rt has fired already.
+func (rt *requestTimer) setRequest(req *Request) (*Request, error) {
+	if rt == nil {
+		return req, nil
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.fired {
+		return nil, errClientTimeout
+	}
+	rt.req = req.WithContext(rt.ctx)
+	return rt.req, nil
+}
+
+// release stops rt once its request is done.
+func (rt *requestTimer) release() {
+	rt.timer.Stop()
+	rt.cancel()
+}
+
+// stop stops rt, returning err, or errClientTimeout in its place if
+// rt had fired.
+func (rt *requestTimer) stop(err error) error {
+	if rt == nil {
+		return err
+	}
+	rt.release()
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.fired && err != nil {
+		return errClientTimeout
+	}
+	return err
+}
+
+// body returns b, wrapped to stop rt when it's read to EOF or
+// closed.
+func (rt *requestTimer) body(b io.ReadCloser) io.ReadCloser {
+	if rt == nil {
+		return b
+	}
+	return &timerBody{rt, b}
+}
+
+type timerBody struct {
+	rt *requestTimer
+	rc io.ReadCloser
+}
+
+func (b *timerBody) Read(p []byte) (n int, err error) {
+	n, err = b.rc.Read(p)
+	if err == io.EOF {
+		b.rt.release()
+	} else if err != nil {
+		err = b.rt.stop(err)
+	}
+	return
+}
+
+func (b *timerBody) Close() error {
+	err := b.rc.Close()
+	b.rt.release()
+	return err
+}
+
+var errRedirectBytes = errors.New("http: redirect response bodies exceed Client.MaxRedirectBytes")
+
+// authHeaders are the headers not copied onto requests redirected to
+// another origin under Client.StripAuthCrossOrigin.
+var authHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// copyRedirectHeaders copies the headers of ireq, the initial request,
+// onto req, which follows one of its redirects.
+func (c *Client) copyRedirectHeaders(req, ireq *Request) {
+	for k, vv := range ireq.Header {
+		req.Header[k] = append([]string(nil), vv...)
+	}
+	if req.Method != ireq.Method {
+		// The body isn't sent again.
+		req.Header.Del("Content-Type")
+		req.Header.Del("Content-Length")
+	}
+	if c.StripAuthCrossOrigin && !sameOrigin(req.URL, ireq.URL) {
+		for _, k := range authHeaders {
+			req.Header.Del(k)
+		}
+	}
+}
+
+// sameOrigin reports whether u and v have the same scheme, host and
+// port.
+func sameOrigin(u, v *url.URL) bool {
+	return strings.EqualFold(u.Scheme, v.Scheme) &&
+		strings.EqualFold(canonicalAddr(u), canonicalAddr(v))
+}
+
 func defaultCheckRedirect(req *Request, via []*Request) error {
 	if len(via) >= 10 {
 		return errors.New("stopped after 10 redirects")
@@ -344,7 +588,8 @@ func Post(url string, bodyType string, body io.Reader) (resp *Response, err erro
 	return DefaultClient.Post(url, bodyType, body)
 }
 
-// Post issues a POST to the specified URL.
+// Post issues a POST to the specified URL. Redirects are followed
+// as by Do.
 //
 // Caller should close resp.Body when done reading from it.
 //
@@ -387,6 +632,7 @@ func (c *Client) PostForm(url string, data url.Values) (resp *Response, err erro
 //    302 (Found)
 //    303 (See Other)
 //    307 (Temporary Redirect)
+//    308 (Permanent Redirect)
 //
 // Head is a wrapper around DefaultClient.Head
 func Head(url string) (resp *Response, err error) {
@@ -401,6 +647,7 @@ func Head(url string) (resp *Response, err error) {
 //    302 (Found)
 //    303 (See Other)
 //    307 (Temporary Redirect)
+//    308 (Permanent Redirect)
 func (c *Client) Head(url string) (resp *Response, err error) {
 	req, err := NewRequest("HEAD", url, nil)
 	if err != nil {
@@ -23,6 +23,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 var robotsTxtHandler = HandlerFunc(func(w ResponseWriter, r *Request) {
@@ -799,3 +800,230 @@ func TestBasicAuth(t *testing.T) {
 		t.Errorf("Invalid auth %q", auth)
 	}
 }
+
+// redirectChainServers returns two servers: the first redirects /a to
+// /b, and /b to the second, which answers with the Authorization,
+// Proxy-Authorization and X-Custom headers it got. Each redirect has a 100-byte body.
+func redirectChainServers(t *testing.T) (first, second *httptest.Server, seen chan string) {
+	seen = make(chan string, 3)
+	echo := func(w ResponseWriter, r *Request) {
+		seen <- r.URL.Path + " " + r.Header.Get("Authorization") + " " + r.Header.Get("Proxy-Authorization") + " " + r.Header.Get("X-Custom")
+	}
+	second = httptest.NewServer(HandlerFunc(echo))
+	first = httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		echo(w, r)
+		next := "/b"
+		if r.URL.Path == "/b" {
+			next = second.URL + "/c"
+		}
+		w.Header().Set("Location", next)
+		w.WriteHeader(StatusFound)
+		w.Write(bytes.Repeat([]byte("x"), 100))
+	}))
+	return first, second, seen
+}
+
+func TestClientRedirectHistory(t *testing.T) {
+	defer afterTest(t)
+	first, second, seen := redirectChainServers(t)
+	defer first.Close()
+	defer second.Close()
+	req, _ := NewRequest("GET", first.URL+"/a", nil)
+	req.Header.Set("Authorization", "secret")
+	res, err := DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	for _, want := range []string{"/a secret  ", "/b   ", "/c   "} {
+		if got := <-seen; got != want {
+			t.Errorf("server saw %q; want %q", got, want)
+		}
+	}
+
+	var paths []string
+	r := res.Request
+	for ; r.Response != nil; r = r.Response.Request {
+		if r.Response.StatusCode != StatusFound {
+			t.Errorf("redirect to %s: status %d", r.URL, r.Response.StatusCode)
+		}
+		paths = append(paths, r.URL.Path)
+	}
+	paths = append(paths, r.URL.Path)
+	if r != req || strings.Join(paths, " ") != "/c /b /a" {
+		t.Errorf("request chain %v ends in the initial request: %v", paths, r == req)
+	}
+}
+
+func TestClientRedirectForwardHeaders(t *testing.T) {
+	defer afterTest(t)
+	first, second, seen := redirectChainServers(t)
+	defer first.Close()
+	defer second.Close()
+	for _, strip := range []bool{false, true} {
+		c := &Client{ForwardHeaders: true, StripAuthCrossOrigin: strip}
+		req, _ := NewRequest("GET", first.URL+"/a", nil)
+		req.Header.Set("Authorization", "secret")
+		req.Header.Set("Proxy-Authorization", "proxy")
+		req.Header.Set("X-Custom", "v")
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		want := []string{"/a secret proxy v", "/b secret proxy v", "/c secret proxy v"}
+		if strip {
+			want[2] = "/c   v"
+		}
+		for _, want := range want {
+			if got := <-seen; got != want {
+				t.Errorf("StripAuthCrossOrigin=%v: server saw %q; want %q", strip, got, want)
+			}
+		}
+	}
+}
+
+func TestClientMaxRedirectBytes(t *testing.T) {
+	defer afterTest(t)
+	first, second, seen := redirectChainServers(t)
+	defer first.Close()
+	defer second.Close()
+	c := &Client{MaxRedirectBytes: 200}
+	res, err := c.Get(first.URL + "/a")
+	if err != nil {
+		t.Fatalf("two 100-byte redirects under a 200-byte cap: %v", err)
+	}
+	res.Body.Close()
+	for i := 0; i < 3; i++ {
+		<-seen
+	}
+
+	c.MaxRedirectBytes = 150
+	res, err = c.Get(first.URL + "/a")
+	if err == nil {
+		res.Body.Close()
+		t.Fatal("redirects over MaxRedirectBytes succeeded")
+	}
+	if !strings.Contains(err.Error(), "MaxRedirectBytes") {
+		t.Errorf("error = %v", err)
+	}
+	if got := len(seen); got != 2 {
+		t.Errorf("%d requests made; want 2", got)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	defer afterTest(t)
+	unblock := make(chan bool)
+	mux := NewServeMux()
+	mux.HandleFunc("/slow", func(w ResponseWriter, r *Request) {
+		<-unblock
+	})
+	mux.HandleFunc("/body", func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "partial")
+		w.(Flusher).Flush()
+		<-unblock
+	})
+	mux.Handle("/redirect", RedirectHandler("/slow", StatusFound))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	defer close(unblock)
+
+	c := &Client{Timeout: 100 * time.Millisecond}
+	isTimeout := func(err error) bool {
+		te, ok := err.(interface {
+			Timeout() bool
+		})
+		return ok && te.Timeout()
+	}
+	for _, path := range []string{"/slow", "/redirect"} {
+		res, err := c.Get(ts.URL + path)
+		if err == nil {
+			res.Body.Close()
+			t.Errorf("GET %s: no error", path)
+		} else if !isTimeout(err) || !strings.Contains(err.Error(), "Client.Timeout") {
+			t.Errorf("GET %s: error %v; want a Client.Timeout error", path, err)
+		}
+	}
+
+	res, err := c.Get(ts.URL + "/body")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(b) != "partial" || !isTimeout(err) {
+		t.Errorf("read %q, %v; want the partial body and a timeout", b, err)
+	}
+
+	c.Transport = new(recordingTransport)
+	if _, err := c.Get(ts.URL + "/slow"); err == nil || !strings.Contains(err.Error(), "CancelRequest") {
+		t.Errorf("Transport without CancelRequest: error %v", err)
+	}
+}
+
+// A Timeout cuts off a dial that takes longer.
+func TestClientTimeoutDial(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
+	unblock := make(chan bool)
+	defer close(unblock)
+	tr := &Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			<-unblock
+			return net.Dial(network, addr)
+		},
+	}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr, Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	res, err := c.Get(ts.URL)
+	if err == nil {
+		res.Body.Close()
+		t.Fatal("GET with a blocked dial succeeded")
+	}
+	if !strings.Contains(err.Error(), "Client.Timeout") {
+		t.Errorf("error %v; want a Client.Timeout error", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("GET returned after %v", d)
+	}
+}
+
+func TestClientRedirectKeepsBody(t *testing.T) {
+	defer afterTest(t)
+	var ts *httptest.Server
+	ts = httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if v := r.URL.Query().Get("code"); v != "" {
+			code, _ := strconv.Atoi(v)
+			w.Header().Set("Location", ts.URL+"/done")
+			w.WriteHeader(code)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s %s", r.Method, r.URL.Path, r.Header.Get("Content-Type"), b)
+	}))
+	defer ts.Close()
+	for _, code := range []string{"307", "308"} {
+		res, err := Post(ts.URL+"/?code="+code, "text/plain", strings.NewReader("content"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if want := "POST /done text/plain content"; string(b) != want {
+			t.Errorf("%s: got %q; want %q", code, b, want)
+		}
+	}
+
+	// A body that can't be replayed stops at the redirect.
+	res, err := Post(ts.URL+"/?code=307", "text/plain", struct{ io.Reader }{strings.NewReader("content")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 307 {
+		t.Errorf("status %d; want the 307 unfollowed", res.StatusCode)
+	}
+}
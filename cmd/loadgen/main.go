@@ -0,0 +1,181 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Loadgen sends requests to an HTTP server from concurrent clients
+// and reports the throughput and latencies it saw, to measure the
+// effect of changes to the server or Transport.
+//
+// Usage:
+//
+//	loadgen [flags] url
+//
+// For example, 50 clients for 30 seconds, each connection starting
+// with a version 2 PROXY protocol header:
+//
+//	loadgen -c 50 -d 30s -proxy v2 http://localhost:8080/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	concurrency = flag.Int("c", 10, "number of concurrent clients")
+	requests    = flag.Int("n", 0, "number of requests to send in all; if 0, send for -d")
+	duration    = flag.Duration("d", 10*time.Second, "how long to send requests for, if -n is 0")
+	method      = flag.String("m", "GET", "request method")
+	body        = flag.String("body", "", "request body")
+	headers     = flag.String("H", "", "request headers, as \"Key: value\" separated by newlines")
+	keepAlive   = flag.Bool("keepalive", true, "reuse connections")
+	proxy       = flag.String("proxy", "", "PROXY protocol header to start each connection with: v1, v2 or none")
+	timeout     = flag.Duration("timeout", 30*time.Second, "time limit of each request")
+)
+
+// A result is the outcome of one request.
+type result struct {
+	latency time.Duration
+	status  int // 0 if the request failed
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: loadgen [flags] url\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *concurrency < 1 {
+		flag.Usage()
+	}
+	url := flag.Arg(0)
+
+	tr := &http.Transport{
+		DisableKeepAlives:   !*keepAlive,
+		MaxIdleConnsPerHost: *concurrency,
+	}
+	switch *proxy {
+	case "", "none":
+	case "v1", "v2":
+		version := 1
+		if *proxy == "v2" {
+			version = 2
+		}
+		tr.ProxyHeader = func(*http.Request) *http.ProxyLine {
+			return &http.ProxyLine{Version: version}
+		}
+	default:
+		log.Fatalf("loadgen: unknown PROXY protocol version %q", *proxy)
+	}
+	client := &http.Client{Transport: tr, Timeout: *timeout}
+
+	hdr := make(http.Header)
+	for _, line := range strings.Split(*headers, "\n") {
+		if kv := strings.SplitN(line, ":", 2); len(kv) == 2 {
+			hdr.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+		}
+	}
+
+	var (
+		sent     int64 // requests started, for -n
+		deadline = time.Now().Add(*duration)
+		mu       sync.Mutex
+		results  []result
+		wg       sync.WaitGroup
+	)
+	next := func() bool {
+		if *requests > 0 {
+			return atomic.AddInt64(&sent, 1) <= int64(*requests)
+		}
+		return time.Now().Before(deadline)
+	}
+	start := time.Now()
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var mine []result
+			for next() {
+				mine = append(mine, send(client, url, hdr))
+			}
+			mu.Lock()
+			results = append(results, mine...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	report(os.Stdout, results, time.Since(start))
+}
+
+// send sends one request and reads its response.
+func send(client *http.Client, url string, hdr http.Header) result {
+	var rbody io.Reader
+	if *body != "" {
+		rbody = strings.NewReader(*body)
+	}
+	req, err := http.NewRequest(*method, url, rbody)
+	if err != nil {
+		log.Fatalf("loadgen: %v", err)
+	}
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+	t0 := time.Now()
+	res, err := client.Do(req)
+	if err != nil {
+		return result{latency: time.Since(t0)}
+	}
+	_, err = io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	if err != nil {
+		return result{latency: time.Since(t0)}
+	}
+	return result{latency: time.Since(t0), status: res.StatusCode}
+}
+
+// report writes the throughput, the latency percentiles and the counts
+// of response codes of results, sent in elapsed.
+func report(w io.Writer, results []result, elapsed time.Duration) {
+	if len(results) == 0 {
+		fmt.Fprintln(w, "no requests sent")
+		return
+	}
+	latencies := make([]time.Duration, len(results))
+	codes := make(map[int]int)
+	for i, r := range results {
+		latencies[i] = r.latency
+		codes[r.status]++
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	pct := func(p float64) time.Duration {
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+
+	fmt.Fprintf(w, "requests: %d in %v, %.1f/s\n", len(results), elapsed.Round(time.Millisecond),
+		float64(len(results))/elapsed.Seconds())
+	fmt.Fprintf(w, "latency:  p50 %v  p90 %v  p99 %v  max %v\n",
+		pct(.50), pct(.90), pct(.99), latencies[len(latencies)-1])
+	var keys []int
+	for code := range codes {
+		keys = append(keys, code)
+	}
+	sort.Ints(keys)
+	for _, code := range keys {
+		name := http.StatusText(code)
+		if code == 0 {
+			name = "failed"
+		}
+		fmt.Fprintf(w, "  %3d %-20s %d\n", code, name, codes[code])
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Concurrency limiting.
+
+package http
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var errConcurrencyLimit = errors.New("http: too many requests in flight")
+
+// A ConcurrencyLimit caps the requests a ConcurrencyLimiter lets in
+// flight at once.
+type ConcurrencyLimit struct {
+	// MaxInFlight, if positive, is the most requests handled at
+	// once. Zero means no limit.
+	MaxInFlight int
+
+	// MaxQueue is the most requests waiting for one of those to
+	// finish, and QueueTimeout how long each waits, if positive.
+	// Others are turned away at once.
+	MaxQueue     int
+	QueueTimeout time.Duration
+}
+
+// A ConcurrencyLimiter caps the requests in flight in the handler it
+// wraps, overall and for each route, so that requests piling up on a
+// slow backend can neither exhaust the server nor starve the other
+// routes. Requests over a limit wait in its queue, if it has room, for
+// up to its QueueTimeout, and are otherwise answered with 503 Service
+// Unavailable and a Retry-After header.
+//
+// Its fields must not be changed once it is in use.
+type ConcurrencyLimiter struct {
+	// Handler is the handler protected.
+	Handler Handler
+
+	// Limit is the limit of all requests.
+	Limit ConcurrencyLimit
+
+	// Routes sets the limits of some paths, each of which is shared
+	// by the requests its key matches: the longest key matching the
+	// request's path applies, a key ending in a slash matching the
+	// subtree it names, as a ServeMux pattern does. Requests must be
+	// within both their route's limit and Limit.
+	Routes map[string]ConcurrencyLimit
+
+	// RetryAfter is sent in the Retry-After header of the requests
+	// turned away, rounded up to seconds. If zero, one second.
+	RetryAfter time.Duration
+
+	once      sync.Once
+	global    *bulkhead
+	bulkheads map[string]*bulkhead
+	routes    *routeTree // of bulkheads
+}
+
+// A bulkhead holds the slots of the requests in flight under a limit.
+type bulkhead struct {
+	limit ConcurrencyLimit
+	slots chan struct{}
+
+	mu      sync.Mutex
+	waiting int
+}
+
+func newBulkhead(limit ConcurrencyLimit) *bulkhead {
+	if limit.MaxInFlight <= 0 {
+		return nil
+	}
+	return &bulkhead{limit: limit, slots: make(chan struct{}, limit.MaxInFlight)}
+}
+
+// acquire takes a slot of b, waiting in the queue for one if there's
+// room, and reports whether it did. A nil bulkhead has no limit.
+func (b *bulkhead) acquire(r *Request) bool {
+	if b == nil {
+		return true
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if b.limit.QueueTimeout <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	if b.waiting >= b.limit.MaxQueue {
+		b.mu.Unlock()
+		return false
+	}
+	b.waiting++
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.waiting--
+		b.mu.Unlock()
+	}()
+
+	t := time.NewTimer(b.limit.QueueTimeout)
+	defer t.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	case <-t.C:
+	case <-r.Context().Done():
+	}
+	return false
+}
+
+func (b *bulkhead) release() {
+	if b != nil {
+		<-b.slots
+	}
+}
+
+func (l *ConcurrencyLimiter) init() {
+	l.global = newBulkhead(l.Limit)
+	l.bulkheads = make(map[string]*bulkhead)
+	patterns := make([]string, 0, len(l.Routes))
+	for pattern, limit := range l.Routes {
+		l.bulkheads[pattern] = newBulkhead(limit)
+		patterns = append(patterns, pattern)
+	}
+	l.routes = newRouteTree(patterns)
+}
+
+// route returns the bulkhead of path, or nil if it has no limit.
+func (l *ConcurrencyLimiter) route(path string) *bulkhead {
+	pattern, ok := l.routes.match(path)
+	if !ok {
+		return nil
+	}
+	return l.bulkheads[pattern]
+}
+
+func (l *ConcurrencyLimiter) ServeHTTP(w ResponseWriter, r *Request) {
+	l.once.Do(l.init)
+	// Taking the route's slot first keeps requests queued for a
+	// slow route from holding global slots meanwhile.
+	rb := l.route(r.URL.Path)
+	if !rb.acquire(r) {
+		l.reject(w, r)
+		return
+	}
+	defer rb.release()
+	if !l.global.acquire(r) {
+		l.reject(w, r)
+		return
+	}
+	defer l.global.release()
+	l.Handler.ServeHTTP(w, r)
+}
+
+func (l *ConcurrencyLimiter) reject(w ResponseWriter, r *Request) {
+	secs := int64((l.RetryAfter + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+	respondError(w, r, StatusServiceUnavailable, "Service Unavailable: too many requests in flight", errConcurrencyLimit)
+}
@@ -0,0 +1,107 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	. "net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	release := make(chan bool)
+	started := make(chan string, 10)
+	l := &ConcurrencyLimiter{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			started <- r.URL.Path
+			if r.URL.Query().Get("block") != "" {
+				<-release
+			}
+		}),
+		Limit: ConcurrencyLimit{MaxInFlight: 3},
+		Routes: map[string]ConcurrencyLimit{
+			"/slow/": {MaxInFlight: 1, MaxQueue: 1, QueueTimeout: time.Minute},
+			"/fast":  {MaxInFlight: 1},
+		},
+		RetryAfter: 1500 * time.Millisecond,
+	}
+	serve := func(path string) *httptest.ResponseRecorder {
+		req, _ := NewRequest("GET", "http://example.com"+path, nil)
+		rec := httptest.NewRecorder()
+		l.ServeHTTP(rec, req)
+		return rec
+	}
+	var wg sync.WaitGroup
+	codes := make(chan int, 10)
+	async := func(path string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- serve(path).Code
+		}()
+	}
+
+	async("/slow/a?block=1")
+	<-started
+	async("/slow/b?block=1") // queued
+	time.Sleep(20 * time.Millisecond)
+	if rec := serve("/slow/c"); rec.Code != 503 || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("over the route's queue: %d, Retry-After %q; want 503, 2", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := serve("/other"); rec.Code != 200 {
+		t.Errorf("another route while /slow/ is full: %d; want 200", rec.Code)
+	}
+	<-started
+
+	async("/fast?block=1")
+	<-started
+	if rec := serve("/fast"); rec.Code != 503 {
+		t.Errorf("over the route's limit without a queue: %d; want 503", rec.Code)
+	}
+	async("/x?block=1")
+	<-started
+	if rec := serve("/y"); rec.Code != 503 {
+		t.Errorf("over the global limit: %d; want 503", rec.Code)
+	}
+
+	release <- true // the first /slow/ request, letting the queued one in
+	if p := <-started; p != "/slow/b" {
+		t.Errorf("started %s; want the queued /slow/b", p)
+	}
+	close(release)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != 200 {
+			t.Errorf("admitted request: %d; want 200", code)
+		}
+	}
+}
+
+func TestConcurrencyLimiterQueueTimeout(t *testing.T) {
+	release := make(chan bool)
+	l := &ConcurrencyLimiter{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) { <-release }),
+		Limit:   ConcurrencyLimit{MaxInFlight: 1, MaxQueue: 5, QueueTimeout: 20 * time.Millisecond},
+	}
+	done := make(chan bool)
+	go func() {
+		req, _ := NewRequest("GET", "http://example.com/", nil)
+		l.ServeHTTP(httptest.NewRecorder(), req)
+		done <- true
+	}()
+	time.Sleep(10 * time.Millisecond)
+	req, _ := NewRequest("GET", "http://example.com/", nil)
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	l.ServeHTTP(rec, req)
+	if rec.Code != 503 || time.Since(start) < 20*time.Millisecond {
+		t.Errorf("queued request: %d after %v; want 503 after the timeout", rec.Code, time.Since(start))
+	}
+	close(release)
+	<-done
+}
@@ -0,0 +1,123 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Limiting the number of concurrent connections.
+
+package http
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// A ConnLimitPolicy says what a Server does with new connections when
+// it has MaxConns connections open.
+type ConnLimitPolicy int
+
+const (
+	// ConnLimitPause stops accepting until a connection closes.
+	// New connections wait in the listener's backlog, which the
+	// kernel bounds.
+	ConnLimitPause ConnLimitPolicy = iota
+
+	// ConnLimitReject accepts new connections anyway and answers
+	// their first request with 503 Service Unavailable before
+	// closing them, so that clients and load balancers learn of
+	// the overload at once.
+	ConnLimitReject
+)
+
+// ConnStats holds connection counts of a Server.
+type ConnStats struct {
+	Open     int    // connections being served
+	Rejected uint64 // connections answered with 503 at the MaxConns limit
+}
+
+// ConnStats returns srv's connection counts, for exporting as
+// metrics.
+func (srv *Server) ConnStats() ConnStats {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return ConnStats{Open: int(atomic.LoadInt32(&srv.numConns)), Rejected: srv.rejectedConns}
+}
+
+func (srv *Server) connsFreeLocked() *sync.Cond {
+	if srv.connsFree == nil {
+		srv.connsFree = sync.NewCond(&srv.mu)
+	}
+	return srv.connsFree
+}
+
+// waitConnSlot blocks while srv is at its connection limit under
+// ConnLimitPause, then reserves a slot for the connection about to be
+// accepted, so that concurrent acceptors can't together exceed the
+// limit. It reports whether it did; the slot is taken by startConn,
+// or released with releaseConnSlot if Accept fails.
+func (srv *Server) waitConnSlot() (reserved bool) {
+	if srv.MaxConns <= 0 || srv.ConnLimitPolicy != ConnLimitPause {
+		return false
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for int(atomic.LoadInt32(&srv.numConns))+srv.reservedConns >= srv.MaxConns && !srv.closed {
+		srv.connsFreeLocked().Wait()
+	}
+	srv.reservedConns++
+	return true
+}
+
+// releaseConnSlot releases a slot reserved by waitConnSlot.
+func (srv *Server) releaseConnSlot() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.reservedConns--
+	if srv.connsFree != nil {
+		srv.connsFree.Signal()
+	}
+}
+
+// startConn counts a newly accepted connection, in the slot reserved
+// for it if any, reporting whether it is over the limit and must be
+// rejected. Rejected connections are not counted as open. Without
+// MaxConns, no lock is taken.
+func (srv *Server) startConn(reserved bool) (overLimit bool) {
+	if srv.MaxConns <= 0 {
+		atomic.AddInt32(&srv.numConns, 1)
+		return false
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if reserved {
+		srv.reservedConns--
+	} else if int(atomic.LoadInt32(&srv.numConns)) >= srv.MaxConns && srv.ConnLimitPolicy == ConnLimitReject {
+		srv.rejectedConns++
+		return true
+	}
+	atomic.AddInt32(&srv.numConns, 1)
+	return false
+}
+
+// endConn uncounts a connection when it is closed or hijacked.
+func (srv *Server) endConn(overLimit bool) {
+	if overLimit {
+		return
+	}
+	atomic.AddInt32(&srv.numConns, -1)
+	if srv.MaxConns <= 0 {
+		return
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.connsFree != nil {
+		srv.connsFree.Signal()
+	}
+}
+
+// overLimitHandler answers requests on connections rejected at the
+// connection limit.
+var overLimitHandler = HandlerFunc(func(w ResponseWriter, r *Request) {
+	w.Header().Set("Connection", "close")
+	w.Header().Set("Retry-After", "1")
+	respondError(w, r, StatusServiceUnavailable, "Service Unavailable: too many connections", errTooManyConns)
+})
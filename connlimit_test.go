@@ -0,0 +1,179 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"testing"
+	"time"
+)
+
+// limitTestConn opens a connection to addr and sends a request,
+// returning the connection and a reader for its responses.
+func limitTestConn(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(c, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	return c, bufio.NewReader(c)
+}
+
+func readLimitTestResponse(t *testing.T, br *bufio.Reader) *Response {
+	res, err := ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	return res
+}
+
+func waitOpenConns(t *testing.T, srv *Server, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.ConnStats().Open != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("open connections = %d; want %d", srv.ConnStats().Open, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServerMaxConnsReject(t *testing.T) {
+	defer afterTest(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	srv := &Server{
+		Handler:         HandlerFunc(func(w ResponseWriter, r *Request) {}),
+		MaxConns:        1,
+		ConnLimitPolicy: ConnLimitReject,
+	}
+	go srv.Serve(ln)
+
+	a, abr := limitTestConn(t, ln.Addr().String())
+	if res := readLimitTestResponse(t, abr); res.StatusCode != StatusOK {
+		t.Fatalf("first connection: status %d", res.StatusCode)
+	}
+	waitOpenConns(t, srv, 1)
+
+	b, bbr := limitTestConn(t, ln.Addr().String())
+	defer b.Close()
+	res := readLimitTestResponse(t, bbr)
+	if res.StatusCode != StatusServiceUnavailable || !res.Close {
+		t.Errorf("connection over the limit: status %d, close %v; want 503 and close", res.StatusCode, res.Close)
+	}
+	if got := srv.ConnStats(); got != (ConnStats{Open: 1, Rejected: 1}) {
+		t.Errorf("ConnStats = %+v; want 1 open, 1 rejected", got)
+	}
+
+	a.Close()
+	waitOpenConns(t, srv, 0)
+	c, cbr := limitTestConn(t, ln.Addr().String())
+	defer c.Close()
+	if res := readLimitTestResponse(t, cbr); res.StatusCode != StatusOK {
+		t.Errorf("connection after one closed: status %d; want 200", res.StatusCode)
+	}
+}
+
+func TestServerMaxConnsPause(t *testing.T) {
+	defer afterTest(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	srv := &Server{
+		Handler:  HandlerFunc(func(w ResponseWriter, r *Request) {}),
+		MaxConns: 1,
+	}
+	go srv.Serve(ln)
+
+	a, abr := limitTestConn(t, ln.Addr().String())
+	readLimitTestResponse(t, abr)
+
+	b, bbr := limitTestConn(t, ln.Addr().String())
+	defer b.Close()
+	got := make(chan *Response, 1)
+	go func() {
+		res, err := ReadResponse(bbr, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		got <- res
+	}()
+	select {
+	case <-got:
+		t.Fatal("connection over the limit was served")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	a.Close()
+	select {
+	case res := <-got:
+		if res != nil && res.StatusCode != StatusOK {
+			t.Errorf("status %d; want 200", res.StatusCode)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting connection not served after another closed")
+	}
+}
+
+// Acceptors waiting at the limit take turns, instead of all accepting
+// once a connection closes.
+func TestServerMaxConnsPauseAcceptors(t *testing.T) {
+	defer afterTest(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	srv := &Server{
+		Handler:   HandlerFunc(func(w ResponseWriter, r *Request) {}),
+		MaxConns:  1,
+		Acceptors: 4,
+	}
+	go srv.Serve(ln)
+	time.Sleep(50 * time.Millisecond) // for all acceptors to start
+
+	a, abr := limitTestConn(t, ln.Addr().String())
+	readLimitTestResponse(t, abr)
+
+	got := make(chan *Response, 2)
+	for i := 0; i < 2; i++ {
+		c, br := limitTestConn(t, ln.Addr().String())
+		defer c.Close()
+		go func() {
+			res, _ := ReadResponse(br, nil)
+			got <- res
+		}()
+	}
+	select {
+	case <-got:
+		t.Fatal("connection over the limit was served")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	a.Close()
+	select {
+	case res := <-got:
+		if res == nil || res.StatusCode != StatusOK {
+			t.Errorf("got %v; want 200", res)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting connection not served after another closed")
+	}
+	select {
+	case <-got:
+		t.Error("two connections served after one closed")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Transparent decoding and size limits of response bodies.
+
+package http
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A ContentDecoder returns a reader of the decoding of r, a body
+// encoded with a content coding such as "br".
+type ContentDecoder func(r io.Reader) (io.ReadCloser, error)
+
+// DecodeDeflate is a ContentDecoder for the "deflate" content coding.
+// As some servers send raw DEFLATE data (RFC 1951) rather than the
+// zlib format (RFC 1950) the coding names, both are accepted.
+func DecodeDeflate(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	hdr, err := br.Peek(2)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if hdr[0]&0x0f == 8 && (uint16(hdr[0])<<8|uint16(hdr[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+func decodeGzip(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// ErrResponseBodyTooLarge is returned by reads of a response body
+// beyond the MaxResponseBodyBytes of its Transport.
+var ErrResponseBodyTooLarge = errors.New("http: response body too large")
+
+// acceptEncoding returns the Accept-Encoding header value the
+// Transport sends when it requests compression itself.
+func (t *Transport) acceptEncoding() string {
+	codings := []string{"gzip"}
+	for c := range t.ContentDecoders {
+		if c != "gzip" {
+			codings = append(codings, c)
+		}
+	}
+	sort.Strings(codings[1:])
+	return strings.Join(codings, ", ")
+}
+
+// decoder returns the decoder of content coding c, or nil.
+func (t *Transport) decoder(c string) ContentDecoder {
+	if d := t.ContentDecoders[c]; d != nil {
+		return d
+	}
+	if c == "gzip" {
+		return decodeGzip
+	}
+	return nil
+}
+
+// decodeBody replaces the body of resp, encoded with the content
+// codings listed in its Content-Encoding header, with its decoding.
+// The body is left as is if any of the codings has no decoder.
+func (t *Transport) decodeBody(resp *Response) error {
+	ce := resp.Header.Get("Content-Encoding")
+	if ce == "" {
+		return nil
+	}
+	codings := strings.Split(ce, ",")
+	decoders := make([]ContentDecoder, 0, len(codings))
+	for _, c := range codings {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c == "identity" {
+			continue
+		}
+		d := t.decoder(c)
+		if d == nil {
+			return nil
+		}
+		decoders = append(decoders, d)
+	}
+
+	// The codings were applied in the order listed, so they are
+	// decoded in reverse.
+	body := &decodedBody{Reader: resp.Body, closers: []io.Closer{resp.Body}}
+	for i := len(decoders) - 1; i >= 0; i-- {
+		rc, err := decoders[i](body.Reader)
+		if err != nil {
+			return err
+		}
+		body.Reader = rc
+		body.closers = append(body.closers, rc)
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Body = body
+	return nil
+}
+
+// decodedBody is the decoding of a response body.
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer // the encoded body, then its decoders
+}
+
+func (b *decodedBody) Close() error {
+	var err error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if cerr := b.closers[i].Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// maxBytesBody is a response body failing with
+// ErrResponseBodyTooLarge after n bytes.
+type maxBytesBody struct {
+	rc io.ReadCloser
+	n  int64 // bytes left, or -1 once too many were read
+}
+
+func (b *maxBytesBody) Read(p []byte) (n int, err error) {
+	if b.n < 0 {
+		return 0, ErrResponseBodyTooLarge
+	}
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	n, err = b.rc.Read(p)
+	if int64(n) > b.n {
+		n, b.n = int(b.n), -1
+		return n, ErrResponseBodyTooLarge
+	}
+	b.n -= int64(n)
+	return n, err
+}
+
+func (b *maxBytesBody) Close() error {
+	return b.rc.Close()
+}
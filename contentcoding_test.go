@@ -0,0 +1,128 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func decodeBase64(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(base64.NewDecoder(base64.StdEncoding, r)), nil
+}
+
+func TestTransportContentDecoders(t *testing.T) {
+	defer afterTest(t)
+	const text = "the decoded response body"
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if g, e := r.Header.Get("Accept-Encoding"), "gzip, deflate, x-base64"; g != e {
+			t.Errorf("Accept-Encoding = %q, want %q", g, e)
+		}
+		var buf bytes.Buffer
+		coding := r.FormValue("coding")
+		switch coding {
+		case "deflate":
+			zw := zlib.NewWriter(&buf)
+			io.WriteString(zw, text)
+			zw.Close()
+		case "rawdeflate":
+			coding = "deflate"
+			fw, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+			io.WriteString(fw, text)
+			fw.Close()
+		case "gzip, x-base64":
+			bw := base64.NewEncoder(base64.StdEncoding, &buf)
+			gw := gzip.NewWriter(bw)
+			io.WriteString(gw, text)
+			gw.Close()
+			bw.Close()
+		default:
+			buf.WriteString(text)
+		}
+		w.Header().Set("Content-Encoding", coding)
+		w.Write(buf.Bytes())
+	}))
+	defer ts.Close()
+
+	tr := &Transport{ContentDecoders: map[string]ContentDecoder{
+		"deflate":  DecodeDeflate,
+		"x-base64": decodeBase64,
+	}}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	for _, coding := range []string{"deflate", "rawdeflate", "gzip, x-base64"} {
+		res, err := c.Get(ts.URL + "/?coding=" + strings.Replace(coding, " ", "+", -1))
+		if err != nil {
+			t.Fatalf("%s: %v", coding, err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil || string(b) != text {
+			t.Errorf("%s: body = %q, %v; want %q", coding, b, err, text)
+		}
+		if ce := res.Header.Get("Content-Encoding"); ce != "" {
+			t.Errorf("%s: Content-Encoding %q left", coding, ce)
+		}
+	}
+
+	// A coding without a decoder is left as is.
+	res, err := c.Get(ts.URL + "/?coding=x-unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(b) != text || res.Header.Get("Content-Encoding") != "x-unknown" {
+		t.Errorf("unknown coding: body %q, Content-Encoding %q", b, res.Header.Get("Content-Encoding"))
+	}
+}
+
+func TestTransportMaxResponseBodyBytes(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.FormValue("gzip") != "" {
+			w.Header().Set("Content-Encoding", "gzip")
+			gw := gzip.NewWriter(w)
+			gw.Write(make([]byte, 1<<20))
+			gw.Close()
+			return
+		}
+		w.Write(make([]byte, 100))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		path string
+		max  int64
+		n    int
+		err  error
+	}{
+		{"/", 100, 100, nil},
+		{"/", 50, 50, ErrResponseBodyTooLarge},
+		{"/?gzip=1", 1000, 1000, ErrResponseBodyTooLarge},
+	}
+	for _, tt := range tests {
+		tr := &Transport{MaxResponseBodyBytes: tt.max}
+		res, err := (&Client{Transport: tr}).Get(ts.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if len(b) != tt.n || err != tt.err {
+			t.Errorf("%s with max %d: read %d bytes, %v; want %d, %v", tt.path, tt.max, len(b), err, tt.n, tt.err)
+		}
+		tr.CloseIdleConnections()
+	}
+}
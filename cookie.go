@@ -6,6 +6,7 @@ package http
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -34,10 +35,70 @@ type Cookie struct {
 	MaxAge   int
 	Secure   bool
 	HttpOnly bool
+	SameSite SameSite
 	Raw      string
 	Unparsed []string // Raw text of unparsed attribute-value pairs
 }
 
+// SameSite allows a server to define a cookie attribute making it
+// impossible for the browser to send this cookie along with cross-site
+// requests. The main goal is to mitigate the risk of cross-origin
+// information leakage, and provide some protection against cross-site
+// request forgery attacks.
+//
+// See https://tools.ietf.org/html/draft-ietf-httpbis-cookie-same-site-00 for details.
+type SameSite int
+
+const (
+	SameSiteDefaultMode SameSite = iota // no SameSite attribute
+	SameSiteLaxMode
+	SameSiteStrictMode
+	SameSiteNoneMode // requires Secure
+)
+
+var sameSiteModes = map[string]SameSite{
+	"lax":    SameSiteLaxMode,
+	"strict": SameSiteStrictMode,
+	"none":   SameSiteNoneMode,
+}
+
+// Errors returned by Cookie.Valid.
+var (
+	ErrCookieName     = errors.New("http: invalid cookie name")
+	ErrCookiePrefix   = errors.New("http: cookie violates the requirements of its name's prefix")
+	ErrCookieSameSite = errors.New("http: SameSite=None cookie not Secure")
+)
+
+// Valid reports whether c may be set: whether its name is a token,
+// and it meets the requirements of the cookie name prefixes of
+// draft-ietf-httpbis-rfc6265bis, that "__Secure-" cookies be Secure
+// and "__Host-" ones too, with a Path of "/" and no Domain, and that
+// SameSite=None cookies be Secure too.
+func (c *Cookie) Valid() error {
+	if c.Name == "" || !isCookieNameValid(c.Name) {
+		return ErrCookieName
+	}
+	if !c.prefixOK() {
+		return ErrCookiePrefix
+	}
+	if c.SameSite == SameSiteNoneMode && !c.Secure {
+		return ErrCookieSameSite
+	}
+	return nil
+}
+
+// prefixOK reports whether c meets the requirements of its name's
+// prefix, if any. Browsers match the prefixes case-sensitively.
+func (c *Cookie) prefixOK() bool {
+	switch {
+	case strings.HasPrefix(c.Name, "__Secure-"):
+		return c.Secure
+	case strings.HasPrefix(c.Name, "__Host-"):
+		return c.Secure && c.Path == "/" && c.Domain == ""
+	}
+	return true
+}
+
 // readSetCookies parses all "Set-Cookie" values from
 // the header h and returns the successfully parsed Cookies.
 func readSetCookies(h Header) []*Cookie {
@@ -92,6 +153,11 @@ func readSetCookies(h Header) []*Cookie {
 			case "httponly":
 				c.HttpOnly = true
 				continue
+			case "samesite":
+				if mode, ok := sameSiteModes[strings.ToLower(val)]; ok {
+					c.SameSite = mode
+					continue
+				}
 			case "domain":
 				c.Domain = val
 				// TODO: Add domain parsing
@@ -126,13 +192,25 @@ func readSetCookies(h Header) []*Cookie {
 			}
 			c.Unparsed = append(c.Unparsed, parts[i])
 		}
+		if !c.prefixOK() {
+			// As browsers do, drop cookies claiming a prefix
+			// whose requirements they don't meet.
+			continue
+		}
 		cookies = append(cookies, c)
 	}
 	return cookies
 }
 
 // SetCookie adds a Set-Cookie header to the provided ResponseWriter's headers.
+// Cookies that don't meet the requirements of their name's prefix, or
+// with SameSite=None but not Secure, which browsers would ignore, are
+// logged and dropped; see Cookie.Valid.
 func SetCookie(w ResponseWriter, cookie *Cookie) {
+	if err := cookie.Valid(); err == ErrCookiePrefix || err == ErrCookieSameSite {
+		log.Printf("net/http: dropping cookie %q: %v", cookie.Name, err)
+		return
+	}
 	w.Header().Add("Set-Cookie", cookie.String())
 }
 
@@ -175,6 +253,14 @@ func (c *Cookie) String() string {
 	if c.Secure {
 		fmt.Fprintf(&b, "; Secure")
 	}
+	switch c.SameSite {
+	case SameSiteLaxMode:
+		fmt.Fprintf(&b, "; SameSite=Lax")
+	case SameSiteStrictMode:
+		fmt.Fprintf(&b, "; SameSite=Strict")
+	case SameSiteNoneMode:
+		fmt.Fprintf(&b, "; SameSite=None")
+	}
 	return b.String()
 }
 
@@ -7,6 +7,9 @@ package http
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
 	"reflect"
 	"testing"
 	"time"
@@ -48,6 +51,18 @@ var writeSetCookiesTests = []struct {
 		&Cookie{Name: "cookie-8", Value: "eight", Domain: "::1"},
 		"cookie-8=eight",
 	},
+	{
+		&Cookie{Name: "cookie-9", Value: "nine", SameSite: SameSiteLaxMode},
+		"cookie-9=nine; SameSite=Lax",
+	},
+	{
+		&Cookie{Name: "cookie-10", Value: "ten", Secure: true, SameSite: SameSiteNoneMode},
+		"cookie-10=ten; Secure; SameSite=None",
+	},
+	{
+		&Cookie{Name: "cookie-11", Value: "eleven", HttpOnly: true, SameSite: SameSiteStrictMode},
+		"cookie-11=eleven; HttpOnly; SameSite=Strict",
+	},
 }
 
 func TestWriteSetCookies(t *testing.T) {
@@ -88,6 +103,44 @@ func TestSetCookie(t *testing.T) {
 	}
 }
 
+var validCookieTests = []struct {
+	c    *Cookie
+	want error
+}{
+	{&Cookie{Name: "a", Value: "b"}, nil},
+	{&Cookie{Name: "", Value: "b"}, ErrCookieName},
+	{&Cookie{Name: "a b", Value: "b"}, ErrCookieName},
+	{&Cookie{Name: "__Secure-id", Secure: true, Domain: "example.com"}, nil},
+	{&Cookie{Name: "__Secure-id"}, ErrCookiePrefix},
+	{&Cookie{Name: "__Host-id", Secure: true, Path: "/"}, nil},
+	{&Cookie{Name: "__Host-id", Secure: true}, ErrCookiePrefix},
+	{&Cookie{Name: "__Host-id", Secure: true, Path: "/", Domain: "example.com"}, ErrCookiePrefix},
+	{&Cookie{Name: "__Host-id", Path: "/"}, ErrCookiePrefix},
+	{&Cookie{Name: "__host-id"}, nil},
+	{&Cookie{Name: "a", SameSite: SameSiteNoneMode}, ErrCookieSameSite},
+	{&Cookie{Name: "a", SameSite: SameSiteNoneMode, Secure: true}, nil},
+}
+
+func TestCookieValid(t *testing.T) {
+	for _, tt := range validCookieTests {
+		if err := tt.c.Valid(); err != tt.want {
+			t.Errorf("%#v.Valid() = %v; want %v", tt.c, err, tt.want)
+		}
+	}
+}
+
+func TestSetCookieDropsInvalid(t *testing.T) {
+	defer log.SetOutput(os.Stderr)
+	log.SetOutput(ioutil.Discard)
+	m := make(Header)
+	SetCookie(headerOnlyResponseWriter(m), &Cookie{Name: "__Host-id", Value: "1", Path: "/"})
+	SetCookie(headerOnlyResponseWriter(m), &Cookie{Name: "a", Value: "1", SameSite: SameSiteNoneMode})
+	SetCookie(headerOnlyResponseWriter(m), &Cookie{Name: "__Host-id", Value: "2", Path: "/", Secure: true})
+	if g, e := m["Set-Cookie"], []string{"__Host-id=2; Path=/; Secure"}; !reflect.DeepEqual(g, e) {
+		t.Errorf("Set-Cookie = %q; want %q", g, e)
+	}
+}
+
 var addCookieTests = []struct {
 	Cookies []*Cookie
 	Raw     string
@@ -166,6 +219,20 @@ var readSetCookiesTests = []struct {
 			Raw:      "ASP.NET_SessionId=foo; path=/; HttpOnly",
 		}},
 	},
+	{
+		Header{"Set-Cookie": {"a=1; SameSite=lax", "b=2; SameSite=Bogus"}},
+		[]*Cookie{
+			{Name: "a", Value: "1", SameSite: SameSiteLaxMode, Raw: "a=1; SameSite=lax"},
+			{Name: "b", Value: "2", Unparsed: []string{"SameSite=Bogus"}, Raw: "b=2; SameSite=Bogus"},
+		},
+	},
+	{
+		Header{"Set-Cookie": {"__Host-a=1; Secure; Path=/", "__Host-b=2; Secure", "__Secure-c=3", "__Secure-d=4; Secure"}},
+		[]*Cookie{
+			{Name: "__Host-a", Value: "1", Secure: true, Path: "/", Raw: "__Host-a=1; Secure; Path=/"},
+			{Name: "__Secure-d", Value: "4", Secure: true, Raw: "__Secure-d=4; Secure"},
+		},
+	},
 
 	// TODO(bradfitz): users have reported seeing this in the
 	// wild, but do browsers handle it? RFC 6265 just says "don't
@@ -0,0 +1,170 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Signed and encrypted cookie values.
+
+package http
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// Errors returned by a CookieCodec.
+var (
+	ErrCookieInvalid  = errors.New("http: cookie value not valid")
+	ErrCookieExpired  = errors.New("http: cookie value expired")
+	ErrCookieTooLong  = errors.New("http: encoded cookie too long")
+	ErrCookieNoKey    = errors.New("http: CookieCodec without HashKeys")
+	ErrCookieBlockKey = errors.New("http: CookieCodec BlockKeys must be 16, 24 or 32 bytes long")
+)
+
+// maxCookieSize is the size of a name=value pair beyond which browsers
+// may drop a cookie.
+const maxCookieSize = 4096
+
+// A CookieCodec encodes values, such as session tokens, as cookie
+// values clients can't forge, signed with HMAC-SHA256, and, given
+// BlockKeys, can't read, encrypted with AES-GCM, and decodes them. The
+// encoding holds the time of encoding and is bound to the cookie's
+// name, so that values of one cookie can't be passed off as another's.
+type CookieCodec struct {
+	// HashKeys are the keys of the signatures: the first signs and
+	// all are tried in verifying, so that keys may be rotated by
+	// prepending new ones. At least one is required, and 32 random
+	// bytes are recommended.
+	HashKeys [][]byte
+
+	// BlockKeys, if any, are the AES keys, of 16, 24 or 32 bytes,
+	// that encrypt values: the first encrypts and all are tried in
+	// decrypting.
+	BlockKeys [][]byte
+
+	// MaxAge, if positive, is the age of a value beyond which Decode
+	// fails with ErrCookieExpired, whatever the cookie's own Expires
+	// or MaxAge, which clients may ignore.
+	MaxAge time.Duration
+}
+
+// Encode returns the encoding of value for the cookie named name.
+func (c *CookieCodec) Encode(name string, value []byte) (string, error) {
+	if len(c.HashKeys) == 0 {
+		return "", ErrCookieNoKey
+	}
+	b := make([]byte, 8, 8+len(value)+sha256.Size)
+	binary.BigEndian.PutUint64(b, uint64(time.Now().UnixNano()))
+	if len(c.BlockKeys) > 0 {
+		aead, err := cookieAEAD(c.BlockKeys[0])
+		if err != nil {
+			return "", err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return "", err
+		}
+		b = append(b, nonce...)
+		b = aead.Seal(b, nonce, value, []byte(name))
+	} else {
+		b = append(b, value...)
+	}
+	b = append(b, cookieMAC(c.HashKeys[0], name, b)...)
+	s := base64.RawURLEncoding.EncodeToString(b)
+	if len(name)+1+len(s) > maxCookieSize {
+		return "", ErrCookieTooLong
+	}
+	return s, nil
+}
+
+// Decode returns the value encoded in s by Encode for the cookie named
+// name, failing with ErrCookieInvalid if it isn't such an encoding,
+// under any of the keys, or ErrCookieExpired if it's older than
+// MaxAge.
+func (c *CookieCodec) Decode(name, s string) ([]byte, error) {
+	if len(c.HashKeys) == 0 {
+		return nil, ErrCookieNoKey
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) < 8+sha256.Size {
+		return nil, ErrCookieInvalid
+	}
+	b, mac := b[:len(b)-sha256.Size], b[len(b)-sha256.Size:]
+	signed := false
+	for _, key := range c.HashKeys {
+		if hmac.Equal(mac, cookieMAC(key, name, b)) {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return nil, ErrCookieInvalid
+	}
+	t := time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+	if c.MaxAge > 0 && time.Since(t) > c.MaxAge {
+		return nil, ErrCookieExpired
+	}
+	value := b[8:]
+	if len(c.BlockKeys) == 0 {
+		return value, nil
+	}
+	for _, key := range c.BlockKeys {
+		aead, err := cookieAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(value) < aead.NonceSize() {
+			break
+		}
+		nonce, sealed := value[:aead.NonceSize()], value[aead.NonceSize():]
+		if plain, err := aead.Open(nil, nonce, sealed, []byte(name)); err == nil {
+			return plain, nil
+		}
+	}
+	return nil, ErrCookieInvalid
+}
+
+// SetCookie sets cookie, with its Value the encoding of value, as
+// SetCookie does.
+func (c *CookieCodec) SetCookie(w ResponseWriter, cookie *Cookie, value []byte) error {
+	s, err := c.Encode(cookie.Name, value)
+	if err != nil {
+		return err
+	}
+	cookie.Value = s
+	SetCookie(w, cookie)
+	return nil
+}
+
+// Cookie returns the value decoded from the named cookie of r, or
+// ErrNoCookie if r has none.
+func (c *CookieCodec) Cookie(r *Request, name string) ([]byte, error) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.Decode(name, cookie.Value)
+}
+
+func cookieMAC(key []byte, name string, b []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	io.WriteString(h, name)
+	h.Write([]byte{0})
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+func cookieAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, ErrCookieBlockKey
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,121 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookieCodec(t *testing.T) {
+	key1, key2 := []byte(strings.Repeat("k", 32)), []byte(strings.Repeat("j", 32))
+	aes1, aes2 := []byte(strings.Repeat("a", 16)), []byte(strings.Repeat("b", 32))
+	for _, blockKeys := range [][][]byte{nil, {aes1}} {
+		c := &CookieCodec{HashKeys: [][]byte{key1}, BlockKeys: blockKeys}
+		value := []byte("user=42")
+		s, err := c.Encode("session", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := c.Decode("session", s); err != nil || !bytes.Equal(got, value) {
+			t.Errorf("Decode = %q, %v; want %q", got, err, value)
+		}
+		raw, _ := base64.RawURLEncoding.DecodeString(s)
+		if plain := bytes.Contains(raw, value); plain != (blockKeys == nil) {
+			t.Errorf("BlockKeys %q: encoding %q holds the plain value: %v", blockKeys, s, plain)
+		}
+		if _, err := c.Decode("other", s); err != ErrCookieInvalid {
+			t.Errorf("Decode under another name: %v; want ErrCookieInvalid", err)
+		}
+		tampered := []byte(s)
+		tampered[10] ^= 1
+		if _, err := c.Decode("session", string(tampered)); err != ErrCookieInvalid {
+			t.Errorf("Decode of tampered value: %v; want ErrCookieInvalid", err)
+		}
+
+		// Rotated keys still decode older values.
+		rotated := &CookieCodec{HashKeys: [][]byte{key2, key1}}
+		if blockKeys != nil {
+			rotated.BlockKeys = append([][]byte{aes2}, blockKeys...)
+		}
+		if got, err := rotated.Decode("session", s); err != nil || !bytes.Equal(got, value) {
+			t.Errorf("Decode with rotated keys = %q, %v; want %q", got, err, value)
+		}
+		other := &CookieCodec{HashKeys: [][]byte{key2}, BlockKeys: blockKeys}
+		if _, err := other.Decode("session", s); err != ErrCookieInvalid {
+			t.Errorf("Decode with other key: %v; want ErrCookieInvalid", err)
+		}
+	}
+}
+
+func TestCookieCodecErrors(t *testing.T) {
+	if _, err := new(CookieCodec).Encode("a", nil); err != ErrCookieNoKey {
+		t.Errorf("Encode without keys: %v; want ErrCookieNoKey", err)
+	}
+	c := &CookieCodec{HashKeys: [][]byte{[]byte("k")}, BlockKeys: [][]byte{[]byte("short")}}
+	if _, err := c.Encode("a", nil); err != ErrCookieBlockKey {
+		t.Errorf("Encode with short block key: %v; want ErrCookieBlockKey", err)
+	}
+	c.BlockKeys = nil
+	if _, err := c.Encode("a", make([]byte, 4000)); err != ErrCookieTooLong {
+		t.Errorf("Encode of 4000 bytes: %v; want ErrCookieTooLong", err)
+	}
+	if _, err := c.Decode("a", "!!!"); err != ErrCookieInvalid {
+		t.Errorf("Decode of garbage: %v; want ErrCookieInvalid", err)
+	}
+	c.MaxAge = time.Millisecond
+	s, _ := c.Encode("a", []byte("v"))
+	time.Sleep(5 * time.Millisecond)
+	if _, err := c.Decode("a", s); err != ErrCookieExpired {
+		t.Errorf("Decode of old value: %v; want ErrCookieExpired", err)
+	}
+}
+
+func TestCookieCodecRoundTrip(t *testing.T) {
+	defer afterTest(t)
+	c := &CookieCodec{HashKeys: [][]byte{[]byte("secret")}, BlockKeys: [][]byte{[]byte(strings.Repeat("x", 24))}}
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/set" {
+			if err := c.SetCookie(w, &Cookie{Name: "__Host-s", Path: "/", Secure: true, SameSite: SameSiteStrictMode}, []byte("token")); err != nil {
+				t.Error(err)
+			}
+			return
+		}
+		v, err := c.Cookie(r, "__Host-s")
+		if err != nil {
+			Error(w, err.Error(), StatusForbidden)
+			return
+		}
+		w.Write(v)
+	}))
+	defer ts.Close()
+
+	res, err := Get(ts.URL + "/set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	cookies := res.Cookies()
+	if len(cookies) != 1 || cookies[0].SameSite != SameSiteStrictMode {
+		t.Fatalf("cookies = %v; want one SameSite=Strict", cookies)
+	}
+	req, _ := NewRequest("GET", ts.URL+"/get", nil)
+	req.AddCookie(&Cookie{Name: cookies[0].Name, Value: cookies[0].Value})
+	res, err = DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	body.ReadFrom(res.Body)
+	res.Body.Close()
+	if res.StatusCode != 200 || body.String() != "token" {
+		t.Errorf("got %d %q; want 200 %q", res.StatusCode, body.String(), "token")
+	}
+}
@@ -2,7 +2,8 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Package cookiejar implements an in-memory RFC 6265-compliant http.CookieJar.
+// Package cookiejar implements an in-memory RFC 6265-compliant http.CookieJar,
+// whose cookies may be persisted by a Storage.
 package cookiejar
 
 import (
@@ -54,11 +55,16 @@ type Options struct {
 	// secure: it means that the HTTP server for foo.co.uk can set a cookie
 	// for bar.co.uk.
 	PublicSuffixList PublicSuffixList
+
+	// Storage, if non-nil, persists the jar's cookies. New loads
+	// the cookies saved last, and the jar's Save method saves them.
+	Storage Storage
 }
 
 // Jar implements the http.CookieJar interface from the net/http package.
 type Jar struct {
-	psList PublicSuffixList
+	psList  PublicSuffixList
+	storage Storage
 
 	// mu locks the remaining fields.
 	mu sync.Mutex
@@ -73,13 +79,21 @@ type Jar struct {
 }
 
 // New returns a new cookie jar. A nil *Options is equivalent to a zero
-// Options.
+// Options. It fails if the Options' Storage fails to load.
 func New(o *Options) (*Jar, error) {
 	jar := &Jar{
 		entries: make(map[string]map[string]entry),
 	}
 	if o != nil {
 		jar.psList = o.PublicSuffixList
+		jar.storage = o.Storage
+	}
+	if jar.storage != nil {
+		entries, err := jar.storage.Load()
+		if err != nil {
+			return nil, err
+		}
+		jar.load(entries, time.Now())
 	}
 	return jar, nil
 }
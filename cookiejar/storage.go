@@ -0,0 +1,169 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cookiejar
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Storage persists the cookies of a Jar, so that they survive the
+// process. Only persistent cookies, those with an expiry time, are
+// stored; session cookies are not.
+//
+// Implementations of Storage must be safe for concurrent use by
+// multiple goroutines.
+type Storage interface {
+	// Load returns the entries saved last.
+	Load() ([]Entry, error)
+
+	// Save replaces the saved entries with entries.
+	Save(entries []Entry) error
+}
+
+// An Entry is a persistent cookie as stored by a Storage. Its fields
+// are those of RFC 6265 section 5.3.
+type Entry struct {
+	Name       string
+	Value      string
+	Domain     string
+	Path       string
+	Secure     bool
+	HttpOnly   bool
+	HostOnly   bool
+	Expires    time.Time
+	Creation   time.Time
+	LastAccess time.Time
+}
+
+// Save saves the jar's unexpired persistent cookies to its Storage.
+// It does nothing if the jar has no Storage.
+func (j *Jar) Save() error {
+	if j.storage == nil {
+		return nil
+	}
+	return j.storage.Save(j.persistentEntries(time.Now()))
+}
+
+// persistentEntries returns the persistent cookies unexpired at now,
+// in the order they were set, which load keeps for cookies created at
+// the same time.
+func (j *Jar) persistentEntries(now time.Time) []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var persistent []entry
+	for _, submap := range j.entries {
+		for _, e := range submap {
+			if e.Persistent && e.Expires.After(now) {
+				persistent = append(persistent, e)
+			}
+		}
+	}
+	sort.Slice(persistent, func(i, k int) bool {
+		return persistent[i].seqNum < persistent[k].seqNum
+	})
+	entries := make([]Entry, 0, len(persistent))
+	for _, e := range persistent {
+		entries = append(entries, Entry{
+			Name:       e.Name,
+			Value:      e.Value,
+			Domain:     e.Domain,
+			Path:       e.Path,
+			Secure:     e.Secure,
+			HttpOnly:   e.HttpOnly,
+			HostOnly:   e.HostOnly,
+			Expires:    e.Expires,
+			Creation:   e.Creation,
+			LastAccess: e.LastAccess,
+		})
+	}
+	return entries
+}
+
+// load adds entries, loaded from the jar's Storage, to the jar,
+// skipping those expired at now or set for a public suffix.
+func (j *Jar) load(entries []Entry, now time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, se := range entries {
+		if !se.Expires.After(now) || se.Name == "" || se.Domain == "" || se.Path == "" {
+			continue
+		}
+		if !se.HostOnly && j.psList != nil && !isIP(se.Domain) &&
+			j.psList.PublicSuffix(se.Domain) == se.Domain {
+			continue
+		}
+		e := entry{
+			Name:       se.Name,
+			Value:      se.Value,
+			Domain:     se.Domain,
+			Path:       se.Path,
+			Secure:     se.Secure,
+			HttpOnly:   se.HttpOnly,
+			Persistent: true,
+			HostOnly:   se.HostOnly,
+			Expires:    se.Expires,
+			Creation:   se.Creation,
+			LastAccess: se.LastAccess,
+			seqNum:     j.nextSeqNum,
+		}
+		j.nextSeqNum++
+		key := jarKey(e.Domain, j.psList)
+		submap := j.entries[key]
+		if submap == nil {
+			submap = make(map[string]entry)
+			j.entries[key] = submap
+		}
+		submap[e.id()] = e
+	}
+}
+
+// FileStorage is a Storage keeping the entries in a JSON file.
+type FileStorage string
+
+// Load implements the Load method of the Storage interface. A missing
+// file holds no entries.
+func (f FileStorage) Load() ([]Entry, error) {
+	b, err := ioutil.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Save implements the Save method of the Storage interface. The file
+// is replaced atomically, and readable only by its owner.
+func (f FileStorage) Save(entries []Entry) error {
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(string(f)), filepath.Base(string(f))+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), string(f))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cookiejar
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJarStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cookiejar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storage := FileStorage(filepath.Join(dir, "cookies.json"))
+
+	jar, err := New(&Options{PublicSuffixList: testPSL{}, Storage: storage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("http://www.host.test/dir/")
+	expires := time.Now().Add(time.Hour)
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "persistent", Value: "1", Expires: expires},
+		{Name: "domain", Value: "2", Domain: "host.test", MaxAge: 3600},
+		{Name: "session", Value: "3"},
+	})
+	if err := jar.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	jar, err = New(&Options{PublicSuffixList: testPSL{}, Storage: storage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(jar.Cookies(u)); got != "[persistent=1 domain=2]" {
+		t.Errorf("cookies after reload: %s", got)
+	}
+	other, _ := url.Parse("http://other.host.test/dir/x")
+	if got := fmt.Sprint(jar.Cookies(other)); got != "[domain=2]" {
+		t.Errorf("cookies for another host after reload: %s", got)
+	}
+}
+
+// Cookies created at the same time are sent in the order they were
+// set, after a reload too.
+func TestJarStorageOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cookiejar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storage := FileStorage(filepath.Join(dir, "cookies.json"))
+
+	jar, err := New(&Options{PublicSuffixList: testPSL{}, Storage: storage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("http://www.host.test/")
+	var cookies []*http.Cookie
+	for i := 0; i < 10; i++ {
+		cookies = append(cookies, &http.Cookie{Name: fmt.Sprintf("c%d", i), Value: "v", MaxAge: 3600})
+	}
+	jar.SetCookies(u, cookies)
+	want := fmt.Sprint(jar.Cookies(u))
+	if err := jar.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	jar, err = New(&Options{PublicSuffixList: testPSL{}, Storage: storage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(jar.Cookies(u)); got != want {
+		t.Errorf("cookies after reload %s; want %s", got, want)
+	}
+}
+
+func TestJarStorageLoad(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	storage := memStorage{
+		{Name: "ok", Value: "1", Domain: "www.host.test", Path: "/", HostOnly: true, Expires: future},
+		{Name: "expired", Value: "2", Domain: "www.host.test", Path: "/", HostOnly: true, Expires: time.Now().Add(-time.Hour)},
+		{Name: "suffix", Value: "3", Domain: "co.uk", Path: "/", Expires: future},
+	}
+	jar, err := New(&Options{PublicSuffixList: testPSL{}, Storage: storage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("http://www.host.test/")
+	if got := fmt.Sprint(jar.Cookies(u)); got != "[ok=1]" {
+		t.Errorf("loaded cookies %s; want only ok=1", got)
+	}
+	u, _ = url.Parse("http://www.bbc.co.uk/")
+	if got := jar.Cookies(u); len(got) != 0 {
+		t.Errorf("cookie for a public suffix loaded: %s", got)
+	}
+
+	if _, err := New(&Options{Storage: FileStorage("testdata/none/cookies.json")}); err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+type memStorage []Entry
+
+func (s memStorage) Load() ([]Entry, error) { return s, nil }
+func (s memStorage) Save([]Entry) error     { return nil }
@@ -0,0 +1,372 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Cross-site request forgery protection.
+
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Reasons a CSRFHandler rejects a request, reported by CSRFFailure.
+var (
+	ErrCSRFOrigin = errors.New("http: CSRF check failed: cross-origin request")
+	ErrCSRFToken  = errors.New("http: CSRF check failed: missing or invalid token")
+)
+
+var csrfKey = &contextKey{"csrf"}
+
+// csrfTokenLen is the length of CSRF tokens, before masking.
+const csrfTokenLen = 32
+
+// A CSRFHandler protects the handler it wraps from cross-site request
+// forgery. Requests with methods other than GET, HEAD, OPTIONS and
+// TRACE, which must not change state, are rejected unless their Origin,
+// or Referer over HTTPS, is the origin the client reached, and they
+// carry the token CSRFToken returns for their clients, in a header or
+// form field.
+//
+// Tokens are by default random values kept by the client in a signed
+// cookie, for the double-submit pattern; with Token set, they're kept
+// by the server with the client's session, for the synchronizer token
+// pattern.
+type CSRFHandler struct {
+	// Handler is the handler protected.
+	Handler Handler
+
+	// Codec signs the token cookies. If nil, a codec with a random
+	// key is used, whose tokens last as long as the process.
+	Codec *CookieCodec
+
+	// Cookie is the template of the token cookies. If its Name is
+	// empty, "csrf_token" is used, if its Path is empty, "/", and
+	// if its SameSite is unset, Lax. Token cookies are always
+	// HttpOnly, and Secure when the client reached the server over
+	// HTTPS. Its Value is ignored.
+	Cookie Cookie
+
+	// Token, if non-nil, returns the token of r's session, which it
+	// must create when the session has none, or nil if r has no
+	// session, for the synchronizer token pattern. No cookie is set
+	// then.
+	Token func(r *Request) []byte
+
+	// HeaderName and FieldName are the request header and the form
+	// field carrying the token of a request. If empty,
+	// "X-CSRF-Token" and "csrf_token" are used.
+	HeaderName, FieldName string
+
+	// TrustedOrigins are other origins, such as
+	// "https://app.example.com", whose pages may send requests.
+	TrustedOrigins []string
+
+	// TrustedProxies lists the networks of the peers, at the
+	// request's RemoteAddr, trusted to report the scheme and host
+	// the client reached in Forwarded or X-Forwarded-Proto and
+	// X-Forwarded-Host headers. A PROXY protocol header is trusted
+	// to report TLS in any case.
+	TrustedProxies []*net.IPNet
+
+	// ExemptPaths are the paths of requests not checked, such as
+	// those of APIs authenticated otherwise than by cookies. A path
+	// ending in a slash exempts the subtree it names, as a ServeMux
+	// pattern does.
+	ExemptPaths []string
+
+	// Exempt, if non-nil, reports whether r isn't checked, as for
+	// ExemptPaths.
+	Exempt func(r *Request) bool
+
+	// FailureHandler, if non-nil, answers rejected requests, whose
+	// reason CSRFFailure returns. If nil, they're answered with 403
+	// Forbidden.
+	FailureHandler Handler
+
+	once  sync.Once
+	codec *CookieCodec
+
+	exemptOnce  sync.Once
+	exemptPaths *routeTree // of ExemptPaths
+}
+
+// csrfState is the CSRF state of a request, kept in its context.
+type csrfState struct {
+	token []byte // unmasked
+	err   error  // why the request was rejected
+}
+
+// CSRFToken returns the CSRF token to send with requests of r's
+// client, such as in a hidden form field, or "" if r didn't pass
+// through a CSRFHandler. Tokens are masked afresh on each call, so
+// that they don't leak through compressed responses.
+func CSRFToken(r *Request) string {
+	st, _ := r.Context().Value(csrfKey).(*csrfState)
+	if st == nil || st.token == nil {
+		return ""
+	}
+	return maskCSRFToken(st.token)
+}
+
+// CSRFFailure returns why a CSRFHandler rejected r, for its
+// FailureHandler, or nil.
+func CSRFFailure(r *Request) error {
+	st, _ := r.Context().Value(csrfKey).(*csrfState)
+	if st == nil {
+		return nil
+	}
+	return st.err
+}
+
+func (h *CSRFHandler) ServeHTTP(w ResponseWriter, r *Request) {
+	st := new(csrfState)
+	r = r.WithContext(context.WithValue(r.Context(), csrfKey, st))
+	scheme, host := clientOrigin(r, h.TrustedProxies)
+
+	var fromClient []byte // the token the client had before r
+	if h.Token != nil {
+		st.token = h.Token(r)
+		fromClient = st.token
+	} else {
+		fromClient = h.cookieToken(r)
+		st.token = fromClient
+		if st.token == nil {
+			st.token = newCSRFToken()
+			h.setCookie(w, st.token, scheme == "https")
+		}
+	}
+
+	if !h.checked(r) {
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+	if !h.sameOrigin(r, scheme, host) {
+		st.err = ErrCSRFOrigin
+	} else if sent := unmaskCSRFToken(h.sentToken(r)); fromClient == nil || sent == nil || subtle.ConstantTimeCompare(sent, fromClient) != 1 {
+		st.err = ErrCSRFToken
+	}
+	if st.err != nil {
+		if h.FailureHandler != nil {
+			h.FailureHandler.ServeHTTP(w, r)
+		} else {
+			Error(w, "Forbidden: "+strings.TrimPrefix(st.err.Error(), "http: "), StatusForbidden)
+		}
+		return
+	}
+	h.Handler.ServeHTTP(w, r)
+}
+
+// checked reports whether r must pass the checks.
+func (h *CSRFHandler) checked(r *Request) bool {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return false
+	}
+	h.exemptOnce.Do(func() { h.exemptPaths = newRouteTree(h.ExemptPaths) })
+	if _, ok := h.exemptPaths.match(r.URL.Path); ok {
+		return false
+	}
+	return h.Exempt == nil || !h.Exempt(r)
+}
+
+// sameOrigin reports whether r's Origin header, or its Referer header
+// for HTTPS, names the origin scheme://host or a trusted one. Requests
+// over HTTP without either pass, as older browsers send neither.
+func (h *CSRFHandler) sameOrigin(r *Request, scheme, host string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		ref := r.Header.Get("Referer")
+		if ref == "" {
+			return scheme != "https"
+		}
+		u, err := url.Parse(ref)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+	if strings.EqualFold(origin, scheme+"://"+host) {
+		return true
+	}
+	for _, o := range h.TrustedOrigins {
+		if strings.EqualFold(origin, o) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *CSRFHandler) sentToken(r *Request) string {
+	name := h.HeaderName
+	if name == "" {
+		name = "X-CSRF-Token"
+	}
+	if t := r.Header.Get(name); t != "" {
+		return t
+	}
+	field := h.FieldName
+	if field == "" {
+		field = "csrf_token"
+	}
+	return r.PostFormValue(field)
+}
+
+func (h *CSRFHandler) cookieName() string {
+	if h.Cookie.Name == "" {
+		return "csrf_token"
+	}
+	return h.Cookie.Name
+}
+
+func (h *CSRFHandler) cookieCodec() *CookieCodec {
+	if h.Codec != nil {
+		return h.Codec
+	}
+	h.once.Do(func() {
+		h.codec = &CookieCodec{HashKeys: [][]byte{newCSRFToken()}}
+	})
+	return h.codec
+}
+
+// cookieToken returns the token of r's cookie, or nil if r has none,
+// or one not signed by the handler's codec.
+func (h *CSRFHandler) cookieToken(r *Request) []byte {
+	c, err := r.Cookie(h.cookieName())
+	if err != nil {
+		return nil
+	}
+	token, err := h.cookieCodec().Decode(c.Name, c.Value)
+	if err != nil || len(token) != csrfTokenLen {
+		return nil
+	}
+	return token
+}
+
+func (h *CSRFHandler) setCookie(w ResponseWriter, token []byte, secure bool) {
+	c := h.Cookie
+	c.Name = h.cookieName()
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if c.SameSite == SameSiteDefaultMode {
+		c.SameSite = SameSiteLaxMode
+	}
+	c.HttpOnly = true
+	c.Secure = c.Secure || secure
+	v, err := h.cookieCodec().Encode(c.Name, token)
+	if err != nil {
+		return
+	}
+	c.Value = v
+	SetCookie(w, &c)
+}
+
+func newCSRFToken() []byte {
+	b := make([]byte, csrfTokenLen)
+	if _, err := rand.Read(b); err != nil {
+		panic("http: can't generate CSRF token: " + err.Error())
+	}
+	return b
+}
+
+// maskCSRFToken returns token XORed with a random pad, after the pad,
+// in base64.
+func maskCSRFToken(token []byte) string {
+	b := make([]byte, 2*len(token))
+	pad := b[:len(token)]
+	if _, err := rand.Read(pad); err != nil {
+		panic("http: can't generate CSRF token: " + err.Error())
+	}
+	for i, c := range token {
+		b[len(token)+i] = c ^ pad[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// unmaskCSRFToken returns the token masked as s, or nil if s isn't one.
+// Tokens of the Token function may be of any length.
+func unmaskCSRFToken(s string) []byte {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 || len(b)%2 != 0 {
+		return nil
+	}
+	pad, token := b[:len(b)/2], b[len(b)/2:]
+	for i := range token {
+		token[i] ^= pad[i]
+	}
+	return token
+}
+
+// clientOrigin returns the scheme and host the client of r reached,
+// as reported by trusted proxies in forwarding headers or a PROXY
+// protocol header.
+func clientOrigin(r *Request, trusted []*net.IPNet) (scheme, host string) {
+	scheme, host = "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if l := r.ProxyLine; l != nil {
+		if ssl := l.TLV(ProxyTLVSSL); len(ssl) > 0 && ssl[0]&0x01 != 0 {
+			scheme = "https"
+		}
+	}
+	if !trustedPeer(r.RemoteAddr, trusted) {
+		return scheme, host
+	}
+	if f := r.Header.Get("Forwarded"); f != "" {
+		// The first element is from the proxy the client reached.
+		if i := strings.IndexByte(f, ','); i >= 0 {
+			f = f[:i]
+		}
+		for _, pair := range strings.Split(f, ";") {
+			k, v := pair, ""
+			if i := strings.IndexByte(pair, '='); i >= 0 {
+				k, v = pair[:i], strings.Trim(pair[i+1:], `"`)
+			}
+			switch strings.ToLower(strings.TrimSpace(k)) {
+			case "proto":
+				scheme = strings.ToLower(v)
+			case "host":
+				host = v
+			}
+		}
+		return scheme, host
+	}
+	if p := firstListElem(r.Header.Get("X-Forwarded-Proto")); p != "" {
+		scheme = strings.ToLower(p)
+	}
+	if h := firstListElem(r.Header.Get("X-Forwarded-Host")); h != "" {
+		host = h
+	}
+	return scheme, host
+}
+
+func firstListElem(v string) string {
+	if i := strings.IndexByte(v, ','); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v)
+}
+
+// trustedPeer reports whether the IP address of addr, a host:port, is
+// in one of nets.
+func trustedPeer(addr string, nets []*net.IPNet) bool {
+	if len(nets) == 0 {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ipInNets(ip, nets)
+}
@@ -0,0 +1,169 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"io"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// serveRecorded serves a request of method to h for path on
+// example.com, with the given headers, as "Name: value" lines, and form
+// body, if any, returning the response recorded.
+func serveRecorded(h Handler, method, path, body string, hdrs ...string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, _ := NewRequest(method, "http://example.com"+path, r)
+	req.RemoteAddr = "192.0.2.1:1234"
+	if body != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for _, kv := range hdrs {
+		i := strings.Index(kv, ": ")
+		req.Header.Add(kv[:i], kv[i+2:])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCSRFHandler(t *testing.T) {
+	var token string
+	h := &CSRFHandler{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			token = CSRFToken(r)
+			io.WriteString(w, "ok")
+		}),
+		ExemptPaths: []string{"/api/", "/hook"},
+	}
+
+	rec := serveRecorded(h, "GET", "/", "")
+	sc := rec.Header().Get("Set-Cookie")
+	if rec.Code != 200 || token == "" || !strings.HasPrefix(sc, "csrf_token=") ||
+		!strings.Contains(sc, "HttpOnly") || !strings.Contains(sc, "SameSite=Lax") || strings.Contains(sc, "Secure") {
+		t.Fatalf("GET: %d, token %q, Set-Cookie %q", rec.Code, token, sc)
+	}
+	cookie := "Cookie: " + strings.SplitN(sc, ";", 2)[0]
+	first := token
+	if serveRecorded(h, "GET", "/", "", cookie).Header().Get("Set-Cookie") != "" {
+		t.Error("GET with token cookie set another")
+	}
+	if token == first {
+		t.Error("CSRFToken returned the same masked token twice")
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		hdrs   []string
+		code   int
+	}{
+		{"no token", "POST", "/", "", []string{cookie}, 403},
+		{"no cookie", "POST", "/", "", []string{"X-CSRF-Token: " + token}, 403},
+		{"header", "POST", "/", "", []string{cookie, "X-CSRF-Token: " + token}, 200},
+		{"earlier token", "POST", "/", "", []string{cookie, "X-CSRF-Token: " + first}, 200},
+		{"field", "POST", "/", "csrf_token=" + url.QueryEscape(token), []string{cookie}, 200},
+		{"bad token", "POST", "/", "", []string{cookie, "X-CSRF-Token: " + token[:len(token)-2] + "AA"}, 403},
+		{"same origin", "DELETE", "/", "", []string{cookie, "X-CSRF-Token: " + token, "Origin: http://example.com"}, 200},
+		{"cross origin", "PUT", "/", "", []string{cookie, "X-CSRF-Token: " + token, "Origin: http://evil.example"}, 403},
+		{"cross referer", "POST", "/", "", []string{cookie, "X-CSRF-Token: " + token, "Referer: http://evil.example/page"}, 403},
+		{"forged Forwarded", "POST", "/", "", []string{cookie, "X-CSRF-Token: " + token, "Origin: https://example.com", "X-Forwarded-Proto: https"}, 403},
+		{"exempt subtree", "POST", "/api/items", "", nil, 200},
+		{"exempt path", "POST", "/hook", "", nil, 200},
+		{"not exempt", "POST", "/hook/x", "", nil, 403},
+	}
+	for _, tt := range tests {
+		if rec := serveRecorded(h, tt.method, tt.path, tt.body, tt.hdrs...); rec.Code != tt.code {
+			t.Errorf("%s: got %d %q; want %d", tt.name, rec.Code, rec.Body.String(), tt.code)
+		}
+	}
+}
+
+func TestCSRFHandlerProxies(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("192.0.2.0/24")
+	var token string
+	h := &CSRFHandler{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			token = CSRFToken(r)
+		}),
+		TrustedProxies: []*net.IPNet{proxies},
+		TrustedOrigins: []string{"https://app.example"},
+	}
+	rec := serveRecorded(h, "GET", "/", "", "X-Forwarded-Proto: https")
+	sc := rec.Header().Get("Set-Cookie")
+	if !strings.Contains(sc, "Secure") {
+		t.Errorf("Set-Cookie = %q; want Secure behind an HTTPS proxy", sc)
+	}
+	cookie := "Cookie: " + strings.SplitN(sc, ";", 2)[0]
+	auth := "X-CSRF-Token: " + token
+
+	tests := []struct {
+		name string
+		hdrs []string
+		code int
+	}{
+		{"X-Forwarded", []string{"X-Forwarded-Proto: https", "X-Forwarded-Host: www.example", "Origin: https://www.example"}, 200},
+		{"Forwarded", []string{`Forwarded: proto=https;host="www.example", proto=http`, "Origin: https://www.example"}, 200},
+		{"scheme mismatch", []string{"Origin: https://example.com"}, 403},
+		{"trusted origin", []string{"Origin: https://app.example"}, 200},
+		{"HTTPS without origin", []string{"X-Forwarded-Proto: https"}, 403},
+		{"HTTPS referer", []string{"X-Forwarded-Proto: https", "Referer: https://example.com/form"}, 200},
+	}
+	for _, tt := range tests {
+		if rec := serveRecorded(h, "POST", "/", "", append(tt.hdrs, cookie, auth)...); rec.Code != tt.code {
+			t.Errorf("%s: got %d %q; want %d", tt.name, rec.Code, rec.Body.String(), tt.code)
+		}
+	}
+}
+
+func TestCSRFHandlerToken(t *testing.T) {
+	sessions := map[string][]byte{"alice": []byte(strings.Repeat("s", 20))}
+	var token string
+	var failure error
+	h := &CSRFHandler{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			token = CSRFToken(r)
+		}),
+		Token: func(r *Request) []byte {
+			return sessions[r.Header.Get("X-User")]
+		},
+		FailureHandler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			failure = CSRFFailure(r)
+			w.WriteHeader(StatusTeapot)
+		}),
+	}
+	if rec := serveRecorded(h, "GET", "/", "", "X-User: alice"); rec.Header().Get("Set-Cookie") != "" || token == "" {
+		t.Fatalf("GET: Set-Cookie %q, token %q; want no cookie and a token", rec.Header().Get("Set-Cookie"), token)
+	}
+	if rec := serveRecorded(h, "POST", "/", "", "X-User: alice", "X-CSRF-Token: "+token); rec.Code != 200 {
+		t.Errorf("POST with token: %d; want 200", rec.Code)
+	}
+	if rec := serveRecorded(h, "POST", "/", "", "X-User: bob", "X-CSRF-Token: "+token); rec.Code != StatusTeapot || failure != ErrCSRFToken {
+		t.Errorf("POST of another session: %d, %v; want FailureHandler with ErrCSRFToken", rec.Code, failure)
+	}
+	if rec := serveRecorded(h, "POST", "/", "", "X-User: alice", "X-CSRF-Token: "+token, "Origin: http://evil.example"); failure != ErrCSRFOrigin {
+		t.Errorf("cross-origin POST: %d, %v; want ErrCSRFOrigin", rec.Code, failure)
+	}
+}
+
+func TestCSRFHandlerProxyLine(t *testing.T) {
+	h := &CSRFHandler{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {})}
+	req, _ := NewRequest("GET", "http://example.com/", nil)
+	req.ProxyLine = &ProxyLine{Version: 2, TLVs: []ProxyTLV{{Type: ProxyTLVSSL, Value: []byte{0x01, 0, 0, 0, 0}}}}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if sc := rec.Header().Get("Set-Cookie"); !strings.Contains(sc, "Secure") {
+		t.Errorf("Set-Cookie = %q; want Secure for a client on TLS reported by PROXY", sc)
+	}
+}
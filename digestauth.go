@@ -0,0 +1,288 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// HTTP Digest Access Authentication, RFC 7616.
+
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var digestUserKey = &contextKey{"digest-user"}
+
+// DefaultNonceLifetime is the NonceLifetime of DigestAuthHandlers that
+// don't set it.
+const DefaultNonceLifetime = 5 * time.Minute
+
+// DigestNonces issues and checks the nonces of a DigestAuthHandler,
+// such as to share them between servers. Implementations must be safe
+// for concurrent use by multiple goroutines.
+type DigestNonces interface {
+	// New returns a new nonce.
+	New() string
+
+	// Use reports whether nonce was issued and is still fresh, and
+	// count, the client's nonce count, wasn't used with it before,
+	// so that requests can't be replayed. It reports stale if the
+	// nonce was issued but expired, so that clients are asked to
+	// retry with a new one rather than for their credentials.
+	Use(nonce string, count uint64) (ok, stale bool)
+}
+
+// A DigestAuthHandler requires the requests of the handler it wraps to
+// be authenticated with HTTP Digest Access Authentication, with qop
+// "auth", answering others with 401 Unauthorized and a challenge.
+// DigestUsername returns the user of authenticated requests.
+type DigestAuthHandler struct {
+	// Handler is the handler protected.
+	Handler Handler
+
+	// Realm is the realm of the credentials, shown by browsers.
+	Realm string
+
+	// Credentials returns the hash of the user's credentials, as
+	// DigestHA1 computes with the handler's Algorithm and Realm, so
+	// that passwords needn't be kept, or false if there is no such
+	// user.
+	Credentials func(username string) (ha1 string, ok bool)
+
+	// Algorithm is "MD5", the default, which all clients support,
+	// or "SHA-256".
+	Algorithm string
+
+	// Nonces issues and checks nonces. If nil, nonces are signed
+	// and last NonceLifetime, or DefaultNonceLifetime if zero; the
+	// counts used with them are kept in memory.
+	Nonces        DigestNonces
+	NonceLifetime time.Duration
+
+	once   sync.Once
+	nonces DigestNonces
+}
+
+// DigestHA1 returns the hash of the credentials of a user for digest
+// authentication with the algorithm, "MD5" or "SHA-256", in a realm.
+func DigestHA1(algorithm, username, realm, password string) string {
+	return digestHash(algorithm, username+":"+realm+":"+password)
+}
+
+// DigestUsername returns the user of r, authenticated by a
+// DigestAuthHandler, or "".
+func DigestUsername(r *Request) string {
+	u, _ := r.Context().Value(digestUserKey).(string)
+	return u
+}
+
+func digestHash(algorithm, s string) string {
+	var h hash.Hash
+	if strings.EqualFold(algorithm, "SHA-256") {
+		h = sha256.New()
+	} else {
+		h = md5.New()
+	}
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (h *DigestAuthHandler) algorithm() string {
+	if h.Algorithm == "" {
+		return "MD5"
+	}
+	return h.Algorithm
+}
+
+func (h *DigestAuthHandler) digestNonces() DigestNonces {
+	if h.Nonces != nil {
+		return h.Nonces
+	}
+	h.once.Do(func() {
+		lifetime := h.NonceLifetime
+		if lifetime == 0 {
+			lifetime = DefaultNonceLifetime
+		}
+		m := &memoryNonces{lifetime: lifetime, nonces: make(map[string]*nonceState)}
+		if _, err := rand.Read(m.key[:]); err != nil {
+			panic("http: can't generate digest nonce key: " + err.Error())
+		}
+		h.nonces = m
+	})
+	return h.nonces
+}
+
+func (h *DigestAuthHandler) ServeHTTP(w ResponseWriter, r *Request) {
+	user, stale := h.authenticate(r)
+	if user == "" {
+		v := `Digest realm=` + strconv.Quote(h.Realm) + `, qop="auth", algorithm=` + h.algorithm() +
+			`, nonce="` + h.digestNonces().New() + `"`
+		if stale {
+			v += ", stale=true"
+		}
+		w.Header().Set("WWW-Authenticate", v)
+		Error(w, "Unauthorized", StatusUnauthorized)
+		return
+	}
+	h.Handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), digestUserKey, user)))
+}
+
+// authenticate returns the user of r, or "" if r isn't authenticated,
+// and whether for a stale nonce alone.
+func (h *DigestAuthHandler) authenticate(r *Request) (user string, stale bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Digest ") {
+		return "", false
+	}
+	p := parseAuthParams(auth[7:])
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	alg := p["algorithm"]
+	if alg == "" {
+		alg = "MD5"
+	}
+	if p["username"] == "" || p["realm"] != h.Realm || p["uri"] != uri || p["qop"] != "auth" ||
+		p["cnonce"] == "" || !strings.EqualFold(alg, h.algorithm()) {
+		return "", false
+	}
+	nc, err := strconv.ParseUint(p["nc"], 16, 64)
+	if err != nil {
+		return "", false
+	}
+	ha1, ok := h.Credentials(p["username"])
+	if !ok {
+		return "", false
+	}
+	ha2 := digestHash(alg, r.Method+":"+uri)
+	want := digestHash(alg, ha1+":"+p["nonce"]+":"+p["nc"]+":"+p["cnonce"]+":auth:"+ha2)
+	if subtle.ConstantTimeCompare([]byte(want), []byte(strings.ToLower(p["response"]))) != 1 {
+		return "", false
+	}
+	if ok, stale := h.digestNonces().Use(p["nonce"], nc); !ok {
+		return "", stale
+	}
+	return p["username"], false
+}
+
+// parseAuthParams parses the comma-separated name=value parameters of
+// an Authorization header, whose values may be quoted strings.
+func parseAuthParams(s string) map[string]string {
+	p := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		i := strings.IndexByte(s, '=')
+		if i < 0 {
+			return p
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = strings.TrimLeft(s[i+1:], " \t")
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var b []byte
+			j := 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b = append(b, s[j])
+			}
+			if j < len(s) {
+				j++ // closing quote
+			}
+			value, s = string(b), s[j:]
+		} else {
+			j := strings.IndexByte(s, ',')
+			if j < 0 {
+				j = len(s)
+			}
+			value, s = strings.TrimSpace(s[:j]), s[j:]
+		}
+		p[name] = value
+	}
+}
+
+// sweepNoncesInterval is how often memoryNonces drops expired nonces.
+const sweepNoncesInterval = time.Minute
+
+// memoryNonces is the DigestNonces of DigestAuthHandlers without one.
+// Its nonces are their time of issue and a random value, signed, so
+// that they can be checked without being kept; only those of
+// authenticated requests are, with the counts they were used with,
+// until they expire.
+type memoryNonces struct {
+	lifetime time.Duration
+	key      [32]byte
+
+	mu        sync.Mutex
+	nonces    map[string]*nonceState
+	lastSweep time.Time
+}
+
+type nonceState struct {
+	expires time.Time
+	used    map[uint64]bool // nonce counts
+}
+
+// nonceMAC returns the signature of the time of issue and random value
+// of a nonce.
+func (m *memoryNonces) nonceMAC(b []byte) []byte {
+	h := hmac.New(sha256.New, m.key[:])
+	h.Write(b)
+	return h.Sum(nil)[:16]
+}
+
+func (m *memoryNonces) New() string {
+	var b [32]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixNano()))
+	if _, err := rand.Read(b[8:16]); err != nil {
+		panic("http: can't generate digest nonce: " + err.Error())
+	}
+	copy(b[16:], m.nonceMAC(b[:16]))
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+func (m *memoryNonces) Use(nonce string, count uint64) (ok, stale bool) {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 32 || !hmac.Equal(b[16:], m.nonceMAC(b[:16])) {
+		return false, false
+	}
+	now := time.Now()
+	expires := time.Unix(0, int64(binary.BigEndian.Uint64(b[:8]))).Add(m.lifetime)
+	if now.After(expires) {
+		return false, true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.lastSweep) > sweepNoncesInterval {
+		for n, st := range m.nonces {
+			if now.After(st.expires) {
+				delete(m.nonces, n)
+			}
+		}
+		m.lastSweep = now
+	}
+	st := m.nonces[nonce]
+	if st == nil {
+		st = &nonceState{expires: expires, used: make(map[uint64]bool)}
+		m.nonces[nonce] = st
+	}
+	if st.used[count] {
+		return false, false
+	}
+	st.used[count] = true
+	return true, false
+}
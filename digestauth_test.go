@@ -0,0 +1,141 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	. "net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var wwwAuthParam = regexp.MustCompile(`(\w+)=("[^"]*"|[^, ]*)`)
+
+// digestAuthorize answers challenge, for the request of
+// method for uri, with the nonce count nc.
+func digestAuthorize(t *testing.T, challenge, user, password, method, uri string, nc int) string {
+	p := make(map[string]string)
+	for _, m := range wwwAuthParam.FindAllStringSubmatch(challenge, -1) {
+		p[m[1]] = strings.Trim(m[2], `"`)
+	}
+	var h func() hash.Hash = md5.New
+	if p["algorithm"] == "SHA-256" {
+		h = sha256.New
+	}
+	H := func(s string) string {
+		d := h()
+		io.WriteString(d, s)
+		return hex.EncodeToString(d.Sum(nil))
+	}
+	ha1 := H(user + ":" + p["realm"] + ":" + password)
+	ha2 := H(method + ":" + uri)
+	ncs := fmt.Sprintf("%08x", nc)
+	resp := H(ha1 + ":" + p["nonce"] + ":" + ncs + ":c0ffee:auth:" + ha2)
+	return fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", qop=auth, nc=%s, cnonce="c0ffee", response="%s", algorithm=%s`,
+		user, p["realm"], p["nonce"], uri, ncs, resp, p["algorithm"])
+}
+
+func TestDigestAuthHandler(t *testing.T) {
+	for _, alg := range []string{"", "SHA-256"} {
+		h := &DigestAuthHandler{
+			Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+				io.WriteString(w, "hello "+DigestUsername(r))
+			}),
+			Realm:     "tools@example.com",
+			Algorithm: alg,
+			Credentials: func(user string) (string, bool) {
+				if user != "alice" {
+					return "", false
+				}
+				return DigestHA1(alg, "alice", "tools@example.com", "s3cret"), true
+			},
+			NonceLifetime: 50 * time.Millisecond,
+		}
+		serve := func(auth string) *httptest.ResponseRecorder {
+			req, _ := NewRequest("GET", "http://example.com/admin?x=1", nil)
+			req.RequestURI = "/admin?x=1"
+			if auth != "" {
+				req.Header.Set("Authorization", auth)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			return rec
+		}
+
+		rec := serve("")
+		challenge := rec.Header().Get("Www-Authenticate")
+		if rec.Code != 401 || !strings.HasPrefix(challenge, `Digest realm="tools@example.com", qop="auth"`) {
+			t.Fatalf("%q: unauthenticated request: %d, challenge %q", alg, rec.Code, challenge)
+		}
+		auth := digestAuthorize(t, challenge, "alice", "s3cret", "GET", "/admin?x=1", 1)
+		if rec := serve(auth); rec.Code != 200 || rec.Body.String() != "hello alice" {
+			t.Errorf("%q: authenticated request: %d %q", alg, rec.Code, rec.Body.String())
+		}
+		if rec := serve(auth); rec.Code != 401 {
+			t.Errorf("%q: replayed request: %d; want 401", alg, rec.Code)
+		}
+		if rec := serve(digestAuthorize(t, challenge, "alice", "s3cret", "GET", "/admin?x=1", 2)); rec.Code != 200 {
+			t.Errorf("%q: next nonce count: %d; want 200", alg, rec.Code)
+		}
+		for _, bad := range []string{
+			digestAuthorize(t, challenge, "alice", "wrong", "GET", "/admin?x=1", 3),
+			digestAuthorize(t, challenge, "bob", "s3cret", "GET", "/admin?x=1", 4),
+			digestAuthorize(t, challenge, "alice", "s3cret", "GET", "/other", 5),
+			digestAuthorize(t, challenge, "alice", "s3cret", "POST", "/admin?x=1", 6),
+			digestAuthorize(t, strings.Replace(challenge, "nonce=\"", "nonce=\"x", 1), "alice", "s3cret", "GET", "/admin?x=1", 7),
+		} {
+			if rec := serve(bad); rec.Code != 401 || strings.Contains(rec.Header().Get("Www-Authenticate"), "stale") {
+				t.Errorf("%q: %s: %d, challenge %q; want 401 not stale", alg, bad, rec.Code, rec.Header().Get("Www-Authenticate"))
+			}
+		}
+		time.Sleep(60 * time.Millisecond)
+		rec = serve(digestAuthorize(t, challenge, "alice", "s3cret", "GET", "/admin?x=1", 8))
+		if rec.Code != 401 || !strings.HasSuffix(rec.Header().Get("Www-Authenticate"), "stale=true") {
+			t.Errorf("%q: expired nonce: %d, challenge %q; want 401 stale", alg, rec.Code, rec.Header().Get("Www-Authenticate"))
+		}
+	}
+}
+
+// Nonces are kept only once authenticated requests use them.
+func TestDigestAuthHandlerNonceMemory(t *testing.T) {
+	h := &DigestAuthHandler{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {}),
+		Realm:   "tools@example.com",
+		Credentials: func(user string) (string, bool) {
+			return DigestHA1("", "alice", "tools@example.com", "s3cret"), user == "alice"
+		},
+	}
+	serve := func(auth string) *httptest.ResponseRecorder {
+		req, _ := NewRequest("GET", "http://example.com/", nil)
+		req.RequestURI = "/"
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	var challenge string
+	for i := 0; i < 100; i++ {
+		challenge = serve("").Header().Get("Www-Authenticate")
+	}
+	if n := h.NumNoncesForTesting(); n != 0 {
+		t.Errorf("%d nonces kept for unauthenticated requests; want 0", n)
+	}
+	if rec := serve(digestAuthorize(t, challenge, "alice", "s3cret", "GET", "/", 1)); rec.Code != 200 {
+		t.Fatalf("authenticated request: %d", rec.Code)
+	}
+	if n := h.NumNoncesForTesting(); n != 1 {
+		t.Errorf("%d nonces kept; want 1", n)
+	}
+}
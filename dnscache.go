@@ -0,0 +1,229 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Caching of host name lookups.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// A Resolver looks up the addresses of the hosts a Transport dials.
+// A *net.Resolver is a Resolver.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// DefaultDNSCacheTTL is the default time a DNSCache keeps addresses.
+const DefaultDNSCacheTTL = time.Minute
+
+// DefaultDNSLookupTimeout is the default time a DNSCache waits for a
+// lookup by its Resolver.
+const DefaultDNSLookupTimeout = 30 * time.Second
+
+// A DNSCache is a Resolver caching the lookups of another. As the
+// system resolver doesn't report the TTLs of records, the addresses
+// of a host are kept for a fixed time. Concurrent lookups of a host
+// share a single lookup.
+//
+// A DNSCache must not be copied after first use.
+type DNSCache struct {
+	// Resolver does the lookups. If nil, net.DefaultResolver is
+	// used.
+	Resolver Resolver
+
+	// TTL is how long the addresses of a host are kept. If zero,
+	// DefaultDNSCacheTTL is used.
+	TTL time.Duration
+
+	// NegativeTTL, if non-zero, is how long the failure of a
+	// lookup for a host that wasn't found is kept. Other failures
+	// are never kept.
+	NegativeTTL time.Duration
+
+	// StaleTTL, if non-zero, is how long after the TTL addresses
+	// are still returned when their lookup fails for another
+	// cause than the host not being found, so that known hosts can
+	// be reached during an outage of the resolver.
+	StaleTTL time.Duration
+
+	// LookupTimeout is how long a lookup by the Resolver, shared by
+	// the lookups of a host, may take before it fails, even if the
+	// Resolver ignores its context. If zero,
+	// DefaultDNSLookupTimeout is used.
+	LookupTimeout time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*dnsEntry
+	calls     map[string]*dnsCall
+	nextSweep int
+	stats     DNSCacheStats
+}
+
+// DNSCacheStats counts the lookups of a DNSCache.
+type DNSCacheStats struct {
+	Hits         int64 // answered with cached or shared addresses
+	NegativeHits int64 // answered with a cached failure
+	StaleHits    int64 // answered with stale addresses
+	Misses       int64 // needing a lookup by the Resolver
+	Errors       int64 // failed lookups by the Resolver
+}
+
+// HitRate returns the fraction of lookups answered without a lookup
+// by the Resolver.
+func (s DNSCacheStats) HitRate() float64 {
+	hits := s.Hits + s.NegativeHits + s.StaleHits
+	if hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+s.Misses)
+}
+
+type dnsEntry struct {
+	ips     []net.IPAddr
+	err     error // a host not found, if non-nil
+	expires time.Time
+	dead    time.Time // when even stale ips aren't used
+}
+
+// dnsCall is a lookup in progress.
+type dnsCall struct {
+	done chan struct{} // closed when ips and err are set
+	ips  []net.IPAddr
+	err  error
+}
+
+// Stats returns the counts of the lookups of c so far.
+func (c *DNSCache) Stats() DNSCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// LookupIPAddr implements the Resolver interface.
+func (c *DNSCache) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	now := time.Now()
+	c.mu.Lock()
+	e := c.entries[host]
+	if e != nil && now.Before(e.expires) {
+		if e.err != nil {
+			c.stats.NegativeHits++
+		} else {
+			c.stats.Hits++
+		}
+		c.mu.Unlock()
+		return e.ips, e.err
+	}
+	call := c.calls[host]
+	if call != nil {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+		call = &dnsCall{done: make(chan struct{})}
+		if c.calls == nil {
+			c.calls = make(map[string]*dnsCall)
+		}
+		c.calls[host] = call
+		go c.lookup(host, call)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if call.err == nil || isNotFound(call.err) {
+		return call.ips, call.err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e != nil && e.err == nil && now.Before(e.dead) {
+		c.stats.StaleHits++
+		return e.ips, nil
+	}
+	return nil, call.err
+}
+
+// lookup looks up host for call, detached from the contexts of the
+// lookups waiting for it but within the LookupTimeout, and caches the
+// outcome.
+func (c *DNSCache) lookup(host string, call *dnsCall) {
+	r := c.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	timeout := c.LookupTimeout
+	if timeout == 0 {
+		timeout = DefaultDNSLookupTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	type result struct {
+		ips []net.IPAddr
+		err error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		ips, err := r.LookupIPAddr(ctx, host)
+		resc <- result{ips, err}
+	}()
+	select {
+	case res := <-resc:
+		call.ips, call.err = res.ips, res.err
+	case <-ctx.Done():
+		call.err = &net.DNSError{Err: "lookup timed out", Name: host, IsTimeout: true}
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.calls, host)
+	close(call.done)
+	switch {
+	case call.err == nil:
+		ttl := c.TTL
+		if ttl == 0 {
+			ttl = DefaultDNSCacheTTL
+		}
+		c.store(host, &dnsEntry{ips: call.ips, expires: now.Add(ttl), dead: now.Add(ttl + c.StaleTTL)}, now)
+	case isNotFound(call.err):
+		c.stats.Errors++
+		if c.NegativeTTL > 0 {
+			exp := now.Add(c.NegativeTTL)
+			c.store(host, &dnsEntry{err: call.err, expires: exp, dead: exp}, now)
+		} else {
+			delete(c.entries, host)
+		}
+	default:
+		c.stats.Errors++
+	}
+}
+
+// store caches e for host, first removing the dead entries whenever
+// the cache has doubled in size. The caller holds c.mu.
+func (c *DNSCache) store(host string, e *dnsEntry, now time.Time) {
+	if c.entries == nil {
+		c.entries = make(map[string]*dnsEntry)
+	}
+	if len(c.entries) >= c.nextSweep {
+		for h, old := range c.entries {
+			if !now.Before(old.dead) {
+				delete(c.entries, h)
+			}
+		}
+		c.nextSweep = 2*len(c.entries) + 64
+	}
+	c.entries[host] = e
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
@@ -0,0 +1,172 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResolver resolves every host to 127.0.0.1, or fails with err.
+type fakeResolver struct {
+	mu      sync.Mutex
+	err     error
+	lookups int
+	block   chan struct{} // if non-nil, lookups wait for it
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if r.block != nil {
+		<-r.block
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups++
+	if r.err != nil {
+		return nil, r.err
+	}
+	return ipAddrs("127.0.0.1"), nil
+}
+
+func (r *fakeResolver) set(err error) (lookups int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+	return r.lookups
+}
+
+func TestDNSCache(t *testing.T) {
+	ctx := context.Background()
+	r := &fakeResolver{}
+	c := &DNSCache{Resolver: r, TTL: 50 * time.Millisecond, StaleTTL: time.Hour}
+	for i := 0; i < 3; i++ {
+		if ips, err := c.LookupIPAddr(ctx, "host.test"); err != nil || len(ips) != 1 {
+			t.Fatalf("lookup %d: %v, %v", i, ips, err)
+		}
+	}
+	if n := r.set(nil); n != 1 {
+		t.Errorf("%d resolver lookups within the TTL; want 1", n)
+	}
+	time.Sleep(60 * time.Millisecond)
+	c.LookupIPAddr(ctx, "host.test")
+	if n := r.set(nil); n != 2 {
+		t.Errorf("%d resolver lookups after the TTL; want 2", n)
+	}
+
+	// During an outage, the expired addresses are still served.
+	time.Sleep(60 * time.Millisecond)
+	r.set(&net.DNSError{Err: "server misbehaving", Name: "host.test", IsTemporary: true})
+	if ips, err := c.LookupIPAddr(ctx, "host.test"); err != nil || len(ips) != 1 {
+		t.Errorf("stale lookup: %v, %v", ips, err)
+	}
+	if _, err := c.LookupIPAddr(ctx, "other.test"); err == nil {
+		t.Error("lookup of an unknown host during an outage succeeded")
+	}
+	want := DNSCacheStats{Hits: 2, StaleHits: 1, Misses: 4, Errors: 2}
+	if s := c.Stats(); s != want {
+		t.Errorf("stats = %+v; want %+v", s, want)
+	}
+	if got := want.HitRate(); got != 3.0/7 {
+		t.Errorf("HitRate = %v", got)
+	}
+}
+
+func TestDNSCacheNegative(t *testing.T) {
+	ctx := context.Background()
+	r := &fakeResolver{err: &net.DNSError{Err: "no such host", Name: "missing.test", IsNotFound: true}}
+	c := &DNSCache{Resolver: r, NegativeTTL: time.Hour}
+	for i := 0; i < 2; i++ {
+		if _, err := c.LookupIPAddr(ctx, "missing.test"); err == nil {
+			t.Fatalf("lookup %d succeeded", i)
+		}
+	}
+	if n := r.set(nil); n != 1 {
+		t.Errorf("%d resolver lookups; want 1", n)
+	}
+	if s := c.Stats(); s.NegativeHits != 1 {
+		t.Errorf("stats = %+v; want 1 negative hit", s)
+	}
+}
+
+func TestDNSCacheSharesLookups(t *testing.T) {
+	r := &fakeResolver{block: make(chan struct{})}
+	c := &DNSCache{Resolver: r}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.LookupIPAddr(context.Background(), "host.test"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	for c.Stats().Hits+c.Stats().Misses < 5 {
+		time.Sleep(time.Millisecond)
+	}
+	close(r.block)
+	wg.Wait()
+	if n := r.set(nil); n != 1 {
+		t.Errorf("%d resolver lookups; want 1", n)
+	}
+
+	// A canceled lookup doesn't wait.
+	r.block = make(chan struct{})
+	defer close(r.block)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.LookupIPAddr(ctx, "other.test"); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled lookup: %v", err)
+	}
+}
+
+// A lookup the Resolver never finishes fails after the LookupTimeout,
+// and later lookups of the host don't wait for it.
+func TestDNSCacheLookupTimeout(t *testing.T) {
+	r := &fakeResolver{block: make(chan struct{})}
+	defer close(r.block)
+	c := &DNSCache{Resolver: r, LookupTimeout: 20 * time.Millisecond}
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		_, err := c.LookupIPAddr(context.Background(), "host.test")
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsTimeout {
+			t.Fatalf("lookup %d: err = %v; want a timeout", i, err)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Fatalf("lookup %d took %v", i, d)
+		}
+	}
+	if s := c.Stats(); s.Misses != 2 || s.Errors != 2 {
+		t.Errorf("stats = %+v; want 2 misses and errors", s)
+	}
+}
+
+func TestTransportResolver(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	r := &fakeResolver{}
+	tr := &Transport{Resolver: &DNSCache{Resolver: r}, DisableKeepAlives: true}
+	c := &Client{Transport: tr}
+	for i := 0; i < 3; i++ {
+		res, err := c.Get("http://cached.test:" + port + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	if n := r.set(nil); n != 1 {
+		t.Errorf("%d resolver lookups for 3 connections; want 1", n)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestServerEarlyHints(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Add("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(StatusEarlyHints)
+		w.Header().Add("Link", "</script.js>; rel=preload; as=script")
+		w.WriteHeader(StatusEarlyHints)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(StatusOK)
+		fmt.Fprint(w, "done")
+	}))
+	defer ts.Close()
+
+	var hints []string
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			hints = append(hints, fmt.Sprintf("%d %s", code, strings.Join(header["Link"], ", ")))
+			return nil
+		},
+	}
+	req, _ := NewRequest("GET", ts.URL, nil)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	res, err := DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != StatusOK || string(b) != "done" {
+		t.Errorf("final response %d %q", res.StatusCode, b)
+	}
+	want := []string{
+		"103 </style.css>; rel=preload; as=style",
+		"103 </style.css>; rel=preload; as=style, </script.js>; rel=preload; as=script",
+	}
+	if fmt.Sprint(hints) != fmt.Sprint(want) {
+		t.Errorf("early hints:\n%q\nwant:\n%q", hints, want)
+	}
+	if n := len(res.Header["Link"]); n != 2 {
+		t.Errorf("final response has %d Link headers; want 2", n)
+	}
+
+	// HTTP/1.0 clients get no informational responses.
+	c, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "GET / HTTP/1.0\r\n\r\n")
+	res, err = ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != StatusOK {
+		t.Errorf("HTTP/1.0 client got status %d first; want 200", res.StatusCode)
+	}
+}
+
+func TestTransportInformationalResponses(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		n := 1
+		fmt.Sscan(r.URL.Query().Get("n"), &n)
+		for i := 0; i < n; i++ {
+			w.WriteHeader(StatusEarlyHints)
+		}
+		fmt.Fprint(w, "done")
+	}))
+	defer ts.Close()
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+
+	get := func(url string, trace *httptrace.ClientTrace) error {
+		req, _ := NewRequest("GET", url, nil)
+		if trace != nil {
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+		}
+		res, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		return nil
+	}
+
+	// Without a trace they are skipped, up to a limit.
+	if err := get(ts.URL+"/?n=5", nil); err != nil {
+		t.Errorf("5 informational responses: %v", err)
+	}
+	if err := get(ts.URL+"/?n=6", nil); err == nil {
+		t.Error("6 informational responses accepted")
+	}
+
+	// An error from the trace aborts the request.
+	stop := errors.New("stop")
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(int, textproto.MIMEHeader) error { return stop },
+	}
+	if err := get(ts.URL, trace); err == nil || !strings.Contains(err.Error(), "stop") {
+		t.Errorf("got error %v; want the trace's", err)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Rendering of the error responses the server generates itself.
+
+package http
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+var (
+	errNotFound      = errors.New("http: page not found")
+	errTooManyConns  = errors.New("http: too many connections")
+	errRateLimited   = errors.New("http: rate limit exceeded")
+	errRedirectHTTPS = errors.New("http: use HTTPS")
+	errExpectNoBody  = errors.New("http: Expect 100-continue without a request body")
+	errExpectation   = errors.New("http: unsupported expectation")
+	errAsteriskURI   = errors.New("http: request URI * cannot be routed")
+)
+
+// respondError replies to r with the error status code, rendered by
+// the ErrorResponder of the Server serving w if it has one. Otherwise
+// clients accepting JSON get a Problem detailed by text, and others
+// text by Error, both including r's request ID if it has one. If err
+// is nil, the ErrorResponder is given an error made of text. The
+// Server is found through any ResponseWriters wrapping w.
+func respondError(w ResponseWriter, r *Request, code int, text string, err error) {
+	if srv := serverOf(w); srv != nil && srv.ErrorResponder != nil {
+		if err == nil {
+			err = errors.New(text)
+		}
+		srv.ErrorResponder(w, r, code, err)
+		return
+	}
+	var id string
+	if r != nil {
+		id = RequestIDFromContext(r.Context())
+	}
+	if r != nil && acceptsJSON(r) {
+		p := &Problem{Detail: text}
+		if id != "" {
+			p.Extensions = map[string]interface{}{"request_id": id}
+		}
+		WriteProblem(w, code, p)
+		return
+	}
+	if id != "" {
+		text += "\nrequest ID: " + id
+	}
+	Error(w, text, code)
+}
+
+// serverOf returns the Server serving w, unwrapping middleware's
+// ResponseWriters, or nil if w is not served by a Server.
+func serverOf(w ResponseWriter) *Server {
+	for {
+		switch rw := w.(type) {
+		case *response:
+			return rw.conn.server
+		case interface{ Unwrap() ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// rejectRequest answers a request that could not be read because of
+// err. The connection is closed afterwards.
+func (c *conn) rejectRequest(err error) {
+	c.requestError(err)
+	code, text := StatusBadRequest, "Bad Request"
+	if err == errTooLarge {
+		code, text = StatusRequestEntityTooLarge, "Request Entity Too Large"
+	} else if re, ok := err.(*RequestError); ok && re.tooLarge() {
+		code, text = statusRequestHeaderFieldsTooLarge, StatusText(statusRequestHeaderFieldsTooLarge)+": "+re.detail()
+	}
+	switch {
+	case c.server.ErrorResponder != nil || code == statusRequestHeaderFieldsTooLarge:
+		c.server.writeErrorResponse(c.rwc, code, text, err)
+	case code == StatusRequestEntityTooLarge:
+		io.WriteString(c.rwc, "HTTP/1.1 413 Request Entity Too Large\r\n\r\n")
+	default:
+		io.WriteString(c.rwc, "HTTP/1.1 400 Bad Request\r\n\r\n")
+		return
+	}
+	// Their HTTP client may or may not be able to read this if
+	// we're responding to them and hanging up while they're still
+	// writing their request. Undefined behavior.
+	c.closeWriteAndWait()
+}
+
+// rejectProxyHeader answers 400 Bad Request on rwc, whose PROXY
+// protocol header could not be parsed because of err, before it is
+// closed.
+func (srv *Server) rejectProxyHeader(rwc net.Conn, err error) {
+	if fn := srv.OnRequestError; fn != nil {
+		fn(rwc.RemoteAddr().String(), err)
+	}
+	rwc.SetWriteDeadline(time.Now().Add(time.Second))
+	if srv.ErrorResponder != nil {
+		srv.writeErrorResponse(rwc, StatusBadRequest, "Bad Request", err)
+		return
+	}
+	io.WriteString(rwc, "HTTP/1.1 400 Bad Request\r\n\r\n")
+}
+
+// writeErrorResponse writes a complete response with the error status
+// code to w, a connection that is closed afterwards and from which no
+// request could be read. The response is rendered by srv's
+// ErrorResponder, given a nil Request, or else as text by Error.
+func (srv *Server) writeErrorResponse(w io.Writer, code int, text string, err error) {
+	ew := &errorResponseWriter{header: make(Header)}
+	if fn := srv.ErrorResponder; fn != nil {
+		fn(ew, nil, code, err)
+	} else {
+		Error(ew, text, code)
+	}
+	if ew.code == 0 {
+		ew.code = code
+	}
+	ew.header.Set("Content-Length", strconv.Itoa(ew.body.Len()))
+	ew.header.Set("Connection", "close")
+	bw := newBufioWriterSize(w, 4<<10)
+	defer putBufioWriter(bw)
+	bw.WriteString("HTTP/1.1 " + strconv.Itoa(ew.code) + " " + StatusText(ew.code) + "\r\n")
+	ew.header.Write(bw)
+	bw.WriteString("\r\n")
+	bw.Write(ew.body.Bytes())
+	bw.Flush()
+}
+
+// errorResponseWriter is the ResponseWriter given to ErrorResponder
+// for requests that could not be read. It buffers the response so
+// that writeErrorResponse can frame it.
+type errorResponseWriter struct {
+	header Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (w *errorResponseWriter) Header() Header { return w.header }
+
+func (w *errorResponseWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *errorResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(StatusOK)
+	return w.body.Write(p)
+}
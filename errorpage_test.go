@@ -0,0 +1,99 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerErrorResponder(t *testing.T) {
+	defer afterTest(t)
+	responder := func(w ResponseWriter, r *Request, code int, err error) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		fmt.Fprintf(w, `{"status":%d,"parsed":%v,"error":%q}`, code, r != nil, err)
+	}
+	mux := NewServeMux()
+	mux.HandleFunc("/ok", func(w ResponseWriter, r *Request) {})
+	mux.HandleFunc("/wrapped", func(w ResponseWriter, r *Request) {
+		NotFound(wrapWriter{w}, r)
+	})
+	mux.HandleFunc("/file", func(w ResponseWriter, r *Request) {
+		ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader("contents"))
+	})
+	srv := &Server{
+		Handler:        mux,
+		ErrorResponder: responder,
+		HeaderPolicy:   &HeaderPolicy{MaxHeaders: 2},
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go srv.Serve(ln)
+	proxied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxied.Close()
+	go srv.ServeWithOptions(proxied, ListenerOptions{WrapConn: ReadProxyHeader})
+
+	tests := []struct {
+		addr, req string
+		code      int
+		want      string
+		close     bool
+	}{
+		{ln.Addr().String(), "GET /missing HTTP/1.1\r\nHost: x\r\n\r\n", 404,
+			`{"status":404,"parsed":true,"error":"http: page not found"}`, false},
+		{ln.Addr().String(), "GET /wrapped HTTP/1.1\r\nHost: x\r\n\r\n", 404,
+			`{"status":404,"parsed":true,"error":"http: page not found"}`, false},
+		{ln.Addr().String(), "GET /file HTTP/1.1\r\nHost: x\r\nRange: bytes=20-\r\n\r\n", 416,
+			`{"status":416,"parsed":true,"error":"invalid range: failed to overlap"}`, false},
+		{ln.Addr().String(), "POST /ok HTTP/1.1\r\nHost: x\r\nExpect: 100-continue\r\n\r\n", 400,
+			`{"status":400,"parsed":true,"error":"http: Expect 100-continue without a request body"}`, true},
+		{ln.Addr().String(), "POST /ok HTTP/1.1\r\nHost: x\r\nExpect: a-pony\r\n\r\n", 417,
+			`{"status":417,"parsed":true,"error":"http: unsupported expectation"}`, true},
+		{ln.Addr().String(), "GET * HTTP/1.1\r\nHost: x\r\n\r\n", 400,
+			`{"status":400,"parsed":true,"error":"http: request URI * cannot be routed"}`, true},
+		{ln.Addr().String(), "GARBAGE\r\n\r\n", 400,
+			`{"status":400,"parsed":false,"error":"malformed HTTP request \"GARBAGE\""}`, true},
+		{ln.Addr().String(), "GET / HTTP/1.1\r\nHost: x\r\nA: 1\r\nB: 2\r\n\r\n", 431,
+			`{"status":431,"parsed":false,"error":"http: too many header fields"}`, true},
+		{proxied.Addr().String(), "PROXY BOGUS\r\nGET /ok HTTP/1.1\r\nHost: x\r\n\r\n", 400,
+			`{"status":400,"parsed":false,"error":"http: malformed PROXY protocol header"}`, true},
+	}
+	for _, tt := range tests {
+		c, err := net.Dial("tcp", tt.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprint(c, tt.req)
+		res, err := ReadResponse(bufio.NewReader(c), nil)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.req, err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		c.Close()
+		if string(body) != tt.want || res.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%q: got %s (%s); want %s", tt.req, body, res.Header.Get("Content-Type"), tt.want)
+		}
+		if res.StatusCode != tt.code {
+			t.Errorf("%q: status %d; want %d", tt.req, res.StatusCode, tt.code)
+		}
+		if res.Close != tt.close {
+			t.Errorf("%q: Close = %v; want %v", tt.req, res.Close, tt.close)
+		}
+	}
+}
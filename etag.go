@@ -0,0 +1,225 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// ETag generation and conditional requests.
+
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+// DefaultETagMaxBufferBytes is the MaxBufferBytes of ETagHandlers that
+// don't set it.
+const DefaultETagMaxBufferBytes = 1 << 20
+
+// An ETagHandler answers the conditional GET and HEAD requests of the
+// handler it wraps, for handlers that don't themselves, with 304 Not
+// Modified when the client's copy of the response, named by its
+// If-None-Match or If-Modified-Since header, is current.
+//
+// The 200 OK responses to GET requests without an ETag header of their
+// own are buffered, up to MaxBufferBytes, to be given one from a hash
+// of their body. Responses streamed with Flush or larger than that are
+// sent as they are written, without an ETag. Responses with an ETag or
+// Last-Modified header of their own, and to HEAD requests, are never
+// buffered: the request is checked against those headers as the
+// response's header is written, and the body discarded if it is
+// answered with 304 Not Modified.
+type ETagHandler struct {
+	// Handler is the handler wrapped.
+	Handler Handler
+
+	// Weak makes the ETags generated weak, as "W/" marks, for
+	// handlers whose responses may differ in bytes but not in
+	// meaning, such as by the order of their JSON object members.
+	// Weak ETags can't validate range requests.
+	Weak bool
+
+	// MaxBufferBytes is the most bytes of a response buffered to
+	// generate its ETag. If zero, DefaultETagMaxBufferBytes is used.
+	MaxBufferBytes int64
+}
+
+func (h *ETagHandler) maxBufferBytes() int64 {
+	if h.MaxBufferBytes <= 0 {
+		return DefaultETagMaxBufferBytes
+	}
+	return h.MaxBufferBytes
+}
+
+func (h *ETagHandler) ServeHTTP(w ResponseWriter, r *Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+	ew := &etagWriter{ResponseWriter: w, h: h, r: r}
+	h.Handler.ServeHTTP(ew, r)
+	ew.finish()
+}
+
+// etagState is where an etagWriter is in writing a response.
+type etagState int
+
+const (
+	etagIdle      etagState = iota // no header written
+	etagBuffering                  // status 200 written, body buffered
+	etagPassing                    // header written, body passed on
+	etagDiscard                    // 304 written, body discarded
+)
+
+// An etagWriter is the ResponseWriter an ETagHandler gives its handler.
+type etagWriter struct {
+	ResponseWriter
+	h *ETagHandler
+	r *Request
+
+	state etagState
+	buf   bytes.Buffer
+}
+
+func (ew *etagWriter) WriteHeader(code int) {
+	if ew.state != etagIdle {
+		return
+	}
+	hdr := ew.ResponseWriter.Header()
+	switch {
+	case code != StatusOK:
+		ew.pass(code)
+	case hdr.get("Etag") != "" || hdr.get("Last-Modified") != "" || ew.r.Method == "HEAD":
+		if notModified(ew.r, hdr) {
+			ew.writeNotModified()
+		} else {
+			ew.pass(code)
+		}
+	default:
+		ew.state = etagBuffering
+	}
+}
+
+func (ew *etagWriter) Write(p []byte) (int, error) {
+	if ew.state == etagIdle {
+		if ew.Header().get("Content-Type") == "" {
+			ew.Header().Set("Content-Type", DetectContentType(p))
+		}
+		ew.WriteHeader(StatusOK)
+	}
+	switch ew.state {
+	case etagDiscard:
+		return len(p), nil
+	case etagBuffering:
+		if int64(ew.buf.Len()+len(p)) <= ew.h.maxBufferBytes() {
+			return ew.buf.Write(p)
+		}
+		ew.flushBuffer()
+	}
+	return ew.ResponseWriter.Write(p)
+}
+
+// Flush sends the response written so far, without an ETag if it was
+// being buffered for one.
+func (ew *etagWriter) Flush() {
+	if ew.state == etagIdle {
+		ew.WriteHeader(StatusOK)
+	}
+	if ew.state == etagBuffering {
+		ew.flushBuffer()
+	}
+	if ew.state == etagPassing {
+		if f, ok := ew.ResponseWriter.(Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// Unwrap returns the ResponseWriter wrapped, for ResponseController.
+func (ew *etagWriter) Unwrap() ResponseWriter {
+	return ew.ResponseWriter
+}
+
+func (ew *etagWriter) pass(code int) {
+	ew.state = etagPassing
+	ew.ResponseWriter.WriteHeader(code)
+}
+
+// flushBuffer gives up on generating an ETag, sending the header and
+// the body buffered.
+func (ew *etagWriter) flushBuffer() {
+	ew.pass(StatusOK)
+	if ew.buf.Len() > 0 {
+		ew.ResponseWriter.Write(ew.buf.Bytes())
+	}
+	ew.buf = bytes.Buffer{}
+}
+
+func (ew *etagWriter) writeNotModified() {
+	ew.state = etagDiscard
+	h := ew.ResponseWriter.Header()
+	delete(h, "Content-Type")
+	delete(h, "Content-Length")
+	ew.ResponseWriter.WriteHeader(StatusNotModified)
+}
+
+// finish completes the response once the handler has returned,
+// generating the ETag of a buffered response.
+func (ew *etagWriter) finish() {
+	if ew.state == etagIdle {
+		ew.WriteHeader(StatusOK)
+	}
+	if ew.state != etagBuffering {
+		return
+	}
+	hdr := ew.ResponseWriter.Header()
+	hdr.Set("Etag", ew.h.etag(ew.buf.Bytes()))
+	if notModified(ew.r, hdr) {
+		ew.writeNotModified()
+		return
+	}
+	if hdr.get("Content-Encoding") == "" {
+		hdr.Set("Content-Length", strconv.Itoa(ew.buf.Len()))
+	}
+	ew.pass(StatusOK)
+	ew.ResponseWriter.Write(ew.buf.Bytes())
+}
+
+// etag returns the ETag of a response with body b.
+func (h *ETagHandler) etag(b []byte) string {
+	sum := sha256.Sum256(b)
+	tag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+	if h.Weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+// notModified reports whether r's conditions find that the client's
+// copy of the response with header h is current, as RFC 9110, Section
+// 13.2.2 orders them: If-None-Match, with the weak comparison, or
+// otherwise If-Modified-Since.
+func notModified(r *Request, h Header) bool {
+	if inm := r.Header.get("If-None-Match"); inm != "" {
+		etag := h.get("Etag")
+		if etag == "" {
+			return false
+		}
+		for _, v := range r.Header["If-None-Match"] {
+			for _, t := range strings.Split(v, ",") {
+				if t = strings.TrimSpace(t); t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	ims, err := ParseTime(r.Header.get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lm, err := ParseTime(h.get("Last-Modified"))
+	return err == nil && !lm.After(ims)
+}
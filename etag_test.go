@@ -0,0 +1,94 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	. "net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestETagHandler(t *testing.T) {
+	modtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	calls := 0
+	h := &ETagHandler{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			calls++
+			switch r.URL.Path {
+			case "/own":
+				w.Header().Set("ETag", `"v1"`)
+			case "/modified":
+				w.Header().Set("Last-Modified", modtime.Format(TimeFormat))
+			case "/missing":
+				NotFound(w, r)
+				return
+			case "/big":
+				w.Write([]byte(strings.Repeat("x", 100)))
+				return
+			case "/stream":
+				w.Write([]byte("part"))
+				w.(Flusher).Flush()
+			}
+			w.Write([]byte("hello, "))
+			w.Write([]byte("world"))
+		}),
+		MaxBufferBytes: 50,
+	}
+
+	rec := serveRecorded(h, "GET", "/", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != 200 || !strings.HasPrefix(etag, `"`) || rec.Body.String() != "hello, world" ||
+		rec.Header().Get("Content-Length") != "12" || rec.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Fatalf("GET: %d, ETag %q, header %v, body %q", rec.Code, etag, rec.Header(), rec.Body.String())
+	}
+	if again := serveRecorded(h, "GET", "/", "").Header().Get("ETag"); again != etag {
+		t.Errorf("ETag of the same response = %q, then %q", etag, again)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		hdrs   []string
+		code   int
+		body   string
+	}{
+		{"match", "GET", "/", []string{"If-None-Match: " + etag}, 304, ""},
+		{"weak match", "GET", "/", []string{"If-None-Match: W/" + etag}, 304, ""},
+		{"list", "GET", "/", []string{`If-None-Match: "a", ` + etag}, 304, ""},
+		{"star", "GET", "/", []string{"If-None-Match: *"}, 304, ""},
+		{"mismatch", "GET", "/", []string{`If-None-Match: "other"`}, 200, "hello, world"},
+		{"own ETag", "GET", "/own", []string{`If-None-Match: "v1"`}, 304, ""},
+		{"own ETag mismatch", "GET", "/own", []string{`If-None-Match: "v0"`}, 200, "hello, world"},
+		{"HEAD own ETag", "HEAD", "/own", []string{`If-None-Match: "v1"`}, 304, ""},
+		{"not modified", "GET", "/modified", []string{"If-Modified-Since: " + modtime.Format(TimeFormat)}, 304, ""},
+		{"modified", "GET", "/modified", []string{"If-Modified-Since: " + modtime.Add(-time.Hour).Format(TimeFormat)}, 200, "hello, world"},
+		{"If-None-Match first", "GET", "/modified", []string{`If-None-Match: "x"`, "If-Modified-Since: " + modtime.Format(TimeFormat)}, 200, "hello, world"},
+		{"error", "GET", "/missing", []string{"If-None-Match: *"}, 404, "404 page not found\n"},
+		{"POST", "POST", "/", []string{"If-None-Match: *"}, 200, "hello, world"},
+	}
+	for _, tt := range tests {
+		rec := serveRecorded(h, tt.method, tt.path, "", tt.hdrs...)
+		if rec.Code != tt.code || rec.Body.String() != tt.body {
+			t.Errorf("%s: %d %q; want %d %q", tt.name, rec.Code, rec.Body.String(), tt.code, tt.body)
+		}
+		if rec.Code == 304 && rec.Header().Get("Content-Type") != "" {
+			t.Errorf("%s: 304 with Content-Type %q", tt.name, rec.Header().Get("Content-Type"))
+		}
+	}
+
+	for _, path := range []string{"/big", "/stream"} {
+		rec := serveRecorded(h, "GET", path, "")
+		if rec.Code != 200 || rec.Header().Get("ETag") != "" || rec.Body.Len() == 0 {
+			t.Errorf("GET %s: %d, ETag %q, %d body bytes; want 200 without ETag", path, rec.Code, rec.Header().Get("ETag"), rec.Body.Len())
+		}
+	}
+
+	weak := &ETagHandler{Handler: h.Handler, Weak: true}
+	if got := serveRecorded(weak, "GET", "/", "").Header().Get("ETag"); got != "W/"+etag {
+		t.Errorf("weak ETag = %q; want W/%s", got, etag)
+	}
+}
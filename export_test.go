@@ -8,6 +8,7 @@
 package http
 
 import (
+	"context"
 	"net"
 	"time"
 )
@@ -16,7 +17,12 @@ func NewLoggingConn(baseName string, c net.Conn) net.Conn {
 	return newLoggingConn(baseName, c)
 }
 
-var ExportAppendTime = appendTime
+var (
+	ExportAppendTime = appendTime
+	ExportServerDate = serverDate
+
+	ExportRingAvailable = ringAvailable
+)
 
 func (t *Transport) NumPendingRequestsForTesting() int {
 	t.reqMu.Lock()
@@ -64,3 +70,29 @@ func NewTestTimeoutHandler(handler Handler, ch <-chan time.Time) Handler {
 }
 
 var DefaultUserAgent = defaultUserAgent
+
+// SetUpgradeCommandForTesting makes Server.Upgrade start the test
+// binary with args instead of rerunning the current command line.
+func SetUpgradeCommandForTesting(args ...string) (restore func()) {
+	old := upgradeCommand
+	upgradeCommand = func() (string, []string, error) {
+		path, _, err := old()
+		return path, append([]string{path}, args...), err
+	}
+	return func() { upgradeCommand = old }
+}
+
+// SetHappyEyeballsHooksForTesting makes Transports without a dial
+// function resolve host names with lookup and dial addresses with dial.
+func SetHappyEyeballsHooksForTesting(lookup func(context.Context, string) ([]net.IPAddr, error), dial func(context.Context, string, string) (net.Conn, error)) (restore func()) {
+	oldLookup, oldDial := lookupIPAddr, dialAddr
+	lookupIPAddr, dialAddr = lookup, dial
+	return func() { lookupIPAddr, dialAddr = oldLookup, oldDial }
+}
+
+func (h *DigestAuthHandler) NumNoncesForTesting() int {
+	m := h.digestNonces().(*memoryNonces)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.nonces)
+}
@@ -15,6 +15,7 @@ import (
 	"net/http"
 	"net/http/cgi"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -244,6 +245,7 @@ func (c *child) serveRequest(req *request, body io.ReadCloser) {
 		c.conn.writeRecord(typeStderr, req.reqId, []byte(err.Error()))
 	} else {
 		httpReq.Body = body
+		setRemote(httpReq, req.params)
 		c.handler.ServeHTTP(r, httpReq)
 	}
 	r.Close()
@@ -264,9 +266,50 @@ func (c *child) serveRequest(req *request, body io.ReadCloser) {
 	}
 }
 
+// setRemote sets the RemoteAddr and ProxyLine of r from the params
+// of its FastCGI request.
+//
+// RemoteAddr is the client's REMOTE_ADDR and REMOTE_PORT, as set by
+// cgi.RequestFromMap. If the web server's listener read the PROXY
+// protocol header of the client's connection, as nginx's does with
+// proxy_protocol, and the web server passes what it reported as the
+// params PROXY_PROTOCOL_ADDR and PROXY_PROTOCOL_PORT, with,
+// optionally, PROXY_PROTOCOL_SERVER_ADDR, PROXY_PROTOCOL_SERVER_PORT
+// and PROXY_PROTOCOL_VERSION, RemoteAddr is the header's source
+// address instead and ProxyLine the header, as for an http.Server
+// listener reading it with http.ReadProxyHeader.
+func setRemote(r *http.Request, params map[string]string) {
+	src := paramAddr(params, "PROXY_PROTOCOL_ADDR", "PROXY_PROTOCOL_PORT")
+	if src == nil {
+		return
+	}
+	line := &http.ProxyLine{Version: 1, Source: src}
+	if v := params["PROXY_PROTOCOL_VERSION"]; v == "2" {
+		line.Version = 2
+	}
+	if dst := paramAddr(params, "PROXY_PROTOCOL_SERVER_ADDR", "PROXY_PROTOCOL_SERVER_PORT"); dst != nil {
+		line.Destination = dst
+	}
+	r.RemoteAddr = src.String()
+	r.ProxyLine = line
+}
+
+// paramAddr returns the TCP address of the IP address and port in the
+// params addrKey and portKey, or nil if there is none.
+func paramAddr(params map[string]string, addrKey, portKey string) *net.TCPAddr {
+	ip := net.ParseIP(params[addrKey])
+	if ip == nil {
+		return nil
+	}
+	port, _ := strconv.Atoi(params[portKey])
+	return &net.TCPAddr{IP: ip, Port: port}
+}
+
 // Serve accepts incoming FastCGI connections on the listener l, creating a new
 // goroutine for each. The goroutine reads requests and then calls handler
-// to reply to them.
+// to reply to them. The requests report the client's address as the
+// web server passed it, including what it read from the PROXY protocol
+// header of the client's connection, if any; see Request.ProxyLine.
 // If l is nil, Serve accepts connections from os.Stdin.
 // If handler is nil, http.DefaultServeMux is used.
 func Serve(l net.Listener, handler http.Handler) error {
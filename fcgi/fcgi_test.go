@@ -7,7 +7,9 @@ package fcgi
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
+	"net/http/cgi"
 	"testing"
 )
 
@@ -148,3 +150,57 @@ func TestGetValues(t *testing.T) {
 		t.Errorf(" got: %q\nwant: %q\n", got, want)
 	}
 }
+
+func TestSetRemote(t *testing.T) {
+	base := map[string]string{
+		"REQUEST_METHOD":  "GET",
+		"SERVER_PROTOCOL": "HTTP/1.1",
+		"REQUEST_URI":     "/",
+		"REMOTE_ADDR":     "10.0.0.5",
+		"REMOTE_PORT":     "40000",
+	}
+	tests := []struct {
+		params     map[string]string
+		wantRemote string
+		wantLine   string // Source Destination Version, or "" for none
+	}{
+		{nil, "10.0.0.5:40000", ""},
+		{map[string]string{
+			"PROXY_PROTOCOL_ADDR": "192.0.2.1",
+			"PROXY_PROTOCOL_PORT": "1234",
+		}, "192.0.2.1:1234", "192.0.2.1:1234 <nil> 1"},
+		{map[string]string{
+			"PROXY_PROTOCOL_ADDR":        "2001:db8::1",
+			"PROXY_PROTOCOL_PORT":        "1234",
+			"PROXY_PROTOCOL_SERVER_ADDR": "2001:db8::2",
+			"PROXY_PROTOCOL_SERVER_PORT": "443",
+			"PROXY_PROTOCOL_VERSION":     "2",
+		}, "[2001:db8::1]:1234", "[2001:db8::1]:1234 [2001:db8::2]:443 2"},
+		// nginx passes empty values for connections without a header.
+		{map[string]string{"PROXY_PROTOCOL_ADDR": ""}, "10.0.0.5:40000", ""},
+	}
+	for i, tt := range tests {
+		params := make(map[string]string)
+		for k, v := range base {
+			params[k] = v
+		}
+		for k, v := range tt.params {
+			params[k] = v
+		}
+		r, err := cgi.RequestFromMap(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setRemote(r, params)
+		if r.RemoteAddr != tt.wantRemote {
+			t.Errorf("%d: RemoteAddr = %q; want %q", i, r.RemoteAddr, tt.wantRemote)
+		}
+		var line string
+		if l := r.ProxyLine; l != nil {
+			line = fmt.Sprintf("%v %v %d", l.Source, l.Destination, l.Version)
+		}
+		if line != tt.wantLine {
+			t.Errorf("%d: ProxyLine = %q; want %q", i, line, tt.wantLine)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Limits on the forms parsed by ParseForm and ParseMultipartForm.
+
+package http
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/url"
+	"strings"
+)
+
+// A FormPolicy limits the forms of a Server's requests parsed by
+// ParseForm, ParseMultipartForm and FormValue, which otherwise take any
+// number of fields of any length, up to 10 MB of URL-encoded body.
+type FormPolicy struct {
+	// MaxKeys, if positive, is the number of fields of a request's
+	// form, from its URL query and body together, beyond which
+	// parsing fails.
+	MaxKeys int
+
+	// MaxKeyBytes and MaxValueBytes, if positive, are the lengths
+	// of a field's name and value, once decoded, beyond which
+	// parsing fails.
+	MaxKeyBytes   int
+	MaxValueBytes int
+
+	// MaxBytes, if positive, is the size of a form's body beyond
+	// which parsing fails, in place of 10 MB for URL-encoded bodies
+	// and in all for multipart ones, files included.
+	MaxBytes int64
+
+	// Strict makes parsing fail on malformed fields of URL queries
+	// and URL-encoded bodies, such as those with invalid escapes or
+	// semicolons, which are otherwise skipped.
+	Strict bool
+}
+
+// A FormError is returned by ParseForm and ParseMultipartForm for a
+// form past the limits of its Server's FormPolicy, or malformed under
+// its Strict. The values of the form parsed are then discarded.
+//
+// The Server doesn't answer such requests itself: handlers should,
+// with the StatusCode of the error. FormValue and PostFormValue drop
+// the error, returning empty values, so handlers of forms under a
+// policy should call ParseForm or ParseMultipartForm first.
+type FormError struct {
+	StatusCode int    // StatusRequestEntityTooLarge or StatusBadRequest
+	Reason     string // such as "too many keys"
+}
+
+func (e *FormError) Error() string { return "http: form: " + e.Reason }
+
+func formTooLarge(reason string) *FormError {
+	return &FormError{StatusCode: StatusRequestEntityTooLarge, Reason: reason}
+}
+
+// parseQuery parses query as url.ParseQuery does, under p, with max
+// fields at most if p.MaxKeys is positive.
+func (p *FormPolicy) parseQuery(query string, max int) (url.Values, error) {
+	m := make(url.Values)
+	var err error
+	n := 0
+	for query != "" {
+		key := query
+		if i := strings.IndexByte(key, '&'); i >= 0 {
+			key, query = key[:i], key[i+1:]
+		} else {
+			query = ""
+		}
+		if strings.IndexByte(key, ';') >= 0 {
+			if p.Strict {
+				return nil, &FormError{StatusCode: StatusBadRequest, Reason: "semicolon in field"}
+			}
+			if err == nil {
+				err = errors.New("invalid semicolon separator in query")
+			}
+			continue
+		}
+		if key == "" {
+			continue
+		}
+		if n++; p.MaxKeys > 0 && n > max {
+			return nil, formTooLarge("too many keys")
+		}
+		value := ""
+		if i := strings.IndexByte(key, '='); i >= 0 {
+			key, value = key[:i], key[i+1:]
+		}
+		key1, err1 := url.QueryUnescape(key)
+		if err1 == nil {
+			value, err1 = url.QueryUnescape(value)
+		}
+		if err1 != nil {
+			if p.Strict {
+				return nil, &FormError{StatusCode: StatusBadRequest, Reason: "invalid escape in field"}
+			}
+			if err == nil {
+				err = err1
+			}
+			continue
+		}
+		if e := p.checkField(key1, value); e != nil {
+			return nil, e
+		}
+		m[key1] = append(m[key1], value)
+	}
+	return m, err
+}
+
+func (p *FormPolicy) checkField(key, value string) *FormError {
+	if p.MaxKeyBytes > 0 && len(key) > p.MaxKeyBytes {
+		return formTooLarge("key too long")
+	}
+	if p.MaxValueBytes > 0 && len(value) > p.MaxValueBytes {
+		return formTooLarge("value too long")
+	}
+	return nil
+}
+
+// limitsFields reports whether p limits the fields of forms, which
+// are then checked part by part in multipart bodies.
+func (p *FormPolicy) limitsFields() bool {
+	return p.MaxKeys > 0 || p.MaxKeyBytes > 0 || p.MaxValueBytes > 0
+}
+
+// readForm reads the multipart form of mr as ReadForm does, checking
+// each part against p as it comes, after n fields of the URL query and
+// body, so that a form past p's limits on fields fails at the part
+// that is, rather than once read whole.
+func (p *FormPolicy) readForm(mr *multipart.Reader, maxMemory int64, n int) (*multipart.Form, error) {
+	f := &multipart.Form{Value: make(map[string][]string), File: make(map[string][]*multipart.FileHeader)}
+	fail := func(err error) (*multipart.Form, error) {
+		f.RemoveAll()
+		return nil, err
+	}
+	maxValueBytes := maxMemory + 10<<20 // as ReadForm allows
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return f, nil
+		}
+		if err != nil {
+			return fail(err)
+		}
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+		if n++; p.MaxKeys > 0 && n > p.MaxKeys {
+			return fail(formTooLarge("too many keys"))
+		}
+		if e := p.checkField(name, ""); e != nil {
+			return fail(e)
+		}
+		if part.FileName() == "" {
+			max := maxValueBytes
+			if p.MaxValueBytes > 0 && int64(p.MaxValueBytes) < max {
+				max = int64(p.MaxValueBytes)
+			}
+			var b bytes.Buffer
+			m, err := io.CopyN(&b, part, max+1)
+			if err != nil && err != io.EOF {
+				return fail(err)
+			}
+			if m > max {
+				if max < maxValueBytes {
+					return fail(formTooLarge("value too long"))
+				}
+				return fail(multipart.ErrMessageTooLarge)
+			}
+			maxValueBytes -= m
+			f.Value[name] = append(f.Value[name], b.String())
+			continue
+		}
+		fh, err := readFormFile(part, maxMemory)
+		if err != nil {
+			return fail(err)
+		}
+		f.File[name] = append(f.File[name], fh)
+		if maxMemory -= fh.Size; maxMemory < 0 {
+			maxMemory = 0
+		}
+	}
+}
+
+// readFormFile stores the file of part, in memory if it fits within
+// maxMemory or else in a temporary file. Only ReadForm makes the
+// FileHeaders of multipart.Forms, so part, read as it arrives, is
+// passed to it as the only part of a form of its own.
+func readFormFile(part *multipart.Part, maxMemory int64) (*multipart.FileHeader, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if _, err := mw.CreatePart(part.Header); err != nil {
+		return nil, err
+	}
+	head := append([]byte(nil), buf.Bytes()...)
+	buf.Reset()
+	mw.Close() // the closing boundary, into buf
+	body := io.MultiReader(bytes.NewReader(head), part, &buf)
+	f, err := multipart.NewReader(body, mw.Boundary()).ReadForm(maxMemory)
+	if err != nil {
+		return nil, err
+	}
+	fhs := f.File[part.FormName()]
+	if len(fhs) != 1 {
+		f.RemoveAll()
+		return nil, errors.New("http: multipart file part not read as a file")
+	}
+	return fhs[0], nil
+}
+
+// parseQuery parses query, of r's URL or URL-encoded body, under r's
+// FormPolicy, if any, the fields in r.PostForm counting against its
+// MaxKeys.
+func (r *Request) parseQuery(query string) (url.Values, error) {
+	p := r.formPolicy()
+	if p == nil {
+		return url.ParseQuery(query)
+	}
+	max := p.MaxKeys
+	for _, vs := range r.PostForm {
+		max -= len(vs)
+	}
+	return p.parseQuery(query, max)
+}
+
+func (r *Request) formPolicy() *FormPolicy {
+	if r.reuse == nil || r.reuse.srv == nil {
+		return nil
+	}
+	return r.reuse.srv.FormPolicy
+}
+
+// A formBodyReader reads a multipart body up to FormPolicy.MaxBytes.
+type formBodyReader struct {
+	r        io.Reader
+	n        int64 // bytes left of MaxBytes
+	exceeded bool
+}
+
+func (l *formBodyReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, formTooLarge("body too large")
+	}
+	// Read one byte past the limit to tell whether there is more.
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.n {
+		l.exceeded = true
+		return int(l.n), formTooLarge("body too large")
+	}
+	l.n -= int64(n)
+	return n, err
+}
@@ -0,0 +1,220 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	. "net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerFormPolicy(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		err := r.ParseMultipartForm(1 << 20)
+		if fe, ok := err.(*FormError); ok {
+			Error(w, fe.Reason, fe.StatusCode)
+			return
+		}
+		if err != nil {
+			Error(w, err.Error(), StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(r.Form.Encode()))
+	}))
+	ts.Config.FormPolicy = &FormPolicy{
+		MaxKeys:       3,
+		MaxKeyBytes:   5,
+		MaxValueBytes: 10,
+		MaxBytes:      1000,
+		Strict:        true,
+	}
+	ts.Start()
+	defer ts.Close()
+
+	form := func(query, body string) *Request {
+		req, _ := NewRequest("POST", ts.URL+"/?"+query, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+	multi := func(query string, fields, files []string) *Request {
+		req := multipartRequest(t, fields, files)
+		req.URL, _ = url.Parse(ts.URL + "/?" + query)
+		return req
+	}
+	tests := []struct {
+		name string
+		req  *Request
+		code int
+		body string
+	}{
+		{"ok", form("q=1", "a=1&b=2"), 200, "a=1&b=2&q=1"},
+		{"too many keys", form("q=1&r=2", "a=1&b=2"), 413, "too many keys"},
+		{"too many keys in query", form("q=1&r=2&s=3&t=4", ""), 413, "too many keys"},
+		{"key too long", form("", "abcdef=1"), 413, "key too long"},
+		{"value too long", form("", "a=01234567890"), 413, "value too long"},
+		{"escaped value", form("", "a=%41%42%43%44"), 200, "a=ABCD"},
+		{"body too large", form("", strings.Repeat("a=1&", 300)), 413, "body too large"},
+		{"semicolon", form("", "a=1;b=2"), 400, "semicolon in field"},
+		{"bad escape", form("a=%zz", ""), 400, "invalid escape in field"},
+		{"multipart ok", multi("q=1", []string{"a=1"}, []string{"f=data"}), 200, "a=1&q=1"},
+		{"multipart too many keys", multi("q=1&r=2", []string{"a=1", "b=2"}, nil), 413, "too many keys"},
+		{"multipart value too long", multi("", []string{"a=01234567890"}, nil), 413, "value too long"},
+		{"multipart too large", multi("", nil, []string{"f=" + strings.Repeat("x", 2000)}), 413, "body too large"},
+	}
+	for _, tt := range tests {
+		res, err := DefaultClient.Do(tt.req)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.code || strings.TrimSpace(string(body)) != tt.body {
+			t.Errorf("%s: got %d %q; want %d %q", tt.name, res.StatusCode, body, tt.code, tt.body)
+		}
+	}
+}
+
+// The files of multipart forms checked part by part are stored as
+// ReadForm stores them, in memory or, past maxMemory, in temporary
+// files.
+func TestServerFormPolicyMultipartFiles(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if err := r.ParseMultipartForm(8); err != nil {
+			Error(w, err.Error(), StatusInternalServerError)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+		fmt.Fprintf(w, "%s:", r.Form.Encode())
+		for _, name := range []string{"f", "g"} {
+			f, fh, err := r.FormFile(name)
+			if err != nil {
+				Error(w, err.Error(), StatusInternalServerError)
+				return
+			}
+			_, onDisk := f.(*os.File)
+			b, _ := ioutil.ReadAll(f)
+			f.Close()
+			fmt.Fprintf(w, " %s %d %s %v", fh.Filename, fh.Size, b, onDisk)
+		}
+	}))
+	ts.Config.FormPolicy = &FormPolicy{MaxKeys: 4}
+	ts.Start()
+	defer ts.Close()
+
+	req := multipartRequest(t, []string{"a=1", "b=2"}, []string{"f=small", "g=larger than maxMemory"})
+	req.URL, _ = url.Parse(ts.URL)
+	res, err := DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if want := "a=1&b=2: f.txt 5 small false g.txt 21 larger than maxMemory true"; string(body) != want {
+		t.Errorf("got %d %q; want %q", res.StatusCode, body, want)
+	}
+}
+
+// Multipart forms are checked part by part, failing before the rest of
+// the body is read.
+func TestServerFormPolicyMultipartStream(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		err := r.ParseMultipartForm(1 << 20)
+		if fe, ok := err.(*FormError); ok {
+			// Not waiting for the rest of the body.
+			w.Header().Set("Connection", "close")
+			Error(w, fe.Reason, fe.StatusCode)
+			return
+		}
+		Error(w, "parsed", StatusOK)
+	}))
+	ts.Config.FormPolicy = &FormPolicy{MaxKeys: 1, MaxValueBytes: 10}
+	ts.Start()
+	defer ts.Close()
+
+	tests := []struct {
+		name  string
+		write func(mw *multipart.Writer)
+		want  string
+	}{
+		{"too many keys", func(mw *multipart.Writer) {
+			mw.WriteField("a", "1")
+			fw, _ := mw.CreateFormFile("f", "f.txt")
+			fw.Write([]byte(strings.Repeat("x", 64<<10)))
+		}, "too many keys"},
+		{"value too long", func(mw *multipart.Writer) {
+			fw, _ := mw.CreateFormField("a")
+			fw.Write([]byte(strings.Repeat("x", 64<<10)))
+		}, "value too long"},
+	}
+	for _, tt := range tests {
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		req, _ := NewRequest("POST", ts.URL, pr)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		go tt.write(mw) // the body is left unfinished
+
+		type result struct {
+			res *Response
+			err error
+		}
+		c := make(chan result, 1)
+		go func() {
+			res, err := DefaultClient.Do(req)
+			c <- result{res, err}
+		}()
+		select {
+		case r := <-c:
+			if r.err != nil {
+				t.Errorf("%s: %v", tt.name, r.err)
+				break
+			}
+			body, _ := ioutil.ReadAll(r.res.Body)
+			r.res.Body.Close()
+			if r.res.StatusCode != 413 || strings.TrimSpace(string(body)) != tt.want {
+				t.Errorf("%s: got %d %q; want 413 %q", tt.name, r.res.StatusCode, body, tt.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: no response before the end of the body", tt.name)
+		}
+		pw.Close()
+	}
+}
+
+func TestServerFormPolicyLenient(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if err := r.ParseForm(); err == nil {
+			t.Error("ParseForm succeeded; want error for malformed fields")
+		} else if _, ok := err.(*FormError); ok {
+			t.Errorf("ParseForm error = %v; want no FormError when not Strict", err)
+		}
+		w.Write([]byte(r.Form.Encode()))
+	}))
+	ts.Config.FormPolicy = &FormPolicy{MaxKeys: 10}
+	ts.Start()
+	defer ts.Close()
+
+	res, err := Get(ts.URL + "/?a=1&b=%zz&c=2;d=3&e=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if want := "a=1&e=4"; string(body) != want {
+		t.Errorf("form = %q; want %q", body, want)
+	}
+}
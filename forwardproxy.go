@@ -0,0 +1,360 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Forward (egress) proxy.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A ForwardProxy is a Handler serving as a forward, or egress, proxy:
+// it opens CONNECT tunnels and forwards requests for absolute http
+// and https URLs, to the destinations its rules allow. Other requests
+// are answered with 400 Bad Request.
+//
+// A ForwardProxy's fields must not be changed once it is in use.
+type ForwardProxy struct {
+	// Allow, if non-empty, lists the only destinations that may be
+	// reached, and Deny destinations that may not, overriding
+	// Allow. An entry is a host name, "*." followed by a domain
+	// matching the names below it, an IP address, or a CIDR block
+	// such as "10.0.0.0/8". Addresses and blocks match both IP
+	// destinations and the addresses host names resolve to.
+	Allow []string
+	Deny  []string
+
+	// Ports, if non-empty, lists the only destination ports that
+	// may be reached.
+	Ports []int
+
+	// MaxConnsPerClient, if positive, limits the tunnels and
+	// requests each client may have in progress; clients beyond it
+	// are answered with 429 Too Many Requests. Clients are keyed by
+	// IP address: the source address of the PROXY protocol header
+	// of their connection, if any, and its remote address
+	// otherwise.
+	MaxConnsPerClient int
+
+	// ClientLimit optionally returns the limit of the client with
+	// the given IP address instead of MaxConnsPerClient, zero or
+	// less meaning none.
+	ClientLimit func(client string) int
+
+	// Dial optionally dials the destinations, for example through
+	// an upstream proxy or from a certain source address. Names
+	// resolved to check their addresses against the rules are
+	// dialed by those addresses. If nil, net.Dial is used.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	once  sync.Once
+	allow rules
+	deny  rules
+	tr    *Transport
+
+	mu      sync.Mutex
+	clients map[string]int // requests in progress, by client
+}
+
+var errDestinationDenied = errors.New("http: destination not allowed by proxy")
+
+// rules are the parsed destination rules of a ForwardProxy.
+type rules struct {
+	names   map[string]bool
+	domains []string // with leading dot
+	nets    []*net.IPNet
+}
+
+func parseRules(entries []string) (r rules) {
+	r.names = make(map[string]bool)
+	for _, e := range entries {
+		e = strings.ToLower(strings.TrimSuffix(e, "."))
+		if _, n, err := net.ParseCIDR(e); err == nil {
+			r.nets = append(r.nets, n)
+		} else if ip := net.ParseIP(e); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			r.nets = append(r.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		} else if strings.HasPrefix(e, "*.") {
+			r.domains = append(r.domains, e[1:])
+		} else {
+			r.names[e] = true
+		}
+	}
+	return
+}
+
+func (r *rules) empty() bool {
+	return len(r.names) == 0 && len(r.domains) == 0 && len(r.nets) == 0
+}
+
+func (r *rules) matchName(host string) bool {
+	if r.names[host] {
+		return true
+	}
+	for _, d := range r.domains {
+		if strings.HasSuffix(host, d) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rules) matchIP(ip net.IP) bool {
+	for _, n := range r.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (fp *ForwardProxy) init() {
+	fp.allow = parseRules(fp.Allow)
+	fp.deny = parseRules(fp.Deny)
+	fp.tr = &Transport{DialContext: fp.dial}
+}
+
+// permitted reports whether the rules let host, a name or IP address
+// not denied by name, be reached, with ips the addresses a name
+// resolves to.
+func (fp *ForwardProxy) permitted(host string, ips []net.IP) bool {
+	for _, ip := range ips {
+		if fp.deny.matchIP(ip) {
+			return false
+		}
+	}
+	if fp.allow.empty() || fp.allow.matchName(host) {
+		return true
+	}
+	for _, ip := range ips {
+		if !fp.allow.matchIP(ip) {
+			return false
+		}
+	}
+	return len(ips) > 0
+}
+
+func (fp *ForwardProxy) portAllowed(port int) bool {
+	for _, p := range fp.Ports {
+		if p == port {
+			return true
+		}
+	}
+	return len(fp.Ports) == 0
+}
+
+// dial dials addr, a destination's "host:port", if the rules allow
+// it, resolving names to check their addresses and dialing those.
+func (fp *ForwardProxy) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, errors.New("http: invalid port " + strconv.Quote(portStr))
+	}
+	if !fp.portAllowed(port) || fp.deny.matchName(host) {
+		return nil, errDestinationDenied
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else if len(fp.deny.nets) > 0 || !fp.allow.matchName(host) && len(fp.allow.nets) > 0 {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	if !fp.permitted(host, ips) {
+		return nil, errDestinationDenied
+	}
+	if len(ips) == 0 {
+		return fp.dialAddr(ctx, network, addr)
+	}
+	// The addresses checked are dialed, rather than the name, which
+	// could resolve to others by now.
+	var firstErr error
+	for _, ip := range ips {
+		c, err := fp.dialAddr(ctx, network, net.JoinHostPort(ip.String(), portStr))
+		if err == nil {
+			return c, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// dialAddr dials addr with fp.Dial, if set.
+func (fp *ForwardProxy) dialAddr(ctx context.Context, network, addr string) (net.Conn, error) {
+	if fp.Dial != nil {
+		return fp.Dial(ctx, network, addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+// clientKey returns the IP address keying the quota of r's client.
+func clientKey(r *Request) string {
+	if ip := clientIP(r); ip != nil {
+		return ip.String()
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// acquire counts a request of r's client in progress, reporting false
+// if the client is over its limit. If it reports true, release must
+// be called when the request is done.
+func (fp *ForwardProxy) acquire(r *Request) (release func(), ok bool) {
+	key := clientKey(r)
+	limit := fp.MaxConnsPerClient
+	if fp.ClientLimit != nil {
+		limit = fp.ClientLimit(key)
+	}
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	if limit > 0 && fp.clients[key] >= limit {
+		return nil, false
+	}
+	if fp.clients == nil {
+		fp.clients = make(map[string]int)
+	}
+	fp.clients[key]++
+	return func() {
+		fp.mu.Lock()
+		defer fp.mu.Unlock()
+		if fp.clients[key]--; fp.clients[key] <= 0 {
+			delete(fp.clients, key)
+		}
+	}, true
+}
+
+// proxyHopHeaders are the hop-by-hop headers of requests and
+// responses, not passed on by a ForwardProxy.
+var proxyHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailers",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeProxyHopHeaders removes the hop-by-hop headers from h,
+// including those the Connection header lists.
+func removeProxyHopHeaders(h Header) {
+	for _, v := range h["Connection"] {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				h.Del(f)
+			}
+		}
+	}
+	for _, k := range proxyHopHeaders {
+		h.Del(k)
+	}
+}
+
+func (fp *ForwardProxy) ServeHTTP(w ResponseWriter, r *Request) {
+	fp.once.Do(fp.init)
+	tunnel := r.Method == "CONNECT" && !strings.HasPrefix(r.RequestURI, "/")
+	if !tunnel && (r.URL.Scheme != "http" && r.URL.Scheme != "https" || r.URL.Host == "") {
+		respondError(w, r, StatusBadRequest, "not a proxy request", nil)
+		return
+	}
+	release, ok := fp.acquire(r)
+	if !ok {
+		tooManyRequests(w, r, 0)
+		return
+	}
+	defer release()
+	if tunnel {
+		fp.serveTunnel(w, r)
+		return
+	}
+
+	out := new(Request)
+	*out = *r
+	out.RequestURI = ""
+	out.Close = false
+	if r.ContentLength == 0 {
+		out.Body = nil
+	}
+	out.Header = make(Header)
+	for k, vv := range r.Header {
+		out.Header[k] = vv
+	}
+	removeProxyHopHeaders(out.Header)
+	res, err := fp.tr.RoundTrip(out)
+	if err != nil {
+		fp.dialError(w, r, err)
+		return
+	}
+	defer res.Body.Close()
+	removeProxyHopHeaders(res.Header)
+	for k, vv := range res.Header {
+		w.Header()[k] = vv
+	}
+	w.WriteHeader(res.StatusCode)
+	io.Copy(w, res.Body)
+}
+
+// dialError answers r, whose destination could not be reached.
+func (fp *ForwardProxy) dialError(w ResponseWriter, r *Request, err error) {
+	if err == errDestinationDenied {
+		respondError(w, r, StatusForbidden, "destination not allowed", err)
+		return
+	}
+	log.Printf("http: proxy error: %v", err)
+	respondError(w, r, StatusBadGateway, "Bad Gateway", err)
+}
+
+// serveTunnel serves the CONNECT request r, replying 200 OK once the
+// destination is connected.
+func (fp *ForwardProxy) serveTunnel(w ResponseWriter, r *Request) {
+	dst, err := fp.dial(r.Context(), "tcp", r.URL.Host)
+	if err != nil {
+		fp.dialError(w, r, err)
+		return
+	}
+	defer dst.Close()
+	hj, ok := w.(Hijacker)
+	if !ok {
+		respondError(w, r, StatusInternalServerError, "CONNECT not supported", nil)
+		return
+	}
+	rwc, buf, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer rwc.Close()
+	buf.WriteString(statusLine(r, StatusOK) + "\r\n")
+	if err := buf.Flush(); err != nil {
+		return
+	}
+	copyTunnel(&tunnelConn{rwc, buf.Reader}, dst)
+}
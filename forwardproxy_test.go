@@ -0,0 +1,210 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForwardProxy(t *testing.T) {
+	defer afterTest(t)
+	unblock := make(chan bool)
+	backend := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/block" {
+			<-unblock
+		}
+		fmt.Fprintf(w, "%s %s auth=%q", r.Host, r.URL.Path, r.Header.Get("Proxy-Authorization"))
+	}))
+	defer backend.Close()
+	backendAddr := backend.Listener.Addr().String()
+	_, port, _ := net.SplitHostPort(backendAddr)
+
+	fp := &ForwardProxy{
+		Deny:              []string{"127.0.0.2", "*.denied.test"},
+		MaxConnsPerClient: 1,
+	}
+	proxy := httptest.NewServer(fp)
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	tr := &Transport{Proxy: ProxyURL(proxyURL)}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+
+	get := func(u string) (int, string) {
+		req, _ := NewRequest("GET", u, nil)
+		req.Header.Set("Proxy-Authorization", "Basic c2VjcmV0")
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", u, err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return res.StatusCode, string(b)
+	}
+
+	if code, body := get(backend.URL + "/foo"); code != 200 || body != backendAddr+` /foo auth=""` {
+		t.Errorf("proxied GET: %d %q", code, body)
+	}
+	if code, _ := get("http://127.0.0.2:" + port + "/"); code != StatusForbidden {
+		t.Errorf("GET of a denied address: status %d; want 403", code)
+	}
+	if code, _ := get("http://www.denied.test/"); code != StatusForbidden {
+		t.Errorf("GET of a denied domain: status %d; want 403", code)
+	}
+
+	// A second request of the client while one is in progress
+	// is over its limit.
+	done := make(chan bool)
+	go func() {
+		get(backend.URL + "/block")
+		done <- true
+	}()
+	time.Sleep(50 * time.Millisecond)
+	res, err := (&Client{Transport: &Transport{Proxy: ProxyURL(proxyURL)}}).Get(backend.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 429 {
+		t.Errorf("request over the client's limit: status %d; want 429", res.StatusCode)
+	}
+	close(unblock)
+	<-done
+
+	// A tunnel, with a request through it.
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", backendAddr, backendAddr)
+	br := bufio.NewReader(conn)
+	res, err = ReadResponse(br, &Request{Method: "CONNECT"})
+	if err != nil || res.StatusCode != StatusOK {
+		t.Fatalf("CONNECT: %v, %v", res, err)
+	}
+	fmt.Fprintf(conn, "GET /tunneled HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", backendAddr)
+	res, err = ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("reading response through the tunnel: %v", err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	if !strings.Contains(string(b), " /tunneled ") {
+		t.Errorf("response through the tunnel %q", b)
+	}
+}
+
+func TestForwardProxyRules(t *testing.T) {
+	defer afterTest(t)
+	backend := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer backend.Close()
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+
+	tests := []struct {
+		fp   *ForwardProxy
+		host string
+		want int
+	}{
+		{&ForwardProxy{Allow: []string{"127.0.0.0/8"}}, "127.0.0.1", 200},
+		{&ForwardProxy{Allow: []string{"10.0.0.0/8"}}, "127.0.0.1", 403},
+		{&ForwardProxy{Allow: []string{"localhost"}}, "localhost", 200},
+		{&ForwardProxy{Allow: []string{"*.localhost"}}, "localhost", 403},
+		{&ForwardProxy{Deny: []string{"127.0.0.1"}}, "localhost", 403},
+		{&ForwardProxy{Ports: []int{443}}, "127.0.0.1", 403},
+	}
+	for _, tt := range tests {
+		proxy := httptest.NewServer(tt.fp)
+		proxyURL, _ := url.Parse(proxy.URL)
+		tr := &Transport{Proxy: ProxyURL(proxyURL)}
+		res, err := (&Client{Transport: tr}).Get("http://" + net.JoinHostPort(tt.host, port) + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.want {
+			t.Errorf("Allow %q, Deny %q, Ports %v: %s got status %d; want %d",
+				tt.fp.Allow, tt.fp.Deny, tt.fp.Ports, tt.host, res.StatusCode, tt.want)
+		}
+		tr.CloseIdleConnections()
+		proxy.Close()
+	}
+}
+
+// Names resolved to be checked are dialed by the addresses checked, so
+// that they can't resolve to others in between.
+func TestForwardProxyDialsCheckedAddr(t *testing.T) {
+	defer afterTest(t)
+	backend := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer backend.Close()
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+
+	var dialed []string
+	fp := &ForwardProxy{
+		Deny: []string{"10.0.0.0/8"},
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+			return net.Dial(network, backend.Listener.Addr().String())
+		},
+	}
+	proxy := httptest.NewServer(fp)
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	tr := &Transport{Proxy: ProxyURL(proxyURL)}
+	defer tr.CloseIdleConnections()
+	res, err := (&Client{Transport: tr}).Get("http://localhost:" + port + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if len(dialed) != 1 {
+		t.Fatalf("dialed %q; want one address", dialed)
+	}
+	host, _, _ := net.SplitHostPort(dialed[0])
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		t.Errorf("dialed %q; want a loopback address", dialed[0])
+	}
+}
+
+func TestForwardProxyConnectionHeaders(t *testing.T) {
+	defer afterTest(t)
+	backend := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Connection", "X-Internal")
+		w.Header().Set("X-Internal", "1")
+		fmt.Fprintf(w, "secret=%q", r.Header.Get("X-Secret"))
+	}))
+	defer backend.Close()
+
+	proxy := httptest.NewServer(&ForwardProxy{})
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	tr := &Transport{Proxy: ProxyURL(proxyURL)}
+	defer tr.CloseIdleConnections()
+	req, _ := NewRequest("GET", backend.URL+"/", nil)
+	req.Header.Set("Connection", "X-Secret")
+	req.Header.Set("X-Secret", "1")
+	res, err := (&Client{Transport: tr}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(b) != `secret=""` {
+		t.Errorf("backend got %s; want the header the Connection header lists removed", b)
+	}
+	if v := res.Header.Get("X-Internal"); v != "" {
+		t.Errorf("response header X-Internal = %q; want it removed", v)
+	}
+}
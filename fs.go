@@ -7,15 +7,20 @@
 package http
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
 	"io"
+	"io/fs"
 	"mime"
 	"mime/multipart"
 	"net/textproto"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -60,6 +65,71 @@ type File interface {
 	Seek(offset int64, whence int) (int64, error)
 }
 
+type ioFS struct {
+	fsys fs.FS
+}
+
+type ioFile struct {
+	file fs.File
+}
+
+// FS converts fsys to a FileSystem implementation, for use with
+// FileServer and NewFileTransport. The files provided by fsys must
+// implement io.Seeker to be served with ServeContent's range
+// support, and directories must implement fs.ReadDirFile to be
+// listed.
+func FS(fsys fs.FS) FileSystem {
+	return ioFS{fsys}
+}
+
+func (f ioFS) Open(name string) (File, error) {
+	if name == "/" {
+		name = "."
+	} else {
+		name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	}
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioFile{file}, nil
+}
+
+var (
+	errMissingSeek    = errors.New("io.File missing Seek method")
+	errMissingReadDir = errors.New("io.File directory missing ReadDir method")
+)
+
+func (f ioFile) Close() error               { return f.file.Close() }
+func (f ioFile) Read(b []byte) (int, error) { return f.file.Read(b) }
+func (f ioFile) Stat() (os.FileInfo, error) { return f.file.Stat() }
+
+func (f ioFile) Seek(offset int64, whence int) (int64, error) {
+	s, ok := f.file.(io.Seeker)
+	if !ok {
+		return 0, errMissingSeek
+	}
+	return s.Seek(offset, whence)
+}
+
+func (f ioFile) Readdir(count int) ([]os.FileInfo, error) {
+	d, ok := f.file.(fs.ReadDirFile)
+	if !ok {
+		return nil, errMissingReadDir
+	}
+	dirs, err := d.ReadDir(count)
+	var list []os.FileInfo
+	for _, dir := range dirs {
+		info, err := dir.Info()
+		if err != nil {
+			// Pretend it doesn't exist, like (*os.File).Readdir does.
+			continue
+		}
+		list = append(list, info)
+	}
+	return list, err
+}
+
 func dirList(w ResponseWriter, f File) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	fmt.Fprintf(w, "<pre>\n")
@@ -80,6 +150,101 @@ func dirList(w ResponseWriter, f File) {
 	fmt.Fprintf(w, "</pre>\n")
 }
 
+// A DirListRenderer renders the listing of a directory that has no
+// index.html file. The entries are sorted by name and dir is the
+// request's URL path.
+type DirListRenderer interface {
+	RenderDirList(w ResponseWriter, r *Request, dir string, entries []os.FileInfo)
+}
+
+// The DirListFunc type is an adapter to allow the use of ordinary
+// functions as DirListRenderers.
+type DirListFunc func(w ResponseWriter, r *Request, dir string, entries []os.FileInfo)
+
+// RenderDirList calls f(w, r, dir, entries).
+func (f DirListFunc) RenderDirList(w ResponseWriter, r *Request, dir string, entries []os.FileInfo) {
+	f(w, r, dir, entries)
+}
+
+// DirListing is the data passed to the template of a
+// TemplateDirList renderer.
+type DirListing struct {
+	Path    string        // URL path of the directory
+	Entries []os.FileInfo // directory contents, sorted by name
+}
+
+// TemplateDirList returns a DirListRenderer that renders directory
+// listings by executing t with a *DirListing. The response has
+// Content-Type "text/html; charset=utf-8" unless one was already set.
+func TemplateDirList(t *template.Template) DirListRenderer {
+	return DirListFunc(func(w ResponseWriter, r *Request, dir string, entries []os.FileInfo) {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, &DirListing{Path: dir, Entries: entries}); err != nil {
+			respondError(w, r, StatusInternalServerError, "error rendering directory listing", err)
+			return
+		}
+		if _, ok := w.Header()["Content-Type"]; !ok {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		w.Write(buf.Bytes())
+	})
+}
+
+// JSONDirList renders directory listings as a JSON array of objects
+// with the fields "name", "size", "mode", "modTime" and "isDir".
+var JSONDirList DirListRenderer = DirListFunc(jsonDirList)
+
+type jsonDirEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"modTime"`
+	IsDir   bool      `json:"isDir"`
+}
+
+func jsonDirList(w ResponseWriter, r *Request, dir string, entries []os.FileInfo) {
+	list := make([]jsonDirEntry, len(entries))
+	for i, d := range entries {
+		list[i] = jsonDirEntry{
+			Name:    d.Name(),
+			Size:    d.Size(),
+			Mode:    d.Mode().String(),
+			ModTime: d.ModTime().UTC(),
+			IsDir:   d.IsDir(),
+		}
+	}
+	b, err := json.Marshal(list)
+	if err != nil {
+		respondError(w, r, StatusInternalServerError, "error rendering directory listing", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+type fileInfosByName []os.FileInfo
+
+func (s fileInfosByName) Len() int           { return len(s) }
+func (s fileInfosByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s fileInfosByName) Less(i, j int) bool { return s[i].Name() < s[j].Name() }
+
+// readDirSorted reads all of f's directory entries, sorted by name.
+func readDirSorted(f File) ([]os.FileInfo, error) {
+	var entries []os.FileInfo
+	for {
+		dirs, err := f.Readdir(100)
+		entries = append(entries, dirs...)
+		if err == io.EOF || err == nil && len(dirs) == 0 {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Sort(fileInfosByName(entries))
+	return entries, nil
+}
+
 // ServeContent replies to the request using the content in the
 // provided ReadSeeker.  The main benefit of ServeContent over io.Copy
 // is that it handles Range requests properly, sets the MIME type, and
@@ -101,7 +266,11 @@ func dirList(w ResponseWriter, f File) {
 // a seek to the end of the content to determine its size.
 //
 // If the caller has set w's ETag header, ServeContent uses it to
-// handle requests using If-Range and If-None-Match.
+// handle requests using If-Range and If-None-Match. If-Range may
+// also carry a date, which is compared against modtime.
+//
+// Requests for more than DefaultMaxRanges byte ranges are served
+// the entire content, to prevent range amplification.
 //
 // Note that *os.File implements the io.ReadSeeker interface.
 func ServeContent(w ResponseWriter, req *Request, name string, modtime time.Time, content io.ReadSeeker) {
@@ -116,9 +285,14 @@ func ServeContent(w ResponseWriter, req *Request, name string, modtime time.Time
 		}
 		return size, nil
 	}
-	serveContent(w, req, name, modtime, sizeFunc, content)
+	serveContent(w, req, name, modtime, sizeFunc, content, DefaultMaxRanges)
 }
 
+// DefaultMaxRanges is the maximum number of byte ranges ServeContent
+// honors in a single request. FileHandler's limit can be changed with
+// its MaxRanges field.
+const DefaultMaxRanges = 100
+
 // errSeeker is returned by ServeContent's sizeFunc when the content
 // doesn't seek properly. The underlying Seeker's error text isn't
 // included in the sizeFunc reply so it's not sent over HTTP to end
@@ -129,11 +303,12 @@ var errSeeker = errors.New("seeker can't seek")
 // if modtime.IsZero(), modtime is unknown.
 // content must be seeked to the beginning of the file.
 // The sizeFunc is called at most once. Its error, if any, is sent in the HTTP response.
-func serveContent(w ResponseWriter, r *Request, name string, modtime time.Time, sizeFunc func() (int64, error), content io.ReadSeeker) {
+// Requests for more than maxRanges ranges get the whole content.
+func serveContent(w ResponseWriter, r *Request, name string, modtime time.Time, sizeFunc func() (int64, error), content io.ReadSeeker, maxRanges int) {
 	if checkLastModified(w, r, modtime) {
 		return
 	}
-	rangeReq, done := checkETag(w, r)
+	rangeReq, done := checkETag(w, r, modtime)
 	if done {
 		return
 	}
@@ -153,7 +328,7 @@ func serveContent(w ResponseWriter, r *Request, name string, modtime time.Time,
 			ctype = DetectContentType(buf[:n])
 			_, err := content.Seek(0, os.SEEK_SET) // rewind to output whole file
 			if err != nil {
-				Error(w, "seeker can't seek", StatusInternalServerError)
+				respondError(w, r, StatusInternalServerError, "seeker can't seek", err)
 				return
 			}
 		}
@@ -164,7 +339,7 @@ func serveContent(w ResponseWriter, r *Request, name string, modtime time.Time,
 
 	size, err := sizeFunc()
 	if err != nil {
-		Error(w, err.Error(), StatusInternalServerError)
+		respondError(w, r, StatusInternalServerError, err.Error(), err)
 		return
 	}
 
@@ -174,14 +349,18 @@ func serveContent(w ResponseWriter, r *Request, name string, modtime time.Time,
 	if size >= 0 {
 		ranges, err := parseRange(rangeReq, size)
 		if err != nil {
-			Error(w, err.Error(), StatusRequestedRangeNotSatisfiable)
+			if err == errNoOverlap {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			}
+			respondError(w, r, StatusRequestedRangeNotSatisfiable, err.Error(), err)
 			return
 		}
-		if sumRangesSize(ranges) > size {
+		if len(ranges) > maxRanges || sumRangesSize(ranges) > size {
 			// The total number of bytes in all the ranges
 			// is larger than the size of the file by
 			// itself, so this is probably an attack, or a
-			// dumb client.  Ignore the range request.
+			// dumb client.  Likewise for an excessive
+			// number of ranges.  Ignore the range request.
 			ranges = nil
 		}
 		switch {
@@ -199,19 +378,13 @@ func serveContent(w ResponseWriter, r *Request, name string, modtime time.Time,
 			// be sent using the multipart/byteranges media type."
 			ra := ranges[0]
 			if _, err := content.Seek(ra.start, os.SEEK_SET); err != nil {
-				Error(w, err.Error(), StatusRequestedRangeNotSatisfiable)
+				respondError(w, r, StatusRequestedRangeNotSatisfiable, err.Error(), err)
 				return
 			}
 			sendSize = ra.length
 			code = StatusPartialContent
 			w.Header().Set("Content-Range", ra.contentRange(size))
 		case len(ranges) > 1:
-			for _, ra := range ranges {
-				if ra.start > size {
-					Error(w, err.Error(), StatusRequestedRangeNotSatisfiable)
-					return
-				}
-			}
 			sendSize = rangesMIMESize(ranges, ctype, size)
 			code = StatusPartialContent
 
@@ -276,24 +449,25 @@ func checkLastModified(w ResponseWriter, r *Request, modtime time.Time) bool {
 
 // checkETag implements If-None-Match and If-Range checks.
 // The ETag must have been previously set in the ResponseWriter's headers.
+// The modtime is used for If-Range dates and may be the zero time.
 //
 // The return value is the effective request "Range" header to use and
 // whether this request is now considered done.
-func checkETag(w ResponseWriter, r *Request) (rangeReq string, done bool) {
+func checkETag(w ResponseWriter, r *Request, modtime time.Time) (rangeReq string, done bool) {
 	etag := w.Header().get("Etag")
 	rangeReq = r.Header.get("Range")
+	if r.Method != "GET" && r.Method != "HEAD" {
+		// RFC 7233, Section 3.1: a server MUST ignore a Range
+		// header field received with a request method other
+		// than GET.  HEAD is treated like GET.
+		rangeReq = ""
+	}
 
 	// Invalidate the range request if the entity doesn't match the one
 	// the client was expecting.
 	// "If-Range: version" means "ignore the Range: header unless version matches the
 	// current file."
-	// We only support ETag versions.
-	// The caller must have set the ETag on the response already.
-	if ir := r.Header.get("If-Range"); ir != "" && ir != etag {
-		// TODO(bradfitz): handle If-Range requests with Last-Modified
-		// times instead of ETags? I'd rather not, at least for
-		// now. That seems like a bug/compromise in the RFC 2616, and
-		// I've never heard of anybody caring about that (yet).
+	if ir := r.Header.get("If-Range"); ir != "" && rangeReq != "" && !ifRangeMatches(ir, etag, modtime) {
 		rangeReq = ""
 	}
 
@@ -326,8 +500,26 @@ func checkETag(w ResponseWriter, r *Request) (rangeReq string, done bool) {
 	return rangeReq, false
 }
 
+// ifRangeMatches reports whether the If-Range header value ir
+// validates the current representation, per RFC 7233, Section 3.2.
+// An entity tag must match etag using the strong comparison; a date
+// must exactly match the Last-Modified time derived from modtime.
+func ifRangeMatches(ir, etag string, modtime time.Time) bool {
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return etag != "" && ir == etag && !strings.HasPrefix(etag, "W/")
+	}
+	if modtime.IsZero() {
+		return false
+	}
+	t, err := ParseTime(ir)
+	if err != nil {
+		return false
+	}
+	return t.Unix() == modtime.Unix()
+}
+
 // name is '/'-separated, not filepath.Separator.
-func serveFile(w ResponseWriter, r *Request, fs FileSystem, name string, redirect bool) {
+func serveFile(w ResponseWriter, r *Request, fh *FileHandler, name string, redirect bool) {
 	const indexPage = "/index.html"
 
 	// redirect .../index.html to .../
@@ -338,6 +530,7 @@ func serveFile(w ResponseWriter, r *Request, fs FileSystem, name string, redirec
 		return
 	}
 
+	fs := fh.Root
 	f, err := fs.Open(name)
 	if err != nil {
 		// TODO expose actual error?
@@ -390,13 +583,112 @@ func serveFile(w ResponseWriter, r *Request, fs FileSystem, name string, redirec
 		if checkLastModified(w, r, d.ModTime()) {
 			return
 		}
+		if fh.DirList != nil {
+			entries, err := readDirSorted(f)
+			if err != nil {
+				respondError(w, r, StatusInternalServerError, "error reading directory", err)
+				return
+			}
+			fh.DirList.RenderDirList(w, r, r.URL.Path, entries)
+			return
+		}
 		dirList(w, f)
 		return
 	}
 
+	modtime := d.ModTime()
+	if fh.CachePolicy != nil {
+		fh.CachePolicy.setHeaders(w, name, d)
+		if fh.CachePolicy.DisableLastModified {
+			modtime = time.Time{}
+		}
+	}
+
+	if fh.Precompressed {
+		if cf, cd, enc := openPrecompressed(w, r, fs, name); cf != nil {
+			defer cf.Close()
+			if _, haveType := w.Header()["Content-Type"]; !haveType {
+				// Type the response after the original file, not
+				// the compressed bytes serveContent would sniff.
+				ctype := mime.TypeByExtension(filepath.Ext(name))
+				if ctype == "" {
+					var buf [sniffLen]byte
+					n, _ := io.ReadFull(f, buf[:])
+					ctype = DetectContentType(buf[:n])
+				}
+				w.Header().Set("Content-Type", ctype)
+			}
+			if etag := w.Header().get("Etag"); etag != "" && fh.CachePolicy != nil && fh.CachePolicy.ETag {
+				w.Header().Set("Etag", etag[:len(etag)-1]+"-"+enc+`"`)
+			}
+			w.Header().Set("Content-Encoding", enc)
+			w.Header().Set("Content-Length", strconv.FormatInt(cd.Size(), 10))
+			sizeFunc := func() (int64, error) { return cd.Size(), nil }
+			serveContent(w, r, d.Name(), modtime, sizeFunc, cf, fh.maxRanges())
+			return
+		}
+	}
+
 	// serverContent will check modification time
 	sizeFunc := func() (int64, error) { return d.Size(), nil }
-	serveContent(w, r, d.Name(), d.ModTime(), sizeFunc, f)
+	serveContent(w, r, d.Name(), modtime, sizeFunc, f, fh.maxRanges())
+}
+
+// precompressedEncodings lists the content codings FileHandler looks
+// for as sibling files, in order of preference.
+var precompressedEncodings = []struct {
+	coding, ext string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// openPrecompressed looks for a precompressed sibling of the named
+// file in the content coding the client prefers, if not identity. It
+// returns a nil File if there is none. Range requests are always served
+// from the original file, since ranges apply to the identity
+// representation.
+func openPrecompressed(w ResponseWriter, r *Request, fs FileSystem, name string) (f File, d os.FileInfo, coding string) {
+	isRange := r.Header.get("Range") != ""
+	var (
+		codings []string
+		files   []File
+		infos   []os.FileInfo
+	)
+	for _, pe := range precompressedEncodings {
+		cf, err := fs.Open(name + pe.ext)
+		if err != nil {
+			continue
+		}
+		// The representation now depends on Accept-Encoding, even
+		// if this particular client doesn't get the compressed one.
+		w.Header().Set("Vary", "Accept-Encoding")
+		if isRange {
+			cf.Close()
+			continue
+		}
+		cd, err := cf.Stat()
+		if err != nil || !cd.Mode().IsRegular() {
+			cf.Close()
+			continue
+		}
+		codings, files, infos = append(codings, pe.coding), append(files, cf), append(infos, cd)
+	}
+	if len(codings) == 0 {
+		return nil, nil, ""
+	}
+	coding = r.AcceptsEncoding(append(codings, "identity")...)
+	for i, c := range codings {
+		if c == coding {
+			f, d = files[i], infos[i]
+		} else {
+			files[i].Close()
+		}
+	}
+	if f == nil {
+		coding = ""
+	}
+	return f, d, coding
 }
 
 // localRedirect gives a Moved Permanently response.
@@ -412,11 +704,91 @@ func localRedirect(w ResponseWriter, r *Request, newPath string) {
 // ServeFile replies to the request with the contents of the named file or directory.
 func ServeFile(w ResponseWriter, r *Request, name string) {
 	dir, file := filepath.Split(name)
-	serveFile(w, r, Dir(dir), file, false)
+	serveFile(w, r, &FileHandler{Root: Dir(dir)}, file, false)
+}
+
+// ServeFileFS replies to the request with the contents of the named
+// file or directory from the file system fsys. The name is a
+// slash-separated path as accepted by fs.FS.Open.
+func ServeFileFS(w ResponseWriter, r *Request, fsys fs.FS, name string) {
+	serveFile(w, r, &FileHandler{Root: FS(fsys)}, name, false)
+}
+
+// A FileHandler serves HTTP requests with the contents of the file
+// system rooted at Root. FileServer returns a FileHandler with only
+// Root set; the remaining fields enable optional behavior.
+type FileHandler struct {
+	// Root is the file system to serve files from.
+	Root FileSystem
+
+	// CachePolicy optionally specifies the caching headers to
+	// send with files. If nil, only Last-Modified is sent.
+	CachePolicy *CachePolicy
+
+	// Precompressed, if true, causes a request for name to be
+	// served from a sibling file named name+".br" or name+".gz"
+	// when one exists and the client's Accept-Encoding allows
+	// it. The Content-Type is still derived from name.
+	Precompressed bool
+
+	// DirList optionally specifies how to render directories
+	// without an index.html file. If nil, a plain HTML list of
+	// links is served.
+	DirList DirListRenderer
+
+	// MaxRanges is the maximum number of byte ranges honored in a
+	// single request; requests for more are served the whole file.
+	// If zero, DefaultMaxRanges is used.
+	MaxRanges int
+}
+
+func (f *FileHandler) maxRanges() int {
+	if f.MaxRanges > 0 {
+		return f.MaxRanges
+	}
+	return DefaultMaxRanges
+}
+
+// A CachePolicy specifies the validators and Cache-Control
+// directives a FileHandler attaches to the files it serves.
+type CachePolicy struct {
+	// ETag, if true, causes an ETag derived from the file's
+	// modification time and size to be sent, unless the handler's
+	// response already has one. Precompressed variants get
+	// distinct ETags.
+	ETag bool
+
+	// DisableLastModified, if true, suppresses the Last-Modified
+	// header and If-Modified-Since handling.
+	DisableLastModified bool
+
+	// MaxAge, if positive, is sent as
+	// "Cache-Control: public, max-age=N".
+	MaxAge time.Duration
+
+	// CacheControl optionally returns the Cache-Control value to
+	// send for the named file, overriding MaxAge. If it returns
+	// the empty string, no Cache-Control header is sent.
+	CacheControl func(name string, fi os.FileInfo) string
 }
 
-type fileHandler struct {
-	root FileSystem
+func (p *CachePolicy) setHeaders(w ResponseWriter, name string, fi os.FileInfo) {
+	h := w.Header()
+	if p.ETag && h.get("Etag") == "" {
+		h.Set("Etag", fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size()))
+	}
+	if _, ok := h["Cache-Control"]; ok {
+		return
+	}
+	var cc string
+	if p.CacheControl != nil {
+		cc = p.CacheControl(name, fi)
+	} else if p.MaxAge > 0 {
+		cc = "public, max-age=" + strconv.FormatInt(int64(p.MaxAge/time.Second), 10)
+	}
+	if cc != "" {
+		h.Set("Cache-Control", cc)
+	}
 }
 
 // FileServer returns a handler that serves HTTP requests
@@ -426,17 +798,29 @@ type fileHandler struct {
 // use http.Dir:
 //
 //     http.Handle("/", http.FileServer(http.Dir("/tmp")))
+//
+// FileServer's caching and precompression behavior can be configured
+// by using a FileHandler directly.
 func FileServer(root FileSystem) Handler {
-	return &fileHandler{root}
+	return &FileHandler{Root: root}
+}
+
+// FileServerFS returns a handler that serves HTTP requests with the
+// contents of the file system fsys, such as an embed.FS. It is
+// equivalent to FileServer(FS(fsys)).
+//
+//	http.Handle("/", http.FileServerFS(assets))
+func FileServerFS(root fs.FS) Handler {
+	return FileServer(FS(root))
 }
 
-func (f *fileHandler) ServeHTTP(w ResponseWriter, r *Request) {
+func (f *FileHandler) ServeHTTP(w ResponseWriter, r *Request) {
 	upath := r.URL.Path
 	if !strings.HasPrefix(upath, "/") {
 		upath = "/" + upath
 		r.URL.Path = upath
 	}
-	serveFile(w, r, f.root, path.Clean(upath), true)
+	serveFile(w, r, f, path.Clean(upath), true)
 }
 
 // httpRange specifies the byte range to be sent to the client.
@@ -455,7 +839,13 @@ func (r httpRange) mimeHeader(contentType string, size int64) textproto.MIMEHead
 	}
 }
 
-// parseRange parses a Range header string as per RFC 2616.
+// errNoOverlap is returned by parseRange if none of the requested
+// ranges overlap the content.
+var errNoOverlap = errors.New("invalid range: failed to overlap")
+
+// parseRange parses a Range header string as per RFC 7233.
+// Unsatisfiable ranges are dropped; errNoOverlap is returned if none
+// remain.
 func parseRange(s string, size int64) ([]httpRange, error) {
 	if s == "" {
 		return nil, nil // header not present
@@ -465,6 +855,7 @@ func parseRange(s string, size int64) ([]httpRange, error) {
 		return nil, errors.New("invalid range")
 	}
 	var ranges []httpRange
+	noOverlap := false
 	for _, ra := range strings.Split(s[len(b):], ",") {
 		ra = strings.TrimSpace(ra)
 		if ra == "" {
@@ -480,9 +871,15 @@ func parseRange(s string, size int64) ([]httpRange, error) {
 			// If no start is specified, end specifies the
 			// range start relative to the end of the file.
 			i, err := strconv.ParseInt(end, 10, 64)
-			if err != nil {
+			if err != nil || i < 0 {
 				return nil, errors.New("invalid range")
 			}
+			if i == 0 || size == 0 {
+				// A zero-length suffix, or any suffix of
+				// empty content, selects no bytes.
+				noOverlap = true
+				continue
+			}
 			if i > size {
 				i = size
 			}
@@ -490,9 +887,21 @@ func parseRange(s string, size int64) ([]httpRange, error) {
 			r.length = size - r.start
 		} else {
 			i, err := strconv.ParseInt(start, 10, 64)
-			if err != nil || i > size || i < 0 {
+			if err != nil || i < 0 {
 				return nil, errors.New("invalid range")
 			}
+			if i >= size {
+				// The range begins after the end of the
+				// content. It is still syntactically valid,
+				// so check the end before skipping it.
+				if end != "" {
+					if j, err := strconv.ParseInt(end, 10, 64); err != nil || i > j {
+						return nil, errors.New("invalid range")
+					}
+				}
+				noOverlap = true
+				continue
+			}
 			r.start = i
 			if end == "" {
 				// If no end is specified, range extends to end of the file.
@@ -510,6 +919,9 @@ func parseRange(s string, size int64) ([]httpRange, error) {
 		}
 		ranges = append(ranges, r)
 	}
+	if noOverlap && len(ranges) == 0 {
+		return nil, errNoOverlap
+	}
 	return ranges, nil
 }
 
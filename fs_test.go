@@ -6,8 +6,10 @@ package http_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"io/ioutil"
 	"mime"
@@ -25,7 +27,9 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -552,6 +556,231 @@ func TestDirectoryIfNotModified(t *testing.T) {
 	res.Body.Close()
 }
 
+func TestFileServerCachePolicy(t *testing.T) {
+	defer afterTest(t)
+	fileMod := time.Unix(1000000000, 0).UTC()
+	fs := fakeFS{
+		"/style.css": &fakeFileInfo{
+			basename: "style.css",
+			modtime:  fileMod,
+			contents: "body {}",
+		},
+	}
+	ts := httptest.NewServer(&FileHandler{
+		Root: fs,
+		CachePolicy: &CachePolicy{
+			ETag:   true,
+			MaxAge: time.Hour,
+		},
+	})
+	defer ts.Close()
+
+	res, err := Get(ts.URL + "/style.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if g, e := res.Header.Get("Cache-Control"), "public, max-age=3600"; g != e {
+		t.Errorf("Cache-Control = %q; want %q", g, e)
+	}
+	if g, e := res.Header.Get("Last-Modified"), fileMod.Format(TimeFormat); g != e {
+		t.Errorf("Last-Modified = %q; want %q", g, e)
+	}
+	etag := res.Header.Get("Etag")
+	if etag == "" {
+		t.Fatal("no ETag sent")
+	}
+
+	req, _ := NewRequest("GET", ts.URL+"/style.css", nil)
+	req.Header.Set("If-None-Match", etag)
+	res, err = DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != StatusNotModified {
+		t.Errorf("If-None-Match status = %d; want 304", res.StatusCode)
+	}
+}
+
+func TestFileServerPrecompressed(t *testing.T) {
+	defer afterTest(t)
+	fileMod := time.Unix(1000000000, 0).UTC()
+	fs := fakeFS{
+		"/app.css":    &fakeFileInfo{basename: "app.css", modtime: fileMod, contents: "plain contents"},
+		"/app.css.gz": &fakeFileInfo{basename: "app.css.gz", modtime: fileMod, contents: "gzip contents"},
+		"/app.css.br": &fakeFileInfo{basename: "app.css.br", modtime: fileMod, contents: "br contents"},
+	}
+	ts := httptest.NewServer(&FileHandler{
+		Root:          fs,
+		Precompressed: true,
+		CachePolicy:   &CachePolicy{ETag: true},
+	})
+	defer ts.Close()
+
+	tests := []struct {
+		accept, range_ string
+		wantEncoding   string
+		wantBody       string
+	}{
+		{"gzip, br", "", "br", "br contents"},
+		{"gzip", "", "gzip", "gzip contents"},
+		{"br;q=0, gzip", "", "gzip", "gzip contents"},
+		{"gzip, br;q=0.5", "", "gzip", "gzip contents"},
+		{"br;q=0.5, identity", "", "", "plain contents"},
+		{"*", "", "br", "br contents"},
+		{"identity", "", "", "plain contents"},
+		{"gzip, br", "bytes=0-4", "", "plain"},
+	}
+	etags := make(map[string]string)
+	for _, tt := range tests {
+		req, _ := NewRequest("GET", ts.URL+"/app.css", nil)
+		req.Header.Set("Accept-Encoding", tt.accept)
+		if tt.range_ != "" {
+			req.Header.Set("Range", tt.range_)
+		}
+		res, err := DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g := res.Header.Get("Content-Encoding"); g != tt.wantEncoding {
+			t.Errorf("Accept-Encoding %q: Content-Encoding = %q; want %q", tt.accept, g, tt.wantEncoding)
+		}
+		if string(b) != tt.wantBody {
+			t.Errorf("Accept-Encoding %q: body = %q; want %q", tt.accept, b, tt.wantBody)
+		}
+		if g, e := res.Header.Get("Content-Type"), "text/css"; !strings.HasPrefix(g, e) {
+			t.Errorf("Accept-Encoding %q: Content-Type = %q; want %q", tt.accept, g, e)
+		}
+		if g := res.Header.Get("Vary"); g != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: Vary = %q; want Accept-Encoding", tt.accept, g)
+		}
+		if tt.range_ == "" {
+			if g, e := res.ContentLength, int64(len(tt.wantBody)); g != e {
+				t.Errorf("Accept-Encoding %q: Content-Length = %d; want %d", tt.accept, g, e)
+			}
+			etags[tt.wantEncoding] = res.Header.Get("Etag")
+		}
+	}
+	if len(etags) != 3 || etags["br"] == etags["gzip"] || etags["gzip"] == etags[""] {
+		t.Errorf("want distinct ETags per encoding; got %q", etags)
+	}
+}
+
+func TestFileServerFS(t *testing.T) {
+	defer afterTest(t)
+	fsys := fstest.MapFS{
+		"static/hello.txt": &fstest.MapFile{Data: []byte("hello, embedded world")},
+		"static/sub/a.txt": &fstest.MapFile{Data: []byte("a")},
+	}
+	mux := NewServeMux()
+	mux.Handle("/", FileServerFS(fsys))
+	mux.HandleFunc("/direct", func(w ResponseWriter, r *Request) {
+		ServeFileFS(w, r, fsys, "static/hello.txt")
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tests := []struct {
+		path, range_ string
+		wantCode     int
+		wantBody     string
+	}{
+		{"/static/hello.txt", "", StatusOK, "hello, embedded world"},
+		{"/static/hello.txt", "bytes=7-14", StatusPartialContent, "embedded"},
+		{"/direct", "", StatusOK, "hello, embedded world"},
+		{"/static/sub/", "", StatusOK, "<pre>\n<a href=\"a.txt\">a.txt</a>\n</pre>\n"},
+		{"/static/missing.txt", "", StatusNotFound, "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		req, _ := NewRequest("GET", ts.URL+tt.path, nil)
+		if tt.range_ != "" {
+			req.Header.Set("Range", tt.range_)
+		}
+		res, err := DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.wantCode {
+			t.Errorf("GET %s: status = %d; want %d", tt.path, res.StatusCode, tt.wantCode)
+		}
+		if string(b) != tt.wantBody {
+			t.Errorf("GET %s: body = %q; want %q", tt.path, b, tt.wantBody)
+		}
+	}
+}
+
+func TestFileServerDirList(t *testing.T) {
+	defer afterTest(t)
+	modtime := time.Unix(1000000000, 0).UTC()
+	fsys := fstest.MapFS{
+		"dir/b.txt":   &fstest.MapFile{Data: []byte("bb"), ModTime: modtime},
+		"dir/a<.txt":  &fstest.MapFile{Data: []byte("a"), ModTime: modtime},
+		"dir/sub/c.x": &fstest.MapFile{Data: []byte("c"), ModTime: modtime},
+	}
+	tmpl := template.Must(template.New("").Parse(
+		`{{.Path}}:{{range .Entries}} {{.Name}}{{end}}`))
+	mux := NewServeMux()
+	mux.Handle("/tmpl/", StripPrefix("/tmpl", &FileHandler{Root: FS(fsys), DirList: TemplateDirList(tmpl)}))
+	mux.Handle("/json/", StripPrefix("/json", &FileHandler{Root: FS(fsys), DirList: JSONDirList}))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	get := func(path string) (ctype, body string) {
+		res, err := Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Header.Get("Content-Type"), string(b)
+	}
+
+	ctype, body := get("/tmpl/dir/")
+	if want := "/dir/: a&lt;.txt b.txt sub"; body != want {
+		t.Errorf("template listing = %q; want %q", body, want)
+	}
+	if want := "text/html; charset=utf-8"; ctype != want {
+		t.Errorf("template listing Content-Type = %q; want %q", ctype, want)
+	}
+
+	ctype, body = get("/json/dir/")
+	if ctype != "application/json" {
+		t.Errorf("JSON listing Content-Type = %q; want application/json", ctype)
+	}
+	var entries []struct {
+		Name    string
+		Size    int64
+		ModTime time.Time
+		IsDir   bool
+	}
+	if err := json.Unmarshal([]byte(body), &entries); err != nil {
+		t.Fatalf("decoding %q: %v", body, err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries; want 3: %q", len(entries), body)
+	}
+	if e := entries[1]; e.Name != "b.txt" || e.Size != 2 || e.IsDir || !e.ModTime.Equal(modtime) {
+		t.Errorf("entry 1 = %+v; want b.txt of size 2", e)
+	}
+	if e := entries[2]; e.Name != "sub" || !e.IsDir {
+		t.Errorf("entry 2 = %+v; want directory sub", e)
+	}
+}
+
 func mustStat(t *testing.T, fileName string) os.FileInfo {
 	fi, err := os.Stat(fileName)
 	if err != nil {
@@ -593,9 +822,11 @@ func TestServeContent(t *testing.T) {
 		reqHeader        map[string]string
 		wantLastMod      string
 		wantContentType  string
+		wantContentRange string // optional
 		wantStatus       int
 	}
 	htmlModTime := mustStat(t, "testdata/index.html").ModTime()
+	styleSize := mustStat(t, "testdata/style.css").Size()
 	tests := map[string]testCase{
 		"no_last_modified": {
 			file:            "testdata/style.css",
@@ -663,6 +894,48 @@ func TestServeContent(t *testing.T) {
 			wantStatus:      200,
 			wantContentType: "text/css; charset=utf-8",
 		},
+		"range_with_weak_etag": {
+			file:      "testdata/style.css",
+			serveETag: `W/"A"`,
+			reqHeader: map[string]string{
+				"Range":    "bytes=0-4",
+				"If-Range": `W/"A"`,
+			},
+			wantStatus:      200,
+			wantContentType: "text/css; charset=utf-8",
+		},
+		"range_match_date": {
+			file:    "testdata/index.html",
+			modtime: htmlModTime,
+			reqHeader: map[string]string{
+				"Range":    "bytes=0-4",
+				"If-Range": htmlModTime.UTC().Format(TimeFormat),
+			},
+			wantStatus:       StatusPartialContent,
+			wantContentType:  "text/html; charset=utf-8",
+			wantContentRange: "bytes 0-4/22",
+			wantLastMod:      htmlModTime.UTC().Format(TimeFormat),
+		},
+		"range_no_match_date": {
+			file:    "testdata/index.html",
+			modtime: htmlModTime,
+			reqHeader: map[string]string{
+				"Range":    "bytes=0-4",
+				"If-Range": htmlModTime.Add(-time.Hour).UTC().Format(TimeFormat),
+			},
+			wantStatus:      200,
+			wantContentType: "text/html; charset=utf-8",
+			wantLastMod:     htmlModTime.UTC().Format(TimeFormat),
+		},
+		"range_unsatisfiable": {
+			file: "testdata/style.css",
+			reqHeader: map[string]string{
+				"Range": "bytes=100000-",
+			},
+			wantStatus:       StatusRequestedRangeNotSatisfiable,
+			wantContentType:  "text/plain; charset=utf-8",
+			wantContentRange: fmt.Sprintf("bytes */%d", styleSize),
+		},
 	}
 	for testName, tt := range tests {
 		var content io.ReadSeeker
@@ -706,6 +979,96 @@ func TestServeContent(t *testing.T) {
 		if g, e := res.Header.Get("Last-Modified"), tt.wantLastMod; g != e {
 			t.Errorf("test %q: last-modified = %q, want %q", testName, g, e)
 		}
+		if g, e := res.Header.Get("Content-Range"), tt.wantContentRange; e != "" && g != e {
+			t.Errorf("test %q: content-range = %q, want %q", testName, g, e)
+		}
+	}
+}
+
+func TestFileServerMaxRanges(t *testing.T) {
+	defer afterTest(t)
+	fs := fakeFS{
+		"/file": &fakeFileInfo{basename: "file", contents: "0123456789"},
+	}
+	ts := httptest.NewServer(&FileHandler{Root: fs, MaxRanges: 2})
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		ranges   string
+		wantCode int
+	}{
+		{"bytes=0-0,2-2", StatusPartialContent},
+		{"bytes=0-0,2-2,4-4", StatusOK},
+	} {
+		req, _ := NewRequest("GET", ts.URL+"/file", nil)
+		req.Header.Set("Range", tt.ranges)
+		res, err := DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.wantCode {
+			t.Errorf("Range %q: status = %d; want %d", tt.ranges, res.StatusCode, tt.wantCode)
+		}
+	}
+}
+
+// readFromCountingConn counts calls to its ReadFrom method.
+type readFromCountingConn struct {
+	*net.TCPConn
+	calls *int32
+}
+
+func (c readFromCountingConn) ReadFrom(r io.Reader) (int64, error) {
+	atomic.AddInt32(c.calls, 1)
+	return c.TCPConn.ReadFrom(r)
+}
+
+// preambleConn is a WrappedConn, like one produced by a listener
+// that strips a connection preamble.
+type preambleConn struct {
+	net.Conn
+	under net.Conn
+}
+
+func (c preambleConn) UnderlyingConn() net.Conn { return c.under }
+
+type preambleListener struct {
+	net.Listener
+	calls *int32
+}
+
+func (ln preambleListener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	rc := readFromCountingConn{c.(*net.TCPConn), ln.calls}
+	return preambleConn{Conn: struct{ net.Conn }{rc}, under: rc}, nil
+}
+
+func TestServeFileWrappedConnReadFrom(t *testing.T) {
+	defer afterTest(t)
+	var calls int32
+	ts := httptest.NewUnstartedServer(FileServer(Dir("testdata")))
+	ts.Listener = preambleListener{ts.Listener, &calls}
+	ts.Start()
+	defer ts.Close()
+
+	res, err := Get(ts.URL + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "0123456789\n" {
+		t.Errorf("body = %q", b)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("underlying ReadFrom called %d times; want 1", n)
 	}
 }
 
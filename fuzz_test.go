@@ -0,0 +1,97 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	. "net/http"
+	"testing"
+)
+
+func FuzzReadRequest(f *testing.F) {
+	for _, s := range []string{
+		"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"POST /upload HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\n\r\nabc",
+		"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n",
+		"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n",
+		"GET / HTTP/1.1\nHost: a\n X-Folded: b\n\n",
+		"GET http://example.com/x?y HTTP/1.0\r\nHost: a\r\nHost: b\r\n\r\n",
+		"CONNECT example.com:443 HTTP/1.1\r\n\r\n",
+	} {
+		f.Add([]byte(s))
+	}
+	strict := &Server{StrictParsing: true, HeaderPolicy: &HeaderPolicy{
+		RejectControl: true,
+		MaxNameBytes:  64,
+		MaxValueBytes: 256,
+		MaxHeaders:    16,
+		DuplicateHost: HostReject,
+	}}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		req, err := ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+		if err == nil {
+			io.Copy(ioutil.Discard, req.Body)
+		}
+		sreq, serr := strict.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+		if serr != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, sreq.Body)
+		// The strict parser only rejects more.
+		if err != nil {
+			t.Fatalf("accepted under StrictParsing but not by ReadRequest: %v", err)
+		}
+		if sreq.Method != req.Method || sreq.RequestURI != req.RequestURI || sreq.ContentLength != req.ContentLength {
+			t.Fatalf("parsed as %s %s (%d) and strictly as %s %s (%d)",
+				req.Method, req.RequestURI, req.ContentLength, sreq.Method, sreq.RequestURI, sreq.ContentLength)
+		}
+	})
+}
+
+func FuzzReadResponse(f *testing.F) {
+	for _, s := range []string{
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+		"HTTP/1.0 204 No Content\r\n\r\n",
+		"HTTP/1.1 301 Moved\r\nLocation: /\r\nConnection: close\r\n\r\nbody",
+	} {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		res, err := ReadResponse(bufio.NewReader(bytes.NewReader(data)), nil)
+		if err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+	})
+}
+
+func FuzzReadProxyLine(f *testing.F) {
+	for _, tt := range proxyHeaderTests {
+		f.Add([]byte(tt.header))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		line, err := ReadProxyLine(bufio.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			return
+		}
+		b, err := line.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes of %+v: %v", line, err)
+		}
+		line2, err := ReadProxyLine(bufio.NewReader(bytes.NewReader(b)))
+		if err != nil {
+			t.Fatalf("reading back %q: %v", b, err)
+		}
+		if got, want := fmt.Sprintf("%+v", line2), fmt.Sprintf("%+v", line); got != want {
+			t.Fatalf("read back %q as %s; want %s", b, got, want)
+		}
+	})
+}
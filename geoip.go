@@ -0,0 +1,70 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Client geolocation.
+
+package http
+
+import (
+	"context"
+	"net"
+	"strconv"
+)
+
+var geoKey = &contextKey{"geo"}
+
+// A GeoInfo describes where a client's IP address is.
+type GeoInfo struct {
+	Country      string // ISO 3166-1 alpha-2 code, such as "NZ", or ""
+	ASN          uint32 // number of the autonomous system announcing the address, or 0
+	Organization string // name of the autonomous system's organization, or ""
+}
+
+// A GeoIP locates IP addresses, typically with a database such as
+// MaxMind's, for a Server whose GeoIP field is set. Implementations
+// must be safe for concurrent use by multiple goroutines, and should
+// be fast, as they're called before each request is handled.
+type GeoIP interface {
+	// Lookup returns where ip is, or nil if it's unknown.
+	Lookup(ip net.IP) *GeoInfo
+}
+
+// GeoFromContext returns where the client of the request of ctx is,
+// as located by the Server's GeoIP, or nil if it's unknown.
+func GeoFromContext(ctx context.Context) *GeoInfo {
+	g, _ := ctx.Value(geoKey).(*GeoInfo)
+	return g
+}
+
+// String formats g as access log fields, such as
+// "country=NZ asn=64500".
+func (g *GeoInfo) String() string {
+	var s string
+	if g.Country != "" {
+		s = "country=" + g.Country
+	}
+	if g.ASN != 0 {
+		if s != "" {
+			s += " "
+		}
+		s += "asn=" + strconv.FormatUint(uint64(g.ASN), 10)
+	}
+	return s
+}
+
+// setGeo locates the client of w's request if the Server has GeoIP
+// set.
+func (c *conn) setGeo(w *response) {
+	geo := c.server.GeoIP
+	if geo == nil {
+		return
+	}
+	ip := clientIP(w.req)
+	if ip == nil {
+		return
+	}
+	if g := geo.Lookup(ip); g != nil {
+		w.req.ctx = context.WithValue(w.req.Context(), geoKey, g)
+	}
+}
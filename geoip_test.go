@@ -0,0 +1,87 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testGeoIP map[string]*GeoInfo
+
+func (g testGeoIP) Lookup(ip net.IP) *GeoInfo { return g[ip.String()] }
+
+func TestServerGeoIP(t *testing.T) {
+	defer afterTest(t)
+	logs := make(chan *AccessLogEntry, 10)
+	srv := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			if g := GeoFromContext(r.Context()); g != nil {
+				fmt.Fprintf(w, "%s %d %s", g.Country, g.ASN, g.Organization)
+			}
+		}),
+		GeoIP: testGeoIP{
+			"198.51.100.7": {Country: "NZ", ASN: 64500, Organization: "Example"},
+		},
+		AccessLog: func(e *AccessLogEntry) { logs <- e },
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go srv.ServeWithOptions(ln, ListenerOptions{WrapConn: ReadProxyHeader})
+
+	get := func(src string) string {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		fmt.Fprintf(c, "PROXY TCP4 %s 127.0.0.1 5555 80\r\nGET / HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n", src)
+		res, err := ReadResponse(bufio.NewReader(c), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		return string(b)
+	}
+
+	if got := get("198.51.100.7"); got != "NZ 64500 Example" {
+		t.Errorf("located client: body %q; want NZ 64500 Example", got)
+	}
+	select {
+	case e := <-logs:
+		if !strings.HasSuffix(e.String(), " country=NZ asn=64500") {
+			t.Errorf("access log %q doesn't end with where the client is", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no access log entry")
+	}
+	if got := get("203.0.113.1"); got != "" {
+		t.Errorf("unknown client: body %q; want none", got)
+	}
+	if e := <-logs; e.Geo != nil || strings.Contains(e.String(), "country=") {
+		t.Errorf("access log of unknown client: %q", e)
+	}
+}
+
+func TestAccessLogEntryGeo(t *testing.T) {
+	e := &AccessLogEntry{
+		Request: &Request{Method: "GET", RequestURI: "/", Proto: "HTTP/1.1", URL: &url.URL{Path: "/"}, Header: Header{}, RemoteAddr: "192.0.2.1:1"},
+		Start:   time.Date(2013, 3, 4, 5, 6, 7, 0, time.UTC),
+		Geo:     &GeoInfo{ASN: 64501},
+	}
+	if got := e.String(); !strings.HasSuffix(got, "0.000 asn=64501") {
+		t.Errorf("got %s; want it to end with asn=64501", got)
+	}
+}
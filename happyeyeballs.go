@@ -0,0 +1,161 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Dual-stack dialing, as RFC 8305 (Happy Eyeballs Version 2)
+// describes.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// defaultFallbackDelay is the Connection Attempt Delay recommended by
+// RFC 8305, section 5.
+const defaultFallbackDelay = 250 * time.Millisecond
+
+// errNoSuitableAddress is returned when the host resolves to no
+// addresses.
+var errNoSuitableAddress = errors.New("http: no suitable address found")
+
+// Replaced by tests.
+var (
+	lookupIPAddr = net.DefaultResolver.LookupIPAddr
+	dialAddr     = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+)
+
+// dialHappyEyeballs dials addr, a "host:port", without a Dial or
+// DialContext function. The addresses of the host are tried in turn,
+// IPv6 and IPv4 alternating, with a new attempt started each
+// FallbackDelay or when the last one fails. The first connection
+// made is returned, and the attempts still running are abandoned.
+func (t *Transport) dialHappyEyeballs(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d := t.DialTimeout; d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || network != "tcp" || net.ParseIP(host) != nil {
+		return dialAddr(ctx, network, addr)
+	}
+	ips, err := t.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := interleaveFamilies(ips, port)
+	if len(addrs) == 0 {
+		return nil, errNoSuitableAddress
+	}
+	if len(addrs) == 1 {
+		return dialAddr(ctx, network, addrs[0])
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type dialResult struct {
+		c   net.Conn
+		err error
+	}
+	results := make(chan dialResult, len(addrs))
+	next, running := 0, 0
+	start := func() {
+		addr := addrs[next]
+		next++
+		running++
+		go func() {
+			c, err := dialAddr(ctx, network, addr)
+			results <- dialResult{c, err}
+		}()
+	}
+
+	delay := t.FallbackDelay
+	if delay == 0 {
+		delay = defaultFallbackDelay
+	}
+	var timer *time.Timer
+	if delay > 0 {
+		timer = time.NewTimer(delay)
+		defer timer.Stop()
+	}
+	start()
+	var firstErr error
+	for {
+		var timerc <-chan time.Time
+		if timer != nil && next < len(addrs) {
+			timerc = timer.C
+		}
+		select {
+		case <-timerc:
+			start()
+			timer.Reset(delay)
+		case r := <-results:
+			running--
+			if r.err == nil {
+				// Close the connections of the attempts
+				// finishing after this one.
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.err == nil {
+							r.c.Close()
+						}
+					}
+				}(running)
+				return r.c, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(addrs) {
+				start()
+				if timer != nil {
+					timer.Reset(delay)
+				}
+			} else if running == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// resolve looks up the addresses of host with the Transport's
+// Resolver, if any.
+func (t *Transport) resolve(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if t.Resolver != nil {
+		return t.Resolver.LookupIPAddr(ctx, host)
+	}
+	return lookupIPAddr(ctx, host)
+}
+
+// interleaveFamilies returns the "host:port" addresses of ips and
+// port, in the order of ips but alternating between IPv6 and IPv4,
+// starting with IPv6, as RFC 8305, section 4, recommends.
+func interleaveFamilies(ips []net.IPAddr, port string) []string {
+	var v6, v4 []net.IPAddr
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	addrs := make([]string, 0, len(ips))
+	for len(v6) > 0 || len(v4) > 0 {
+		if len(v6) > 0 {
+			addrs = append(addrs, net.JoinHostPort(v6[0].String(), port))
+			v6 = v6[1:]
+		}
+		if len(v4) > 0 {
+			addrs = append(addrs, net.JoinHostPort(v4[0].String(), port))
+			v4 = v4[1:]
+		}
+	}
+	return addrs
+}
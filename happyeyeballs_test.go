@@ -0,0 +1,119 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// dualStackHooks resolves every host to ips and dials the test
+// server ts for 127.0.0.1. The IPv6 address 2001:db8::1 is
+// black-holed, until canceled, and other addresses refused.
+type dualStackHooks struct {
+	ips []net.IPAddr
+	ts  *httptest.Server
+
+	mu       sync.Mutex
+	dialed   []string
+	canceled []string
+}
+
+func (h *dualStackHooks) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return h.ips, nil
+}
+
+func (h *dualStackHooks) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(addr)
+	h.mu.Lock()
+	h.dialed = append(h.dialed, host)
+	h.mu.Unlock()
+	switch {
+	case host == "127.0.0.1":
+		return net.Dial(network, h.ts.Listener.Addr().String())
+	case host == "2001:db8::1":
+		<-ctx.Done()
+		h.mu.Lock()
+		h.canceled = append(h.canceled, host)
+		h.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	return nil, errors.New("connection refused")
+}
+
+func ipAddrs(ips ...string) (addrs []net.IPAddr) {
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return
+}
+
+func TestTransportHappyEyeballs(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	tests := []struct {
+		ips      []string
+		delay    time.Duration
+		dialed   []string
+		canceled []string
+	}{
+		// The IPv4 address is tried after the delay, and wins.
+		{[]string{"2001:db8::1", "127.0.0.1"}, 20 * time.Millisecond,
+			[]string{"2001:db8::1", "127.0.0.1"}, []string{"2001:db8::1"}},
+		// Families alternate, IPv6 first. A failed attempt has
+		// the next one start at once.
+		{[]string{"192.0.2.1", "127.0.0.1", "2001:db8::2"}, time.Hour,
+			[]string{"2001:db8::2", "192.0.2.1", "127.0.0.1"}, nil},
+		{[]string{"192.0.2.1", "127.0.0.1"}, -1,
+			[]string{"192.0.2.1", "127.0.0.1"}, nil},
+	}
+	for i, tt := range tests {
+		h := &dualStackHooks{ips: ipAddrs(tt.ips...), ts: ts}
+		restore := SetHappyEyeballsHooksForTesting(h.lookup, h.dial)
+		tr := &Transport{FallbackDelay: tt.delay}
+		c := &Client{Transport: tr, Timeout: 2 * time.Second}
+		res, err := c.Get("http://dualstack.test:" + port + "/")
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+		} else {
+			res.Body.Close()
+		}
+		tr.CloseIdleConnections()
+		time.Sleep(10 * time.Millisecond)
+		restore()
+		h.mu.Lock()
+		if !reflect.DeepEqual(h.dialed, tt.dialed) {
+			t.Errorf("%d: dialed %v; want %v", i, h.dialed, tt.dialed)
+		}
+		if !reflect.DeepEqual(h.canceled, tt.canceled) {
+			t.Errorf("%d: canceled %v; want %v", i, h.canceled, tt.canceled)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// A host without addresses fails to be dialed.
+func TestTransportHappyEyeballsNoAddrs(t *testing.T) {
+	h := &dualStackHooks{}
+	defer SetHappyEyeballsHooksForTesting(h.lookup, h.dial)()
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	if _, err := tr.RoundTrip(mustNewRequest(t, "GET", "http://dualstack.test/")); err == nil {
+		t.Fatal("RoundTrip to a host without addresses succeeded")
+	}
+	if len(h.dialed) != 0 {
+		t.Errorf("dialed %v; want none", h.dialed)
+	}
+}
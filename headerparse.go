@@ -0,0 +1,327 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Header parsing with few allocations.
+
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/textproto"
+	"sync"
+)
+
+// A headerReader reads the header or trailer of a request or response
+// as textproto.Reader's ReadMIMEHeader does, with fewer allocations:
+// the header block is copied into a buffer reused across messages,
+// which then becomes one string that the values and uncommon keys are
+// cut from. The keys of common headers are interned, and those of
+// others recently seen by the headerReader are cached. Holding on to
+// any value of the header thus holds on to all of it.
+type headerReader struct {
+	buf    []byte
+	fields []headerField
+	keys   map[string]string // cached canonical keys
+}
+
+// A headerField locates a key and value in a headerReader's buf.
+type headerField struct {
+	key    string // interned key, or "" for the key at buf[k0:k1]
+	k0, k1 int
+	v0, v1 int
+}
+
+const (
+	// maxPooledHeaderBuf is the capacity beyond which the buffer
+	// of a headerReader isn't kept for reuse, so that one large
+	// header doesn't pin its memory.
+	maxPooledHeaderBuf = 64 << 10
+
+	// A headerReader caches keys of up to maxCachedHeaderKeyLen
+	// bytes, forgetting them all once it has maxCachedHeaderKeys,
+	// so that what it keeps are those seen often.
+	maxCachedHeaderKeys   = 128
+	maxCachedHeaderKeyLen = 64
+)
+
+var headerReaderPool sync.Pool
+
+func newHeaderReader() *headerReader {
+	if v := headerReaderPool.Get(); v != nil {
+		return v.(*headerReader)
+	}
+	return new(headerReader)
+}
+
+func putHeaderReader(hr *headerReader) {
+	if cap(hr.buf) > maxPooledHeaderBuf {
+		return
+	}
+	hr.buf, hr.fields = hr.buf[:0], hr.fields[:0]
+	headerReaderPool.Put(hr)
+}
+
+// commonHeaderKeys interns the canonical keys of common headers.
+var commonHeaderKeys = make(map[string]string)
+
+func init() {
+	for _, k := range []string{
+		"Accept",
+		"Accept-Charset",
+		"Accept-Encoding",
+		"Accept-Language",
+		"Authorization",
+		"Cache-Control",
+		"Connection",
+		"Content-Encoding",
+		"Content-Length",
+		"Content-Type",
+		"Cookie",
+		"Date",
+		"Dnt",
+		"Expect",
+		"Forwarded",
+		"Host",
+		"Idempotency-Key",
+		"If-Match",
+		"If-Modified-Since",
+		"If-None-Match",
+		"If-Range",
+		"If-Unmodified-Since",
+		"Origin",
+		"Pragma",
+		"Proxy-Authorization",
+		"Range",
+		"Referer",
+		"Te",
+		"Trailer",
+		"Transfer-Encoding",
+		"Upgrade",
+		"Upgrade-Insecure-Requests",
+		"User-Agent",
+		"Via",
+		"X-Forwarded-For",
+		"X-Forwarded-Host",
+		"X-Forwarded-Proto",
+		"X-Real-Ip",
+		"X-Request-Id",
+		"X-Requested-With",
+	} {
+		commonHeaderKeys[k] = k
+	}
+}
+
+// readLine appends a line read from b to hr.buf, without its line
+// ending.
+func (hr *headerReader) readLine(b *bufio.Reader) error {
+	for {
+		l, more, err := b.ReadLine()
+		if err != nil {
+			return err
+		}
+		hr.buf = append(hr.buf, l...)
+		if !more {
+			return nil
+		}
+	}
+}
+
+// readFirstLine reads the request or status line from b.
+func (hr *headerReader) readFirstLine(b *bufio.Reader) (string, error) {
+	hr.buf = hr.buf[:0]
+	if err := hr.readLine(b); err != nil {
+		return "", err
+	}
+	return string(hr.buf), nil
+}
+
+// readHeader reads a header block from b, up to and including the
+// blank line ending it.
+func (hr *headerReader) readHeader(b *bufio.Reader) (Header, error) {
+	return hr.readHeaderInto(b, nil)
+}
+
+// readHeaderInto is readHeader adding the header to h, if non-nil, an
+// empty Header being reused.
+func (hr *headerReader) readHeaderInto(b *bufio.Reader, h Header) (Header, error) {
+	hr.buf, hr.fields = hr.buf[:0], hr.fields[:0]
+
+	// The first line cannot start with a leading space.
+	if p, err := b.Peek(1); err == nil && (p[0] == ' ' || p[0] == '\t') {
+		if err := hr.readLine(b); err != nil {
+			return nil, err
+		}
+		line := hr.buf
+		if len(line) > 80 {
+			line = line[:80]
+		}
+		return nil, textproto.ProtocolError(fmt.Sprintf("malformed MIME header initial line: %q", line))
+	}
+
+	for {
+		start := len(hr.buf)
+		if err := hr.readLine(b); err != nil {
+			return nil, err
+		}
+		if len(hr.buf) == start { // blank line
+			break
+		}
+		i, j := trimBounds(hr.buf[start:])
+		hr.buf = hr.buf[:start+j]
+		start += i
+		if bytes.IndexByte(hr.buf[start:], ':') < 0 {
+			return nil, textproto.ProtocolError(fmt.Sprintf("malformed MIME header: missing colon: %q", hr.buf[start:]))
+		}
+		hr.readContinuations(b)
+		if err := hr.addField(start); err != nil {
+			return nil, err
+		}
+	}
+
+	s := string(hr.buf)
+	if h == nil {
+		h = make(Header, len(hr.fields))
+	}
+	strs := make([]string, len(hr.fields))
+	for _, f := range hr.fields {
+		key := f.key
+		if key == "" {
+			key = s[f.k0:f.k1]
+		}
+		v := s[f.v0:f.v1]
+		if vv := h[key]; vv != nil {
+			h[key] = append(vv, v)
+			continue
+		}
+		// Most headers have one value: give each its own
+		// one-element slice of strs, extended by append if not.
+		vv := strs[:1:1]
+		strs = strs[1:]
+		vv[0] = v
+		h[key] = vv
+	}
+	return h, nil
+}
+
+// readContinuations appends to the line at the end of hr.buf the
+// continuation lines following it, those starting with a space or
+// tab, each trimmed and set off by a single space.
+func (hr *headerReader) readContinuations(b *bufio.Reader) {
+	for {
+		skipped := false
+		for {
+			p, err := b.Peek(1)
+			if err != nil || p[0] != ' ' && p[0] != '\t' {
+				break
+			}
+			b.ReadByte()
+			skipped = true
+		}
+		if !skipped {
+			return
+		}
+		hr.buf = append(hr.buf, ' ')
+		start := len(hr.buf)
+		if err := hr.readLine(b); err != nil {
+			hr.buf = hr.buf[:start]
+			return
+		}
+		i, j := trimBounds(hr.buf[start:])
+		n := copy(hr.buf[start:], hr.buf[start+i:start+j])
+		hr.buf = hr.buf[:start+n]
+	}
+}
+
+// addField records the header line at hr.buf[start:], canonicalizing
+// its key in place.
+func (hr *headerReader) addField(start int) error {
+	kv := hr.buf[start:]
+	c := bytes.IndexByte(kv, ':')
+	k, v := kv[:c], kv[c+1:]
+	f := headerField{k0: start, k1: start + c}
+	key, ok := hr.canonicalKey(k)
+	if !ok {
+		return textproto.ProtocolError(fmt.Sprintf("malformed MIME header line: %q", kv))
+	}
+	f.key = key
+	for _, b := range v {
+		if !validHeaderValueByte(b) {
+			return textproto.ProtocolError(fmt.Sprintf("malformed MIME header line: %q", kv))
+		}
+	}
+	f.v0, f.v1 = f.k1+1, len(hr.buf)
+	for f.v0 < f.v1 && (hr.buf[f.v0] == ' ' || hr.buf[f.v0] == '\t') {
+		f.v0++
+	}
+	hr.fields = append(hr.fields, f)
+	return nil
+}
+
+// canonicalKey canonicalizes the header key k in place, as
+// CanonicalHeaderKey does, returning its interned or cached form, if
+// any. A key with a space isn't canonicalized; ok is false for an
+// empty key or one with other bytes not allowed in a token.
+func (hr *headerReader) canonicalKey(k []byte) (key string, ok bool) {
+	if len(k) == 0 {
+		return "", false
+	}
+	canon := true
+	for _, c := range k {
+		if c == ' ' {
+			canon = false
+		} else if !isToken(rune(c)) {
+			return "", false
+		}
+	}
+	if !canon {
+		return "", true
+	}
+	upper := true
+	for i, c := range k {
+		if upper && 'a' <= c && c <= 'z' {
+			c -= 'a' - 'A'
+		} else if !upper && 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		k[i] = c
+		upper = c == '-'
+	}
+	// The compiler doesn't copy k for these lookups.
+	if key := commonHeaderKeys[string(k)]; key != "" {
+		return key, true
+	}
+	if len(k) > maxCachedHeaderKeyLen {
+		return "", true
+	}
+	if key := hr.keys[string(k)]; key != "" {
+		return key, true
+	}
+	if hr.keys == nil || len(hr.keys) >= maxCachedHeaderKeys {
+		hr.keys = make(map[string]string)
+	}
+	key = string(k)
+	hr.keys[key] = key
+	return key, true
+}
+
+// trimBounds returns the bounds of b with leading and trailing ASCII
+// white space removed.
+func trimBounds(b []byte) (i, j int) {
+	j = len(b)
+	for i < j && isASCIISpace(b[i]) {
+		i++
+	}
+	for j > i && isASCIISpace(b[j-1]) {
+		j--
+	}
+	return i, j
+}
+
+// validHeaderValueByte reports whether b may appear in a header
+// value: any byte but the controls other than tab.
+func validHeaderValueByte(b byte) bool {
+	return b >= 0x20 && b != 0x7f || b == '\t'
+}
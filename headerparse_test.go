@@ -0,0 +1,107 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	. "net/http"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var headerParseTests = []string{
+	"User-Agent: x\r\nAccept: */*\r\n\r\n",
+	"content-type: text/plain\r\nx-custom-HEADER:  v \r\n\r\n",
+	"X-Multi: a\r\nX-Multi: b\r\nx-multi: c\r\n\r\n",
+	"X-Folded: a\r\n  b\r\n\tc  \r\nAccept: d\r\n\r\n",
+	"X-Folded: a\r\n   \r\n\r\n",
+	"X-Space : a\r\nX Space: b\r\n\r\n",
+	"X-Empty:\r\nX-Tab:\ta\tb\r\n\r\n",
+	"X-Bare: a\nX-Lf: b\n\n",
+	"X-Obs-Text: caf\xc3\xa9\r\n\r\n",
+	" Leading: space\r\n\r\n",
+	"Missing colon\r\n\r\n",
+	": no key\r\n\r\n",
+	"X-Bad\x01Key: a\r\n\r\n",
+	"X-Bad-Value: a\x01b\r\n\r\n",
+	"X-Truncated: a\r\n",
+}
+
+// Request and response headers are read as textproto.Reader's
+// ReadMIMEHeader reads them.
+func TestReadHeaderAsTextproto(t *testing.T) {
+	for _, header := range headerParseTests {
+		want, wantErr := textproto.NewReader(bufio.NewReader(strings.NewReader(header))).ReadMIMEHeader()
+		req, err := ReadRequest(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n" + header)))
+		checkHeaderParse(t, "request", header, req, err, want, wantErr)
+		res, err := ReadResponse(bufio.NewReader(strings.NewReader("HTTP/1.1 204 No Content\r\n"+header)), nil)
+		checkHeaderParse(t, "response", header, res, err, want, wantErr)
+	}
+}
+
+func checkHeaderParse(t *testing.T, kind, header string, msg interface{}, err error, want textproto.MIMEHeader, wantErr error) {
+	if wantErr != nil {
+		if err == nil {
+			t.Errorf("%s %q: no error; want %v", kind, header, wantErr)
+		} else if wantErr.Error() != err.Error() && !(wantErr.Error() == "EOF" && err.Error() == "unexpected EOF") {
+			t.Errorf("%s %q: error %v; want %v", kind, header, err, wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Errorf("%s %q: %v", kind, header, err)
+		return
+	}
+	var got Header
+	switch m := msg.(type) {
+	case *Request:
+		got = m.Header
+	case *Response:
+		got = m.Header
+	}
+	if !reflect.DeepEqual(got, Header(want)) {
+		t.Errorf("%s %q: header %q; want %q", kind, header, got, want)
+	}
+}
+
+// Many distinct keys, more than are cached, are all read right.
+func TestReadRequestHeaderManyKeys(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		raw := fmt.Sprintf("GET / HTTP/1.1\r\nx-key-%d: %d\r\nX-KEY-%d: %d\r\n\r\n", i%300, i, i%300, i)
+		req, err := ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := fmt.Sprintf("X-Key-%d", i%300)
+		if got, want := req.Header[key], []string{fmt.Sprint(i), fmt.Sprint(i)}; len(req.Header) != 1 || !reflect.DeepEqual(got, want) {
+			t.Fatalf("request %d: header %q; want %s: %q", i, req.Header, key, want)
+		}
+	}
+}
+
+func TestReadRequestHeaderLong(t *testing.T) {
+	var b bytes.Buffer
+	b.WriteString("GET / HTTP/1.1\r\n")
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&b, "X-Header-%d: %s\r\n", i, strings.Repeat("v", i*100))
+	}
+	b.WriteString("\r\n")
+	for i := 0; i < 2; i++ {
+		req, err := ReadRequest(bufio.NewReaderSize(strings.NewReader(b.String()), 16))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(req.Header) != 100 {
+			t.Fatalf("read %d headers; want 100", len(req.Header))
+		}
+		if got := req.Header.Get("X-Header-99"); got != strings.Repeat("v", 9900) {
+			t.Errorf("X-Header-99 of %d bytes; want 9900", len(got))
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Liveness and readiness endpoints.
+
+package http
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+)
+
+// A HealthHandler serves liveness and readiness endpoints for load
+// balancers and orchestrators. A request whose path ends in "/live"
+// succeeds as long as the process can serve it. A request whose path
+// ends in "/ready" runs the registered checks and succeeds if they all
+// pass and Server, if set, is neither draining nor closed; otherwise it
+// fails with 503 Service Unavailable. The response body lists each
+// check's result. Other paths get 404.
+//
+// Health probes usually don't send PROXY protocol headers, so a
+// HealthHandler is typically served on a separate plain listener of
+// the same Server, with ServeWithOptions and the Handler option.
+type HealthHandler struct {
+	// Server, if non-nil, is the server whose draining (see Drain)
+	// and closing make the readiness check fail.
+	Server *Server
+
+	// Timeout, if positive, bounds how long the readiness checks
+	// may take; checks that haven't returned by then fail.
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	checks []healthCheck
+}
+
+type healthCheck struct {
+	name  string
+	check func() error
+}
+
+// AddCheck registers a readiness check, such as pinging a database,
+// reported under name. Checks run concurrently for each readiness
+// request; check returns a non-nil error when unhealthy.
+func (h *HealthHandler) AddCheck(name string, check func() error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, healthCheck{name, check})
+}
+
+func (h *HealthHandler) ServeHTTP(w ResponseWriter, r *Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	switch path.Base(r.URL.Path) {
+	case "live":
+		fmt.Fprintln(w, "ok")
+	case "ready":
+		body, ok := h.ready()
+		if !ok {
+			w.WriteHeader(StatusServiceUnavailable)
+		}
+		w.Write(body)
+	default:
+		NotFound(w, r)
+	}
+}
+
+var errHealthTimeout = errors.New("timed out")
+
+// ready runs the readiness checks, returning the report and whether
+// all of them passed.
+func (h *HealthHandler) ready() ([]byte, bool) {
+	h.mu.Lock()
+	checks := h.checks
+	h.mu.Unlock()
+
+	errs := make([]error, len(checks))
+	finished := make([]bool, len(checks))
+	var wg sync.WaitGroup
+	var mu sync.Mutex // guards errs and finished
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, check func() error) {
+			defer wg.Done()
+			err := check()
+			mu.Lock()
+			errs[i], finished[i] = err, true
+			mu.Unlock()
+		}(i, c.check)
+	}
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	if h.Timeout > 0 {
+		t := time.NewTimer(h.Timeout)
+		defer t.Stop()
+		select {
+		case <-done:
+		case <-t.C:
+		}
+	} else {
+		<-done
+	}
+
+	var buf bytes.Buffer
+	ok := true
+	if srv := h.Server; srv != nil {
+		switch {
+		case srv.isClosed():
+			ok = false
+			buf.WriteString("server: closed\n")
+		case srv.Draining():
+			ok = false
+			buf.WriteString("server: draining\n")
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i, c := range checks {
+		err := errs[i]
+		if !finished[i] {
+			err = errHealthTimeout
+		}
+		if err != nil {
+			ok = false
+			fmt.Fprintf(&buf, "%s: %v\n", c.name, err)
+		} else {
+			fmt.Fprintf(&buf, "%s: ok\n", c.name)
+		}
+	}
+	if ok && len(checks) == 0 {
+		buf.WriteString("ok\n")
+	}
+	return buf.Bytes(), ok
+}
@@ -0,0 +1,88 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	var dbErr error
+	h := &HealthHandler{Timeout: 50 * time.Millisecond}
+	h.AddCheck("db", func() error { return dbErr })
+	block := make(chan bool)
+	defer close(block)
+
+	check := func(path string, wantCode int, wantBody string) {
+		rw := httptest.NewRecorder()
+		req, _ := NewRequest("GET", "http://health"+path, nil)
+		h.ServeHTTP(rw, req)
+		if rw.Code != wantCode || !strings.Contains(rw.Body.String(), wantBody) {
+			t.Errorf("GET %s = %d %q; want %d containing %q", path, rw.Code, rw.Body, wantCode, wantBody)
+		}
+	}
+	check("/health/live", 200, "ok")
+	check("/health/ready", 200, "db: ok")
+	check("/health/other", 404, "not found")
+
+	dbErr = errors.New("connection refused")
+	check("/health/ready", 503, "db: connection refused")
+	check("/health/live", 200, "ok")
+
+	dbErr = nil
+	h.AddCheck("cache", func() error { <-block; return nil })
+	check("/health/ready", 503, "cache: timed out")
+}
+
+func TestHealthHandlerDraining(t *testing.T) {
+	defer afterTest(t)
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {})}
+	// The public listener expects PROXY headers; the health listener
+	// serves plain probes.
+	public, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	health, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeWithOptions(public, ListenerOptions{WrapConn: ReadProxyHeader})
+	go srv.ServeWithOptions(health, ListenerOptions{Handler: &HealthHandler{Server: srv}})
+
+	ready := func() (int, string) {
+		tr := &Transport{DisableKeepAlives: true}
+		defer tr.CloseIdleConnections()
+		res, err := (&Client{Transport: tr}).Get("http://" + health.Addr().String() + "/ready")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(b)
+	}
+	if code, body := ready(); code != 200 {
+		t.Errorf("before Drain: %d %q; want 200", code, body)
+	}
+	drained := make(chan bool)
+	go func() {
+		srv.Drain(100 * time.Millisecond)
+		close(drained)
+	}()
+	for !srv.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+	if code, body := ready(); code != 503 || !strings.Contains(body, "draining") {
+		t.Errorf("while draining: %d %q; want 503 draining", code, body)
+	}
+	<-drained
+}
@@ -0,0 +1,224 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Transport request hedging.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var errHedgeCanceled = errors.New("net/http: request canceled")
+
+// DefaultHedgeDelay is the default value of HedgePolicy's Delay.
+const DefaultHedgeDelay = 100 * time.Millisecond
+
+// A HedgePolicy specifies how a Transport hedges requests: when a
+// request has no response after a Delay, a copy of it is sent on
+// another connection, possibly to another host, and whichever response
+// arrives first is used, the other requests being canceled. This cuts
+// the tail latency of calls to replicated servers at the cost of some
+// duplicate work.
+//
+// Only requests that are safe to send again are hedged, as for
+// RetryPolicy. A request failing without a response has its next
+// hedge sent at once.
+type HedgePolicy struct {
+	// Delay is how long to wait for a response before sending each
+	// hedge, such as the 95th percentile latency of the server.
+	// If zero, DefaultHedgeDelay is used.
+	Delay time.Duration
+
+	// MaxHedges is the most copies sent of a request, besides the
+	// request itself. If zero, 1 is used.
+	MaxHedges int
+
+	// Hosts, if not empty, are the hosts, as host or host:port,
+	// that hedges are sent to in turn instead of the request's,
+	// such as the other replicas of the server. The Host header is
+	// still that of the request.
+	Hosts []string
+}
+
+func (p *HedgePolicy) delay() time.Duration {
+	if p.Delay <= 0 {
+		return DefaultHedgeDelay
+	}
+	return p.Delay
+}
+
+func (p *HedgePolicy) maxHedges() int {
+	if p.MaxHedges <= 0 {
+		return 1
+	}
+	return p.MaxHedges
+}
+
+// A hedgeState is the state of a request being hedged, for
+// CancelRequest.
+type hedgeState struct {
+	attempts []*Request
+	cancels  []context.CancelFunc // of the attempts' contexts
+	canceled bool
+}
+
+// attempt makes one attempt at the round trip of req, with retries.
+func (t *Transport) attempt(req *Request) (*Response, error) {
+	if t.Retry != nil {
+		return t.retryRoundTrip(req)
+	}
+	return t.roundTrip(req)
+}
+
+// hedgeRequest returns the request of attempt n at req, from 0, with
+// the context canceled by cancel, or nil if req was canceled.
+func (t *Transport) hedgeRequest(ctx context.Context, cancel context.CancelFunc, req *Request, n int, hs *hedgeState) (*Request, error) {
+	a := req.WithContext(ctx)
+	if n > 0 {
+		if req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			a.Body = body
+		}
+		if hosts := t.Hedge.Hosts; len(hosts) > 0 {
+			u := *req.URL
+			u.Host = hosts[(n-1)%len(hosts)]
+			a.URL = &u
+			if a.Host == "" {
+				a.Host = req.URL.Host
+			}
+		}
+	}
+	t.reqMu.Lock()
+	defer t.reqMu.Unlock()
+	if hs.canceled {
+		if n > 0 && a.Body != nil {
+			a.Body.Close()
+		}
+		return nil, nil
+	}
+	hs.attempts = append(hs.attempts, a)
+	hs.cancels = append(hs.cancels, cancel)
+	return a, nil
+}
+
+func (t *Transport) hedgedRoundTrip(req *Request) (*Response, error) {
+	p := t.Hedge
+	if !retryable(req) {
+		return t.attempt(req)
+	}
+	hs := new(hedgeState)
+	t.reqMu.Lock()
+	if t.hedging == nil {
+		t.hedging = make(map[*Request]*hedgeState)
+	}
+	t.hedging[req] = hs
+	t.reqMu.Unlock()
+	defer func() {
+		t.reqMu.Lock()
+		delete(t.hedging, req)
+		t.reqMu.Unlock()
+	}()
+
+	type result struct {
+		n   int
+		res *Response
+		err error
+	}
+	results := make(chan result, p.maxHedges()+1)
+	var attempts []*Request
+	var cancels []context.CancelFunc
+	start := func() error {
+		n := len(attempts)
+		ctx, cancel := context.WithCancel(req.Context())
+		a, err := t.hedgeRequest(ctx, cancel, req, n, hs)
+		if a == nil {
+			cancel()
+			if err == nil {
+				err = errHedgeCanceled
+			}
+			return err
+		}
+		attempts, cancels = append(attempts, a), append(cancels, cancel)
+		go func() {
+			res, err := t.attempt(a)
+			results <- result{n, res, err}
+		}()
+		return nil
+	}
+
+	if err := start(); err != nil {
+		return nil, err
+	}
+	pending := 1
+	timer := time.NewTimer(p.delay())
+	defer timer.Stop()
+	var err error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if start() == nil {
+				pending++
+			}
+			if len(attempts) <= p.maxHedges() {
+				timer.Reset(p.delay())
+			}
+		case r := <-results:
+			pending--
+			if r.err != nil {
+				err = r.err
+				cancels[r.n]()
+				if len(attempts) <= p.maxHedges() && start() == nil {
+					pending++
+					if !timer.Stop() {
+						select {
+						case <-timer.C:
+						default:
+						}
+					}
+					if len(attempts) <= p.maxHedges() {
+						timer.Reset(p.delay())
+					}
+				}
+				continue
+			}
+			for i, a := range attempts {
+				if i != r.n {
+					cancels[i]()
+					t.CancelRequest(a)
+				}
+			}
+			go func(pending int) {
+				// Close the bodies of the losers done anyway.
+				for ; pending > 0; pending-- {
+					if r := <-results; r.err == nil {
+						r.res.Body.Close()
+					}
+				}
+			}(pending)
+			r.res.Body = &cancelBody{r.res.Body, cancels[r.n]}
+			return r.res, nil
+		}
+	}
+	return nil, err
+}
+
+// cancelBody calls cancel, such as the CancelFunc of the context of a
+// request, when its response body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,146 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransportHedge(t *testing.T) {
+	defer afterTest(t)
+	var slowHits, fastHits int32
+	slow := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		atomic.AddInt32(&slowHits, 1)
+		if r.URL.Path != "/quick" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		atomic.AddInt32(&fastHits, 1)
+		ioutil.ReadAll(r.Body)
+		w.Write([]byte("fast " + r.Host))
+	}))
+	defer fast.Close()
+	slowHost := strings.TrimPrefix(slow.URL, "http://")
+
+	tr := &Transport{Hedge: &HedgePolicy{
+		Delay: 20 * time.Millisecond,
+		Hosts: []string{strings.TrimPrefix(fast.URL, "http://")},
+	}}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	do := func(method, path string) (string, time.Duration) {
+		req, _ := NewRequest(method, slow.URL+path, strings.NewReader("body"))
+		start := time.Now()
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return string(b), time.Since(start)
+	}
+
+	if body, d := do("PUT", "/"); body != "fast "+slowHost || d > 250*time.Millisecond {
+		t.Errorf("slow request: %q after %v; want the hedge's answer, with the request's Host", body, d)
+	}
+	if n := atomic.LoadInt32(&fastHits); n != 1 {
+		t.Errorf("%d hedges sent; want 1", n)
+	}
+
+	atomic.StoreInt32(&fastHits, 0)
+	if body, _ := do("GET", "/quick"); body != "slow" || atomic.LoadInt32(&fastHits) != 0 {
+		t.Errorf("quick request: %q, %d hedges; want slow and none", body, atomic.LoadInt32(&fastHits))
+	}
+
+	if body, _ := do("POST", "/"); body != "slow" || atomic.LoadInt32(&fastHits) != 0 {
+		t.Errorf("POST: %q, %d hedges; want no hedging", body, atomic.LoadInt32(&fastHits))
+	}
+}
+
+func TestTransportHedgeAfterFailure(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := ln.Addr().String()
+	ln.Close()
+
+	tr := &Transport{Hedge: &HedgePolicy{
+		Delay: time.Minute,
+		Hosts: []string{strings.TrimPrefix(ts.URL, "http://")},
+	}}
+	defer tr.CloseIdleConnections()
+	res, err := tr.RoundTrip(mustNewRequest(t, "GET", "http://"+dead+"/"))
+	if err != nil {
+		t.Fatalf("RoundTrip: %v; want the hedge's response", err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(b) != "ok" {
+		t.Errorf("body %q; want ok", b)
+	}
+
+	tr.Hedge.Hosts = []string{dead}
+	if _, err := tr.RoundTrip(mustNewRequest(t, "GET", "http://"+dead+"/")); err == nil {
+		t.Error("RoundTrip to dead hosts succeeded")
+	}
+}
+
+func TestTransportHedgeClientTimeout(t *testing.T) {
+	defer afterTest(t)
+	started := make(chan bool, 3)
+	unblock := make(chan bool)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		started <- true
+		<-unblock
+	}))
+	defer ts.Close()
+	defer close(unblock)
+	tr := &Transport{Hedge: &HedgePolicy{Delay: 10 * time.Millisecond, MaxHedges: 2}}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr, Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	_, err := c.Get(ts.URL)
+	d := time.Since(start)
+
+	// The server is closed only once the request and both hedges
+	// reached it.
+	for i := 0; i < 3; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d of 3 attempts reached the server", i)
+		}
+	}
+	if err == nil {
+		t.Fatal("Get succeeded; want a timeout")
+	}
+	if d > 2*time.Second {
+		t.Errorf("Get took %v; want the timeout to cancel the hedges", d)
+	}
+}
+
+func mustNewRequest(t *testing.T, method, url string) *Request {
+	req, err := NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
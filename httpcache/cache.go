@@ -0,0 +1,557 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package httpcache implements a private HTTP cache for clients, as
+// RFC 9111 describes, keeping responses in a pluggable Cache.
+//
+// A Transport wraps the RoundTripper of a Client:
+//
+//	c := &http.Client{Transport: &httpcache.Transport{Cache: new(httpcache.MemoryCache)}}
+//
+// Responses to GET requests are stored when their Cache-Control,
+// Expires or Last-Modified headers allow it, and served while fresh.
+// Stale ones are validated with If-None-Match and If-Modified-Since
+// requests, or served while validated in the background within their
+// stale-while-revalidate period, or instead of errors within their
+// stale-if-error period. Responses varying by request headers, as
+// named by Vary, are stored for each variant.
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FromCacheHeader is the header set to "1" on responses served from
+// the cache, validated or not.
+const FromCacheHeader = "X-From-Cache"
+
+// DefaultMaxBodyBytes is the MaxBodyBytes of Transports that don't set
+// it.
+const DefaultMaxBodyBytes = 10 << 20
+
+// A Transport is an http.RoundTripper caching the responses of another.
+type Transport struct {
+	// Transport makes the requests not answered from the cache. If
+	// nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	// Cache stores the responses.
+	Cache Cache
+
+	// MaxBodyBytes is the size of the largest response bodies
+	// stored. Responses are read whole before being returned, up
+	// to that size. If zero, DefaultMaxBodyBytes is used.
+	MaxBodyBytes int64
+
+	mu           sync.Mutex
+	revalidating map[string]bool // keys validated in the background
+}
+
+// An entry is a response stored in the Cache, in JSON.
+type entry struct {
+	StatusCode   int
+	Header       http.Header
+	Body         []byte
+	RequestTime  time.Time   // when the request was sent
+	ResponseTime time.Time   // when the response was received
+	Vary         http.Header // request header values named by Vary
+}
+
+func (t *Transport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (t *Transport) maxBodyBytes() int64 {
+	if t.MaxBodyBytes <= 0 {
+		return DefaultMaxBodyBytes
+	}
+	return t.MaxBodyBytes
+}
+
+// RoundTrip implements the RoundTrip method of the http.RoundTripper
+// interface.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.roundTrip(req, time.Now())
+}
+
+func (t *Transport) roundTrip(req *http.Request, now time.Time) (*http.Response, error) {
+	if req.Method != "GET" && req.Method != "" || req.Header.Get("Range") != "" {
+		res, err := t.transport().RoundTrip(req)
+		if err == nil && unsafeMethod(req.Method) && res.StatusCode < 400 {
+			t.invalidate(req, res)
+		}
+		return res, err
+	}
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-store"]; ok {
+		return t.transport().RoundTrip(req)
+	}
+	_, reqNoCache := reqCC["no-cache"]
+	if len(reqCC) == 0 && strings.EqualFold(req.Header.Get("Pragma"), "no-cache") {
+		reqNoCache = true
+	}
+
+	key := req.URL.String()
+	e := t.lookup(key, req)
+	if e != nil && !reqNoCache {
+		resCC := parseCacheControl(e.Header)
+		_, noCache := resCC["no-cache"]
+		_, mustRevalidate := resCC["must-revalidate"]
+		age, lifetime := e.age(now), e.freshnessLifetime()
+		if !noCache && e.fresh(reqCC, age, lifetime) {
+			return e.response(req, age), nil
+		}
+		if !noCache && !mustRevalidate {
+			stale := age - lifetime
+			if v, ok := reqCC["max-stale"]; ok {
+				if max, ok := seconds(v); !ok || stale <= max {
+					return e.response(req, age), nil
+				}
+			}
+			if max, ok := seconds(resCC["stale-while-revalidate"]); ok && stale <= max {
+				res := e.response(req, age)
+				t.revalidate(key, req, e)
+				return res, nil
+			}
+		}
+	}
+	if _, ok := reqCC["only-if-cached"]; ok {
+		return &http.Response{
+			Status:     "504 Gateway Timeout",
+			StatusCode: http.StatusGatewayTimeout,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}, nil
+	}
+
+	outreq := req
+	if e != nil && req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == "" {
+		outreq = e.conditional(req)
+	}
+	start := time.Now()
+	res, err := t.transport().RoundTrip(outreq)
+	responseTime := now.Add(time.Since(start))
+	if e != nil && (err != nil || res.StatusCode >= 500) && e.staleIfError(reqCC, e.age(responseTime)) {
+		if res != nil {
+			res.Body.Close()
+		}
+		return e.response(req, e.age(responseTime)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if outreq != req && res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		e.update(res.Header, now, responseTime)
+		t.store(key, e)
+		return e.response(req, e.age(responseTime)), nil
+	}
+	return t.save(key, req, res, now, responseTime), nil
+}
+
+// lookup returns the entry stored for key matching the headers of req,
+// or nil if there is none.
+func (t *Transport) lookup(key string, req *http.Request) *entry {
+	e := t.get(key)
+	if e == nil || e.matches(req) {
+		return e
+	}
+	if len(e.Vary) == 0 {
+		return nil
+	}
+	if e = t.get(variantKey(key, e.Vary, req.Header)); e != nil && e.matches(req) {
+		return e
+	}
+	return nil
+}
+
+func (t *Transport) get(key string) *entry {
+	b, ok := t.Cache.Get(key)
+	if !ok {
+		return nil
+	}
+	e := new(entry)
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil
+	}
+	return e
+}
+
+// store stores e for key and, if it varies, for its variant.
+func (t *Transport) store(key string, e *entry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	t.Cache.Set(key, b)
+	if len(e.Vary) > 0 {
+		t.Cache.Set(variantKey(key, e.Vary, e.Vary), b)
+	}
+}
+
+// save stores res, the response to req, if it may be, for key, and
+// returns it.
+func (t *Transport) save(key string, req *http.Request, res *http.Response, requestTime, responseTime time.Time) *http.Response {
+	if !storable(res) {
+		return res
+	}
+	var vary http.Header
+	for _, name := range varyNames(res.Header) {
+		if name == "*" {
+			return res
+		}
+		if vary == nil {
+			vary = make(http.Header)
+		}
+		vary[http.CanonicalHeaderKey(name)] = req.Header[http.CanonicalHeaderKey(name)]
+	}
+	max := t.maxBodyBytes()
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, max+1))
+	if err != nil || int64(len(body)) > max {
+		// Too big or cut short: pass it on as it is.
+		res.Body = &prefixBody{io.MultiReader(bytes.NewReader(body), res.Body), res.Body}
+		return res
+	}
+	res.Body.Close()
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	t.store(key, &entry{
+		StatusCode:   res.StatusCode,
+		Header:       cloneHeader(res.Header),
+		Body:         body,
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+		Vary:         vary,
+	})
+	return res
+}
+
+// revalidate validates e, the entry for key, in the background, with a
+// request like req.
+func (t *Transport) revalidate(key string, req *http.Request, e *entry) {
+	t.mu.Lock()
+	if t.revalidating[key] {
+		t.mu.Unlock()
+		return
+	}
+	if t.revalidating == nil {
+		t.revalidating = make(map[string]bool)
+	}
+	t.revalidating[key] = true
+	t.mu.Unlock()
+
+	// The request's own context ends with its response.
+	outreq := e.conditional(req.WithContext(context.Background()))
+	go func() {
+		defer func() {
+			t.mu.Lock()
+			delete(t.revalidating, key)
+			t.mu.Unlock()
+		}()
+		now := time.Now()
+		res, err := t.transport().RoundTrip(outreq)
+		if err != nil {
+			return
+		}
+		responseTime := time.Now()
+		if res.StatusCode == http.StatusNotModified {
+			res.Body.Close()
+			e.update(res.Header, now, responseTime)
+			t.store(key, e)
+			return
+		}
+		if res.StatusCode < 500 {
+			res = t.save(key, req, res, now, responseTime)
+		}
+		res.Body.Close()
+	}()
+}
+
+// invalidate drops the entries of the URLs that res, the response to
+// req with an unsafe method, may have changed.
+func (t *Transport) invalidate(req *http.Request, res *http.Response) {
+	t.Cache.Delete(req.URL.String())
+	for _, h := range []string{"Location", "Content-Location"} {
+		if u, err := req.URL.Parse(res.Header.Get(h)); err == nil && res.Header.Get(h) != "" && sameOrigin(u, req.URL) {
+			t.Cache.Delete(u.String())
+		}
+	}
+}
+
+func sameOrigin(a, b *url.URL) bool {
+	return a.Scheme == b.Scheme && strings.EqualFold(a.Host, b.Host)
+}
+
+func unsafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return false
+	}
+	return true
+}
+
+// storable reports whether res may be stored, before its Vary header
+// is considered.
+func storable(res *http.Response) bool {
+	cc := parseCacheControl(res.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	switch res.StatusCode {
+	case 200, 203, 204, 300, 301, 308, 404, 405, 410, 414, 501:
+		// Heuristically cacheable.
+		return true
+	case http.StatusPartialContent, http.StatusNotModified:
+		return false
+	}
+	_, maxAge := cc["max-age"]
+	return res.StatusCode >= 200 && (maxAge || res.Header.Get("Expires") != "")
+}
+
+// matches reports whether the request headers named by Vary of e are
+// those of req.
+func (e *entry) matches(req *http.Request) bool {
+	for name, values := range e.Vary {
+		if normalizeField(values) != normalizeField(req.Header[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// fresh reports whether e, at age, with its freshness lifetime, may be
+// served without validation for request directives cc.
+func (e *entry) fresh(cc map[string]string, age, lifetime time.Duration) bool {
+	if v, ok := cc["max-age"]; ok {
+		if max, ok := seconds(v); !ok || age > max {
+			return false
+		}
+	}
+	if v, ok := cc["min-fresh"]; ok {
+		if min, ok := seconds(v); ok {
+			lifetime -= min
+		}
+	}
+	return age < lifetime
+}
+
+func (e *entry) staleIfError(reqCC map[string]string, age time.Duration) bool {
+	resCC := parseCacheControl(e.Header)
+	if _, ok := resCC["must-revalidate"]; ok {
+		return false
+	}
+	stale := age - e.freshnessLifetime()
+	for _, cc := range []map[string]string{reqCC, resCC} {
+		if max, ok := seconds(cc["stale-if-error"]); ok && stale <= max {
+			return true
+		}
+	}
+	return false
+}
+
+// date returns when e was generated.
+func (e *entry) date() time.Time {
+	if d, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		return d
+	}
+	return e.ResponseTime
+}
+
+// freshnessLifetime returns how long e is fresh for, as RFC 9111,
+// Section 4.2.1 says.
+func (e *entry) freshnessLifetime() time.Duration {
+	cc := parseCacheControl(e.Header)
+	if v, ok := cc["max-age"]; ok {
+		d, _ := seconds(v)
+		return d
+	}
+	if v := e.Header.Get("Expires"); v != "" {
+		exp, err := http.ParseTime(v)
+		if err != nil {
+			return 0 // invalid dates are in the past
+		}
+		return exp.Sub(e.date())
+	}
+	if lm, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil {
+		switch e.StatusCode {
+		case 200, 203, 204, 300, 301, 308, 404, 405, 410, 414, 501:
+			if d := e.date().Sub(lm); d > 0 {
+				return d / 10
+			}
+		}
+	}
+	return 0
+}
+
+// age returns the age of e at now, as RFC 9111, Section 4.2.3 says.
+func (e *entry) age(now time.Time) time.Duration {
+	apparent := e.ResponseTime.Sub(e.date())
+	if apparent < 0 {
+		apparent = 0
+	}
+	ageValue, _ := seconds(e.Header.Get("Age"))
+	corrected := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	if corrected > apparent {
+		apparent = corrected
+	}
+	return apparent + now.Sub(e.ResponseTime)
+}
+
+// conditional returns a copy of req validating e.
+func (e *entry) conditional(req *http.Request) *http.Request {
+	etag, lm := e.Header.Get("ETag"), e.Header.Get("Last-Modified")
+	if etag == "" && lm == "" {
+		return req
+	}
+	r := new(http.Request)
+	*r = *req
+	r.Header = cloneHeader(req.Header)
+	if etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	if lm != "" {
+		r.Header.Set("If-Modified-Since", lm)
+	}
+	return r
+}
+
+// update freshens e with the header of a 304 Not Modified response to
+// a request validating it, as RFC 9111, Section 4.3.4 says.
+func (e *entry) update(h http.Header, requestTime, responseTime time.Time) {
+	for k, v := range h {
+		switch k {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Content-Range":
+			continue
+		}
+		e.Header[k] = v
+	}
+	e.RequestTime, e.ResponseTime = requestTime, responseTime
+}
+
+// response returns the response of e, at age, to req.
+func (e *entry) response(req *http.Request, age time.Duration) *http.Response {
+	h := cloneHeader(e.Header)
+	h.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	h.Set(FromCacheHeader, "1")
+	return &http.Response{
+		Status:        strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// parseCacheControl returns the directives of h's Cache-Control
+// header, by lower-case name.
+func parseCacheControl(h http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, line := range h["Cache-Control"] {
+		for _, d := range strings.Split(line, ",") {
+			d = strings.TrimSpace(d)
+			if d == "" {
+				continue
+			}
+			name, value := d, ""
+			if i := strings.IndexByte(d, '='); i >= 0 {
+				name, value = strings.TrimSpace(d[:i]), strings.Trim(strings.TrimSpace(d[i+1:]), `"`)
+			}
+			cc[strings.ToLower(name)] = value
+		}
+	}
+	return cc
+}
+
+// seconds parses a delta-seconds value.
+func seconds(v string) (time.Duration, bool) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	if n > 1<<31-1 {
+		n = 1<<31 - 1
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// varyNames returns the header names listed by h's Vary header.
+func varyNames(h http.Header) []string {
+	var names []string
+	for _, line := range h["Vary"] {
+		for _, name := range strings.Split(line, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// variantKey returns the key of the variant of the response for key
+// with the values of h for the names of vary.
+func variantKey(key string, vary, h http.Header) string {
+	names := make([]string, 0, len(vary))
+	for name := range vary {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	b.WriteString(key)
+	for _, name := range names {
+		b.WriteString("\x00" + name + ":" + normalizeField(h[name]))
+	}
+	return b.String()
+}
+
+// normalizeField returns the values of a header field as one, with
+// the whitespace around their elements removed.
+func normalizeField(values []string) string {
+	var elems []string
+	for _, v := range values {
+		for _, el := range strings.Split(v, ",") {
+			if el = strings.TrimSpace(el); el != "" {
+				elems = append(elems, el)
+			}
+		}
+	}
+	return strings.Join(elems, ",")
+}
+
+func cloneHeader(h http.Header) http.Header {
+	h2 := make(http.Header, len(h))
+	for k, v := range h {
+		h2[k] = append([]string(nil), v...)
+	}
+	return h2
+}
+
+// prefixBody is the body of a response too large to store, whose
+// start was read already.
+type prefixBody struct {
+	io.Reader
+	body io.Closer
+}
+
+func (b *prefixBody) Close() error { return b.body.Close() }
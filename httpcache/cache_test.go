@@ -0,0 +1,347 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpcache
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// cacheTest is a server counting its requests, behind a Transport.
+type cacheTest struct {
+	t  *testing.T
+	ts *httptest.Server
+	tr *Transport
+
+	mu   sync.Mutex
+	now  time.Time // the time of the request in flight, for Date
+	hits int
+	last *http.Request // the last request served
+}
+
+func newCacheTest(t *testing.T, h http.HandlerFunc) *cacheTest {
+	ct := &cacheTest{t: t}
+	ct.ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ct.mu.Lock()
+		ct.hits++
+		ct.last = r
+		if !ct.now.IsZero() {
+			w.Header().Set("Date", ct.now.UTC().Format(http.TimeFormat))
+		}
+		ct.mu.Unlock()
+		h(w, r)
+	}))
+	ct.tr = &Transport{Cache: new(MemoryCache)}
+	return ct
+}
+
+func (ct *cacheTest) close() {
+	ct.ts.Close()
+}
+
+func (ct *cacheTest) serverHits() int {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.hits
+}
+
+// get sends a request for path, with hdrs as "Name: value" lines, at
+// now, returning the response and its body.
+func (ct *cacheTest) get(path string, now time.Time, hdrs ...string) (res *http.Response, body string) {
+	req, _ := http.NewRequest("GET", ct.ts.URL+path, nil)
+	for _, kv := range hdrs {
+		i := strings.Index(kv, ": ")
+		req.Header.Add(kv[:i], kv[i+2:])
+	}
+	ct.mu.Lock()
+	ct.now = now
+	ct.mu.Unlock()
+	res, err := ct.tr.roundTrip(req, now)
+	if err != nil {
+		ct.t.Fatalf("GET %s: %v", path, err)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		ct.t.Fatalf("GET %s: reading body: %v", path, err)
+	}
+	return res, string(b)
+}
+
+func (ct *cacheTest) lastRequest() *http.Request {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.last
+}
+
+func fromCache(res *http.Response) bool {
+	return res.Header.Get(FromCacheHeader) == "1"
+}
+
+func TestTransportFresh(t *testing.T) {
+	var ct *cacheTest
+	ct = newCacheTest(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(strings.Repeat("x", ct.serverHits())))
+	})
+	defer ct.close()
+	now := time.Now()
+	if res, body := ct.get("/", now); fromCache(res) || body != "x" {
+		t.Fatalf("first GET: from cache %v, body %q", fromCache(res), body)
+	}
+	res, body := ct.get("/", now.Add(30*time.Second))
+	if !fromCache(res) || body != "x" || res.Header.Get("Age") != "30" {
+		t.Errorf("fresh GET: from cache %v, body %q, Age %q; want cached x with Age 30", fromCache(res), body, res.Header.Get("Age"))
+	}
+	if res, _ := ct.get("/", now, "Cache-Control: no-cache"); fromCache(res) {
+		t.Error("GET with no-cache served from cache")
+	}
+	if res, _ := ct.get("/", now.Add(10*time.Second), "Cache-Control: max-age=5"); fromCache(res) {
+		t.Error("GET with max-age=5 served a response 10s old")
+	}
+	if res, body := ct.get("/", now.Add(2*time.Minute)); fromCache(res) || body != "xxxx" {
+		t.Errorf("stale GET: from cache %v, body %q; want xxxx from the server", fromCache(res), body)
+	}
+	if res, _ := ct.get("/", now.Add(3*time.Minute), "Cache-Control: max-stale"); !fromCache(res) {
+		t.Error("GET with max-stale not served from cache")
+	}
+	if got := ct.serverHits(); got != 4 {
+		t.Errorf("server hits = %d; want 4", got)
+	}
+}
+
+func TestTransportValidation(t *testing.T) {
+	ct := newCacheTest(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=10")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.Header().Set("X-Validated", "yes")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("body"))
+	})
+	defer ct.close()
+	now := time.Now()
+	ct.get("/", now)
+	res, body := ct.get("/", now.Add(time.Minute))
+	if !fromCache(res) || body != "body" || res.StatusCode != 200 {
+		t.Fatalf("validated GET: from cache %v, %d %q; want cached 200 body", fromCache(res), res.StatusCode, body)
+	}
+	if ct.lastRequest().Header.Get("If-None-Match") != `"v1"` {
+		t.Errorf("validation If-None-Match = %q", ct.lastRequest().Header.Get("If-None-Match"))
+	}
+	if res.Header.Get("X-Validated") != "yes" {
+		t.Error("headers of 304 response not merged")
+	}
+	// Freshened by the 304.
+	if res, _ := ct.get("/", now.Add(65*time.Second)); !fromCache(res) || ct.serverHits() != 2 {
+		t.Errorf("GET after validation: from cache %v, %d server hits; want cached, 2", fromCache(res), ct.serverHits())
+	}
+}
+
+func TestTransportHeuristic(t *testing.T) {
+	ct := newCacheTest(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", time.Now().Add(-100*time.Minute).UTC().Format(http.TimeFormat))
+		w.Write([]byte("old"))
+	})
+	defer ct.close()
+	now := time.Now()
+	ct.get("/", now)
+	if res, _ := ct.get("/", now.Add(5*time.Minute)); !fromCache(res) {
+		t.Error("GET within 10% of the age of Last-Modified not served from cache")
+	}
+	ct.get("/", now.Add(15*time.Minute))
+	if ct.lastRequest().Header.Get("If-Modified-Since") == "" {
+		t.Error("stale GET sent no If-Modified-Since")
+	}
+}
+
+func TestTransportVary(t *testing.T) {
+	ct := newCacheTest(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte("lang=" + r.Header.Get("Accept-Language")))
+	})
+	defer ct.close()
+	now := time.Now()
+	ct.get("/", now, "Accept-Language: en")
+	ct.get("/", now, "Accept-Language: fr")
+	for _, lang := range []string{"en", "fr"} {
+		res, body := ct.get("/", now.Add(time.Second), "Accept-Language: "+lang)
+		if !fromCache(res) || body != "lang="+lang {
+			t.Errorf("GET for %s: from cache %v, body %q", lang, fromCache(res), body)
+		}
+	}
+	if res, body := ct.get("/", now, "Accept-Language: de"); fromCache(res) || body != "lang=de" {
+		t.Errorf("GET for another variant: from cache %v, body %q", fromCache(res), body)
+	}
+}
+
+func TestTransportNotStored(t *testing.T) {
+	ct := newCacheTest(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store, max-age=60")
+		case "/vary-star":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "*")
+		case "/plain":
+			// No freshness or validators.
+		case "/big":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte(strings.Repeat("x", 100)))
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	defer ct.close()
+	ct.tr.MaxBodyBytes = 50
+	now := time.Now()
+	for _, path := range []string{"/no-store", "/vary-star", "/plain", "/big"} {
+		ct.get(path, now)
+		res, body := ct.get(path, now)
+		if fromCache(res) {
+			t.Errorf("GET %s served from cache", path)
+		}
+		if path == "/big" && len(body) != 100 {
+			t.Errorf("GET /big: body of %d bytes; want 100", len(body))
+		}
+	}
+}
+
+func TestTransportStaleWhileRevalidate(t *testing.T) {
+	var mu sync.Mutex
+	version := "v1"
+	ct := newCacheTest(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Cache-Control", "max-age=1, stale-while-revalidate=60")
+		w.Write([]byte(version))
+	})
+	defer ct.close()
+	now := time.Now()
+	ct.get("/", now)
+	mu.Lock()
+	version = "v2"
+	mu.Unlock()
+
+	res, body := ct.get("/", now.Add(10*time.Second))
+	if !fromCache(res) || body != "v1" {
+		t.Fatalf("GET in stale-while-revalidate: from cache %v, body %q; want stale v1", fromCache(res), body)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ct.tr.mu.Lock()
+		done := len(ct.tr.revalidating) == 0
+		ct.tr.mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background validation didn't finish")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if res, body := ct.get("/", time.Now()); !fromCache(res) || body != "v2" {
+		t.Errorf("GET after validation: from cache %v, body %q; want cached v2", fromCache(res), body)
+	}
+	if got := ct.serverHits(); got != 2 {
+		t.Errorf("server hits = %d; want 2", got)
+	}
+	if res, _ := ct.get("/", time.Now().Add(2*time.Minute)); fromCache(res) {
+		t.Error("GET past stale-while-revalidate served from cache")
+	}
+}
+
+type errorTransport struct{}
+
+func (errorTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("unreachable")
+}
+
+func TestTransportStaleIfError(t *testing.T) {
+	var mu sync.Mutex
+	fail := false
+	ct := newCacheTest(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=1, stale-if-error=60")
+		w.Write([]byte("ok"))
+	})
+	defer ct.close()
+	now := time.Now()
+	ct.get("/", now)
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	if res, body := ct.get("/", now.Add(30*time.Second)); !fromCache(res) || res.StatusCode != 200 || body != "ok" {
+		t.Errorf("GET on 503: from cache %v, %d %q; want stale ok", fromCache(res), res.StatusCode, body)
+	}
+	if res, _ := ct.get("/", now.Add(2*time.Minute)); res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("GET on 503 past stale-if-error: %d; want 503", res.StatusCode)
+	}
+
+	ct.tr.Transport = errorTransport{}
+	if res, _ := ct.get("/", now.Add(30*time.Second)); !fromCache(res) {
+		t.Error("GET on transport error not served from cache")
+	}
+}
+
+func TestTransportInvalidate(t *testing.T) {
+	ct := newCacheTest(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.Header().Set("Location", "/other")
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("ok"))
+	})
+	defer ct.close()
+	now := time.Now()
+	ct.get("/", now)
+	ct.get("/other", now)
+	req, _ := http.NewRequest("POST", ct.ts.URL+"/", strings.NewReader("x"))
+	res, err := ct.tr.roundTrip(req, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	for _, path := range []string{"/", "/other"} {
+		if res, _ := ct.get(path, now); fromCache(res) {
+			t.Errorf("GET %s after POST served from cache", path)
+		}
+	}
+}
+
+func TestTransportOnlyIfCached(t *testing.T) {
+	ct := newCacheTest(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("ok"))
+	})
+	defer ct.close()
+	now := time.Now()
+	if res, _ := ct.get("/", now, "Cache-Control: only-if-cached"); res.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("only-if-cached GET of nothing: %d; want 504", res.StatusCode)
+	}
+	ct.get("/", now)
+	if res, _ := ct.get("/", now, "Cache-Control: only-if-cached"); !fromCache(res) || res.StatusCode != 200 {
+		t.Errorf("only-if-cached GET: from cache %v, %d; want cached 200", fromCache(res), res.StatusCode)
+	}
+	if got := ct.serverHits(); got != 1 {
+		t.Errorf("server hits = %d; want 1", got)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpcache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// A Cache stores the responses of a Transport by key. Entries are
+// opaque to it.
+//
+// Implementations of Cache, such as ones keeping responses on disk or
+// in an external store, must be safe for concurrent use by multiple
+// goroutines. They may drop entries at any time.
+type Cache interface {
+	// Get returns the entry stored for key, or false if there is
+	// none.
+	Get(key string) (entry []byte, ok bool)
+
+	// Set stores entry for key, replacing any stored before.
+	Set(key string, entry []byte)
+
+	// Delete deletes the entry for key, if any.
+	Delete(key string)
+}
+
+// A MemoryCache is a Cache keeping entries in memory. The zero value
+// is an empty cache without a size limit, ready to use.
+type MemoryCache struct {
+	// MaxBytes, if positive, limits the total size of the entries,
+	// those used least recently being dropped to make room.
+	MaxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // of *memoryEntry
+	lru     list.List                // most recently used first
+	size    int64
+}
+
+type memoryEntry struct {
+	key   string
+	entry []byte
+}
+
+// Get implements the Get method of the Cache interface.
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*memoryEntry).entry, true
+}
+
+// Set implements the Set method of the Cache interface.
+func (c *MemoryCache) Set(key string, entry []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.MaxBytes > 0 && int64(len(entry)) > c.MaxBytes {
+		c.deleteLocked(key)
+		return
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+	}
+	if el, ok := c.entries[key]; ok {
+		me := el.Value.(*memoryEntry)
+		c.size += int64(len(entry) - len(me.entry))
+		me.entry = entry
+		c.lru.MoveToFront(el)
+	} else {
+		c.entries[key] = c.lru.PushFront(&memoryEntry{key, entry})
+		c.size += int64(len(entry))
+	}
+	for c.MaxBytes > 0 && c.size > c.MaxBytes {
+		c.deleteLocked(c.lru.Back().Value.(*memoryEntry).key)
+	}
+}
+
+// Delete implements the Delete method of the Cache interface.
+func (c *MemoryCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deleteLocked(key)
+}
+
+func (c *MemoryCache) deleteLocked(key string) {
+	el, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	c.lru.Remove(el)
+	delete(c.entries, key)
+	c.size -= int64(len(el.Value.(*memoryEntry).entry))
+}
+
+// Len returns the number of entries in c.
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
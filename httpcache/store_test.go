@@ -0,0 +1,35 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpcache
+
+import "testing"
+
+func TestMemoryCache(t *testing.T) {
+	c := &MemoryCache{MaxBytes: 10}
+	c.Set("a", []byte("1234"))
+	c.Set("b", []byte("5678"))
+	if e, ok := c.Get("a"); !ok || string(e) != "1234" {
+		t.Fatalf("Get(a) = %q, %v", e, ok)
+	}
+	c.Set("c", []byte("90")) // 10 bytes, at the limit
+	if c.Len() != 3 {
+		t.Fatalf("Len = %d; want 3", c.Len())
+	}
+	c.Set("d", []byte("x")) // drops b, the least recently used
+	if _, ok := c.Get("b"); ok {
+		t.Error("b kept over MaxBytes")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("a, used recently, dropped")
+	}
+	c.Set("a", []byte("too long an entry"))
+	if _, ok := c.Get("a"); ok {
+		t.Error("entry longer than MaxBytes stored")
+	}
+	c.Delete("c")
+	if _, ok := c.Get("c"); ok || c.Len() != 1 {
+		t.Errorf("after Delete: Len %d", c.Len())
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Servers behind proxies speaking the PROXY protocol.
+
+package httptest
+
+import (
+	"net"
+	"net/http"
+)
+
+// NewProxyServer starts and returns a new Server reading the PROXY
+// protocol header, as a server behind a load balancer does, at the
+// start of each connection. Clients must send the header; those of
+// ProxyClient do.
+// The caller should call Close when finished, to shut it down.
+func NewProxyServer(handler http.Handler) *Server {
+	ts := NewUnstartedServer(handler)
+	ts.StartProxy()
+	return ts
+}
+
+// StartProxy starts a server from NewUnstartedServer, reading the
+// PROXY protocol header at the start of each connection with
+// http.ReadProxyHeader.
+func (s *Server) StartProxy() {
+	if s.URL != "" {
+		panic("Server already started")
+	}
+	s.Listener = &historyListener{Listener: s.Listener}
+	s.URL = "http://" + s.Listener.Addr().String()
+	s.wrapHandler()
+	go s.Config.ServeWithOptions(s.Listener, http.ListenerOptions{WrapConn: http.ReadProxyHeader})
+}
+
+// ProxyClient returns a Client whose connections start with a
+// version 1 PROXY protocol header reporting that they come from src,
+// for servers started with StartProxy. Close closes its idle
+// connections.
+func (s *Server) ProxyClient(src *net.TCPAddr) *http.Client {
+	tr := &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			c, err := net.Dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := c.Write(proxyHeader(src, c.RemoteAddr())); err != nil {
+				c.Close()
+				return nil, err
+			}
+			return c, nil
+		},
+	}
+	s.mu.Lock()
+	s.transports = append(s.transports, tr)
+	s.mu.Unlock()
+	return &http.Client{Transport: tr}
+}
+
+// proxyHeader returns the version 1 PROXY protocol header of a
+// connection from src to dst.
+func proxyHeader(src *net.TCPAddr, dst net.Addr) []byte {
+	if d, ok := dst.(*net.TCPAddr); !ok || (d.IP.To4() == nil) != (src.IP.To4() == nil) {
+		dst = src
+	}
+	line := &http.ProxyLine{Version: 1, Source: src, Destination: dst}
+	b, _ := line.Bytes() // version 1 headers have no TLVs to overflow
+	return b
+}
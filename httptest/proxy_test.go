@@ -0,0 +1,63 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httptest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestProxyServer(t *testing.T) {
+	ts := NewProxyServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProxyLine == nil {
+			fmt.Fprint(w, "no PROXY header")
+			return
+		}
+		fmt.Fprintf(w, "v%d %s %s", r.ProxyLine.Version, r.ProxyLine.Source, r.RemoteAddr)
+	}))
+	defer ts.Close()
+
+	src := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 4711}
+	res, err := ts.ProxyClient(src).Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if want := "v1 203.0.113.7:4711 203.0.113.7:4711"; string(b) != want {
+		t.Errorf("got %q; want %q", b, want)
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	req := NewRequest("", "https://example.org/a?b=c", nil)
+	if req.Method != "GET" || req.Host != "example.org" || req.URL.Path != "/a" || req.TLS == nil {
+		t.Errorf("got %s %s %v, TLS %v", req.Method, req.Host, req.URL, req.TLS)
+	}
+	if req.RemoteAddr != "192.0.2.1:1234" || req.ProxyLine != nil {
+		t.Errorf("RemoteAddr %q, ProxyLine %v", req.RemoteAddr, req.ProxyLine)
+	}
+
+	line := &http.ProxyLine{
+		Version:     2,
+		Source:      &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 4711},
+		Destination: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443},
+	}
+	req = NewProxyRequest("POST", "/upload", nil, line)
+	if req.Host != "example.com" || req.TLS != nil || req.ProxyLine != line || req.RemoteAddr != "[2001:db8::7]:4711" {
+		t.Errorf("got Host %q, TLS %v, ProxyLine %v, RemoteAddr %q", req.Host, req.TLS, req.ProxyLine, req.RemoteAddr)
+	}
+
+	rec := NewRecorder()
+	http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.ProxyLine.Destination)
+	}).ServeHTTP(rec, req)
+	if rec.Body.String() != "[2001:db8::1]:443" {
+		t.Errorf("handler saw %q", rec.Body)
+	}
+}
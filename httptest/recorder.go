@@ -6,19 +6,50 @@
 package httptest
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 // ResponseRecorder is an implementation of http.ResponseWriter that
-// records its mutations for later inspection in tests.
+// records its mutations for later inspection in tests. It also
+// implements http.Flusher and http.Hijacker.
 type ResponseRecorder struct {
 	Code      int           // the HTTP response code from WriteHeader
 	HeaderMap http.Header   // the HTTP response headers
 	Body      *bytes.Buffer // if non-nil, the bytes.Buffer to append written data to
 	Flushed   bool
 
+	// Interim records the informational (1xx) responses written
+	// before the final one, except 101 Switching Protocols, which
+	// is final.
+	Interim []InterimResponse
+
+	// Hijackable, if true, has Hijack succeed, setting Hijacked and
+	// ClientConn. Otherwise Hijack fails.
+	Hijackable bool
+	Hijacked   bool
+
+	// ClientConn is, after Hijack, the client's end of the
+	// connection handed to the handler. The two ends are
+	// synchronous, as with net.Pipe, so a handler writing to its
+	// end blocks until the test reads from ClientConn.
+	ClientConn net.Conn
+
 	wroteHeader bool
+	snapHeader  http.Header // HeaderMap when the final header was written
+}
+
+// An InterimResponse is an informational (1xx) response recorded by a
+// ResponseRecorder.
+type InterimResponse struct {
+	Code   int
+	Header http.Header
 }
 
 // NewRecorder returns an initialized ResponseRecorder.
@@ -44,8 +75,11 @@ func (rw *ResponseRecorder) Header() http.Header {
 	return m
 }
 
-// Write always succeeds and writes to rw.Body, if not nil.
+// Write writes to rw.Body, if not nil. It fails only after Hijack.
 func (rw *ResponseRecorder) Write(buf []byte) (int, error) {
+	if rw.Hijacked {
+		return 0, http.ErrHijacked
+	}
 	if !rw.wroteHeader {
 		rw.WriteHeader(200)
 	}
@@ -55,12 +89,19 @@ func (rw *ResponseRecorder) Write(buf []byte) (int, error) {
 	return len(buf), nil
 }
 
-// WriteHeader sets rw.Code.
+// WriteHeader sets rw.Code, or records an interim response for an
+// informational code.
 func (rw *ResponseRecorder) WriteHeader(code int) {
-	if !rw.wroteHeader {
-		rw.Code = code
+	if rw.wroteHeader || rw.Hijacked {
+		return
 	}
+	if code >= 100 && code <= 199 && code != http.StatusSwitchingProtocols {
+		rw.Interim = append(rw.Interim, InterimResponse{code, cloneHeader(rw.HeaderMap)})
+		return
+	}
+	rw.Code = code
 	rw.wroteHeader = true
+	rw.snapHeader = cloneHeader(rw.HeaderMap)
 }
 
 // Flush sets rw.Flushed to true.
@@ -70,3 +111,88 @@ func (rw *ResponseRecorder) Flush() {
 	}
 	rw.Flushed = true
 }
+
+// Hijack implements the http.Hijacker interface if rw.Hijackable is
+// set, returning the handler's end of a connection whose other end
+// is rw.ClientConn.
+func (rw *ResponseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if !rw.Hijackable {
+		return nil, nil, errors.New("httptest: ResponseRecorder not Hijackable")
+	}
+	if rw.Hijacked {
+		return nil, nil, http.ErrHijacked
+	}
+	rw.Hijacked = true
+	server, client := net.Pipe()
+	rw.ClientConn = client
+	return server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), nil
+}
+
+// Result returns the response the handler wrote: its final status,
+// its header as it was when the status was written, its body and the
+// trailers it declared in the "Trailer" header, with their values as
+// they are when Result is called. Result should be called once the
+// handler has returned.
+func (rw *ResponseRecorder) Result() *http.Response {
+	if !rw.wroteHeader {
+		rw.WriteHeader(200)
+	}
+	res := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		StatusCode: rw.Code,
+		Status:     strconv.Itoa(rw.Code) + " " + http.StatusText(rw.Code),
+		Header:     rw.snapHeader,
+	}
+	if res.Header == nil {
+		res.Header = make(http.Header)
+	}
+	var body []byte
+	if rw.Body != nil {
+		body = rw.Body.Bytes()
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	res.ContentLength = -1
+	if cl := res.Header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil {
+			res.ContentLength = n
+		}
+	}
+	for _, v := range rw.snapHeader["Trailer"] {
+		for _, k := range strings.Split(v, ",") {
+			k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+			if vv, ok := rw.HeaderMap[k]; ok && k != "" {
+				if res.Trailer == nil {
+					res.Trailer = make(http.Header)
+				}
+				res.Trailer[k] = append([]string(nil), vv...)
+			}
+		}
+	}
+	// Headers set with http.TrailerPrefix are trailers too, whenever
+	// they were set, as the server sends them.
+	for k, vv := range rw.HeaderMap {
+		if !strings.HasPrefix(k, http.TrailerPrefix) {
+			continue
+		}
+		if res.Trailer == nil {
+			res.Trailer = make(http.Header)
+		}
+		res.Trailer[http.CanonicalHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix))] = append([]string(nil), vv...)
+	}
+	for k := range res.Header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			delete(res.Header, k)
+		}
+	}
+	return res
+}
+
+func cloneHeader(h http.Header) http.Header {
+	h2 := make(http.Header, len(h))
+	for k, vv := range h {
+		h2[k] = append([]string(nil), vv...)
+	}
+	return h2
+}
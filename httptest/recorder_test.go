@@ -5,8 +5,11 @@
 package httptest
 
 import (
+	"bufio"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"reflect"
 	"testing"
 )
 
@@ -39,6 +42,35 @@ func TestRecorder(t *testing.T) {
 		}
 	}
 
+	hasInterim := func(codes ...int) checkFunc {
+		return func(rec *ResponseRecorder) error {
+			var got []int
+			for _, r := range rec.Interim {
+				got = append(got, r.Code)
+			}
+			if !reflect.DeepEqual(got, codes) {
+				return fmt.Errorf("Interim codes = %v; want %v", got, codes)
+			}
+			return nil
+		}
+	}
+	hasHeader := func(key, want string) checkFunc {
+		return func(rec *ResponseRecorder) error {
+			if got := rec.Result().Header.Get(key); got != want {
+				return fmt.Errorf("header %s = %q; want %q", key, got, want)
+			}
+			return nil
+		}
+	}
+	hasTrailer := func(key, want string) checkFunc {
+		return func(rec *ResponseRecorder) error {
+			if got := rec.Result().Trailer.Get(key); got != want {
+				return fmt.Errorf("trailer %s = %q; want %q", key, got, want)
+			}
+			return nil
+		}
+	}
+
 	tests := []struct {
 		name   string
 		h      func(w http.ResponseWriter, r *http.Request)
@@ -75,6 +107,35 @@ func TestRecorder(t *testing.T) {
 			},
 			check(hasStatus(200), hasFlush(true)),
 		},
+		{
+			"interim responses",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Link", "</style.css>; rel=preload")
+				w.WriteHeader(103)
+				w.WriteHeader(202)
+				w.WriteHeader(100)
+			},
+			check(hasStatus(202), hasInterim(103), hasHeader("Link", "</style.css>; rel=preload")),
+		},
+		{
+			"trailers",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Trailer", "Checksum, Undeclared-Unset")
+				w.Write([]byte("body"))
+				w.Header().Set("Checksum", "abc")
+				w.Header().Set("Late", "x")
+			},
+			check(hasStatus(200), hasContents("body"), hasTrailer("Checksum", "abc"), hasHeader("Late", "")),
+		},
+		{
+			"prefixed trailers",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(http.TrailerPrefix+"Early", "1")
+				w.Write([]byte("body"))
+				w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+			},
+			check(hasTrailer("Early", "1"), hasTrailer("Grpc-Status", "0"), hasHeader(http.TrailerPrefix+"Early", "")),
+		},
 	}
 	r, _ := http.NewRequest("GET", "http://foo.com/", nil)
 	for _, tt := range tests {
@@ -88,3 +149,44 @@ func TestRecorder(t *testing.T) {
 		}
 	}
 }
+
+func TestRecorderHijack(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Log(err)
+			return
+		}
+		go func() {
+			defer conn.Close()
+			brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\nhello")
+			brw.Flush()
+		}()
+	})
+	r, _ := http.NewRequest("GET", "http://foo.com/", nil)
+
+	rec := NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Hijacked || rec.ClientConn != nil {
+		t.Fatal("Hijack succeeded without Hijackable")
+	}
+
+	rec = NewRecorder()
+	rec.Hijackable = true
+	h.ServeHTTP(rec, r)
+	if !rec.Hijacked {
+		t.Fatal("not hijacked")
+	}
+	if _, err := rec.Write([]byte("x")); err != http.ErrHijacked {
+		t.Errorf("Write after Hijack = %v; want ErrHijacked", err)
+	}
+	br := bufio.NewReader(rec.ClientConn)
+	res, err := http.ReadResponse(br, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(br)
+	if res.StatusCode != 101 || string(b) != "hello" {
+		t.Errorf("client read %d %q", res.StatusCode, b)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Implementation of NewRequest
+
+package httptest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// NewRequest returns a new incoming server Request, suitable for
+// passing to an http.Handler for testing, without a connection.
+//
+// The target is the request-target: either a path or an absolute
+// URL. If target is an absolute URL, the host name from the URL is
+// used; otherwise "example.com" is used. RemoteAddr is set to
+// "192.0.2.1:1234". If target has the scheme "https", TLS is set to a
+// non-nil value.
+//
+// An empty method means "GET". NewRequest panics on error, for ease
+// of use in testing.
+func NewRequest(method, target string, body io.Reader) *http.Request {
+	if method == "" {
+		method = "GET"
+	}
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(method + " " + target + " HTTP/1.0\r\n\r\n")))
+	if err != nil {
+		panic("invalid NewRequest arguments; " + err.Error())
+	}
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/1.1", 1, 1
+	req.Close = false
+
+	if body != nil {
+		switch v := body.(type) {
+		case *bytes.Buffer:
+			req.ContentLength = int64(v.Len())
+		case *bytes.Reader:
+			req.ContentLength = int64(v.Len())
+		case *strings.Reader:
+			req.ContentLength = int64(v.Len())
+		default:
+			req.ContentLength = -1
+		}
+		rc, ok := body.(io.ReadCloser)
+		if !ok {
+			rc = ioutil.NopCloser(body)
+		}
+		req.Body = rc
+	}
+
+	req.RemoteAddr = "192.0.2.1:1234"
+	if req.Host == "" {
+		req.Host = "example.com"
+	}
+	if strings.HasPrefix(target, "https://") {
+		req.TLS = &tls.ConnectionState{
+			Version:           tls.VersionTLS12,
+			HandshakeComplete: true,
+			ServerName:        req.Host,
+		}
+	}
+	return req
+}
+
+// NewProxyRequest is like NewRequest but returns a Request as if its
+// connection had started with the PROXY protocol header line, as for
+// a server started with StartProxy. RemoteAddr is set to the source
+// address of line, if any.
+func NewProxyRequest(method, target string, body io.Reader, line *http.ProxyLine) *http.Request {
+	req := NewRequest(method, target, body)
+	req.ProxyLine = line
+	if line != nil && line.Source != nil {
+		req.RemoteAddr = line.Source.String()
+	}
+	return req
+}
@@ -34,6 +34,9 @@ type Server struct {
 	// wg counts the number of outstanding HTTP requests on this server.
 	// Close blocks until all requests are finished.
 	wg sync.WaitGroup
+
+	mu         sync.Mutex        // guards transports
+	transports []*http.Transport // of ProxyClient clients
 }
 
 // historyListener keeps track of all connections that it's ever
@@ -170,6 +173,11 @@ func (s *Server) Close() {
 	if t, ok := http.DefaultTransport.(*http.Transport); ok {
 		t.CloseIdleConnections()
 	}
+	s.mu.Lock()
+	for _, t := range s.transports {
+		t.CloseIdleConnections()
+	}
+	s.mu.Unlock()
 }
 
 // CloseClientConnections closes any currently open HTTP connections
@@ -0,0 +1,66 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package httptrace provides hooks to trace the events within HTTP
+// client requests.
+package httptrace
+
+import (
+	"context"
+	"net/textproto"
+)
+
+// A ClientTrace is a set of hooks to run at various stages of an
+// outgoing HTTP request made by a Transport. Any particular hook may
+// be nil. Functions may be called concurrently from different
+// goroutines, and some may be called after the request has completed
+// or failed.
+type ClientTrace struct {
+	// Got1xxResponse is called for each informational (1xx)
+	// response header returned before the final non-1xx response,
+	// 100 Continue included, such as the Link headers of a 103
+	// Early Hints response. If it returns an error, the request is
+	// aborted with that error.
+	Got1xxResponse func(code int, header textproto.MIMEHeader) error
+}
+
+type clientTraceKey struct{}
+
+// ContextClientTrace returns the ClientTrace associated with ctx, or
+// nil if there is none.
+func ContextClientTrace(ctx context.Context) *ClientTrace {
+	trace, _ := ctx.Value(clientTraceKey{}).(*ClientTrace)
+	return trace
+}
+
+// WithClientTrace returns a new context based on parent, in which
+// requests made by a Transport run the hooks of trace, in addition to
+// those of any ClientTrace already registered with parent, which run
+// after.
+func WithClientTrace(parent context.Context, trace *ClientTrace) context.Context {
+	if trace == nil {
+		panic("nil trace")
+	}
+	if old := ContextClientTrace(parent); old != nil {
+		trace = trace.compose(old)
+	}
+	return context.WithValue(parent, clientTraceKey{}, trace)
+}
+
+// compose returns a ClientTrace running the hooks of t, then those of
+// old.
+func (t *ClientTrace) compose(old *ClientTrace) *ClientTrace {
+	nt := *t
+	if tf, of := t.Got1xxResponse, old.Got1xxResponse; tf == nil {
+		nt.Got1xxResponse = of
+	} else if of != nil {
+		nt.Got1xxResponse = func(code int, header textproto.MIMEHeader) error {
+			if err := tf(code, header); err != nil {
+				return err
+			}
+			return of(code, header)
+		}
+	}
+	return &nt
+}
@@ -0,0 +1,46 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httptrace
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+	"testing"
+)
+
+func TestWithClientTrace(t *testing.T) {
+	var calls []string
+	hook := func(name string, err error) func(int, textproto.MIMEHeader) error {
+		return func(int, textproto.MIMEHeader) error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+	ctx := context.Background()
+	if ContextClientTrace(ctx) != nil {
+		t.Fatal("trace in an empty context")
+	}
+	ctx = WithClientTrace(ctx, &ClientTrace{Got1xxResponse: hook("old", nil)})
+	ctx = WithClientTrace(ctx, &ClientTrace{})
+	ctx = WithClientTrace(ctx, &ClientTrace{Got1xxResponse: hook("new", nil)})
+	if err := ContextClientTrace(ctx).Got1xxResponse(103, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 || calls[0] != "new" || calls[1] != "old" {
+		t.Errorf("hooks called: %v; want [new old]", calls)
+	}
+
+	// An error from a hook stops those after it.
+	calls = nil
+	stop := errors.New("stop")
+	ctx = WithClientTrace(ctx, &ClientTrace{Got1xxResponse: hook("failing", stop)})
+	if err := ContextClientTrace(ctx).Got1xxResponse(103, nil); err != stop {
+		t.Errorf("error %v; want %v", err, stop)
+	}
+	if len(calls) != 1 {
+		t.Errorf("hooks called: %v; want [failing]", calls)
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Load balancing across the backends of a reverse proxy.
+
+package httputil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// A BalancePolicy chooses the backend a Balancer sends a request to.
+type BalancePolicy int
+
+const (
+	// RoundRobin takes the backends in turn.
+	RoundRobin BalancePolicy = iota
+
+	// LeastConns takes the backend with the fewest requests in
+	// progress, in turn among those with as few.
+	LeastConns
+)
+
+// A Balancer is an http.RoundTripper sending each request to one of
+// several backends, retrying it on another when a backend fails. It
+// is meant as the Transport of a ReverseProxy; see NewLoadBalancer.
+//
+// A backend fails a request when the connection to it fails or it
+// answers 502 (Bad Gateway), 503 (Service Unavailable) or 504 (Gateway
+// Timeout). A backend failing MaxFails requests in a row is marked down
+// for FailTimeout and gets no requests then, unless all backends are
+// down. Requests are retried only if that is safe: those with an
+// idempotent method (GET, HEAD, OPTIONS, TRACE, PUT or DELETE) or an
+// Idempotency-Key header, and with no body, a nil Body or NoBody, or a
+// body that GetBody can recreate.
+//
+// A Balancer's fields must not be changed once it is in use.
+type Balancer struct {
+	// Backends are the URLs of the backends. A request for "/dir"
+	// is sent to a backend's scheme and host, with the path
+	// "/base/dir" for a backend URL with the path "/base".
+	Backends []*url.URL
+
+	// Policy chooses among the backends.
+	Policy BalancePolicy
+
+	// MaxTries limits the backends a request is sent to, each at
+	// most once. If zero, it is 3.
+	MaxTries int
+
+	// TryTimeout, if positive, limits the time each try may take
+	// until the backend's response header arrives, after which the
+	// try fails. The Transport must support CancelRequest.
+	TryTimeout time.Duration
+
+	// MaxFails is how many failures in a row mark a backend down,
+	// and FailTimeout for how long. They default to 1 and 10
+	// seconds.
+	MaxFails    int
+	FailTimeout time.Duration
+
+	// Transport sends the requests to the backends. If nil,
+	// http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	once     sync.Once
+	mu       sync.Mutex
+	backends []*backend
+	next     int // index of the next backend in turn
+}
+
+// backend is the state of one of a Balancer's backends, guarded by
+// the Balancer's mu.
+type backend struct {
+	url       *url.URL
+	active    int       // requests in progress
+	fails     int       // failures in a row
+	downUntil time.Time // marked down for FailTimeout
+}
+
+// NewLoadBalancer returns a new ReverseProxy sending requests to
+// backends with a Balancer using policy.
+func NewLoadBalancer(policy BalancePolicy, backends ...*url.URL) *ReverseProxy {
+	return &ReverseProxy{
+		Director:  func(*http.Request) {},
+		Transport: &Balancer{Backends: backends, Policy: policy},
+	}
+}
+
+var errNoBackends = errors.New("httputil: Balancer has no backends")
+
+func (b *Balancer) init() {
+	b.backends = make([]*backend, len(b.Backends))
+	for i, u := range b.Backends {
+		b.backends[i] = &backend{url: u}
+	}
+}
+
+func (b *Balancer) maxTries() int {
+	n := b.MaxTries
+	if n == 0 {
+		n = 3
+	}
+	if n > len(b.backends) {
+		n = len(b.backends)
+	}
+	return n
+}
+
+func (b *Balancer) transport() http.RoundTripper {
+	if b.Transport == nil {
+		return http.DefaultTransport
+	}
+	return b.Transport
+}
+
+// pick chooses a backend not in tried, counting a request in progress
+// on it, or returns nil if all were tried.
+func (b *Balancer) pick(tried map[*backend]bool) *backend {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	var best *backend
+	bestIdx := 0
+	for _, up := range []bool{true, false} {
+		for i := range b.backends {
+			idx := (b.next + i) % len(b.backends)
+			be := b.backends[idx]
+			if tried[be] || up && now.Before(be.downUntil) {
+				continue
+			}
+			if best == nil || b.Policy == LeastConns && be.active < best.active {
+				best, bestIdx = be, idx
+			}
+			if b.Policy == RoundRobin {
+				break
+			}
+		}
+		if best != nil {
+			break
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	b.next = (bestIdx + 1) % len(b.backends)
+	best.active++
+	return best
+}
+
+// done records the end of a request in progress on be, which failed
+// if failed is true.
+func (b *Balancer) done(be *backend, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	be.active--
+	if !failed {
+		be.fails = 0
+		return
+	}
+	be.fails++
+	maxFails := b.MaxFails
+	if maxFails == 0 {
+		maxFails = 1
+	}
+	if be.fails >= maxFails {
+		d := b.FailTimeout
+		if d == 0 {
+			d = 10 * time.Second
+		}
+		be.downUntil = time.Now().Add(d)
+	}
+}
+
+// replayable reports whether req is safe to send to another backend.
+func replayable(req *http.Request) bool {
+	switch req.Method {
+	case "", "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+	default:
+		if req.Header.Get("Idempotency-Key") == "" {
+			return false
+		}
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+func failedStatus(code int) bool {
+	return code == http.StatusBadGateway || code == http.StatusServiceUnavailable || code == http.StatusGatewayTimeout
+}
+
+// RoundTrip sends req to one of b's backends.
+func (b *Balancer) RoundTrip(req *http.Request) (*http.Response, error) {
+	b.once.Do(b.init)
+	if len(b.backends) == 0 {
+		return nil, errNoBackends
+	}
+	tries := 1
+	if replayable(req) {
+		tries = b.maxTries()
+	}
+	tried := make(map[*backend]bool)
+	var lastErr error
+	for try := 0; try < tries; try++ {
+		be := b.pick(tried)
+		if be == nil {
+			break
+		}
+		tried[be] = true
+		out := *req
+		if try > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				b.done(be, false)
+				return nil, err
+			}
+			out.Body = body
+		}
+		out.URL = backendURL(be.url, req.URL)
+		res, err := b.roundTrip(&out)
+		if err == nil && (!failedStatus(res.StatusCode) || try == tries-1) {
+			failed := failedStatus(res.StatusCode)
+			body := &backendBody{ReadCloser: res.Body, done: func() { b.done(be, failed) }}
+			if conn, ok := res.Body.(io.ReadWriteCloser); ok {
+				// The connection of a response switching protocols.
+				res.Body = backendConn{body, conn}
+			} else {
+				res.Body = body
+			}
+			return res, nil
+		}
+		if err == nil {
+			res.Body.Close()
+			err = fmt.Errorf("httputil: backend %s answered %s", be.url.Host, res.Status)
+		}
+		b.done(be, true)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// roundTrip sends req, canceling it after TryTimeout if the response
+// header hasn't arrived by then.
+func (b *Balancer) roundTrip(req *http.Request) (*http.Response, error) {
+	tr := b.transport()
+	if b.TryTimeout <= 0 {
+		return tr.RoundTrip(req)
+	}
+	canceler, ok := tr.(interface {
+		CancelRequest(*http.Request)
+	})
+	if !ok {
+		return nil, fmt.Errorf("httputil: Balancer Transport of type %T doesn't support CancelRequest; TryTimeout not supported", tr)
+	}
+	timer := time.AfterFunc(b.TryTimeout, func() { canceler.CancelRequest(req) })
+	res, err := tr.RoundTrip(req)
+	if !timer.Stop() {
+		if err == nil {
+			res.Body.Close()
+		}
+		return nil, fmt.Errorf("httputil: backend %s timed out after %v", req.URL.Host, b.TryTimeout)
+	}
+	return res, err
+}
+
+// backendURL returns the URL of the request for u sent to the backend
+// at target.
+func backendURL(target, u *url.URL) *url.URL {
+	out := *u
+	out.Scheme = target.Scheme
+	out.Host = target.Host
+	out.Path = singleJoiningSlash(target.Path, u.Path)
+	if target.RawQuery == "" || u.RawQuery == "" {
+		out.RawQuery = target.RawQuery + u.RawQuery
+	} else {
+		out.RawQuery = target.RawQuery + "&" + u.RawQuery
+	}
+	return &out
+}
+
+// backendBody is the body of a backend's response, ending the request
+// in progress on it when closed.
+type backendBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *backendBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
+
+// backendConn is a backendBody that can be written to, that of a
+// response switching protocols, which is the connection to the
+// backend.
+type backendConn struct {
+	*backendBody
+	io.Writer
+}
@@ -0,0 +1,200 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// namedBackend starts a backend answering with its name, or with a
+// 503 while *down is true.
+func namedBackend(t *testing.T, name string, down *bool) (*httptest.Server, *url.URL) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down != nil && *down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(name + " " + r.URL.RequestURI()))
+	}))
+	u, err := url.Parse(ts.URL + "/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts, u
+}
+
+func get(t *testing.T, c *http.Client, method, u string) (int, string) {
+	req, _ := http.NewRequest(method, u, strings.NewReader(""))
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	return res.StatusCode, string(body)
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	var urls []*url.URL
+	for _, name := range []string{"a", "b", "c"} {
+		ts, u := namedBackend(t, name, nil)
+		defer ts.Close()
+		urls = append(urls, u)
+	}
+	frontend := httptest.NewServer(NewLoadBalancer(RoundRobin, urls...))
+	defer frontend.Close()
+
+	var got []string
+	for i := 0; i < 6; i++ {
+		_, body := get(t, http.DefaultClient, "GET", frontend.URL+"/x?q=1")
+		got = append(got, body)
+	}
+	want := "a /a/x?q=1,b /b/x?q=1,c /c/x?q=1,a /a/x?q=1,b /b/x?q=1,c /c/x?q=1"
+	if g := strings.Join(got, ","); g != want {
+		t.Errorf("responses = %s; want %s", g, want)
+	}
+}
+
+func TestBalancerUpgrade(t *testing.T) {
+	testProxyUpgrade(t, func(u *url.URL) http.Handler { return NewLoadBalancer(RoundRobin, u) })
+}
+
+func TestBalancerFailover(t *testing.T) {
+	down := true
+	bad, badURL := namedBackend(t, "bad", &down)
+	defer bad.Close()
+	good, goodURL := namedBackend(t, "good", nil)
+	defer good.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL, _ := url.Parse(dead.URL)
+	dead.Close()
+
+	b := &Balancer{Backends: []*url.URL{deadURL, badURL, goodURL}, FailTimeout: time.Hour}
+	frontend := httptest.NewServer(&ReverseProxy{Director: func(*http.Request) {}, Transport: b})
+	defer frontend.Close()
+
+	for i := 0; i < 3; i++ {
+		if code, body := get(t, http.DefaultClient, "GET", frontend.URL+"/"); code != 200 || body != "good /good/" {
+			t.Fatalf("try %d: got %d %q; want the good backend", i, code, body)
+		}
+	}
+	if got := b.backends[0].downUntil.IsZero(); got {
+		t.Error("dead backend not marked down")
+	}
+	if got := b.backends[1].downUntil.IsZero(); got {
+		t.Error("failing backend not marked down")
+	}
+
+	// Requests that are unsafe to retry get one try, here the
+	// remaining backend up.
+	if code, _ := get(t, http.DefaultClient, "POST", frontend.URL+"/"); code != 200 {
+		t.Errorf("POST status = %d; want 200", code)
+	}
+	b.mu.Lock()
+	b.backends[1].downUntil = time.Time{}
+	b.next = 1
+	b.mu.Unlock()
+	if code, _ := get(t, http.DefaultClient, "POST", frontend.URL+"/"); code != http.StatusServiceUnavailable {
+		t.Errorf("POST to failing backend status = %d; want 503", code)
+	}
+}
+
+func TestBalancerReplayable(t *testing.T) {
+	newRequest := func(method string, body io.Reader) *http.Request {
+		req, _ := http.NewRequest(method, "http://example.com/", body)
+		return req
+	}
+	keyed := newRequest("POST", nil)
+	keyed.Header.Set("Idempotency-Key", "k")
+	noBody := newRequest("GET", nil)
+	noBody.Body = http.NoBody
+	for _, tt := range []struct {
+		name string
+		req  *http.Request
+		want bool
+	}{
+		{"GET without a body", newRequest("GET", nil), true},
+		{"GET with NoBody", noBody, true},
+		{"GET with a body GetBody recreates", newRequest("GET", strings.NewReader("x")), true},
+		{"GET with a body of unknown length", newRequest("GET", ioutil.NopCloser(strings.NewReader("x"))), false},
+		{"POST", newRequest("POST", nil), false},
+		{"POST with an Idempotency-Key", keyed, true},
+	} {
+		if got := replayable(tt.req); got != tt.want {
+			t.Errorf("%s: replayable = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBalancerLeastConns(t *testing.T) {
+	release := make(chan bool)
+	started := make(chan bool)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+		w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	slowURL, _ := url.Parse(slow.URL)
+	fast, fastURL := namedBackend(t, "fast", nil)
+	defer fast.Close()
+
+	frontend := httptest.NewServer(NewLoadBalancer(LeastConns, slowURL, fastURL))
+	defer frontend.Close()
+
+	done := make(chan string)
+	go func() {
+		_, body := get(t, http.DefaultClient, "GET", frontend.URL+"/")
+		done <- body
+	}()
+	<-started
+	for i := 0; i < 3; i++ {
+		if _, body := get(t, http.DefaultClient, "GET", frontend.URL+"/"); body != "fast /fast/" {
+			t.Errorf("request %d with the slow backend busy went to %q", i, body)
+		}
+	}
+	close(release)
+	if body := <-done; body != "slow" {
+		t.Errorf("first request = %q; want slow", body)
+	}
+}
+
+func TestBalancerTryTimeout(t *testing.T) {
+	started := make(chan bool, 1)
+	hang := make(chan bool)
+	stuck := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-hang
+	}))
+	defer stuck.Close()
+	defer close(hang)
+	stuckURL, _ := url.Parse(stuck.URL)
+	ok, okURL := namedBackend(t, "ok", nil)
+	defer ok.Close()
+
+	b := &Balancer{
+		Backends:   []*url.URL{stuckURL, okURL},
+		TryTimeout: 50 * time.Millisecond,
+		Transport:  &http.Transport{},
+	}
+	frontend := httptest.NewServer(&ReverseProxy{Director: func(*http.Request) {}, Transport: b})
+	defer frontend.Close()
+	if code, body := get(t, http.DefaultClient, "GET", frontend.URL+"/"); code != 200 || body != "ok /ok/" {
+		t.Errorf("got %d %q; want the ok backend after the timeout", code, body)
+	}
+	// The stuck backend is closed only once the request reached it.
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request reached the stuck backend")
+	}
+}
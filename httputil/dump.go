@@ -199,6 +199,9 @@ func DumpRequest(req *http.Request, body bool) (dump []byte, err error) {
 	if len(req.TransferEncoding) > 0 {
 		fmt.Fprintf(&b, "Transfer-Encoding: %s\r\n", strings.Join(req.TransferEncoding, ","))
 	}
+	if !chunked && req.ContentLength > 0 {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n", req.ContentLength)
+	}
 	if req.Close {
 		fmt.Fprintf(&b, "Connection: close\r\n")
 	}
@@ -253,3 +256,42 @@ func DumpResponse(resp *http.Response, body bool) (dump []byte, err error) {
 	dump = b.Bytes()
 	return
 }
+
+// DumpProxyRequest is like DumpRequest but starts the dump with the
+// PROXY protocol header of req.ProxyLine, if any, as a server behind
+// a proxy received it. ReadProxyRequest reads the dump back.
+// Responses carry no PROXY header; DumpResponse dumps them.
+func DumpProxyRequest(req *http.Request, body bool) ([]byte, error) {
+	dump, err := DumpRequest(req, body)
+	if err != nil || req.ProxyLine == nil {
+		return dump, err
+	}
+	line, err := req.ProxyLine.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return append(line, dump...), nil
+}
+
+// ReadProxyRequest reads a request from b, as DumpProxyRequest dumps
+// it. If the request starts with a PROXY protocol header, its
+// ProxyLine is set and, if the header reported addresses, its
+// RemoteAddr.
+func ReadProxyRequest(b *bufio.Reader) (*http.Request, error) {
+	var line *http.ProxyLine
+	if p, _ := b.Peek(6); string(p) == "PROXY " || string(p) == "\r\n\r\n\x00\r" {
+		var err error
+		if line, err = http.ReadProxyLine(b); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.ReadRequest(b)
+	if err != nil {
+		return nil, err
+	}
+	req.ProxyLine = line
+	if line != nil && line.Source != nil {
+		req.RemoteAddr = line.Source.String()
+	}
+	return req, nil
+}
@@ -5,12 +5,15 @@
 package httputil
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -176,3 +179,45 @@ func mustNewRequest(method, url string, body io.Reader) *http.Request {
 	}
 	return req
 }
+
+func TestDumpProxyRequest(t *testing.T) {
+	lines := []*http.ProxyLine{
+		nil,
+		{Version: 1},
+		{Version: 1, Source: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324}, Destination: &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443}},
+		{Version: 2},
+		{Version: 2, Source: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1000}, Destination: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 80}},
+	}
+	for _, line := range lines {
+		req, _ := http.NewRequest("POST", "http://example.com/upload", strings.NewReader("data"))
+		req.ProxyLine = line
+		dump, err := DumpProxyRequest(req, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != nil && line.Version == 1 && !bytes.HasPrefix(dump, []byte("PROXY ")) {
+			t.Errorf("%+v: dump %q doesn't start with the PROXY header", line, dump)
+		}
+
+		got, err := ReadProxyRequest(bufio.NewReader(bytes.NewReader(dump)))
+		if err != nil {
+			t.Errorf("%+v: reading dump %q: %v", line, dump, err)
+			continue
+		}
+		switch {
+		case line == nil:
+			if got.ProxyLine != nil {
+				t.Errorf("read ProxyLine %+v from a dump without it", got.ProxyLine)
+			}
+		case got.ProxyLine == nil || got.ProxyLine.Version != line.Version ||
+			fmt.Sprint(got.ProxyLine.Source, got.ProxyLine.Destination) != fmt.Sprint(line.Source, line.Destination):
+			t.Errorf("read ProxyLine %+v; want %+v", got.ProxyLine, line)
+		case line.Source != nil && got.RemoteAddr != line.Source.String():
+			t.Errorf("RemoteAddr = %q; want %q", got.RemoteAddr, line.Source)
+		}
+		b, _ := ioutil.ReadAll(got.Body)
+		if got.Method != "POST" || got.URL.Path != "/upload" || got.Host != "example.com" || string(b) != "data" {
+			t.Errorf("%+v: read %s %s on %s with body %q", line, got.Method, got.URL, got.Host, b)
+		}
+	}
+}
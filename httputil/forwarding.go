@@ -0,0 +1,165 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Forwarding headers of reverse proxies.
+
+package httputil
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A ProxyRequest is a request being proxied by a ReverseProxy, given
+// to its Rewrite function.
+type ProxyRequest struct {
+	// In is the request received by the proxy. It must not be
+	// modified.
+	In *http.Request
+
+	// Out is the request the proxy will send to the backend.
+	Out *http.Request
+}
+
+// SetURL routes the outgoing request to the scheme, host and base path
+// of target, as NewSingleHostReverseProxy does. The Host header of the
+// outgoing request becomes target's host.
+func (r *ProxyRequest) SetURL(target *url.URL) {
+	r.Out.URL = backendURL(target, r.In.URL)
+	r.Out.Host = ""
+}
+
+// A ForwardingPolicy sets the forwarding headers of the requests a
+// ReverseProxy sends to its backends, telling them about the client
+// and the proxies between. RFC 7239 defines Forwarded; the others are
+// de facto standards.
+//
+// Only the forwarding headers of requests from trusted proxies are
+// extended. Those of requests from other clients, which may forge
+// them, are removed, including those the policy doesn't set.
+type ForwardingPolicy struct {
+	// TrustedProxies lists the IP addresses and CIDR blocks, such
+	// as "10.0.0.0/8", of the peers trusted to set forwarding
+	// headers. The peer is the client of the request's RemoteAddr,
+	// the source address of the PROXY protocol header of the
+	// connection, if any.
+	TrustedProxies []string
+
+	// XForwarded sets the X-Forwarded-For, X-Forwarded-Host and
+	// X-Forwarded-Proto headers. Requests from trusted proxies keep
+	// the host and protocol they report.
+	XForwarded bool
+
+	// Forwarded sets the Forwarded header.
+	Forwarded bool
+
+	// Via, if non-empty, is the name of the proxy added to the Via
+	// header, such as "gateway".
+	Via string
+
+	once sync.Once
+	nets []*net.IPNet
+}
+
+// forwardingHeaders are the headers a ForwardingPolicy removes from
+// requests of untrusted clients.
+var forwardingHeaders = []string{
+	"Forwarded",
+	"Via",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+}
+
+func (fp *ForwardingPolicy) init() {
+	for _, s := range fp.TrustedProxies {
+		if _, n, err := net.ParseCIDR(s); err == nil {
+			fp.nets = append(fp.nets, n)
+		} else if ip := net.ParseIP(s); ip != nil {
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			fp.nets = append(fp.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		}
+	}
+}
+
+// trusted reports whether ip is that of a trusted proxy.
+func (fp *ForwardingPolicy) trusted(ip net.IP) bool {
+	fp.once.Do(fp.init)
+	for _, n := range fp.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply sets the forwarding headers of out, the request to the backend
+// for in.
+func (fp *ForwardingPolicy) apply(out, in *http.Request) {
+	host, _, err := net.SplitHostPort(in.RemoteAddr)
+	if err != nil {
+		host = in.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	h := out.Header
+	if ip == nil || !fp.trusted(ip) {
+		for _, k := range forwardingHeaders {
+			h.Del(k)
+		}
+	}
+	proto := "http"
+	if in.TLS != nil {
+		proto = "https"
+	}
+
+	if fp.XForwarded {
+		appendHeader(h, "X-Forwarded-For", host)
+		if h.Get("X-Forwarded-Host") == "" {
+			h.Set("X-Forwarded-Host", in.Host)
+		}
+		if h.Get("X-Forwarded-Proto") == "" {
+			h.Set("X-Forwarded-Proto", proto)
+		}
+	}
+	if fp.Forwarded {
+		node := host
+		if ip == nil {
+			node = "unknown"
+		} else if ip.To4() == nil {
+			node = `"[` + host + `]"`
+		}
+		appendHeader(h, "Forwarded", "for="+node+";host="+quoteForwarded(in.Host)+";proto="+proto)
+	}
+	if fp.Via != "" {
+		v := strconv.Itoa(in.ProtoMajor) + "." + strconv.Itoa(in.ProtoMinor)
+		appendHeader(h, "Via", v+" "+fp.Via)
+	}
+}
+
+// appendHeader appends v to the comma-separated list of h's header
+// key, folding multiple headers into one.
+func appendHeader(h http.Header, key, v string) {
+	if prior := h[key]; len(prior) > 0 {
+		v = strings.Join(prior, ", ") + ", " + v
+	}
+	h.Set(key, v)
+}
+
+// quoteForwarded returns s as a Forwarded parameter value, quoted
+// unless it's a token.
+func quoteForwarded(s string) string {
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
@@ -0,0 +1,122 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// headerBackend starts a backend reporting the request headers
+// keys in its response headers, prefixed with "Got-".
+func headerBackend(keys ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, k := range keys {
+			if v, ok := r.Header[k]; ok {
+				w.Header()["Got-"+k] = v
+			}
+		}
+		w.Header().Set("Got-Host", r.Host)
+		w.Header().Set("Connection", "X-Backend-Hop")
+		w.Header().Set("X-Backend-Hop", "1")
+	}))
+}
+
+func TestForwardingPolicy(t *testing.T) {
+	backend := headerBackend("X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto", "Forwarded", "Via", "X-Hop")
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	tests := []struct {
+		trusted string
+		want    map[string]string
+	}{
+		{"127.0.0.0/8", map[string]string{
+			"X-Forwarded-For":   "203.0.113.1, 127.0.0.1",
+			"X-Forwarded-Host":  "original.example",
+			"X-Forwarded-Proto": "https",
+			"Forwarded":         `for=203.0.113.1, for=127.0.0.1;host="front.example:8080";proto=http`,
+			"Via":               "1.1 edge, 1.1 gateway",
+		}},
+		{"10.0.0.0/8", map[string]string{
+			"X-Forwarded-For":   "127.0.0.1",
+			"X-Forwarded-Host":  "front.example:8080",
+			"X-Forwarded-Proto": "http",
+			"Forwarded":         `for=127.0.0.1;host="front.example:8080";proto=http`,
+			"Via":               "1.1 gateway",
+		}},
+	}
+	for _, tt := range tests {
+		p := NewSingleHostReverseProxy(backendURL)
+		p.Forwarding = &ForwardingPolicy{
+			TrustedProxies: []string{tt.trusted},
+			XForwarded:     true,
+			Forwarded:      true,
+			Via:            "gateway",
+		}
+		frontend := httptest.NewServer(p)
+		req, _ := http.NewRequest("GET", frontend.URL, nil)
+		req.Host = "front.example:8080"
+		req.Header.Set("X-Forwarded-For", "203.0.113.1")
+		req.Header.Set("X-Forwarded-Host", "original.example")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("Forwarded", "for=203.0.113.1")
+		req.Header.Set("Via", "1.1 edge")
+		req.Header.Set("Connection", "X-Hop")
+		req.Header.Set("X-Hop", "1")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		for k, v := range tt.want {
+			if got := res.Header.Get("Got-" + k); got != v {
+				t.Errorf("trusted %s: backend got %s %q; want %q", tt.trusted, k, got, v)
+			}
+		}
+		if got := res.Header.Get("Got-X-Hop"); got != "" {
+			t.Errorf("backend got X-Hop %q, listed in Connection", got)
+		}
+		if got := res.Header.Get("X-Backend-Hop"); got != "" {
+			t.Errorf("client got X-Backend-Hop %q, listed in Connection", got)
+		}
+		frontend.Close()
+	}
+}
+
+func TestReverseProxyRewrite(t *testing.T) {
+	backend := headerBackend("X-Forwarded-For", "X-Rewritten")
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	p := &ReverseProxy{
+		Forwarding: &ForwardingPolicy{},
+		Rewrite: func(r *ProxyRequest) {
+			r.SetURL(backendURL)
+			r.Out.Header.Set("X-Rewritten", r.In.URL.Path)
+		},
+	}
+	frontend := httptest.NewServer(p)
+	defer frontend.Close()
+
+	req, _ := http.NewRequest("GET", frontend.URL+"/path", nil)
+	req.Header.Set("X-Forwarded-For", "forged")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if got := res.Header.Get("Got-X-Rewritten"); got != "/path" {
+		t.Errorf("X-Rewritten = %q; want /path", got)
+	}
+	// A policy setting no headers still removes untrusted ones.
+	if got := res.Header.Get("Got-X-Forwarded-For"); got != "" {
+		t.Errorf("X-Forwarded-For = %q; want none", got)
+	}
+	if got, want := res.Header.Get("Got-Host"), backendURL.Host; got != want {
+		t.Errorf("Host = %q; want %q", got, want)
+	}
+}
@@ -9,6 +9,7 @@ package httputil
 import (
 	"io"
 	"log"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
@@ -28,9 +29,23 @@ type ReverseProxy struct {
 	// Director must be a function which modifies
 	// the request into a new request to be sent
 	// using Transport. Its response is then copied
-	// back to the original client unmodified.
+	// back to the original client unmodified,
+	// except for hop-by-hop headers. Director may
+	// be nil if Rewrite is set.
 	Director func(*http.Request)
 
+	// Forwarding, if non-nil, sets the forwarding
+	// headers of requests to the backend, such as
+	// X-Forwarded-For and Forwarded. If nil, the
+	// client's address is added to X-Forwarded-For.
+	Forwarding *ForwardingPolicy
+
+	// Rewrite, if non-nil, is called last to modify
+	// the request to the backend, after Director,
+	// the removal of hop-by-hop headers and
+	// Forwarding.
+	Rewrite func(*ProxyRequest)
+
 	// The transport used to perform proxy requests.
 	// If nil, http.DefaultTransport is used.
 	Transport http.RoundTripper
@@ -39,6 +54,12 @@ type ReverseProxy struct {
 	// to flush to the client while copying the
 	// response body.
 	// If zero, no periodic flushing is done.
+	// A negative value means to flush immediately
+	// after each write to the client. The
+	// FlushInterval is ignored for streamed
+	// responses, those of unknown length such as
+	// chunked ones, and Server-Sent Events, which
+	// are flushed immediately.
 	FlushInterval time.Duration
 }
 
@@ -88,12 +109,28 @@ var hopHeaders = []string{
 	"Keep-Alive",
 	"Proxy-Authenticate",
 	"Proxy-Authorization",
-	"Te", // canonicalized version of "TE"
+	"Proxy-Connection", // non-standard, sent by some clients
+	"Te",               // canonicalized version of "TE"
 	"Trailers",
 	"Transfer-Encoding",
 	"Upgrade",
 }
 
+// removeHopHeaders removes the hop-by-hop headers from h, including
+// those the Connection header lists.
+func removeHopHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				h.Del(f)
+			}
+		}
+	}
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+}
+
 func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	transport := p.Transport
 	if transport == nil {
@@ -103,7 +140,11 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	outreq := new(http.Request)
 	*outreq = *req // includes shallow copies of maps, but okay
 
-	p.Director(outreq)
+	outreq.Header = make(http.Header)
+	copyHeader(outreq.Header, req.Header)
+	if p.Director != nil {
+		p.Director(outreq)
+	}
 	outreq.Proto = "HTTP/1.1"
 	outreq.ProtoMajor = 1
 	outreq.ProtoMinor = 1
@@ -111,22 +152,21 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 
 	// Remove hop-by-hop headers to the backend.  Especially
 	// important is "Connection" because we want a persistent
-	// connection, regardless of what the client sent to us.  This
-	// is modifying the same underlying map from req (shallow
-	// copied above) so we only copy it if necessary.
-	copiedHeaders := false
-	for _, h := range hopHeaders {
-		if outreq.Header.Get(h) != "" {
-			if !copiedHeaders {
-				outreq.Header = make(http.Header)
-				copyHeader(outreq.Header, req.Header)
-				copiedHeaders = true
-			}
-			outreq.Header.Del(h)
-		}
+	// connection, regardless of what the client sent to us.
+	reqUpType := upgradeType(outreq.Header)
+	removeHopHeaders(outreq.Header)
+
+	// Requests to switch protocols, such as to WebSocket, are
+	// passed on, and the switched connection proxied once the
+	// backend agrees.
+	if reqUpType != "" {
+		outreq.Header.Set("Connection", "Upgrade")
+		outreq.Header.Set("Upgrade", reqUpType)
 	}
 
-	if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+	if p.Forwarding != nil {
+		p.Forwarding.apply(outreq, req)
+	} else if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
 		// If we aren't the first proxy retain prior
 		// X-Forwarded-For information as a comma+space
 		// separated list and fold multiple headers into one.
@@ -135,6 +175,9 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		}
 		outreq.Header.Set("X-Forwarded-For", clientIP)
 	}
+	if p.Rewrite != nil {
+		p.Rewrite(&ProxyRequest{In: req, Out: outreq})
+	}
 
 	res, err := transport.RoundTrip(outreq)
 	if err != nil {
@@ -144,29 +187,112 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusSwitchingProtocols {
+		p.switchProtocols(rw, reqUpType, res)
+		return
+	}
+
+	removeHopHeaders(res.Header)
 	copyHeader(rw.Header(), res.Header)
 
 	rw.WriteHeader(res.StatusCode)
-	p.copyResponse(rw, res.Body)
+	p.copyResponse(rw, res.Body, p.flushInterval(res))
 }
 
-func (p *ReverseProxy) copyResponse(dst io.Writer, src io.Reader) {
-	if p.FlushInterval != 0 {
+// upgradeType returns the protocol that the header h asks to switch
+// to, or "" if none.
+func upgradeType(h http.Header) string {
+	for _, v := range h["Connection"] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), "upgrade") {
+				return h.Get("Upgrade")
+			}
+		}
+	}
+	return ""
+}
+
+// switchProtocols relays the 101 Switching Protocols response res to
+// the client of rw and copies between the client's connection and the
+// backend's until either side is done.
+func (p *ReverseProxy) switchProtocols(rw http.ResponseWriter, reqUpType string, res *http.Response) {
+	resUpType := upgradeType(res.Header)
+	backConn, ok := res.Body.(io.ReadWriteCloser)
+	if !strings.EqualFold(reqUpType, resUpType) || !ok {
+		log.Printf("http: proxy error: backend switched to protocol %q, not %q", resUpType, reqUpType)
+		rw.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	conn, brw, err := http.NewResponseController(rw).Hijack()
+	if err != nil {
+		log.Printf("http: proxy error: can't switch protocols: %v", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	res.Header.Write(brw)
+	brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		return
+	}
+
+	errc := make(chan error, 2)
+	go func() {
+		// Bytes the client sent after its request are
+		// buffered in brw.
+		_, err := io.Copy(backConn, brw)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(conn, backConn)
+		errc <- err
+	}()
+	<-errc
+}
+
+// flushInterval returns the interval at which the body of res is
+// flushed to the client, negative for immediately.
+func (p *ReverseProxy) flushInterval(res *http.Response) time.Duration {
+	ct, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if ct == "text/event-stream" || res.ContentLength == -1 {
+		return -1
+	}
+	return p.FlushInterval
+}
+
+func (p *ReverseProxy) copyResponse(dst io.Writer, src io.Reader, flushInterval time.Duration) {
+	if flushInterval != 0 {
 		if wf, ok := dst.(writeFlusher); ok {
-			mlw := &maxLatencyWriter{
-				dst:     wf,
-				latency: p.FlushInterval,
-				done:    make(chan bool),
+			if flushInterval < 0 {
+				dst = immediateFlusher{wf}
+			} else {
+				mlw := &maxLatencyWriter{
+					dst:     wf,
+					latency: flushInterval,
+					done:    make(chan bool),
+				}
+				go mlw.flushLoop()
+				defer mlw.stop()
+				dst = mlw
 			}
-			go mlw.flushLoop()
-			defer mlw.stop()
-			dst = mlw
 		}
 	}
 
 	io.Copy(dst, src)
 }
 
+// immediateFlusher flushes each write to the client.
+type immediateFlusher struct {
+	dst writeFlusher
+}
+
+func (f immediateFlusher) Write(p []byte) (int, error) {
+	n, err := f.dst.Write(p)
+	f.dst.Flush()
+	return n, err
+}
+
 type writeFlusher interface {
 	io.Writer
 	http.Flusher
@@ -7,7 +7,10 @@
 package httputil
 
 import (
+	"bufio"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -195,3 +198,112 @@ func TestReverseProxyFlushInterval(t *testing.T) {
 		t.Error("maxLatencyWriter flushLoop() never exited")
 	}
 }
+
+func TestReverseProxyUpgrade(t *testing.T) {
+	testProxyUpgrade(t, func(u *url.URL) http.Handler { return NewSingleHostReverseProxy(u) })
+}
+
+// testProxyUpgrade tests switching protocols through the proxy to a
+// backend at a URL that newProxy returns.
+func testProxyUpgrade(t *testing.T, newProxy func(*url.URL) http.Handler) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" || r.Header.Get("Connection") != "Upgrade" {
+			t.Errorf("backend got Upgrade %q, Connection %q", r.Header.Get("Upgrade"), r.Header.Get("Connection"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		for {
+			line, err := brw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			brw.WriteString("echo: " + line)
+			brw.Flush()
+		}
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	frontend := httptest.NewServer(newProxy(backendURL))
+	defer frontend.Close()
+
+	conn, err := net.Dial("tcp", frontend.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// The first line is sent with the request, before the switch.
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\nfirst\n")
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, &http.Request{Method: "GET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols || res.Header.Get("Upgrade") != "echo" {
+		t.Fatalf("got %d with Upgrade %q; want 101 echo", res.StatusCode, res.Header.Get("Upgrade"))
+	}
+	for _, line := range []string{"first", "second"} {
+		if line != "first" {
+			io.WriteString(conn, line+"\n")
+		}
+		got, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "echo: " + line + "\n"; got != want {
+			t.Errorf("got %q; want %q", got, want)
+		}
+	}
+}
+
+func TestReverseProxyStreaming(t *testing.T) {
+	for _, ct := range []string{"text/event-stream", "application/octet-stream"} {
+		next := make(chan bool)
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", ct)
+			for i := 0; i < 2; i++ {
+				io.WriteString(w, "data: event\n\n")
+				w.(http.Flusher).Flush()
+				<-next
+			}
+		}))
+		backendURL, _ := url.Parse(backend.URL)
+		frontend := httptest.NewServer(NewSingleHostReverseProxy(backendURL))
+
+		res, err := http.Get(frontend.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		br := bufio.NewReader(res.Body)
+		for i := 0; i < 2; i++ {
+			// Each event must arrive while the backend is
+			// still waiting to send the next.
+			done := make(chan error, 1)
+			go func() {
+				_, err := br.ReadString('\n')
+				br.ReadString('\n')
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("%s: %v", ct, err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: event %d not flushed to the client", ct, i)
+			}
+			next <- true
+		}
+		res.Body.Close()
+		frontend.Close()
+		backend.Close()
+	}
+}
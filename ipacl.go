@@ -0,0 +1,77 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// IP address access control.
+
+package http
+
+import (
+	"net"
+)
+
+// An IPACLHandler admits requests to the handler it wraps by the IP
+// address of their client: the Source of their ProxyLine, so that it
+// works behind load balancers speaking the PROXY protocol, or else
+// their RemoteAddr. Others are answered with 403 Forbidden.
+//
+// A client is admitted unless it is in Deny, or Allow isn't empty and
+// it isn't in Allow. Clients whose address is unknown are admitted only
+// if Allow is empty.
+type IPACLHandler struct {
+	// Handler is the handler protected.
+	Handler Handler
+
+	// Allow and Deny list the networks of the clients admitted and
+	// refused, Deny taking precedence.
+	Allow, Deny []*net.IPNet
+
+	// DenyHandler, if non-nil, answers the requests refused.
+	DenyHandler Handler
+}
+
+func (h *IPACLHandler) ServeHTTP(w ResponseWriter, r *Request) {
+	if h.admits(clientIP(r)) {
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+	if h.DenyHandler != nil {
+		h.DenyHandler.ServeHTTP(w, r)
+		return
+	}
+	Error(w, "Forbidden", StatusForbidden)
+}
+
+func (h *IPACLHandler) admits(ip net.IP) bool {
+	if ip == nil {
+		return len(h.Allow) == 0
+	}
+	if ipInNets(ip, h.Deny) {
+		return false
+	}
+	return len(h.Allow) == 0 || ipInNets(ip, h.Allow)
+}
+
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of r's client, from its ProxyLine if
+// it reports one, or nil if it's unknown.
+func clientIP(r *Request) net.IP {
+	if r.ProxyLine != nil {
+		if ip := addrIP(r.ProxyLine.Source); ip != nil {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
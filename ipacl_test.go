@@ -0,0 +1,68 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func mustParseCIDRs(t *testing.T, cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, s := range cidrs {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func TestIPACLHandler(t *testing.T) {
+	ok := HandlerFunc(func(w ResponseWriter, r *Request) {})
+	allowDeny := &IPACLHandler{
+		Handler: ok,
+		Allow:   mustParseCIDRs(t, "10.0.0.0/8", "fd00::/8"),
+		Deny:    mustParseCIDRs(t, "10.9.0.0/16"),
+	}
+	denyOnly := &IPACLHandler{
+		Handler:     ok,
+		Deny:        mustParseCIDRs(t, "192.0.2.0/24"),
+		DenyHandler: HandlerFunc(func(w ResponseWriter, r *Request) { w.WriteHeader(StatusTeapot) }),
+	}
+	lb := &net.TCPAddr{IP: net.ParseIP("10.1.1.1"), Port: 80}
+	tests := []struct {
+		name   string
+		h      Handler
+		remote string
+		line   *ProxyLine
+		code   int
+	}{
+		{"allowed", allowDeny, "10.1.2.3:1234", nil, 200},
+		{"allowed IPv6", allowDeny, "[fd00::1]:1234", nil, 200},
+		{"not allowed", allowDeny, "192.0.2.1:1234", nil, 403},
+		{"denied", allowDeny, "10.9.1.1:1234", nil, 403},
+		{"unknown", allowDeny, "pipe", nil, 403},
+		{"PROXY source", allowDeny, "10.1.1.1:4000", &ProxyLine{Version: 1, Source: &net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 5555}, Destination: lb}, 403},
+		{"PROXY source allowed", allowDeny, "192.0.2.1:4000", &ProxyLine{Version: 2, Source: &net.TCPAddr{IP: net.ParseIP("10.2.0.1"), Port: 5555}, Destination: lb}, 200},
+		{"PROXY LOCAL", allowDeny, "10.1.1.1:4000", &ProxyLine{Version: 2}, 200},
+		{"deny only", denyOnly, "198.51.100.1:1234", nil, 200},
+		{"deny only denied", denyOnly, "192.0.2.9:1234", nil, StatusTeapot},
+		{"deny only unknown", denyOnly, "", nil, 200},
+	}
+	for _, tt := range tests {
+		req, _ := NewRequest("GET", "http://example.com/internal", nil)
+		req.RemoteAddr = tt.remote
+		req.ProxyLine = tt.line
+		rec := httptest.NewRecorder()
+		tt.h.ServeHTTP(rec, req)
+		if rec.Code != tt.code {
+			t.Errorf("%s: got %d; want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Per-listener server settings.
+
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// ListenerOptions holds the settings of one listener served by
+// Server.ServeWithOptions, so that listeners of the same Server can
+// differ, for example a public TLS listener behind a load balancer and
+// a plain one for health checks. Zero fields fall back to the
+// Server's settings.
+type ListenerOptions struct {
+	// WrapConn optionally wraps each accepted connection before
+	// anything else reads from it, for example ReadProxyHeader to
+	// consume a PROXY protocol header and report the client's
+	// address from RemoteAddr. It runs on the connection's own
+	// goroutine, so it may block reading. If it returns an error,
+	// the connection is closed.
+	WrapConn func(net.Conn) (net.Conn, error)
+
+	// TLSConfig, if non-nil, makes the listener serve HTTPS, after
+	// WrapConn, using a copy of this configuration. As with
+	// ListenAndServeTLS, the Server's ALPN protocols, ClientCAs,
+	// OCSPStapler and session ticket keys are applied to the copy.
+	TLSConfig *tls.Config
+
+	TCPOptions     *TCPOptions   // overrides Server.TCPOptions
+	ReadTimeout    time.Duration // overrides Server.ReadTimeout
+	WriteTimeout   time.Duration // overrides Server.WriteTimeout
+	MaxHeaderBytes int           // overrides Server.MaxHeaderBytes
+	Acceptors      int           // overrides Server.Acceptors
+
+	// Handler, if non-nil, serves the listener's requests instead
+	// of the Server's Handler.
+	Handler Handler
+}
+
+// ServeWithOptions accepts incoming connections on the Listener l,
+// configured by opts, creating a new service goroutine for each. It
+// may be called for several listeners of one Server concurrently.
+func (srv *Server) ServeWithOptions(l net.Listener, opts ListenerOptions) error {
+	if config := opts.TLSConfig; config != nil {
+		if len(config.Certificates) == 0 && config.GetCertificate == nil {
+			l.Close()
+			return errors.New("http: ListenerOptions.TLSConfig has no certificates")
+		}
+		config = config.Clone()
+		if config.NextProtos == nil {
+			config.NextProtos = []string{"http/1.1"}
+		}
+		config.NextProtos = srv.nextProtos(config.NextProtos)
+		srv.setClientAuth(config)
+		srv.setOCSPStapling(config)
+		srv.trackTLSConfig(config, true)
+		defer srv.trackTLSConfig(config, false)
+		opts.TLSConfig = config
+	}
+	return srv.serve(l, &opts)
+}
+
+// serveWrapped applies opts.WrapConn and opts.TLSConfig to the new
+// connection rwc and serves it.
+func (srv *Server) serveWrapped(rwc net.Conn, opts *ListenerOptions, overLimit bool) {
+	if opts.WrapConn != nil {
+		wc, err := opts.WrapConn(rwc)
+		if err != nil {
+			if err == errProxyHeader && opts.TLSConfig == nil {
+				srv.rejectProxyHeader(rwc, err)
+			}
+			rwc.Close()
+			srv.endConn(overLimit)
+			return
+		}
+		rwc = wc
+	}
+	if opts.TLSConfig != nil {
+		rwc = tls.Server(rwc, opts.TLSConfig)
+	}
+	c, err := srv.newConn(rwc)
+	if err != nil {
+		rwc.Close()
+		srv.endConn(overLimit)
+		return
+	}
+	c.opts = opts
+	c.overLimit = overLimit
+	c.serve()
+}
+
+// ListenReusePort is like net.Listen for TCP but sets SO_REUSEPORT on
+// the socket, so that several processes can listen on the same
+// address, each with its own listener, and the kernel distributes
+// incoming connections between them. Each process must bind with
+// SO_REUSEPORT, as the same user. Connection options such as
+// ReadProxyHeader apply to each connection in the process that
+// accepts it, so they behave the same in all of them.
+//
+// ListenReusePort returns an error on platforms without SO_REUSEPORT.
+func ListenReusePort(network, addr string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: reusePortControl}
+	return lc.Listen(context.Background(), network, addr)
+}
+
+// A ListenerSpec describes one listener of ListenAndServeMulti.
+type ListenerSpec struct {
+	// Network is "tcp", "tcp4", "tcp6" or "unix". If empty, "tcp"
+	// is used.
+	Network string
+
+	// Addr is the address to listen on, in the form net.Listen
+	// expects for Network. For "unix" it is the socket's path,
+	// listened on with ListenUnix.
+	Addr string
+
+	// Perm, if non-zero, sets the permissions of a "unix" socket
+	// file.
+	Perm os.FileMode
+
+	// ReusePort, for TCP, binds the address with ListenReusePort so
+	// that other processes can listen on it too.
+	ReusePort bool
+
+	// Options configures how the listener is served.
+	Options ListenerOptions
+}
+
+// ListenAndServeMulti listens on every address in specs and serves
+// them all concurrently, as ServeWithOptions does. If any address
+// cannot be bound, none are served. When serving any listener fails,
+// or srv is closed, the others are closed too and the first error is
+// returned.
+func (srv *Server) ListenAndServeMulti(specs []ListenerSpec) error {
+	if len(specs) == 0 {
+		return errors.New("http: ListenAndServeMulti with no listeners")
+	}
+	lns := make([]net.Listener, 0, len(specs))
+	opts := make([]ListenerOptions, 0, len(specs))
+	for _, spec := range specs {
+		network := spec.Network
+		if network == "" {
+			network = "tcp"
+		}
+		var l net.Listener
+		var err error
+		if network == "unix" {
+			l, err = srv.listenUnix(spec.Addr, spec.Perm)
+		} else {
+			l, err = srv.listen(network, spec.Addr, spec.ReusePort)
+		}
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+			return err
+		}
+		lns = append(lns, l)
+		opts = append(opts, spec.Options)
+	}
+	return srv.serveAll(lns, opts)
+}
+
+// serveAll serves each of lns with the corresponding opts until one
+// of them fails, then closes the others and returns the first error.
+func (srv *Server) serveAll(lns []net.Listener, opts []ListenerOptions) error {
+	errc := make(chan error, len(lns))
+	for i, l := range lns {
+		go func(l net.Listener, opts ListenerOptions) {
+			errc <- srv.ServeWithOptions(l, opts)
+		}(l, opts[i])
+	}
+	err := <-errc
+	for _, l := range lns {
+		l.Close()
+	}
+	for range lns[1:] {
+		<-errc
+	}
+	return err
+}
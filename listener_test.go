@@ -0,0 +1,278 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addrPreambleConn is a connection whose first line names the client
+// address, a simplified stand-in for the PROXY protocol.
+type addrPreambleConn struct {
+	net.Conn
+	br   *bufio.Reader
+	addr net.Addr
+}
+
+func (c *addrPreambleConn) Read(p []byte) (int, error) { return c.br.Read(p) }
+func (c *addrPreambleConn) RemoteAddr() net.Addr       { return c.addr }
+func (c *addrPreambleConn) UnderlyingConn() net.Conn   { return c.Conn }
+
+func readAddrPreamble(c net.Conn) (net.Conn, error) {
+	br := bufio.NewReader(c)
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	addr, err := net.ResolveTCPAddr("tcp", strings.TrimPrefix(strings.TrimSpace(line), "CLIENT "))
+	if err != nil {
+		return nil, err
+	}
+	return &addrPreambleConn{c, br, addr}, nil
+}
+
+func TestServeWithOptions(t *testing.T) {
+	defer afterTest(t)
+	ca := newTestCA(t)
+	cert, _, _ := ca.issue(t, "example.com")
+
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		fmt.Fprintf(w, "main %s tls=%v", r.RemoteAddr, r.TLS != nil)
+	})}
+	public, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer public.Close()
+	go srv.ServeWithOptions(public, ListenerOptions{
+		WrapConn:  readAddrPreamble,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	})
+	health, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer health.Close()
+	go srv.ServeWithOptions(health, ListenerOptions{
+		MaxHeaderBytes: 1,
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			fmt.Fprintf(w, "health tls=%v", r.TLS != nil)
+		}),
+	})
+
+	get := func(tr *Transport, url string) string {
+		defer tr.CloseIdleConnections()
+		res, err := (&Client{Transport: tr}).Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		return string(b)
+	}
+
+	tr := &Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			c, err := net.Dial(network, addr)
+			if err == nil {
+				io.WriteString(c, "CLIENT 203.0.113.9:1234\n")
+			}
+			return c, err
+		},
+		TLSClientConfig: &tls.Config{ServerName: "example.com", RootCAs: ca.pool()},
+	}
+	if got, want := get(tr, "https://"+public.Addr().String()+"/"), "main 203.0.113.9:1234 tls=true"; got != want {
+		t.Errorf("public listener: got %q; want %q", got, want)
+	}
+	if got, want := get(&Transport{}, "http://"+health.Addr().String()+"/"), "health tls=false"; got != want {
+		t.Errorf("health listener: got %q; want %q", got, want)
+	}
+
+	// The health listener's header limit doesn't apply to the
+	// public listener.
+	c, err := net.Dial("tcp", health.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: x\r\nX-Big: %s\r\n\r\n", strings.Repeat("a", 8<<10))
+	line, _ := bufio.NewReader(c).ReadString('\n')
+	if !strings.Contains(line, "413") {
+		t.Errorf("oversized headers on health listener: got %q; want 413", line)
+	}
+	tr.CloseIdleConnections()
+	req, _ := NewRequest("GET", "https://"+public.Addr().String()+"/", nil)
+	req.Header.Set("X-Big", strings.Repeat("a", 8<<10))
+	res, err := (&Client{Transport: tr}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	tr.CloseIdleConnections()
+	if res.StatusCode != StatusOK {
+		t.Errorf("public listener status = %d; want 200", res.StatusCode)
+	}
+}
+
+func TestServeWithOptionsNoCertificates(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if err := new(Server).ServeWithOptions(ln, ListenerOptions{TLSConfig: &tls.Config{}}); err == nil {
+		t.Error("ServeWithOptions with certificate-less TLSConfig succeeded")
+	}
+}
+
+func TestListenAndServeMulti(t *testing.T) {
+	defer afterTest(t)
+	dir, err := ioutil.TempDir("", "multi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "http.sock")
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcpAddr := ln.Addr().String()
+	ln.Close()
+
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "main")
+	})}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServeMulti([]ListenerSpec{
+			{Network: "tcp4", Addr: tcpAddr},
+			{Network: "unix", Addr: sock, Options: ListenerOptions{
+				Handler: HandlerFunc(func(w ResponseWriter, r *Request) { io.WriteString(w, "unix") }),
+			}},
+		})
+	}()
+
+	get := func(network, addr string) (string, error) {
+		tr := &Transport{Dial: func(string, string) (net.Conn, error) { return net.Dial(network, addr) }}
+		defer tr.CloseIdleConnections()
+		res, err := (&Client{Transport: tr}).Get("http://example.com/")
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		b, err := ioutil.ReadAll(res.Body)
+		return string(b), err
+	}
+	for i := 0; ; i++ {
+		got, err := get("tcp4", tcpAddr)
+		if err == nil {
+			if got != "main" {
+				t.Errorf("tcp4 listener: got %q; want main", got)
+			}
+			break
+		}
+		if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, err := get("unix", sock); err != nil || got != "unix" {
+		t.Errorf("unix listener: got %q, %v; want unix", got, err)
+	}
+
+	srv.Close()
+	select {
+	case err := <-errc:
+		if err != ErrServerClosed {
+			t.Errorf("ListenAndServeMulti = %v; want ErrServerClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListenAndServeMulti did not return after Close")
+	}
+	if _, err := get("tcp4", tcpAddr); err == nil {
+		t.Error("tcp4 listener still serving after Close")
+	}
+}
+
+func TestListenAndServeMultiBindError(t *testing.T) {
+	busy, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	free := ln.Addr().String()
+	ln.Close()
+
+	err = new(Server).ListenAndServeMulti([]ListenerSpec{
+		{Addr: free},
+		{Addr: busy.Addr().String()},
+	})
+	if err == nil {
+		t.Fatal("ListenAndServeMulti with an address in use succeeded")
+	}
+	// The listener bound before the failure was released.
+	ln, err = net.Listen("tcp4", free)
+	if err != nil {
+		t.Fatalf("first address not released: %v", err)
+	}
+	ln.Close()
+}
+
+func TestListenReusePort(t *testing.T) {
+	defer afterTest(t)
+	l1, err := ListenReusePort("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("SO_REUSEPORT unavailable: %v", err)
+	}
+	defer l1.Close()
+	addr := l1.Addr().String()
+	l2, err := ListenReusePort("tcp4", addr)
+	if err != nil {
+		t.Fatalf("second listener on %s: %v", addr, err)
+	}
+	defer l2.Close()
+	if l, err := net.Listen("tcp4", addr); err == nil {
+		l.Close()
+		t.Error("listening without SO_REUSEPORT on a shared address succeeded")
+	}
+
+	for i, l := range []net.Listener{l1, l2} {
+		name := []string{"one", "two"}[i]
+		go (&Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			io.WriteString(w, name)
+		})}).Serve(l)
+	}
+	// The kernel spreads new connections over both listeners.
+	c := &Client{Transport: &Transport{DisableKeepAlives: true}}
+	seen := map[string]bool{}
+	for i := 0; i < 200 && len(seen) < 2; i++ {
+		res, err := c.Get("http://" + addr + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		seen[string(b)] = true
+	}
+	if !seen["one"] || !seen["two"] {
+		t.Errorf("responses came from %v; want both listeners", seen)
+	}
+}
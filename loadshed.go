@@ -0,0 +1,173 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Adaptive load shedding.
+
+package http
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var errOverloaded = errors.New("http: server overloaded")
+
+const (
+	// defaultShedInterval is the Interval of LoadShedders without one.
+	defaultShedInterval = 100 * time.Millisecond
+
+	// shedStep is how much a LoadShedder raises the fraction of
+	// requests it sheds after each interval overloaded, up to
+	// maxShed. Some requests always pass, so that the latency keeps
+	// being measured.
+	shedStep = 0.1
+	maxShed  = 0.9
+)
+
+// A LoadShedder answers a fraction of the requests of the handler it
+// wraps with 503 Service Unavailable and a Retry-After header while it
+// is overloaded, so that the rest are still answered promptly.
+//
+// As with the CoDel queue discipline, the handler is overloaded during
+// an interval if even the fastest request finished in that interval took
+// longer than TargetLatency, as a few slow requests are normal but a
+// standing queue is not, or if more requests than MaxInFlight, or a
+// QueueDepth over MaxQueueDepth, were seen. Each interval overloaded
+// raises the fraction shed by a tenth, up to nine tenths, and each
+// other halves it.
+//
+// Its fields must not be changed once it is in use.
+type LoadShedder struct {
+	// Handler is the handler protected.
+	Handler Handler
+
+	// TargetLatency, if positive, is the latency of the handler's
+	// fastest requests above which it is overloaded.
+	TargetLatency time.Duration
+
+	// Interval is how often the load is assessed. If zero, every
+	// 100ms.
+	Interval time.Duration
+
+	// MaxInFlight, if positive, is the most requests in the handler
+	// at once before it is overloaded.
+	MaxInFlight int
+
+	// QueueDepth optionally reports the length of a queue ahead of
+	// the handler, such as of connections waiting to be accepted or
+	// of requests queued by a ConcurrencyLimiter, and MaxQueueDepth
+	// the length above which the handler is overloaded. QueueDepth
+	// is called once each interval, and must not block.
+	QueueDepth    func() int
+	MaxQueueDepth int
+
+	// Exempt, if non-nil, reports whether r is never shed nor
+	// measured, such as for health checks, whose failure would
+	// take the server out of its load balancer and worsen the
+	// overload elsewhere.
+	Exempt func(r *Request) bool
+
+	// RetryAfter is sent in the Retry-After header of the requests
+	// shed, rounded up to seconds. If zero, one second.
+	RetryAfter time.Duration
+
+	mu         sync.Mutex
+	inFlight   int
+	peak       int           // most requests in flight this interval
+	start      time.Time     // of the interval
+	minLatency time.Duration // this interval, or -1 if none finished
+	shed       float64
+}
+
+// Shedding returns the fraction of requests l is shedding, from 0 to
+// 0.9, for exporting as a metric.
+func (l *LoadShedder) Shedding() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.shed
+}
+
+func (l *LoadShedder) ServeHTTP(w ResponseWriter, r *Request) {
+	if l.Exempt != nil && l.Exempt(r) {
+		l.Handler.ServeHTTP(w, r)
+		return
+	}
+	start := time.Now()
+	if !l.admit(start) {
+		secs := int64((l.RetryAfter + time.Second - 1) / time.Second)
+		if secs < 1 {
+			secs = 1
+		}
+		w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+		respondError(w, r, StatusServiceUnavailable, "Service Unavailable: server overloaded", errOverloaded)
+		return
+	}
+	defer l.done(start)
+	l.Handler.ServeHTTP(w, r)
+}
+
+// admit reports whether a request arriving at now is to be served,
+// counting it in flight if so.
+func (l *LoadShedder) admit(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.assessLocked(now)
+	if l.shed > 0 && rand.Float64() < l.shed {
+		return false
+	}
+	l.inFlight++
+	if l.inFlight > l.peak {
+		l.peak = l.inFlight
+	}
+	return true
+}
+
+// done records the latency of a request served from start.
+func (l *LoadShedder) done(start time.Time) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	l.assessLocked(now)
+	if d := now.Sub(start); l.minLatency < 0 || d < l.minLatency {
+		l.minLatency = d
+	}
+}
+
+// assessLocked adjusts the fraction shed if the interval is over as
+// of now, and starts the next.
+func (l *LoadShedder) assessLocked(now time.Time) {
+	if l.start.IsZero() {
+		l.start, l.minLatency = now, -1
+		return
+	}
+	interval := l.Interval
+	if interval <= 0 {
+		interval = defaultShedInterval
+	}
+	elapsed := now.Sub(l.start)
+	if elapsed < interval {
+		return
+	}
+	latency := l.minLatency
+	if latency < 0 && l.inFlight > 0 {
+		// None finished: those in flight took the whole interval.
+		latency = elapsed
+	}
+	overloaded := l.TargetLatency > 0 && latency > l.TargetLatency ||
+		l.MaxInFlight > 0 && l.peak > l.MaxInFlight ||
+		l.QueueDepth != nil && l.QueueDepth() > l.MaxQueueDepth
+	if overloaded {
+		l.shed += shedStep
+		if l.shed > maxShed {
+			l.shed = maxShed
+		}
+	} else if l.shed /= 2; l.shed < 0.01 {
+		l.shed = 0
+	}
+	l.start, l.minLatency, l.peak = now, -1, l.inFlight
+}
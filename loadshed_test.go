@@ -0,0 +1,84 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	. "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoadShedder(t *testing.T) {
+	l := &LoadShedder{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			if r.URL.Path == "/slow" {
+				time.Sleep(5 * time.Millisecond)
+			}
+		}),
+		TargetLatency: 2 * time.Millisecond,
+		Interval:      4 * time.Millisecond,
+		Exempt:        func(r *Request) bool { return r.URL.Path == "/healthz" },
+	}
+	serve := func(path string) int {
+		req, _ := NewRequest("GET", "http://example.com"+path, nil)
+		rec := httptest.NewRecorder()
+		l.ServeHTTP(rec, req)
+		if rec.Code == 503 && rec.Header().Get("Retry-After") != "1" {
+			t.Errorf("shed request's Retry-After = %q; want 1", rec.Header().Get("Retry-After"))
+		}
+		return rec.Code
+	}
+
+	for i := 0; i < 200 && l.Shedding() < 0.9; i++ {
+		if serve("/slow") == 503 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if s := l.Shedding(); s != 0.9 {
+		t.Fatalf("after slow requests: shedding %v; want 0.9", s)
+	}
+	shed := 0
+	for i := 0; i < 20; i++ {
+		if serve("/fast") == 503 {
+			shed++
+		}
+	}
+	if shed == 0 {
+		t.Error("no requests shed while overloaded")
+	}
+	for i := 0; i < 20; i++ {
+		if code := serve("/healthz"); code != 200 {
+			t.Fatalf("exempt request while shedding: %d; want 200", code)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for l.Shedding() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("shedding %v after fast requests; want 0", l.Shedding())
+		}
+		serve("/fast")
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLoadShedderQueueDepth(t *testing.T) {
+	depth := 10
+	l := &LoadShedder{
+		Handler:       HandlerFunc(func(w ResponseWriter, r *Request) {}),
+		Interval:      time.Millisecond,
+		QueueDepth:    func() int { return depth },
+		MaxQueueDepth: 5,
+	}
+	req, _ := NewRequest("GET", "http://example.com/", nil)
+	for i := 0; i < 3; i++ {
+		l.ServeHTTP(httptest.NewRecorder(), req)
+		time.Sleep(2 * time.Millisecond)
+	}
+	if s := l.Shedding(); s == 0 {
+		t.Errorf("shedding %v with a long queue; want some", s)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Minimum transfer rates, against slow clients.
+
+package http
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// A MinDataRate is a minimum average rate of transfer. Only the time
+// spent waiting for the client counts, not the time a handler takes
+// between reads or writes.
+type MinDataRate struct {
+	// BytesPerSecond is the rate.
+	BytesPerSecond float64
+
+	// GracePeriod is how long the transfer may take before the
+	// rate is enforced, to allow for TCP slow start.
+	GracePeriod time.Duration
+}
+
+// ErrBodyReadTooSlow is returned by reads of a Request.Body sent more
+// slowly than the Server's MinRequestBodyRate. The connection is
+// closed after the reply.
+var ErrBodyReadTooSlow = errors.New("http: request body sent too slowly")
+
+// rateMeter measures a transfer against a MinDataRate.
+type rateMeter struct {
+	rate   *MinDataRate
+	n      int64         // bytes transferred
+	active time.Duration // time spent transferring them
+}
+
+// deadline returns the time by which, starting at now, the next want
+// bytes must be transferred to keep up the rate.
+func (m *rateMeter) deadline(now time.Time, want int) time.Time {
+	allowed := time.Duration(float64(m.n+int64(want)) / m.rate.BytesPerSecond * float64(time.Second))
+	if allowed < m.rate.GracePeriod {
+		allowed = m.rate.GracePeriod
+	}
+	return now.Add(allowed - m.active)
+}
+
+func (m *rateMeter) add(start time.Time, n int) {
+	m.n += int64(n)
+	m.active += time.Since(start)
+}
+
+// earlier returns the earlier of the deadlines a and b, the zero time
+// meaning none.
+func earlier(a, b time.Time) time.Time {
+	if b.IsZero() || (!a.IsZero() && a.Before(b)) {
+		return a
+	}
+	return b
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+func (c *conn) setReadDeadline(t time.Time) error {
+	c.readDeadline = t
+	return c.rwc.SetReadDeadline(t)
+}
+
+func (c *conn) setWriteDeadline(t time.Time) error {
+	c.writeDeadline = t
+	return c.rwc.SetWriteDeadline(t)
+}
+
+// minRateBody is the Body of a request read by a Server with a
+// MinRequestBodyRate.
+type minRateBody struct {
+	rc    io.ReadCloser
+	w     *response
+	meter rateMeter
+}
+
+func (b *minRateBody) Read(p []byte) (int, error) {
+	c := b.w.conn
+	if c.hijacked() || c.buf.Reader.Buffered() > 0 {
+		return b.rc.Read(p)
+	}
+	now := time.Now()
+	dl := b.meter.deadline(now, 1)
+	c.rwc.SetReadDeadline(earlier(dl, c.readDeadline))
+	n, err := b.rc.Read(p)
+	b.meter.add(now, n)
+	if c.hijacked() {
+		return n, err
+	}
+	c.rwc.SetReadDeadline(c.readDeadline)
+	if err != nil && isTimeout(err) && (c.readDeadline.IsZero() || dl.Before(c.readDeadline)) {
+		c.mu.Lock()
+		c.slowBody = true
+		c.mu.Unlock()
+		err = ErrBodyReadTooSlow
+	}
+	return n, err
+}
+
+// bodyTooSlow reports whether a request body was sent below the
+// MinRequestBodyRate, after which the connection is closed. The body
+// may be read concurrently with writing the response.
+func (c *conn) bodyTooSlow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.slowBody
+}
+
+func (b *minRateBody) Close() error {
+	return b.rc.Close()
+}
+
+// minRateWriter writes to the connection of c at no less than its
+// Server's MinResponseWriteRate, closing it otherwise.
+type minRateWriter struct {
+	c     *conn
+	rwc   net.Conn
+	w     io.Writer
+	meter rateMeter
+}
+
+func (mw *minRateWriter) Write(p []byte) (int, error) {
+	c := mw.c
+	if c.hijacked() {
+		return mw.w.Write(p)
+	}
+	now := time.Now()
+	dl := mw.meter.deadline(now, len(p))
+	mw.rwc.SetWriteDeadline(earlier(dl, c.writeDeadline))
+	n, err := mw.w.Write(p)
+	mw.meter.add(now, n)
+	if c.hijacked() {
+		return n, err
+	}
+	mw.rwc.SetWriteDeadline(c.writeDeadline)
+	if err != nil && isTimeout(err) {
+		// The response is cut short; the connection is of no
+		// further use.
+		mw.rwc.Close()
+	}
+	return n, err
+}
@@ -0,0 +1,109 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerMinRequestBodyRate(t *testing.T) {
+	defer afterTest(t)
+	errc := make(chan error, 1)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		_, err := ioutil.ReadAll(r.Body)
+		errc <- err
+	}))
+	ts.Config.MinRequestBodyRate = &MinDataRate{BytesPerSecond: 1000, GracePeriod: 100 * time.Millisecond}
+	ts.Start()
+	defer ts.Close()
+
+	// A body sent at once is read.
+	res, err := Post(ts.URL, "text/plain", strings.NewReader(strings.Repeat("x", 10000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if err := <-errc; err != nil {
+		t.Fatalf("fast body: %v", err)
+	}
+
+	// A body trickled at 20 bytes per second is not.
+	c, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "POST / HTTP/1.1\r\nHost: foo\r\nContent-Length: 100\r\n\r\n")
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		for i := 0; i < 100; i++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+			if _, err := c.Write([]byte("x")); err != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-errc:
+		if err != ErrBodyReadTooSlow {
+			t.Fatalf("slow body: got error %v; want ErrBodyReadTooSlow", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("slow body still being read")
+	}
+	// The connection is closed, or reset as the client is still
+	// sending.
+	c.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = ioutil.ReadAll(c)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("connection not closed after reply")
+	}
+}
+
+func TestServerMinResponseWriteRate(t *testing.T) {
+	defer afterTest(t)
+	errc := make(chan error, 1)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		chunk := make([]byte, 32<<10)
+		for i := 0; i < 1<<15; i++ {
+			if _, err := w.Write(chunk); err != nil {
+				errc <- err
+				return
+			}
+		}
+		errc <- nil
+	}))
+	ts.Config.MinResponseWriteRate = &MinDataRate{BytesPerSecond: 10 << 20, GracePeriod: 100 * time.Millisecond}
+	ts.Start()
+	defer ts.Close()
+
+	// A client that never reads the response.
+	c, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: foo\r\n\r\n")
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("1GB written to a client not reading")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("response still being written")
+	}
+}
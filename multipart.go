@@ -0,0 +1,219 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Streaming of multipart/form-data request bodies under limits.
+
+package http
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+)
+
+// Errors of a MultipartStream past its limits.
+var (
+	ErrPartTooLarge = errors.New("http: multipart part too large")
+	ErrTooManyParts = errors.New("http: too many multipart parts")
+)
+
+// MultipartLimits limits what a MultipartStream reads of a request
+// body and how it stores the parts it spools, and reports its
+// progress.
+type MultipartLimits struct {
+	// MaxMemory is the number of bytes of the parts spooled by
+	// Spool kept in memory in all. Parts that don't fit are stored
+	// in temporary files instead. If zero, 32 MB are, as with
+	// FormValue and FormFile.
+	MaxMemory int64
+
+	// MaxPartSize, if positive, is the size of a part beyond which
+	// reading it fails with ErrPartTooLarge.
+	MaxPartSize int64
+
+	// MaxParts, if positive, is the number of parts beyond which
+	// NextPart fails with ErrTooManyParts.
+	MaxParts int
+
+	// TempDir is the directory of the temporary files. If empty,
+	// os.TempDir is used.
+	TempDir string
+
+	// Progress, if non-nil, is called as each part arrives: by
+	// NextPart with n of 0 once the part's header is read, and
+	// then after each read of its content, with the n bytes of it
+	// read so far. If it returns an error, NextPart or the read,
+	// and any later reads of the part, return the error instead,
+	// so that uploads that break a policy stop early.
+	Progress func(p *StreamPart, n int64) error
+}
+
+// A MultipartStream reads the parts of a multipart/form-data request
+// body one at a time, as they arrive, under limits on their sizes and
+// number. Unlike with ParseMultipartForm, the handler decides which
+// parts to keep, and Spool keeps them in memory or on disk.
+type MultipartStream struct {
+	mr     *multipart.Reader
+	limits MultipartLimits
+	parts  int      // returned by NextPart
+	mem    int64    // bytes of memory Spool may still use
+	files  []string // temporary files created by Spool
+}
+
+// MultipartStream returns a MultipartStream of the multipart/form-data
+// body of r, under limits, which may be nil for no limits but the
+// default MaxMemory. As with MultipartReader, the body isn't also
+// parsed by ParseMultipartForm.
+func (r *Request) MultipartStream(limits *MultipartLimits) (*MultipartStream, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	s := &MultipartStream{mr: mr}
+	if limits != nil {
+		s.limits = *limits
+	}
+	s.mem = s.limits.MaxMemory
+	if s.mem <= 0 {
+		s.mem = defaultMaxMemory
+	}
+	return s, nil
+}
+
+// NextPart returns the next part of the body, or io.EOF after the
+// last one. Reading it fails once it's longer than MaxPartSize.
+func (s *MultipartStream) NextPart() (*StreamPart, error) {
+	p, err := s.mr.NextPart()
+	if err != nil {
+		return nil, err
+	}
+	if s.limits.MaxParts > 0 && s.parts >= s.limits.MaxParts {
+		p.Close()
+		return nil, ErrTooManyParts
+	}
+	s.parts++
+	sp := &StreamPart{Part: p, max: s.limits.MaxPartSize, progress: s.limits.Progress}
+	if sp.progress != nil {
+		if err := sp.progress(sp, 0); err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
+	return sp, nil
+}
+
+// A StreamPart is a part of a body read by a MultipartStream.
+type StreamPart struct {
+	*multipart.Part
+	n        int64 // bytes read
+	max      int64 // MaxPartSize, if positive
+	progress func(*StreamPart, int64) error
+	err      error // returned by progress
+}
+
+// Read reads the part's content, failing with ErrPartTooLarge past
+// its stream's MaxPartSize or with the error of its Progress.
+func (p *StreamPart) Read(b []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	// Read one byte past the limit to tell whether there is more.
+	if left := p.max - p.n + 1; p.max > 0 && int64(len(b)) > left {
+		b = b[:left]
+	}
+	n, err := p.Part.Read(b)
+	p.n += int64(n)
+	if p.max > 0 && p.n > p.max {
+		n -= int(p.n - p.max)
+		p.n = p.max
+		return n, ErrPartTooLarge
+	}
+	if p.progress != nil && n > 0 {
+		if perr := p.progress(p, p.n); perr != nil {
+			p.err = perr
+			return n, perr
+		}
+	}
+	return n, err
+}
+
+// A SpooledFile is the content of a part, stored by Spool in memory
+// or in a temporary file.
+type SpooledFile struct {
+	FormName string
+	FileName string
+	Header   textproto.MIMEHeader
+	Size     int64
+
+	content []byte
+	path    string // of the temporary file, if any
+}
+
+// Spool reads the rest of p and stores it, in memory if it fits within
+// MaxMemory, less what earlier parts use, or else in a temporary file
+// in TempDir, which RemoveAll removes.
+func (s *MultipartStream) Spool(p *StreamPart) (*SpooledFile, error) {
+	f := &SpooledFile{FormName: p.FormName(), FileName: p.FileName(), Header: p.Header}
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, p, s.mem+1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n <= s.mem {
+		s.mem -= n
+		f.content, f.Size = buf.Bytes(), n
+		return f, nil
+	}
+	tmp, err := ioutil.TempFile(s.limits.TempDir, "multipart-")
+	if err != nil {
+		return nil, err
+	}
+	f.path = tmp.Name()
+	size, err := io.Copy(tmp, io.MultiReader(&buf, p))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.path)
+		return nil, err
+	}
+	s.files = append(s.files, f.path)
+	f.Size = size
+	return f, nil
+}
+
+// RemoveAll removes the temporary files of the parts spooled by s.
+func (s *MultipartStream) RemoveAll() error {
+	var err error
+	for _, name := range s.files {
+		if e := os.Remove(name); e != nil && err == nil {
+			err = e
+		}
+	}
+	s.files = nil
+	return err
+}
+
+// OnDisk reports whether f is stored in a temporary file.
+func (f *SpooledFile) OnDisk() bool {
+	return f.path != ""
+}
+
+// Open opens the content of f.
+func (f *SpooledFile) Open() (multipart.File, error) {
+	if f.path != "" {
+		return os.Open(f.path)
+	}
+	return sectionReadCloser{io.NewSectionReader(bytes.NewReader(f.content), 0, int64(len(f.content)))}, nil
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+}
+
+func (sectionReadCloser) Close() error { return nil }
@@ -0,0 +1,202 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	. "net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// multipartRequest returns a POST request whose multipart/form-data
+// body has a field for each of fields, as name=value, and a file for
+// each of files.
+func multipartRequest(t *testing.T, fields, files []string) *Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, kv := range fields {
+		i := strings.Index(kv, "=")
+		mw.WriteField(kv[:i], kv[i+1:])
+	}
+	for _, kv := range files {
+		i := strings.Index(kv, "=")
+		w, err := mw.CreateFormFile(kv[:i], kv[:i]+".txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, kv[i+1:])
+	}
+	mw.Close()
+	req, err := NewRequest("POST", "http://example.com/upload", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestMultipartStreamSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "multipart-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	big := strings.Repeat("x", 10000)
+	req := multipartRequest(t, []string{"a=small"}, []string{"f=" + big, "g=tiny"})
+	s, err := req.MultipartStream(&MultipartLimits{MaxMemory: 100, TempDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := req.MultipartStream(nil); err == nil {
+		t.Error("second MultipartStream succeeded")
+	}
+	var spooled []*SpooledFile
+	for {
+		p, err := s.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := s.Spool(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spooled = append(spooled, f)
+	}
+
+	want := []struct {
+		name, content string
+		onDisk        bool
+	}{
+		{"a", "small", false},
+		{"f", big, true},
+		{"g", "tiny", false},
+	}
+	if len(spooled) != len(want) {
+		t.Fatalf("spooled %d parts; want %d", len(spooled), len(want))
+	}
+	for i, w := range want {
+		f := spooled[i]
+		if f.FormName != w.name || f.OnDisk() != w.onDisk || f.Size != int64(len(w.content)) {
+			t.Errorf("part %d: name %q, on disk %v, size %d; want %q, %v, %d",
+				i, f.FormName, f.OnDisk(), f.Size, w.name, w.onDisk, len(w.content))
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if string(got) != w.content {
+			t.Errorf("part %d: content of %d bytes; want %d", i, len(got), len(w.content))
+		}
+	}
+	if names, _ := ioutil.ReadDir(dir); len(names) != 1 {
+		t.Errorf("%d temporary files; want 1", len(names))
+	}
+	if err := s.RemoveAll(); err != nil {
+		t.Error(err)
+	}
+	if names, _ := ioutil.ReadDir(dir); len(names) != 0 {
+		t.Errorf("%d temporary files after RemoveAll; want 0", len(names))
+	}
+}
+
+func TestMultipartStreamLimits(t *testing.T) {
+	req := multipartRequest(t, []string{"a=0123456789", "b=01234567890"}, nil)
+	s, err := req.MultipartStream(&MultipartLimits{MaxPartSize: 10, MaxParts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := s.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(p); err != nil || string(b) != "0123456789" {
+		t.Errorf("part of MaxPartSize: read %q, %v", b, err)
+	}
+	if _, err := s.NextPart(); err != ErrTooManyParts {
+		t.Errorf("NextPart past MaxParts: %v; want ErrTooManyParts", err)
+	}
+
+	req = multipartRequest(t, []string{"a=01234567890"}, nil)
+	s, _ = req.MultipartStream(&MultipartLimits{MaxPartSize: 10, MaxParts: 1})
+	p, _ = s.NextPart()
+	if b, err := ioutil.ReadAll(p); err != ErrPartTooLarge || string(b) != "0123456789" {
+		t.Errorf("part past MaxPartSize: read %q, %v; want the first 10 bytes and ErrPartTooLarge", b, err)
+	}
+	// MaxParts parts are allowed.
+	if _, err := s.NextPart(); err != io.EOF {
+		t.Errorf("NextPart after the last part: %v; want EOF", err)
+	}
+}
+
+func TestMultipartStreamProgress(t *testing.T) {
+	errPolicy := errors.New("policy")
+	big := strings.Repeat("x", 5000)
+	req := multipartRequest(t, []string{"a=small"}, []string{"f=" + big, "exe=MZ"})
+	var calls []string
+	var last int64
+	s, err := req.MultipartStream(&MultipartLimits{
+		Progress: func(p *StreamPart, n int64) error {
+			if n == 0 {
+				calls = append(calls, p.FormName()+" "+p.Header.Get("Content-Type"))
+				if p.FormName() == "exe" {
+					return errPolicy
+				}
+			} else if n < last {
+				t.Errorf("%s: progress went back from %d to %d", p.FormName(), last, n)
+			}
+			last = n
+			if n > 1000 {
+				return errPolicy
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := s.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(p); err != nil || string(b) != "small" {
+		t.Errorf("part a: read %q, %v", b, err)
+	}
+	if last != 5 {
+		t.Errorf("part a: progress reported %d bytes; want 5", last)
+	}
+
+	p, err = s.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Spool(p); err != errPolicy {
+		t.Errorf("Spool of part f: %v; want the Progress error", err)
+	}
+	if _, err := p.Read(make([]byte, 1)); err != errPolicy {
+		t.Errorf("Read after the Progress error: %v; want the Progress error", err)
+	}
+
+	if _, err := s.NextPart(); err != errPolicy {
+		t.Errorf("NextPart of part exe: %v; want the Progress error", err)
+	}
+	want := []string{"a ", "f application/octet-stream", "exe application/octet-stream"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("parts reported %q; want %q", calls, want)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The radix tree ServeMux, and the Routes of middleware, look patterns
+// up in.
+
+package http
+
+// A muxNode is a node of a radix tree of patterns. The key of a node
+// is the concatenation of the prefixes of the nodes from the root to
+// it, and its entry, if any, that of the pattern equal to the key.
+type muxNode struct {
+	prefix   string
+	entry    *muxEntry
+	indices  string     // first bytes of the prefixes of children
+	children []*muxNode // in the order of indices
+}
+
+// child returns the child of n whose prefix starts with c, or nil.
+func (n *muxNode) child(c byte) *muxNode {
+	for i := 0; i < len(n.indices); i++ {
+		if n.indices[i] == c {
+			return n.children[i]
+		}
+	}
+	return nil
+}
+
+// insert adds the pattern key to the tree rooted at n, which has an
+// empty prefix, replacing the entry of key if it has one.
+func (n *muxNode) insert(key string, e muxEntry) {
+	for {
+		if key == "" {
+			n.entry = &e
+			return
+		}
+		c := n.child(key[0])
+		if c == nil {
+			n.indices += key[:1]
+			n.children = append(n.children, &muxNode{prefix: key, entry: &e})
+			return
+		}
+		i := 0
+		for i < len(key) && i < len(c.prefix) && key[i] == c.prefix[i] {
+			i++
+		}
+		if i < len(c.prefix) {
+			// Split c, leaving its start in place.
+			tail := *c
+			tail.prefix = c.prefix[i:]
+			*c = muxNode{
+				prefix:   c.prefix[:i],
+				indices:  tail.prefix[:1],
+				children: []*muxNode{&tail},
+			}
+		}
+		n, key = c, key[i:]
+	}
+}
+
+// match returns the entry of the longest pattern in the tree rooted
+// at n matching path: the pattern equal to path or, failing that, the
+// longest one ending in a slash that is a prefix of path. It returns
+// nil if none does.
+func (n *muxNode) match(path string) *muxEntry {
+	var best *muxEntry
+	i := 0 // bytes of path matched by the nodes so far
+	for {
+		if len(path)-i < len(n.prefix) || path[i:i+len(n.prefix)] != n.prefix {
+			return best
+		}
+		i += len(n.prefix)
+		if n.entry != nil && (i == len(path) || path[i-1] == '/') {
+			best = n.entry
+		}
+		if i == len(path) {
+			return best
+		}
+		if n = n.child(path[i]); n == nil {
+			return best
+		}
+	}
+}
+
+// A routeTree matches paths with patterns, ServeMux patterns without a
+// host, such as the keys of the Routes of middleware, as ServeMux does.
+type routeTree struct {
+	root muxNode
+}
+
+// newRouteTree returns the routeTree of patterns. Empty patterns match
+// no path.
+func newRouteTree(patterns []string) *routeTree {
+	t := new(routeTree)
+	for _, p := range patterns {
+		if p != "" {
+			t.root.insert(p, muxEntry{pattern: p})
+		}
+	}
+	return t
+}
+
+// match returns the longest pattern matching path, and whether one
+// does.
+func (t *routeTree) match(path string) (pattern string, ok bool) {
+	if e := t.root.match(path); e != nil {
+		return e.pattern, true
+	}
+	return "", false
+}
+
+// pathMatch reports whether path matches pattern, as a ServeMux
+// pattern without a host: exactly or, if pattern ends in a slash, as
+// part of the subtree it names.
+func pathMatch(pattern, path string) bool {
+	n := len(pattern)
+	if n > 0 && pattern[n-1] == '/' {
+		return len(path) >= n && path[:n] == pattern
+	}
+	return path == pattern
+}
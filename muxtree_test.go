@@ -0,0 +1,97 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"math/rand"
+	. "net/http"
+	"strings"
+	"testing"
+)
+
+// linearMatch returns the pattern a ServeMux with patterns should
+// match path with, found by trying each.
+func linearMatch(patterns []string, host, path string) string {
+	best := ""
+	for _, hostFirst := range []bool{true, false} {
+		key := path
+		if hostFirst {
+			key = host + path
+		}
+		for _, p := range patterns {
+			if (hostFirst != (p[0] != '/')) || len(p) <= len(best) {
+				continue
+			}
+			if p == key || p[len(p)-1] == '/' && strings.HasPrefix(key, p) {
+				best = p
+			}
+		}
+		if best != "" {
+			return best
+		}
+	}
+	return ""
+}
+
+func TestServeMuxTree(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	segs := []string{"a", "ab", "abc", "b", "api", "v1", "v2", "users", "user", "u"}
+	randPath := func() string {
+		var b []byte
+		for i, n := 0, 1+r.Intn(4); i < n; i++ {
+			b = append(b, '/')
+			b = append(b, segs[r.Intn(len(segs))]...)
+		}
+		if r.Intn(2) == 0 {
+			b = append(b, '/')
+		}
+		return string(b)
+	}
+	for iter := 0; iter < 50; iter++ {
+		mux := NewServeMux()
+		seen := make(map[string]bool)
+		var patterns []string
+		for i := 0; i < 40; i++ {
+			p := randPath()
+			if r.Intn(5) == 0 {
+				p = "example.com" + p
+			}
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			patterns = append(patterns, p)
+			mux.Handle(p, NotFoundHandler())
+		}
+		// The implicit redirects of subtrees count as patterns too,
+		// unless registered.
+		all := append([]string(nil), patterns...)
+		for _, p := range patterns {
+			if p[len(p)-1] == '/' && !seen[p[:len(p)-1]] {
+				all = append(all, p[:len(p)-1])
+			}
+		}
+		for i := 0; i < 200; i++ {
+			path := randPath()
+			host := "other.example"
+			if r.Intn(2) == 0 {
+				host = "example.com"
+			}
+			req, err := NewRequest("GET", "http://"+host+path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, got := mux.Handler(req)
+			want := linearMatch(all, host, path)
+			if want != "" && want[len(want)-1] != '/' && seen[want+"/"] && !seen[want] {
+				// An implicit redirect reports its subtree.
+				want += "/"
+			}
+			if got != want {
+				t.Fatalf("patterns %q: %s%s matched %q; want %q", patterns, host, path, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Content negotiation with the Accept, Accept-Encoding and
+// Accept-Language request headers.
+
+package http
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Accepts returns the media type of offers, such as "text/html" or
+// "application/json", that the Accept header of r prefers, or "" if
+// it accepts none. The quality values of the header decide, the most
+// specific of its ranges matching an offer giving the offer's, and
+// ties go to the earlier offer. Without an Accept header, the first
+// offer is returned.
+func (r *Request) Accepts(offers ...string) string {
+	ranges, ok := r.acceptRanges("Accept")
+	if !ok {
+		return firstOffer(offers)
+	}
+	return negotiate(ranges, offers, matchMediaType, "")
+}
+
+// AcceptsEncoding returns the content coding of offers, such as
+// "gzip" or "identity", that the Accept-Encoding header of r prefers,
+// or "" if it accepts none, as Accepts does. The identity coding is
+// acceptable unless excluded, as with "identity;q=0" or "*;q=0", so
+// without an Accept-Encoding header it's the only one.
+func (r *Request) AcceptsEncoding(offers ...string) string {
+	ranges, _ := r.acceptRanges("Accept-Encoding")
+	return negotiate(ranges, offers, matchToken, "identity")
+}
+
+// AcceptsLanguage returns the language tag of offers, such as "en-US",
+// that the Accept-Language header of r prefers, or "" if it accepts
+// none, as Accepts does. A range such as "en" matches an offer of its
+// own or its subtags, such as "en-GB".
+func (r *Request) AcceptsLanguage(offers ...string) string {
+	ranges, ok := r.acceptRanges("Accept-Language")
+	if !ok {
+		return firstOffer(offers)
+	}
+	return negotiate(ranges, offers, matchLanguage, "")
+}
+
+func firstOffer(offers []string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	return offers[0]
+}
+
+// An acceptRange is an element of an Accept, Accept-Encoding or
+// Accept-Language header.
+type acceptRange struct {
+	value  string   // lower-cased
+	params []string // media type parameters but q, as lower-cased name=value
+	q      float64
+}
+
+// acceptRanges returns the ranges of the header named key of r, and
+// whether r has the header.
+func (r *Request) acceptRanges(key string) ([]acceptRange, bool) {
+	vv, ok := r.Header[key]
+	if !ok {
+		return nil, false
+	}
+	var ranges []acceptRange
+	for _, v := range vv {
+		ranges = parseAccept(ranges, v)
+	}
+	return ranges, true
+}
+
+// parseAccept appends to ranges those of the header value v, skipping
+// elements with malformed quality values.
+func parseAccept(ranges []acceptRange, v string) []acceptRange {
+	for _, elem := range strings.Split(v, ",") {
+		params := strings.Split(elem, ";")
+		ar := acceptRange{value: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		if ar.value == "" {
+			continue
+		}
+		ok := true
+		for _, p := range params[1:] {
+			p = strings.ToLower(strings.TrimSpace(p))
+			if strings.HasPrefix(p, "q=") {
+				q, err := strconv.ParseFloat(p[2:], 64)
+				if err != nil || q < 0 || q > 1 {
+					ok = false
+				}
+				ar.q = q
+				// Parameters after the quality value are accept
+				// extensions, not part of the range.
+				break
+			}
+			if p != "" {
+				ar.params = append(ar.params, strings.Replace(p, " ", "", -1))
+			}
+		}
+		if ok {
+			ranges = append(ranges, ar)
+		}
+	}
+	return ranges
+}
+
+// negotiate returns the offer with the highest quality under ranges,
+// from the most specific range match says matches it, or "" if none
+// has a quality above zero. Offers no range matches have none, but for
+// def, which has a quality of 1.
+func negotiate(ranges []acceptRange, offers []string, match func(ar *acceptRange, offer string) (specificity int, ok bool), def string) string {
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		lower := strings.ToLower(offer)
+		q, spec := 0.0, -1
+		if lower == def {
+			q = 1
+		}
+		for i := range ranges {
+			if s, ok := match(&ranges[i], lower); ok && s > spec {
+				q, spec = ranges[i].q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// matchMediaType matches media ranges such as "*/*", "text/*" and
+// "text/html;level=1", whose parameters the offer must all have.
+func matchMediaType(ar *acceptRange, offer string) (int, bool) {
+	params := strings.Split(offer, ";")
+	typ := strings.TrimSpace(params[0])
+	switch {
+	case ar.value == "*/*":
+		return 0, true
+	case strings.HasSuffix(ar.value, "/*"):
+		if !strings.HasPrefix(typ, ar.value[:len(ar.value)-1]) {
+			return 0, false
+		}
+		return 1, true
+	case ar.value != typ:
+		return 0, false
+	}
+	for _, p := range ar.params {
+		found := false
+		for _, op := range params[1:] {
+			if strings.Replace(op, " ", "", -1) == p {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	return 2 + len(ar.params), true
+}
+
+// matchToken matches content codings and "*".
+func matchToken(ar *acceptRange, offer string) (int, bool) {
+	switch ar.value {
+	case "*":
+		return 0, true
+	case offer:
+		return 1, true
+	}
+	return 0, false
+}
+
+// matchLanguage matches language ranges as in RFC 4647's basic
+// filtering, more specific by the length of the range.
+func matchLanguage(ar *acceptRange, offer string) (int, bool) {
+	switch {
+	case ar.value == "*":
+		return 0, true
+	case ar.value == offer,
+		strings.HasPrefix(offer, ar.value) && offer[len(ar.value)] == '-':
+		return len(ar.value), true
+	}
+	return 0, false
+}
@@ -0,0 +1,83 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	. "net/http"
+	"strings"
+	"testing"
+)
+
+var negotiateTests = []struct {
+	key    string // header; Accept-Encoding uses AcceptsEncoding and so on
+	header string // "-" for none
+	offers string
+	want   string
+}{
+	{"Accept", "-", "text/html,application/json", "text/html"},
+	{"Accept", "", "text/html", ""},
+	{"Accept", "*/*", "text/html,application/json", "text/html"},
+	{"Accept", "application/json", "text/html,application/json", "application/json"},
+	{"Accept", "text/html;q=0.5, application/json", "text/html,application/json", "application/json"},
+	{"Accept", "text/*;q=0.3, text/html;q=0.7, */*;q=0.5", "image/png,text/plain,text/html", "text/html"},
+	{"Accept", "text/*;q=0.3, text/html;q=0.7, */*;q=0.5", "image/png,text/plain", "image/png"},
+	{"Accept", "text/*, text/plain;q=0", "text/plain,text/css", "text/css"},
+	{"Accept", "text/html;level=1, text/html;q=0.1", "text/html", "text/html"},
+	{"Accept", "text/html;level=1;q=0, text/html", "text/html;level=1", ""},
+	{"Accept", "TEXT/HTML;Q=0.9", "text/html", "text/html"},
+	{"Accept", "text/html;q=abc, application/json;q=2", "text/html,application/json", ""},
+	{"Accept", "application/json;q=0.001", "application/json", "application/json"},
+
+	{"Accept-Encoding", "-", "gzip,identity", "identity"},
+	{"Accept-Encoding", "-", "gzip", ""},
+	{"Accept-Encoding", "gzip", "br,gzip,identity", "gzip"},
+	{"Accept-Encoding", "gzip;q=0.5, br", "gzip,br", "br"},
+	{"Accept-Encoding", "gzip;q=0.5", "gzip,identity", "identity"},
+	{"Accept-Encoding", "gzip, identity;q=0", "identity", ""},
+	{"Accept-Encoding", "*", "br,identity", "br"},
+	{"Accept-Encoding", "*;q=0", "gzip,identity", ""},
+	{"Accept-Encoding", "*, gzip;q=0", "gzip,br", "br"},
+	{"Accept-Encoding", "GZIP", "gzip", "gzip"},
+
+	{"Accept-Language", "-", "en,fr", "en"},
+	{"Accept-Language", "fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5", "en-US,fr,de", "fr"},
+	{"Accept-Language", "fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5", "de,en-GB", "en-GB"},
+	{"Accept-Language", "fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5", "de", "de"},
+	{"Accept-Language", "en-us", "en,en-US", "en-US"},
+	{"Accept-Language", "en", "english", ""},
+	{"Accept-Language", "en, de;q=0", "de-AT", ""},
+}
+
+func TestRequestAccepts(t *testing.T) {
+	for _, tt := range negotiateTests {
+		r := &Request{Header: Header{}}
+		if tt.header != "-" {
+			r.Header.Set(tt.key, tt.header)
+		}
+		offers := strings.Split(tt.offers, ",")
+		var got string
+		switch tt.key {
+		case "Accept":
+			got = r.Accepts(offers...)
+		case "Accept-Encoding":
+			got = r.AcceptsEncoding(offers...)
+		case "Accept-Language":
+			got = r.AcceptsLanguage(offers...)
+		}
+		if got != tt.want {
+			t.Errorf("%s: %q, offers %q: got %q; want %q", tt.key, tt.header, tt.offers, got, tt.want)
+		}
+	}
+}
+
+func TestRequestAcceptsRepeatedHeader(t *testing.T) {
+	r := &Request{Header: Header{"Accept": {"text/html;q=0.5", "application/json"}}}
+	if got := r.Accepts("text/html", "application/json"); got != "application/json" {
+		t.Errorf("Accepts = %q; want application/json", got)
+	}
+	if got := r.Accepts(); got != "" {
+		t.Errorf("Accepts() = %q; want empty", got)
+	}
+}
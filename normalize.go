@@ -0,0 +1,291 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Normalization of hosts and URLs.
+
+package http
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+var errInvalidHost = errors.New("http: invalid host")
+
+// NormalizeHost returns host, a host name or IP address with an
+// optional port as in the Host header, in its canonical form for a
+// URL with scheme: without a trailing dot, in lower case, with
+// internationalized labels encoded as IDNA A-labels ("xn--..."),
+// IPv6 addresses in their shortest form and in brackets, and
+// without the port if it's the default port of scheme.
+//
+// The only mapping of internationalized labels before their encoding
+// is to lower case; labels needing the full mapping of UTS #46 should
+// be mapped by the caller.
+func NormalizeHost(host, scheme string) (string, error) {
+	h, port := host, ""
+	if hasPort(host) {
+		var err error
+		if h, port, err = net.SplitHostPort(host); err != nil {
+			return "", err
+		}
+		for i := 0; i < len(port); i++ {
+			if port[i] < '0' || port[i] > '9' {
+				return "", errInvalidHost
+			}
+		}
+		if port == portMap[strings.ToLower(scheme)] {
+			port = ""
+		}
+	} else if strings.HasPrefix(h, "[") && strings.HasSuffix(h, "]") {
+		h = h[1 : len(h)-1]
+	}
+
+	if ip := net.ParseIP(h); ip != nil {
+		h = ip.String()
+	} else if strings.Contains(h, ":") {
+		// An IPv6 address with a zone.
+		h = strings.ToLower(h)
+	} else {
+		var err error
+		if h, err = idnaToASCII(strings.TrimSuffix(h, ".")); err != nil {
+			return "", err
+		}
+	}
+	if strings.Contains(h, ":") {
+		h = "[" + h + "]"
+	}
+	if port != "" {
+		h += ":" + port
+	}
+	return h, nil
+}
+
+// NormalizeURL returns a copy of u normalized as RFC 3986, sections
+// 6.2.2 and 6.2.3, describe: the scheme and host as NormalizeHost
+// normalizes them, an empty path of a URL with a host made "/", and
+// in the path and query percent-encoded unreserved characters
+// decoded and other percent-encodings in upper case.
+func NormalizeURL(u *url.URL) (*url.URL, error) {
+	v := *u
+	v.Scheme = strings.ToLower(v.Scheme)
+	if v.Host != "" {
+		host, err := NormalizeHost(v.Host, v.Scheme)
+		if err != nil {
+			return nil, err
+		}
+		v.Host = host
+	}
+	if v.Opaque == "" {
+		p := normalizeEscapes(u.EscapedPath())
+		if p == "" && v.Host != "" {
+			p = "/"
+		}
+		path, err := url.PathUnescape(p)
+		if err != nil {
+			return nil, err
+		}
+		v.Path, v.RawPath = path, ""
+		if v.EscapedPath() != p {
+			v.RawPath = p
+		}
+	}
+	v.RawQuery = normalizeEscapes(v.RawQuery)
+	return &v, nil
+}
+
+// asciiHost returns host, with an optional port, in lower case and
+// with internationalized labels encoded, or host itself if it's not
+// a valid host.
+func asciiHost(host string) string {
+	h, port := host, ""
+	if hasPort(host) {
+		i := strings.LastIndex(host, ":")
+		h, port = host[:i], host[i:]
+	}
+	if strings.HasPrefix(h, "[") {
+		return strings.ToLower(host)
+	}
+	a, err := idnaToASCII(h)
+	if err != nil {
+		return host
+	}
+	return a + port
+}
+
+// idnaToASCII returns the host name h in lower case, with its
+// non-ASCII labels encoded as A-labels, as RFC 5891 describes.
+func idnaToASCII(h string) (string, error) {
+	if h == "" {
+		return "", nil
+	}
+	labels := strings.Split(h, ".")
+	for i, label := range labels {
+		label = strings.ToLower(label)
+		if !isASCII(label) {
+			enc, err := punycodeEncode(label)
+			if err != nil {
+				return "", err
+			}
+			label = "xn--" + enc
+		}
+		if label == "" || len(label) > 63 {
+			return "", errInvalidHost
+		}
+		for j := 0; j < len(label); j++ {
+			if c := label[j]; !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+				return "", errInvalidHost
+			}
+		}
+		labels[i] = label
+	}
+	return strings.Join(labels, "."), nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// Parameters of Punycode, RFC 3492, section 5.
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// punycodeEncode returns the Punycode encoding of s, as RFC 3492,
+// section 6.3, describes.
+func punycodeEncode(s string) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", errInvalidHost
+	}
+	runes := []rune(s)
+	out := make([]byte, 0, len(s))
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+	b := len(out)
+	if b > 0 {
+		out = append(out, '-')
+	}
+	const maxDelta = 1<<31 - 1
+	n, delta, bias := rune(punyInitialN), 0, punyInitialBias
+	for h := b; h < len(runes); {
+		m := rune(utf8.MaxRune + 1)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		if int64(m-n)*int64(h+1) > int64(maxDelta-delta) {
+			return "", errInvalidHost
+		}
+		delta += int(m-n) * (h + 1)
+		n = m
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := k - bias
+				if t < punyTMin {
+					t = punyTMin
+				} else if t > punyTMax {
+					t = punyTMax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punyDigit(q))
+			bias = punyAdapt(delta, h+1, h == b)
+			delta = 0
+			h++
+		}
+		delta++
+		n++
+	}
+	return string(out), nil
+}
+
+func punyAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > (punyBase-punyTMin)*punyTMax/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+// normalizeEscapes returns s with the percent-encodings of unreserved
+// characters decoded and the others in upper case.
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	const upperhex = "0123456789ABCDEF"
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !ishex(s[i+1]) || !ishex(s[i+2]) {
+			b = append(b, s[i])
+			continue
+		}
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' {
+			b = append(b, c)
+		} else {
+			b = append(b, '%', upperhex[c>>4], upperhex[c&15])
+		}
+		i += 2
+	}
+	return string(b)
+}
+
+func ishex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
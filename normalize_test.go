@@ -0,0 +1,162 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+var normalizeHostTests = []struct {
+	host, scheme string
+	want         string // or "" for an error
+}{
+	{"Example.COM", "http", "example.com"},
+	{"example.com.", "http", "example.com"},
+	{"example.com:80", "http", "example.com"},
+	{"example.com:80", "https", "example.com:80"},
+	{"example.com:443", "HTTPS", "example.com"},
+	{"example.com:8080", "http", "example.com:8080"},
+	{"Bücher.example", "http", "xn--bcher-kva.example"},
+	{"MÜNCHEN.de:443", "https", "xn--mnchen-3ya.de"},
+	{"他们为什么不说中文", "", "xn--ihqwcrb4cv8a8dqg056pqjye"},
+	{"xn--bcher-kva.example", "", "xn--bcher-kva.example"},
+	{"192.0.2.1:80", "http", "192.0.2.1"},
+	{"[2001:DB8:0:0::1]:443", "https", "[2001:db8::1]"},
+	{"[2001:db8::1]:8443", "https", "[2001:db8::1]:8443"},
+	{"[::1]", "http", "[::1]"},
+	{"exa mple.com", "http", ""},
+	{"example..com", "http", ""},
+	{"example.com:x", "http", ""},
+}
+
+func TestNormalizeHost(t *testing.T) {
+	for _, tt := range normalizeHostTests {
+		got, err := NormalizeHost(tt.host, tt.scheme)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("NormalizeHost(%q, %q) = %q; want an error", tt.host, tt.scheme, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeHost(%q, %q) = %q, %v; want %q", tt.host, tt.scheme, got, err, tt.want)
+		}
+	}
+}
+
+var normalizeURLTests = []struct {
+	in, want string
+}{
+	{"HTTP://Example.COM:80", "http://example.com/"},
+	{"https://example.com:443/a%2fb/%7Euser/%41%62c", "https://example.com/a%2Fb/~user/Abc"},
+	{"http://example.com/?q=%7e%2f&r=%41", "http://example.com/?q=~%2F&r=A"},
+	{"http://bücher.example/x%20y", "http://xn--bcher-kva.example/x%20y"},
+	{"/rel/%5Fpath", "/rel/_path"},
+}
+
+func TestNormalizeURL(t *testing.T) {
+	for _, tt := range normalizeURLTests {
+		u, err := url.Parse(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := u.String()
+		v, err := NormalizeURL(u)
+		if err != nil {
+			t.Errorf("NormalizeURL(%q): %v", tt.in, err)
+			continue
+		}
+		if got := v.String(); got != tt.want {
+			t.Errorf("NormalizeURL(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+		if u.String() != before {
+			t.Errorf("NormalizeURL(%q) modified its argument", tt.in)
+		}
+	}
+}
+
+func TestServeMuxNormalizedHosts(t *testing.T) {
+	mux := NewServeMux()
+	mux.HandleFunc("Bücher.example/", func(w ResponseWriter, r *Request) { io.WriteString(w, "books") })
+	mux.HandleFunc("example.com:80/", func(w ResponseWriter, r *Request) { io.WriteString(w, "example") })
+	mux.HandleFunc("Secure.example:443/", func(w ResponseWriter, r *Request) { io.WriteString(w, "secure") })
+	mux.HandleFunc("my_host/", func(w ResponseWriter, r *Request) { io.WriteString(w, "mine") })
+	mux.HandleFunc("/", func(w ResponseWriter, r *Request) { io.WriteString(w, "default") })
+	for _, tt := range []struct {
+		host string
+		tls  bool
+		want string
+	}{
+		{"bücher.example", false, "books"},
+		{"bücher.example:443", true, "books"},
+		{"example.com", false, "example"},
+		{"example.com:80", false, "example"},
+		{"example.com:8080", false, "default"},
+		{"example.com", true, "default"},
+		{"secure.example", true, "secure"},
+		{"secure.example:443", true, "secure"},
+		{"secure.example", false, "default"},
+		{"my_host", false, "mine"},
+		{"XN--BCHER-KVA.example:80", false, "books"},
+		{"xn--bcher-kva.example.", false, "books"},
+		{"xn--bcher-kva.example:8080", false, "default"},
+		{"other.example", false, "default"},
+	} {
+		req, _ := NewRequest("GET", "http://"+tt.host+"/", nil)
+		if tt.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		_, pattern := mux.Handler(req)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if got := rec.Body.String(); got != tt.want {
+			t.Errorf("host %q (TLS %v): served %q (pattern %q); want %q", tt.host, tt.tls, got, pattern, tt.want)
+		}
+	}
+}
+
+// recordingResolver resolves every host to 127.0.0.1, recording the
+// host names looked up.
+type recordingResolver struct {
+	hosts []string
+}
+
+func (r *recordingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.hosts = append(r.hosts, host)
+	return ipAddrs("127.0.0.1"), nil
+}
+
+func TestTransportIDNAHost(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, r.Host)
+	}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	r := &recordingResolver{}
+	tr := &Transport{Resolver: r}
+	defer tr.CloseIdleConnections()
+	res, err := (&Client{Transport: tr}).Get("http://Bücher.test:" + port + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if want := "xn--bcher-kva.test:" + port; string(b) != want {
+		t.Errorf("Host header %q; want %q", b, want)
+	}
+	if len(r.hosts) != 1 || r.hosts[0] != "xn--bcher-kva.test" {
+		t.Errorf("looked up %q", r.hosts)
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"io/ioutil"
 	. "net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -116,3 +118,67 @@ type http09Writer struct {
 
 func (w http09Writer) Header() Header  { return w.h }
 func (w http09Writer) WriteHeader(int) {} // no headers
+
+func TestRegisterALPN(t *testing.T) {
+	defer afterTest(t)
+	ca := newTestCA(t)
+	dir, err := ioutil.TempDir("", "alpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_, c, k := ca.issue(t, "example.com")
+	writeFile(t, certFile, c)
+	writeFile(t, keyFile, k)
+
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "http")
+	})}
+	srv.RegisterALPN("echo", func(conn *tls.Conn) {
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		io.WriteString(conn, "echo: "+line)
+	})
+	addr := listenAndServeTLSTest(t, srv, certFile, keyFile)
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		ServerName: "example.com",
+		RootCAs:    ca.pool(),
+		NextProtos: []string{"echo"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if p := conn.ConnectionState().NegotiatedProtocol; p != "echo" {
+		t.Fatalf("negotiated protocol %q; want echo", p)
+	}
+	io.WriteString(conn, "hello\n")
+	got, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "echo: hello\n" {
+		t.Errorf("echo protocol got %q", got)
+	}
+
+	// HTTP keeps working on the same listener.
+	tr := &Transport{TLSClientConfig: &tls.Config{ServerName: "example.com", RootCAs: ca.pool()}}
+	defer tr.CloseIdleConnections()
+	res, err := (&Client{Transport: tr}).Get("https://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "http" {
+		t.Errorf("HTTP body = %q; want http", body)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("RegisterALPN(\"http/1.1\") did not panic")
+		}
+	}()
+	srv.RegisterALPN("http/1.1", func(*tls.Conn) {})
+}
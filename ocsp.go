@@ -0,0 +1,478 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// OCSP stapling (RFC 6960, RFC 6066 Section 8).
+
+package http
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"sync"
+	"time"
+)
+
+var (
+	oidSHA1              = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidOCSPBasicResponse = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+)
+
+// ocspHashes maps the hash algorithms of CertIDs to their
+// implementations.
+var ocspHashes = []struct {
+	oid asn1.ObjectIdentifier
+	new func() hash.Hash
+}{
+	{oidSHA1, sha1.New},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}, sha256.New},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}, sha512.New384},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}, sha512.New},
+}
+
+// ocspSignatureAlgorithms maps the signature algorithms OCSP
+// responders commonly use to their crypto/x509 equivalent.
+var ocspSignatureAlgorithms = []struct {
+	oid asn1.ObjectIdentifier
+	alg x509.SignatureAlgorithm
+}{
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}, x509.SHA1WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, x509.SHA256WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, x509.SHA384WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, x509.SHA512WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}, x509.ECDSAWithSHA1},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, x509.ECDSAWithSHA256},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}, x509.ECDSAWithSHA384},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}, x509.ECDSAWithSHA512},
+	{asn1.ObjectIdentifier{1, 3, 101, 112}, x509.PureEd25519},
+}
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspRequest struct {
+	TBSRequest struct {
+		Version     int `asn1:"explicit,tag:0,default:0,optional"`
+		RequestList []struct {
+			Cert ocspCertID
+		}
+	}
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response struct {
+		ResponseType asn1.ObjectIdentifier
+		Response     []byte
+	} `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    ocspResponseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Raw            asn1.RawContent
+	Version        int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID asn1.RawValue
+	ProducedAt     time.Time `asn1:"generalized"`
+	Responses      []ocspSingleResponse
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked    asn1.RawValue    `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+// ocspCertIDFor returns the SHA-1 CertID of leaf, issued by issuer.
+func ocspCertIDFor(leaf, issuer *x509.Certificate) (ocspCertID, error) {
+	nameHash, keyHash, err := ocspIssuerHashes(sha1.New, issuer)
+	if err != nil {
+		return ocspCertID{}, err
+	}
+	return ocspCertID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+		NameHash:      nameHash,
+		IssuerKeyHash: keyHash,
+		SerialNumber:  leaf.SerialNumber,
+	}, nil
+}
+
+// ocspIssuerHashes returns the hashes of the name and public key of
+// issuer in a CertID.
+func ocspIssuerHashes(newHash func() hash.Hash, issuer *x509.Certificate) (nameHash, keyHash []byte, err error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, nil, err
+	}
+	h := newHash()
+	h.Write(issuer.RawSubject)
+	nameHash = h.Sum(nil)
+	h = newHash()
+	h.Write(spki.PublicKey.RightAlign())
+	return nameHash, h.Sum(nil), nil
+}
+
+// matches reports whether id is the CertID of leaf, issued by issuer,
+// with any hash algorithm of ocspHashes.
+func (id *ocspCertID) matches(leaf, issuer *x509.Certificate) bool {
+	if id.SerialNumber == nil || id.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		return false
+	}
+	for _, h := range ocspHashes {
+		if h.oid.Equal(id.HashAlgorithm.Algorithm) {
+			nameHash, keyHash, err := ocspIssuerHashes(h.new, issuer)
+			return err == nil && bytes.Equal(id.NameHash, nameHash) && bytes.Equal(id.IssuerKeyHash, keyHash)
+		}
+	}
+	return false
+}
+
+// parseOCSPResponse checks that der is a current, correctly signed
+// OCSP response reporting leaf as good, and returns its validity
+// period. A zero nextUpdate means the responder did not give one.
+func parseOCSPResponse(der []byte, leaf, issuer *x509.Certificate) (thisUpdate, nextUpdate time.Time, err error) {
+	var resp ocspResponse
+	if rest, err := asn1.Unmarshal(der, &resp); err != nil {
+		return thisUpdate, nextUpdate, err
+	} else if len(rest) > 0 {
+		return thisUpdate, nextUpdate, errors.New("trailing data in OCSP response")
+	}
+	if resp.Status != 0 {
+		return thisUpdate, nextUpdate, fmt.Errorf("OCSP responder status %d", resp.Status)
+	}
+	if !resp.Response.ResponseType.Equal(oidOCSPBasicResponse) {
+		return thisUpdate, nextUpdate, errors.New("unsupported OCSP response type")
+	}
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return thisUpdate, nextUpdate, err
+	}
+
+	signer := issuer
+	if len(basic.Certificates) > 0 {
+		responder, err := x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return thisUpdate, nextUpdate, err
+		}
+		if !bytes.Equal(responder.Raw, issuer.Raw) {
+			if err := responder.CheckSignatureFrom(issuer); err != nil {
+				return thisUpdate, nextUpdate, fmt.Errorf("OCSP responder certificate: %v", err)
+			}
+			ok := false
+			for _, u := range responder.ExtKeyUsage {
+				ok = ok || u == x509.ExtKeyUsageOCSPSigning
+			}
+			if !ok {
+				return thisUpdate, nextUpdate, errors.New("OCSP responder certificate is not authorized for OCSP signing")
+			}
+			signer = responder
+		}
+	}
+	alg := x509.UnknownSignatureAlgorithm
+	for _, a := range ocspSignatureAlgorithms {
+		if a.oid.Equal(basic.SignatureAlgorithm.Algorithm) {
+			alg = a.alg
+		}
+	}
+	if err := signer.CheckSignature(alg, basic.TBSResponseData.Raw, basic.Signature.RightAlign()); err != nil {
+		return thisUpdate, nextUpdate, fmt.Errorf("OCSP response signature: %v", err)
+	}
+
+	for _, r := range basic.TBSResponseData.Responses {
+		if !r.CertID.matches(leaf, issuer) {
+			continue
+		}
+		switch {
+		case bool(r.Good):
+		case r.Revoked.FullBytes != nil:
+			return thisUpdate, nextUpdate, errors.New("certificate has been revoked")
+		default:
+			return thisUpdate, nextUpdate, errors.New("certificate status unknown to OCSP responder")
+		}
+		now := time.Now()
+		if now.Before(r.ThisUpdate) {
+			return thisUpdate, nextUpdate, errors.New("OCSP response is not yet valid")
+		}
+		if !r.NextUpdate.IsZero() && !now.Before(r.NextUpdate) {
+			return thisUpdate, nextUpdate, errors.New("OCSP response has expired")
+		}
+		return r.ThisUpdate, r.NextUpdate, nil
+	}
+	return thisUpdate, nextUpdate, errors.New("OCSP response does not cover the certificate")
+}
+
+// DefaultOCSPRefresh is the longest an OCSPStapler waits between
+// refreshes when RefreshInterval is zero.
+const DefaultOCSPRefresh = 12 * time.Hour
+
+// ocspRetry is how long an OCSPStapler waits after a failed fetch.
+var ocspRetry = 5 * time.Minute
+
+// An OCSPStapler fetches OCSP responses for served certificates from
+// their issuers' responders and staples them to the TLS handshake,
+// sparing clients their own revocation lookup. Responses are
+// refreshed in the background; if a refresh fails, the previous
+// response is stapled until it expires.
+//
+// The issuing certificate must follow the leaf in the certificate
+// chain. Certificates that already carry an OCSPStaple are served
+// unchanged.
+type OCSPStapler struct {
+	// RefreshInterval is how often responses are fetched again. If
+	// zero, a response is refreshed halfway through its validity
+	// period, but at least every DefaultOCSPRefresh.
+	RefreshInterval time.Duration
+
+	// Client is used to query OCSP responders. If nil,
+	// DefaultClient is used.
+	Client *Client
+
+	// OnError, if non-nil, is called with the leaf certificate
+	// whenever fetching or validating its OCSP response fails.
+	OnError func(leaf *x509.Certificate, err error)
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*ocspEntry
+	stopped bool
+}
+
+// ocspEntry is the stapling state of one certificate.
+type ocspEntry struct {
+	cert       *tls.Certificate
+	stapled    *tls.Certificate // copy of cert with the staple, or nil
+	nextUpdate time.Time
+	used       bool // served since the last refresh
+	timer      *time.Timer
+}
+
+// Staple fetches an OCSP response for cert right away and keeps it
+// fresh, so the first handshakes using cert are stapled too. Without
+// Staple, the first handshake starts a fetch in the background and is
+// served without a staple.
+func (s *OCSPStapler) Staple(cert *tls.Certificate) error {
+	e := s.entry(cert)
+	return s.refresh(cert, e)
+}
+
+// Wrap returns a tls.Config.GetCertificate hook that staples OCSP
+// responses to the certificates returned by get.
+func (s *OCSPStapler) Wrap(get func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := get(hello)
+		if err != nil || cert == nil || cert.OCSPStaple != nil || len(cert.Certificate) < 2 {
+			return cert, err
+		}
+		orig := cert
+		key := sha256.Sum256(cert.Certificate[0])
+		s.mu.Lock()
+		e, ok := s.entries[key]
+		if ok {
+			e.used = true
+			if e.stapled != nil && (e.nextUpdate.IsZero() || time.Now().Before(e.nextUpdate)) {
+				cert = e.stapled
+			}
+		} else {
+			e = s.newEntryLocked(key, cert)
+		}
+		s.mu.Unlock()
+		if !ok {
+			go s.refresh(orig, e)
+		}
+		return cert, nil
+	}
+}
+
+// Stop cancels all background refreshes.
+func (s *OCSPStapler) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	for key, e := range s.entries {
+		if e.timer != nil {
+			e.timer.Stop()
+		}
+		delete(s.entries, key)
+	}
+}
+
+func (s *OCSPStapler) entry(cert *tls.Certificate) *ocspEntry {
+	key := sha256.Sum256(cert.Certificate[0])
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		return e
+	}
+	return s.newEntryLocked(key, cert)
+}
+
+func (s *OCSPStapler) newEntryLocked(key [sha256.Size]byte, cert *tls.Certificate) *ocspEntry {
+	if s.entries == nil {
+		s.entries = make(map[[sha256.Size]byte]*ocspEntry)
+	}
+	e := &ocspEntry{cert: cert, used: true}
+	s.entries[key] = e
+	return e
+}
+
+// refresh fetches a new response for e and schedules the next
+// refresh. Entries that were not served since the previous refresh
+// are dropped instead.
+func (s *OCSPStapler) refresh(cert *tls.Certificate, e *ocspEntry) error {
+	staple, thisUpdate, nextUpdate, err := s.fetch(cert)
+	if err != nil && s.OnError != nil {
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		s.OnError(leaf, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return err
+	}
+	delay := ocspRetry
+	if err == nil {
+		c := *cert
+		c.OCSPStaple = staple
+		e.stapled, e.nextUpdate = &c, nextUpdate
+		delay = s.refreshDelay(thisUpdate, nextUpdate)
+	}
+	if s.RefreshInterval > 0 && s.RefreshInterval < delay {
+		delay = s.RefreshInterval
+	}
+	e.used = false
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	key := sha256.Sum256(cert.Certificate[0])
+	e.timer = time.AfterFunc(delay, func() {
+		s.mu.Lock()
+		used := e.used
+		if !used && s.entries[key] == e {
+			delete(s.entries, key)
+		}
+		s.mu.Unlock()
+		if used {
+			s.refresh(cert, e)
+		}
+	})
+	return err
+}
+
+func (s *OCSPStapler) refreshDelay(thisUpdate, nextUpdate time.Time) time.Duration {
+	if s.RefreshInterval > 0 {
+		return s.RefreshInterval
+	}
+	if nextUpdate.IsZero() {
+		return DefaultOCSPRefresh
+	}
+	d := thisUpdate.Add(nextUpdate.Sub(thisUpdate) / 2).Sub(time.Now())
+	if d < time.Minute {
+		d = time.Minute
+	}
+	if d > DefaultOCSPRefresh {
+		d = DefaultOCSPRefresh
+	}
+	return d
+}
+
+func (s *OCSPStapler) fetch(cert *tls.Certificate) (staple []byte, thisUpdate, nextUpdate time.Time, err error) {
+	if len(cert.Certificate) < 2 {
+		return nil, thisUpdate, nextUpdate, errors.New("http: OCSP: certificate chain has no issuer")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, thisUpdate, nextUpdate, err
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, thisUpdate, nextUpdate, err
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return nil, thisUpdate, nextUpdate, errors.New("http: OCSP: certificate names no OCSP responder")
+	}
+	id, err := ocspCertIDFor(leaf, issuer)
+	if err != nil {
+		return nil, thisUpdate, nextUpdate, err
+	}
+	var req ocspRequest
+	req.TBSRequest.RequestList = append(req.TBSRequest.RequestList, struct{ Cert ocspCertID }{id})
+	body, err := asn1.Marshal(req)
+	if err != nil {
+		return nil, thisUpdate, nextUpdate, err
+	}
+
+	client := s.Client
+	if client == nil {
+		client = DefaultClient
+	}
+	res, err := client.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(body))
+	if err != nil {
+		return nil, thisUpdate, nextUpdate, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != StatusOK {
+		return nil, thisUpdate, nextUpdate, fmt.Errorf("http: OCSP responder returned %s", res.Status)
+	}
+	staple, err = ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, thisUpdate, nextUpdate, err
+	}
+	thisUpdate, nextUpdate, err = parseOCSPResponse(staple, leaf, issuer)
+	if err != nil {
+		return nil, thisUpdate, nextUpdate, fmt.Errorf("http: OCSP: %v", err)
+	}
+	return staple, thisUpdate, nextUpdate, nil
+}
+
+// setOCSPStapling makes config staple OCSP responses using
+// srv.OCSPStapler.
+func (srv *Server) setOCSPStapling(config *tls.Config) {
+	s := srv.OCSPStapler
+	if s == nil {
+		return
+	}
+	get := config.GetCertificate
+	if get == nil {
+		certs := config.Certificates
+		get = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			for i := range certs {
+				if hello.SupportsCertificate(&certs[i]) == nil {
+					return &certs[i], nil
+				}
+			}
+			if len(certs) == 0 {
+				return nil, errors.New("http: no certificates configured")
+			}
+			return &certs[0], nil
+		}
+	}
+	config.GetCertificate = s.Wrap(get)
+}
@@ -0,0 +1,293 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	. "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type testOCSPRequest struct {
+	TBSRequest struct {
+		RequestList []struct {
+			Cert testCertID
+		}
+	}
+}
+
+type testSingleResponse struct {
+	CertID     testCertID
+	Status     asn1.RawValue
+	ThisUpdate time.Time `asn1:"generalized"`
+	NextUpdate time.Time `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+type testResponseData struct {
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []testSingleResponse
+}
+
+type testBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+}
+
+type testResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type testOCSPResponse struct {
+	Status   asn1.Enumerated
+	Response testResponseBytes `asn1:"explicit,tag:0"`
+}
+
+// ocspResponder is an OCSP responder for a testCA.
+type ocspResponder struct {
+	t  *testing.T
+	ca *testCA
+	ts *httptest.Server
+
+	mu      sync.Mutex
+	revoked map[int64]bool
+	hits    int
+	last    []byte // last response sent
+
+	otherIssuer bool // answer for the certificate of another issuer
+}
+
+func newOCSPResponder(t *testing.T, ca *testCA) *ocspResponder {
+	r := &ocspResponder{t: t, ca: ca, revoked: make(map[int64]bool)}
+	r.ts = httptest.NewServer(r)
+	ca.ocsp = []string{r.ts.URL}
+	return r
+}
+
+func (o *ocspResponder) ServeHTTP(w ResponseWriter, r *Request) {
+	if ct := r.Header.Get("Content-Type"); ct != "application/ocsp-request" {
+		o.t.Errorf("OCSP request Content-Type = %q", ct)
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	var req testOCSPRequest
+	if _, err := asn1.Unmarshal(body, &req); err != nil || len(req.TBSRequest.RequestList) != 1 {
+		o.t.Errorf("bad OCSP request: %v", err)
+		return
+	}
+	id := req.TBSRequest.RequestList[0].Cert
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.hits++
+	status := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0}
+	now := time.Now().UTC().Truncate(time.Second)
+	if o.revoked[id.SerialNumber.Int64()] {
+		revokedAt, _ := asn1.MarshalWithParams(now.Add(-time.Minute), "generalized")
+		status = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: revokedAt}
+	}
+	if o.otherIssuer {
+		id.IssuerKeyHash = append([]byte(nil), id.IssuerKeyHash...)
+		id.IssuerKeyHash[0] ^= 0xff
+	}
+	keyHash, _ := asn1.Marshal(id.IssuerKeyHash)
+	tbs, err := asn1.Marshal(testResponseData{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: keyHash},
+		ProducedAt:  now,
+		Responses: []testSingleResponse{{
+			CertID:     id,
+			Status:     status,
+			ThisUpdate: now.Add(-time.Minute),
+			NextUpdate: now.Add(time.Hour),
+		}},
+	})
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	sum := sha256.Sum256(tbs)
+	sig, err := o.ca.key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	basic, _ := asn1.Marshal(testBasicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	})
+	resp, _ := asn1.Marshal(testOCSPResponse{
+		Response: testResponseBytes{
+			ResponseType: asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1},
+			Response:     basic,
+		},
+	})
+	o.last = resp
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(resp)
+}
+
+func (o *ocspResponder) state() (hits int, last []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.hits, o.last
+}
+
+// issueChain returns a certificate for name whose chain includes ca.
+func (ca *testCA) issueChain(t *testing.T, name string) tls.Certificate {
+	cert, _, _ := ca.issue(t, name)
+	cert.Certificate = append(cert.Certificate, ca.cert.Raw)
+	return cert
+}
+
+func TestOCSPStapler(t *testing.T) {
+	defer afterTest(t)
+	ca := newTestCA(t)
+	responder := newOCSPResponder(t, ca)
+	defer responder.ts.Close()
+
+	var errs []error
+	s := &OCSPStapler{OnError: func(leaf *x509.Certificate, err error) { errs = append(errs, err) }}
+	defer s.Stop()
+
+	cert := ca.issueChain(t, "example.com")
+	if err := s.Staple(&cert); err != nil {
+		t.Fatalf("Staple: %v", err)
+	}
+	get := s.Wrap(func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return &cert, nil })
+	got, err := get(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, last := responder.state(); len(got.OCSPStaple) == 0 || !bytes.Equal(got.OCSPStaple, last) {
+		t.Errorf("stapled %d bytes; want the responder's %d byte response", len(got.OCSPStaple), len(last))
+	}
+	if cert.OCSPStaple != nil {
+		t.Error("Staple modified the original certificate")
+	}
+
+	revoked := ca.issueChain(t, "revoked.example.com")
+	responder.mu.Lock()
+	responder.revoked[ca.serial] = true
+	responder.mu.Unlock()
+	if err := s.Staple(&revoked); err == nil || !strings.Contains(err.Error(), "revoked") {
+		t.Errorf("Staple of revoked certificate: err = %v; want revocation error", err)
+	}
+	if len(errs) != 1 {
+		t.Errorf("OnError called %d times; want 1", len(errs))
+	}
+	get = s.Wrap(func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return &revoked, nil })
+	if got, _ := get(&tls.ClientHelloInfo{}); got.OCSPStaple != nil {
+		t.Error("revoked certificate was stapled")
+	}
+}
+
+// Responses for the serial number of the certificate but another
+// issuer don't cover it.
+func TestOCSPStaplerOtherIssuer(t *testing.T) {
+	defer afterTest(t)
+	ca := newTestCA(t)
+	responder := newOCSPResponder(t, ca)
+	defer responder.ts.Close()
+	responder.otherIssuer = true
+
+	s := new(OCSPStapler)
+	defer s.Stop()
+	cert := ca.issueChain(t, "example.com")
+	if err := s.Staple(&cert); err == nil || !strings.Contains(err.Error(), "does not cover") {
+		t.Errorf("Staple: err = %v; want the response not to cover the certificate", err)
+	}
+}
+
+func TestOCSPStaplerRefresh(t *testing.T) {
+	defer afterTest(t)
+	ca := newTestCA(t)
+	responder := newOCSPResponder(t, ca)
+	defer responder.ts.Close()
+
+	s := &OCSPStapler{RefreshInterval: 10 * time.Millisecond}
+	defer s.Stop()
+	cert := ca.issueChain(t, "example.com")
+	get := s.Wrap(func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return &cert, nil })
+
+	// The first handshake is served unstapled and starts a fetch;
+	// refreshes continue while the certificate is in use.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, _ := get(&tls.ClientHelloInfo{})
+		hits, _ := responder.state()
+		if hits >= 3 && got.OCSPStaple != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for refreshes; responder saw %d requests", hits)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServerOCSPStapling(t *testing.T) {
+	defer afterTest(t)
+	ca := newTestCA(t)
+	responder := newOCSPResponder(t, ca)
+	defer responder.ts.Close()
+
+	dir, err := ioutil.TempDir("", "ocsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_, c, k := ca.issue(t, "example.com")
+	writeFile(t, certFile, c, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}))
+	writeFile(t, keyFile, k)
+
+	s := new(OCSPStapler)
+	defer s.Stop()
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {}), OCSPStapler: s}
+	addr := listenAndServeTLSTest(t, srv, certFile, keyFile)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "example.com", RootCAs: ca.pool()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		staple := conn.ConnectionState().OCSPResponse
+		conn.Close()
+		if staple != nil {
+			if _, last := responder.state(); !bytes.Equal(staple, last) {
+				t.Error("client received a different OCSP response than the responder sent")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for a stapled handshake")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
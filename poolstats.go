@@ -0,0 +1,138 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Transport connection pool statistics.
+
+package http
+
+import (
+	"context"
+	"net"
+	"strconv"
+)
+
+// PoolStats holds connection pool counts of a Transport.
+type PoolStats struct {
+	Idle         int    // connections idle in the pool
+	InUse        int    // open connections not idle
+	Dialing      int    // dials in progress
+	Dials        uint64 // connections dialed, in total
+	DialFailures uint64 // dials failed, in total
+	Evictions    uint64 // idle connections closed by the pool limits, in total
+}
+
+// A ConnEventType is the type of a ConnEvent.
+type ConnEventType int
+
+const (
+	// ConnCreated is for a connection dialed and set up.
+	ConnCreated ConnEventType = iota
+
+	// ConnClosed is for a connection closed after use or failure,
+	// by either end, or by CloseIdleConnections.
+	ConnClosed
+
+	// ConnEvicted is for an idle connection closed because of
+	// MaxIdleConns, MaxIdleConnsPerHost or IdleConnTimeout.
+	ConnEvicted
+)
+
+var connEventTypeNames = []string{"created", "closed", "evicted"}
+
+func (t ConnEventType) String() string {
+	if t >= 0 && int(t) < len(connEventTypeNames) {
+		return connEventTypeNames[t]
+	}
+	return "ConnEventType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// A ConnEvent describes a change to a Transport's connection pool,
+// for Transport.OnConnEvent.
+type ConnEvent struct {
+	Type ConnEventType
+
+	// Addr is the host:port dialed, that of the server or of the
+	// proxy reaching it.
+	Addr string
+
+	LocalAddr, RemoteAddr net.Addr
+}
+
+// poolCounts are the counts of PoolStats kept as they change.
+type poolCounts struct {
+	open, dialing                  int
+	dials, dialFailures, evictions uint64
+}
+
+// PoolStats returns t's connection pool counts, for exporting as
+// metrics.
+func (t *Transport) PoolStats() PoolStats {
+	t.idleMu.Lock()
+	idle := t.idleLRU.Len()
+	t.idleMu.Unlock()
+	t.poolMu.Lock()
+	defer t.poolMu.Unlock()
+	c := t.pool
+	inUse := c.open - idle
+	if inUse < 0 {
+		inUse = 0 // closed meanwhile
+	}
+	return PoolStats{
+		Idle:         idle,
+		InUse:        inUse,
+		Dialing:      c.dialing,
+		Dials:        c.dials,
+		DialFailures: c.dialFailures,
+		Evictions:    c.evictions,
+	}
+}
+
+// countedDial calls dialConn, counting the dial for PoolStats and
+// reporting the connection created to OnConnEvent.
+func (t *Transport) countedDial(ctx context.Context, cm *connectMethod, sem chan struct{}) (*persistConn, error) {
+	t.poolMu.Lock()
+	t.pool.dialing++
+	t.poolMu.Unlock()
+	pc, err := t.dialConn(ctx, cm, sem)
+	t.poolMu.Lock()
+	t.pool.dialing--
+	if err != nil {
+		t.pool.dialFailures++
+	} else {
+		t.pool.dials++
+		t.pool.open++
+	}
+	t.poolMu.Unlock()
+	if err == nil {
+		t.connEvent(ConnCreated, pc)
+	}
+	return pc, err
+}
+
+// connClosed uncounts pc, just closed, having been evicted if so.
+func (t *Transport) connClosed(pc *persistConn, evicted bool) {
+	t.poolMu.Lock()
+	t.pool.open--
+	if evicted {
+		t.pool.evictions++
+	}
+	t.poolMu.Unlock()
+	typ := ConnClosed
+	if evicted {
+		typ = ConnEvicted
+	}
+	t.connEvent(typ, pc)
+}
+
+func (t *Transport) connEvent(typ ConnEventType, pc *persistConn) {
+	if t.OnConnEvent == nil {
+		return
+	}
+	t.OnConnEvent(ConnEvent{
+		Type:       typ,
+		Addr:       pc.addr,
+		LocalAddr:  pc.conn.LocalAddr(),
+		RemoteAddr: pc.conn.RemoteAddr(),
+	})
+}
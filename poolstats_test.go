@@ -0,0 +1,132 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransportPoolStats(t *testing.T) {
+	defer afterTest(t)
+	arrived := make(chan bool, 2)
+	release := make(chan bool)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/wait" {
+			arrived <- true
+			<-release
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	var mu sync.Mutex
+	var events []string
+	tr := &Transport{
+		MaxIdleConnsPerHost: 1,
+		IdleConnTimeout:     time.Minute,
+		OnConnEvent: func(e ConnEvent) {
+			if e.Addr != addr || e.RemoteAddr.String() != addr || e.LocalAddr == nil {
+				t.Errorf("event %v: Addr %q, RemoteAddr %v, LocalAddr %v; want %s", e.Type, e.Addr, e.RemoteAddr, e.LocalAddr, addr)
+			}
+			mu.Lock()
+			events = append(events, e.Type.String())
+			mu.Unlock()
+		},
+	}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	get := func(path string) {
+		res, err := c.Get(ts.URL + path)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get("/wait")
+		}()
+	}
+	<-arrived
+	<-arrived
+	if s := tr.PoolStats(); s.InUse != 2 || s.Idle != 0 || s.Dials != 2 || s.Dialing != 0 {
+		t.Errorf("with two requests in flight: %+v", s)
+	}
+	close(release)
+	wg.Wait()
+	if s := tr.PoolStats(); s.InUse != 0 || s.Idle != 1 || s.Evictions != 1 {
+		t.Errorf("after the requests, with room for one idle: %+v", s)
+	}
+	tr.CloseIdleConnections()
+	if s := tr.PoolStats(); s.Idle != 0 || s.InUse != 0 || s.Dials != 2 {
+		t.Errorf("after CloseIdleConnections: %+v", s)
+	}
+
+	mu.Lock()
+	got := strings.Join(events, " ")
+	mu.Unlock()
+	if want := "created created evicted closed"; got != want {
+		t.Errorf("events = %q; want %q", got, want)
+	}
+}
+
+func TestTransportPoolStatsDialFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	tr := &Transport{}
+	if _, err := (&Client{Transport: tr}).Get("http://" + addr + "/"); err == nil {
+		t.Fatal("Get of a closed port succeeded")
+	}
+	if s := tr.PoolStats(); s.DialFailures != 1 || s.Dials != 0 || s.Dialing != 0 {
+		t.Errorf("after a failed dial: %+v", s)
+	}
+}
+
+func TestTransportIdleTimeoutEvicts(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
+	evicted := make(chan bool, 1)
+	tr := &Transport{
+		IdleConnTimeout: 10 * time.Millisecond,
+		OnConnEvent: func(e ConnEvent) {
+			if e.Type == ConnEvicted {
+				evicted <- true
+			}
+		},
+	}
+	res, err := (&Client{Transport: tr}).Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	select {
+	case <-evicted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle connection not evicted")
+	}
+	if s := tr.PoolStats(); s.Idle != 0 || s.Evictions != 1 {
+		t.Errorf("after IdleConnTimeout: %+v", s)
+	}
+}
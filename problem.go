@@ -0,0 +1,81 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// RFC 7807 problem details.
+
+package http
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// A Problem is an RFC 7807 problem details object, describing an
+// error in an application/problem+json response body.
+type Problem struct {
+	Type     string // URI identifying the problem type; "about:blank" if empty
+	Title    string // short summary of the problem type
+	Status   int    // the response's status code
+	Detail   string // explanation of this occurrence of the problem
+	Instance string // URI identifying this occurrence
+
+	// Extensions holds additional members of the object. Members
+	// named like the fields above are ignored.
+	Extensions map[string]interface{}
+}
+
+// MarshalJSON encodes p as a JSON object, omitting empty members.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	for k, v := range map[string]string{"type": p.Type, "title": p.Title, "detail": p.Detail, "instance": p.Instance} {
+		if delete(m, k); v != "" {
+			m[k] = v
+		}
+	}
+	if delete(m, "status"); p.Status != 0 {
+		m["status"] = p.Status
+	}
+	return json.Marshal(m)
+}
+
+// WriteProblem replies to the request with the status code and p as
+// an application/problem+json body. p's Status is set to code, and
+// an empty Title to the status text. If p is nil, the problem has only
+// a title and status.
+func WriteProblem(w ResponseWriter, code int, p *Problem) {
+	var q Problem
+	if p != nil {
+		q = *p
+	}
+	q.Status = code
+	if q.Title == "" {
+		q.Title = StatusText(code)
+	}
+	b, err := json.Marshal(&q)
+	if err != nil {
+		// An Extensions value can't be encoded; send the rest.
+		q.Extensions = nil
+		b, _ = json.Marshal(&q)
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(code)
+	w.Write(append(b, '\n'))
+}
+
+// acceptsJSON reports whether the Accept header of r names a JSON
+// media type, such as application/json or application/problem+json,
+// without excluding it with "q=0". Wildcards don't count, so browsers
+// still get text.
+func acceptsJSON(r *Request) bool {
+	ranges, _ := r.acceptRanges("Accept")
+	for _, ar := range ranges {
+		if ar.q > 0 && (ar.value == "application/json" || strings.HasPrefix(ar.value, "application/") && strings.HasSuffix(ar.value, "+json")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"io/ioutil"
+	. "net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var writeProblemTests = []struct {
+	code int
+	p    *Problem
+	want string
+}{
+	{404, nil, `{"status":404,"title":"Not Found"}`},
+	{409, &Problem{
+		Type:       "https://example.com/probs/out-of-credit",
+		Title:      "You do not have enough credit.",
+		Status:     200,
+		Detail:     "Your current balance is 30, but that costs 50.",
+		Instance:   "/account/12345/msgs/abc",
+		Extensions: map[string]interface{}{"balance": 30, "status": "ignored"},
+	}, `{"balance":30,"detail":"Your current balance is 30, but that costs 50.","instance":"/account/12345/msgs/abc","status":409,"title":"You do not have enough credit.","type":"https://example.com/probs/out-of-credit"}`},
+	{500, &Problem{Detail: "d", Extensions: map[string]interface{}{"bad": make(chan int)}},
+		`{"detail":"d","status":500,"title":"Internal Server Error"}`},
+}
+
+func TestWriteProblem(t *testing.T) {
+	for _, tt := range writeProblemTests {
+		rec := httptest.NewRecorder()
+		WriteProblem(rec, tt.code, tt.p)
+		if rec.Code != tt.code {
+			t.Errorf("code = %d; want %d", rec.Code, tt.code)
+		}
+		if ct := rec.HeaderMap.Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		if got := rec.Body.String(); got != tt.want+"\n" {
+			t.Errorf("body = %s; want %s", got, tt.want)
+		}
+	}
+}
+
+func TestNotFoundProblem(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(NotFoundHandler())
+	defer ts.Close()
+	for _, tt := range []struct {
+		accept, ctype, body string
+	}{
+		{"", "text/plain; charset=utf-8", "404 page not found\n"},
+		{"text/html,*/*;q=0.8", "text/plain; charset=utf-8", "404 page not found\n"},
+		{"application/json;q=0, text/plain", "text/plain; charset=utf-8", "404 page not found\n"},
+		{"text/html, application/problem+json", "application/problem+json", `{"detail":"404 page not found","status":404,"title":"Not Found"}` + "\n"},
+		{"application/json; q=0.5", "application/problem+json", `{"detail":"404 page not found","status":404,"title":"Not Found"}` + "\n"},
+	} {
+		req, _ := NewRequest("GET", ts.URL, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		res, err := DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if ct := res.Header.Get("Content-Type"); res.StatusCode != 404 || ct != tt.ctype || string(body) != tt.body {
+			t.Errorf("Accept %q: got %d %q %q; want 404 %q %q", tt.accept, res.StatusCode, ct, body, tt.ctype, tt.body)
+		}
+	}
+}
@@ -58,6 +58,8 @@ var cacheKeysTests = []struct {
 	{"", "https", "foo.com", "|https|foo.com"},
 	{"http://foo.com", "http", "foo.com", "http://foo.com|http|"},
 	{"http://foo.com", "https", "foo.com", "http://foo.com|https|foo.com"},
+	{"socks5://foo.com", "http", "foo.com", "socks5://foo.com|http|foo.com"},
+	{"socks5://foo.com", "https", "foo.com", "socks5://foo.com|https|foo.com"},
 }
 
 func TestCacheKeys(t *testing.T) {
@@ -70,7 +72,7 @@ func TestCacheKeys(t *testing.T) {
 			}
 			proxy = u
 		}
-		cm := connectMethod{proxy, tt.scheme, tt.addr}
+		cm := connectMethod{proxyURL: proxy, targetScheme: tt.scheme, targetAddr: tt.addr}
 		if cm.String() != tt.key {
 			t.Fatalf("{%q, %q, %q} cache key %q; want %q", tt.proxy, tt.scheme, tt.addr, cm.String(), tt.key)
 		}
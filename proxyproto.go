@@ -0,0 +1,311 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// PROXY protocol header parsing.
+
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyHeaderTimeout bounds how long ReadProxyHeader waits for the
+// header.
+var proxyHeaderTimeout = 10 * time.Second
+
+// proxyV2Sig starts a version 2 (binary) PROXY protocol header.
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var (
+	errProxyHeader  = errors.New("http: malformed PROXY protocol header")
+	errProxyTLVSize = errors.New("http: PROXY protocol header TLVs longer than 64 KiB")
+)
+
+// A ProxyLine is what the PROXY protocol header at the start of a
+// connection reported. See Request.ProxyLine.
+type ProxyLine struct {
+	Version int // 1 or 2
+
+	// Source and Destination are the addresses of the client and
+	// of the proxy it connected to, or nil if the header didn't
+	// report them, as for the UNKNOWN family and LOCAL connections.
+	Source, Destination net.Addr
+
+	// TLVs are the type-length-value fields of a version 2 header,
+	// which carry more about the connection, such as an ID
+	// assigned by the proxy.
+	TLVs []ProxyTLV
+}
+
+// A ProxyTLV is a type-length-value field of a version 2 PROXY
+// protocol header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Types of the TLVs of version 2 PROXY protocol headers defined by
+// the protocol. Types 0xe0 to 0xef are for applications' own use.
+const (
+	ProxyTLVALPN      = 0x01 // application protocol negotiated by TLS ALPN
+	ProxyTLVAuthority = 0x02 // host name the client asked for, as by TLS SNI
+	ProxyTLVUniqueID  = 0x05 // opaque ID of the connection, up to 128 bytes
+	ProxyTLVSSL       = 0x20 // TLS details; bit 0 of the first byte is set if the client used TLS
+	ProxyTLVNetNS     = 0x30 // name of the network namespace
+)
+
+// TLV returns the value of l's first TLV of type typ, or nil if there
+// is none.
+func (l *ProxyLine) TLV(typ byte) []byte {
+	for _, tlv := range l.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value
+		}
+	}
+	return nil
+}
+
+// proxyConn is a connection whose addresses were reported by a PROXY
+// protocol header.
+type proxyConn struct {
+	net.Conn
+	br            *bufio.Reader // read past the header; nil once drained
+	remote, local net.Addr
+	line          ProxyLine
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr     { return c.remote }
+func (c *proxyConn) LocalAddr() net.Addr      { return c.local }
+func (c *proxyConn) UnderlyingConn() net.Conn { return c.Conn }
+
+// Read reads what was buffered past the header, then from the
+// connection itself, the buffer going back to the pool once drained.
+func (c *proxyConn) Read(p []byte) (int, error) {
+	if c.br != nil {
+		if c.br.Buffered() > 0 {
+			return c.br.Read(p)
+		}
+		putBufioReader(c.br)
+		c.br = nil
+	}
+	return c.Conn.Read(p)
+}
+
+// ReadProxyHeader reads the PROXY protocol header, version 1 or 2,
+// that a proxy sends at the start of each connection, and returns a
+// connection whose RemoteAddr and LocalAddr are the client's and the
+// proxy's addresses from the header. It is meant for the WrapConn
+// field of ListenerOptions; only connections from trusted proxies
+// should be given to it, as the header is not authenticated.
+//
+// Headers for the UNKNOWN family (version 1) and for LOCAL or non-IP
+// connections (version 2), as sidecar proxies send over Unix sockets,
+// are accepted and leave the connection's own addresses in place.
+func ReadProxyHeader(c net.Conn) (net.Conn, error) {
+	c.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer c.SetReadDeadline(time.Time{})
+	pc := &proxyConn{Conn: c, br: newBufioReader(c), remote: c.RemoteAddr(), local: c.LocalAddr()}
+	line, err := ReadProxyLine(pc.br)
+	if err != nil {
+		putBufioReader(pc.br)
+		return nil, err
+	}
+	pc.line = *line
+	if line.Source != nil {
+		pc.remote, pc.local = line.Source, line.Destination
+	}
+	return pc, nil
+}
+
+// ReadProxyLine reads a PROXY protocol header, version 1 or 2, from
+// b, as ReadProxyHeader does for a connection.
+func ReadProxyLine(b *bufio.Reader) (*ProxyLine, error) {
+	sig, err := b.Peek(len(proxyV2Sig))
+	if err == nil && bytes.Equal(sig, proxyV2Sig) {
+		return readProxyV2(b)
+	}
+	return readProxyV1(b)
+}
+
+// readProxyV1 parses a version 1 header such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyV1(br *bufio.Reader) (*ProxyLine, error) {
+	const maxLen = 107
+	var line []byte
+	for len(line) < maxLen {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) || !bytes.HasPrefix(line, []byte("PROXY ")) {
+		return nil, errProxyHeader
+	}
+	pl := &ProxyLine{Version: 1}
+	f := strings.Split(string(line[:len(line)-2]), " ")
+	if len(f) >= 2 && f[1] == "UNKNOWN" {
+		return pl, nil
+	}
+	if len(f) != 6 || (f[1] != "TCP4" && f[1] != "TCP6") {
+		return nil, errProxyHeader
+	}
+	src, dst := net.ParseIP(f[2]), net.ParseIP(f[3])
+	sport, err1 := strconv.ParseUint(f[4], 10, 16)
+	dport, err2 := strconv.ParseUint(f[5], 10, 16)
+	if src == nil || dst == nil || err1 != nil || err2 != nil {
+		return nil, errProxyHeader
+	}
+	// Both addresses must be of the family declared.
+	v4 := f[1] == "TCP4"
+	if (src.To4() != nil) != v4 || (dst.To4() != nil) != v4 {
+		return nil, errProxyHeader
+	}
+	pl.Source = &net.TCPAddr{IP: src, Port: int(sport)}
+	pl.Destination = &net.TCPAddr{IP: dst, Port: int(dport)}
+	return pl, nil
+}
+
+// readProxyV2 parses a version 2 (binary) header.
+func readProxyV2(br *bufio.Reader) (*ProxyLine, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, errProxyHeader
+	}
+	pl := &ProxyLine{Version: 2}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, err
+	}
+	cmd := hdr[12] & 0xf
+	if cmd > 1 { // neither LOCAL, for health checks and the like, nor PROXY
+		return nil, errProxyHeader
+	}
+	var ipLen, addrLen int
+	switch hdr[13] >> 4 {
+	case 0: // AF_UNSPEC
+	case 1: // AF_INET
+		ipLen, addrLen = net.IPv4len, 2*net.IPv4len+4
+	case 2: // AF_INET6
+		ipLen, addrLen = net.IPv6len, 2*net.IPv6len+4
+	case 3: // AF_UNIX
+		addrLen = 216
+	default:
+		return pl, nil
+	}
+	if len(body) < addrLen {
+		return nil, errProxyHeader
+	}
+	for tlvs := body[addrLen:]; len(tlvs) > 0; {
+		if len(tlvs) < 3 {
+			return nil, errProxyHeader
+		}
+		n := 3 + int(binary.BigEndian.Uint16(tlvs[1:]))
+		if len(tlvs) < n {
+			return nil, errProxyHeader
+		}
+		pl.TLVs = append(pl.TLVs, ProxyTLV{Type: tlvs[0], Value: tlvs[3:n:n]})
+		tlvs = tlvs[n:]
+	}
+	if cmd == 0 || ipLen == 0 {
+		return pl, nil
+	}
+	src := net.IP(body[:ipLen])
+	dst := net.IP(body[ipLen : 2*ipLen])
+	ports := body[2*ipLen:]
+	pl.Source = &net.TCPAddr{IP: src, Port: int(binary.BigEndian.Uint16(ports))}
+	pl.Destination = &net.TCPAddr{IP: dst, Port: int(binary.BigEndian.Uint16(ports[2:]))}
+	return pl, nil
+}
+
+// Bytes returns the PROXY protocol header of version l.Version
+// reporting l's addresses: a text line for version 1 and the binary
+// form for version 2. Without TCP addresses of the same family, the
+// header is of the UNKNOWN family (version 1) or a LOCAL connection
+// (version 2). A version 2 header ends with l's TLVs; Bytes returns an
+// error if they don't fit in one, whose length is at most 64 KiB.
+func (l *ProxyLine) Bytes() ([]byte, error) {
+	src, _ := l.Source.(*net.TCPAddr)
+	dst, _ := l.Destination.(*net.TCPAddr)
+	var srcIP, dstIP net.IP
+	if src != nil && dst != nil && (src.IP.To4() == nil) == (dst.IP.To4() == nil) {
+		srcIP, dstIP = src.IP.To4(), dst.IP.To4()
+		if srcIP == nil {
+			srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+		}
+	}
+	if l.Version == 2 {
+		var tlvs []byte
+		for _, tlv := range l.TLVs {
+			if len(tlv.Value) > 0xffff {
+				return nil, errProxyTLVSize
+			}
+			tlvs = append(tlvs, tlv.Type, byte(len(tlv.Value)>>8), byte(len(tlv.Value)))
+			tlvs = append(tlvs, tlv.Value...)
+		}
+		b := append([]byte(nil), proxyV2Sig...)
+		if srcIP == nil {
+			n := len(tlvs)
+			if n > 0xffff {
+				return nil, errProxyTLVSize
+			}
+			b = append(b, 0x20, 0x00, byte(n>>8), byte(n))
+			return append(b, tlvs...), nil
+		}
+		fam := byte(0x11)
+		if len(srcIP) == net.IPv6len {
+			fam = 0x21
+		}
+		n := 2*len(srcIP) + 4 + len(tlvs)
+		if n > 0xffff {
+			return nil, errProxyTLVSize
+		}
+		b = append(b, 0x21, fam, byte(n>>8), byte(n))
+		b = append(append(b, srcIP...), dstIP...)
+		b = append(b, byte(src.Port>>8), byte(src.Port), byte(dst.Port>>8), byte(dst.Port))
+		return append(b, tlvs...), nil
+	}
+	if srcIP == nil {
+		return []byte("PROXY UNKNOWN\r\n"), nil
+	}
+	fam := "TCP4"
+	if len(srcIP) == net.IPv6len {
+		fam = "TCP6"
+	}
+	return []byte("PROXY " + fam + " " + srcIP.String() + " " + dstIP.String() + " " +
+		strconv.Itoa(src.Port) + " " + strconv.Itoa(dst.Port) + "\r\n"), nil
+}
+
+// proxyLineOf returns the PROXY protocol header read by
+// ReadProxyHeader for c, possibly wrapped, or nil.
+func proxyLineOf(c net.Conn) *ProxyLine {
+	for {
+		switch cc := c.(type) {
+		case *proxyConn:
+			line := cc.line
+			return &line
+		case *tls.Conn:
+			c = cc.NetConn()
+		case WrappedConn:
+			c = cc.UnderlyingConn()
+		default:
+			return nil
+		}
+	}
+}
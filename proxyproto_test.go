@@ -0,0 +1,240 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func proxyV2(cmd, fam byte, addrs ...byte) string {
+	h := []byte("\r\n\r\n\x00\r\nQUIT\n")
+	h = append(h, 0x20|cmd, fam, byte(len(addrs)>>8), byte(len(addrs)))
+	return string(append(h, addrs...))
+}
+
+var proxyHeaderTests = []struct {
+	header     string
+	remote     string // "" for the connection's own address
+	local      string
+	shouldFail bool
+}{
+	{header: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", remote: "192.0.2.1:56324", local: "198.51.100.1:443"},
+	{header: "PROXY TCP6 2001:db8::1 2001:db8::2 1000 80\r\n", remote: "[2001:db8::1]:1000", local: "[2001:db8::2]:80"},
+	{header: "PROXY UNKNOWN\r\n"},
+	{header: "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"},
+	{
+		header: proxyV2(1, 0x11, 192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb),
+		remote: "192.0.2.1:56324", local: "198.51.100.1:443",
+	},
+	{header: proxyV2(0, 0x00)},                       // LOCAL
+	{header: proxyV2(1, 0x00)},                       // AF_UNSPEC
+	{header: proxyV2(1, 0x31, make([]byte, 216)...)}, // AF_UNIX
+
+	{header: "GET / HTTP/1.1\r\n", shouldFail: true},
+	{header: "PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n", shouldFail: true},
+	{header: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 99999\r\n", shouldFail: true},
+	{header: "PROXY TCP4 192.0.2.1 198.51.100.1 1 2\n", shouldFail: true},
+	{header: "PROXY TCP4 1.2.3.4 ::1 1 2\r\n", shouldFail: true},
+	{header: "PROXY TCP6 1.2.3.4 5.6.7.8 1 2\r\n", shouldFail: true},
+	{header: proxyV2(2, 0x11), shouldFail: true},
+	{header: proxyV2(1, 0x11, 192, 0, 2, 1), shouldFail: true},
+	{header: proxyV2(1, 0x11, 192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb, 0x05, 0x00), shouldFail: true},
+	{header: proxyV2(0, 0x00, 0x05, 0x00, 0x02, 'x'), shouldFail: true},
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	for _, tt := range proxyHeaderTests {
+		client, server := net.Pipe()
+		go func() {
+			client.Write([]byte(tt.header + "body"))
+			client.Close()
+		}()
+		c, err := ReadProxyHeader(server)
+		if tt.shouldFail {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.header)
+			}
+			server.Close()
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.header, err)
+			server.Close()
+			continue
+		}
+		wantRemote, wantLocal := tt.remote, tt.local
+		if wantRemote == "" {
+			wantRemote, wantLocal = server.RemoteAddr().String(), server.LocalAddr().String()
+		}
+		if got := c.RemoteAddr().String(); got != wantRemote {
+			t.Errorf("%q: RemoteAddr = %q; want %q", tt.header, got, wantRemote)
+		}
+		if got := c.LocalAddr().String(); got != wantLocal {
+			t.Errorf("%q: LocalAddr = %q; want %q", tt.header, got, wantLocal)
+		}
+		if b, _ := ioutil.ReadAll(c); string(b) != "body" {
+			t.Errorf("%q: read %q after the header; want %q", tt.header, b, "body")
+		}
+		c.Close()
+	}
+}
+
+// The bytes buffered past the header, then those of the connection,
+// are read in order.
+func TestReadProxyHeaderLongBody(t *testing.T) {
+	body := strings.Repeat("0123456789", 2000)
+	client, server := net.Pipe()
+	go func() {
+		client.Write([]byte("PROXY UNKNOWN\r\n" + body[:5000]))
+		client.Write([]byte(body[5000:]))
+		client.Close()
+	}()
+	c, err := ReadProxyHeader(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if b, _ := ioutil.ReadAll(c); string(b) != body {
+		t.Errorf("read %d bytes after the header; want the %d written", len(b), len(body))
+	}
+}
+
+func TestProxyLineBytes(t *testing.T) {
+	for _, header := range []string{
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n",
+		"PROXY TCP6 2001:db8::1 2001:db8::2 1000 80\r\n",
+		"PROXY UNKNOWN\r\n",
+		proxyV2(1, 0x11, 192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb),
+		proxyV2(0, 0x00),
+		proxyV2(1, 0x11, 192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb, 0x05, 0x00, 0x02, 'i', 'd'),
+		proxyV2(0, 0x00, 0x30, 0x00, 0x02, 'n', 's', 0xe0, 0x00, 0x00),
+	} {
+		line, err := ReadProxyLine(bufio.NewReader(strings.NewReader(header)))
+		if err != nil {
+			t.Errorf("%q: %v", header, err)
+			continue
+		}
+		if got, err := line.Bytes(); string(got) != header || err != nil {
+			t.Errorf("%q: Bytes = %q, %v", header, got, err)
+		}
+	}
+}
+
+func TestProxyLineTLV(t *testing.T) {
+	header := proxyV2(1, 0x11, 192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb,
+		0x05, 0x00, 0x02, 'i', 'd', 0x30, 0x00, 0x00)
+	line, err := ReadProxyLine(bufio.NewReader(strings.NewReader(header)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(line.TLV(ProxyTLVUniqueID)); got != "id" {
+		t.Errorf("unique ID = %q; want id", got)
+	}
+	if got := line.TLV(ProxyTLVNetNS); got == nil || len(got) != 0 {
+		t.Errorf("namespace = %q; want empty", got)
+	}
+	if got := line.TLV(ProxyTLVALPN); got != nil {
+		t.Errorf("ALPN = %q; want none", got)
+	}
+}
+
+func TestProxyLineTLVTooLong(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+	dst := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443}
+	for _, tlvs := range [][]ProxyTLV{
+		{{Type: ProxyTLVUniqueID, Value: make([]byte, 1<<16)}},
+		{{Type: ProxyTLVUniqueID, Value: make([]byte, 1<<15)}, {Type: ProxyTLVNetNS, Value: make([]byte, 1<<15)}},
+	} {
+		for _, line := range []*ProxyLine{
+			{Version: 2, Source: src, Destination: dst, TLVs: tlvs},
+			{Version: 2, TLVs: tlvs},
+		} {
+			if b, err := line.Bytes(); err == nil {
+				t.Errorf("Bytes with %d TLVs = %d bytes; want an error", len(tlvs), len(b))
+			}
+		}
+	}
+}
+
+func TestTransportProxyHeader(t *testing.T) {
+	defer afterTest(t)
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		fmt.Fprintf(w, "%s %s %s", r.ProxyLine.TLV(ProxyTLVUniqueID), r.ProxyLine.TLV(ProxyTLVNetNS), r.RemoteAddr)
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go srv.ServeWithOptions(ln, ListenerOptions{WrapConn: ReadProxyHeader})
+
+	tr := &Transport{ProxyHeader: func(req *Request) *ProxyLine {
+		return &ProxyLine{
+			Version: 2,
+			Source:  &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234},
+			TLVs: []ProxyTLV{
+				{Type: ProxyTLVUniqueID, Value: []byte(req.Header.Get("X-Tenant"))},
+				{Type: ProxyTLVNetNS, Value: []byte("ns")},
+			},
+		}
+	}}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	u := (&url.URL{Scheme: "http", Host: ln.Addr().String()}).String()
+	for _, tenant := range []string{"a", "b", "a", "b"} {
+		req, _ := NewRequest("GET", u, nil)
+		req.Header.Set("X-Tenant", tenant)
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if want := tenant + " ns 192.0.2.1:1234"; string(b) != want {
+			t.Errorf("tenant %s: backend saw %q; want %q", tenant, b, want)
+		}
+	}
+	if keys := tr.IdleConnKeysForTesting(); len(keys) != 2 {
+		t.Errorf("idle connections %q; want one per tenant", keys)
+	}
+}
+
+func benchmarkReadProxyLine(b *testing.B, header string) {
+	b.SetBytes(int64(len(header)))
+	b.ReportAllocs()
+	r := strings.NewReader(header)
+	br := bufio.NewReader(r)
+	for i := 0; i < b.N; i++ {
+		r.Reset(header)
+		br.Reset(r)
+		if _, err := ReadProxyLine(br); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadProxyLineV1(b *testing.B) {
+	benchmarkReadProxyLine(b, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n")
+}
+
+func BenchmarkReadProxyLineV1IPv6(b *testing.B) {
+	benchmarkReadProxyLine(b, "PROXY TCP6 2001:db8::1 2001:db8::2 1000 80\r\n")
+}
+
+func BenchmarkReadProxyLineV2(b *testing.B) {
+	benchmarkReadProxyLine(b, proxyV2(1, 0x11, 192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb))
+}
+
+func BenchmarkReadProxyLineV2TLVs(b *testing.B) {
+	benchmarkReadProxyLine(b, proxyV2(1, 0x11, 192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb,
+		0x05, 0x00, 0x04, 'u', 'u', 'i', 'd', 0x30, 0x00, 0x02, 'n', 's'))
+}
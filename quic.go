@@ -0,0 +1,138 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// HTTP/3 over QUIC, served through an external QUIC stack.
+
+package http
+
+import (
+	"log"
+	"net"
+	"runtime"
+)
+
+// A QUICListener is the integration point of a QUIC stack with a
+// Server, for serving HTTP/3. The stack handles QUIC and the HTTP/3
+// framing; the Server serves the requests it decodes.
+//
+// Support is experimental, and the interfaces may change.
+type QUICListener interface {
+	// Accept waits for and returns the next connection.
+	Accept() (QUICConn, error)
+
+	// Close closes the listener; Accept then returns an error.
+	Close() error
+
+	// Addr returns the listener's UDP address, whose port is
+	// advertised by the Server in Alt-Svc headers.
+	Addr() net.Addr
+}
+
+// A QUICConn is a client's QUIC connection, carrying HTTP/3 request
+// streams.
+//
+// If the stack learned the client's address from a PROXY protocol
+// header, as sent by UDP load balancers, the connection should also
+// have a method
+//
+//	ProxyLine() *ProxyLine
+//
+// returning it. The requests then report the header's source address
+// as RemoteAddr and the header as ProxyLine, as they do for TCP
+// connections read with ReadProxyHeader.
+type QUICConn interface {
+	// AcceptRequest waits for and returns the next request stream
+	// of the connection: the request, whose Body reads the
+	// stream, and the QUICResponseWriter replying on it.
+	AcceptRequest() (*Request, QUICResponseWriter, error)
+
+	RemoteAddr() net.Addr
+	Close() error
+}
+
+// A QUICResponseWriter is a ResponseWriter replying on an HTTP/3
+// request stream. Close ends the stream once the handler is done.
+type QUICResponseWriter interface {
+	ResponseWriter
+	Close() error
+}
+
+// ServeQUIC accepts incoming QUIC connections on the listener l of a
+// QUIC stack and serves their HTTP/3 requests with srv.Handler, each
+// on its own goroutine, until l fails or srv is closed. While it does,
+// replies on srv's other listeners advertise l's port with an Alt-Svc
+// header, unless their handler removes it, so that clients may switch
+// to HTTP/3.
+func (srv *Server) ServeQUIC(l QUICListener) error {
+	defer l.Close()
+	if !srv.trackQUIC(l, true) {
+		return ErrServerClosed
+	}
+	defer srv.trackQUIC(l, false)
+	for {
+		qc, err := l.Accept()
+		if err != nil {
+			if srv.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go srv.serveQUICConn(qc)
+	}
+}
+
+func (srv *Server) trackQUIC(l QUICListener, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if !add {
+		delete(srv.quic, l)
+		srv.updateQUICAltSvc()
+		return true
+	}
+	if srv.closed {
+		return false
+	}
+	if srv.quic == nil {
+		srv.quic = make(map[QUICListener]bool)
+	}
+	srv.quic[l] = true
+	srv.updateQUICAltSvc()
+	return true
+}
+
+func (srv *Server) serveQUICConn(qc QUICConn) {
+	defer qc.Close()
+	var line *ProxyLine
+	if pc, ok := qc.(interface {
+		ProxyLine() *ProxyLine
+	}); ok {
+		line = pc.ProxyLine()
+	}
+	remote := qc.RemoteAddr().String()
+	if line != nil && line.Source != nil {
+		remote = line.Source.String()
+	}
+	for {
+		req, w, err := qc.AcceptRequest()
+		if err != nil {
+			return
+		}
+		req.RemoteAddr = remote
+		req.ProxyLine = line
+		go srv.serveQUICRequest(w, req)
+	}
+}
+
+func (srv *Server) serveQUICRequest(w QUICResponseWriter, req *Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			const size = 4096
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			log.Printf("http: panic serving %v: %v\n%s", req.RemoteAddr, err, buf)
+		}
+		w.Close()
+	}()
+	serverHandler{srv, nil}.ServeHTTP(w, req)
+}
@@ -0,0 +1,170 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// fakeQUIC is a QUICListener of a fake QUIC stack, accepting the
+// connections sent on conns.
+type fakeQUIC struct {
+	addr     *net.UDPAddr
+	conns    chan QUICConn
+	accepted chan bool // receives when Accept is first called
+	closed   chan bool
+}
+
+func newFakeQUIC(port int) *fakeQUIC {
+	return &fakeQUIC{
+		addr:     &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port},
+		conns:    make(chan QUICConn),
+		accepted: make(chan bool, 1),
+		closed:   make(chan bool),
+	}
+}
+
+func (l *fakeQUIC) Accept() (QUICConn, error) {
+	select {
+	case l.accepted <- true:
+	default:
+	}
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, errors.New("closed")
+	}
+}
+
+func (l *fakeQUIC) Close() error {
+	select {
+	case <-l.closed:
+	default:
+		close(l.closed)
+	}
+	return nil
+}
+
+func (l *fakeQUIC) Addr() net.Addr { return l.addr }
+
+type fakeQUICConn struct {
+	remote net.Addr
+	line   *ProxyLine
+	reqs   chan *Request
+	done   chan *fakeQUICStream
+}
+
+func (c *fakeQUICConn) AcceptRequest() (*Request, QUICResponseWriter, error) {
+	req, ok := <-c.reqs
+	if !ok {
+		return nil, nil, errors.New("closed")
+	}
+	return req, &fakeQUICStream{httptest.NewRecorder(), c.done}, nil
+}
+
+func (c *fakeQUICConn) RemoteAddr() net.Addr  { return c.remote }
+func (c *fakeQUICConn) Close() error          { return nil }
+func (c *fakeQUICConn) ProxyLine() *ProxyLine { return c.line }
+
+type fakeQUICStream struct {
+	*httptest.ResponseRecorder
+	done chan *fakeQUICStream
+}
+
+func (s *fakeQUICStream) Close() error {
+	s.done <- s
+	return nil
+}
+
+func TestServeQUIC(t *testing.T) {
+	defer afterTest(t)
+	log.SetOutput(ioutil.Discard) // of the handler panic
+	defer log.SetOutput(os.Stderr)
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("X-Remote", r.RemoteAddr)
+		if r.ProxyLine != nil {
+			w.Header().Set("X-Proxied", "yes")
+		}
+		if r.URL.Path == "/panic" {
+			panic("handler panic")
+		}
+	})}
+	l := newFakeQUIC(4433)
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ServeQUIC(l) }()
+
+	client := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5555}
+	src := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 9), Port: 7777}
+	tests := []struct {
+		line       *ProxyLine
+		wantRemote string
+		wantProxy  bool
+	}{
+		{nil, client.String(), false},
+		{&ProxyLine{Version: 2, Source: src, Destination: l.addr}, src.String(), true},
+	}
+	for _, tt := range tests {
+		c := &fakeQUICConn{remote: client, line: tt.line, reqs: make(chan *Request), done: make(chan *fakeQUICStream)}
+		l.conns <- c
+		for _, path := range []string{"/", "/panic"} {
+			c.reqs <- httptest.NewRequest("GET", path, nil)
+			s := <-c.done
+			if got := s.Header().Get("X-Remote"); got != tt.wantRemote {
+				t.Errorf("%s: RemoteAddr = %q; want %q", path, got, tt.wantRemote)
+			}
+			if got := s.Header().Get("X-Proxied") != ""; got != tt.wantProxy {
+				t.Errorf("%s: ProxyLine set = %v; want %v", path, got, tt.wantProxy)
+			}
+		}
+		close(c.reqs)
+	}
+
+	srv.Close()
+	if err := <-errc; err != ErrServerClosed {
+		t.Errorf("ServeQUIC = %v; want ErrServerClosed", err)
+	}
+}
+
+func TestServerAltSvc(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/none" {
+			w.Header().Del("Alt-Svc")
+		}
+	}))
+	ts.Start()
+	defer ts.Close()
+
+	get := func(path string) string {
+		res, err := Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.Header.Get("Alt-Svc")
+	}
+	if got := get("/"); got != "" {
+		t.Errorf("Alt-Svc without QUIC = %q; want none", got)
+	}
+
+	l := newFakeQUIC(4433)
+	defer l.Close()
+	go ts.Config.ServeQUIC(l)
+	<-l.accepted
+	if got, want := get("/"), `h3=":4433"; ma=86400`; got != want {
+		t.Errorf("Alt-Svc = %q; want %q", got, want)
+	}
+	if got := get("/none"); got != "" {
+		t.Errorf("Alt-Svc removed by handler = %q; want none", got)
+	}
+}
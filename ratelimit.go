@@ -0,0 +1,183 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Per-client rate limiting.
+
+package http
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A RateLimiter limits the rate of new connections and of requests
+// from each client, using a token bucket for each. Clients are
+// identified by the IP address of the connection's RemoteAddr, which
+// is the client's own address, not the load balancer's, when the
+// listener reads PROXY protocol headers with ReadProxyHeader.
+//
+// Requests over the request limit are answered with 429 Too Many
+// Requests. On connections over the connection limit, the first
+// request is answered that way and the connection is closed.
+//
+// A RateLimiter may be shared by several Servers. Its fields must not
+// be changed once it is in use.
+type RateLimiter struct {
+	// ConnsPerSecond, if positive, is the sustained rate of new
+	// connections allowed from a client, and ConnBurst the number
+	// that may be opened at once. ConnBurst defaults to 1.
+	ConnsPerSecond float64
+	ConnBurst      int
+
+	// RequestsPerSecond, if positive, is the sustained rate of
+	// requests allowed from a client, and RequestBurst the number
+	// that may be made at once. RequestBurst defaults to 1.
+	RequestsPerSecond float64
+	RequestBurst      int
+
+	// Key optionally maps a connection's remote address to the key
+	// its limits are shared by, for example to group IPv6
+	// addresses by prefix. If nil, the IP address is used.
+	Key func(remoteAddr string) string
+
+	mu      sync.Mutex
+	clients map[string]*rateClient
+	sweepAt int // size of clients triggering the next sweep
+}
+
+// rateClient holds the token buckets of one client.
+type rateClient struct {
+	conns, requests tokenBucket
+}
+
+// tokenBucket holds tokens refilled continuously at a rate up to a
+// burst size.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills b up to burst at rate tokens per second as of now and
+// takes a token, reporting false and how long until one is available
+// if there is none.
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) (bool, time.Duration) {
+	b.refill(now, rate, burst)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+func (b *tokenBucket) refill(now time.Time, rate float64, burst int) {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+}
+
+func (b *tokenBucket) full(now time.Time, rate float64, burst int) bool {
+	return rate <= 0 || b.last.IsZero() || b.tokens+now.Sub(b.last).Seconds()*rate >= float64(burst)
+}
+
+func burstOrOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// allowConn reports whether a new connection from remoteAddr is
+// within the limit.
+func (rl *RateLimiter) allowConn(remoteAddr string) bool {
+	if rl.ConnsPerSecond <= 0 {
+		return true
+	}
+	ok, _ := rl.take(remoteAddr, true)
+	return ok
+}
+
+// allowRequest reports whether a request from remoteAddr is within the
+// limit and, if not, how long until it would be.
+func (rl *RateLimiter) allowRequest(remoteAddr string) (bool, time.Duration) {
+	if rl.RequestsPerSecond <= 0 {
+		return true, 0
+	}
+	return rl.take(remoteAddr, false)
+}
+
+func (rl *RateLimiter) take(remoteAddr string, conn bool) (bool, time.Duration) {
+	key := remoteAddr
+	if rl.Key != nil {
+		key = rl.Key(remoteAddr)
+	} else if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		key = host
+	}
+	now := time.Now()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	c := rl.clients[key]
+	if c == nil {
+		if rl.clients == nil {
+			rl.clients = make(map[string]*rateClient)
+		}
+		if len(rl.clients) >= rl.sweepAt {
+			rl.sweepLocked(now)
+		}
+		c = new(rateClient)
+		rl.clients[key] = c
+	}
+	if conn {
+		return c.conns.take(now, rl.ConnsPerSecond, burstOrOne(rl.ConnBurst))
+	}
+	return c.requests.take(now, rl.RequestsPerSecond, burstOrOne(rl.RequestBurst))
+}
+
+// sweepLocked forgets clients whose buckets have refilled, as they are
+// no different from new ones.
+func (rl *RateLimiter) sweepLocked(now time.Time) {
+	for key, c := range rl.clients {
+		if c.conns.full(now, rl.ConnsPerSecond, burstOrOne(rl.ConnBurst)) &&
+			c.requests.full(now, rl.RequestsPerSecond, burstOrOne(rl.RequestBurst)) {
+			delete(rl.clients, key)
+		}
+	}
+	rl.sweepAt = 2 * len(rl.clients)
+	if rl.sweepAt < 1024 {
+		rl.sweepAt = 1024
+	}
+}
+
+// tooManyRequests answers a request over a rate limit, asking the
+// client to retry after retryAfter.
+func tooManyRequests(w ResponseWriter, r *Request, retryAfter time.Duration) {
+	secs := int64(math.Ceil(retryAfter.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+	respondError(w, r, statusTooManyRequests, "Too Many Requests", errRateLimited)
+}
+
+// connRateLimitedHandler answers the first request on a connection
+// over the connection rate limit.
+var connRateLimitedHandler = HandlerFunc(func(w ResponseWriter, r *Request) {
+	w.Header().Set("Connection", "close")
+	tooManyRequests(w, r, 0)
+})
+
+// allowRequest applies the Server's request rate limit, if any, to the
+// next request on c.
+func (c *conn) allowRequest() (bool, time.Duration) {
+	rl := c.server.RateLimiter
+	if rl == nil || c.overLimit || c.throttled {
+		return true, 0
+	}
+	return rl.allowRequest(c.remoteAddr)
+}
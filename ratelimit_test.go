@@ -0,0 +1,86 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"testing"
+)
+
+func TestServerRateLimiter(t *testing.T) {
+	defer afterTest(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	srv := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {}),
+		RateLimiter: &RateLimiter{
+			ConnsPerSecond:    0.01,
+			ConnBurst:         2,
+			RequestsPerSecond: 0.01,
+			RequestBurst:      3,
+		},
+	}
+	go srv.ServeWithOptions(ln, ListenerOptions{WrapConn: ReadProxyHeader})
+
+	// dial opens a connection for the client at ip and sends n requests
+	// on it, returning the status codes of the responses.
+	dial := func(ip string, n int) (codes []int, retryAfter string) {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		fmt.Fprintf(c, "PROXY TCP4 %s 192.0.2.100 1234 80\r\n", ip)
+		br := bufio.NewReader(c)
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+			res, err := ReadResponse(br, nil)
+			if err != nil {
+				break
+			}
+			ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			codes = append(codes, res.StatusCode)
+			if ra := res.Header.Get("Retry-After"); ra != "" {
+				retryAfter = ra
+			}
+			if res.Close {
+				break
+			}
+		}
+		return
+	}
+
+	codes, retry := dial("203.0.113.1", 4)
+	if want := fmt.Sprint([]int{200, 200, 200, 429}); fmt.Sprint(codes) != want {
+		t.Errorf("requests over the limit: got %v; want %v", codes, want)
+	}
+	if retry != "100" {
+		t.Errorf("Retry-After = %q; want 100", retry)
+	}
+	// Another client, behind the same proxy, has its own limits.
+	if codes, _ := dial("203.0.113.2", 1); fmt.Sprint(codes) != "[200]" {
+		t.Errorf("second client: got %v; want [200]", codes)
+	}
+	// The first client's second connection is within the burst but
+	// its requests are still limited; its third is over the
+	// connection limit and closed after one response.
+	if codes, _ := dial("203.0.113.1", 1); fmt.Sprint(codes) != "[429]" {
+		t.Errorf("second connection: got %v; want [429]", codes)
+	}
+	if codes, _ := dial("203.0.113.2", 2); fmt.Sprint(codes) != "[200 200]" {
+		t.Errorf("second client's second connection: got %v; want [200 200]", codes)
+	}
+	if codes, _ := dial("203.0.113.2", 2); fmt.Sprint(codes) != "[429]" {
+		t.Errorf("connection over the limit: got %v; want [429]", codes)
+	}
+}
@@ -9,14 +9,15 @@ package http
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime"
 	"mime/multipart"
-	"net/textproto"
 	"net/url"
 	"strconv"
 	"strings"
@@ -117,6 +118,14 @@ type Request struct {
 	// Handler does not need to.
 	Body io.ReadCloser
 
+	// GetBody, if non-nil, returns a new copy of Body, so that a
+	// client can send the request again, as a Client does when
+	// following a 307 or 308 redirect and a Transport does when it
+	// retries the request. NewRequest sets it for bodies that are
+	// a *bytes.Buffer, *bytes.Reader or *strings.Reader. It's
+	// unused by servers.
+	GetBody func() (io.ReadCloser, error)
+
 	// ContentLength records the length of the associated content.
 	// The value -1 indicates that the length is unknown.
 	// Values >= 0 indicate that the given number of bytes may
@@ -186,9 +195,51 @@ type Request struct {
 	// was received. This field is not filled in by ReadRequest.
 	// The HTTP server in this package sets the field for
 	// TLS-enabled connections before invoking a handler;
-	// otherwise it leaves the field nil.
+	// otherwise it leaves the field nil. If the client presented
+	// a certificate (see Server.ClientCAs), PeerCertificates and
+	// VerifiedChains hold it. The field is also set when the
+	// listener wraps TLS connections in a WrappedConn, whose
+	// address is then reported in RemoteAddr.
 	// This field is ignored by the HTTP client.
 	TLS *tls.ConnectionState
+
+	// ProxyLine is, for server requests, the PROXY protocol header
+	// read by ReadProxyHeader at the start of the connection, or
+	// nil. Unlike RemoteAddr, it tells whether the addresses came
+	// from a proxy. This field is ignored by the HTTP client.
+	ProxyLine *ProxyLine
+
+	// Response is the redirect response which caused this request
+	// to be created, linking back through its Request field to the
+	// chain of earlier requests and responses. This field is only
+	// populated during client redirects.
+	Response *Response
+
+	ctx context.Context // see Context and WithContext
+
+	reuse *reuseState // for server requests; see Retain
+}
+
+// Context returns the request's context, carrying values such as its
+// request ID. It is never nil; it defaults to context.Background.
+func (r *Request) Context() context.Context {
+	r.checkReuse("Request.Context")
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of r with its context changed to
+// ctx, which must be non-nil.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("http: nil Context")
+	}
+	r2 := new(Request)
+	*r2 = *r
+	r2.ctx = ctx
+	return r2
 }
 
 // ProtoAtLeast reports whether the HTTP protocol used
@@ -205,6 +256,7 @@ func (r *Request) UserAgent() string {
 
 // Cookies parses and returns the HTTP cookies sent with the request.
 func (r *Request) Cookies() []*Cookie {
+	r.checkReuse("Request.Cookies")
 	return readCookies(r.Header, "")
 }
 
@@ -213,6 +265,7 @@ var ErrNoCookie = errors.New("http: named cookie not present")
 // Cookie returns the named cookie provided in the request or
 // ErrNoCookie if not found.
 func (r *Request) Cookie(name string) (*Cookie, error) {
+	r.checkReuse("Request.Cookie")
 	for _, c := range readCookies(r.Header, name) {
 		return c, nil
 	}
@@ -244,6 +297,36 @@ func (r *Request) Referer() string {
 	return r.Header.Get("Referer")
 }
 
+// BasicAuth returns the username and password provided in the request's
+// Authorization header, if the request uses HTTP Basic Authentication.
+// See RFC 2617, Section 2.
+func (r *Request) BasicAuth() (username, password string, ok bool) {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return
+	}
+	return parseBasicAuth(auth)
+}
+
+// parseBasicAuth parses an HTTP Basic Authentication string.
+// "Basic QWxhZGRpbjpvcGVuIHNlc2FtZQ==" returns ("Aladdin", "open sesame", true).
+func parseBasicAuth(auth string) (username, password string, ok bool) {
+	const prefix = "Basic "
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return
+	}
+	c, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return
+	}
+	cs := string(c)
+	s := strings.IndexByte(cs, ':')
+	if s < 0 {
+		return
+	}
+	return cs[:s], cs[s+1:], true
+}
+
 // multipartByReader is a sentinel value.
 // Its presence in Request.MultipartForm indicates that parsing of the request
 // body has been handed off to a MultipartReader instead of ParseMultipartFrom.
@@ -257,6 +340,7 @@ var multipartByReader = &multipart.Form{
 // Use this function instead of ParseMultipartForm to
 // process the request body as a stream.
 func (r *Request) MultipartReader() (*multipart.Reader, error) {
+	r.checkReuse("Request.MultipartReader")
 	if r.MultipartForm == multipartByReader {
 		return nil, errors.New("http: MultipartReader called twice")
 	}
@@ -268,19 +352,27 @@ func (r *Request) MultipartReader() (*multipart.Reader, error) {
 }
 
 func (r *Request) multipartReader() (*multipart.Reader, error) {
+	boundary, err := r.multipartBoundary()
+	if err != nil {
+		return nil, err
+	}
+	return multipart.NewReader(r.Body, boundary), nil
+}
+
+func (r *Request) multipartBoundary() (string, error) {
 	v := r.Header.Get("Content-Type")
 	if v == "" {
-		return nil, ErrNotMultipart
+		return "", ErrNotMultipart
 	}
 	d, params, err := mime.ParseMediaType(v)
 	if err != nil || d != "multipart/form-data" {
-		return nil, ErrNotMultipart
+		return "", ErrNotMultipart
 	}
 	boundary, ok := params["boundary"]
 	if !ok {
-		return nil, ErrMissingBoundary
+		return "", ErrMissingBoundary
 	}
-	return multipart.NewReader(r.Body, boundary), nil
+	return boundary, nil
 }
 
 // Return value if nonempty, def otherwise.
@@ -312,7 +404,7 @@ const defaultUserAgent = "Go 1.1 package http"
 // hasn't been set to "identity", Write adds "Transfer-Encoding:
 // chunked" to the header. Body is closed after it is sent.
 func (r *Request) Write(w io.Writer) error {
-	return r.write(w, false, nil)
+	return r.write(w, false, nil, nil)
 }
 
 // WriteProxy is like Write but writes the request in the form
@@ -322,11 +414,12 @@ func (r *Request) Write(w io.Writer) error {
 // In either case, WriteProxy also writes a Host header, using
 // either r.Host or r.URL.Host.
 func (r *Request) WriteProxy(w io.Writer) error {
-	return r.write(w, true, nil)
+	return r.write(w, true, nil, nil)
 }
 
 // extraHeaders may be nil
-func (req *Request) write(w io.Writer, usingProxy bool, extraHeaders Header) error {
+// waitForContinue may be nil
+func (req *Request) write(w io.Writer, usingProxy bool, extraHeaders Header, waitForContinue func() bool) error {
 	host := req.Host
 	if host == "" {
 		if req.URL == nil {
@@ -334,6 +427,9 @@ func (req *Request) write(w io.Writer, usingProxy bool, extraHeaders Header) err
 		}
 		host = req.URL.Host
 	}
+	if !isASCII(host) {
+		host = asciiHost(host)
+	}
 
 	ruri := req.URL.RequestURI()
 	if usingProxy && req.URL.Scheme != "" && req.URL.Opaque == "" {
@@ -396,6 +492,19 @@ func (req *Request) write(w io.Writer, usingProxy bool, extraHeaders Header) err
 
 	io.WriteString(w, "\r\n")
 
+	// Flush and wait for 100-continue if expected.
+	if waitForContinue != nil {
+		if bw, ok := w.(*bufio.Writer); ok {
+			if err = bw.Flush(); err != nil {
+				return err
+			}
+		}
+		if !waitForContinue() {
+			req.Body.Close()
+			return nil
+		}
+	}
+
 	// Write body and trailer
 	err = tw.WriteBody(w)
 	if err != nil {
@@ -464,10 +573,24 @@ func NewRequest(method, urlStr string, body io.Reader) (*Request, error) {
 		switch v := body.(type) {
 		case *bytes.Buffer:
 			req.ContentLength = int64(v.Len())
+			buf := v.Bytes()
+			req.GetBody = func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(buf)), nil
+			}
 		case *bytes.Reader:
 			req.ContentLength = int64(v.Len())
+			snapshot := *v
+			req.GetBody = func() (io.ReadCloser, error) {
+				r := snapshot
+				return ioutil.NopCloser(&r), nil
+			}
 		case *strings.Reader:
 			req.ContentLength = int64(v.Len())
+			snapshot := *v
+			req.GetBody = func() (io.ReadCloser, error) {
+				r := snapshot
+				return ioutil.NopCloser(&r), nil
+			}
 		}
 	}
 
@@ -494,40 +617,41 @@ func parseRequestLine(line string) (method, requestURI, proto string, ok bool) {
 	return line[:s1], line[s1+1 : s2], line[s2+1:], true
 }
 
-// TODO(bradfitz): use a sync.Cache when available
-var textprotoReaderCache = make(chan *textproto.Reader, 4)
-
-func newTextprotoReader(br *bufio.Reader) *textproto.Reader {
-	select {
-	case r := <-textprotoReaderCache:
-		r.R = br
-		return r
-	default:
-		return textproto.NewReader(br)
-	}
+// ReadRequest reads and parses a request from b.
+func ReadRequest(b *bufio.Reader) (req *Request, err error) {
+	return readRequest(b, nil)
 }
 
-func putTextprotoReader(r *textproto.Reader) {
-	r.R = nil
-	select {
-	case textprotoReaderCache <- r:
-	default:
+// readRequest is ReadRequest filling in reuse, if non-nil, a Request
+// being reused.
+func readRequest(b *bufio.Reader, reuse *Request) (req *Request, err error) {
+	if req, err = readRequestHeader(b, reuse); err != nil {
+		return nil, err
+	}
+	if err = readTransfer(req, b); err != nil {
+		return nil, err
 	}
+	return req, nil
 }
 
-// ReadRequest reads and parses a request from b.
-func ReadRequest(b *bufio.Reader) (req *Request, err error) {
+// readRequestHeader reads the request line and header of a request
+// from b, leaving its body unread. If reuse is non-nil, it is filled
+// in rather than allocating a Request.
+func readRequestHeader(b *bufio.Reader, reuse *Request) (req *Request, err error) {
 
-	tp := newTextprotoReader(b)
-	req = new(Request)
+	hr := newHeaderReader()
+	defer putHeaderReader(hr)
+	req = reuse
+	if req == nil {
+		req = new(Request)
+	}
 
 	// First line: GET /index.html HTTP/1.0
 	var s string
-	if s, err = tp.ReadLine(); err != nil {
+	if s, err = hr.readFirstLine(b); err != nil {
 		return nil, err
 	}
 	defer func() {
-		putTextprotoReader(tp)
 		if err == io.EOF {
 			err = io.ErrUnexpectedEOF
 		}
@@ -567,11 +691,9 @@ func ReadRequest(b *bufio.Reader) (req *Request, err error) {
 	}
 
 	// Subsequent lines: Key: value.
-	mimeHeader, err := tp.ReadMIMEHeader()
-	if err != nil {
+	if req.Header, err = hr.readHeaderInto(b, req.Header); err != nil {
 		return nil, err
 	}
-	req.Header = Header(mimeHeader)
 
 	// RFC2616: Must treat
 	//	GET /index.html HTTP/1.1
@@ -614,11 +736,6 @@ func ReadRequest(b *bufio.Reader) (req *Request, err error) {
 	//	Via
 	//	Warning
 
-	err = readTransfer(req, b)
-	if err != nil {
-		return nil, err
-	}
-
 	return req, nil
 }
 
@@ -682,7 +799,10 @@ func parsePostForm(r *Request) (vs url.Values, err error) {
 	case ct == "application/x-www-form-urlencoded":
 		var reader io.Reader = r.Body
 		maxFormSize := int64(1<<63 - 1)
-		if _, ok := r.Body.(*maxBytesReader); !ok {
+		if p := r.formPolicy(); p != nil && p.MaxBytes > 0 {
+			maxFormSize = p.MaxBytes
+			reader = io.LimitReader(r.Body, maxFormSize+1)
+		} else if _, ok := r.Body.(*maxBytesReader); !ok {
 			maxFormSize = int64(10 << 20) // 10 MB is a lot of text.
 			reader = io.LimitReader(r.Body, maxFormSize+1)
 		}
@@ -694,10 +814,14 @@ func parsePostForm(r *Request) (vs url.Values, err error) {
 			break
 		}
 		if int64(len(b)) > maxFormSize {
-			err = errors.New("http: POST too large")
+			if r.formPolicy() != nil {
+				err = formTooLarge("body too large")
+			} else {
+				err = errors.New("http: POST too large")
+			}
 			return
 		}
-		vs, e = url.ParseQuery(string(b))
+		vs, e = r.parseQuery(string(b))
 		if err == nil {
 			err = e
 		}
@@ -720,11 +844,13 @@ func parsePostForm(r *Request) (vs url.Values, err error) {
 // in r.Form.
 //
 // If the request Body's size has not already been limited by MaxBytesReader,
-// the size is capped at 10MB.
+// the size is capped at 10MB. The Server's FormPolicy may limit the form
+// further, failing with a *FormError.
 //
 // ParseMultipartForm calls ParseForm automatically.
 // It is idempotent.
 func (r *Request) ParseForm() error {
+	r.checkReuse("Request.ParseForm")
 	var err error
 	if r.PostForm == nil {
 		if r.Method == "POST" || r.Method == "PUT" {
@@ -742,7 +868,7 @@ func (r *Request) ParseForm() error {
 		var newValues url.Values
 		if r.URL != nil {
 			var e error
-			newValues, e = url.ParseQuery(r.URL.RawQuery)
+			newValues, e = r.parseQuery(r.URL.RawQuery)
 			if err == nil {
 				err = e
 			}
@@ -786,8 +912,23 @@ func (r *Request) ParseMultipartForm(maxMemory int64) error {
 		return err
 	}
 
-	f, err := mr.ReadForm(maxMemory)
+	var body *formBodyReader
+	p := r.formPolicy()
+	if p != nil && p.MaxBytes > 0 {
+		body = &formBodyReader{r: r.Body, n: p.MaxBytes}
+		boundary, _ := r.multipartBoundary()
+		mr = multipart.NewReader(body, boundary)
+	}
+	var f *multipart.Form
+	if p != nil && p.limitsFields() {
+		f, err = p.readForm(mr, maxMemory, len(r.Form))
+	} else {
+		f, err = mr.ReadForm(maxMemory)
+	}
 	if err != nil {
+		if body != nil && body.exceeded {
+			return formTooLarge("body too large")
+		}
 		return err
 	}
 	for k, v := range f.Value {
@@ -0,0 +1,154 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Request IDs.
+
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+)
+
+// contextKey is the type of the context keys of this package.
+type contextKey struct {
+	name string
+}
+
+func (k *contextKey) String() string { return "net/http context value " + k.name }
+
+var requestIDKey = &contextKey{"request-id"}
+
+// RequestIDOptions configures the request IDs assigned by a Server
+// whose RequestID field is set. Each request gets an ID, stored in
+// its context and header and echoed in the response header, so that
+// it can be followed through the access log, error pages and the
+// services it reaches.
+type RequestIDOptions struct {
+	// Header is the request and response header carrying the ID.
+	// If empty, "X-Request-ID" is used.
+	Header string
+
+	// TrustedProxies lists the networks allowed to supply the ID of
+	// a request in its header. The address checked is the peer's,
+	// not one reported by a PROXY protocol header, so it is that
+	// of a load balancer. Other requests always get a new ID.
+	TrustedProxies []*net.IPNet
+
+	// Generate optionally returns new IDs. If nil, IDs are 16
+	// random bytes in hex.
+	Generate func() string
+}
+
+// maxRequestIDLen is the longest incoming request ID kept.
+const maxRequestIDLen = 128
+
+// RequestIDFromContext returns the request ID stored in ctx by a
+// Server, or "" if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func (o *RequestIDOptions) header() string {
+	if o.Header == "" {
+		return "X-Request-ID"
+	}
+	return o.Header
+}
+
+func (o *RequestIDOptions) generate() string {
+	if o.Generate != nil {
+		return o.Generate()
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("http: can't generate request ID: " + err.Error())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// trusted reports whether a request ID may be taken from a request
+// whose peer address is addr.
+func (o *RequestIDOptions) trusted(addr net.Addr) bool {
+	ip := addrIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range o.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// validRequestID reports whether id is short enough and made of
+// printable ASCII, fit to be kept and logged.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// setRequestID assigns w's request its ID if the Server has
+// RequestID set.
+func (c *conn) setRequestID(w *response) {
+	o := c.server.RequestID
+	if o == nil {
+		return
+	}
+	h := o.header()
+	id := w.req.Header.Get(h)
+	if !validRequestID(id) || !o.trusted(peerAddr(c.rwc)) {
+		id = o.generate()
+	}
+	w.req.Header.Set(h, id)
+	w.req.ctx = context.WithValue(w.req.Context(), requestIDKey, id)
+	w.Header().Set(h, id)
+}
+
+// peerAddr returns the remote address of the connection underlying c,
+// beneath any TLS or WrappedConn layers.
+func peerAddr(c net.Conn) net.Addr {
+	for {
+		switch cc := c.(type) {
+		case *tls.Conn:
+			c = cc.NetConn()
+		case WrappedConn:
+			c = cc.UnderlyingConn()
+		default:
+			return c.RemoteAddr()
+		}
+	}
+}
+
+// addrIP returns the IP address of addr, or nil if it has none.
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	if addr == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,130 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustParseCIDR(t *testing.T, s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestServerRequestID(t *testing.T) {
+	defer afterTest(t)
+	logs := make(chan *AccessLogEntry, 10)
+	mux := NewServeMux()
+	mux.HandleFunc("/", func(w ResponseWriter, r *Request) {
+		fmt.Fprintf(w, "%s %s", RequestIDFromContext(r.Context()), r.Header.Get("X-Request-ID"))
+	})
+	mux.HandleFunc("/missing", NotFound)
+	srv := &Server{
+		Handler:   mux,
+		RequestID: &RequestIDOptions{TrustedProxies: []*net.IPNet{mustParseCIDR(t, "127.0.0.0/8")}},
+		AccessLog: func(e *AccessLogEntry) { logs <- e },
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go srv.ServeWithOptions(ln, ListenerOptions{WrapConn: ReadProxyHeader})
+
+	// A PROXY header reporting an untrusted client doesn't stop the
+	// trusted load balancer's ID from being kept.
+	get := func(path, id string) (*Response, string) {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		fmt.Fprintf(c, "PROXY TCP4 198.51.100.7 127.0.0.1 5555 80\r\nGET %s HTTP/1.1\r\nHost: x\r\n", path)
+		if id != "" {
+			fmt.Fprintf(c, "X-Request-ID: %s\r\n", id)
+		}
+		fmt.Fprintf(c, "Connection: close\r\n\r\n")
+		res, err := ReadResponse(bufio.NewReader(c), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return res, string(b)
+	}
+
+	res, body := get("/", "")
+	id := res.Header.Get("X-Request-ID")
+	if !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(id) {
+		t.Fatalf("generated ID %q; want 32 hex digits", id)
+	}
+	if body != id+" "+id {
+		t.Errorf("handler saw %q; want the ID in context and header", body)
+	}
+	e := <-logs
+	if e.RequestID != id || e.Status != 200 || e.Written != int64(len(body)) || e.Request.RemoteAddr != "198.51.100.7:5555" {
+		t.Errorf("access log entry %+v", e)
+	}
+	if s := e.String(); !strings.HasPrefix(s, "198.51.100.7 - - [") || !strings.HasSuffix(s, " id="+id) {
+		t.Errorf("access log line %q", s)
+	}
+
+	if res, body = get("/", "lb-1234"); res.Header.Get("X-Request-ID") != "lb-1234" || body != "lb-1234 lb-1234" {
+		t.Errorf("trusted ID: got header %q, body %q; want lb-1234", res.Header.Get("X-Request-ID"), body)
+	}
+	<-logs
+	if res, _ = get("/", "bad id"); res.Header.Get("X-Request-ID") == "bad id" {
+		t.Error("invalid incoming ID was kept")
+	}
+	<-logs
+
+	res, body = get("/missing", "lb-5678")
+	if res.StatusCode != 404 || body != "404 page not found\nrequest ID: lb-5678\n" {
+		t.Errorf("error page: %d %q", res.StatusCode, body)
+	}
+	if e := <-logs; e.Status != 404 || e.RequestID != "lb-5678" {
+		t.Errorf("access log entry for 404: %+v", e)
+	}
+
+	srv.RequestID.TrustedProxies = []*net.IPNet{mustParseCIDR(t, "10.0.0.0/8")}
+	if res, _ = get("/", "lb-1234"); res.Header.Get("X-Request-ID") == "lb-1234" {
+		t.Error("ID from an untrusted peer was kept")
+	}
+	<-logs
+}
+
+func TestAccessLogEntryString(t *testing.T) {
+	req := &Request{
+		Method:     "GET",
+		RequestURI: "/a?b=c",
+		Proto:      "HTTP/1.1",
+		URL:        &url.URL{Path: "/a", RawQuery: "b=c"},
+		Header:     Header{"Referer": {"http://example.com/"}, "User-Agent": {`Go "test"`}},
+		RemoteAddr: "[2001:db8::1]:1234",
+	}
+	e := &AccessLogEntry{
+		Request:  req,
+		Status:   200,
+		Written:  42,
+		Start:    time.Date(2013, 3, 4, 5, 6, 7, 0, time.UTC),
+		Duration: 1500 * time.Millisecond,
+	}
+	want := `2001:db8::1 - - [04/Mar/2013:05:06:07 +0000] "GET /a?b=c HTTP/1.1" 200 42 "http://example.com/" "Go \"test\"" 1.500`
+	if got := e.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"bufio"
 	"errors"
 	"io"
-	"net/textproto"
 	"net/url"
 	"strconv"
 	"strings"
@@ -49,6 +48,11 @@ type Response struct {
 	//
 	// The Body is automatically dechunked if the server replied
 	// with a "chunked" Transfer-Encoding.
+	//
+	// For a 101 Switching Protocols response returned by a
+	// Transport, Body is an io.ReadWriteCloser of the connection,
+	// which then speaks the protocol switched to and is closed by
+	// closing Body.
 	Body io.ReadCloser
 
 	// ContentLength records the length of the associated content.  The
@@ -105,13 +109,14 @@ func (r *Response) Location() (*url.URL, error) {
 // After that call, clients can inspect resp.Trailer to find key/value
 // pairs included in the response trailer.
 func ReadResponse(r *bufio.Reader, req *Request) (*Response, error) {
-	tp := textproto.NewReader(r)
+	hr := newHeaderReader()
+	defer putHeaderReader(hr)
 	resp := &Response{
 		Request: req,
 	}
 
 	// Parse the first line of the response.
-	line, err := tp.ReadLine()
+	line, err := hr.readFirstLine(r)
 	if err != nil {
 		if err == io.EOF {
 			err = io.ErrUnexpectedEOF
@@ -139,11 +144,9 @@ func ReadResponse(r *bufio.Reader, req *Request) (*Response, error) {
 	}
 
 	// Parse the response headers.
-	mimeHeader, err := tp.ReadMIMEHeader()
-	if err != nil {
+	if resp.Header, err = hr.readHeader(r); err != nil {
 		return nil, err
 	}
-	resp.Header = Header(mimeHeader)
 
 	fixPragmaCacheControl(resp.Header)
 
@@ -0,0 +1,339 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Server-side response caching.
+
+package http
+
+import (
+	"bytes"
+	"container/list"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the ResponseCache fields left zero.
+const (
+	DefaultResponseCacheTTL           = time.Minute
+	DefaultResponseCacheMaxBytes      = 32 << 20
+	DefaultResponseCacheMaxEntryBytes = 1 << 20
+)
+
+// A ResponseCache keeps the 200 OK responses of the handler it wraps to
+// GET requests, for a time, answering the requests with the same key
+// from memory, such as for expensive pages that needn't be up to the
+// second. HEAD requests are answered from the responses to GET ones.
+//
+// Responses with a Set-Cookie header or a Cache-Control header with
+// no-store or private aren't kept, nor those with a Vary header naming
+// request headers other than KeyHeaders, and neither are those to
+// requests with a Range header, or with an Authorization or Cookie
+// header unless Key tells their clients apart. Responses served from
+// the cache have an Age header and an "X-Cache: HIT" header, others
+// "X-Cache: MISS".
+//
+// Its fields must not be changed once it is in use.
+type ResponseCache struct {
+	// Handler is the handler whose responses are cached.
+	Handler Handler
+
+	// TTL is how long responses are kept. If zero,
+	// DefaultResponseCacheTTL is used.
+	TTL time.Duration
+
+	// Routes sets the TTLs of some paths: the TTL of the longest key
+	// matching the request's path applies, a key ending in a slash
+	// matching the subtree it names, as a ServeMux pattern does. A
+	// negative TTL keeps no responses.
+	Routes map[string]time.Duration
+
+	// KeyHeaders are the request headers whose values are part of
+	// the key of the request, besides whether it came over TLS and
+	// its host, path and query, such as "Accept-Encoding" or
+	// "Accept-Language" for handlers whose responses depend on them.
+	KeyHeaders []string
+
+	// Key, if non-nil, returns attributes of r's client that are part
+	// of its key too, such as the user it is authenticated as or its
+	// tenant, for handlers whose responses depend on them.
+	Key func(r *Request) string
+
+	// MaxBytes is the most bytes of response bodies kept, those used
+	// least recently being dropped to make room, and MaxEntryBytes
+	// the largest body kept. If zero, DefaultResponseCacheMaxBytes and
+	// DefaultResponseCacheMaxEntryBytes are used.
+	MaxBytes      int64
+	MaxEntryBytes int64
+
+	once   sync.Once
+	routes *routeTree // of Routes
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // of *cachedResponse
+	lru     list.List                // most recently used first
+	size    int64
+}
+
+// A cachedResponse is a response kept by a ResponseCache.
+type cachedResponse struct {
+	key     string
+	path    string
+	header  Header
+	body    []byte
+	created time.Time
+	expires time.Time
+}
+
+func (c *ResponseCache) ttl(path string) time.Duration {
+	c.once.Do(func() {
+		patterns := make([]string, 0, len(c.Routes))
+		for pattern := range c.Routes {
+			patterns = append(patterns, pattern)
+		}
+		c.routes = newRouteTree(patterns)
+	})
+	ttl := c.TTL
+	if pattern, ok := c.routes.match(path); ok {
+		ttl = c.Routes[pattern]
+	}
+	if ttl == 0 {
+		return DefaultResponseCacheTTL
+	}
+	return ttl
+}
+
+func (c *ResponseCache) maxBytes() int64 {
+	if c.MaxBytes <= 0 {
+		return DefaultResponseCacheMaxBytes
+	}
+	return c.MaxBytes
+}
+
+func (c *ResponseCache) maxEntryBytes() int64 {
+	if c.MaxEntryBytes <= 0 {
+		return DefaultResponseCacheMaxEntryBytes
+	}
+	return c.MaxEntryBytes
+}
+
+// key returns the key of r.
+func (c *ResponseCache) key(r *Request) string {
+	var b bytes.Buffer
+	if r.TLS != nil {
+		b.WriteString("https://")
+	} else {
+		b.WriteString("http://")
+	}
+	b.WriteString(strings.ToLower(r.Host))
+	b.WriteString(r.URL.RequestURI())
+	for _, name := range c.KeyHeaders {
+		b.WriteString("\x00" + strings.Join(r.Header[CanonicalHeaderKey(name)], ","))
+	}
+	if c.Key != nil {
+		b.WriteString("\x00" + c.Key(r))
+	}
+	return b.String()
+}
+
+func (c *ResponseCache) ServeHTTP(w ResponseWriter, r *Request) {
+	ttl := c.ttl(r.URL.Path)
+	if r.Method != "GET" && r.Method != "HEAD" || ttl < 0 || r.Header.Get("Range") != "" ||
+		(r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "") && c.Key == nil {
+		c.Handler.ServeHTTP(w, r)
+		return
+	}
+	key := c.key(r)
+	now := time.Now()
+	if cr := c.get(key, now); cr != nil {
+		h := w.Header()
+		for k, v := range cr.header {
+			h[k] = v
+		}
+		h.Set("Age", strconv.FormatInt(int64(now.Sub(cr.created)/time.Second), 10))
+		h.Set("X-Cache", "HIT")
+		h.Set("Content-Length", strconv.Itoa(len(cr.body)))
+		w.WriteHeader(StatusOK)
+		if r.Method != "HEAD" {
+			w.Write(cr.body)
+		}
+		return
+	}
+	w.Header().Set("X-Cache", "MISS")
+	if r.Method == "HEAD" {
+		c.Handler.ServeHTTP(w, r)
+		return
+	}
+	cw := &cacheWriter{ResponseWriter: w, max: c.maxEntryBytes()}
+	c.Handler.ServeHTTP(cw, r)
+	if cw.code == 0 {
+		cw.WriteHeader(StatusOK)
+	}
+	if cw.code != StatusOK || cw.over || !c.cacheable(cw.header) {
+		return
+	}
+	c.put(&cachedResponse{
+		key:     key,
+		path:    r.URL.Path,
+		header:  cw.header,
+		body:    cw.body.Bytes(),
+		created: now,
+		expires: now.Add(ttl),
+	})
+}
+
+// cacheable reports whether a response with header h may be kept.
+func (c *ResponseCache) cacheable(h Header) bool {
+	if len(h["Set-Cookie"]) > 0 {
+		return false
+	}
+	for _, v := range h["Vary"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" && !c.keyHeader(name) {
+				return false
+			}
+		}
+	}
+	for _, v := range h["Cache-Control"] {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.ToLower(strings.TrimSpace(d))
+			if d == "no-store" || d == "private" || strings.HasPrefix(d, "private=") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// keyHeader reports whether the request header name is one of
+// c.KeyHeaders, whose values are part of the key.
+func (c *ResponseCache) keyHeader(name string) bool {
+	for _, k := range c.KeyHeaders {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the unexpired response for key, or nil.
+func (c *ResponseCache) get(key string, now time.Time) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[key]
+	if e == nil {
+		return nil
+	}
+	cr := e.Value.(*cachedResponse)
+	if !now.Before(cr.expires) {
+		c.remove(e)
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	return cr
+}
+
+func (c *ResponseCache) put(cr *cachedResponse) {
+	size := int64(len(cr.body))
+	max := c.maxBytes()
+	if size > max {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+	}
+	if e := c.entries[cr.key]; e != nil {
+		c.remove(e)
+	}
+	for c.size+size > max {
+		c.remove(c.lru.Back())
+	}
+	c.entries[cr.key] = c.lru.PushFront(cr)
+	c.size += size
+}
+
+func (c *ResponseCache) remove(e *list.Element) {
+	cr := c.lru.Remove(e).(*cachedResponse)
+	delete(c.entries, cr.key)
+	c.size -= int64(len(cr.body))
+}
+
+// Purge drops the responses kept for path, or for the subtree it names
+// if it ends in a slash, as a ServeMux pattern does, such as when the
+// data they show changes.
+func (c *ResponseCache) Purge(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries {
+		if pathMatch(path, e.Value.(*cachedResponse).path) {
+			c.remove(e)
+		}
+	}
+}
+
+// PurgeAll drops all the responses kept.
+func (c *ResponseCache) PurgeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+	c.lru.Init()
+	c.size = 0
+}
+
+// Len returns the number of responses kept, expired or not.
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// A cacheWriter passes a response on while keeping its header and its
+// body, unless longer than max bytes, for a ResponseCache.
+type cacheWriter struct {
+	ResponseWriter
+	max int64
+
+	code   int
+	header Header // as written
+	body   bytes.Buffer
+	over   bool // the body is over max bytes
+}
+
+func (cw *cacheWriter) WriteHeader(code int) {
+	if cw.code != 0 {
+		return
+	}
+	cw.code = code
+	cw.header = cw.ResponseWriter.Header().clone()
+	delete(cw.header, "X-Cache")
+	delete(cw.header, "Content-Length")
+	delete(cw.header, "Date")
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *cacheWriter) Write(p []byte) (int, error) {
+	if cw.code == 0 {
+		if cw.ResponseWriter.Header().Get("Content-Type") == "" {
+			cw.ResponseWriter.Header().Set("Content-Type", DetectContentType(p))
+		}
+		cw.WriteHeader(StatusOK)
+	}
+	if !cw.over {
+		if int64(cw.body.Len()+len(p)) > cw.max {
+			cw.over = true
+			cw.body = bytes.Buffer{}
+		} else {
+			cw.body.Write(p)
+		}
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the ResponseWriter wrapped, for ResponseController.
+func (cw *cacheWriter) Unwrap() ResponseWriter {
+	return cw.ResponseWriter
+}
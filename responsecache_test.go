@@ -0,0 +1,182 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseCache(t *testing.T) {
+	hits := 0
+	c := &ResponseCache{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			hits++
+			switch r.URL.Path {
+			case "/cookie":
+				SetCookie(w, &Cookie{Name: "a", Value: "b"})
+			case "/private":
+				w.Header().Set("Cache-Control", "private, max-age=60")
+			case "/vary":
+				w.Header().Set("Vary", r.URL.Query().Get("h"))
+			case "/missing":
+				NotFound(w, r)
+				return
+			}
+			w.Header().Set("X-Hit", fmt.Sprint(hits))
+			fmt.Fprintf(w, "%s %s user=%s hit=%d", r.URL.RequestURI(), r.Header.Get("Accept-Language"), r.Header.Get("X-User"), hits)
+		}),
+		Routes:     map[string]time.Duration{"/live/": -1, "/short": 20 * time.Millisecond},
+		KeyHeaders: []string{"Accept-Language"},
+		Key:        func(r *Request) string { return r.Header.Get("X-User") },
+	}
+	serve := func(method, path string, hdrs ...string) *httptest.ResponseRecorder {
+		req, _ := NewRequest(method, "http://example.com"+path, nil)
+		for _, kv := range hdrs {
+			i := strings.Index(kv, ": ")
+			req.Header.Add(kv[:i], kv[i+2:])
+		}
+		rec := httptest.NewRecorder()
+		c.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := serve("GET", "/page?x=1")
+	if first.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("first GET: X-Cache %q; want MISS", first.Header().Get("X-Cache"))
+	}
+	rec := serve("GET", "/page?x=1")
+	if rec.Header().Get("X-Cache") != "HIT" || rec.Body.String() != first.Body.String() ||
+		rec.Header().Get("X-Hit") != "1" || rec.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("second GET: X-Cache %q, X-Hit %q, Content-Type %q, body %q; want the first response",
+			rec.Header().Get("X-Cache"), rec.Header().Get("X-Hit"), rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	if rec := serve("HEAD", "/page?x=1"); rec.Header().Get("X-Cache") != "HIT" || rec.Body.Len() != 0 ||
+		rec.Header().Get("Content-Length") != fmt.Sprint(first.Body.Len()) {
+		t.Errorf("HEAD: X-Cache %q, Content-Length %q, %d body bytes", rec.Header().Get("X-Cache"), rec.Header().Get("Content-Length"), rec.Body.Len())
+	}
+	req, _ := NewRequest("GET", "https://example.com/page?x=1", nil)
+	req.TLS = &tls.ConnectionState{}
+	rec = httptest.NewRecorder()
+	c.ServeHTTP(rec, req)
+	if rec.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("GET over TLS: X-Cache %q; want MISS", rec.Header().Get("X-Cache"))
+	}
+
+	tests := []struct {
+		method, path string
+		hdrs         []string
+		cached       bool
+	}{
+		{"GET", "/page?x=2", nil, true},
+		{"GET", "/page?x=1", []string{"Accept-Language: fr"}, true},
+		{"GET", "/page?x=1", []string{"X-User: alice"}, true},
+		{"GET", "/page?x=1", []string{"Cookie: session=alice"}, true},
+		{"POST", "/page?x=1", nil, false},
+		{"GET", "/page?x=1", []string{"Range: bytes=0-1"}, false},
+		{"GET", "/live/feed", nil, false},
+		{"GET", "/cookie", nil, false},
+		{"GET", "/private", nil, false},
+		{"GET", "/vary?h=accept-language", nil, true},
+		{"GET", "/vary?h=Accept-Encoding", nil, false},
+		{"GET", "/vary?h=*", nil, false},
+		{"GET", "/missing", nil, false},
+	}
+	for _, tt := range tests {
+		serve(tt.method, tt.path, tt.hdrs...)
+		before := hits
+		serve(tt.method, tt.path, tt.hdrs...)
+		if cached := hits == before; cached != tt.cached {
+			t.Errorf("%s %s %q: cached %v; want %v", tt.method, tt.path, tt.hdrs, cached, tt.cached)
+		}
+	}
+
+	serve("GET", "/short")
+	time.Sleep(30 * time.Millisecond)
+	if rec := serve("GET", "/short"); rec.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("GET past TTL: X-Cache %q; want MISS", rec.Header().Get("X-Cache"))
+	}
+
+	n := c.Len()
+	c.Purge("/page")
+	if got := c.Len(); got != n-5 {
+		t.Errorf("after Purge(/page): %d responses; want %d", got, n-5)
+	}
+	if rec := serve("GET", "/page?x=1"); rec.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("GET after Purge: X-Cache %q; want MISS", rec.Header().Get("X-Cache"))
+	}
+	c.PurgeAll()
+	if c.Len() != 0 {
+		t.Errorf("after PurgeAll: %d responses", c.Len())
+	}
+}
+
+// Without a Key, requests with credentials aren't answered from the
+// cache, nor their responses kept.
+func TestResponseCacheCredentials(t *testing.T) {
+	hits := 0
+	c := &ResponseCache{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			hits++
+			fmt.Fprintf(w, "hit=%d", hits)
+		}),
+	}
+	serve := func(hdr string) *httptest.ResponseRecorder {
+		req, _ := NewRequest("GET", "http://example.com/", nil)
+		if hdr != "" {
+			i := strings.Index(hdr, ": ")
+			req.Header.Set(hdr[:i], hdr[i+2:])
+		}
+		rec := httptest.NewRecorder()
+		c.ServeHTTP(rec, req)
+		return rec
+	}
+	serve("")
+	for _, hdr := range []string{"Cookie: session=alice", "Authorization: Basic YWxpY2U6"} {
+		before := hits
+		if rec := serve(hdr); hits == before || rec.Header().Get("X-Cache") == "HIT" {
+			t.Errorf("request with %q answered from the cache", hdr)
+		}
+	}
+	if c.Len() != 1 {
+		t.Errorf("%d responses kept; want 1", c.Len())
+	}
+}
+
+func TestResponseCacheSize(t *testing.T) {
+	c := &ResponseCache{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			w.Write([]byte(strings.Repeat("x", len(r.URL.Path)*10)))
+		}),
+		MaxBytes:      130,
+		MaxEntryBytes: 60,
+	}
+	serve := func(path string) string {
+		req, _ := NewRequest("GET", "http://example.com"+path, nil)
+		rec := httptest.NewRecorder()
+		c.ServeHTTP(rec, req)
+		return rec.Header().Get("X-Cache")
+	}
+	for _, p := range []string{"/aaa", "/bbb", "/ccc"} { // 40 bytes each
+		serve(p)
+	}
+	serve("/aaa")
+	serve("/bbbb") // 50 more, dropping /bbb, the least recently used
+	if got := c.Len(); got != 3 {
+		t.Errorf("%d responses kept; want 3", got)
+	}
+	if serve("/aaa") != "HIT" || serve("/bbb") != "MISS" {
+		t.Error("the least recently used response wasn't the one dropped")
+	}
+	serve("/toolong")
+	if serve("/toolong") != "MISS" {
+		t.Error("response over MaxEntryBytes was kept")
+	}
+}
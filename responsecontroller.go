@@ -0,0 +1,164 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"bufio"
+	"net"
+	"time"
+)
+
+// A ResponseController is used by an HTTP handler to control the
+// response, such as extending the deadlines of a long streaming
+// response one chunk at a time instead of relying on a long
+// Server.WriteTimeout.
+//
+// Its methods reach the ResponseWriter's optional features through
+// any middleware wrapping it that provides an Unwrap method, rather
+// than through type assertions, which wrapping defeats.
+//
+// A ResponseController may not be used after the handler's ServeHTTP
+// method has returned.
+type ResponseController struct {
+	rw ResponseWriter
+}
+
+// NewResponseController creates a ResponseController for a request.
+//
+// The ResponseWriter should be the original value passed to the
+// handler's ServeHTTP method, or have an Unwrap method returning the
+// original ResponseWriter, as middleware wrapping ResponseWriters
+// should provide.
+func NewResponseController(rw ResponseWriter) *ResponseController {
+	return &ResponseController{rw}
+}
+
+type rwUnwrapper interface {
+	Unwrap() ResponseWriter
+}
+
+// Flush flushes buffered data to the client.
+func (c *ResponseController) Flush() error {
+	rw := c.rw
+	for {
+		switch t := rw.(type) {
+		case Flusher:
+			t.Flush()
+			return nil
+		case rwUnwrapper:
+			rw = t.Unwrap()
+		default:
+			return ErrNotSupported
+		}
+	}
+}
+
+// Hijack lets the caller take over the connection.
+// See the Hijacker interface for details.
+func (c *ResponseController) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	rw := c.rw
+	for {
+		switch t := rw.(type) {
+		case Hijacker:
+			return t.Hijack()
+		case rwUnwrapper:
+			rw = t.Unwrap()
+		default:
+			return nil, nil, ErrNotSupported
+		}
+	}
+}
+
+// SetReadDeadline sets the deadline for reading the entire request,
+// including the body. Reads from the request body after the deadline
+// has been exceeded will return an error. A zero value means no
+// deadline.
+func (c *ResponseController) SetReadDeadline(deadline time.Time) error {
+	rw := c.rw
+	for {
+		switch t := rw.(type) {
+		case interface{ SetReadDeadline(time.Time) error }:
+			return t.SetReadDeadline(deadline)
+		case rwUnwrapper:
+			rw = t.Unwrap()
+		default:
+			return ErrNotSupported
+		}
+	}
+}
+
+// SetWriteDeadline sets the deadline for writing the response.
+// Writes to the response body after the deadline has been exceeded
+// will not block, but may succeed if the data has been buffered. A
+// zero value means no deadline.
+func (c *ResponseController) SetWriteDeadline(deadline time.Time) error {
+	rw := c.rw
+	for {
+		switch t := rw.(type) {
+		case interface{ SetWriteDeadline(time.Time) error }:
+			return t.SetWriteDeadline(deadline)
+		case rwUnwrapper:
+			rw = t.Unwrap()
+		default:
+			return ErrNotSupported
+		}
+	}
+}
+
+// EnableFullDuplex indicates that the request handler will
+// interleave reads from Request.Body with writes to the
+// ResponseWriter.
+//
+// By default, a Server consumes any unread portion of the request
+// body before beginning to write the response, as HTTP/1.x clients
+// generally don't read the response until they have sent the whole
+// request. Handlers of clients that do, such as bidirectional
+// streaming protocols, call EnableFullDuplex before writing the
+// response to keep reading the body afterwards, possibly from another
+// goroutine than the one writing. All reads of the body must be done
+// before the handler's ServeHTTP method returns.
+func (c *ResponseController) EnableFullDuplex() error {
+	rw := c.rw
+	for {
+		switch t := rw.(type) {
+		case interface{ EnableFullDuplex() error }:
+			return t.EnableFullDuplex()
+		case rwUnwrapper:
+			rw = t.Unwrap()
+		default:
+			return ErrNotSupported
+		}
+	}
+}
+
+func (w *response) SetReadDeadline(deadline time.Time) error {
+	if w.conn.hijacked() {
+		return ErrHijacked
+	}
+	return w.conn.setReadDeadline(deadline)
+}
+
+func (w *response) SetWriteDeadline(deadline time.Time) error {
+	if w.conn.hijacked() {
+		return ErrHijacked
+	}
+	return w.conn.setWriteDeadline(deadline)
+}
+
+func (w *response) EnableFullDuplex() error {
+	if w.conn.hijacked() {
+		return ErrHijacked
+	}
+	w.fullDuplex = true
+	// A client expecting 100 Continue is told to send the body now,
+	// as the first read of the body may come after the response
+	// has begun, when it is too late to.
+	if _, ok := bodyExpecter(w.req.Body); ok && !w.wroteContinue && !w.wroteHeader {
+		w.wroteContinue = true
+		w.conn.buf.WriteString("HTTP/1.1 100 Continue\r\n\r\n")
+		w.conn.buf.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wrapWriter is middleware's ResponseWriter, unwrapping to the
+// original.
+type wrapWriter struct {
+	ResponseWriter
+}
+
+func (w wrapWriter) Unwrap() ResponseWriter { return w.ResponseWriter }
+
+func TestResponseControllerWriteDeadline(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		ctl := NewResponseController(wrapWriter{w})
+		for i := 0; i < 5; i++ {
+			if err := ctl.SetWriteDeadline(time.Now().Add(time.Second)); err != nil {
+				t.Errorf("SetWriteDeadline: %v", err)
+				return
+			}
+			fmt.Fprintf(w, "chunk %d\n", i)
+			if err := ctl.Flush(); err != nil {
+				t.Errorf("Flush: %v", err)
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	ts.Config.WriteTimeout = 100 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	res, err := Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatalf("reading streamed body: %v", err)
+	}
+	if n := strings.Count(string(b), "chunk"); n != 5 {
+		t.Errorf("got %d chunks past the WriteTimeout; want 5: %q", n, b)
+	}
+}
+
+// Deadlines a handler sets must not carry over to the next request on
+// the connection.
+func TestResponseControllerDeadlineKeepAlive(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/set" {
+			if err := NewResponseController(w).SetWriteDeadline(time.Now().Add(200 * time.Millisecond)); err != nil {
+				t.Errorf("SetWriteDeadline: %v", err)
+			}
+			io.WriteString(w, "set")
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+		io.WriteString(w, "slept")
+	}))
+	defer ts.Close()
+
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr, Timeout: 5 * time.Second}
+	for _, path := range []string{"/set", "/sleep"} {
+		res, err := c.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("GET %s: reading body: %v", path, err)
+		}
+		if path == "/sleep" && string(b) != "slept" {
+			t.Errorf("GET %s: body = %q; want %q", path, b, "slept")
+		}
+	}
+}
+
+func TestResponseControllerReadDeadline(t *testing.T) {
+	defer afterTest(t)
+	errc := make(chan error, 1)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		ctl := NewResponseController(w)
+		if err := ctl.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+			errc <- err
+			return
+		}
+		_, err := ioutil.ReadAll(r.Body)
+		errc <- err
+	}))
+	defer ts.Close()
+
+	c, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "POST / HTTP/1.1\r\nHost: foo\r\nContent-Length: 10\r\n\r\nabc")
+	select {
+	case err := <-errc:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Errorf("reading body past the deadline: got error %v; want a timeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read deadline not applied")
+	}
+}
+
+func TestResponseControllerFullDuplex(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		ctl := NewResponseController(wrapWriter{w})
+		if err := ctl.EnableFullDuplex(); err != nil {
+			t.Errorf("EnableFullDuplex: %v", err)
+			return
+		}
+		w.WriteHeader(StatusOK)
+		ctl.Flush()
+		buf := make([]byte, 5)
+		for {
+			if _, err := io.ReadFull(r.Body, buf); err != nil {
+				return
+			}
+			w.Write(buf)
+			ctl.Flush()
+		}
+	}))
+	defer ts.Close()
+
+	c, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(c, "POST / HTTP/1.1\r\nHost: foo\r\nTransfer-Encoding: chunked\r\n\r\n")
+	res, err := ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatalf("response header before the request body: %v", err)
+	}
+	// Each message is echoed before the next is sent.
+	for _, msg := range []string{"ping1", "ping2", "ping3"} {
+		fmt.Fprintf(c, "5\r\n%s\r\n", msg)
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(res.Body, buf); err != nil {
+			t.Fatalf("reading echo of %q: %v", msg, err)
+		}
+		if string(buf) != msg {
+			t.Errorf("echo %q; want %q", buf, msg)
+		}
+	}
+	fmt.Fprintf(c, "0\r\n\r\n")
+	if rest, err := ioutil.ReadAll(res.Body); err != nil || len(rest) != 0 {
+		t.Errorf("rest of body %q, %v", rest, err)
+	}
+}
+
+// A body read concurrently with the response, of a client expecting
+// 100 Continue.
+func TestResponseControllerFullDuplexConcurrent(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		ctl := NewResponseController(w)
+		if err := ctl.EnableFullDuplex(); err != nil {
+			t.Errorf("EnableFullDuplex: %v", err)
+			return
+		}
+		lines := make(chan string)
+		go func() {
+			defer close(lines)
+			br := bufio.NewReader(r.Body)
+			for {
+				line, err := br.ReadString('\n')
+				if err != nil {
+					return
+				}
+				lines <- line
+			}
+		}()
+		w.WriteHeader(StatusOK)
+		ctl.Flush()
+		for line := range lines {
+			io.WriteString(w, "echo "+line)
+			ctl.Flush()
+		}
+	}))
+	defer ts.Close()
+
+	c, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(c, "POST / HTTP/1.1\r\nHost: foo\r\nExpect: 100-continue\r\nTransfer-Encoding: chunked\r\n\r\n")
+	br := bufio.NewReader(c)
+	res, err := ReadResponse(br, nil)
+	if err != nil || res.StatusCode != StatusContinue {
+		t.Fatalf("got %v, %v; want 100 Continue", res, err)
+	}
+	res, err = ReadResponse(br, nil)
+	if err != nil || res.StatusCode != StatusOK {
+		t.Fatalf("got %v, %v; want 200 OK", res, err)
+	}
+	rb := bufio.NewReader(res.Body)
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(c, "6\r\nline%d\n\r\n", i)
+		got, err := rb.ReadString('\n')
+		if want := fmt.Sprintf("echo line%d\n", i); got != want || err != nil {
+			t.Fatalf("got %q, %v; want %q", got, err, want)
+		}
+	}
+	fmt.Fprintf(c, "0\r\n\r\n")
+	if rest, err := ioutil.ReadAll(rb); err != nil || len(rest) != 0 {
+		t.Errorf("rest of body %q, %v", rest, err)
+	}
+}
+
+func TestResponseControllerNotSupported(t *testing.T) {
+	ctl := NewResponseController(httptest.NewRecorder())
+	if err := ctl.Flush(); err != nil {
+		t.Errorf("Flush: %v", err)
+	}
+	if err := ctl.SetWriteDeadline(time.Now()); err != ErrNotSupported {
+		t.Errorf("SetWriteDeadline error = %v; want ErrNotSupported", err)
+	}
+	if _, _, err := NewResponseController(wrapWriter{}).Hijack(); err != ErrNotSupported {
+		t.Errorf("Hijack error = %v; want ErrNotSupported", err)
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Transport retries.
+
+package http
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultMaxRetries is the default value of RetryPolicy's MaxRetries.
+const DefaultMaxRetries = 2
+
+// A RetryPolicy specifies how a Transport retries requests.
+//
+// Only requests that are safe to send again are retried: those with
+// an idempotent method (GET, HEAD, OPTIONS, TRACE, PUT or DELETE) or
+// an Idempotency-Key header, and with no body or a body that GetBody
+// can recreate. They are retried when the connection fails before a
+// response arrives, such as when it's refused or reset, or when the
+// server answers 502 (Bad Gateway) or 503 (Service Unavailable).
+//
+// A RetryPolicy must not be copied after first use.
+type RetryPolicy struct {
+	// MaxRetries is the most times a request is retried.
+	// If zero, DefaultMaxRetries is used.
+	MaxRetries int
+
+	// MinBackoff and MaxBackoff bound the time waited before a
+	// retry, which doubles from MinBackoff with each retry up to
+	// MaxBackoff, and is shortened by a random jitter of up to
+	// half. They default to 50 milliseconds and 2 seconds.
+	// A 503 response's Retry-After header, if longer, sets the
+	// wait instead; if it's longer than MaxBackoff, the request
+	// isn't retried.
+	MinBackoff, MaxBackoff time.Duration
+
+	// Budget, if positive, limits retries to that fraction of
+	// requests, such as 0.1 for one retry per ten requests, so that
+	// retries don't overload a failing server. An allowance of
+	// 10 retries lets the occasional failure of a quiet client be
+	// retried.
+	Budget float64
+
+	mu     sync.Mutex
+	init   bool
+	tokens float64 // retries allowed by Budget
+}
+
+const retryBudgetAllowance = 10
+
+func (p *RetryPolicy) maxRetries() int {
+	if p.MaxRetries == 0 {
+		return DefaultMaxRetries
+	}
+	return p.MaxRetries
+}
+
+func (p *RetryPolicy) maxBackoff() time.Duration {
+	if p.MaxBackoff == 0 {
+		return 2 * time.Second
+	}
+	return p.MaxBackoff
+}
+
+// backoff returns the time to wait before the retry numbered retry,
+// from 0, after res, the response if any. It reports false if res
+// asks to wait longer than MaxBackoff.
+func (p *RetryPolicy) backoff(retry int, res *Response) (time.Duration, bool) {
+	d, max := p.MinBackoff, p.maxBackoff()
+	if d == 0 {
+		d = 50 * time.Millisecond
+	}
+	for i := 0; i < retry && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	d -= time.Duration(rand.Int63n(int64(d)/2 + 1))
+	if res != nil && res.StatusCode == StatusServiceUnavailable {
+		if secs, err := strconv.Atoi(res.Header.get("Retry-After")); err == nil && secs >= 0 {
+			ra := time.Duration(secs) * time.Second
+			if ra > max {
+				return 0, false
+			}
+			if ra > d {
+				d = ra
+			}
+		}
+	}
+	return d, true
+}
+
+// request records a request under the Budget.
+func (p *RetryPolicy) request() {
+	if p.Budget <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.init {
+		p.init = true
+		p.tokens = retryBudgetAllowance
+	}
+	if p.tokens += p.Budget; p.tokens > retryBudgetAllowance {
+		p.tokens = retryBudgetAllowance
+	}
+}
+
+// allowRetry reports whether the Budget allows a retry, taking it.
+func (p *RetryPolicy) allowRetry() bool {
+	if p.Budget <= 0 {
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tokens < 1 {
+		return false
+	}
+	p.tokens--
+	return true
+}
+
+// retryable reports whether req is safe to send again.
+func retryable(req *Request) bool {
+	switch valueOrDefault(req.Method, "GET") {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+	default:
+		if req.Header.get("Idempotency-Key") == "" {
+			return false
+		}
+	}
+	return req.Body == nil || req.GetBody != nil
+}
+
+// retryableError reports whether err, from a round trip, is a
+// failure of the connection that a retry may not meet.
+func retryableError(err error) bool {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return false
+	}
+	return err == io.EOF || err == io.ErrUnexpectedEOF ||
+		err == errBrokenConn || err == errClosedBeforeResponse ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+func retryableStatus(code int) bool {
+	return code == StatusBadGateway || code == StatusServiceUnavailable
+}
+
+var errRetryCanceled = errors.New("net/http: request canceled while waiting to retry")
+
+// A retryState is the state of a request being retried, for
+// CancelRequest.
+type retryState struct {
+	cur      *Request      // attempt in flight
+	canceled chan struct{} // closed by CancelRequest
+}
+
+// cancelLocked cancels the retries. t.reqMu must be held.
+func (rs *retryState) cancelLocked() {
+	select {
+	case <-rs.canceled:
+	default:
+		close(rs.canceled)
+	}
+}
+
+func (t *Transport) retryRoundTrip(req *Request) (*Response, error) {
+	p := t.Retry
+	p.request()
+	if !retryable(req) {
+		return t.roundTrip(req)
+	}
+	rs := &retryState{cur: req, canceled: make(chan struct{})}
+	t.reqMu.Lock()
+	if t.retrying == nil {
+		t.retrying = make(map[*Request]*retryState)
+	}
+	t.retrying[req] = rs
+	t.reqMu.Unlock()
+	defer func() {
+		t.reqMu.Lock()
+		delete(t.retrying, req)
+		t.reqMu.Unlock()
+	}()
+
+	attempt := req
+	for retry := 0; ; retry++ {
+		res, err := t.roundTrip(attempt)
+		if retry == p.maxRetries() {
+			return res, err
+		}
+		if err != nil && !retryableError(err) || err == nil && !retryableStatus(res.StatusCode) {
+			return res, err
+		}
+		wait, ok := p.backoff(retry, res)
+		if !ok || !p.allowRetry() {
+			return res, err
+		}
+		if res != nil {
+			// Drain a little, for the connection to be reused.
+			io.CopyN(ioutil.Discard, res.Body, 4<<10)
+			res.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-rs.canceled:
+			timer.Stop()
+			return nil, errRetryCanceled
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+
+		if req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attempt = new(Request)
+			*attempt = *req
+			attempt.Body = body
+			t.reqMu.Lock()
+			rs.cur = attempt
+			t.reqMu.Unlock()
+		}
+	}
+}
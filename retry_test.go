@@ -0,0 +1,136 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"io"
+	"io/ioutil"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransportRetry(t *testing.T) {
+	defer afterTest(t)
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		n := hits[r.URL.Path]
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/reset":
+			if n == 1 {
+				c, _, _ := w.(Hijacker).Hijack()
+				c.Close()
+				return
+			}
+		case "/later":
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(StatusServiceUnavailable)
+			return
+		default:
+			if n < 3 {
+				w.WriteHeader(StatusBadGateway)
+				return
+			}
+		}
+		io.Copy(w, r.Body)
+	}))
+	defer ts.Close()
+	tr := &Transport{Retry: &RetryPolicy{MinBackoff: time.Millisecond}}
+	defer tr.CloseIdleConnections()
+
+	tests := []struct {
+		method, path string
+		body         string
+		getBody      bool
+		idempotent   bool
+		status       int
+		hits         int
+	}{
+		{"GET", "/get", "", false, false, 200, 3},
+		{"GET", "/reset", "", false, false, 200, 2},
+		{"GET", "/later", "", false, false, 503, 1},
+		{"POST", "/post", "x", true, false, 502, 1},
+		{"POST", "/post-key", "x", true, true, 200, 3},
+		{"PUT", "/put", "hello", true, false, 200, 3},
+		{"PUT", "/put-nogetbody", "hello", false, false, 502, 1},
+	}
+	for _, tt := range tests {
+		var body io.Reader
+		if tt.body != "" {
+			body = strings.NewReader(tt.body)
+		}
+		req, _ := NewRequest(tt.method, ts.URL+tt.path, body)
+		if !tt.getBody {
+			req.GetBody = nil
+		}
+		if tt.idempotent {
+			req.Header.Set("Idempotency-Key", "k1")
+		}
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.method, tt.path, err)
+			continue
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		mu.Lock()
+		n := hits[tt.path]
+		mu.Unlock()
+		if res.StatusCode != tt.status || n != tt.hits {
+			t.Errorf("%s %s: status %d after %d requests; want %d after %d", tt.method, tt.path, res.StatusCode, n, tt.status, tt.hits)
+		}
+		if res.StatusCode == 200 && string(b) != tt.body {
+			t.Errorf("%s %s: server got body %q; want %q", tt.method, tt.path, b, tt.body)
+		}
+	}
+}
+
+func TestTransportRetryBudget(t *testing.T) {
+	defer afterTest(t)
+	var mu sync.Mutex
+	hits := 0
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		w.WriteHeader(StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	tr := &Transport{Retry: &RetryPolicy{MaxRetries: 1, MinBackoff: time.Millisecond, Budget: 0.01}}
+	defer tr.CloseIdleConnections()
+	for i := 0; i < 15; i++ {
+		req, _ := NewRequest("GET", ts.URL, nil)
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	// 15 requests and the 10 retries of the allowance.
+	if hits != 25 {
+		t.Errorf("server hit %d times; want 25", hits)
+	}
+}
+
+func TestTransportRetryCanceled(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.WriteHeader(StatusBadGateway)
+	}))
+	defer ts.Close()
+	tr := &Transport{Retry: &RetryPolicy{MinBackoff: time.Hour, MaxBackoff: time.Hour}}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr, Timeout: 50 * time.Millisecond}
+	if _, err := c.Get(ts.URL); err == nil || !strings.Contains(err.Error(), "Client.Timeout") {
+		t.Errorf("error %v; want a Client.Timeout while waiting to retry", err)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Reuse of the Requests and ResponseWriters of finished requests.
+
+package http
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// A reuseState tells whether a request and its response, which holds
+// the state, may be reused once the handler returns.
+type reuseState struct {
+	srv      *Server
+	retained bool  // by Request.Retain
+	released int32 // under ReuseCheck, once the handler returned; accessed atomically
+}
+
+// errHandlerReturned is returned by the ResponseWriter of a Server with
+// a ReuseCheck when it's written after its handler returned.
+var errHandlerReturned = errors.New("http: ResponseWriter used after its handler returned")
+
+// maxPooledHeaderKeys is the number of keys beyond which the Header of
+// a reused Request isn't kept for the next.
+const maxPooledHeaderKeys = 64
+
+var requestPool sync.Pool
+
+// newRequest returns a Request for readRequest to fill in: empty, but
+// possibly with an empty Header.
+func newRequest() *Request {
+	if v := requestPool.Get(); v != nil {
+		return v.(*Request)
+	}
+	return new(Request)
+}
+
+// putRequest keeps r, whose handler returned, for a later request.
+func putRequest(r *Request) {
+	h := r.Header
+	if len(h) > maxPooledHeaderKeys {
+		h = nil
+	}
+	for k := range h {
+		delete(h, k)
+	}
+	*r = Request{Header: h}
+	requestPool.Put(r)
+}
+
+// Retain keeps r, and the ResponseWriter given to the handler with it,
+// from being reused by a Server for later requests, so that they may
+// be used after the handler returns, as from a goroutine it started.
+// It must be called before the handler returns, on r or a copy of it
+// made by WithContext. See Server.ReuseRequests.
+func (r *Request) Retain() {
+	if r.reuse != nil {
+		r.reuse.retained = true
+	}
+}
+
+// checkReuse reports to the Server's ReuseCheck each use of r after
+// its handler returned, as the given method.
+func (r *Request) checkReuse(method string) {
+	if s := r.reuse; s != nil && atomic.LoadInt32(&s.released) != 0 {
+		s.srv.ReuseCheck(r, method)
+	}
+}
+
+// checkReuse reports to the Server's ReuseCheck each use of w after
+// its handler returned, as the given method, and whether there was one.
+func (w *response) checkReuse(method string) bool {
+	if atomic.LoadInt32(&w.reuse.released) == 0 {
+		return false
+	}
+	w.reuse.srv.ReuseCheck(w.req, method)
+	return true
+}
+
+// release gives up w and its request once the handler returned and
+// the response was sent on a connection kept alive: under
+// ReuseRequests they are reused, unless retained by the handler, and
+// under ReuseCheck marked so that later uses are reported.
+func (c *conn) release(w *response) {
+	if w.reuse.retained {
+		return
+	}
+	if c.server.ReuseCheck != nil {
+		atomic.StoreInt32(&w.reuse.released, 1)
+		return
+	}
+	if c.server.ReuseRequests {
+		putRequest(w.req)
+		putResponse(w)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// keepAliveGets sends GET requests for paths on one connection to ts,
+// each with the headers in hdrs, returning the bodies of the responses.
+func keepAliveGets(t *testing.T, ts *httptest.Server, paths []string, hdrs []string) []string {
+	c, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+	var bodies []string
+	for i, path := range paths {
+		io.WriteString(c, "GET "+path+" HTTP/1.1\r\nHost: x\r\n"+hdrs[i]+"\r\n")
+		res, err := ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		bodies = append(bodies, string(body))
+	}
+	return bodies
+}
+
+func TestServerReuseRequests(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, r.URL.Path+" "+r.Header.Get("X-A")+" "+r.Header.Get("X-B"))
+		if len(r.Form) != 0 {
+			t.Errorf("%s: Form = %v; want none", r.URL.Path, r.Form)
+		}
+		if r.URL.Path == "/1" {
+			r.ParseForm()
+		}
+	}))
+	ts.Config.ReuseRequests = true
+	ts.Start()
+	defer ts.Close()
+
+	got := keepAliveGets(t, ts,
+		[]string{"/1?q=1", "/2", "/3"},
+		[]string{"X-A: a\r\n", "X-B: b\r\n", ""})
+	want := []string{"/1 a ", "/2  b", "/3  "}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("responses = %q; want %q", got, want)
+	}
+}
+
+// Without ReuseRequests, ResponseWriters held past their handler are
+// left alone.
+func TestServerNoReuseByDefault(t *testing.T) {
+	defer afterTest(t)
+	var w1 ResponseWriter
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/1" {
+			w.Header().Set("X-Kept", "1")
+			w1 = w
+			return
+		}
+		if w == w1 || w1.Header().Get("X-Kept") != "1" {
+			t.Error("ResponseWriter of the first request reused")
+		}
+	}))
+	defer ts.Close()
+	keepAliveGets(t, ts, []string{"/1", "/2"}, []string{"", ""})
+}
+
+func TestServerReuseCheck(t *testing.T) {
+	defer afterTest(t)
+	var (
+		uses     []string
+		retained *Request
+		w1       ResponseWriter
+		r1       *Request
+	)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		switch r.URL.Path {
+		case "/keep":
+			w1, r1 = w, r
+		case "/retain":
+			r.Retain()
+			retained = r
+		case "/use":
+			// Uses of the earlier requests, after their
+			// handlers returned.
+			w1.Header().Set("X", "1")
+			w1.Write([]byte("late"))
+			r1.Context()
+			r1.Cookie("c")
+			retained.Context()
+		}
+	}))
+	ts.Config.ReuseRequests = true
+	ts.Config.ReuseCheck = func(r *Request, method string) {
+		uses = append(uses, r.URL.Path+" "+method)
+	}
+	ts.Start()
+	defer ts.Close()
+
+	keepAliveGets(t, ts, []string{"/keep", "/retain", "/use"}, []string{"", "", ""})
+	want := []string{
+		"/keep ResponseWriter.Header",
+		"/keep ResponseWriter.Write",
+		"/keep Request.Context",
+		"/keep Request.Cookie",
+	}
+	if !reflect.DeepEqual(uses, want) {
+		t.Errorf("uses reported:\n%s\nwant:\n%s", strings.Join(uses, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func BenchmarkServerFakeConnReuseRequests(b *testing.B) {
+	b.ReportAllocs()
+
+	req := reqBytes(`GET / HTTP/1.1
+Host: golang.org
+User-Agent: Mozilla/5.0
+Accept: text/html
+Accept-Encoding: gzip
+Cookie: session=1
+`)
+	res := []byte("Hello world!\n")
+
+	conn := &rwTestConn{
+		Reader: &repeatReader{content: req, count: b.N},
+		Writer: ioutil.Discard,
+		closec: make(chan bool, 1),
+	}
+	handled := 0
+	handler := HandlerFunc(func(rw ResponseWriter, r *Request) {
+		handled++
+		rw.Write(res)
+	})
+	ln := &oneConnListener{conn: conn}
+	srv := &Server{Handler: handler, ReuseRequests: true}
+	go srv.Serve(ln)
+	<-conn.closec
+	if b.N != handled {
+		b.Errorf("b.N=%d but handled %d", b.N, handled)
+	}
+}
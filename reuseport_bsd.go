@@ -0,0 +1,14 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package http
+
+import "syscall"
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return setSockoptInt(c, syscall.SOL_SOCKET, syscall.SO_REUSEPORT, 1)
+}
@@ -0,0 +1,18 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+// +build linux,!mips,!mipsle,!mips64,!mips64le
+
+package http
+
+import "syscall"
+
+// soReusePort is SO_REUSEPORT, which package syscall lacks on some
+// Linux architectures.
+const soReusePort = 0xf
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return setSockoptInt(c, syscall.SOL_SOCKET, soReusePort, 1)
+}
@@ -0,0 +1,22 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && (!linux || mips || mipsle || mips64 || mips64le)
+// +build !darwin
+// +build !dragonfly
+// +build !freebsd
+// +build !netbsd
+// +build !openbsd
+// +build !linux mips mipsle mips64 mips64le
+
+package http
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return errors.New("http: SO_REUSEPORT is not supported on this platform")
+}
@@ -0,0 +1,176 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Security response headers.
+
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var cspNonceKey = &contextKey{"csp-nonce"}
+
+// A SecurityPolicy says which security headers a
+// SecurityHeadersHandler sets on responses. Empty fields set none.
+type SecurityPolicy struct {
+	// HSTSMaxAge, if positive, sets Strict-Transport-Security on
+	// responses to clients that reached the server over HTTPS,
+	// for that long, with includeSubDomains and preload if
+	// HSTSIncludeSubdomains and HSTSPreload are set.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+
+	// NoSniff sets "X-Content-Type-Options: nosniff".
+	NoSniff bool
+
+	// FrameOptions, "DENY" or "SAMEORIGIN", sets X-Frame-Options,
+	// and the frame-ancestors directive of Content-Security-Policy
+	// to match, unless ContentSecurityPolicy has its own.
+	FrameOptions string
+
+	// ReferrerPolicy sets Referrer-Policy, such as
+	// "strict-origin-when-cross-origin".
+	ReferrerPolicy string
+
+	// ContentSecurityPolicy sets Content-Security-Policy, with each
+	// "{nonce}" replaced by a random nonce, new for each request,
+	// that CSPNonce returns for the handler to put in its script and
+	// style elements.
+	ContentSecurityPolicy string
+
+	// CSPReportOnly sends the policy as
+	// Content-Security-Policy-Report-Only instead, so that browsers
+	// report violations without blocking.
+	CSPReportOnly bool
+}
+
+// A SecurityHeadersHandler sets the headers of a SecurityPolicy on the
+// responses of the handler it wraps, before calling it, so that the
+// handler may still change them. Its fields must not be changed once
+// it is in use.
+type SecurityHeadersHandler struct {
+	// Handler is the handler wrapped.
+	Handler Handler
+
+	// Policy is the policy of requests that no Routes match.
+	Policy *SecurityPolicy
+
+	// Routes optionally overrides Policy for some paths: the policy
+	// of the longest key matching the request's path applies, a key
+	// ending in a slash matching the subtree it names, as a ServeMux
+	// pattern does. A nil policy sets no headers.
+	Routes map[string]*SecurityPolicy
+
+	// TrustedProxies lists the networks of the peers trusted to
+	// report that the client reached the server over HTTPS, as for
+	// CSRFHandler.
+	TrustedProxies []*net.IPNet
+
+	once   sync.Once
+	routes *routeTree // of Routes
+}
+
+// CSPNonce returns the nonce of r's Content-Security-Policy from a
+// SecurityHeadersHandler, or "" if it has none.
+func CSPNonce(r *Request) string {
+	n, _ := r.Context().Value(cspNonceKey).(string)
+	return n
+}
+
+// policy returns the policy of path.
+func (h *SecurityHeadersHandler) policy(path string) *SecurityPolicy {
+	h.once.Do(func() {
+		patterns := make([]string, 0, len(h.Routes))
+		for pattern := range h.Routes {
+			patterns = append(patterns, pattern)
+		}
+		h.routes = newRouteTree(patterns)
+	})
+	if pattern, ok := h.routes.match(path); ok {
+		return h.Routes[pattern]
+	}
+	return h.Policy
+}
+
+func (h *SecurityHeadersHandler) ServeHTTP(w ResponseWriter, r *Request) {
+	p := h.policy(r.URL.Path)
+	if p == nil {
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+	hdr := w.Header()
+	if p.HSTSMaxAge > 0 {
+		if scheme, _ := clientOrigin(r, h.TrustedProxies); scheme == "https" {
+			v := "max-age=" + strconv.FormatInt(int64(p.HSTSMaxAge/time.Second), 10)
+			if p.HSTSIncludeSubdomains {
+				v += "; includeSubDomains"
+			}
+			if p.HSTSPreload {
+				v += "; preload"
+			}
+			hdr.Set("Strict-Transport-Security", v)
+		}
+	}
+	if p.NoSniff {
+		hdr.Set("X-Content-Type-Options", "nosniff")
+	}
+	if p.FrameOptions != "" {
+		hdr.Set("X-Frame-Options", p.FrameOptions)
+	}
+	if p.ReferrerPolicy != "" {
+		hdr.Set("Referrer-Policy", p.ReferrerPolicy)
+	}
+	if csp := p.csp(); csp != "" {
+		if strings.Contains(csp, "{nonce}") {
+			nonce := newCSPNonce()
+			csp = strings.Replace(csp, "{nonce}", nonce, -1)
+			r = r.WithContext(context.WithValue(r.Context(), cspNonceKey, nonce))
+		}
+		key := "Content-Security-Policy"
+		if p.CSPReportOnly {
+			key += "-Report-Only"
+		}
+		hdr.Set(key, csp)
+	}
+	h.Handler.ServeHTTP(w, r)
+}
+
+// csp returns the Content-Security-Policy template of p, with a
+// frame-ancestors directive for FrameOptions if it has none.
+func (p *SecurityPolicy) csp() string {
+	csp := p.ContentSecurityPolicy
+	var ancestors string
+	switch strings.ToUpper(p.FrameOptions) {
+	case "DENY":
+		ancestors = "frame-ancestors 'none'"
+	case "SAMEORIGIN":
+		ancestors = "frame-ancestors 'self'"
+	default:
+		return csp
+	}
+	if strings.Contains(csp, "frame-ancestors") {
+		return csp
+	}
+	if csp = strings.TrimRight(strings.TrimSpace(csp), ";"); csp == "" {
+		return ancestors
+	}
+	return csp + "; " + ancestors
+}
+
+func newCSPNonce() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("http: can't generate CSP nonce: " + err.Error())
+	}
+	return base64.StdEncoding.EncodeToString(b[:])
+}
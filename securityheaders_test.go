@@ -0,0 +1,90 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"crypto/tls"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecurityHeadersHandler(t *testing.T) {
+	var nonce string
+	h := &SecurityHeadersHandler{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			nonce = CSPNonce(r)
+			if r.URL.Path == "/embed/custom" {
+				w.Header().Del("X-Frame-Options")
+			}
+		}),
+		Policy: &SecurityPolicy{
+			HSTSMaxAge:            365 * 24 * time.Hour,
+			HSTSIncludeSubdomains: true,
+			NoSniff:               true,
+			FrameOptions:          "DENY",
+			ReferrerPolicy:        "no-referrer",
+			ContentSecurityPolicy: "default-src 'self'; script-src 'nonce-{nonce}'",
+		},
+		Routes: map[string]*SecurityPolicy{
+			"/embed/":     {FrameOptions: "SAMEORIGIN", ContentSecurityPolicy: "default-src *", CSPReportOnly: true},
+			"/raw":        nil,
+			"/embed/raw/": nil,
+		},
+	}
+	serve := func(path string, tlsOn bool) Header {
+		req, _ := NewRequest("GET", "http://example.com"+path, nil)
+		if tlsOn {
+			req.TLS = &tls.ConnectionState{}
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Header()
+	}
+
+	hdr := serve("/", true)
+	want := map[string]string{
+		"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Referrer-Policy":           "no-referrer",
+		"Content-Security-Policy":   "default-src 'self'; script-src 'nonce-" + nonce + "'; frame-ancestors 'none'",
+	}
+	if nonce == "" {
+		t.Error("CSPNonce is empty")
+	}
+	for k, v := range want {
+		if g := hdr.Get(k); g != v {
+			t.Errorf("%s = %q; want %q", k, g, v)
+		}
+	}
+	first := nonce
+	if hdr := serve("/", false); hdr.Get("Strict-Transport-Security") != "" || nonce == first {
+		t.Errorf("over HTTP: Strict-Transport-Security %q, nonce %q after %q; want none and a new nonce",
+			hdr.Get("Strict-Transport-Security"), nonce, first)
+	}
+
+	hdr = serve("/embed/page", true)
+	if g, e := hdr.Get("Content-Security-Policy-Report-Only"), "default-src *; frame-ancestors 'self'"; g != e {
+		t.Errorf("route CSP = %q; want %q", g, e)
+	}
+	if hdr.Get("X-Frame-Options") != "SAMEORIGIN" || hdr.Get("X-Content-Type-Options") != "" || hdr.Get("Content-Security-Policy") != "" {
+		t.Errorf("route headers = %v; want only the route's policy", hdr)
+	}
+	if hdr := serve("/embed/custom", true); hdr.Get("X-Frame-Options") != "" {
+		t.Errorf("handler couldn't drop X-Frame-Options: %v", hdr)
+	}
+	if hdr := serve("/raw", true); len(hdr) != 0 {
+		t.Errorf("route with nil policy: headers %v; want none", hdr)
+	}
+	if hdr := serve("/raw/x", true); !strings.Contains(hdr.Get("Content-Security-Policy"), "nonce-") {
+		t.Errorf("/raw/x: headers %v; want the default policy", hdr)
+	}
+	if hdr := serve("/embed/raw/x", true); len(hdr) != 0 {
+		t.Errorf("/embed/raw/x: headers %v; want those of the longest route, none", hdr)
+	}
+}
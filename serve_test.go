@@ -10,6 +10,7 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
@@ -22,6 +23,7 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"strconv"
@@ -912,6 +914,337 @@ func TestTLSServer(t *testing.T) {
 	})
 }
 
+// listenAndServeTLSTest runs srv.ListenAndServeTLS on a free loopback
+// port and returns the address once it is accepting connections. The
+// server is never stopped.
+func listenAndServeTLSTest(t *testing.T, srv *Server, certFile, keyFile string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Addr = ln.Addr().String()
+	ln.Close()
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServeTLS(certFile, keyFile) }()
+	for i := 0; i < 100; i++ {
+		select {
+		case err := <-errc:
+			t.Fatalf("ListenAndServeTLS: %v", err)
+		default:
+		}
+		if c, err := net.Dial("tcp", srv.Addr); err == nil {
+			c.Close()
+			return srv.Addr
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timeout waiting for ListenAndServeTLS")
+	return ""
+}
+
+func TestServerReloadTLSCertificates(t *testing.T) {
+	defer afterTest(t)
+	ca := newTestCA(t)
+	dir, err := ioutil.TempDir("", "reloadtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_, c, k := ca.issue(t, "example.com") // serial 2
+	writeFile(t, certFile, c)
+	writeFile(t, keyFile, k)
+
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {})}
+	addr := listenAndServeTLSTest(t, srv, certFile, keyFile)
+
+	// newClient returns a client that records the serial number of
+	// each certificate it is presented.
+	newClient := func(serials chan<- int64) (*Client, *Transport) {
+		tr := &Transport{TLSClientConfig: &tls.Config{
+			ServerName: "example.com",
+			RootCAs:    ca.pool(),
+			VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+				leaf, err := x509.ParseCertificate(raw[0])
+				if err != nil {
+					return err
+				}
+				serials <- leaf.SerialNumber.Int64()
+				return nil
+			},
+		}}
+		return &Client{Transport: tr}, tr
+	}
+	get := func(c *Client) {
+		res, err := c.Get("https://" + addr + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	oldSerials := make(chan int64, 10)
+	oldClient, oldTr := newClient(oldSerials)
+	defer oldTr.CloseIdleConnections()
+	get(oldClient)
+	if got := <-oldSerials; got != 2 {
+		t.Fatalf("first certificate serial = %d; want 2", got)
+	}
+
+	_, c, k = ca.issue(t, "example.com") // serial 3
+	writeFile(t, certFile, c)
+	writeFile(t, keyFile, k)
+	if err := srv.ReloadTLSCertificates(); err != nil {
+		t.Fatal(err)
+	}
+
+	newSerials := make(chan int64, 10)
+	newClient2, newTr := newClient(newSerials)
+	defer newTr.CloseIdleConnections()
+	get(newClient2)
+	if got := <-newSerials; got != 3 {
+		t.Errorf("after reload, certificate serial = %d; want 3", got)
+	}
+
+	// The established keep-alive connection survives the reload.
+	get(oldClient)
+	select {
+	case got := <-oldSerials:
+		t.Errorf("existing client did a new handshake (serial %d); want connection reused", got)
+	default:
+	}
+}
+
+// A GetCertificate of the TLSConfig is asked before the certificate
+// files.
+func TestServerListenAndServeTLSGetCertificate(t *testing.T) {
+	defer afterTest(t)
+	ca := newTestCA(t)
+	dir, err := ioutil.TempDir("", "getcert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_, c, k := ca.issue(t, "example.com") // serial 2
+	writeFile(t, certFile, c)
+	writeFile(t, keyFile, k)
+	other, _, _ := ca.issue(t, "other.example") // serial 3
+
+	srv := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {}),
+		TLSConfig: &tls.Config{GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName == "other.example" {
+				return &other, nil
+			}
+			return nil, nil
+		}},
+	}
+	addr := listenAndServeTLSTest(t, srv, certFile, keyFile)
+	for name, want := range map[string]int64{"example.com": 2, "other.example": 3} {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: name, RootCAs: ca.pool()})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got := conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(); got != want {
+			t.Errorf("%s: certificate serial = %d; want %d", name, got, want)
+		}
+		conn.Close()
+	}
+}
+
+func TestServerClientCerts(t *testing.T) {
+	defer afterTest(t)
+	ca := newTestCA(t)
+	dir, err := ioutil.TempDir("", "mtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_, c, k := ca.issue(t, "example.com")
+	writeFile(t, certFile, c)
+	writeFile(t, keyFile, k)
+
+	var policyAddr atomic.Value
+	srv := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			if len(r.TLS.VerifiedChains) == 0 {
+				t.Error("no verified client chain in Request.TLS")
+				return
+			}
+			io.WriteString(w, r.TLS.VerifiedChains[0][0].Subject.CommonName)
+		}),
+		ClientCAs: ca.pool(),
+		ClientCertPolicy: func(state *tls.ConnectionState, remoteAddr string) error {
+			policyAddr.Store(remoteAddr)
+			if len(state.VerifiedChains) > 0 && state.VerifiedChains[0][0].Subject.CommonName == "mallory" {
+				return errors.New("mallory is banned")
+			}
+			return nil
+		},
+	}
+	addr := listenAndServeTLSTest(t, srv, certFile, keyFile)
+
+	get := func(clientCerts ...tls.Certificate) (string, error) {
+		tr := &Transport{TLSClientConfig: &tls.Config{
+			ServerName:   "example.com",
+			RootCAs:      ca.pool(),
+			Certificates: clientCerts,
+		}}
+		defer tr.CloseIdleConnections()
+		res, err := (&Client{Transport: tr}).Get("https://" + addr + "/")
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		b, err := ioutil.ReadAll(res.Body)
+		return string(b), err
+	}
+
+	alice, _, _ := ca.issue(t, "alice")
+	if got, err := get(alice); err != nil || got != "alice" {
+		t.Errorf("with client certificate: got %q, %v; want alice", got, err)
+	}
+	if addr, _ := policyAddr.Load().(string); !strings.HasPrefix(addr, "127.0.0.1:") {
+		t.Errorf("ClientCertPolicy got remote address %q", addr)
+	}
+	if _, err := get(); err == nil {
+		t.Error("request without client certificate succeeded")
+	}
+	mallory, _, _ := ca.issue(t, "mallory")
+	if _, err := get(mallory); err == nil {
+		t.Error("request rejected by ClientCertPolicy succeeded")
+	}
+}
+
+// remoteAddrConn reports a different remote address, as a PROXY
+// protocol listener would, for the connection it wraps.
+type remoteAddrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c remoteAddrConn) RemoteAddr() net.Addr     { return c.addr }
+func (c remoteAddrConn) UnderlyingConn() net.Conn { return c.Conn }
+
+type remoteAddrListener struct {
+	net.Listener
+	addr net.Addr
+}
+
+func (l remoteAddrListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return remoteAddrConn{c, l.addr}, nil
+}
+
+func TestServerWrappedTLSConn(t *testing.T) {
+	defer afterTest(t)
+	ca := newTestCA(t)
+	serverCert, _, _ := ca.issue(t, "example.com")
+	clientCert, _, _ := ca.issue(t, "client")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	realIP := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 4242}
+	tlsLn := tls.NewListener(ln, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		NextProtos:   []string{"http/1.1"},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool(),
+	})
+	type result struct {
+		remoteAddr string
+		state      *tls.ConnectionState
+	}
+	got := make(chan result, 1)
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		got <- result{r.RemoteAddr, r.TLS}
+	})}
+	go srv.Serve(remoteAddrListener{tlsLn, realIP})
+
+	tr := &Transport{TLSClientConfig: &tls.Config{
+		ServerName:   "example.com",
+		RootCAs:      ca.pool(),
+		Certificates: []tls.Certificate{clientCert},
+		NextProtos:   []string{"http/1.1"},
+		MaxVersion:   tls.VersionTLS12,
+	}}
+	defer tr.CloseIdleConnections()
+	res, err := (&Client{Transport: tr}).Get("https://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	r := <-got
+	if r.remoteAddr != realIP.String() {
+		t.Errorf("RemoteAddr = %q; want %q", r.remoteAddr, realIP)
+	}
+	st := r.state
+	if st == nil {
+		t.Fatal("Request.TLS is nil for wrapped TLS connection")
+	}
+	if !st.HandshakeComplete || st.Version != tls.VersionTLS12 || st.CipherSuite == 0 {
+		t.Errorf("handshake state: complete=%v version=%x cipher=%x", st.HandshakeComplete, st.Version, st.CipherSuite)
+	}
+	if st.ServerName != "example.com" {
+		t.Errorf("ServerName = %q; want example.com", st.ServerName)
+	}
+	if st.NegotiatedProtocol != "http/1.1" {
+		t.Errorf("NegotiatedProtocol = %q; want http/1.1", st.NegotiatedProtocol)
+	}
+	if len(st.PeerCertificates) != 1 || st.PeerCertificates[0].Subject.CommonName != "client" {
+		t.Errorf("PeerCertificates = %v; want the client certificate", st.PeerCertificates)
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	defer afterTest(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {})}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	res, err := Get("http://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if err := srv.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	select {
+	case err := <-errc:
+		if err != ErrServerClosed {
+			t.Errorf("Serve = %v; want ErrServerClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after Close")
+	}
+
+	ln2, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Serve(ln2); err != ErrServerClosed {
+		t.Errorf("Serve after Close = %v; want ErrServerClosed", err)
+	}
+	if _, err := ln2.Accept(); err == nil {
+		t.Error("Serve after Close left the listener open")
+	}
+}
+
 type serverExpectTest struct {
 	contentLength    int    // of request body
 	expectation      string // e.g. "100-continue"
@@ -1902,6 +2235,95 @@ func TestAcceptMaxFds(t *testing.T) {
 	}
 }
 
+func TestAcceptBackoff(t *testing.T) {
+	emfile := &net.OpError{Op: "accept", Err: syscall.EMFILE}
+	ln := &errorListener{[]error{emfile, emfile, emfile}}
+	var waits []int
+	var reported []time.Duration
+	srv := &Server{
+		AcceptBackoff: func(n int) time.Duration {
+			waits = append(waits, n)
+			return time.Duration(n) * time.Microsecond
+		},
+		OnAcceptError: func(err error, n int, delay time.Duration) {
+			if err != emfile {
+				t.Errorf("OnAcceptError got %v; want %v", err, emfile)
+			}
+			reported = append(reported, delay)
+		},
+	}
+	if err := srv.Serve(ln); err != io.EOF {
+		t.Errorf("got error %v, want EOF", err)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(waits, want) {
+		t.Errorf("AcceptBackoff called with %v; want %v", waits, want)
+	}
+	if want := []time.Duration{1e3, 2e3, 3e3}; !reflect.DeepEqual(reported, want) {
+		t.Errorf("OnAcceptError reported delays %v; want %v", reported, want)
+	}
+
+	srv = &Server{
+		MaxAcceptErrors: 2,
+		AcceptBackoff:   func(int) time.Duration { return 0 },
+		OnAcceptError:   func(error, int, time.Duration) {},
+	}
+	ln = &errorListener{[]error{emfile, emfile, emfile}}
+	if err := srv.Serve(ln); err != emfile {
+		t.Errorf("with MaxAcceptErrors: got error %v, want %v", err, emfile)
+	}
+	if len(ln.errs) != 1 {
+		t.Errorf("Accept called %d times; want 2", 3-len(ln.errs))
+	}
+}
+
+// countingListener counts the goroutines blocked in its Accept.
+type countingListener struct {
+	net.Listener
+	waiting int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	atomic.AddInt32(&l.waiting, 1)
+	defer atomic.AddInt32(&l.waiting, -1)
+	return l.Listener.Accept()
+}
+
+func TestServerAcceptors(t *testing.T) {
+	defer afterTest(t)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := &countingListener{Listener: inner}
+	srv := &Server{
+		Acceptors: 4,
+		Handler:   HandlerFunc(func(w ResponseWriter, r *Request) {}),
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&ln.waiting) != 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines accepting; want 4", atomic.LoadInt32(&ln.waiting))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		res, err := Get("http://" + inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	srv.Close()
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("Serve = %v; want ErrServerClosed", err)
+	}
+	if n := atomic.LoadInt32(&ln.waiting); n != 0 {
+		t.Errorf("%d goroutines still accepting after Serve returned", n)
+	}
+}
+
 func TestWriteAfterHijack(t *testing.T) {
 	req := reqBytes("GET / HTTP/1.1\nHost: golang.org")
 	var buf bytes.Buffer
@@ -2065,6 +2487,47 @@ func TestNoContentTypeOnNotModified(t *testing.T) {
 	}
 }
 
+func TestServerBufferSizes(t *testing.T) {
+	defer afterTest(t)
+	handler := HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/header" {
+			w.Header().Set("X-Once", "1")
+			w.WriteHeader(StatusAccepted)
+		}
+		n, _ := strconv.Atoi(r.FormValue("n"))
+		w.Write(bytes.Repeat([]byte("x"), n))
+	})
+	for _, size := range []int{0, 256} {
+		ts := httptest.NewUnstartedServer(handler)
+		ts.Config.ConnBufferSize = size * 4
+		ts.Config.ResponseBufferSize = size
+		ts.Start()
+		// The paths alternate on one connection, so that pooled
+		// buffers carry nothing over from the last response.
+		for i, n := range []int{100, 1000, 100, 1000} {
+			path := []string{"/header", "/"}[i%2]
+			res, err := Get(fmt.Sprintf("%s%s?n=%d", ts.URL, path, n))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			chunked := len(res.TransferEncoding) > 0
+			if wantChunked := size != 0 && n > size; chunked != wantChunked || len(body) != n {
+				t.Errorf("buffer size %d, %d bytes: chunked = %v, read %d bytes", size, n, chunked, len(body))
+			}
+			wantCode, wantOnce := 200, ""
+			if path == "/header" {
+				wantCode, wantOnce = StatusAccepted, "1"
+			}
+			if res.StatusCode != wantCode || res.Header.Get("X-Once") != wantOnce {
+				t.Errorf("request %d to %s: status %d, X-Once %q", i, path, res.StatusCode, res.Header.Get("X-Once"))
+			}
+		}
+		ts.Close()
+	}
+}
+
 func TestResponseWriterWriteStringAllocs(t *testing.T) {
 	ht := newHandlerTest(HandlerFunc(func(w ResponseWriter, r *Request) {
 		if r.URL.Path == "/s" {
@@ -2093,6 +2556,21 @@ func TestAppendTime(t *testing.T) {
 	}
 }
 
+func TestServerDate(t *testing.T) {
+	t1 := time.Date(2013, 9, 21, 15, 41, 0, 0, time.UTC)
+	d1 := ExportServerDate(t1)
+	if got, want := string(d1), "Sat, 21 Sep 2013 15:41:00 GMT"; got != want {
+		t.Fatalf("date %q; want %q", got, want)
+	}
+	// Within the second, the value formatted for it is shared.
+	if d := ExportServerDate(t1.Add(999 * time.Millisecond)); &d[0] != &d1[0] {
+		t.Error("date formatted again within the second")
+	}
+	if got, want := string(ExportServerDate(t1.Add(time.Second))), "Sat, 21 Sep 2013 15:41:01 GMT"; got != want {
+		t.Errorf("date of the next second %q; want %q", got, want)
+	}
+}
+
 func BenchmarkClientServer(b *testing.B) {
 	b.ReportAllocs()
 	b.StopTimer()
@@ -2303,6 +2781,36 @@ Accept-Charset: ISO-8859-1,utf-8;q=0.7,*;q=0.3
 	}
 }
 
+// same as above, on a connection starting with a PROXY header.
+func BenchmarkServerFakeConnWithKeepAliveProxy(b *testing.B) {
+	b.ReportAllocs()
+
+	req := reqBytes(`GET / HTTP/1.1
+Host: golang.org
+`)
+	res := []byte("Hello world!\n")
+
+	conn := &rwTestConn{
+		Reader: io.MultiReader(
+			strings.NewReader("PROXY TCP4 192.0.2.1 198.51.100.1 56324 80\r\n"),
+			&repeatReader{content: req, count: b.N}),
+		Writer: ioutil.Discard,
+		closec: make(chan bool, 1),
+	}
+	handled := 0
+	handler := HandlerFunc(func(rw ResponseWriter, r *Request) {
+		handled++
+		rw.Write(res)
+	})
+	ln := &oneConnListener{conn: conn}
+	srv := &Server{Handler: handler}
+	go srv.ServeWithOptions(ln, ListenerOptions{WrapConn: ReadProxyHeader})
+	<-conn.closec
+	if b.N != handled {
+		b.Errorf("b.N=%d but handled %d", b.N, handled)
+	}
+}
+
 // same as above, but representing the most simple possible request
 // and handler. Notably: the handler does not call rw.Header().
 func BenchmarkServerFakeConnWithKeepAliveLite(b *testing.B) {
@@ -2390,3 +2898,152 @@ Host: golang.org
 		b.Errorf("b.N=%d but handled %d", b.N, handled)
 	}
 }
+
+func TestServerDrain(t *testing.T) {
+	defer afterTest(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {})}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c, bufio.NewReader(c)
+	}
+	get := func(c net.Conn, br *bufio.Reader) *Response {
+		io.WriteString(c, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+		res, err := ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+	a, abr := dial()
+	defer a.Close()
+	if res := get(a, abr); res.Close {
+		t.Fatal("response before Drain closed the connection")
+	}
+
+	drained := make(chan error, 1)
+	go func() { drained <- srv.Drain(200 * time.Millisecond) }()
+	for !srv.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+	if res := get(a, abr); !res.Close {
+		t.Error("keep-alive connection not closed while draining")
+	}
+	// New connections are still served.
+	b, bbr := dial()
+	defer b.Close()
+	if res := get(b, bbr); res.StatusCode != StatusOK || !res.Close {
+		t.Errorf("new connection while draining: status %d, close %v; want 200 and close", res.StatusCode, res.Close)
+	}
+	idle, _ := dial()
+	defer idle.Close()
+
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Errorf("Drain: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Drain did not return")
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("Serve = %v; want ErrServerClosed", err)
+	}
+	idle.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := idle.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("connection open at the end of Drain: Read error %v; want EOF", err)
+	}
+}
+
+// Connections are tracked in shards; Drain must find and close
+// idle ones in all of them, and ConnStats must count them all.
+func TestServerDrainManyConns(t *testing.T) {
+	defer afterTest(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {})}
+	go srv.Serve(ln)
+
+	const n = 100
+	var conns []net.Conn
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	for i := 0; i < n; i++ {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, c)
+		io.WriteString(c, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+		res, err := ReadResponse(bufio.NewReader(c), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	if got := srv.ConnStats().Open; got != n {
+		t.Errorf("open connections = %d; want %d", got, n)
+	}
+	if err := srv.Drain(5 * time.Second); err != nil {
+		t.Errorf("Drain: %v", err)
+	}
+	for i, c := range conns {
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("connection %d: Read error %v after Drain; want EOF", i, err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.ConnStats().Open != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("open connections = %d after Drain; want 0", srv.ConnStats().Open)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func BenchmarkServeMux(b *testing.B) {
+	mux := NewServeMux()
+	var paths []string
+	for _, dir := range []string{"api", "static", "users", "orders", "admin"} {
+		for i := 0; i < 10; i++ {
+			p := fmt.Sprintf("/%s/v%d/", dir, i)
+			mux.Handle(p, NotFoundHandler())
+			mux.Handle(p+"item", NotFoundHandler())
+			paths = append(paths, p+"item", p+"other/x")
+		}
+	}
+	mux.Handle("example.com/api/", NotFoundHandler())
+	var reqs []*Request
+	for _, p := range append(paths, "/nowhere", "/api/v9") {
+		r, err := NewRequest("GET", "http://example.org"+p, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		reqs = append(reqs, r)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, r := range reqs {
+			if h, _ := mux.Handler(r); h == nil {
+				b.Fatalf("no handler for %s", r.URL)
+			}
+		}
+	}
+}
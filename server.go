@@ -9,6 +9,7 @@ package http
 import (
 	"bufio"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
@@ -22,6 +23,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -41,6 +43,13 @@ var (
 // and then return.  Returning signals that the request is finished
 // and that the HTTP server can move on to the next request on
 // the connection.
+//
+// A Server with ReuseRequests set reuses the ResponseWriter and the
+// Request for later requests once ServeHTTP returns: its handlers must
+// then not use either after that, including from goroutines ServeHTTP
+// started, unless they call the Request's Retain method before
+// returning. Values taken from the Request, such as its Header, URL
+// and Form, are reused with it. Other Servers reuse neither.
 type Handler interface {
 	ServeHTTP(ResponseWriter, *Request)
 }
@@ -111,11 +120,21 @@ type conn struct {
 	lr         *io.LimitedReader    // io.LimitReader(sr)
 	buf        *bufio.ReadWriter    // buffered(lr,rwc), reading from bufio->limitReader->sr->rwc
 	tlsState   *tls.ConnectionState // or nil when not using TLS
+	proxyLine  *ProxyLine           // or nil when not read with ReadProxyHeader
+	opts       *ListenerOptions     // or nil when served by Serve
+	overLimit  bool                 // accepted beyond Server.MaxConns
+	throttled  bool                 // over the RateLimiter's connection rate
+	idle       bool                 // between requests; guarded by shard.mu
+	shard      *connShard           // of the Server's conns holding the connection
+
+	readDeadline  time.Time // of rwc, set by setReadDeadline
+	writeDeadline time.Time // of rwc, set by setWriteDeadline
 
 	mu           sync.Mutex // guards the following
 	clientGone   bool       // if client has disconnected mid-request
 	closeNotifyc chan bool  // made lazily
 	hijackedv    bool       // connection has been hijacked by handler
+	slowBody     bool       // request body sent below MinRequestBodyRate
 }
 
 func (c *conn) hijacked() bool {
@@ -133,6 +152,7 @@ func (c *conn) hijack() (rwc net.Conn, buf *bufio.ReadWriter, err error) {
 	if c.closeNotifyc != nil {
 		return nil, nil, errors.New("http: Hijack is incompatible with use of CloseNotifier")
 	}
+	c.server.trackConn(c, false)
 	c.hijackedv = true
 	rwc = c.rwc
 	buf = c.buf
@@ -278,10 +298,14 @@ func (cw *chunkWriter) close() {
 		cw.writeHeader(nil)
 	}
 	if cw.chunking {
-		// zero EOF chunk, trailer key/value pairs (currently
-		// unsupported in Go's server), followed by a blank
-		// line.
-		cw.res.conn.buf.WriteString("0\r\n\r\n")
+		// zero EOF chunk, trailer key/value pairs, followed by
+		// a blank line.
+		bw := cw.res.conn.buf
+		bw.WriteString("0\r\n")
+		if t := cw.res.finalTrailers(); t != nil {
+			t.Write(bw)
+		}
+		bw.Write(crlf)
 	}
 }
 
@@ -307,6 +331,10 @@ type response struct {
 	contentLength int64 // explicitly-declared Content-Length; or -1
 	status        int   // status code passed to WriteHeader
 
+	// transform holds the writers of the Server's BodyTransformers,
+	// the one the handler writes to last.
+	transform []io.WriteCloser
+
 	// close connection after this reply.  set on request and
 	// updated after response from handler if there's a
 	// "Connection: keep-alive" response header and a
@@ -324,8 +352,20 @@ type response struct {
 
 	handlerDone bool // set true when the handler exits
 
-	// Buffers for Date and Content-Length
-	dateBuf [len(TimeFormat)]byte
+	// fullDuplex is set by EnableFullDuplex, after which the
+	// request body is not consumed when the reply header is
+	// written, so the handler may read it while replying.
+	fullDuplex bool
+
+	// trailers are the keys of the trailers declared in the
+	// "Trailer" header when the reply header was written.
+	trailers []string
+
+	// reuse, shared with req, tells whether the response and its
+	// request may be reused for the connection's next request.
+	reuse reuseState
+
+	// Buffer for Content-Length
 	clenBuf [10]byte
 }
 
@@ -366,18 +406,68 @@ func srcIsRegularFile(src io.Reader) (isRegular bool, err error) {
 	}
 }
 
+// A WrappedConn is a net.Conn, typically returned by a wrapping
+// net.Listener, that passes writes through unmodified to another
+// connection. A listener that consumes a preamble such as a PROXY
+// protocol header before handing the connection to the Server is an
+// example. The Server unwraps such connections to find an
+// io.ReaderFrom, such as a *net.TCPConn, for zero-copy (sendfile)
+// file responses, and to find the *tls.Conn of a TLS connection that
+// was wrapped after the handshake layer, so that Request.TLS is set
+// while Request.RemoteAddr reports the wrapper's address.
+//
+// Connections that transform written bytes, such as *tls.Conn, must
+// not implement WrappedConn.
+type WrappedConn interface {
+	net.Conn
+
+	// UnderlyingConn returns the connection that writes are
+	// passed through to.
+	UnderlyingConn() net.Conn
+}
+
+// connReaderFrom returns the io.ReaderFrom of c or, if c doesn't
+// have one, of the connection it wraps.
+func connReaderFrom(c net.Conn) (io.ReaderFrom, bool) {
+	for {
+		if rf, ok := c.(io.ReaderFrom); ok {
+			return rf, true
+		}
+		wc, ok := c.(WrappedConn)
+		if !ok {
+			return nil, false
+		}
+		c = wc.UnderlyingConn()
+	}
+}
+
+// connTLS returns the *tls.Conn that c is or wraps.
+func connTLS(c net.Conn) (*tls.Conn, bool) {
+	for {
+		if tc, ok := c.(*tls.Conn); ok {
+			return tc, true
+		}
+		wc, ok := c.(WrappedConn)
+		if !ok {
+			return nil, false
+		}
+		c = wc.UnderlyingConn()
+	}
+}
+
 // ReadFrom is here to optimize copying from an *os.File regular file
 // to a *net.TCPConn with sendfile.
 func (w *response) ReadFrom(src io.Reader) (n int64, err error) {
 	// Our underlying w.conn.rwc is usually a *TCPConn (with its
-	// own ReadFrom method). If not, or if our src isn't a regular
-	// file, just fall back to the normal copy method.
-	rf, ok := w.conn.rwc.(io.ReaderFrom)
+	// own ReadFrom method), possibly inside a WrappedConn. If
+	// not, or if our src isn't a regular file, just fall back to
+	// the normal copy method.
+	rf, ok := connReaderFrom(w.conn.rwc)
 	regFile, err := srcIsRegularFile(src)
 	if err != nil {
 		return 0, err
 	}
-	if !ok || !regFile {
+	if !ok || !regFile || w.conn.server.Bandwidth != nil {
 		return io.Copy(writerOnly{w}, src)
 	}
 
@@ -386,6 +476,10 @@ func (w *response) ReadFrom(src io.Reader) (n int64, err error) {
 	if !w.wroteHeader {
 		w.WriteHeader(StatusOK)
 	}
+	if len(w.transform) > 0 {
+		// The body goes through the BodyTransformers.
+		return io.Copy(writerOnly{w}, src)
+	}
 
 	if w.needsSniff() {
 		n0, err := io.Copy(writerOnly{w}, io.LimitReader(src, sniffLen))
@@ -422,70 +516,137 @@ const debugServerConnections = false
 func (srv *Server) newConn(rwc net.Conn) (c *conn, err error) {
 	c = new(conn)
 	c.remoteAddr = rwc.RemoteAddr().String()
+	c.proxyLine = proxyLineOf(rwc)
 	c.server = srv
 	c.rwc = rwc
 	if debugServerConnections {
 		c.rwc = newLoggingConn("server", c.rwc)
 	}
-	c.sr = liveSwitchReader{r: c.rwc}
+	var r io.Reader = c.rwc
+	var w io.Writer = c.rwc
+	if bl := srv.Bandwidth; bl != nil {
+		r, w = bl.limit(c.rwc, c.proxyLine)
+	}
+	if rate := srv.MinResponseWriteRate; rate != nil {
+		w = &minRateWriter{c: c, rwc: c.rwc, w: w, meter: rateMeter{rate: rate}}
+	}
+	c.sr = liveSwitchReader{r: r}
 	c.lr = io.LimitReader(&c.sr, noLimit).(*io.LimitedReader)
-	br := newBufioReader(c.lr)
-	bw := newBufioWriterSize(c.rwc, 4<<10)
+	br := newBufioReaderSize(c.lr, srv.connBufferSize())
+	bw := newBufioWriterSize(w, srv.connBufferSize())
 	c.buf = bufio.NewReadWriter(br, bw)
 	return c, nil
 }
 
-// TODO: use a sync.Cache instead
+func (srv *Server) connBufferSize() int {
+	if srv.ConnBufferSize > 0 {
+		return srv.ConnBufferSize
+	}
+	return 4 << 10
+}
+
+func (srv *Server) responseBufferSize() int {
+	if srv.ResponseBufferSize > 0 {
+		return srv.ResponseBufferSize
+	}
+	return bufferBeforeChunkingSize
+}
+
 var (
-	bufioReaderCache   = make(chan *bufio.Reader, 4)
-	bufioWriterCache2k = make(chan *bufio.Writer, 4)
-	bufioWriterCache4k = make(chan *bufio.Writer, 4)
+	bufioReaderPool   sync.Pool
+	bufioWriter2kPool sync.Pool
+	bufioWriter4kPool sync.Pool
+
+	// Pools of the buffers of other sizes, as set by Servers.
+	bufioPoolsMu     sync.RWMutex
+	bufioReaderPools map[int]*sync.Pool
+	bufioWriterPools map[int]*sync.Pool
 )
 
-func bufioWriterCache(size int) chan *bufio.Writer {
+func bufioReaderPoolOf(size int) *sync.Pool {
+	if size == 4<<10 {
+		return &bufioReaderPool
+	}
+	return sizedPool(&bufioReaderPools, size)
+}
+
+func bufioWriterPool(size int) *sync.Pool {
 	switch size {
 	case 2 << 10:
-		return bufioWriterCache2k
+		return &bufioWriter2kPool
 	case 4 << 10:
-		return bufioWriterCache4k
+		return &bufioWriter4kPool
 	}
-	return nil
+	return sizedPool(&bufioWriterPools, size)
 }
 
-func newBufioReader(r io.Reader) *bufio.Reader {
-	select {
-	case p := <-bufioReaderCache:
-		p.Reset(r)
+// sizedPool returns the pool in pools of the buffers of size, adding
+// it if there is none.
+func sizedPool(pools *map[int]*sync.Pool, size int) *sync.Pool {
+	bufioPoolsMu.RLock()
+	p := (*pools)[size]
+	bufioPoolsMu.RUnlock()
+	if p != nil {
 		return p
-	default:
-		return bufio.NewReader(r)
 	}
+	bufioPoolsMu.Lock()
+	defer bufioPoolsMu.Unlock()
+	if p = (*pools)[size]; p == nil {
+		if *pools == nil {
+			*pools = make(map[int]*sync.Pool)
+		}
+		p = new(sync.Pool)
+		(*pools)[size] = p
+	}
+	return p
+}
+
+func newBufioReader(r io.Reader) *bufio.Reader {
+	return newBufioReaderSize(r, 4<<10)
+}
+
+func newBufioReaderSize(r io.Reader, size int) *bufio.Reader {
+	if v := bufioReaderPoolOf(size).Get(); v != nil {
+		br := v.(*bufio.Reader)
+		br.Reset(r)
+		return br
+	}
+	return bufio.NewReaderSize(r, size)
 }
 
 func putBufioReader(br *bufio.Reader) {
 	br.Reset(nil)
-	select {
-	case bufioReaderCache <- br:
-	default:
-	}
+	bufioReaderPoolOf(br.Size()).Put(br)
 }
 
 func newBufioWriterSize(w io.Writer, size int) *bufio.Writer {
-	select {
-	case p := <-bufioWriterCache(size):
-		p.Reset(w)
-		return p
-	default:
-		return bufio.NewWriterSize(w, size)
+	if v := bufioWriterPool(size).Get(); v != nil {
+		bw := v.(*bufio.Writer)
+		bw.Reset(w)
+		return bw
 	}
+	return bufio.NewWriterSize(w, size)
 }
 
 func putBufioWriter(bw *bufio.Writer) {
 	bw.Reset(nil)
-	select {
-	case bufioWriterCache(bw.Available()) <- bw:
-	default:
+	bufioWriterPool(bw.Size()).Put(bw)
+}
+
+var responsePool sync.Pool
+
+func newResponse() *response {
+	if v := responsePool.Get(); v != nil {
+		return v.(*response)
 	}
+	return new(response)
+}
+
+// putResponse keeps w, whose request was answered on a connection
+// kept alive, for the connection's next request or another's.
+func putResponse(w *response) {
+	*w = response{}
+	responsePool.Put(w)
 }
 
 // DefaultMaxHeaderBytes is the maximum permitted size of the headers
@@ -500,6 +661,40 @@ func (srv *Server) maxHeaderBytes() int {
 	return DefaultMaxHeaderBytes
 }
 
+func (c *conn) maxHeaderBytes() int {
+	if c.opts != nil && c.opts.MaxHeaderBytes > 0 {
+		return c.opts.MaxHeaderBytes
+	}
+	return c.server.maxHeaderBytes()
+}
+
+func (c *conn) readTimeout() time.Duration {
+	if c.opts != nil && c.opts.ReadTimeout != 0 {
+		return c.opts.ReadTimeout
+	}
+	return c.server.ReadTimeout
+}
+
+func (c *conn) writeTimeout() time.Duration {
+	if c.opts != nil && c.opts.WriteTimeout != 0 {
+		return c.opts.WriteTimeout
+	}
+	return c.server.WriteTimeout
+}
+
+func (c *conn) handler() serverHandler {
+	sh := serverHandler{srv: c.server}
+	if c.opts != nil {
+		sh.h = c.opts.Handler
+	}
+	if c.overLimit {
+		sh.h = overLimitHandler
+	} else if c.throttled {
+		sh.h = connRateLimitedHandler
+	}
+	return sh
+}
+
 // wrapper around io.ReaderCloser which on first read, sends an
 // HTTP/1.1 100 Continue header
 type expectContinueReader struct {
@@ -525,12 +720,46 @@ func (ecr *expectContinueReader) Close() error {
 	return ecr.readCloser.Close()
 }
 
+// bodyExpecter returns the expectContinueReader of a request body,
+// read through BodyTransformers or not.
+func bodyExpecter(body io.ReadCloser) (*expectContinueReader, bool) {
+	if tb, ok := body.(*transformedBody); ok {
+		ecr, ok := tb.body.(*expectContinueReader)
+		return ecr, ok
+	}
+	ecr, ok := body.(*expectContinueReader)
+	return ecr, ok
+}
+
 // TimeFormat is the time format to use with
 // time.Parse and time.Time.Format when parsing
 // or generating times in HTTP headers.
 // It is like time.RFC1123 but hard codes GMT as the time zone.
 const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
 
+// A formattedDate is the Date header value of the second sec.
+type formattedDate struct {
+	sec int64
+	b   [len(TimeFormat)]byte
+}
+
+// dateCache holds the *formattedDate of the second of the last reply.
+var dateCache atomic.Value
+
+// serverDate returns the Date header value for a reply at now. The
+// value is formatted once each second and shared by all replies in
+// it, so it must not be modified.
+func serverDate(now time.Time) []byte {
+	sec := now.Unix()
+	if d, _ := dateCache.Load().(*formattedDate); d != nil && d.sec == sec {
+		return d.b[:]
+	}
+	d := &formattedDate{sec: sec}
+	appendTime(d.b[:0], now)
+	dateCache.Store(d)
+	return d.b[:]
+}
+
 // appendTime is a non-allocating version of []byte(t.UTC().Format(TimeFormat))
 func appendTime(b []byte, t time.Time) []byte {
 	const days = "SunMonTueWedThuFriSat"
@@ -561,18 +790,29 @@ func (c *conn) readRequest() (w *response, err error) {
 		return nil, ErrHijacked
 	}
 
-	if d := c.server.ReadTimeout; d != 0 {
-		c.rwc.SetReadDeadline(time.Now().Add(d))
-	}
-	if d := c.server.WriteTimeout; d != 0 {
-		defer func() {
-			c.rwc.SetWriteDeadline(time.Now().Add(d))
-		}()
+	// Both deadlines are reset, even without timeouts, so that those
+	// the handler of the previous request set with a
+	// ResponseController don't carry over to this one.
+	var rd time.Time
+	if d := c.readTimeout(); d != 0 {
+		rd = time.Now().Add(d)
 	}
+	c.setReadDeadline(rd)
+	defer func() {
+		var wd time.Time
+		if d := c.writeTimeout(); d != 0 {
+			wd = time.Now().Add(d)
+		}
+		c.setWriteDeadline(wd)
+	}()
 
-	c.lr.N = int64(c.server.maxHeaderBytes()) + 4096 /* bufio slop */
-	var req *Request
-	if req, err = ReadRequest(c.buf.Reader); err != nil {
+	c.lr.N = int64(c.maxHeaderBytes()) + int64(c.buf.Reader.Size()) /* bufio slop */
+	var reuse *Request
+	if c.server.ReuseRequests && c.server.ReuseCheck == nil {
+		reuse = newRequest()
+	}
+	req, err := c.server.readRequest(c.buf.Reader, reuse)
+	if err != nil {
 		if c.lr.N == 0 {
 			return nil, errTooLarge
 		}
@@ -581,20 +821,30 @@ func (c *conn) readRequest() (w *response, err error) {
 	c.lr.N = noLimit
 
 	req.RemoteAddr = c.remoteAddr
+	req.ProxyLine = c.proxyLine
 	req.TLS = c.tlsState
 
-	w = &response{
+	w = newResponse()
+	*w = response{
 		conn:          c,
 		req:           req,
 		handlerHeader: make(Header),
 		contentLength: -1,
+		reuse:         reuseState{srv: c.server},
+	}
+	req.reuse = &w.reuse
+	if v := c.server.altSvc(req.Host); v != "" {
+		w.Header().Set("Alt-Svc", v)
 	}
 	w.cw.res = w
-	w.w = newBufioWriterSize(&w.cw, bufferBeforeChunkingSize)
+	w.w = newBufioWriterSize(&w.cw, c.server.responseBufferSize())
 	return w, nil
 }
 
 func (w *response) Header() Header {
+	if w.checkReuse("ResponseWriter.Header") {
+		return make(Header)
+	}
 	if w.cw.header == nil && w.wroteHeader && !w.cw.wroteHeader {
 		// Accessing the header between logically writing it
 		// and physically writing it means we need to allocate
@@ -617,6 +867,9 @@ func (w *response) Header() Header {
 const maxPostHandlerReadBytes = 256 << 10
 
 func (w *response) WriteHeader(code int) {
+	if w.checkReuse("ResponseWriter.WriteHeader") {
+		return
+	}
 	if w.conn.hijacked() {
 		log.Print("http: response.WriteHeader on hijacked connection")
 		return
@@ -625,8 +878,13 @@ func (w *response) WriteHeader(code int) {
 		log.Print("http: multiple response.WriteHeader calls")
 		return
 	}
+	if code >= 100 && code <= 199 && code != StatusSwitchingProtocols {
+		w.writeInterim(code)
+		return
+	}
 	w.wroteHeader = true
 	w.status = code
+	w.transformWriter()
 
 	if w.calledHeader && w.cw.header == nil {
 		w.cw.header = w.handlerHeader.clone()
@@ -643,6 +901,33 @@ func (w *response) WriteHeader(code int) {
 	}
 }
 
+// excludedInterimHeaders are the headers of the response not
+// written with informational responses, which have no body.
+var excludedInterimHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+}
+
+// writeInterim writes an informational (1xx) response with code,
+// such as 103 Early Hints, with the headers set so far, which remain
+// set for the final response. Clients older than HTTP/1.1 do not get
+// them, nor do clients get 100 Continue twice.
+func (w *response) writeInterim(code int) {
+	if !w.req.ProtoAtLeast(1, 1) {
+		return
+	}
+	if code == StatusContinue {
+		if w.wroteContinue {
+			return
+		}
+		w.wroteContinue = true
+	}
+	w.conn.buf.WriteString(statusLine(w.req, code))
+	w.handlerHeader.WriteSubset(w.conn.buf, excludedInterimHeaders)
+	w.conn.buf.Write(crlf)
+	w.conn.buf.Flush()
+}
+
 // extraHeader is the set of headers sometimes added by chunkWriter.writeHeader.
 // This type is used to avoid extra allocations from cloning and/or populating
 // the response Header map and all its 1-element slices.
@@ -735,6 +1020,14 @@ func (cw *chunkWriter) writeHeader(p []byte) {
 	}
 	var setHeader extraHeader
 
+	// Headers set with TrailerPrefix are sent after the body.
+	for k := range header {
+		if strings.HasPrefix(k, TrailerPrefix) {
+			delHeader(k)
+		}
+	}
+	trailers := w.declareTrailers(header)
+
 	// If the handler is done but never sent a Content-Length
 	// response header and this is our first (and last) write, set
 	// it, even to zero. This helps HTTP/1.0 clients keep their
@@ -746,8 +1039,9 @@ func (cw *chunkWriter) writeHeader(p []byte) {
 	// HEAD, the handler should either write the Content-Length or
 	// write non-zero bytes.  If it's actually 0 bytes and the
 	// handler never looked at the Request.Method, we just don't
-	// send a Content-Length header.
-	if w.handlerDone && w.status != StatusNotModified && header.get("Content-Length") == "" && (!isHEAD || len(p) > 0) {
+	// send a Content-Length header. Nor is one sent if trailers
+	// were declared, as they need a chunked reply.
+	if w.handlerDone && !trailers && w.status != StatusNotModified && header.get("Content-Length") == "" && (!isHEAD || len(p) > 0) {
 		w.contentLength = int64(len(p))
 		setHeader.contentLength = strconv.AppendInt(cw.res.clenBuf[:0], int64(len(p)), 10)
 	}
@@ -773,7 +1067,7 @@ func (cw *chunkWriter) writeHeader(p []byte) {
 		w.closeAfterReply = true
 	}
 
-	if header.get("Connection") == "close" {
+	if header.get("Connection") == "close" || w.conn.server.Draining() || w.conn.bodyTooSlow() {
 		w.closeAfterReply = true
 	}
 
@@ -781,8 +1075,8 @@ func (cw *chunkWriter) writeHeader(p []byte) {
 	// replying, if the handler hasn't already done so.  But we
 	// don't want to do an unbounded amount of reading here for
 	// DoS reasons, so we only try up to a threshold.
-	if w.req.ContentLength != 0 && !w.closeAfterReply {
-		ecr, isExpecter := w.req.Body.(*expectContinueReader)
+	if w.req.ContentLength != 0 && !w.closeAfterReply && !w.fullDuplex {
+		ecr, isExpecter := bodyExpecter(w.req.Body)
 		if !isExpecter || ecr.resp.wroteContinue {
 			n, _ := io.CopyN(ioutil.Discard, w.req.Body, maxPostHandlerReadBytes+1)
 			if n >= maxPostHandlerReadBytes {
@@ -811,7 +1105,7 @@ func (cw *chunkWriter) writeHeader(p []byte) {
 	}
 
 	if _, ok := header["Date"]; !ok {
-		setHeader.date = appendTime(cw.res.dateBuf[:0], time.Now())
+		setHeader.date = serverDate(time.Now())
 	}
 
 	te := header.get("Transfer-Encoding")
@@ -939,10 +1233,12 @@ func (w *response) bodyAllowed() bool {
 // The Writers are wired together like:
 //
 // 1. *response (the ResponseWriter) ->
-// 2. (*response).w, a *bufio.Writer of bufferBeforeChunkingSize bytes
+// 2. (*response).w, a *bufio.Writer of bufferBeforeChunkingSize bytes,
+//    or Server.ResponseBufferSize
 // 3. chunkWriter.Writer (whose writeHeader finalizes Content-Length/Type)
 //    and which writes the chunk headers, if needed.
-// 4. conn.buf, a bufio.Writer of default (4kB) bytes
+// 4. conn.buf, a bufio.Writer of default (4kB) bytes, or
+//    Server.ConnBufferSize
 // 5. the rwc, the net.Conn.
 //
 // TODO(bradfitz): short-circuit some of the buffering when the
@@ -964,6 +1260,9 @@ func (w *response) WriteString(data string) (n int, err error) {
 
 // either dataB or dataS is non-zero.
 func (w *response) write(lenData int, dataB []byte, dataS string) (n int, err error) {
+	if w.checkReuse("ResponseWriter.Write") {
+		return 0, errHandlerReturned
+	}
 	if w.conn.hijacked() {
 		log.Print("http: response.Write on hijacked connection")
 		return 0, ErrHijacked
@@ -982,6 +1281,13 @@ func (w *response) write(lenData int, dataB []byte, dataS string) (n int, err er
 	if w.contentLength != -1 && w.written > w.contentLength {
 		return 0, ErrContentLength
 	}
+	if len(w.transform) > 0 {
+		tw := w.transform[len(w.transform)-1]
+		if dataB != nil {
+			return tw.Write(dataB)
+		}
+		return io.WriteString(tw, dataS)
+	}
 	if dataB != nil {
 		return w.w.Write(dataB)
 	} else {
@@ -991,11 +1297,15 @@ func (w *response) write(lenData int, dataB []byte, dataS string) (n int, err er
 
 func (w *response) finishRequest() {
 	w.handlerDone = true
+	if w.conn.bodyTooSlow() {
+		w.closeAfterReply = true
+	}
 
 	if !w.wroteHeader {
 		w.WriteHeader(StatusOK)
 	}
 
+	w.closeTransform()
 	w.w.Flush()
 	putBufioWriter(w.w)
 	w.cw.close()
@@ -1017,9 +1327,13 @@ func (w *response) finishRequest() {
 }
 
 func (w *response) Flush() {
+	if w.checkReuse("ResponseWriter.Flush") {
+		return
+	}
 	if !w.wroteHeader {
 		w.WriteHeader(StatusOK)
 	}
+	w.flushTransform()
 	w.w.Flush()
 	w.cw.flush()
 }
@@ -1066,9 +1380,7 @@ const rstAvoidanceDelay = 500 * time.Millisecond
 // See http://golang.org/issue/3595
 func (c *conn) closeWriteAndWait() {
 	c.finalFlush()
-	if tcp, ok := c.rwc.(*net.TCPConn); ok {
-		tcp.CloseWrite()
-	}
+	closeWrite(c.rwc)
 	time.Sleep(rstAvoidanceDelay)
 }
 
@@ -1096,23 +1408,37 @@ func (c *conn) serve() {
 		if !c.hijacked() {
 			c.close()
 		}
+		c.server.trackConn(c, false)
+		c.server.endConn(c.overLimit)
 	}()
+	c.server.trackConn(c, true)
+	if rl := c.server.RateLimiter; rl != nil && !c.overLimit {
+		c.throttled = !rl.allowConn(c.remoteAddr)
+	}
 
-	if tlsConn, ok := c.rwc.(*tls.Conn); ok {
-		if d := c.server.ReadTimeout; d != 0 {
-			c.rwc.SetReadDeadline(time.Now().Add(d))
+	if tlsConn, ok := connTLS(c.rwc); ok {
+		if d := c.readTimeout(); d != 0 {
+			c.setReadDeadline(time.Now().Add(d))
 		}
-		if d := c.server.WriteTimeout; d != 0 {
-			c.rwc.SetWriteDeadline(time.Now().Add(d))
+		if d := c.writeTimeout(); d != 0 {
+			c.setWriteDeadline(time.Now().Add(d))
 		}
 		if err := tlsConn.Handshake(); err != nil {
 			return
 		}
 		c.tlsState = new(tls.ConnectionState)
 		*c.tlsState = tlsConn.ConnectionState()
+		if policy := c.server.ClientCertPolicy; policy != nil {
+			if err := policy(c.tlsState, c.remoteAddr); err != nil {
+				log.Printf("http: TLS client %s rejected: %v", c.remoteAddr, err)
+				return
+			}
+		}
 		if proto := c.tlsState.NegotiatedProtocol; validNPN(proto) {
-			if fn := c.server.TLSNextProto[proto]; fn != nil {
-				h := initNPNRequest{tlsConn, serverHandler{c.server}}
+			if fn := c.server.alpnHandler(proto); fn != nil {
+				fn(tlsConn)
+			} else if fn := c.server.TLSNextProto[proto]; fn != nil {
+				h := initNPNRequest{tlsConn, c.handler(), c.remoteAddr}
 				fn(c.server, tlsConn, h)
 			}
 			return
@@ -1122,26 +1448,27 @@ func (c *conn) serve() {
 	for {
 		w, err := c.readRequest()
 		if err != nil {
-			if err == errTooLarge {
-				// Their HTTP client may or may not be
-				// able to read this if we're
-				// responding to them and hanging up
-				// while they're still writing their
-				// request.  Undefined behavior.
-				io.WriteString(c.rwc, "HTTP/1.1 413 Request Entity Too Large\r\n\r\n")
-				c.closeWriteAndWait()
-				break
-			} else if err == io.EOF {
+			if err == io.EOF {
 				break // Don't reply
 			} else if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
 				break // Don't reply
 			}
-			io.WriteString(c.rwc, "HTTP/1.1 400 Bad Request\r\n\r\n")
+			c.rejectRequest(err)
 			break
 		}
+		c.server.setConnIdle(c, false)
+		start := time.Now()
+		c.setRequestID(w)
+		c.setGeo(w)
+		setTraceContext(w.req)
+		endSpan := c.startSpan(w)
 
-		// Expect 100 Continue support
 		req := w.req
+		if rate := c.server.MinRequestBodyRate; rate != nil && req.ContentLength != 0 {
+			req.Body = &minRateBody{rc: req.Body, w: w, meter: rateMeter{rate: rate}}
+		}
+
+		// Expect 100 Continue support
 		if req.expectsContinue() {
 			if req.ProtoAtLeast(1, 1) {
 				// Wrap the Body reader with one that replies on the connection
@@ -1149,7 +1476,7 @@ func (c *conn) serve() {
 			}
 			if req.ContentLength == 0 {
 				w.Header().Set("Connection", "close")
-				w.WriteHeader(StatusBadRequest)
+				respondError(w, w.req, StatusBadRequest, "Bad Request: Expect 100-continue without a body", errExpectNoBody)
 				w.finishRequest()
 				break
 			}
@@ -1158,23 +1485,37 @@ func (c *conn) serve() {
 			w.sendExpectationFailed()
 			break
 		}
+		c.server.transformRequestBody(req)
 
 		// HTTP cannot have multiple simultaneous active requests.[*]
 		// Until the server replies to this request, it can't read another,
 		// so we might as well run the handler in this goroutine.
 		// [*] Not strictly true: HTTP pipelining.  We could let them all process
 		// in parallel even if their responses need to be serialized.
-		serverHandler{c.server}.ServeHTTP(w, w.req)
+		if ok, retry := c.allowRequest(); !ok {
+			tooManyRequests(w, w.req, retry)
+		} else if c.isTunnel(w.req) {
+			c.serveTunnel(w)
+		} else {
+			c.handler().ServeHTTP(w, w.req)
+		}
 		if c.hijacked() {
+			endSpan()
 			return
 		}
 		w.finishRequest()
+		endSpan()
+		c.logAccess(w, start)
 		if w.closeAfterReply {
 			if w.requestBodyLimitHit {
 				c.closeWriteAndWait()
 			}
 			break
 		}
+		c.release(w)
+		if !c.server.setConnIdle(c, true) {
+			break
+		}
 	}
 }
 
@@ -1192,13 +1533,16 @@ func (w *response) sendExpectationFailed() {
 	// extension that it does not support, it MUST
 	// respond with a 417 (Expectation Failed) status."
 	w.Header().Set("Connection", "close")
-	w.WriteHeader(StatusExpectationFailed)
+	respondError(w, w.req, StatusExpectationFailed, "Expectation Failed", errExpectation)
 	w.finishRequest()
 }
 
 // Hijack implements the Hijacker.Hijack method. Our response is both a ResponseWriter
 // and a Hijacker.
 func (w *response) Hijack() (rwc net.Conn, buf *bufio.ReadWriter, err error) {
+	if w.checkReuse("Hijacker.Hijack") {
+		return nil, nil, errHandlerReturned
+	}
 	if w.wroteHeader {
 		w.cw.flush()
 	}
@@ -1231,7 +1575,9 @@ func Error(w ResponseWriter, error string, code int) {
 }
 
 // NotFound replies to the request with an HTTP 404 not found error.
-func NotFound(w ResponseWriter, r *Request) { Error(w, "404 page not found", StatusNotFound) }
+func NotFound(w ResponseWriter, r *Request) {
+	respondError(w, r, StatusNotFound, "404 page not found", errNotFound)
+}
 
 // NotFoundHandler returns a simple request handler
 // that replies to each request with a ``404 page not found'' reply.
@@ -1368,15 +1714,24 @@ func RedirectHandler(url string, code int) Handler {
 // URLs on that host only.  Host-specific patterns take precedence over
 // general patterns, so that a handler might register for the two patterns
 // "/codesearch" and "codesearch.google.com/" without also taking over
-// requests for "http://www.google.com/".
+// requests for "http://www.google.com/". Host names are matched as
+// NormalizeHost normalizes them, so that "Bücher.example/" matches
+// requests for "xn--bcher-kva.example" and, over HTTPS,
+// "bücher.example:443", and "example.com:80/" those for
+// "example.com" over HTTP but not over HTTPS.
 //
 // ServeMux also takes care of sanitizing the URL request path,
 // redirecting any request containing . or .. elements to an
 // equivalent .- and ..-free URL.
+//
+// Patterns are kept in a radix tree, so that finding the one for a
+// request takes time in proportion to the length of its path rather
+// than to the number of patterns.
 type ServeMux struct {
 	mu    sync.RWMutex
 	m     map[string]muxEntry
-	hosts bool // whether any patterns contain hostnames
+	tree  muxNode // of the patterns in m, for lookup
+	hosts bool    // whether any patterns contain hostnames
 }
 
 type muxEntry struct {
@@ -1391,19 +1746,6 @@ func NewServeMux() *ServeMux { return &ServeMux{m: make(map[string]muxEntry)} }
 // DefaultServeMux is the default ServeMux used by Serve.
 var DefaultServeMux = NewServeMux()
 
-// Does path match pattern?
-func pathMatch(pattern, path string) bool {
-	if len(pattern) == 0 {
-		// should not happen
-		return false
-	}
-	n := len(pattern)
-	if pattern[n-1] != '/' {
-		return pattern == path
-	}
-	return len(path) >= n && path[0:n] == pattern
-}
-
 // Return the canonical path for p, eliminating . and .. elements.
 func cleanPath(p string) string {
 	if p == "" {
@@ -1424,18 +1766,10 @@ func cleanPath(p string) string {
 // Find a handler on a handler map given a path string
 // Most-specific (longest) pattern wins
 func (mux *ServeMux) match(path string) (h Handler, pattern string) {
-	var n = 0
-	for k, v := range mux.m {
-		if !pathMatch(k, path) {
-			continue
-		}
-		if h == nil || len(k) > n {
-			n = len(k)
-			h = v.h
-			pattern = v.pattern
-		}
+	if e := mux.tree.match(path); e != nil {
+		return e.h, e.pattern
 	}
-	return
+	return nil, ""
 }
 
 // Handler returns the handler to use for the given request,
@@ -1453,25 +1787,39 @@ func (mux *ServeMux) match(path string) (h Handler, pattern string) {
 func (mux *ServeMux) Handler(r *Request) (h Handler, pattern string) {
 	if r.Method != "CONNECT" {
 		if p := cleanPath(r.URL.Path); p != r.URL.Path {
-			_, pattern = mux.handler(r.Host, p)
+			_, pattern = mux.handler(r, p)
 			url := *r.URL
 			url.Path = p
 			return RedirectHandler(url.String(), StatusMovedPermanently), pattern
 		}
 	}
 
-	return mux.handler(r.Host, r.URL.Path)
+	return mux.handler(r, r.URL.Path)
 }
 
 // handler is the main implementation of Handler.
 // The path is known to be in canonical form, except for CONNECT methods.
-func (mux *ServeMux) handler(host, path string) (h Handler, pattern string) {
+func (mux *ServeMux) handler(r *Request, path string) (h Handler, pattern string) {
 	mux.mu.RLock()
 	defer mux.mu.RUnlock()
 
 	// Host-specific pattern takes precedence over generic ones
 	if mux.hosts {
-		h, pattern = mux.match(host + path)
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		host, err := NormalizeHost(r.Host, scheme)
+		if err != nil {
+			host = r.Host
+		}
+		if !hasPort(host) {
+			// Patterns naming the default port of scheme first.
+			h, pattern = mux.match(host + ":" + portMap[scheme] + path)
+		}
+		if h == nil {
+			h, pattern = mux.match(host + path)
+		}
 	}
 	if h == nil {
 		h, pattern = mux.match(path)
@@ -1489,10 +1837,11 @@ func (mux *ServeMux) ServeHTTP(w ResponseWriter, r *Request) {
 		if r.ProtoAtLeast(1, 1) {
 			w.Header().Set("Connection", "close")
 		}
-		w.WriteHeader(StatusBadRequest)
+		respondError(w, r, StatusBadRequest, "Bad Request", errAsteriskURI)
 		return
 	}
-	h, _ := mux.Handler(r)
+	h, pattern := mux.Handler(r)
+	setRoute(r, pattern)
 	h.ServeHTTP(w, r)
 }
 
@@ -1508,11 +1857,15 @@ func (mux *ServeMux) Handle(pattern string, handler Handler) {
 	if handler == nil {
 		panic("http: nil handler")
 	}
+	name := pattern
+	if i := strings.Index(pattern, "/"); i > 0 {
+		pattern = patternHost(pattern[:i]) + pattern[i:]
+	}
 	if mux.m[pattern].explicit {
-		panic("http: multiple registrations for " + pattern)
+		panic("http: multiple registrations for " + name)
 	}
 
-	mux.m[pattern] = muxEntry{explicit: true, h: handler, pattern: pattern}
+	mux.add(pattern, muxEntry{explicit: true, h: handler, pattern: name})
 
 	if pattern[0] != '/' {
 		mux.hosts = true
@@ -1522,7 +1875,7 @@ func (mux *ServeMux) Handle(pattern string, handler Handler) {
 	// If pattern is /tree/, insert an implicit permanent redirect for /tree.
 	// It can be overridden by an explicit registration.
 	n := len(pattern)
-	if n > 0 && pattern[n-1] == '/' && !mux.m[pattern[0:n-1]].explicit {
+	if n > 1 && pattern[n-1] == '/' && !mux.m[pattern[0:n-1]].explicit {
 		// If pattern contains a host name, strip it and use remaining
 		// path for redirect.
 		path := pattern
@@ -1531,8 +1884,27 @@ func (mux *ServeMux) Handle(pattern string, handler Handler) {
 			// strings.Index can't be -1.
 			path = pattern[strings.Index(pattern, "/"):]
 		}
-		mux.m[pattern[0:n-1]] = muxEntry{h: RedirectHandler(path, StatusMovedPermanently), pattern: pattern}
+		mux.add(pattern[0:n-1], muxEntry{h: RedirectHandler(path, StatusMovedPermanently), pattern: name})
+	}
+}
+
+// patternHost returns host, of a pattern, normalized as the hosts of
+// requests are. Patterns don't name a scheme, so their ports are kept,
+// for a pattern naming a default port to match only requests of its
+// scheme, and hosts NormalizeHost rejects are kept as they are, as
+// those of requests are.
+func patternHost(host string) string {
+	h, err := NormalizeHost(host, "")
+	if err != nil {
+		return host
 	}
+	return h
+}
+
+// add records e as the entry of pattern.
+func (mux *ServeMux) add(pattern string, e muxEntry) {
+	mux.m[pattern] = e
+	mux.tree.insert(pattern, e)
 }
 
 // HandleFunc registers the handler function for the given pattern.
@@ -1578,16 +1950,251 @@ type Server struct {
 	// and RemoteAddr if not already set.  The connection is
 	// automatically closed when the function returns.
 	TLSNextProto map[string]func(*Server, *tls.Conn, Handler)
-}
 
-// serverHandler delegates to either the server's Handler or
-// DefaultServeMux and also handles "OPTIONS *" requests.
+	// AutoCert optionally specifies the certificate manager used
+	// by ListenAndServeAutoTLS and ServeAutoTLS.
+	AutoCert *AutoCertManager
+
+	// ClientCAs, if non-nil, enables mutual TLS on listeners started
+	// by ListenAndServeTLS and ServeAutoTLS: clients must present a
+	// certificate signed by one of these authorities. To make client
+	// certificates optional, also set TLSConfig.ClientAuth to
+	// tls.VerifyClientCertIfGiven. The verified chains are available
+	// to handlers in Request.TLS.VerifiedChains.
+	ClientCAs *x509.CertPool
+
+	// ClientCertPolicy optionally authorizes each TLS connection once
+	// its handshake completes, before any request is read. It is
+	// given the connection state, holding the client's verified
+	// certificate chains, if any, and the client's network address as
+	// reported by the connection, so policies may combine both. If it
+	// returns an error the connection is closed.
+	ClientCertPolicy func(state *tls.ConnectionState, remoteAddr string) error
+
+	// OCSPStapler optionally staples OCSP responses to the
+	// certificates served by ListenAndServeTLS and ServeAutoTLS.
+	OCSPStapler *OCSPStapler
+
+	// SessionTicketRotation, if positive, is how often the TLS
+	// session ticket keys of listeners started by ListenAndServeTLS
+	// and ServeAutoTLS are rotated, limiting how much traffic a
+	// leaked key exposes. Each rotation also keeps two previous
+	// keys for decrypting recent tickets.
+	SessionTicketRotation time.Duration
+
+	// SessionTicketKeySource optionally supplies the session ticket
+	// keys at each rotation, in the order SetSessionTicketKeys
+	// expects, so that a fleet of servers can share keys. If nil,
+	// keys are generated randomly. If it fails, the current keys
+	// remain in use until the next rotation.
+	SessionTicketKeySource func() ([][32]byte, error)
+
+	// TCPOptions optionally sets socket options of accepted TCP
+	// connections.
+	TCPOptions *TCPOptions
+
+	// AcceptBackoff optionally returns how long to wait before
+	// accepting again after the nth consecutive temporary Accept
+	// error, such as running out of file descriptors. If nil, the
+	// wait starts at 5ms and doubles up to 1s.
+	AcceptBackoff func(n int) time.Duration
+
+	// OnAcceptError, if non-nil, is called with each temporary
+	// Accept error, the number of consecutive errors so far and the
+	// wait before the next attempt, instead of logging the error.
+	OnAcceptError func(err error, n int, delay time.Duration)
+
+	// MaxAcceptErrors, if positive, is the number of consecutive
+	// temporary Accept errors after which Serve gives up and
+	// returns the error.
+	MaxAcceptErrors int
+
+	// Acceptors is the number of goroutines accepting connections on
+	// each listener at once. Several keep the latency of accepting
+	// low on machines with many CPUs when bursts of connections
+	// arrive at once, such as from load balancers re-resolving. If
+	// zero, one goroutine accepts; if negative, GOMAXPROCS do.
+	Acceptors int
+
+	// IOUring, if true, makes the server read from and write to
+	// accepted TCP connections through an io_uring, submitting the
+	// system calls of many connections together. It is experimental
+	// and only takes effect on Linux, in programs built with the
+	// "uring" build tag, where io_uring is available. Elsewhere, and
+	// for any read or write the ring can't do, the standard network
+	// poller is used.
+	IOUring bool
+
+	// ReuseRequests, if true, makes the server reuse the *Request of
+	// a request, its Header and its ResponseWriter for a later
+	// request once the handler returns, saving their allocations.
+	// Handlers must then follow the rules at Handler strictly: see
+	// also Request.Retain.
+	ReuseRequests bool
+
+	// ReuseCheck, if non-nil, is called for uses of the Request
+	// and ResponseWriter of a request after its handler returned,
+	// which break the rules at Handler, with req and the method
+	// used, such as "ResponseWriter.Write". Only some methods are
+	// checked, and none of the fields. Requests and ResponseWriters
+	// are then never reused: the check is for tests and debugging,
+	// doing at run time what a vet check would of the code.
+	ReuseCheck func(req *Request, method string)
+
+	// MaxConns, if positive, limits the number of connections served
+	// at once; ConnLimitPolicy says what happens to connections
+	// beyond it. Hijacked connections no longer count.
+	MaxConns        int
+	ConnLimitPolicy ConnLimitPolicy
+
+	// RateLimiter optionally limits the rate of connections and
+	// requests from each client.
+	RateLimiter *RateLimiter
+
+	// Bandwidth optionally limits the rate at which connections
+	// are read from and written to. Responses are then not sent
+	// with sendfile.
+	Bandwidth *BandwidthLimiter
+
+	// MinRequestBodyRate and MinResponseWriteRate optionally guard
+	// against clients, such as slowloris attackers, holding
+	// connections open by sending request bodies or reading
+	// responses too slowly, when ReadTimeout and WriteTimeout must
+	// be long enough for the largest legitimate transfers. Reads of
+	// a request body sent too slowly fail with ErrBodyReadTooSlow
+	// and the connection is closed after the reply; a connection
+	// whose responses are read too slowly is closed at once.
+	// Bandwidth limits should be well above the minimum rates.
+	MinRequestBodyRate   *MinDataRate
+	MinResponseWriteRate *MinDataRate
+
+	// TunnelHandler, if non-nil, serves the tunnels of CONNECT
+	// requests to a "host:port", as a forward proxy, instead of
+	// the Handler. ForwardTunnel connects them to their targets.
+	TunnelHandler TunnelHandler
+
+	// AllowTunnel optionally checks whether the client of the
+	// CONNECT request r, at r.RemoteAddr, may open a tunnel to
+	// target. If it returns an error, the request is refused with
+	// 403 Forbidden and the error's text.
+	AllowTunnel func(target string, r *Request) error
+
+	// StrictParsing rejects requests whose framing intermediaries
+	// may interpret differently, closing the connection after a
+	// 400 Bad Request: requests with both Transfer-Encoding and
+	// Content-Length, or either of them repeated, header lines
+	// continued with obsolete line folding, and lines ending in a
+	// bare LF instead of CRLF. Servers behind proxies should set it
+	// to guard against request smuggling.
+	StrictParsing bool
+
+	// HeaderPolicy optionally tightens the checks on request lines
+	// and headers further.
+	HeaderPolicy *HeaderPolicy
+
+	// FormPolicy optionally limits the forms of requests parsed by
+	// ParseForm, ParseMultipartForm and FormValue.
+	FormPolicy *FormPolicy
+
+	// OnRequestError, if non-nil, is called with the client's
+	// address and the error for each request the server rejects as
+	// malformed or too large, before the error response is written.
+	// Requests rejected under StrictParsing or HeaderPolicy report a
+	// *RequestError saying which check failed.
+	OnRequestError func(remoteAddr string, err error)
+
+	// ErrorResponder optionally renders the error responses the
+	// server generates itself, for example as branded HTML,
+	// instead of as plain text, or as a Problem for clients that
+	// accept JSON: 400 for malformed requests and PROXY
+	// headers, 404 from NotFound, 413 and 431 for oversized
+	// headers, 403 from AllowTunnel, 417 for unsupported
+	// expectations, 429 and 503 from RateLimiter and MaxConns,
+	// and 416 and 500 from FileServer.
+	// It is called with the status code and err describing the
+	// failure, and must write the response with that code. If the
+	// request could not be read, r is nil and the connection is
+	// closed after the response.
+	ErrorResponder func(w ResponseWriter, r *Request, code int, err error)
+
+	// RequestID, if non-nil, gives each request an ID, available
+	// from RequestIDFromContext and included in the access log and
+	// in error pages.
+	RequestID *RequestIDOptions
+
+	// GeoIP, if non-nil, locates the client of each request, at the
+	// address of its PROXY protocol header or else its RemoteAddr,
+	// for GeoFromContext and the access log.
+	GeoIP GeoIP
+
+	// BodyTransformers, if any, transform the bodies of the
+	// requests handlers read and of the responses they write, in
+	// turn, as they stream.
+	BodyTransformers []BodyTransformer
+
+	// AccessLog, if non-nil, is called after each request is
+	// answered, except on hijacked connections. The entry's String
+	// method formats it as a log line.
+	AccessLog func(*AccessLogEntry)
+
+	// Tracer, if non-nil, starts a span for handling each request.
+	Tracer Tracer
+
+	// AltSvc, if non-empty, lists the alternative services, such as
+	// HTTP/2 or HTTP/3 endpoints, advertised in an Alt-Svc header on
+	// each reply, instead of the listeners served by ServeQUIC.
+	// AltSvcHosts optionally overrides it for requests to the given
+	// host names, an empty list advertising none. Handlers may
+	// change or remove the header.
+	AltSvc      []AltService
+	AltSvcHosts map[string][]AltService
+
+	// ConnBufferSize is the size of the buffers each connection is
+	// read and written through, 4KB if zero. ResponseBufferSize is
+	// that of the buffer a response is collected in until its
+	// length is known or it is sent in chunks, 2KB if zero.
+	// Buffers are pooled and reused across connections and
+	// requests; smaller ones save memory when there are many
+	// connections, at the cost of more reads and writes.
+	ConnBufferSize     int
+	ResponseBufferSize int
+
+	mu            sync.Mutex
+	closed        bool
+	draining      int32 // ending keep-alive connections if 1; accessed atomically
+	listeners     map[net.Listener]bool
+	quic          map[QUICListener]bool // being served by ServeQUIC
+	quicAltSvc    atomic.Value            // string, the Alt-Svc header advertising quic
+	numConns      int32                   // accepted and not yet closed or hijacked; accessed atomically
+	rejectedConns uint64                  // over MaxConns under ConnLimitReject
+	reservedConns int                     // slots reserved by waitConnSlot
+	connsFree     *sync.Cond              // signaled when numConns drops
+	conns         [connShards]connShard   // being served
+	nextShard     uint32                  // for the next connection served, accessed atomically
+	upgradable    map[string]net.Listener // see listen
+	certStores    map[*CertStore]bool     // certificate files loaded by ListenAndServeTLS
+	tlsConfigs    map[*tls.Config]bool
+	ticketKeys    [][32]byte
+	ticketTimer   *time.Timer // next session ticket key rotation
+	ticketGen     uint64      // of the session ticket key rotation, bumped as it starts and stops
+	ticketRotate  bool        // while there are TLS listeners and SessionTicketRotation is set
+	alpnProtos    []string    // registered by RegisterALPN
+	alpnHandlers  map[string]func(*tls.Conn)
+}
+
+// serverHandler delegates to either the listener's Handler, the
+// server's Handler or DefaultServeMux and also handles "OPTIONS *"
+// requests.
 type serverHandler struct {
 	srv *Server
+	h   Handler // from ListenerOptions, or nil
 }
 
 func (sh serverHandler) ServeHTTP(rw ResponseWriter, req *Request) {
-	handler := sh.srv.Handler
+	handler := sh.h
+	if handler == nil {
+		handler = sh.srv.Handler
+	}
 	if handler == nil {
 		handler = DefaultServeMux
 	}
@@ -1605,7 +2212,7 @@ func (srv *Server) ListenAndServe() error {
 	if addr == "" {
 		addr = ":http"
 	}
-	l, e := net.Listen("tcp", addr)
+	l, e := srv.listen("tcp", addr, false)
 	if e != nil {
 		return e
 	}
@@ -1616,35 +2223,279 @@ func (srv *Server) ListenAndServe() error {
 // new service goroutine for each.  The service goroutines read requests and
 // then call srv.Handler to reply to them.
 func (srv *Server) Serve(l net.Listener) error {
+	return srv.serve(l, nil)
+}
+
+func (srv *Server) serve(l net.Listener, opts *ListenerOptions) error {
 	defer l.Close()
-	var tempDelay time.Duration // how long to sleep on accept failure
+	if !srv.trackListener(l, true) {
+		return ErrServerClosed
+	}
+	defer srv.trackListener(l, false)
+	n := srv.acceptors(opts)
+	if n == 1 {
+		return srv.acceptLoop(l, opts)
+	}
+	errc := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() { errc <- srv.acceptLoop(l, opts) }()
+	}
+	// The first to fail closes the listener, stopping the others.
+	err := <-errc
+	l.Close()
+	for i := 1; i < n; i++ {
+		<-errc
+	}
+	return err
+}
+
+// acceptors returns the number of goroutines that accept on a
+// listener configured by opts.
+func (srv *Server) acceptors(opts *ListenerOptions) int {
+	n := srv.Acceptors
+	if opts != nil && opts.Acceptors != 0 {
+		n = opts.Acceptors
+	}
+	switch {
+	case n < 0:
+		return runtime.GOMAXPROCS(0)
+	case n == 0:
+		return 1
+	}
+	return n
+}
+
+// acceptLoop accepts connections on l and serves each on its own
+// goroutine, until Accept fails.
+func (srv *Server) acceptLoop(l net.Listener, opts *ListenerOptions) error {
+	failures := 0 // consecutive temporary accept failures
 	for {
+		reserved := srv.waitConnSlot()
 		rw, e := l.Accept()
 		if e != nil {
+			if reserved {
+				srv.releaseConnSlot()
+			}
 			if ne, ok := e.(net.Error); ok && ne.Temporary() {
-				if tempDelay == 0 {
-					tempDelay = 5 * time.Millisecond
-				} else {
-					tempDelay *= 2
+				failures++
+				if max := srv.MaxAcceptErrors; max > 0 && failures >= max {
+					return e
 				}
-				if max := 1 * time.Second; tempDelay > max {
-					tempDelay = max
+				delay := srv.acceptBackoff(failures)
+				if fn := srv.OnAcceptError; fn != nil {
+					fn(e, failures, delay)
+				} else {
+					log.Printf("http: Accept error: %v; retrying in %v", e, delay)
 				}
-				log.Printf("http: Accept error: %v; retrying in %v", e, tempDelay)
-				time.Sleep(tempDelay)
+				time.Sleep(delay)
 				continue
 			}
+			if srv.isClosed() {
+				return ErrServerClosed
+			}
 			return e
 		}
-		tempDelay = 0
+		failures = 0
+		if to := srv.tcpOptions(opts); to != nil {
+			to.apply(rw)
+		}
+		if srv.IOUring {
+			rw = newRingConn(rw)
+		}
+		overLimit := srv.startConn(reserved)
+		if opts != nil && (opts.WrapConn != nil || opts.TLSConfig != nil) {
+			go srv.serveWrapped(rw, opts, overLimit)
+			continue
+		}
 		c, err := srv.newConn(rw)
 		if err != nil {
+			srv.endConn(overLimit)
 			continue
 		}
+		c.opts = opts
+		c.overLimit = overLimit
 		go c.serve()
 	}
 }
 
+func (srv *Server) acceptBackoff(n int) time.Duration {
+	if fn := srv.AcceptBackoff; fn != nil {
+		return fn(n)
+	}
+	delay := 5 * time.Millisecond
+	for i := 1; i < n && delay < time.Second; i++ {
+		delay *= 2
+	}
+	if delay > time.Second {
+		delay = time.Second
+	}
+	return delay
+}
+
+// ErrServerClosed is returned by Serve and the Server's other serving
+// methods after a call to Close.
+var ErrServerClosed = errors.New("http: Server closed")
+
+// Close immediately closes all listeners being served by srv, so that
+// Serve and the other serving methods return ErrServerClosed.
+// Connections that were already accepted are not interrupted. A closed
+// Server cannot serve again.
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	srv.closed = true
+	if srv.connsFree != nil {
+		srv.connsFree.Broadcast()
+	}
+	lns := make([]io.Closer, 0, len(srv.listeners)+len(srv.quic))
+	for l := range srv.listeners {
+		lns = append(lns, l)
+	}
+	for l := range srv.quic {
+		lns = append(lns, l)
+	}
+	srv.mu.Unlock()
+	var err error
+	for _, l := range lns {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// drain ends srv's keep-alive connections, closing those idle between
+// requests and closing the others after their current response, and
+// waits up to timeout for them to finish. Connections still open after
+// that are closed.
+func (srv *Server) drain(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	atomic.StoreInt32(&srv.draining, 1)
+	srv.eachConn(func(c *conn, rwc net.Conn) {
+		if c.idle {
+			rwc.Close()
+		}
+	})
+	for srv.trackedConns() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	srv.closeConns()
+}
+
+// Drain puts srv in lame-duck mode for the grace period d and then
+// closes it. While draining, srv keeps accepting connections and
+// serving requests, but responses carry "Connection: close", so that
+// clients move their keep-alive connections elsewhere, and Draining
+// reports true, so that health checks can tell load balancers to stop
+// sending traffic. After d, srv is closed, as Close does, and its
+// remaining connections are closed.
+func (srv *Server) Drain(d time.Duration) error {
+	atomic.StoreInt32(&srv.draining, 1)
+	time.Sleep(d)
+	err := srv.Close()
+	srv.closeConns()
+	return err
+}
+
+// Draining reports whether srv is draining its connections, after a
+// call to Drain or Upgrade.
+func (srv *Server) Draining() bool {
+	return atomic.LoadInt32(&srv.draining) != 0
+}
+
+// connShards is the number of shards of the set of connections a
+// Server serves. Each is locked on its own, so that connections
+// starting, ending and going idle seldom contend for a lock.
+const connShards = 32
+
+// A connShard is one shard of the connections a Server serves.
+type connShard struct {
+	mu    sync.Mutex
+	conns map[*conn]net.Conn // by their connections
+}
+
+// trackConn records c as being served by srv, until it is closed or
+// hijacked.
+func (srv *Server) trackConn(c *conn, add bool) {
+	if !add {
+		c.shard.mu.Lock()
+		delete(c.shard.conns, c)
+		c.shard.mu.Unlock()
+		return
+	}
+	c.shard = &srv.conns[atomic.AddUint32(&srv.nextShard, 1)%connShards]
+	c.shard.mu.Lock()
+	defer c.shard.mu.Unlock()
+	if c.shard.conns == nil {
+		c.shard.conns = make(map[*conn]net.Conn)
+	}
+	c.shard.conns[c] = c.rwc
+}
+
+// eachConn calls fn for each connection srv serves, with the lock of
+// its shard held.
+func (srv *Server) eachConn(fn func(c *conn, rwc net.Conn)) {
+	for i := range srv.conns {
+		sh := &srv.conns[i]
+		sh.mu.Lock()
+		for c, rwc := range sh.conns {
+			fn(c, rwc)
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// trackedConns returns the number of connections srv serves.
+func (srv *Server) trackedConns() int {
+	n := 0
+	for i := range srv.conns {
+		sh := &srv.conns[i]
+		sh.mu.Lock()
+		n += len(sh.conns)
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// closeConns closes the connections srv serves.
+func (srv *Server) closeConns() {
+	srv.eachConn(func(_ *conn, rwc net.Conn) { rwc.Close() })
+}
+
+// setConnIdle marks c as idle between requests or not, reporting false
+// if srv is draining and c should be closed instead of waiting for its
+// next request.
+func (srv *Server) setConnIdle(c *conn, idle bool) bool {
+	c.shard.mu.Lock()
+	defer c.shard.mu.Unlock()
+	c.idle = idle
+	return !(idle && srv.Draining())
+}
+
+func (srv *Server) isClosed() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.closed
+}
+
+// trackListener records l as being served, reporting false if srv is
+// closed.
+func (srv *Server) trackListener(l net.Listener, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if !add {
+		delete(srv.listeners, l)
+		return true
+	}
+	if srv.closed {
+		return false
+	}
+	if srv.listeners == nil {
+		srv.listeners = make(map[net.Listener]bool)
+	}
+	srv.listeners[l] = true
+	return true
+}
+
 // ListenAndServe listens on the TCP network address addr
 // and then calls Serve with handler to handle requests
 // on incoming connections.  Handler is typically nil,
@@ -1717,6 +2568,11 @@ func ListenAndServeTLS(addr string, certFile string, keyFile string, handler Han
 // the server must be provided. If the certificate is signed by a
 // certificate authority, the certFile should be the concatenation
 // of the server's certificate followed by the CA's certificate.
+// If srv.TLSConfig already provides Certificates or GetCertificate
+// (from a CertStore, for example), certFile and keyFile may be empty.
+// Otherwise its Certificates are replaced, and its GetCertificate is
+// asked first, the file's certificate being used for handshakes it
+// returns neither a certificate nor an error for.
 //
 // If srv.Addr is blank, ":https" is used.
 func (srv *Server) ListenAndServeTLS(certFile, keyFile string) error {
@@ -1731,23 +2587,150 @@ func (srv *Server) ListenAndServeTLS(certFile, keyFile string) error {
 	if config.NextProtos == nil {
 		config.NextProtos = []string{"http/1.1"}
 	}
+	config.NextProtos = srv.nextProtos(config.NextProtos)
+	srv.setClientAuth(config)
 
-	var err error
-	config.Certificates = make([]tls.Certificate, 1)
-	config.Certificates[0], err = tls.LoadX509KeyPair(certFile, keyFile)
-	if err != nil {
-		return err
+	haveCerts := len(config.Certificates) > 0 || config.GetCertificate != nil
+	if !haveCerts || certFile != "" || keyFile != "" {
+		store := new(CertStore)
+		if err := store.AddFile(certFile, keyFile); err != nil {
+			return err
+		}
+		config.Certificates = nil
+		if get := config.GetCertificate; get != nil {
+			config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				if cert, err := get(hello); cert != nil || err != nil {
+					return cert, err
+				}
+				return store.GetCertificate(hello)
+			}
+		} else {
+			config.GetCertificate = store.GetCertificate
+		}
+		srv.trackCertStore(store, true)
+		defer srv.trackCertStore(store, false)
 	}
+	srv.setOCSPStapling(config)
 
-	conn, err := net.Listen("tcp", addr)
+	conn, err := srv.listen("tcp", addr, false)
 	if err != nil {
 		return err
 	}
+	srv.trackTLSConfig(config, true)
+	defer srv.trackTLSConfig(config, false)
 
 	tlsListener := tls.NewListener(conn, config)
 	return srv.Serve(tlsListener)
 }
 
+// RegisterALPN arranges for TLS connections that negotiate the ALPN
+// protocol proto to be passed to handler instead of being served as
+// HTTP, so that other protocols can share the server's listeners. It
+// is a simpler form of setting srv.TLSNextProto[proto], which it
+// takes precedence over, that also advertises proto, ahead of HTTP,
+// on the listeners started by ListenAndServeTLS and ServeAutoTLS.
+// Listeners created by the caller must list proto in their
+// tls.Config.NextProtos.
+//
+// The connection is closed when handler returns. RegisterALPN must be
+// called before the server starts. It panics if proto is empty or
+// names HTTP/1.
+func (srv *Server) RegisterALPN(proto string, handler func(conn *tls.Conn)) {
+	if !validNPN(proto) {
+		panic("http: RegisterALPN with invalid protocol " + strconv.Quote(proto))
+	}
+	if handler == nil {
+		panic("http: RegisterALPN with nil handler")
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.alpnHandlers == nil {
+		srv.alpnHandlers = make(map[string]func(*tls.Conn))
+	}
+	if _, dup := srv.alpnHandlers[proto]; !dup {
+		srv.alpnProtos = append(srv.alpnProtos, proto)
+	}
+	srv.alpnHandlers[proto] = handler
+}
+
+// alpnHandler returns the handler registered by RegisterALPN for
+// proto, or nil.
+func (srv *Server) alpnHandler(proto string) func(*tls.Conn) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.alpnHandlers[proto]
+}
+
+// nextProtos returns protos with the protocols registered by
+// RegisterALPN in front.
+func (srv *Server) nextProtos(protos []string) []string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.alpnProtos) == 0 {
+		return protos
+	}
+	out := append([]string(nil), srv.alpnProtos...)
+	for _, p := range protos {
+		dup := false
+		for _, q := range srv.alpnProtos {
+			dup = dup || p == q
+		}
+		if !dup {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// setClientAuth applies srv.ClientCAs to config.
+func (srv *Server) setClientAuth(config *tls.Config) {
+	if srv.ClientCAs == nil {
+		return
+	}
+	config.ClientCAs = srv.ClientCAs
+	if config.ClientAuth == tls.NoClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+}
+
+func (srv *Server) trackCertStore(s *CertStore, add bool) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.certStores == nil {
+		srv.certStores = make(map[*CertStore]bool)
+	}
+	if add {
+		srv.certStores[s] = true
+	} else {
+		delete(srv.certStores, s)
+	}
+}
+
+// ReloadTLSCertificates reads the certificate and key files passed to
+// running calls of ListenAndServeTLS again. New TLS handshakes use the
+// reloaded certificates; established connections, including
+// long-lived streams and hijacked connections, are left alone. If a
+// pair fails to load, its previous certificate stays in use and the
+// first error is returned.
+//
+// Certificates supplied through srv.TLSConfig are not reloaded; use a
+// CertStore to rotate those.
+func (srv *Server) ReloadTLSCertificates() error {
+	srv.mu.Lock()
+	stores := make([]*CertStore, 0, len(srv.certStores))
+	for s := range srv.certStores {
+		stores = append(stores, s)
+	}
+	srv.mu.Unlock()
+	var firstErr error
+	for _, s := range stores {
+		if err := s.Reload(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 // TimeoutHandler returns a Handler that runs h with the given time limit.
 //
 // The new Handler calls h.ServeHTTP to handle each request, but if a
@@ -1791,6 +2774,8 @@ func (h *timeoutHandler) ServeHTTP(w ResponseWriter, r *Request) {
 	case <-done:
 		return
 	case <-h.timeout():
+		// The handler goes on using r and tw.w.
+		r.Retain()
 		tw.mu.Lock()
 		defer tw.mu.Unlock()
 		if !tw.wroteHeader {
@@ -1850,23 +2835,26 @@ func (globalOptionsHandler) ServeHTTP(w ResponseWriter, r *Request) {
 	}
 }
 
-// eofReader is a non-nil io.ReadCloser that always returns EOF.
-// It embeds a *strings.Reader so it still has a WriteTo method
-// and io.Copy won't need a buffer.
-var eofReader = &struct {
-	*strings.Reader
-	io.Closer
-}{
-	strings.NewReader(""),
-	ioutil.NopCloser(nil),
-}
+// NoBody is an io.ReadCloser with no bytes: Read always returns EOF
+// and Close always returns nil. It is the Body of the requests and
+// responses read without one, and can be used as the Body of an
+// outgoing request to say that it has none, so that it can be sent
+// again without GetBody.
+var NoBody = emptyBody{}
+
+type emptyBody struct{}
+
+func (emptyBody) Read([]byte) (int, error)         { return 0, io.EOF }
+func (emptyBody) Close() error                     { return nil }
+func (emptyBody) WriteTo(io.Writer) (int64, error) { return 0, nil }
 
 // initNPNRequest is an HTTP handler that initializes certain
 // uninitialized fields in its *Request. Such partially-initialized
 // Requests come from NPN protocol handlers.
 type initNPNRequest struct {
-	c *tls.Conn
-	h serverHandler
+	c          *tls.Conn
+	h          serverHandler
+	remoteAddr string // of the outermost connection
 }
 
 func (h initNPNRequest) ServeHTTP(rw ResponseWriter, req *Request) {
@@ -1875,10 +2863,10 @@ func (h initNPNRequest) ServeHTTP(rw ResponseWriter, req *Request) {
 		*req.TLS = h.c.ConnectionState()
 	}
 	if req.Body == nil {
-		req.Body = eofReader
+		req.Body = NoBody
 	}
 	if req.RemoteAddr == "" {
-		req.RemoteAddr = h.c.RemoteAddr().String()
+		req.RemoteAddr = h.remoteAddr
 	}
 	h.h.ServeHTTP(rw, req)
 }
@@ -0,0 +1,348 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sessions implements server-side HTTP sessions, identified by
+// a cookie and kept by a pluggable Store.
+//
+// A Manager's Handler wraps the handlers using sessions, which get the
+// session of each request with Get:
+//
+//	m := &sessions.Manager{Store: new(sessions.MemoryStore)}
+//	http.Handle("/", m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+//		s := sessions.Get(r)
+//		s.Set("visited", "yes")
+//		...
+//	})))
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultIdleTimeout is the IdleTimeout of Managers that don't set it.
+const DefaultIdleTimeout = 24 * time.Hour
+
+// idLen is the length of session IDs: 32 random bytes in base64.
+const idLen = 43
+
+// A Manager loads and saves the sessions of the requests of the
+// handlers it wraps.
+type Manager struct {
+	// Store keeps the sessions.
+	Store Store
+
+	// Codec, if non-nil, signs, and possibly encrypts, the session
+	// IDs in cookies, so that IDs clients make up are rejected
+	// without reaching the Store. As cookies are set only for new
+	// sessions, its MaxAge limits how long a session may last.
+	Codec *http.CookieCodec
+
+	// Cookie is the template of the session cookies. If its Name
+	// is empty, "session" is used, and if its Path is empty, "/".
+	// Session cookies are always HttpOnly. Its Value is ignored.
+	Cookie http.Cookie
+
+	// IdleTimeout is how long a session lasts after the last
+	// request using it. If zero, DefaultIdleTimeout is used.
+	IdleTimeout time.Duration
+
+	// ErrorLog, if non-nil, is called with the errors of the Store,
+	// which otherwise are logged with the log package. A session
+	// that fails to load is replaced by a new one.
+	ErrorLog func(r *http.Request, err error)
+}
+
+type contextKey struct{}
+
+// Get returns the session of r, from the Handler of a Manager, or nil
+// if r didn't pass through one.
+func Get(r *http.Request) *Session {
+	s, _ := r.Context().Value(contextKey{}).(*Session)
+	return s
+}
+
+// A Session is the state kept across the requests of a client. Its
+// methods are safe for use by multiple goroutines, but only until the
+// handler returns, when it is saved.
+//
+// The session cookie is set as the response header is written, so
+// Renew, Destroy and the first Set of a new session must come before.
+type Session struct {
+	mu        sync.Mutex
+	id        string
+	oldID     string // replaced by Renew, to be deleted
+	values    map[string]string
+	expires   time.Time // of the record loaded, or zero for a new session
+	changed   bool
+	destroyed bool
+	committed bool // the response header was written
+	hasCookie bool // the client has, or is sent, the cookie of id
+}
+
+// ID returns the ID of s.
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// IsNew reports whether s was created for its request, rather than
+// loaded from the Store.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expires.IsZero()
+}
+
+// Get returns the value of the key of s, or "" if there is none.
+func (s *Session) Get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+// Set sets the value of the key of s. A new session is saved, and
+// gets its cookie, only if it has values.
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.changed = true
+}
+
+// Delete deletes the key of s.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.changed = true
+	}
+}
+
+// Renew gives s a new ID, keeping its values, as handlers should when
+// the privileges of the session change, such as on logging in, since
+// the old ID may have been planted by an attacker.
+func (s *Session) Renew() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.oldID == "" && !s.expires.IsZero() {
+		s.oldID = s.id
+	}
+	s.id = newID()
+	s.changed = true
+}
+
+// Destroy ends s: it's deleted from the Store and its cookie expired.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+}
+
+func newID() string {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("sessions: can't generate session ID: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+// validID reports whether id may be one made by newID, and so is safe
+// to give a Store.
+func validID(id string) bool {
+	if len(id) != idLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		switch c := id[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Manager) cookieName() string {
+	if m.Cookie.Name == "" {
+		return "session"
+	}
+	return m.Cookie.Name
+}
+
+func (m *Manager) idleTimeout() time.Duration {
+	if m.IdleTimeout == 0 {
+		return DefaultIdleTimeout
+	}
+	return m.IdleTimeout
+}
+
+func (m *Manager) logf(r *http.Request, err error) {
+	if m.ErrorLog != nil {
+		m.ErrorLog(r, err)
+		return
+	}
+	log.Printf("sessions: %v", err)
+}
+
+// Handler returns a handler giving the requests it passes to h their
+// sessions, which it saves once h returns.
+func (m *Manager) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := m.load(r)
+		sw := &sessionWriter{ResponseWriter: w, m: m, s: s, r: r}
+		h.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), contextKey{}, s)))
+		sw.setCookie()
+		m.save(r, s)
+	})
+}
+
+// load returns the session named by the cookie of r, if any and still
+// in the Store, or a new one.
+func (m *Manager) load(r *http.Request) *Session {
+	if id := m.cookieID(r); id != "" {
+		rec, err := m.Store.Load(id)
+		if err != nil {
+			m.logf(r, err)
+		}
+		if rec != nil {
+			if rec.Values == nil {
+				rec.Values = make(map[string]string)
+			}
+			return &Session{id: id, values: rec.Values, expires: rec.Expires}
+		}
+	}
+	return &Session{id: newID(), values: make(map[string]string)}
+}
+
+func (m *Manager) cookieID(r *http.Request) string {
+	name := m.cookieName()
+	c, err := r.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	id := c.Value
+	if m.Codec != nil {
+		b, err := m.Codec.Decode(name, id)
+		if err != nil {
+			return ""
+		}
+		id = string(b)
+	}
+	if !validID(id) {
+		return ""
+	}
+	return id
+}
+
+// save saves s, or deletes it if destroyed, once the handler returned.
+// Unchanged sessions are saved again only once half their idle timeout
+// passed, to extend it.
+func (m *Manager) save(r *http.Request, s *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	isNew := s.expires.IsZero()
+	if s.oldID != "" {
+		if err := m.Store.Delete(s.oldID); err != nil {
+			m.logf(r, err)
+		}
+	}
+	if s.destroyed {
+		if !isNew {
+			if err := m.Store.Delete(s.id); err != nil {
+				m.logf(r, err)
+			}
+		}
+		return
+	}
+	if !s.hasCookie {
+		return
+	}
+	timeout := m.idleTimeout()
+	if !s.changed && time.Until(s.expires) > timeout/2 {
+		return
+	}
+	rec := &Record{Values: s.values, Expires: time.Now().Add(timeout)}
+	if err := m.Store.Save(s.id, rec); err != nil {
+		m.logf(r, err)
+	}
+}
+
+// A sessionWriter sets the session cookie, if any, as the response
+// header is written.
+type sessionWriter struct {
+	http.ResponseWriter
+	m *Manager
+	s *Session
+	r *http.Request
+}
+
+func (w *sessionWriter) WriteHeader(code int) {
+	w.setCookie()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sessionWriter) Write(p []byte) (int, error) {
+	w.setCookie()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *sessionWriter) Flush() {
+	w.setCookie()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *sessionWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// setCookie sets the session cookie, once: for a new session with
+// values or one renewed, or an expired one for a session destroyed.
+func (w *sessionWriter) setCookie() {
+	s := w.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.committed {
+		return
+	}
+	s.committed = true
+	isNew := s.expires.IsZero()
+	c := w.m.Cookie
+	c.Name = w.m.cookieName()
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	c.HttpOnly = true
+	switch {
+	case s.destroyed:
+		if isNew && s.oldID == "" {
+			return
+		}
+		c.Value, c.MaxAge, c.Expires = "", -1, time.Time{}
+	case !isNew && s.oldID == "":
+		s.hasCookie = true
+		return
+	case isNew && len(s.values) == 0:
+		return
+	default:
+		c.Value = s.id
+		if w.m.Codec != nil {
+			v, err := w.m.Codec.Encode(c.Name, []byte(s.id))
+			if err != nil {
+				w.m.logf(w.r, err)
+				return
+			}
+			c.Value = v
+		}
+		s.hasCookie = true
+	}
+	http.SetCookie(w.ResponseWriter, &c)
+}
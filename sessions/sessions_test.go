@@ -0,0 +1,138 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sessions
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestServer returns a server of m whose handler runs the
+// operations named by the path of each request on its session, such
+// as "/set/k/v", "/renew" or "/destroy", and writes the session's
+// value of "k".
+func newTestServer(m *Manager) *httptest.Server {
+	return httptest.NewServer(m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := Get(r)
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		switch parts[0] {
+		case "set":
+			s.Set(parts[1], parts[2])
+		case "delete":
+			s.Delete(parts[1])
+		case "renew":
+			s.Renew()
+		case "destroy":
+			s.Destroy()
+		}
+		io.WriteString(w, s.Get("k"))
+	})))
+}
+
+// get requests path from ts with c, returning the body and the
+// Set-Cookie header of the response.
+func get(t *testing.T, c *http.Client, ts *httptest.Server, path string) (body, setCookie string) {
+	res, err := c.Get(ts.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	return string(b), res.Header.Get("Set-Cookie")
+}
+
+func TestManager(t *testing.T) {
+	store := new(MemoryStore)
+	m := &Manager{Store: store}
+	ts := newTestServer(m)
+	defer ts.Close()
+	jar, _ := cookiejar.New(nil)
+	c := &http.Client{Jar: jar}
+
+	if body, sc := get(t, c, ts, "/"); body != "" || sc != "" || store.Len() != 0 {
+		t.Fatalf("empty session: body %q, Set-Cookie %q, %d stored; want none", body, sc, store.Len())
+	}
+	body, sc := get(t, c, ts, "/set/k/v1")
+	if body != "v1" || !strings.HasPrefix(sc, "session=") || !strings.Contains(sc, "HttpOnly") || store.Len() != 1 {
+		t.Fatalf("Set: body %q, Set-Cookie %q, %d stored", body, sc, store.Len())
+	}
+	id := strings.TrimPrefix(strings.SplitN(sc, ";", 2)[0], "session=")
+	if body, sc := get(t, c, ts, "/"); body != "v1" || sc != "" {
+		t.Errorf("next request: body %q, Set-Cookie %q; want v1 and no cookie", body, sc)
+	}
+
+	body, sc = get(t, c, ts, "/renew")
+	if body != "v1" || sc == "" || strings.Contains(sc, id) {
+		t.Errorf("Renew: body %q, Set-Cookie %q; want v1 and a new ID", body, sc)
+	}
+	if rec, _ := store.Load(id); rec != nil || store.Len() != 1 {
+		t.Errorf("Renew kept the old session: %v, %d stored", rec, store.Len())
+	}
+	if body, _ := get(t, c, ts, "/set/k/v2"); body != "v2" {
+		t.Errorf("Set after Renew: body %q; want v2", body)
+	}
+
+	body, sc = get(t, c, ts, "/destroy")
+	if !strings.Contains(sc, "Max-Age=0") || store.Len() != 0 {
+		t.Errorf("Destroy: Set-Cookie %q, %d stored; want cookie expired and none", sc, store.Len())
+	}
+	if body, _ := get(t, c, ts, "/"); body != "" {
+		t.Errorf("after Destroy: body %q; want a new session", body)
+	}
+}
+
+func TestManagerCodec(t *testing.T) {
+	store := new(MemoryStore)
+	m := &Manager{
+		Store:  store,
+		Codec:  &http.CookieCodec{HashKeys: [][]byte{[]byte("secret")}},
+		Cookie: http.Cookie{Name: "sid", Path: "/app"},
+	}
+	ts := newTestServer(m)
+	defer ts.Close()
+
+	_, sc := get(t, http.DefaultClient, ts, "/set/k/v")
+	if !strings.HasPrefix(sc, "sid=") || !strings.Contains(sc, "Path=/app") {
+		t.Fatalf("Set-Cookie = %q; want sid with Path=/app", sc)
+	}
+	value := strings.TrimPrefix(strings.SplitN(sc, ";", 2)[0], "sid=")
+	if v, err := m.Codec.Decode("sid", value); err != nil || !validID(string(v)) {
+		t.Errorf("cookie %q decodes to %q, %v; want a session ID", value, v, err)
+	}
+
+	for _, tt := range []struct{ value, want string }{
+		{value, "v"},
+		{value[:len(value)-2], ""},
+		{newID(), ""},
+	} {
+		req, _ := http.NewRequest("GET", ts.URL+"/", nil)
+		req.AddCookie(&http.Cookie{Name: "sid", Value: tt.value})
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if string(b) != tt.want {
+			t.Errorf("cookie %q: body %q; want %q", tt.value, b, tt.want)
+		}
+	}
+}
+
+func TestValidID(t *testing.T) {
+	if id := newID(); !validID(id) {
+		t.Errorf("validID(%q) = false", id)
+	}
+	for _, id := range []string{"", "short", strings.Repeat("a", 42) + "/", strings.Repeat("a", 44)} {
+		if validID(id) {
+			t.Errorf("validID(%q) = true", id)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sessions
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A Store keeps the records of sessions by ID. IDs are made of
+// letters, digits, '-' and '_' only.
+//
+// Implementations of Store, such as ones keeping sessions in an
+// external database, must be safe for concurrent use by multiple
+// goroutines.
+type Store interface {
+	// Load returns the record saved for the session id, or nil if
+	// there is none or it expired.
+	Load(id string) (*Record, error)
+
+	// Save saves r as the record of the session id, replacing any
+	// saved before.
+	Save(id string, r *Record) error
+
+	// Delete deletes the record of the session id, if any.
+	Delete(id string) error
+}
+
+// A Record is the state of a session kept by a Store.
+type Record struct {
+	Values  map[string]string
+	Expires time.Time // when the Store may forget the session
+}
+
+// sweepInterval is how often a MemoryStore drops expired sessions.
+const sweepInterval = time.Minute
+
+// A MemoryStore is a Store keeping sessions in memory. The zero value
+// is an empty store ready to use.
+type MemoryStore struct {
+	mu        sync.Mutex
+	records   map[string]Record
+	lastSweep time.Time
+}
+
+// Load implements the Load method of the Store interface.
+func (s *MemoryStore) Load(id string) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[id]
+	if !ok {
+		return nil, nil
+	}
+	if !r.Expires.After(time.Now()) {
+		delete(s.records, id)
+		return nil, nil
+	}
+	r.Values = copyValues(r.Values)
+	return &r, nil
+}
+
+// Save implements the Save method of the Store interface. Sessions
+// that expired are dropped from time to time.
+func (s *MemoryStore) Save(id string, r *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.records == nil {
+		s.records = make(map[string]Record)
+	}
+	now := time.Now()
+	if now.Sub(s.lastSweep) > sweepInterval {
+		for id, r := range s.records {
+			if !r.Expires.After(now) {
+				delete(s.records, id)
+			}
+		}
+		s.lastSweep = now
+	}
+	s.records[id] = Record{Values: copyValues(r.Values), Expires: r.Expires}
+	return nil
+}
+
+// Delete implements the Delete method of the Store interface.
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, id)
+	return nil
+}
+
+// Len returns the number of sessions in s, expired or not.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.records)
+}
+
+func copyValues(v map[string]string) map[string]string {
+	c := make(map[string]string, len(v))
+	for k, s := range v {
+		c[k] = s
+	}
+	return c
+}
+
+// FileStore is a Store keeping each session in a JSON file, named by
+// its ID, in the directory FileStore names. Expired sessions' files
+// are removed when loaded.
+type FileStore string
+
+func (d FileStore) path(id string) string {
+	return filepath.Join(string(d), id+".json")
+}
+
+// Load implements the Load method of the Store interface.
+func (d FileStore) Load(id string) (*Record, error) {
+	b, err := ioutil.ReadFile(d.path(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := new(Record)
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, err
+	}
+	if !r.Expires.After(time.Now()) {
+		os.Remove(d.path(id))
+		return nil, nil
+	}
+	return r, nil
+}
+
+// Save implements the Save method of the Store interface. The file is
+// replaced atomically, and readable only by its owner.
+func (d FileStore) Save(id string, r *Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(string(d), id+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.path(id))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Delete implements the Delete method of the Store interface.
+func (d FileStore) Delete(id string) error {
+	err := os.Remove(d.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
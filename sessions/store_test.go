@@ -0,0 +1,63 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sessions
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, s Store) {
+	id := newID()
+	if rec, err := s.Load(id); rec != nil || err != nil {
+		t.Fatalf("Load of unknown session = %v, %v; want nil, nil", rec, err)
+	}
+	rec := &Record{Values: map[string]string{"a": "1"}, Expires: time.Now().Add(time.Hour)}
+	if err := s.Save(id, rec); err != nil {
+		t.Fatal(err)
+	}
+	rec.Values["a"] = "changed"
+	got, err := s.Load(id)
+	if err != nil || got == nil || !reflect.DeepEqual(got.Values, map[string]string{"a": "1"}) {
+		t.Fatalf("Load = %v, %v; want the values saved", got, err)
+	}
+	if err := s.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := s.Load(id); rec != nil {
+		t.Errorf("Load after Delete = %v; want nil", rec)
+	}
+	if err := s.Delete(id); err != nil {
+		t.Errorf("second Delete: %v", err)
+	}
+
+	s.Save(id, &Record{Expires: time.Now().Add(-time.Second)})
+	if rec, _ := s.Load(id); rec != nil {
+		t.Errorf("Load of expired session = %v; want nil", rec)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := new(MemoryStore)
+	testStore(t, s)
+	if n := s.Len(); n != 0 {
+		t.Errorf("Len = %d after expired session loaded; want 0", n)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testStore(t, FileStore(dir))
+	if names, _ := ioutil.ReadDir(dir); len(names) != 0 {
+		t.Errorf("%d files left; want none", len(names))
+	}
+}
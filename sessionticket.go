@@ -0,0 +1,126 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// TLS session ticket key management.
+
+package http
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"log"
+	"time"
+)
+
+// sessionTicketKeysKept is how many keys rotation keeps: the current
+// key, which encrypts new tickets, and the previous ones, which still
+// decrypt tickets issued before the last rotations.
+const sessionTicketKeysKept = 3
+
+// SetSessionTicketKeys sets the TLS session ticket keys of the
+// listeners started by ListenAndServeTLS and ServeAutoTLS, including
+// those already running. The first key encrypts new tickets; all of
+// them are tried when decrypting. Servers sharing keys can resume each
+// other's sessions.
+//
+// SetSessionTicketKeys panics if keys is empty.
+func (srv *Server) SetSessionTicketKeys(keys [][32]byte) {
+	if len(keys) == 0 {
+		panic("http: SetSessionTicketKeys with no keys")
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.setTicketKeysLocked(keys)
+}
+
+func (srv *Server) setTicketKeysLocked(keys [][32]byte) {
+	srv.ticketKeys = append([][32]byte(nil), keys...)
+	for config := range srv.tlsConfigs {
+		config.SetSessionTicketKeys(srv.ticketKeys)
+	}
+}
+
+// trackTLSConfig registers a TLS configuration in use by a listener so
+// it receives session ticket keys, starting rotation with the first
+// listener and stopping it with the last.
+func (srv *Server) trackTLSConfig(config *tls.Config, add bool) {
+	srv.mu.Lock()
+	if !add {
+		delete(srv.tlsConfigs, config)
+		if len(srv.tlsConfigs) == 0 && srv.ticketRotate {
+			if srv.ticketTimer != nil {
+				srv.ticketTimer.Stop()
+				srv.ticketTimer = nil
+			}
+			srv.ticketRotate = false
+			srv.ticketGen++
+		}
+		srv.mu.Unlock()
+		return
+	}
+	if srv.tlsConfigs == nil {
+		srv.tlsConfigs = make(map[*tls.Config]bool)
+	}
+	srv.tlsConfigs[config] = true
+	if srv.ticketKeys != nil {
+		config.SetSessionTicketKeys(srv.ticketKeys)
+	}
+	start := srv.SessionTicketRotation > 0 && !srv.ticketRotate
+	if start {
+		srv.ticketRotate = true
+		srv.ticketGen++
+	}
+	gen := srv.ticketGen
+	srv.mu.Unlock()
+	if start {
+		srv.rotateTicketKeys(gen)
+	}
+}
+
+// rotateTicketKeys installs the next set of session ticket keys and
+// schedules the following rotation, unless the rotation of generation
+// gen was stopped meanwhile. The SessionTicketKeySource is called
+// without holding srv.mu, which handshakes and Close also take.
+func (srv *Server) rotateTicketKeys(gen uint64) {
+	var keys [][32]byte
+	var err error
+	src := srv.SessionTicketKeySource
+	if src != nil {
+		keys, err = src()
+		if err == nil && len(keys) == 0 {
+			err = errors.New("key source returned no keys")
+		}
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.ticketGen != gen {
+		return
+	}
+	if src == nil {
+		keys, err = srv.randomTicketKeysLocked()
+	}
+	if err != nil {
+		log.Printf("http: rotating session ticket keys: %v", err)
+	} else {
+		srv.setTicketKeysLocked(keys)
+	}
+	srv.ticketTimer = time.AfterFunc(srv.SessionTicketRotation, func() {
+		srv.rotateTicketKeys(gen)
+	})
+}
+
+// randomTicketKeysLocked returns a new random key followed by the
+// current ones that rotation keeps.
+func (srv *Server) randomTicketKeysLocked() ([][32]byte, error) {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return nil, err
+	}
+	keys := append([][32]byte{key}, srv.ticketKeys...)
+	if len(keys) > sessionTicketKeysKept {
+		keys = keys[:sessionTicketKeysKept]
+	}
+	return keys, nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	. "net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveTicketTest starts srv on TLS with a certificate from ca and
+// returns a function reporting whether a handshake resumed a session
+// from cache.
+func serveTicketTest(t *testing.T, srv *Server, ca *testCA) (dial func(cache tls.ClientSessionCache) bool, cleanup func()) {
+	dir, err := ioutil.TempDir("", "tickets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_, c, k := ca.issue(t, "example.com")
+	writeFile(t, certFile, c)
+	writeFile(t, keyFile, k)
+	srv.Handler = HandlerFunc(func(w ResponseWriter, r *Request) {})
+	addr := listenAndServeTLSTest(t, srv, certFile, keyFile)
+	dial = func(cache tls.ClientSessionCache) bool {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{
+			ServerName:         "example.com",
+			RootCAs:            ca.pool(),
+			ClientSessionCache: cache,
+			MaxVersion:         tls.VersionTLS12, // tickets arrive during the handshake
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().DidResume
+	}
+	return dial, func() { os.RemoveAll(dir) }
+}
+
+func TestServerSetSessionTicketKeys(t *testing.T) {
+	defer afterTest(t)
+	ca := newTestCA(t)
+	srv := new(Server)
+	srv.SetSessionTicketKeys([][32]byte{{1}})
+	dial, cleanup := serveTicketTest(t, srv, ca)
+	defer cleanup()
+
+	cache := tls.NewLRUClientSessionCache(1)
+	if dial(cache) {
+		t.Fatal("first handshake resumed a session")
+	}
+	if !dial(cache) {
+		t.Fatal("second handshake did not resume")
+	}
+
+	srv.SetSessionTicketKeys([][32]byte{{2}})
+	if dial(cache) {
+		t.Error("resumed with a ticket encrypted under a retired key")
+	}
+	if !dial(cache) {
+		t.Error("did not resume with a ticket for the new key")
+	}
+
+	// Previous keys still decrypt.
+	srv.SetSessionTicketKeys([][32]byte{{3}, {2}})
+	if !dial(cache) {
+		t.Error("did not resume with a ticket encrypted under the second key")
+	}
+}
+
+func TestServerSessionTicketRotation(t *testing.T) {
+	defer afterTest(t)
+	ca := newTestCA(t)
+	var keys atomic.Value
+	keys.Store([][32]byte{{1}})
+	var calls int32
+	srv := &Server{
+		SessionTicketRotation: 10 * time.Millisecond,
+		SessionTicketKeySource: func() ([][32]byte, error) {
+			atomic.AddInt32(&calls, 1)
+			return keys.Load().([][32]byte), nil
+		},
+	}
+	dial, cleanup := serveTicketTest(t, srv, ca)
+	defer cleanup()
+
+	// A second server sharing the key source resumes the first
+	// one's sessions.
+	srv2 := &Server{
+		SessionTicketRotation:  srv.SessionTicketRotation,
+		SessionTicketKeySource: srv.SessionTicketKeySource,
+	}
+	dial2, cleanup2 := serveTicketTest(t, srv2, ca)
+	defer cleanup2()
+
+	cache := tls.NewLRUClientSessionCache(1)
+	dial(cache)
+	if !dial2(cache) {
+		t.Fatal("server sharing the key source did not resume the session")
+	}
+
+	keys.Store([][32]byte{{2}})
+	deadline := time.Now().Add(5 * time.Second)
+	for dial(cache) {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the rotated keys to take effect")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&calls); n < 3 {
+		t.Errorf("key source called %d times; want repeated rotations", n)
+	}
+}
+
+// A key source that is slow to answer holds up neither handshakes nor
+// SetSessionTicketKeys.
+func TestServerSessionTicketSlowSource(t *testing.T) {
+	defer afterTest(t)
+	ca := newTestCA(t)
+	var calls int32
+	release := make(chan bool)
+	defer close(release)
+	srv := &Server{
+		SessionTicketRotation: time.Millisecond,
+		SessionTicketKeySource: func() ([][32]byte, error) {
+			if atomic.AddInt32(&calls, 1) > 1 {
+				<-release
+			}
+			return [][32]byte{{1}}, nil
+		},
+	}
+	dial, cleanup := serveTicketTest(t, srv, ca)
+	defer cleanup()
+	for atomic.LoadInt32(&calls) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan bool)
+	go func() {
+		srv.SetSessionTicketKeys([][32]byte{{2}})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SetSessionTicketKeys waited for the key source")
+	}
+	dial(nil)
+}
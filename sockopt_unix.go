@@ -0,0 +1,39 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package http
+
+import (
+	"net"
+	"syscall"
+)
+
+// setNonblock puts l's socket back in non-blocking mode after passing
+// it to a child process, which puts the descriptor, shared with the
+// child, in blocking mode.
+func setNonblock(l net.Listener) {
+	sc, ok := l.(interface {
+		SyscallConn() (syscall.RawConn, error)
+	})
+	if !ok {
+		return
+	}
+	if rc, err := sc.SyscallConn(); err == nil {
+		rc.Control(func(fd uintptr) { syscall.SetNonblock(int(fd), true) })
+	}
+}
+
+// setSockoptInt sets an integer socket option on c's socket.
+func setSockoptInt(c syscall.RawConn, level, opt, value int) error {
+	var serr error
+	if err := c.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), level, opt, value)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,155 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// SOCKS5 client, for the Transport's socks5 proxies. See RFC 1928
+// and, for username/password authentication, RFC 1929.
+
+package http
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+const (
+	socks5Version = 5
+
+	socksAuthNone     = 0x00
+	socksAuthPassword = 0x02
+	socksNoAcceptable = 0xff
+
+	socksConnect = 1
+
+	socksAddrIPv4   = 1
+	socksAddrDomain = 3
+	socksAddrIPv6   = 4
+)
+
+var socksReplyText = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5Connect has the SOCKS5 proxy at proxyURL, to which conn is
+// connected, connect to addr, a "host:port". Afterwards conn speaks
+// with addr.
+func socks5Connect(conn net.Conn, proxyURL *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 0xffff {
+		return errors.New("invalid port " + strconv.Quote(portStr))
+	}
+
+	methods := []byte{socksAuthNone}
+	if proxyURL.User != nil {
+		methods = []byte{socksAuthPassword}
+	}
+	buf := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return err
+	}
+	if buf[0] != socks5Version {
+		return fmt.Errorf("unexpected SOCKS version %d", buf[0])
+	}
+	switch buf[1] {
+	case socksAuthNone:
+	case socksAuthPassword:
+		if err := socksAuthenticate(conn, proxyURL.User); err != nil {
+			return err
+		}
+	case socksNoAcceptable:
+		return errors.New("no acceptable SOCKS authentication method")
+	default:
+		return fmt.Errorf("unrequested SOCKS authentication method %d", buf[1])
+	}
+
+	buf = append(buf[:0], socks5Version, socksConnect, 0)
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			buf = append(append(buf, socksAddrIPv4), ip4...)
+		} else {
+			buf = append(append(buf, socksAddrIPv6), ip...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.New("host name too long for SOCKS: " + host)
+		}
+		buf = append(append(buf, socksAddrDomain, byte(len(host))), host...)
+	}
+	buf = append(buf, byte(port>>8), byte(port))
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+
+	// Reply: version, status, reserved, then the bound address,
+	// which is skipped.
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return err
+	}
+	if buf[0] != socks5Version {
+		return fmt.Errorf("unexpected SOCKS version %d", buf[0])
+	}
+	if rep := buf[1]; rep != 0 {
+		if text, ok := socksReplyText[rep]; ok {
+			return errors.New(text)
+		}
+		return fmt.Errorf("unknown SOCKS error %d", rep)
+	}
+	var n int
+	switch buf[3] {
+	case socksAddrIPv4:
+		n = net.IPv4len
+	case socksAddrIPv6:
+		n = net.IPv6len
+	case socksAddrDomain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		n = int(buf[0])
+	default:
+		return fmt.Errorf("unknown SOCKS address type %d", buf[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, n+2))
+	return err
+}
+
+// socksAuthenticate sends the username and password of user to the
+// SOCKS5 proxy on conn.
+func socksAuthenticate(conn net.Conn, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return errors.New("SOCKS username or password too long")
+	}
+	buf := []byte{1, byte(len(username))}
+	buf = append(buf, username...)
+	buf = append(buf, byte(len(password)))
+	buf = append(buf, password...)
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return err
+	}
+	if buf[1] != 0 {
+		return errors.New("SOCKS username/password authentication failed")
+	}
+	return nil
+}
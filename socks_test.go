@@ -0,0 +1,124 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// socksServer is a minimal SOCKS5 proxy requiring the user "gopher"
+// with password "secret". It reports the address asked for on addrc.
+type socksServer struct {
+	ln    net.Listener
+	addrc chan string
+}
+
+func newSOCKSServer(t *testing.T) *socksServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &socksServer{ln, make(chan string, 10)}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *socksServer) serve(c net.Conn) {
+	defer c.Close()
+	buf := make([]byte, 512)
+	read := func(n int) []byte {
+		if _, err := io.ReadFull(c, buf[:n]); err != nil {
+			panic(err)
+		}
+		return buf[:n]
+	}
+	defer func() { recover() }()
+
+	read(int(read(2)[1])) // methods
+	c.Write([]byte{5, 2})
+	user := string(read(int(read(2)[1])))
+	pass := string(read(int(read(1)[0])))
+	if user != "gopher" || pass != "secret" {
+		c.Write([]byte{1, 1})
+		return
+	}
+	c.Write([]byte{1, 0})
+
+	var host string
+	switch read(4)[3] {
+	case 1:
+		host = net.IP(read(4)).String()
+	case 3:
+		host = string(read(int(read(1)[0])))
+	case 4:
+		host = net.IP(read(16)).String()
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(read(2)))))
+	s.addrc <- addr
+	up, err := net.Dial("tcp", addr)
+	if err != nil {
+		c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer up.Close()
+	c.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+	go io.Copy(up, c)
+	io.Copy(c, up)
+}
+
+func TestTransportSOCKS5Proxy(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "via socks "+r.URL.Path)
+	}))
+	defer ts.Close()
+	s := newSOCKSServer(t)
+	defer s.ln.Close()
+
+	proxyURL := &url.URL{Scheme: "socks5", Host: s.ln.Addr().String(), User: url.UserPassword("gopher", "secret")}
+	tr := &Transport{Proxy: ProxyURL(proxyURL)}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	res, err := c.Get(ts.URL + "/path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(b) != "via socks /path" {
+		t.Errorf("got %q", b)
+	}
+	if addr := <-s.addrc; addr != ts.Listener.Addr().String() {
+		t.Errorf("proxy asked for %q; want %q", addr, ts.Listener.Addr())
+	}
+
+	proxyURL.User = url.UserPassword("gopher", "wrong")
+	tr.CloseIdleConnections()
+	if _, err = c.Get(ts.URL); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("bad password: error %v", err)
+	}
+
+	tr.Proxy = ProxyURL(&url.URL{Scheme: "ftp", Host: "proxy"})
+	if _, err = c.Get(ts.URL); err == nil || !strings.Contains(err.Error(), "unsupported proxy scheme") {
+		t.Errorf("ftp proxy: error %v", err)
+	}
+}
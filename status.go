@@ -8,6 +8,7 @@ package http
 const (
 	StatusContinue           = 100
 	StatusSwitchingProtocols = 101
+	StatusEarlyHints         = 103 // RFC 8297
 
 	StatusOK                   = 200
 	StatusCreated              = 201
@@ -24,6 +25,7 @@ const (
 	StatusNotModified       = 304
 	StatusUseProxy          = 305
 	StatusTemporaryRedirect = 307
+	StatusPermanentRedirect = 308
 
 	StatusBadRequest                   = 400
 	StatusUnauthorized                 = 401
@@ -63,6 +65,7 @@ const (
 var statusText = map[int]string{
 	StatusContinue:           "Continue",
 	StatusSwitchingProtocols: "Switching Protocols",
+	StatusEarlyHints:         "Early Hints",
 
 	StatusOK:                   "OK",
 	StatusCreated:              "Created",
@@ -79,6 +82,7 @@ var statusText = map[int]string{
 	StatusNotModified:       "Not Modified",
 	StatusUseProxy:          "Use Proxy",
 	StatusTemporaryRedirect: "Temporary Redirect",
+	StatusPermanentRedirect: "Permanent Redirect",
 
 	StatusBadRequest:                   "Bad Request",
 	StatusUnauthorized:                 "Unauthorized",
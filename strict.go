@@ -0,0 +1,318 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Strict request parsing.
+
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// A RequestErrorCode identifies why a Server rejected a request while
+// parsing it.
+type RequestErrorCode int
+
+const (
+	ErrCodeBareLF             RequestErrorCode = iota + 1 // line ended in LF without CR
+	ErrCodeObsFold                                        // header continued by obsolete line folding
+	ErrCodeTEAndCL                                        // both Transfer-Encoding and Content-Length
+	ErrCodeRepeatedLength                                 // Transfer-Encoding or Content-Length repeated
+	ErrCodeNUL                                            // NUL byte in the request line or a header
+	ErrCodeControlChar                                    // other control character
+	ErrCodeHeaderNameTooLong                              // header name over HeaderPolicy.MaxNameBytes
+	ErrCodeHeaderValueTooLong                             // header value over HeaderPolicy.MaxValueBytes
+	ErrCodeDuplicateHost                                  // Host repeated, against HeaderPolicy.DuplicateHost
+	ErrCodeTooManyHeaders                                 // more header lines than HeaderPolicy.MaxHeaders
+	ErrCodeHeaderTooLarge                                 // header over HeaderPolicy.MaxFieldBytes
+	ErrCodeSpaceBeforeColon                               // whitespace between a header name and its colon
+	ErrCodeNoColon                                        // header line without a colon
+)
+
+var requestErrorText = map[RequestErrorCode]string{
+	ErrCodeBareLF:             "line not terminated by CRLF",
+	ErrCodeObsFold:            "obsolete line folding",
+	ErrCodeTEAndCL:            "both Transfer-Encoding and Content-Length",
+	ErrCodeRepeatedLength:     "repeated Transfer-Encoding or Content-Length",
+	ErrCodeNUL:                "NUL byte",
+	ErrCodeControlChar:        "control character",
+	ErrCodeHeaderNameTooLong:  "header name too long",
+	ErrCodeHeaderValueTooLong: "header value too long",
+	ErrCodeDuplicateHost:      "duplicate Host header",
+	ErrCodeTooManyHeaders:     "too many header fields",
+	ErrCodeHeaderTooLarge:     "header field too large",
+	ErrCodeSpaceBeforeColon:   "whitespace before colon",
+	ErrCodeNoColon:            "header line without colon",
+}
+
+func (c RequestErrorCode) String() string {
+	if s, ok := requestErrorText[c]; ok {
+		return s
+	}
+	return "RequestErrorCode(" + strconv.Itoa(int(c)) + ")"
+}
+
+// A RequestError is returned for a request rejected under
+// Server.StrictParsing or Server.HeaderPolicy, and passed to
+// Server.OnRequestError.
+type RequestError struct {
+	Code   RequestErrorCode
+	Header string // canonical name of the offending header, if any
+}
+
+func (e *RequestError) Error() string { return "http: " + e.detail() }
+
+func (e *RequestError) detail() string {
+	if e.Header == "" {
+		return e.Code.String()
+	}
+	return e.Code.String() + " in " + e.Header
+}
+
+// tooLarge reports whether e is for a header exceeding a size limit,
+// which is answered with 431 Request Header Fields Too Large rather
+// than 400 Bad Request.
+func (e *RequestError) tooLarge() bool {
+	switch e.Code {
+	case ErrCodeHeaderNameTooLong, ErrCodeHeaderValueTooLong, ErrCodeTooManyHeaders, ErrCodeHeaderTooLarge:
+		return true
+	}
+	return false
+}
+
+// A HeaderPolicy tightens how a Server parses request lines and
+// headers. Requests that violate it are answered with 400 Bad Request,
+// or 431 Request Header Fields Too Large for the size limits, and the
+// connection is closed. The size limits apply on top of the Server's
+// MaxHeaderBytes.
+type HeaderPolicy struct {
+	// RejectNUL rejects NUL bytes in the request line and headers.
+	RejectNUL bool
+
+	// RejectControl rejects ASCII control characters and DEL in the
+	// request line and headers, NUL included, except for horizontal
+	// tabs.
+	RejectControl bool
+
+	MaxNameBytes  int // if positive, the longest header name allowed
+	MaxValueBytes int // if positive, the longest header value allowed
+	MaxFieldBytes int // if positive, the longest name and value together
+	MaxHeaders    int // if positive, the most header fields allowed
+
+	// DuplicateHost says how requests with more than one Host
+	// header are treated.
+	DuplicateHost DuplicateHostPolicy
+}
+
+// A DuplicateHostPolicy says how a Server treats a request with more
+// than one Host header.
+type DuplicateHostPolicy int
+
+const (
+	// HostUseFirst uses the first Host header, as the server does
+	// without a HeaderPolicy.
+	HostUseFirst DuplicateHostPolicy = iota
+
+	// HostNormalize accepts repeated Host headers with the same
+	// value as a single one and rejects the request if they differ.
+	HostNormalize
+
+	// HostReject rejects any request that repeats Host.
+	HostReject
+)
+
+// headerRules are the checks made while reading a request's head.
+type headerRules struct {
+	strict bool
+	policy HeaderPolicy
+}
+
+// headerRules returns the checks configured on srv, or nil if requests
+// are parsed by ReadRequest as is.
+func (srv *Server) headerRules() *headerRules {
+	if !srv.StrictParsing && srv.HeaderPolicy == nil {
+		return nil
+	}
+	r := &headerRules{strict: srv.StrictParsing}
+	if srv.HeaderPolicy != nil {
+		r.policy = *srv.HeaderPolicy
+	}
+	return r
+}
+
+// ReadRequest reads and parses an incoming request from b as srv does
+// on its connections, under its StrictParsing and HeaderPolicy. It
+// lets the parser be tested, or fuzzed, without a connection. The
+// MaxHeaderBytes limit, which the connection enforces, isn't applied.
+func (srv *Server) ReadRequest(b *bufio.Reader) (*Request, error) {
+	return srv.readRequest(b, nil)
+}
+
+// readRequest is ReadRequest filling in reuse, if non-nil, a Request
+// being reused.
+func (srv *Server) readRequest(b *bufio.Reader, reuse *Request) (*Request, error) {
+	if rules := srv.headerRules(); rules != nil {
+		return rules.readRequest(b, reuse)
+	}
+	return readRequest(b, reuse)
+}
+
+// readRequest is ReadRequest checking the request against r. Under
+// StrictParsing it rejects the request framings that intermediaries
+// are known to disagree on, so that no proxy in front of the server
+// can see a different request boundary than the server does.
+func (r *headerRules) readRequest(b *bufio.Reader, reuse *Request) (*Request, error) {
+	head, err := r.readHead(b)
+	if err != nil {
+		return nil, err
+	}
+	req, err := readRequestHeader(bufio.NewReader(bytes.NewReader(head)), reuse)
+	if err != nil {
+		return nil, err
+	}
+	if r.strict {
+		te, cl := req.Header["Transfer-Encoding"], req.Header["Content-Length"]
+		if len(te) > 1 || len(cl) > 1 {
+			return nil, &RequestError{Code: ErrCodeRepeatedLength}
+		}
+		if te != nil && cl != nil {
+			return nil, &RequestError{Code: ErrCodeTEAndCL}
+		}
+	}
+	if err := readTransfer(req, b); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// readHead reads the request line and header lines from b, up to and
+// including the blank line ending them, checking each line against r.
+// Unless r is strict, lines without a colon are left for the parser to
+// skip.
+func (r *headerRules) readHead(b *bufio.Reader) ([]byte, error) {
+	var (
+		head     []byte
+		key      string // canonical name of the current header
+		valueLen int
+		fields   int
+		host     string
+		hosts    int
+	)
+	for first := true; ; first = false {
+		line, err := readLineSlice(b)
+		if err != nil {
+			if err == io.EOF && !first {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		head = append(head, line...)
+		line = line[:len(line)-1]
+		if n := len(line); n > 0 && line[n-1] == '\r' {
+			line = line[:n-1]
+		} else if r.strict {
+			return nil, &RequestError{Code: ErrCodeBareLF}
+		}
+		switch {
+		case first:
+			if err := r.checkBytes(line, ""); err != nil {
+				return nil, err
+			}
+			continue
+		case len(line) == 0:
+			return head, nil
+		case line[0] == ' ' || line[0] == '\t':
+			if r.strict {
+				return nil, &RequestError{Code: ErrCodeObsFold, Header: key}
+			}
+			valueLen += 1 + len(bytes.TrimSpace(line))
+		default:
+			i := bytes.IndexByte(line, ':')
+			if i < 0 {
+				if r.strict {
+					return nil, &RequestError{Code: ErrCodeNoColon}
+				}
+				continue
+			}
+			// No whitespace is allowed before the colon (RFC 7230,
+			// section 3.2.4): the Header would keep it in the name,
+			// which would then not be the one checked here.
+			if r.strict && bytes.IndexAny(line[:i], " \t") >= 0 {
+				return nil, &RequestError{Code: ErrCodeSpaceBeforeColon, Header: CanonicalHeaderKey(string(bytes.TrimSpace(line[:i])))}
+			}
+			key = CanonicalHeaderKey(string(bytes.TrimSpace(line[:i])))
+			if fields++; r.policy.MaxHeaders > 0 && fields > r.policy.MaxHeaders {
+				return nil, &RequestError{Code: ErrCodeTooManyHeaders}
+			}
+			if max := r.policy.MaxNameBytes; max > 0 && len(key) > max {
+				return nil, &RequestError{Code: ErrCodeHeaderNameTooLong, Header: key}
+			}
+			value := bytes.TrimSpace(line[i+1:])
+			valueLen = len(value)
+			if key == "Host" {
+				hosts++
+				switch {
+				case hosts == 1:
+					host = string(value)
+				case r.policy.DuplicateHost == HostReject,
+					r.policy.DuplicateHost == HostNormalize && string(value) != host:
+					return nil, &RequestError{Code: ErrCodeDuplicateHost, Header: key}
+				}
+			}
+		}
+		if err := r.checkBytes(line, key); err != nil {
+			return nil, err
+		}
+		if max := r.policy.MaxValueBytes; max > 0 && valueLen > max {
+			return nil, &RequestError{Code: ErrCodeHeaderValueTooLong, Header: key}
+		}
+		if max := r.policy.MaxFieldBytes; max > 0 && len(key)+valueLen > max {
+			return nil, &RequestError{Code: ErrCodeHeaderTooLarge, Header: key}
+		}
+	}
+}
+
+// checkBytes checks p, a line of the request's head, for the
+// characters r rejects.
+func (r *headerRules) checkBytes(p []byte, header string) error {
+	if !r.policy.RejectNUL && !r.policy.RejectControl {
+		return nil
+	}
+	for _, c := range p {
+		if c == 0 {
+			return &RequestError{Code: ErrCodeNUL, Header: header}
+		}
+		if r.policy.RejectControl && (c < ' ' && c != '\t' || c == 0x7f) {
+			return &RequestError{Code: ErrCodeControlChar, Header: header}
+		}
+	}
+	return nil
+}
+
+// requestError reports err, for a request about to be rejected, to
+// the Server's OnRequestError.
+func (c *conn) requestError(err error) {
+	if fn := c.server.OnRequestError; fn != nil {
+		fn(c.remoteAddr, err)
+	}
+}
+
+// readLineSlice reads a line from b, including its trailing '\n',
+// however long it is. The result is only valid until the next read
+// from b.
+func readLineSlice(b *bufio.Reader) ([]byte, error) {
+	line, err := b.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return line, err
+	}
+	long := append([]byte(nil), line...)
+	for err == bufio.ErrBufferFull {
+		line, err = b.ReadSlice('\n')
+		long = append(long, line...)
+	}
+	return long, err
+}
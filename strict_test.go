@@ -0,0 +1,162 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rawResponses sends req on a new connection to addr and returns
+// everything the server wrote before closing it.
+func rawResponses(t *testing.T, addr, req string) string {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(c, req)
+	b, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatalf("reading responses to %q: %v", req, err)
+	}
+	return string(b)
+}
+
+// parseTestServer starts srv on a new listener, recording the errors
+// it reports to OnRequestError.
+func parseTestServer(t *testing.T, srv *Server) (ln net.Listener, errc chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc = make(chan error, 10)
+	srv.OnRequestError = func(remoteAddr string, err error) { errc <- err }
+	srv.Handler = HandlerFunc(func(w ResponseWriter, r *Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		io.WriteString(w, r.Host+" "+string(b))
+	})
+	go srv.Serve(ln)
+	return ln, errc
+}
+
+type parseTest struct {
+	name string
+	req  string
+	code RequestErrorCode // or 0 if the request is valid
+}
+
+func runParseTests(t *testing.T, srv *Server, tests []parseTest) {
+	ln, errc := parseTestServer(t, srv)
+	defer ln.Close()
+	for _, tt := range tests {
+		got := rawResponses(t, ln.Addr().String(), tt.req)
+		if tt.code == 0 {
+			if !strings.HasPrefix(got, "HTTP/1.1 200 ") || !strings.HasSuffix(got, "x hi") {
+				t.Errorf("%s: got %q; want 200 echoing the host and body", tt.name, got)
+			}
+			continue
+		}
+		// The connection is closed after the single error response,
+		// so nothing following the rejected header is read as
+		// another request.
+		switch tt.code {
+		case ErrCodeHeaderNameTooLong, ErrCodeHeaderValueTooLong, ErrCodeTooManyHeaders, ErrCodeHeaderTooLarge:
+			if !strings.HasPrefix(got, "HTTP/1.1 431 ") || !strings.Contains(got, "\r\n\r\nRequest Header Fields Too Large: "+tt.code.String()) {
+				t.Errorf("%s: got %q; want a lone 431 explaining the limit", tt.name, got)
+			}
+		default:
+			if got != "HTTP/1.1 400 Bad Request\r\n\r\n" {
+				t.Errorf("%s: got %q; want a lone 400", tt.name, got)
+			}
+		}
+		select {
+		case err := <-errc:
+			if re, ok := err.(*RequestError); !ok || re.Code != tt.code {
+				t.Errorf("%s: OnRequestError got %v; want code %v", tt.name, err, tt.code)
+			}
+		default:
+			t.Errorf("%s: OnRequestError not called", tt.name)
+		}
+	}
+}
+
+func TestServerStrictParsing(t *testing.T) {
+	defer afterTest(t)
+	runParseTests(t, &Server{StrictParsing: true}, []parseTest{
+		{"valid", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 2\r\nConnection: close\r\n\r\nhi", 0},
+		{"chunked", "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n2\r\nhi\r\n0\r\n\r\n", 0},
+		{"te and cl", "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\nContent-Length: 2\r\n\r\n2\r\nhi\r\n0\r\n\r\n", ErrCodeTEAndCL},
+		{"repeated cl", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 2\r\nContent-Length: 2\r\n\r\nhi", ErrCodeRepeatedLength},
+		{"repeated te", "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: identity\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", ErrCodeRepeatedLength},
+		{"obs-fold", "GET / HTTP/1.1\r\nHost: x\r\nX-Folded: a\r\n b\r\n\r\n", ErrCodeObsFold},
+		{"bare lf in header", "GET / HTTP/1.1\r\nHost: x\nX: y\r\n\r\n", ErrCodeBareLF},
+		{"bare lf request line", "GET / HTTP/1.1\nHost: x\r\n\r\n", ErrCodeBareLF},
+		{"bare lf end", "GET / HTTP/1.1\r\nHost: x\r\n\n", ErrCodeBareLF},
+		{"spaced te", "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding : chunked\r\nContent-Length: 3\r\n\r\n0\r\n\r\n", ErrCodeSpaceBeforeColon},
+		{"spaced host", "GET / HTTP/1.1\r\nHost: x\r\nHost : y\r\n\r\n", ErrCodeSpaceBeforeColon},
+		{"no colon", "GET / HTTP/1.1\r\nHost: x\r\nX-No-Colon\r\n\r\n", ErrCodeNoColon},
+	})
+}
+
+func TestServerHeaderPolicy(t *testing.T) {
+	defer afterTest(t)
+	runParseTests(t, &Server{HeaderPolicy: &HeaderPolicy{
+		RejectControl: true,
+		MaxNameBytes:  16,
+		MaxValueBytes: 8,
+		DuplicateHost: HostNormalize,
+	}}, []parseTest{
+		{"valid", "POST / HTTP/1.1\r\nHost: x\r\nX-Tab: a\tb\r\nContent-Length: 2\r\nConnection: close\r\n\r\nhi", 0},
+		{"same host", "POST / HTTP/1.1\r\nHost: x\r\nHost: x\r\nContent-Length: 2\r\nConnection: close\r\n\r\nhi", 0},
+		{"bare lf allowed", "POST / HTTP/1.1\nHost: x\nContent-Length: 2\nConnection: close\n\nhi", 0},
+		{"different hosts", "GET / HTTP/1.1\r\nHost: x\r\nHost: y\r\n\r\n", ErrCodeDuplicateHost},
+		{"nul in value", "GET / HTTP/1.1\r\nHost: x\r\nX-A: a\x00b\r\n\r\n", ErrCodeNUL},
+		{"nul in target", "GET /a\x00b HTTP/1.1\r\nHost: x\r\n\r\n", ErrCodeNUL},
+		{"control in value", "GET / HTTP/1.1\r\nHost: x\r\nX-A: a\x01b\r\n\r\n", ErrCodeControlChar},
+		{"del in name", "GET / HTTP/1.1\r\nHost: x\r\nX-\x7f: a\r\n\r\n", ErrCodeControlChar},
+		{"long name", "GET / HTTP/1.1\r\nHost: x\r\nX-Much-Too-Long-Name: a\r\n\r\n", ErrCodeHeaderNameTooLong},
+		{"long value", "GET / HTTP/1.1\r\nHost: x\r\nX-A: 123456789\r\n\r\n", ErrCodeHeaderValueTooLong},
+		{"long folded value", "GET / HTTP/1.1\r\nHost: x\r\nX-A: 1234\r\n 5678\r\n\r\n", ErrCodeHeaderValueTooLong},
+	})
+	runParseTests(t, &Server{HeaderPolicy: &HeaderPolicy{RejectNUL: true, DuplicateHost: HostReject}}, []parseTest{
+		{"valid", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 2\r\nConnection: close\r\n\r\nhi", 0},
+		{"nul in value", "GET / HTTP/1.1\r\nHost: x\r\nX-A: a\x00b\r\n\r\n", ErrCodeNUL},
+		{"same host", "GET / HTTP/1.1\r\nHost: x\r\nHost: x\r\n\r\n", ErrCodeDuplicateHost},
+	})
+}
+
+func TestServerHeaderLimits(t *testing.T) {
+	defer afterTest(t)
+	runParseTests(t, &Server{HeaderPolicy: &HeaderPolicy{MaxHeaders: 4, MaxFieldBytes: 16}}, []parseTest{
+		{"valid", "POST / HTTP/1.1\r\nHost: x\r\nX-Abc: 1234567\r\nContent-Length: 2\r\nConnection: close\r\n\r\nhi", 0},
+		{"too many", "GET / HTTP/1.1\r\nHost: x\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n", ErrCodeTooManyHeaders},
+		{"too large", "GET / HTTP/1.1\r\nHost: x\r\nX-Abc: 123456789012\r\n\r\n", ErrCodeHeaderTooLarge},
+		{"too large folded", "GET / HTTP/1.1\r\nHost: x\r\nX-Abc: 1234\r\n 5678901\r\n\r\n", ErrCodeHeaderTooLarge},
+	})
+}
+
+func TestServerLenientParsing(t *testing.T) {
+	defer afterTest(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go (&Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, r.Header.Get("X-Folded"))
+	})}).Serve(ln)
+
+	got := rawResponses(t, ln.Addr().String(), "GET / HTTP/1.1\nHost: x\nX-Folded: a\n b\nConnection: close\n\n")
+	if !strings.HasPrefix(got, "HTTP/1.1 200 ") || !strings.HasSuffix(got, "a b") {
+		t.Errorf("without StrictParsing: got %q; want 200 with the unfolded header", got)
+	}
+}
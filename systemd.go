@@ -0,0 +1,97 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// systemd socket activation.
+
+package http
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd.
+const listenFdsStart = 3
+
+// SystemdListeners returns the listening sockets passed to the process
+// by systemd socket activation, in the order of the .socket unit's
+// Listen directives, or in a process started by Server.Upgrade, those
+// its parent served with ServeSystemd. It returns no listeners and no
+// error if the process was not socket-activated.
+//
+// The LISTEN_* environment variables are cleared, so that processes
+// started later don't mistake the sockets for their own, and so
+// SystemdListeners only returns the listeners on its first call.
+func SystemdListeners() ([]net.Listener, error) {
+	pid := os.Getenv("LISTEN_PID")
+	fds := os.Getenv("LISTEN_FDS")
+	names := os.Getenv("LISTEN_FDNAMES")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid == "" || pid != strconv.Itoa(os.Getpid()) {
+		// Sockets systemd passed to a process that then upgraded
+		// itself with Server.Upgrade.
+		var lns []net.Listener
+		for i := 0; ; i++ {
+			l := claimInherited("systemd " + strconv.Itoa(i))
+			if l == nil {
+				return lns, nil
+			}
+			lns = append(lns, l)
+		}
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, errors.New("http: invalid LISTEN_FDS " + strconv.Quote(fds))
+	}
+	nameOf := strings.Split(names, ":")
+	lns := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFdsStart+i)
+		if i < len(nameOf) && nameOf[i] != "" {
+			name = nameOf[i]
+		}
+		f := os.NewFile(uintptr(listenFdsStart+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+			return nil, errors.New("http: systemd socket " + name + ": " + err.Error())
+		}
+		lns = append(lns, l)
+	}
+	return lns, nil
+}
+
+// ServeSystemd serves the listeners passed by systemd socket
+// activation, each configured by opts, for example with
+// ReadProxyHeader as the WrapConn option behind a proxy. Because
+// systemd keeps the sockets open between restarts of the service,
+// connections arriving while it restarts wait in the kernel's queue
+// instead of being refused.
+//
+// ServeSystemd returns an error if the process was not
+// socket-activated. Otherwise it returns when serving any of the
+// listeners fails or srv is closed, as ListenAndServeMulti does.
+func (srv *Server) ServeSystemd(opts ListenerOptions) error {
+	lns, err := SystemdListeners()
+	if err != nil {
+		return err
+	}
+	if len(lns) == 0 {
+		return errors.New("http: no systemd sockets passed (LISTEN_FDS not set)")
+	}
+	all := make([]ListenerOptions, len(lns))
+	for i := range all {
+		all[i] = opts
+		lns[i] = srv.upgradableListener("systemd "+strconv.Itoa(i), lns[i])
+	}
+	return srv.serveAll(lns, all)
+}
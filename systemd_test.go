@@ -0,0 +1,92 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+)
+
+func TestServeSystemd(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lnf, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child := exec.Command(os.Args[0], "-test.run=TestServeSystemdChild")
+	child.ExtraFiles = []*os.File{lnf}
+	child.Env = append([]string{"GO_WANT_HELPER_PROCESS=1", "LISTEN_FDS=1", "LISTEN_FDNAMES=web"}, os.Environ()...)
+	child.Stderr = os.Stderr
+	if err := child.Start(); err != nil {
+		t.Fatal(err)
+	}
+	lnf.Close()
+	defer child.Wait()
+	defer child.Process.Kill()
+
+	// The socket is already listening, so the request waits in its
+	// queue until the child starts accepting.
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 80\r\nGET / HTTP/1.0\r\n\r\n")
+	res, err := ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	if got, want := string(b), "192.0.2.1:56324 LISTEN_FDS="; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+// TestServeSystemdChild isn't a real test. It's used as a helper
+// process for TestServeSystemd, standing in for a service started by
+// systemd.
+func TestServeSystemdChild(*testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	defer os.Exit(0)
+	// systemd sets LISTEN_PID between fork and exec.
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		fmt.Fprintf(w, "%s LISTEN_FDS=%s", r.RemoteAddr, os.Getenv("LISTEN_FDS"))
+	})}
+	if err := srv.ServeSystemd(ListenerOptions{WrapConn: ReadProxyHeader}); err != nil {
+		panic(err)
+	}
+}
+
+func TestSystemdListenersNotActivated(t *testing.T) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+	lns, err := SystemdListeners()
+	if lns != nil || err != nil {
+		t.Errorf("SystemdListeners for another process = %v, %v; want none", lns, err)
+	}
+	if v := os.Getenv("LISTEN_FDS"); v != "" {
+		t.Errorf("LISTEN_FDS = %q after SystemdListeners; want it cleared", v)
+	}
+	if err := new(Server).ServeSystemd(ListenerOptions{}); err == nil {
+		t.Error("ServeSystemd without socket activation succeeded")
+	}
+}
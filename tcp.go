@@ -0,0 +1,77 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// TCP socket options of accepted connections.
+
+package http
+
+import (
+	"net"
+	"time"
+)
+
+// TCPOptions holds socket options applied to each accepted TCP
+// connection as soon as it is accepted, before anything, such as a
+// PROXY protocol header or TLS handshake, is read from it. Zero fields
+// leave the operating system's defaults in place.
+type TCPOptions struct {
+	// KeepAlivePeriod, if positive, enables TCP keep-alives with
+	// this period, so that connections to vanished clients are
+	// eventually closed. If negative, keep-alives are disabled.
+	KeepAlivePeriod time.Duration
+
+	// DelayWrites, if true, disables TCP_NODELAY, letting the
+	// kernel coalesce small writes at the cost of latency.
+	DelayWrites bool
+
+	// Linger, if positive, makes closing a connection with unsent
+	// data block for up to Linger (rounded up to whole seconds)
+	// while the data is sent. If negative, unsent data is discarded
+	// and the connection is reset on close, freeing it at once.
+	Linger time.Duration
+
+	// ReadBuffer and WriteBuffer, if positive, set the sizes of
+	// the socket's receive and send buffers.
+	ReadBuffer  int
+	WriteBuffer int
+}
+
+// apply sets o's options on c, if it is a TCP connection.
+func (o *TCPOptions) apply(c net.Conn) {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	switch {
+	case o.KeepAlivePeriod > 0:
+		tc.SetKeepAlive(true)
+		tc.SetKeepAlivePeriod(o.KeepAlivePeriod)
+	case o.KeepAlivePeriod < 0:
+		tc.SetKeepAlive(false)
+	}
+	if o.DelayWrites {
+		tc.SetNoDelay(false)
+	}
+	switch {
+	case o.Linger > 0:
+		tc.SetLinger(int((o.Linger + time.Second - 1) / time.Second))
+	case o.Linger < 0:
+		tc.SetLinger(0)
+	}
+	if o.ReadBuffer > 0 {
+		tc.SetReadBuffer(o.ReadBuffer)
+	}
+	if o.WriteBuffer > 0 {
+		tc.SetWriteBuffer(o.WriteBuffer)
+	}
+}
+
+// tcpOptions returns the TCP options for connections accepted on a
+// listener served with opts, or nil.
+func (srv *Server) tcpOptions(opts *ListenerOptions) *TCPOptions {
+	if opts != nil && opts.TCPOptions != nil {
+		return opts.TCPOptions
+	}
+	return srv.TCPOptions
+}
@@ -0,0 +1,90 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"net"
+	. "net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// acceptedListener records the connections it accepts.
+type acceptedListener struct {
+	net.Listener
+	conns chan net.Conn
+}
+
+func (l *acceptedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.conns <- c
+	}
+	return c, err
+}
+
+func sockopt(t *testing.T, c net.Conn, level, opt int) int {
+	rc, err := c.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	var serr error
+	rc.Control(func(fd uintptr) { v, serr = syscall.GetsockoptInt(int(fd), level, opt) })
+	if serr != nil {
+		t.Fatal(serr)
+	}
+	return v
+}
+
+func TestServerTCPOptions(t *testing.T) {
+	defer afterTest(t)
+	for _, perListener := range []bool{false, true} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		al := &acceptedListener{ln, make(chan net.Conn, 1)}
+		to := &TCPOptions{KeepAlivePeriod: -1, DelayWrites: true, ReadBuffer: 8192, WriteBuffer: 16384}
+		srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {})}
+		if perListener {
+			srv.TCPOptions = &TCPOptions{ReadBuffer: 1 << 20}
+			go srv.ServeWithOptions(al, ListenerOptions{TCPOptions: to})
+		} else {
+			srv.TCPOptions = to
+			go srv.Serve(al)
+		}
+
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sc net.Conn
+		select {
+		case sc = <-al.conns:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for Accept")
+		}
+		// The options are set before the connection is served.
+		c.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+		c.Read(make([]byte, 1))
+		if v := sockopt(t, sc, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); v != 0 {
+			t.Errorf("perListener=%v: SO_KEEPALIVE = %d; want 0", perListener, v)
+		}
+		if v := sockopt(t, sc, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); v != 0 {
+			t.Errorf("perListener=%v: TCP_NODELAY = %d; want 0", perListener, v)
+		}
+		// Linux doubles the requested sizes for bookkeeping.
+		if v := sockopt(t, sc, syscall.SOL_SOCKET, syscall.SO_RCVBUF); v != 2*8192 {
+			t.Errorf("perListener=%v: SO_RCVBUF = %d; want %d", perListener, v, 2*8192)
+		}
+		if v := sockopt(t, sc, syscall.SOL_SOCKET, syscall.SO_SNDBUF); v != 2*16384 {
+			t.Errorf("perListener=%v: SO_SNDBUF = %d; want %d", perListener, v, 2*16384)
+		}
+		c.Close()
+		ln.Close()
+	}
+}
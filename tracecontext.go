@@ -0,0 +1,151 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// W3C Trace Context propagation.
+
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+var traceContextKey = &contextKey{"trace-context"}
+
+var errTraceParent = errors.New("http: malformed traceparent header")
+
+// A TraceContext is the W3C Trace Context of a request, carried in
+// its traceparent and tracestate headers. The Server stores the
+// TraceContext of each request that has a valid traceparent header in
+// the request's context, and reports its trace ID in the access log.
+type TraceContext struct {
+	TraceID  [16]byte // identifies the whole trace
+	ParentID [8]byte  // identifies the caller's span
+	Flags    byte     // trace flags, such as TraceSampled
+	State    string   // vendor-specific tracestate, passed on as is
+}
+
+// TraceSampled is the trace flag saying that the caller may have
+// recorded the trace.
+const TraceSampled = 0x01
+
+// ParseTraceParent parses a traceparent header value. Versions after
+// 00 are accepted as long as they start like version 00.
+func ParseTraceParent(s string) (TraceContext, error) {
+	var tc TraceContext
+	// version "-" trace-id "-" parent-id "-" trace-flags
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return tc, errTraceParent
+	}
+	version := s[:2]
+	if version == "ff" || !isLowerHex(version) || version == "00" && len(s) != 55 ||
+		len(s) > 55 && s[55] != '-' {
+		return tc, errTraceParent
+	}
+	var flags [1]byte
+	if !decodeLowerHex(tc.TraceID[:], s[3:35]) || !decodeLowerHex(tc.ParentID[:], s[36:52]) ||
+		!decodeLowerHex(flags[:], s[53:55]) {
+		return tc, errTraceParent
+	}
+	if tc.TraceID == [16]byte{} || tc.ParentID == [8]byte{} {
+		return tc, errTraceParent
+	}
+	tc.Flags = flags[0]
+	return tc, nil
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeLowerHex(dst []byte, s string) bool {
+	if !isLowerHex(s) {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// TraceIDString returns tc's trace ID in hex, as logged.
+func (tc TraceContext) TraceIDString() string { return hex.EncodeToString(tc.TraceID[:]) }
+
+// Sampled reports whether tc has the TraceSampled flag.
+func (tc TraceContext) Sampled() bool { return tc.Flags&TraceSampled != 0 }
+
+// String returns tc as a version 00 traceparent header value.
+func (tc TraceContext) String() string {
+	return "00-" + hex.EncodeToString(tc.TraceID[:]) + "-" + hex.EncodeToString(tc.ParentID[:]) +
+		"-" + hex.EncodeToString([]byte{tc.Flags})
+}
+
+// NewSpan returns a copy of tc with a new random ParentID, for the
+// span of a call made on tc's behalf.
+func (tc TraceContext) NewSpan() TraceContext {
+	for {
+		if _, err := rand.Read(tc.ParentID[:]); err != nil {
+			panic("http: can't generate span ID: " + err.Error())
+		}
+		if tc.ParentID != [8]byte{} {
+			return tc
+		}
+	}
+}
+
+// TraceContextFromContext returns the TraceContext stored in ctx, if
+// any.
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey).(TraceContext)
+	return tc, ok
+}
+
+// ContextWithTraceContext returns a copy of ctx holding tc.
+func ContextWithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey, tc)
+}
+
+// PropagateTraceContext sets the traceparent and tracestate headers of
+// the outbound request req from the TraceContext in its context, if
+// any, with a new span ID. A handler passes on its request's trace by
+// giving the outbound request its context:
+//
+//	out = out.WithContext(r.Context())
+//	PropagateTraceContext(out)
+func PropagateTraceContext(req *Request) {
+	tc, ok := TraceContextFromContext(req.Context())
+	if !ok {
+		return
+	}
+	if req.Header == nil {
+		req.Header = make(Header)
+	}
+	req.Header.Set("Traceparent", tc.NewSpan().String())
+	if tc.State != "" {
+		req.Header.Set("Tracestate", tc.State)
+	} else {
+		req.Header.Del("Tracestate")
+	}
+}
+
+// setTraceContext stores the TraceContext of req, if it has a valid
+// traceparent header, in its context.
+func setTraceContext(req *Request) {
+	tp := req.Header["Traceparent"]
+	if len(tp) != 1 {
+		return
+	}
+	tc, err := ParseTraceParent(strings.TrimSpace(tp[0]))
+	if err != nil {
+		return
+	}
+	tc.State = strings.Join(req.Header["Tracestate"], ",")
+	req.ctx = ContextWithTraceContext(req.Context(), tc)
+}
@@ -0,0 +1,112 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"io"
+	"io/ioutil"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+var parseTraceParentTests = []struct {
+	in string
+	ok bool
+}{
+	{testTraceParent, true},
+	{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+	{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true},
+	{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", false},
+	{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01future", false},
+	{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+	{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+	{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+	{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+	{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0g", false},
+	{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+	{"", false},
+}
+
+func TestParseTraceParent(t *testing.T) {
+	for _, tt := range parseTraceParentTests {
+		tc, err := ParseTraceParent(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseTraceParent(%q) error = %v; want ok=%v", tt.in, err, tt.ok)
+			continue
+		}
+		if tt.ok && tc.String() != "00"+tt.in[2:55] {
+			t.Errorf("ParseTraceParent(%q).String() = %q", tt.in, tc.String())
+		}
+	}
+	tc, _ := ParseTraceParent(testTraceParent)
+	if !tc.Sampled() || tc.TraceIDString() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("parsed %+v", tc)
+	}
+}
+
+func TestServerTraceContext(t *testing.T) {
+	defer afterTest(t)
+	backend := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, r.Header.Get("Traceparent")+" "+r.Header.Get("Tracestate"))
+	}))
+	defer backend.Close()
+
+	logs := make(chan *AccessLogEntry, 1)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		out, _ := NewRequest("GET", backend.URL, nil)
+		out = out.WithContext(r.Context())
+		PropagateTraceContext(out)
+		res, err := DefaultClient.Do(out)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer res.Body.Close()
+		io.Copy(w, res.Body)
+	}))
+	ts.Config.AccessLog = func(e *AccessLogEntry) { logs <- e }
+	ts.Start()
+	defer ts.Close()
+
+	req, _ := NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Traceparent", testTraceParent)
+	req.Header.Add("Tracestate", "congo=t61rcWkgMzE")
+	req.Header.Add("Tracestate", "rojo=00f067aa0ba902b7")
+	res, err := DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	f := strings.Fields(string(b))
+	if len(f) != 2 {
+		t.Fatalf("backend got %q", b)
+	}
+	out, err := ParseTraceParent(f[0])
+	if err != nil {
+		t.Fatalf("propagated traceparent %q: %v", f[0], err)
+	}
+	if out.TraceIDString() != "4bf92f3577b34da6a3ce929d0e0e4736" || !out.Sampled() || f[0] == testTraceParent {
+		t.Errorf("propagated traceparent %q; want the same trace and flags in a new span", f[0])
+	}
+	if f[1] != "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7" {
+		t.Errorf("propagated tracestate %q", f[1])
+	}
+	e := <-logs
+	if e.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || !strings.HasSuffix(e.String(), " trace="+e.TraceID) {
+		t.Errorf("access log entry %+v: %s", e, e)
+	}
+
+	// Without a trace, nothing is propagated.
+	out2, _ := NewRequest("GET", backend.URL, nil)
+	PropagateTraceContext(out2)
+	if out2.Header.Get("Traceparent") != "" {
+		t.Error("traceparent set without a TraceContext")
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Tracing hooks.
+
+package http
+
+import (
+	"context"
+	"net"
+	"strconv"
+)
+
+// A Tracer starts spans for the requests a Server handles and the
+// round trips a Transport makes, so that a tracing system such as
+// OpenTelemetry can be plugged in without this package depending on
+// it. Attributes are named after the OpenTelemetry semantic
+// conventions for HTTP.
+type Tracer interface {
+	// StartSpan starts a span of the kind named name, as a child of
+	// any span in ctx, and returns a copy of ctx holding it.
+	StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, Span)
+}
+
+// A Span is an operation being traced, started by a Tracer.
+type Span interface {
+	// SetAttribute records an attribute of the operation. The
+	// value is a string, int, int64 or bool.
+	SetAttribute(key string, value interface{})
+
+	// End ends the span, which failed if err is non-nil.
+	End(err error)
+}
+
+// A SpanKind says what a Span traces.
+type SpanKind int
+
+const (
+	SpanServer SpanKind = iota + 1 // handling of a request by a Server
+	SpanClient                     // round trip of a request by a Transport
+)
+
+var spanKey = &contextKey{"span"}
+
+// SpanFromContext returns the Span stored in ctx by a Server's Tracer,
+// or nil. Handlers may use it to add attributes to the server span.
+func SpanFromContext(ctx context.Context) Span {
+	s, _ := ctx.Value(spanKey).(Span)
+	return s
+}
+
+// startSpan starts the server span of w's request if the Server has
+// a Tracer, returning the function ending it.
+func (c *conn) startSpan(w *response) (end func()) {
+	t := c.server.Tracer
+	if t == nil {
+		return func() {}
+	}
+	req := w.req
+	ctx, span := t.StartSpan(req.Context(), req.Method, SpanServer)
+	req.ctx = context.WithValue(ctx, spanKey, span)
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("url.path", req.URL.Path)
+	if c.tlsState != nil {
+		span.SetAttribute("url.scheme", "https")
+	} else {
+		span.SetAttribute("url.scheme", "http")
+	}
+	span.SetAttribute("network.protocol.version", req.Proto)
+	if host, _, err := net.SplitHostPort(c.remoteAddr); err == nil {
+		span.SetAttribute("client.address", host)
+	}
+	if ip := addrIP(peerAddr(c.rwc)); ip != nil {
+		span.SetAttribute("network.peer.address", ip.String())
+	}
+	if ua := req.UserAgent(); ua != "" {
+		span.SetAttribute("user_agent.original", ua)
+	}
+	return func() {
+		if !c.hijacked() {
+			span.SetAttribute("http.response.status_code", w.status)
+		}
+		span.End(nil)
+	}
+}
+
+// setRoute records the ServeMux pattern that matched r on its span,
+// if any.
+func setRoute(r *Request, pattern string) {
+	if span := SpanFromContext(r.Context()); span != nil && pattern != "" {
+		span.SetAttribute("http.route", pattern)
+	}
+}
+
+// startClientSpan starts the client span of a round trip of req by a
+// Transport with Tracer t, returning a copy of req with the span's
+// context, for the spans of dials and the like to be its children.
+// The span ends with the response header.
+func startClientSpan(t Tracer, req *Request) (*Request, Span) {
+	method := req.Method
+	if method == "" {
+		method = "GET"
+	}
+	ctx, span := t.StartSpan(req.Context(), method, SpanClient)
+	span.SetAttribute("http.request.method", method)
+	u := *req.URL
+	u.User = nil
+	span.SetAttribute("url.full", u.String())
+	host, port, err := net.SplitHostPort(req.URL.Host)
+	if err != nil {
+		host = req.URL.Host
+	}
+	span.SetAttribute("server.address", host)
+	if n, err := strconv.Atoi(port); err == nil {
+		span.SetAttribute("server.port", n)
+	}
+	return req.WithContext(ctx), span
+}
+
+func endClientSpan(span Span, res *Response, err error) {
+	if res != nil {
+		span.SetAttribute("http.response.status_code", res.StatusCode)
+	}
+	span.End(err)
+}
+
+// setTraced records r2 as the copy of req sent with its client span's
+// context, for CancelRequest, or forgets req's copy if r2 is nil.
+func (t *Transport) setTraced(req, r2 *Request) {
+	t.reqMu.Lock()
+	defer t.reqMu.Unlock()
+	if r2 == nil {
+		delete(t.traced, req)
+		return
+	}
+	if t.traced == nil {
+		t.traced = make(map[*Request]*Request)
+	}
+	t.traced[req] = r2
+}
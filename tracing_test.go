@@ -0,0 +1,187 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testSpan struct {
+	name  string
+	kind  SpanKind
+	attrs map[string]interface{}
+	ended chan bool // closed by End
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) { s.attrs[key] = value }
+func (s *testSpan) End(err error)                              { close(s.ended) }
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := &testSpan{name: name, kind: kind, attrs: map[string]interface{}{}, ended: make(chan bool)}
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, testSpanKey{}, s), s
+}
+
+type testSpanKey struct{}
+
+// endedSpan returns the only span started by t, once it has ended.
+func (t *testTracer) endedSpan(tb testing.TB) *testSpan {
+	t.mu.Lock()
+	spans := t.spans
+	t.mu.Unlock()
+	if len(spans) != 1 {
+		tb.Fatalf("started %d spans; want 1", len(spans))
+	}
+	select {
+	case <-spans[0].ended:
+	case <-time.After(5 * time.Second):
+		tb.Fatal("span not ended")
+	}
+	return spans[0]
+}
+
+func TestServerTracer(t *testing.T) {
+	defer afterTest(t)
+	tracer := new(testTracer)
+	mux := NewServeMux()
+	mux.HandleFunc("/items/", func(w ResponseWriter, r *Request) {
+		if span := SpanFromContext(r.Context()); span != nil {
+			span.SetAttribute("app.item", r.URL.Path[len("/items/"):])
+		}
+		w.WriteHeader(StatusCreated)
+	})
+	srv := &Server{Handler: mux, Tracer: tracer}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go srv.ServeWithOptions(ln, ListenerOptions{WrapConn: ReadProxyHeader})
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "PROXY TCP4 198.51.100.7 127.0.0.1 5555 80\r\nPUT /items/42 HTTP/1.1\r\nHost: x\r\nUser-Agent: tester\r\nContent-Length: 0\r\n\r\n")
+	res, err := ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	span := tracer.endedSpan(t)
+	want := map[string]interface{}{
+		"http.request.method":       "PUT",
+		"url.path":                  "/items/42",
+		"url.scheme":                "http",
+		"network.protocol.version":  "HTTP/1.1",
+		"client.address":            "198.51.100.7",
+		"network.peer.address":      "127.0.0.1",
+		"user_agent.original":       "tester",
+		"http.route":                "/items/",
+		"app.item":                  "42",
+		"http.response.status_code": 201,
+	}
+	if span.name != "PUT" || span.kind != SpanServer || !reflect.DeepEqual(span.attrs, want) {
+		t.Errorf("server span %q kind %d attributes\n%v\nwant\n%v", span.name, span.kind, span.attrs, want)
+	}
+}
+
+func TestTransportTracer(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.WriteHeader(StatusAccepted)
+	}))
+	defer ts.Close()
+	tracer := new(testTracer)
+	var dialSpan interface{}
+	tr := &Transport{
+		Tracer: tracer,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialSpan = ctx.Value(testSpanKey{})
+			return net.Dial(network, addr)
+		},
+	}
+	defer tr.CloseIdleConnections()
+	u := "http://user:secret@" + ts.Listener.Addr().String() + "/path?q=1"
+	req, _ := NewRequest("GET", u, nil)
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.Request != req {
+		t.Error("Response.Request is not the request sent")
+	}
+
+	span := tracer.endedSpan(t)
+	if dialSpan != span {
+		t.Error("dial context does not hold the client span")
+	}
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	want := map[string]interface{}{
+		"http.request.method":       "GET",
+		"url.full":                  "http://" + ts.Listener.Addr().String() + "/path?q=1",
+		"server.address":            "127.0.0.1",
+		"server.port":               portNum,
+		"http.response.status_code": 202,
+	}
+	if span.name != "GET" || span.kind != SpanClient || !reflect.DeepEqual(span.attrs, want) {
+		t.Errorf("client span %q kind %d attributes\n%v\nwant\n%v", span.name, span.kind, span.attrs, want)
+	}
+}
+
+func TestTransportTracerCancelRequest(t *testing.T) {
+	defer afterTest(t)
+	unblock := make(chan bool)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.(Flusher).Flush()
+		<-unblock
+	}))
+	defer ts.Close()
+	defer close(unblock)
+	tr := &Transport{Tracer: new(testTracer)}
+	defer tr.CloseIdleConnections()
+	req, _ := NewRequest("GET", ts.URL, nil)
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	tr.CancelRequest(req)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := ioutil.ReadAll(res.Body)
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Error("read of the canceled body succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("CancelRequest did not cancel the traced request")
+	}
+}
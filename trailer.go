@@ -0,0 +1,69 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Server response trailers.
+
+package http
+
+import "strings"
+
+// TrailerPrefix is a magic prefix for ResponseWriter.Header map keys
+// that, if present, signals that the map entry is actually for the
+// response trailers, and not the response headers. The prefix is
+// stripped after the ServeHTTP call finishes and the values are sent
+// in the trailers.
+//
+// This mechanism is intended only for trailers that are not known
+// prior to the headers being written. If the set of trailers is fixed
+// or known before the header is written, the normal Go trailers
+// mechanism is preferred: declare them in the "Trailer" header before
+// calling WriteHeader or Write and set their values once the body has
+// been written.
+//
+// Trailers are sent only with chunked replies, to HTTP/1.1 clients,
+// so declaring trailers keeps the server from adding a Content-Length.
+// A reply whose trailers carry its status, as gRPC's do, may
+// therefore be one with headers only or with trailers after a body.
+const TrailerPrefix = "Trailer:"
+
+// declareTrailers records the trailers declared in the "Trailer"
+// header h, reporting whether there are any.
+func (w *response) declareTrailers(h Header) bool {
+	for _, v := range h["Trailer"] {
+		for _, k := range strings.Split(v, ",") {
+			k = CanonicalHeaderKey(strings.TrimSpace(k))
+			switch k {
+			case "", "Transfer-Encoding", "Trailer", "Content-Length":
+				// Forbidden by RFC 2616 section 14.40.
+				continue
+			}
+			w.trailers = append(w.trailers, k)
+		}
+	}
+	return len(w.trailers) > 0
+}
+
+// finalTrailers returns the trailers of the reply: the values of the
+// declared trailers and of headers set with TrailerPrefix, if any, or
+// nil.
+func (w *response) finalTrailers() Header {
+	var t Header
+	for k, vv := range w.handlerHeader {
+		if strings.HasPrefix(k, TrailerPrefix) {
+			if t == nil {
+				t = make(Header)
+			}
+			t[strings.TrimPrefix(k, TrailerPrefix)] = vv
+		}
+	}
+	for _, k := range w.trailers {
+		if vv, ok := w.handlerHeader[k]; ok {
+			if t == nil {
+				t = make(Header)
+			}
+			t[k] = vv
+		}
+	}
+	return t
+}
@@ -0,0 +1,91 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServerTrailers(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewProxyServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.Header().Set("Content-Type", "application/grpc")
+		if r.URL.Path == "/body" {
+			w.Write([]byte("message"))
+		}
+		if r.ProxyLine == nil {
+			w.Header().Set("Grpc-Status", "13")
+			return
+		}
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", r.ProxyLine.Source.String())
+		w.Header().Set(TrailerPrefix+"X-Undeclared", "late")
+	}))
+	defer ts.Close()
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+	c := ts.ProxyClient(src)
+
+	for _, path := range []string{"/body", "/trailers-only"} {
+		res, err := c.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if path == "/body" && string(body) != "message" {
+			t.Errorf("%s: body = %q", path, body)
+		}
+		if len(res.TransferEncoding) != 1 || res.TransferEncoding[0] != "chunked" {
+			t.Errorf("%s: TransferEncoding = %q; want chunked", path, res.TransferEncoding)
+		}
+		// Values set before a reply without a body is written are
+		// sent in its header too, as in gRPC's trailers-only replies.
+		if got := res.Header.Get("Grpc-Status"); path == "/body" && got != "" {
+			t.Errorf("%s: Grpc-Status header = %q; want it in the trailers only", path, got)
+		}
+		want := map[string]string{
+			"Grpc-Status":  "0",
+			"Grpc-Message": src.String(),
+			"X-Undeclared": "late",
+		}
+		for k, v := range want {
+			if got := res.Trailer.Get(k); got != v {
+				t.Errorf("%s: trailer %s = %q; want %q", path, k, got, v)
+			}
+		}
+	}
+}
+
+func TestServerTrailersHTTP10(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte("body"))
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	defer ts.Close()
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+	got, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// HTTP/1.0 clients get no chunked reply, so no trailers.
+	if want := "\r\n\r\nbody"; len(got) < len(want) || string(got[len(got)-len(want):]) != want {
+		t.Errorf("reply = %q; want one ending in %q", got, want)
+	}
+}
@@ -11,13 +11,16 @@ package http
 
 import (
 	"bufio"
-	"compress/gzip"
+	"container/list"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/http/httptrace"
+	"net/textproto"
 	"net/url"
 	"os"
 	"strings"
@@ -40,18 +43,32 @@ const DefaultMaxIdleConnsPerHost = 2
 // https, and http proxies (for either http or https with CONNECT).
 // Transport can also cache connections for future re-use.
 type Transport struct {
-	idleMu     sync.Mutex
-	idleConn   map[string][]*persistConn
-	idleConnCh map[string]chan *persistConn
-	reqMu      sync.Mutex
-	reqConn    map[*Request]*persistConn
-	altMu      sync.RWMutex
-	altProto   map[string]RoundTripper // nil or map of URI scheme => RoundTripper
+	idleMu       sync.Mutex
+	idleConn     map[string][]*persistConn
+	idleConnCh   map[string]chan *persistConn
+	idleLRU      list.List                      // of idle *persistConn, oldest first
+	connsPerHost map[string]chan struct{}       // semaphores of MaxConnsPerHost
+	connsWait    map[string][]chan *persistConn // waiting at MaxConnsPerHost
+	reqMu        sync.Mutex
+	reqConn      map[*Request]*persistConn
+	retrying     map[*Request]*retryState // requests being retried, by original
+	hedging      map[*Request]*hedgeState // requests being hedged, by original
+	transformed  map[*Request]*Request    // requests sent with BodyTransformers, by original
+	traced       map[*Request]*Request    // requests sent with a Tracer's span, by original
+	altMu        sync.RWMutex
+	altProto     map[string]RoundTripper // nil or map of URI scheme => RoundTripper
+	poolMu       sync.Mutex
+	pool         poolCounts // for PoolStats
 
 	// Proxy specifies a function to return a proxy for a given
 	// Request. If the function returns a non-nil error, the
 	// request is aborted with the provided error.
 	// If Proxy is nil or returns a nil *URL, no proxy is used.
+	//
+	// The URL's scheme selects the kind of proxy: "http" (the
+	// default if empty) or "https" for an HTTP proxy, reached
+	// over TLS for "https", or "socks5" for a SOCKS5 proxy. The
+	// URL's user information, if any, authenticates to the proxy.
 	Proxy func(*Request) (*url.URL, error)
 
 	// Dial specifies the dial function for creating TCP
@@ -59,10 +76,61 @@ type Transport struct {
 	// If Dial is nil, net.Dial is used.
 	Dial func(network, addr string) (net.Conn, error)
 
+	// DialContext specifies the dial function for creating TCP
+	// connections, given the context of the request needing the
+	// connection. Canceling the context may abort the dial, but
+	// a connection dialed is kept for later requests.
+	// If DialContext is set, Dial is not used.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// DialTLSContext specifies the dial function for creating TLS
+	// connections for HTTPS requests not using a proxy. The
+	// connection returned is used as is, without a TLS handshake
+	// or TLSClientConfig, so the function can also reach the
+	// server some other way, such as through a tunnel.
+	// If DialTLSContext is nil, the connection is dialed as for
+	// HTTP requests and TLS set up over it.
+	DialTLSContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// DialTimeout, if non-zero, specifies the maximum amount of
+	// time a dial, by DialContext, Dial, DialTLSContext or
+	// net.Dial, may take.
+	DialTimeout time.Duration
+
+	// FallbackDelay specifies, when a host has several addresses,
+	// how long to wait for a connection to one before also trying
+	// the next, IPv6 and IPv4 addresses alternating, as RFC 8305
+	// describes. If zero, a delay of 250ms is used. If negative,
+	// the next address is tried only when the last one fails.
+	// FallbackDelay is not used with Dial or DialContext.
+	FallbackDelay time.Duration
+
+	// Resolver, if non-nil, looks up the addresses of the hosts
+	// to dial, such as with a DNSCache. If nil, the addresses are
+	// looked up with net.DefaultResolver. Resolver is not used
+	// with Dial or DialContext.
+	Resolver Resolver
+
+	// ProxyHeader, if non-nil, returns the PROXY protocol header
+	// to send at the start of each new connection made for a
+	// request, or nil to send none, as for backends behind a proxy
+	// reading it with ReadProxyHeader. A nil Source or Destination
+	// is filled in with the connection's local or remote address.
+	// The TLVs of a version 2 header carry what else the backend
+	// should know of the connection, such as a tenant's ID or
+	// namespace. Connections are reused only for requests given
+	// the same header. The header is the first thing written to a
+	// connection, before any TLS the Transport sets up.
+	ProxyHeader func(*Request) *ProxyLine
+
 	// TLSClientConfig specifies the TLS configuration to use with
 	// tls.Client. If nil, the default configuration is used.
 	TLSClientConfig *tls.Config
 
+	// TLSHandshakeTimeout, if non-zero, specifies the maximum
+	// amount of time to wait for a TLS handshake.
+	TLSHandshakeTimeout time.Duration
+
 	// DisableKeepAlives, if true, prevents re-use of TCP connections
 	// between different HTTP requests.
 	DisableKeepAlives bool
@@ -77,29 +145,100 @@ type Transport struct {
 	// uncompressed.
 	DisableCompression bool
 
+	// ContentDecoders maps content codings other than gzip, such
+	// as "deflate" or "br", to their decoders. When the Transport
+	// requests compression itself, it asks for these codings as
+	// well as gzip and transparently decodes bodies using them.
+	// DecodeDeflate decodes "deflate".
+	ContentDecoders map[string]ContentDecoder
+
+	// MaxResponseBodyBytes, if non-zero, limits the number of bytes
+	// of a response body, after any decoding, that may be read.
+	// Reading more fails with ErrResponseBodyTooLarge and closes
+	// the connection.
+	MaxResponseBodyBytes int64
+
+	// MaxIdleConns, if non-zero, controls the maximum number of
+	// idle (keep-alive) connections across all hosts. When it's
+	// reached, the connection idle the longest is closed.
+	MaxIdleConns int
+
 	// MaxIdleConnsPerHost, if non-zero, controls the maximum idle
 	// (keep-alive) to keep per-host.  If zero,
 	// DefaultMaxIdleConnsPerHost is used.
 	MaxIdleConnsPerHost int
 
+	// MaxConnsPerHost, if non-zero, limits the number of
+	// connections per host, counting those being dialed, in use
+	// and idle. Requests needing a connection beyond the limit
+	// wait for one to become idle or be closed.
+	MaxConnsPerHost int
+
+	// IdleConnTimeout, if non-zero, is the maximum amount of time
+	// a connection stays idle before it's closed.
+	IdleConnTimeout time.Duration
+
+	// OnConnEvent, if non-nil, is called when a connection is
+	// created, closed or evicted from the pool, such as to log it
+	// or update metrics. PoolStats returns the counts of the pool.
+	// It is called synchronously, and must not block.
+	OnConnEvent func(ConnEvent)
+
 	// ResponseHeaderTimeout, if non-zero, specifies the amount of
 	// time to wait for a server's response headers after fully
 	// writing the request (including its body, if any). This
 	// time does not include the time to read the response body.
 	ResponseHeaderTimeout time.Duration
 
-	// TODO: tunable on global max cached connections
-	// TODO: tunable on timeout on cached connections
+	// ExpectContinueTimeout, if non-zero, specifies the amount of
+	// time to wait for a server's first response headers after
+	// fully writing the request headers if the request has an
+	// "Expect: 100-continue" header. The body is sent when the
+	// server answers 100 Continue or the timeout passes, and not
+	// at all if the server answers with a final status and closes
+	// the connection. If zero, the body is sent without waiting.
+	ExpectContinueTimeout time.Duration
+
+	// Tracer, if non-nil, starts a span for each round trip, ending
+	// when the response header has been read.
+	Tracer Tracer
+
+	// Retry, if non-nil, has requests retried as it specifies
+	// when their connection fails or the server is unavailable.
+	Retry *RetryPolicy
+
+	// CircuitBreaker, if non-nil, fails requests with
+	// ErrCircuitOpen, without sending them, to hosts that have
+	// been failing. Each retry under Retry counts as a request.
+	CircuitBreaker *CircuitBreaker
+
+	// Hedge, if non-nil, has requests hedged as it specifies,
+	// sending copies of those slow to be answered.
+	Hedge *HedgePolicy
+
+	// BodyTransformers, if any, transform the bodies of the
+	// requests sent and of the responses received, in turn, as they
+	// stream.
+	BodyTransformers []BodyTransformer
 }
 
 // ProxyFromEnvironment returns the URL of the proxy to use for a
 // given request, as indicated by the environment variables
-// $HTTP_PROXY and $NO_PROXY (or $http_proxy and $no_proxy).
+// $HTTP_PROXY, $HTTPS_PROXY and $NO_PROXY (or $http_proxy,
+// $https_proxy and $no_proxy). $HTTPS_PROXY, if set, takes precedence
+// over $HTTP_PROXY for https requests. A proxy address without a
+// scheme is taken to be that of an HTTP proxy.
 // An error is returned if the proxy environment is invalid.
 // A nil URL and nil error are returned if no proxy is defined in the
 // environment, or a proxy should not be used for the given request.
 func ProxyFromEnvironment(req *Request) (*url.URL, error) {
-	proxy := getenvEitherCase("HTTP_PROXY")
+	var proxy string
+	if req.URL.Scheme == "https" {
+		proxy = getenvEitherCase("HTTPS_PROXY")
+	}
+	if proxy == "" {
+		proxy = getenvEitherCase("HTTP_PROXY")
+	}
 	if proxy == "" {
 		return nil, nil
 	}
@@ -107,7 +246,7 @@ func ProxyFromEnvironment(req *Request) (*url.URL, error) {
 		return nil, nil
 	}
 	proxyURL, err := url.Parse(proxy)
-	if err != nil || !strings.HasPrefix(proxyURL.Scheme, "http") {
+	if err != nil || !validProxyScheme(proxyURL.Scheme) || proxyURL.Scheme == "" {
 		// proxy was bogus. Try prepending "http://" to it and
 		// see if that parses correctly. If not, we fall
 		// through and complain about the original one.
@@ -129,6 +268,16 @@ func ProxyURL(fixedURL *url.URL) func(*Request) (*url.URL, error) {
 	}
 }
 
+// validProxyScheme reports whether a proxy URL may have the scheme
+// scheme.
+func validProxyScheme(scheme string) bool {
+	switch scheme {
+	case "", "http", "https", "socks5":
+		return true
+	}
+	return false
+}
+
 // transportRequest is a wrapper around a *Request that adds
 // optional extra headers to write.
 type transportRequest struct {
@@ -148,6 +297,14 @@ func (tr *transportRequest) extraHeaders() Header {
 // For higher-level HTTP client support (such as handling of cookies
 // and redirects), see Get, Post, and the Client type.
 func (t *Transport) RoundTrip(req *Request) (resp *Response, err error) {
+	if t.Hedge != nil {
+		return t.hedgedRoundTrip(req)
+	}
+	return t.attempt(req)
+}
+
+// roundTrip makes a single attempt at the round trip of req.
+func (t *Transport) roundTrip(req *Request) (resp *Response, err error) {
 	if req.URL == nil {
 		return nil, errors.New("http: nil Request.URL")
 	}
@@ -169,6 +326,28 @@ func (t *Transport) RoundTrip(req *Request) (resp *Response, err error) {
 	if req.URL.Host == "" {
 		return nil, errors.New("http: no Host in request URL")
 	}
+	if cb := t.CircuitBreaker; cb != nil {
+		done, cerr := cb.allow(canonicalAddr(req.URL))
+		if cerr != nil {
+			return nil, cerr
+		}
+		defer func() { done(req, resp, err) }()
+	}
+	if t.Tracer != nil {
+		orig := req
+		var span Span
+		req, span = startClientSpan(t.Tracer, orig)
+		t.setTraced(orig, req)
+		defer func() {
+			endClientSpan(span, resp, err)
+			if err != nil {
+				t.setTraced(orig, nil)
+				return
+			}
+			resp.Request = orig
+			resp.Body = &cancelBody{resp.Body, func() { t.setTraced(orig, nil) }}
+		}()
+	}
 	treq := &transportRequest{Request: req}
 	cm, err := t.connectMethodForRequest(treq)
 	if err != nil {
@@ -179,11 +358,14 @@ func (t *Transport) RoundTrip(req *Request) (resp *Response, err error) {
 	// host (for http or https), the http proxy, or the http proxy
 	// pre-CONNECTed to https server.  In any case, we'll be ready
 	// to send it requests.
-	pconn, err := t.getConn(cm)
+	pconn, err := t.getConn(req.Context(), cm)
 	if err != nil {
 		return nil, err
 	}
 
+	if len(t.BodyTransformers) > 0 {
+		return t.transformRoundTrip(pconn, req)
+	}
 	return pconn.roundTrip(treq)
 }
 
@@ -217,10 +399,16 @@ func (t *Transport) CloseIdleConnections() {
 	m := t.idleConn
 	t.idleConn = nil
 	t.idleConnCh = nil
-	t.idleMu.Unlock()
-	if m == nil {
-		return
+	for _, conns := range m {
+		for _, pconn := range conns {
+			pconn.idleElem = nil
+			if pconn.idleTimer != nil {
+				pconn.idleTimer.Stop()
+			}
+		}
 	}
+	t.idleLRU.Init()
+	t.idleMu.Unlock()
 	for _, conns := range m {
 		for _, pconn := range conns {
 			pconn.close()
@@ -232,6 +420,26 @@ func (t *Transport) CloseIdleConnections() {
 // connection.
 func (t *Transport) CancelRequest(req *Request) {
 	t.reqMu.Lock()
+	if hs := t.hedging[req]; hs != nil {
+		hs.canceled = true
+		attempts, cancels := hs.attempts, hs.cancels
+		t.reqMu.Unlock()
+		for i, a := range attempts {
+			cancels[i]()
+			t.CancelRequest(a)
+		}
+		return
+	}
+	if rs := t.retrying[req]; rs != nil {
+		rs.cancelLocked()
+		req = rs.cur
+	}
+	if r2 := t.traced[req]; r2 != nil {
+		req = r2
+	}
+	if r2 := t.transformed[req]; r2 != nil {
+		req = r2
+	}
 	pc := t.reqConn[req]
 	t.reqMu.Unlock()
 	if pc != nil {
@@ -261,6 +469,12 @@ func (t *Transport) connectMethodForRequest(treq *transportRequest) (*connectMet
 		if err != nil {
 			return nil, err
 		}
+		if cm.proxyURL != nil && !validProxyScheme(cm.proxyURL.Scheme) {
+			return nil, &badStringError{"unsupported proxy scheme", cm.proxyURL.Scheme}
+		}
+	}
+	if t.ProxyHeader != nil {
+		cm.proxyLine = t.ProxyHeader(treq.Request)
 	}
 	return cm, nil
 }
@@ -298,6 +512,25 @@ func (t *Transport) putIdleConn(pconn *persistConn) bool {
 	}
 	t.idleMu.Lock()
 
+	if ws := t.connsWait[key]; len(ws) > 0 {
+		// Somebody is waiting at MaxConnsPerHost. The handoff is
+		// made under idleMu, which the waiter takes before giving
+		// up, so that it finds the connection if it does.
+		w := ws[0]
+		if len(ws) == 1 {
+			delete(t.connsWait, key)
+		} else {
+			t.connsWait[key] = ws[1:]
+		}
+		select {
+		case w <- pconn:
+			t.idleMu.Unlock()
+			return true
+		default:
+			// The waiter is gone; keep the connection idle.
+		}
+	}
+
 	waitingDialer := t.idleConnCh[key]
 	select {
 	case waitingDialer <- pconn:
@@ -320,7 +553,7 @@ func (t *Transport) putIdleConn(pconn *persistConn) bool {
 	}
 	if len(t.idleConn[key]) >= max {
 		t.idleMu.Unlock()
-		pconn.close()
+		pconn.evict()
 		return false
 	}
 	for _, exist := range t.idleConn[key] {
@@ -329,10 +562,68 @@ func (t *Transport) putIdleConn(pconn *persistConn) bool {
 		}
 	}
 	t.idleConn[key] = append(t.idleConn[key], pconn)
+	pconn.idleElem = t.idleLRU.PushBack(pconn)
+	var evict *persistConn
+	if t.MaxIdleConns > 0 && t.idleLRU.Len() > t.MaxIdleConns {
+		evict = t.idleLRU.Front().Value.(*persistConn)
+		t.removeIdleConnLocked(evict)
+	}
+	if d := t.IdleConnTimeout; d > 0 {
+		pconn.idleAt = time.Now()
+		if pconn.idleTimer == nil {
+			pconn.idleTimer = time.AfterFunc(d, pconn.closeIfStillIdle)
+		} else {
+			pconn.idleTimer.Reset(d)
+		}
+	}
 	t.idleMu.Unlock()
+	if evict != nil {
+		evict.evict()
+	}
 	return true
 }
 
+// removeIdleConnLocked removes the idle pconn from the idle lists.
+// t.idleMu must be held.
+func (t *Transport) removeIdleConnLocked(pconn *persistConn) {
+	if pconn.idleElem == nil {
+		return
+	}
+	t.idleLRU.Remove(pconn.idleElem)
+	pconn.idleElem = nil
+	if pconn.idleTimer != nil {
+		pconn.idleTimer.Stop()
+	}
+	key := pconn.cacheKey
+	pconns := t.idleConn[key]
+	for i, pc := range pconns {
+		if pc == pconn {
+			pconns = append(pconns[:i], pconns[i+1:]...)
+			break
+		}
+	}
+	if len(pconns) == 0 {
+		delete(t.idleConn, key)
+	} else {
+		t.idleConn[key] = pconns
+	}
+}
+
+// closeIfStillIdle closes pc once it has been idle for the
+// Transport's IdleConnTimeout.
+func (pc *persistConn) closeIfStillIdle() {
+	t := pc.t
+	t.idleMu.Lock()
+	if pc.idleElem == nil || time.Since(pc.idleAt) < t.IdleConnTimeout {
+		// Reused, or idle again since the timer was reset.
+		t.idleMu.Unlock()
+		return
+	}
+	t.removeIdleConnLocked(pc)
+	t.idleMu.Unlock()
+	pc.evict()
+}
+
 // getIdleConnCh returns a channel to receive and return idle
 // persistent connection for the given connectMethod.
 // It may return nil, if persistent connections are not being used.
@@ -355,32 +646,111 @@ func (t *Transport) getIdleConnCh(cm *connectMethod) chan *persistConn {
 }
 
 func (t *Transport) getIdleConn(cm *connectMethod) (pconn *persistConn) {
-	key := cm.key()
 	t.idleMu.Lock()
 	defer t.idleMu.Unlock()
-	if t.idleConn == nil {
-		return nil
-	}
+	return t.getIdleConnLocked(cm.key())
+}
+
+func (t *Transport) getIdleConnLocked(key string) (pconn *persistConn) {
 	for {
 		pconns, ok := t.idleConn[key]
 		if !ok {
 			return nil
 		}
-		if len(pconns) == 1 {
-			pconn = pconns[0]
-			delete(t.idleConn, key)
-		} else {
-			// 2 or more cached connections; pop last
-			// TODO: queue?
-			pconn = pconns[len(pconns)-1]
-			t.idleConn[key] = pconns[0 : len(pconns)-1]
-		}
+		// Pop the most recently used.
+		// TODO: queue?
+		pconn = pconns[len(pconns)-1]
+		t.removeIdleConnLocked(pconn)
 		if !pconn.isBroken() {
 			return
 		}
 	}
 }
 
+// connsPerHostSem returns the semaphore holding a slot for each
+// connection to key, or nil if the Transport has no MaxConnsPerHost.
+func (t *Transport) connsPerHostSem(key string) chan struct{} {
+	if t.MaxConnsPerHost <= 0 {
+		return nil
+	}
+	t.idleMu.Lock()
+	defer t.idleMu.Unlock()
+	if t.connsPerHost == nil {
+		t.connsPerHost = make(map[string]chan struct{})
+	}
+	sem, ok := t.connsPerHost[key]
+	if !ok {
+		sem = make(chan struct{}, t.MaxConnsPerHost)
+		t.connsPerHost[key] = sem
+	}
+	return sem
+}
+
+// waitConnSlot takes a slot of sem for a new connection to key,
+// waiting if need be, or returns a connection to key that became
+// idle first. It gives up when ctx is done.
+func (t *Transport) waitConnSlot(ctx context.Context, key string, sem chan struct{}) (*persistConn, error) {
+	select {
+	case sem <- struct{}{}:
+		return nil, nil
+	default:
+	}
+	t.idleMu.Lock()
+	if pc := t.getIdleConnLocked(key); pc != nil {
+		t.idleMu.Unlock()
+		return pc, nil
+	}
+	if t.connsWait == nil {
+		t.connsWait = make(map[string][]chan *persistConn)
+	}
+	w := make(chan *persistConn, 1)
+	t.connsWait[key] = append(t.connsWait[key], w)
+	t.idleMu.Unlock()
+
+	select {
+	case pc := <-w:
+		return pc, nil
+	case sem <- struct{}{}:
+		if pc := t.stopConnWait(key, w); pc != nil {
+			// Handed a connection as the slot came free.
+			<-sem
+			return pc, nil
+		}
+		return nil, nil
+	case <-ctx.Done():
+		if pc := t.stopConnWait(key, w); pc != nil {
+			// Handed a connection as the request was canceled.
+			t.putIdleConn(pc)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// stopConnWait removes w from the requests waiting at MaxConnsPerHost
+// for key, returning the connection it was handed meanwhile, if any.
+func (t *Transport) stopConnWait(key string, w chan *persistConn) *persistConn {
+	t.idleMu.Lock()
+	ws := t.connsWait[key]
+	for i, w1 := range ws {
+		if w1 == w {
+			ws = append(ws[:i], ws[i+1:]...)
+			break
+		}
+	}
+	if len(ws) == 0 {
+		delete(t.connsWait, key)
+	} else {
+		t.connsWait[key] = ws
+	}
+	t.idleMu.Unlock()
+	select {
+	case pc := <-w:
+		return pc
+	default:
+		return nil
+	}
+}
+
 func (t *Transport) setReqConn(r *Request, pc *persistConn) {
 	t.reqMu.Lock()
 	defer t.reqMu.Unlock()
@@ -394,21 +764,98 @@ func (t *Transport) setReqConn(r *Request, pc *persistConn) {
 	}
 }
 
-func (t *Transport) dial(network, addr string) (c net.Conn, err error) {
-	if t.Dial != nil {
+func (t *Transport) dial(ctx context.Context, network, addr string) (c net.Conn, err error) {
+	d := t.DialTimeout
+	if t.DialContext != nil {
+		return dialContextTimeout(ctx, t.DialContext, d, network, addr)
+	}
+	if t.Dial == nil {
+		return t.dialHappyEyeballs(ctx, network, addr)
+	}
+	if d <= 0 {
 		return t.Dial(network, addr)
 	}
-	return net.Dial(network, addr)
+	type dialRes struct {
+		c   net.Conn
+		err error
+	}
+	dialc := make(chan dialRes, 1)
+	go func() {
+		c, err := t.Dial(network, addr)
+		dialc <- dialRes{c, err}
+	}()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case v := <-dialc:
+		return v.c, v.err
+	case <-timer.C:
+		go func() {
+			// Close the connection of a dial
+			// finishing too late.
+			if v := <-dialc; v.err == nil {
+				v.c.Close()
+			}
+		}()
+		return nil, &timeoutError{"net/http: dial timeout"}
+	}
+}
+
+// dialContextTimeout calls dial with ctx, limited to the DialTimeout
+// d if non-zero.
+func dialContextTimeout(ctx context.Context, dial func(context.Context, string, string) (net.Conn, error), d time.Duration, network, addr string) (net.Conn, error) {
+	if d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	return dial(ctx, network, addr)
+}
+
+// tlsHandshake runs the handshake of tc, giving up after the
+// TLSHandshakeTimeout. The caller closes the connection on error.
+func (t *Transport) tlsHandshake(tc *tls.Conn) error {
+	d := t.TLSHandshakeTimeout
+	if d <= 0 {
+		return tc.Handshake()
+	}
+	errc := make(chan error, 2)
+	timer := time.AfterFunc(d, func() {
+		errc <- &timeoutError{"net/http: TLS handshake timeout"}
+	})
+	go func() {
+		errc <- tc.Handshake()
+	}()
+	err := <-errc
+	timer.Stop()
+	return err
+}
+
+// timeoutError is the error of a Transport or Client timeout. Like a
+// net.Error, it reports that it's a timeout.
+type timeoutError struct {
+	err string
 }
 
+func (e *timeoutError) Error() string   { return e.err }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
 // getConn dials and creates a new persistConn to the target as
 // specified in the connectMethod.  This includes doing a proxy CONNECT
 // and/or setting up TLS.  If this doesn't return an error, the persistConn
 // is ready to write requests to.
-func (t *Transport) getConn(cm *connectMethod) (*persistConn, error) {
+func (t *Transport) getConn(ctx context.Context, cm *connectMethod) (*persistConn, error) {
 	if pc := t.getIdleConn(cm); pc != nil {
 		return pc, nil
 	}
+	sem := t.connsPerHostSem(cm.key())
+	if sem != nil {
+		pc, err := t.waitConnSlot(ctx, cm.key(), sem)
+		if pc != nil || err != nil {
+			return pc, err
+		}
+	}
 
 	type dialRes struct {
 		pc  *persistConn
@@ -416,10 +863,20 @@ func (t *Transport) getConn(cm *connectMethod) (*persistConn, error) {
 	}
 	dialc := make(chan dialRes)
 	go func() {
-		pc, err := t.dialConn(cm)
+		pc, err := t.countedDial(ctx, cm, sem)
+		if err != nil && sem != nil {
+			<-sem
+		}
 		dialc <- dialRes{pc, err}
 	}()
 
+	// giveAway hands the connection our dial makes, after it's
+	// no longer needed, to the idle pool.
+	giveAway := func() {
+		if v := <-dialc; v.err == nil {
+			t.putIdleConn(v.pc)
+		}
+	}
 	idleConnCh := t.getIdleConnCh(cm)
 	select {
 	case v := <-dialc:
@@ -431,38 +888,78 @@ func (t *Transport) getConn(cm *connectMethod) (*persistConn, error) {
 		// else's dial that they didn't use.
 		// But our dial is still going, so give it away
 		// when it finishes:
-		go func() {
-			if v := <-dialc; v.err == nil {
-				t.putIdleConn(v.pc)
-			}
-		}()
+		go giveAway()
 		return pc, nil
+	case <-ctx.Done():
+		go giveAway()
+		return nil, ctx.Err()
 	}
 }
 
-func (t *Transport) dialConn(cm *connectMethod) (*persistConn, error) {
-	conn, err := t.dial("tcp", cm.addr())
+// dialConn dials a new persistConn, which releases its slot of sem,
+// if non-nil, when closed.
+func (t *Transport) dialConn(ctx context.Context, cm *connectMethod, sem chan struct{}) (*persistConn, error) {
+	var conn net.Conn
+	var err error
+	dialedTLS := cm.proxyURL == nil && cm.targetScheme == "https" && t.DialTLSContext != nil
+	if dialedTLS {
+		conn, err = dialContextTimeout(ctx, t.DialTLSContext, t.DialTimeout, "tcp", cm.addr())
+	} else {
+		conn, err = t.dial(ctx, "tcp", cm.addr())
+	}
 	if err != nil {
 		if cm.proxyURL != nil {
 			err = fmt.Errorf("http: error connecting to proxy %s: %v", cm.proxyURL, err)
 		}
 		return nil, err
 	}
+	if cm.proxyLine != nil {
+		line := *cm.proxyLine
+		if line.Source == nil {
+			line.Source = conn.LocalAddr()
+		}
+		if line.Destination == nil {
+			line.Destination = conn.RemoteAddr()
+		}
+		b, err := line.Bytes()
+		if err == nil {
+			_, err = conn.Write(b)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
 
 	pa := cm.proxyAuth()
 
 	pconn := &persistConn{
 		t:        t,
 		cacheKey: cm.key(),
+		addr:     cm.addr(),
 		conn:     conn,
 		reqch:    make(chan requestAndChan, 50),
 		writech:  make(chan writeRequest, 50),
 		closech:  make(chan struct{}),
+		hostSem:  sem,
+	}
+
+	if cm.proxyURL != nil && cm.proxyURL.Scheme == "https" {
+		// Speak TLS to the proxy itself.
+		if conn, err = t.tlsClient(conn, cm.proxyURL.Hostname()); err != nil {
+			return nil, err
+		}
+		pconn.conn = conn
 	}
 
 	switch {
 	case cm.proxyURL == nil:
 		// Do nothing.
+	case cm.proxyURL.Scheme == "socks5":
+		if err := socks5Connect(conn, cm.proxyURL, cm.targetAddr); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("http: SOCKS5 proxy %s: %v", cm.proxyURL.Host, err)
+		}
 	case cm.targetScheme == "http":
 		pconn.isProxy = true
 		if pa != "" {
@@ -498,29 +995,14 @@ func (t *Transport) dialConn(cm *connectMethod) (*persistConn, error) {
 		}
 	}
 
-	if cm.targetScheme == "https" {
-		// Initiate TLS and check remote host name against certificate.
-		cfg := t.TLSClientConfig
-		if cfg == nil || cfg.ServerName == "" {
-			host := cm.tlsHost()
-			if cfg == nil {
-				cfg = &tls.Config{ServerName: host}
-			} else {
-				clone := *cfg // shallow clone
-				clone.ServerName = host
-				cfg = &clone
-			}
+	if cm.targetScheme == "https" && !dialedTLS {
+		name := cm.tlsHost()
+		if cfg := t.TLSClientConfig; cfg != nil && cfg.ServerName != "" {
+			name = cfg.ServerName
 		}
-		conn = tls.Client(conn, cfg)
-		if err = conn.(*tls.Conn).Handshake(); err != nil {
+		if pconn.conn, err = t.tlsClient(conn, name); err != nil {
 			return nil, err
 		}
-		if !cfg.InsecureSkipVerify {
-			if err = conn.(*tls.Conn).VerifyHostname(cfg.ServerName); err != nil {
-				return nil, err
-			}
-		}
-		pconn.conn = conn
 	}
 
 	pconn.br = bufio.NewReader(pconn.conn)
@@ -530,6 +1012,32 @@ func (t *Transport) dialConn(cm *connectMethod) (*persistConn, error) {
 	return pconn, nil
 }
 
+// tlsClient initiates TLS over conn with the server named serverName
+// and checks that name against the certificate, closing conn on
+// failure.
+func (t *Transport) tlsClient(conn net.Conn, serverName string) (*tls.Conn, error) {
+	cfg := t.TLSClientConfig
+	if cfg == nil {
+		cfg = &tls.Config{ServerName: serverName}
+	} else if cfg.ServerName != serverName {
+		clone := *cfg // shallow clone
+		clone.ServerName = serverName
+		cfg = &clone
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := t.tlsHandshake(tlsConn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !cfg.InsecureSkipVerify {
+		if err := tlsConn.VerifyHostname(cfg.ServerName); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return tlsConn, nil
+}
+
 // useProxy returns true if requests to addr should use a proxy,
 // according to the NO_PROXY or no_proxy environment variable.
 // addr is always a canonicalAddr with a host and port.
@@ -594,13 +1102,15 @@ func useProxy(addr string) bool {
 // ||https|foo.com               https directly to server, no proxy
 // http://proxy.com|https|foo.com  http to proxy, then CONNECT to foo.com
 // http://proxy.com|http           http to proxy, http to anywhere after that
-//
-// Note: no support to https to the proxy yet.
+// https://proxy.com|http          https to proxy, http to anywhere after that
+// socks5://proxy.com|http|foo.com  SOCKS5 to proxy, then http to foo.com
+// socks5://proxy.com|https|foo.com SOCKS5 to proxy, then https to foo.com
 //
 type connectMethod struct {
-	proxyURL     *url.URL // nil for no proxy, else full proxy URL
-	targetScheme string   // "http" or "https"
-	targetAddr   string   // Not used if proxy + http targetScheme (4th example in table)
+	proxyURL     *url.URL   // nil for no proxy, else full proxy URL
+	targetScheme string     // "http" or "https"
+	targetAddr   string     // Not used if proxy + http targetScheme (4th example in table)
+	proxyLine    *ProxyLine // PROXY protocol header to send, or nil
 }
 
 func (ck *connectMethod) key() string {
@@ -612,11 +1122,16 @@ func (ck *connectMethod) String() string {
 	targetAddr := ck.targetAddr
 	if ck.proxyURL != nil {
 		proxyStr = ck.proxyURL.String()
-		if ck.targetScheme == "http" {
+		if ck.targetScheme == "http" && ck.proxyURL.Scheme != "socks5" {
 			targetAddr = ""
 		}
 	}
-	return strings.Join([]string{proxyStr, ck.targetScheme, targetAddr}, "|")
+	s := strings.Join([]string{proxyStr, ck.targetScheme, targetAddr}, "|")
+	if l := ck.proxyLine; l != nil {
+		b, _ := l.Bytes() // an error fails the dial
+		s += fmt.Sprintf("|%v %v %x", l.Source, l.Destination, b)
+	}
+	return s
 }
 
 // addr returns the first hop "host:port" to which we need to TCP connect.
@@ -630,7 +1145,11 @@ func (cm *connectMethod) addr() string {
 // tlsHost returns the host name to match against the peer's
 // TLS certificate.
 func (cm *connectMethod) tlsHost() string {
-	h := cm.targetAddr
+	return hostOnly(cm.targetAddr)
+}
+
+// hostOnly returns the host of a "host" or "host:port".
+func hostOnly(h string) string {
 	if hasPort(h) {
 		h = h[:strings.LastIndex(h, ":")]
 	}
@@ -642,6 +1161,7 @@ func (cm *connectMethod) tlsHost() string {
 type persistConn struct {
 	t        *Transport
 	cacheKey string // its connectMethod.String()
+	addr     string // dialed, for ConnEvents
 	conn     net.Conn
 	closed   bool                // whether conn has been closed
 	br       *bufio.Reader       // from conn
@@ -650,6 +1170,12 @@ type persistConn struct {
 	writech  chan writeRequest   // written by roundTrip; read by writeLoop
 	closech  chan struct{}       // broadcast close when readLoop (TCP connection) closes
 	isProxy  bool
+	hostSem  chan struct{} // or nil; has a slot of MaxConnsPerHost
+
+	// Guarded by t.idleMu:
+	idleElem  *list.Element // in t.idleLRU, while idle
+	idleAt    time.Time     // when last made idle
+	idleTimer *time.Timer   // for IdleConnTimeout
 
 	lk                   sync.Mutex // guards following 3 fields
 	numExpectedResponses int
@@ -688,8 +1214,11 @@ func (pc *persistConn) readLoop() {
 
 		pc.lk.Lock()
 		if pc.numExpectedResponses == 0 {
-			pc.closeLocked()
+			closed := pc.closeLocked()
 			pc.lk.Unlock()
+			if closed {
+				pc.t.connClosed(pc, false)
+			}
 			if len(pb) > 0 {
 				log.Printf("Unsolicited response received on idle HTTP channel starting with %q; err=%v",
 					string(pb), err)
@@ -702,33 +1231,41 @@ func (pc *persistConn) readLoop() {
 
 		var resp *Response
 		if err == nil {
-			resp, err = ReadResponse(pc.br, rc.req)
-			if err == nil && resp.StatusCode == 100 {
-				// Skip any 100-continue for now.
-				// TODO(bradfitz): if rc.req had "Expect: 100-continue",
-				// actually block the request body write and signal the
-				// writeLoop now to begin sending it. (Issue 2184) For now we
-				// eat it, since we're never expecting one.
-				resp, err = ReadResponse(pc.br, rc.req)
+			resp, err = pc.readResponse(&rc)
+			if err == nil && rc.continueCh != nil {
+				// A final status came first. The body must
+				// still be sent, unless the connection is
+				// closed after this response.
+				if resp.Close || rc.req.Close {
+					close(rc.continueCh)
+				} else {
+					rc.continueCh <- struct{}{}
+				}
 			}
 		}
+		if err == nil && resp.StatusCode == StatusSwitchingProtocols {
+			// The connection now speaks the protocol switched
+			// to, read and written through the response's Body.
+			pc.markBroken()
+			resp.Body = &switchedBody{pc}
+			pc.t.setReqConn(rc.req, nil)
+			rc.ch <- responseAndError{resp, nil}
+			return
+		}
 		hasBody := resp != nil && rc.req.Method != "HEAD" && resp.ContentLength != 0
 
 		if err != nil {
 			pc.close()
 		} else {
-			if rc.addedGzip && hasBody && resp.Header.Get("Content-Encoding") == "gzip" {
-				resp.Header.Del("Content-Encoding")
-				resp.Header.Del("Content-Length")
-				resp.ContentLength = -1
-				gzReader, zerr := gzip.NewReader(resp.Body)
-				if zerr != nil {
+			if rc.addedCompression && hasBody {
+				if zerr := pc.t.decodeBody(resp); zerr != nil {
 					pc.close()
 					err = zerr
-				} else {
-					resp.Body = &readerAndCloser{gzReader, resp.Body}
 				}
 			}
+			if max := pc.t.MaxResponseBodyBytes; max > 0 {
+				resp.Body = &maxBytesBody{rc: resp.Body, n: max}
+			}
 			resp.Body = &bodyEOFSignal{body: resp.Body}
 		}
 
@@ -786,15 +1323,65 @@ func (pc *persistConn) readLoop() {
 	}
 }
 
+// switchedBody is the Body of a 101 Switching Protocols response, an
+// io.ReadWriteCloser of the connection, which the caller now owns.
+type switchedBody struct {
+	pc *persistConn
+}
+
+func (b *switchedBody) Read(p []byte) (int, error)  { return b.pc.br.Read(p) }
+func (b *switchedBody) Write(p []byte) (int, error) { return b.pc.conn.Write(p) }
+func (b *switchedBody) Close() error {
+	b.pc.close()
+	return nil
+}
+
+// max1xxResponses is the number of informational responses, other
+// than 100 Continue, a Transport reads before a final response.
+const max1xxResponses = 5
+
+// readResponse reads the response to rc, passing informational (1xx)
+// responses before it, except 101 Switching Protocols, to the request's
+// httptrace.ClientTrace.
+func (pc *persistConn) readResponse(rc *requestAndChan) (*Response, error) {
+	trace := httptrace.ContextClientTrace(rc.req.Context())
+	num1xx := 0
+	for {
+		resp, err := ReadResponse(pc.br, rc.req)
+		if err != nil {
+			return nil, err
+		}
+		code := resp.StatusCode
+		if code < 100 || code > 199 || code == StatusSwitchingProtocols {
+			return resp, nil
+		}
+		if code == StatusContinue {
+			// Let the writeLoop send a body waiting
+			// for the 100-continue, if any.
+			if rc.continueCh != nil {
+				rc.continueCh <- struct{}{}
+				rc.continueCh = nil
+			}
+		} else if num1xx++; num1xx > max1xxResponses {
+			return nil, errors.New("http: too many 1xx informational responses")
+		}
+		if trace != nil && trace.Got1xxResponse != nil {
+			if err := trace.Got1xxResponse(code, textproto.MIMEHeader(resp.Header)); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
 func (pc *persistConn) writeLoop() {
 	for {
 		select {
 		case wr := <-pc.writech:
 			if pc.isBroken() {
-				wr.ch <- errors.New("http: can't write HTTP request on broken connection")
+				wr.ch <- errBrokenConn
 				continue
 			}
-			err := wr.req.Request.write(pc.bw, pc.isProxy, wr.req.extra)
+			err := wr.req.Request.write(pc.bw, pc.isProxy, wr.req.extra, pc.waitForContinue(wr.continueCh))
 			if err == nil {
 				err = pc.bw.Flush()
 			}
@@ -808,6 +1395,11 @@ func (pc *persistConn) writeLoop() {
 	}
 }
 
+var (
+	errBrokenConn           = errors.New("http: can't write HTTP request on broken connection")
+	errClosedBeforeResponse = errors.New("net/http: transport closed before response was received")
+)
+
 type responseAndError struct {
 	res *Response
 	err error
@@ -818,9 +1410,14 @@ type requestAndChan struct {
 	ch  chan responseAndError
 
 	// did the Transport (as opposed to the client code) add an
-	// Accept-Encoding gzip header? only if it we set it do
-	// we transparently decode the gzip.
-	addedGzip bool
+	// Accept-Encoding header? only if it we set it do we
+	// transparently decode the body.
+	addedCompression bool
+
+	// continueCh, if non-nil, is signaled by the readLoop to
+	// have the body of an "Expect: 100-continue" request sent,
+	// or closed to have it not sent.
+	continueCh chan<- struct{}
 }
 
 // A writeRequest is sent by the readLoop's goroutine to the
@@ -830,6 +1427,31 @@ type requestAndChan struct {
 type writeRequest struct {
 	req *transportRequest
 	ch  chan<- error
+
+	// continueCh, if non-nil, is the channel on which the
+	// writeLoop waits before writing the request body.
+	continueCh <-chan struct{}
+}
+
+// waitForContinue returns the function the writeLoop calls before
+// writing the body of a request waiting for a 100-continue on
+// continueCh, or nil. The function reports whether to send the body.
+func (pc *persistConn) waitForContinue(continueCh <-chan struct{}) func() bool {
+	if continueCh == nil {
+		return nil
+	}
+	return func() bool {
+		timer := time.NewTimer(pc.t.ExpectContinueTimeout)
+		defer timer.Stop()
+		select {
+		case _, ok := <-continueCh:
+			return ok
+		case <-timer.C:
+			return true
+		case <-pc.closech:
+			return false
+		}
+	}
 }
 
 func (pc *persistConn) roundTrip(req *transportRequest) (resp *Response, err error) {
@@ -845,11 +1467,12 @@ func (pc *persistConn) roundTrip(req *transportRequest) (resp *Response, err err
 
 	// Ask for a compressed version if the caller didn't set their
 	// own value for Accept-Encoding. We only attempted to
-	// uncompress the gzip stream if we were the layer that
+	// uncompress the stream if we were the layer that
 	// requested it.
-	requestedGzip := false
+	requestedCompression := false
 	if !pc.t.DisableCompression && req.Header.Get("Accept-Encoding") == "" && req.Method != "HEAD" {
-		// Request gzip only, not deflate. Deflate is ambiguous and
+		// Request gzip, and deflate only if there's a decoder
+		// for it in ContentDecoders. Deflate is ambiguous and
 		// not as universally supported anyway.
 		// See: http://www.gzip.org/zlib/zlib_faq.html#faq38
 		//
@@ -857,18 +1480,23 @@ func (pc *persistConn) roundTrip(req *transportRequest) (resp *Response, err err
 		// due to a bug in nginx:
 		//   http://trac.nginx.org/nginx/ticket/358
 		//   http://golang.org/issue/5522
-		requestedGzip = true
-		req.extraHeaders().Set("Accept-Encoding", "gzip")
+		requestedCompression = true
+		req.extraHeaders().Set("Accept-Encoding", pc.t.acceptEncoding())
 	}
 
 	// Write the request concurrently with waiting for a response,
 	// in case the server decides to reply before reading our full
 	// request body.
+	var continueCh chan struct{}
+	if pc.t.ExpectContinueTimeout > 0 && req.Body != nil && req.expectsContinue() {
+		continueCh = make(chan struct{}, 1)
+	}
+
 	writeErrCh := make(chan error, 1)
-	pc.writech <- writeRequest{req, writeErrCh}
+	pc.writech <- writeRequest{req, writeErrCh, continueCh}
 
 	resc := make(chan responseAndError, 1)
-	pc.reqch <- requestAndChan{req.Request, resc, requestedGzip}
+	pc.reqch <- requestAndChan{req.Request, resc, requestedCompression, continueCh}
 
 	var re responseAndError
 	var pconnDeadCh = pc.closech
@@ -902,11 +1530,11 @@ WaitResponse:
 			pconnDeadCh = nil                               // avoid spinning
 			failTicker = time.After(100 * time.Millisecond) // arbitrary time to wait for resc
 		case <-failTicker:
-			re = responseAndError{err: errors.New("net/http: transport closed before response was received")}
+			re = responseAndError{err: errClosedBeforeResponse}
 			break WaitResponse
 		case <-respHeaderTimer:
 			pc.close()
-			re = responseAndError{err: errors.New("net/http: timeout awaiting response headers")}
+			re = responseAndError{err: &timeoutError{"net/http: timeout awaiting response headers"}}
 			break WaitResponse
 		case re = <-resc:
 			break WaitResponse
@@ -934,27 +1562,48 @@ func (pc *persistConn) markBroken() {
 
 func (pc *persistConn) close() {
 	pc.lk.Lock()
-	defer pc.lk.Unlock()
-	pc.closeLocked()
+	closed := pc.closeLocked()
+	pc.lk.Unlock()
+	if closed {
+		pc.t.connClosed(pc, false)
+	}
 }
 
-func (pc *persistConn) closeLocked() {
-	pc.broken = true
-	if !pc.closed {
-		pc.conn.Close()
-		pc.closed = true
+// evict closes pc, idle, for the limits of the pool.
+func (pc *persistConn) evict() {
+	pc.lk.Lock()
+	closed := pc.closeLocked()
+	pc.lk.Unlock()
+	if closed {
+		pc.t.connClosed(pc, true)
 	}
+}
+
+// closeLocked closes pc, reporting whether it wasn't already.
+func (pc *persistConn) closeLocked() (closed bool) {
+	pc.broken = true
 	pc.mutateHeaderFunc = nil
+	if pc.closed {
+		return false
+	}
+	pc.conn.Close()
+	pc.closed = true
+	if pc.hostSem != nil {
+		<-pc.hostSem
+	}
+	return true
 }
 
 var portMap = map[string]string{
-	"http":  "80",
-	"https": "443",
+	"http":   "80",
+	"https":  "443",
+	"socks5": "1080",
 }
 
-// canonicalAddr returns url.Host but always with a ":port" suffix
+// canonicalAddr returns url.Host, in lower case and with IDNA
+// encoding, but always with a ":port" suffix
 func canonicalAddr(url *url.URL) string {
-	addr := url.Host
+	addr := asciiHost(url.Host)
 	if !hasPort(addr) {
 		return addr + ":" + portMap[url.Scheme]
 	}
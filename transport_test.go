@@ -10,7 +10,10 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/rand"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -709,6 +712,41 @@ func TestTransportProxy(t *testing.T) {
 	}
 }
 
+// The TLS connection to an https proxy is checked against the proxy's
+// host name, not the ServerName of TLSClientConfig, which is the
+// target's.
+func TestTransportHTTPSProxy(t *testing.T) {
+	defer afterTest(t)
+	ca := newTestCA(t)
+	cert, _, _ := ca.issue(t, "proxy.example")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go (&Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "proxy for "+r.URL.String())
+	})}).Serve(tls.NewListener(ln, &tls.Config{Certificates: []tls.Certificate{cert}}))
+
+	tr := &Transport{
+		Proxy: ProxyURL(&url.URL{Scheme: "https", Host: "proxy.example:443"}),
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.Dial(network, ln.Addr().String())
+		},
+		TLSClientConfig: &tls.Config{ServerName: "target.example", RootCAs: ca.pool()},
+	}
+	defer tr.CloseIdleConnections()
+	res, err := (&Client{Transport: tr}).Get("http://target.example/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if want := "proxy for http://target.example/x"; string(body) != want {
+		t.Errorf("got %q; want %q", body, want)
+	}
+}
+
 // TestTransportGzipRecursive sends a gzip quine and checks that the
 // client gets the same value back. This is more cute than anything,
 // but checks that we don't recurse forever, and checks that
@@ -1411,6 +1449,47 @@ func TestTransportSocketLateBinding(t *testing.T) {
 	dialGate <- true
 }
 
+func TestTransportSwitchingProtocols(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		conn, brw, err := w.(Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		line, _ := brw.ReadString('\n')
+		brw.WriteString("echo: " + line)
+		brw.Flush()
+	}))
+	defer ts.Close()
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+
+	req, _ := NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != StatusSwitchingProtocols {
+		t.Fatalf("status = %d; want 101", res.StatusCode)
+	}
+	rwc, ok := res.Body.(io.ReadWriteCloser)
+	if !ok {
+		t.Fatalf("Body of type %T is not an io.ReadWriteCloser", res.Body)
+	}
+	defer rwc.Close()
+	io.WriteString(rwc, "hello\n")
+	got, err := bufio.NewReader(rwc).ReadString('\n')
+	if err != nil || got != "echo: hello\n" {
+		t.Errorf("read %q, %v; want the echo", got, err)
+	}
+}
+
 // Issue 2184
 func TestTransportReading100Continue(t *testing.T) {
 	defer afterTest(t)
@@ -1511,20 +1590,21 @@ Content-Length: %d
 	}
 
 	// And some other informational 1xx but non-100 responses, to test
-	// we return them but don't re-use the connection.
+	// we skip them for the final response.
 	for i := 1; i <= numReqs; i++ {
 		req, _ := NewRequest("POST", "http://other.tld/", strings.NewReader(reqBody(i)))
 		req.Header.Set("X-Want-Response-Code", "123 Sesame Street")
-		testResponse(req, fmt.Sprintf("123, %d/%d", i, numReqs), 123)
+		testResponse(req, fmt.Sprintf("123, %d/%d", i, numReqs), 200)
 	}
 }
 
 type proxyFromEnvTest struct {
-	req     string // URL to fetch; blank means "http://example.com"
-	env     string
-	noenv   string
-	want    string
-	wanterr error
+	req      string // URL to fetch; blank means "http://example.com"
+	env      string
+	httpsenv string
+	noenv    string
+	want     string
+	wanterr  error
 }
 
 func (t proxyFromEnvTest) String() string {
@@ -1532,6 +1612,9 @@ func (t proxyFromEnvTest) String() string {
 	if t.env != "" {
 		fmt.Fprintf(&buf, "http_proxy=%q", t.env)
 	}
+	if t.httpsenv != "" {
+		fmt.Fprintf(&buf, " https_proxy=%q", t.httpsenv)
+	}
 	if t.noenv != "" {
 		fmt.Fprintf(&buf, " no_proxy=%q", t.noenv)
 	}
@@ -1556,15 +1639,25 @@ var proxyFromEnvTests = []proxyFromEnvTest{
 	{noenv: "ample.com", req: "http://example.com/", env: "proxy", want: "http://proxy"},
 	{noenv: "example.com", req: "http://foo.example.com/", env: "proxy", want: "<nil>"},
 	{noenv: ".foo.com", req: "http://example.com/", env: "proxy", want: "http://proxy"},
+
+	{env: "socks5://127.0.0.1:1080", want: "socks5://127.0.0.1:1080"},
+	{req: "https://example.com/", env: "proxy", httpsenv: "secureproxy", want: "http://secureproxy"},
+	{req: "https://example.com/", env: "proxy", want: "http://proxy"},
+	{req: "http://example.com/", env: "proxy", httpsenv: "secureproxy", want: "http://proxy"},
+	{noenv: "example.com", req: "https://example.com/", httpsenv: "secureproxy", want: "<nil>"},
 }
 
 func TestProxyFromEnvironment(t *testing.T) {
 	os.Setenv("HTTP_PROXY", "")
 	os.Setenv("http_proxy", "")
+	os.Setenv("HTTPS_PROXY", "")
+	os.Setenv("https_proxy", "")
 	os.Setenv("NO_PROXY", "")
 	os.Setenv("no_proxy", "")
+	defer os.Setenv("HTTPS_PROXY", "")
 	for _, tt := range proxyFromEnvTests {
 		os.Setenv("HTTP_PROXY", tt.env)
+		os.Setenv("HTTPS_PROXY", tt.httpsenv)
 		os.Setenv("NO_PROXY", tt.noenv)
 		reqURL := tt.req
 		if reqURL == "" {
@@ -1688,3 +1781,340 @@ var rgz = []byte{
 	0x00, 0x00, 0x3d, 0xb1, 0x20, 0x85, 0xfa, 0x00,
 	0x00, 0x00,
 }
+
+func TestTransportDialTimeout(t *testing.T) {
+	defer afterTest(t)
+	unblock := make(chan bool)
+	defer close(unblock)
+	tr := &Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			<-unblock
+			return nil, errors.New("dial canceled")
+		},
+		DialTimeout: 50 * time.Millisecond,
+	}
+	c := &Client{Transport: tr}
+	_, err := c.Get("http://example.com/")
+	if err == nil || !strings.Contains(err.Error(), "dial timeout") {
+		t.Fatalf("error %v; want a dial timeout", err)
+	}
+	if ne, ok := err.(*url.Error).Err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("error %#v isn't a net.Error timeout", err.(*url.Error).Err)
+	}
+}
+
+func TestTransportTLSHandshakeTimeout(t *testing.T) {
+	defer afterTest(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		// Accept a connection and never answer the handshake.
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(ioutil.Discard, c)
+	}()
+	tr := &Transport{TLSHandshakeTimeout: 50 * time.Millisecond}
+	c := &Client{Transport: tr}
+	_, err = c.Get("https://" + ln.Addr().String() + "/")
+	if err == nil || !strings.Contains(err.Error(), "TLS handshake timeout") {
+		t.Fatalf("error %v; want a TLS handshake timeout", err)
+	}
+}
+
+// countingBody records how much of it has been read, and closes
+// closed when closed.
+type countingBody struct {
+	io.Reader
+	n      int
+	closed chan bool
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	b.n += n
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func TestTransportExpectContinue(t *testing.T) {
+	defer afterTest(t)
+	mux := NewServeMux()
+	mux.HandleFunc("/echo", func(w ResponseWriter, r *Request) {
+		io.Copy(w, r.Body)
+	})
+	mux.HandleFunc("/deny", func(w ResponseWriter, r *Request) {
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(StatusForbidden)
+	})
+	mux.HandleFunc("/slow", func(w ResponseWriter, r *Request) {
+		// Read too late for the client to wait.
+		time.Sleep(100 * time.Millisecond)
+		io.Copy(w, r.Body)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tests := []struct {
+		path    string
+		timeout time.Duration
+		status  int
+		sent    bool
+	}{
+		{"/echo", time.Hour, 200, true},
+		{"/deny", time.Hour, 403, false},
+		{"/slow", 10 * time.Millisecond, 200, true},
+	}
+	for _, tt := range tests {
+		tr := &Transport{ExpectContinueTimeout: tt.timeout}
+		body := &countingBody{Reader: strings.NewReader("body"), closed: make(chan bool)}
+		req, _ := NewRequest("PUT", ts.URL+tt.path, body)
+		req.ContentLength = 4
+		req.Header.Set("Expect", "100-continue")
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+			continue
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.status {
+			t.Errorf("%s: status %d; want %d", tt.path, res.StatusCode, tt.status)
+		}
+		select {
+		case <-body.closed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: body not closed", tt.path)
+		}
+		if sent := body.n == 4; sent != tt.sent || tt.sent && string(b) != "body" {
+			t.Errorf("%s: sent %d bytes of body; server echoed %q", tt.path, body.n, b)
+		}
+		tr.CloseIdleConnections()
+	}
+}
+
+func TestTransportMaxIdleConns(t *testing.T) {
+	defer afterTest(t)
+	ts1 := httptest.NewServer(hostPortHandler)
+	defer ts1.Close()
+	ts2 := httptest.NewServer(hostPortHandler)
+	defer ts2.Close()
+	tr := &Transport{MaxIdleConns: 1}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	for _, u := range []string{ts1.URL, ts2.URL} {
+		res, err := c.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+	keys := tr.IdleConnKeysForTesting()
+	if len(keys) != 1 || !strings.HasSuffix(keys[0], ts2.Listener.Addr().String()) {
+		t.Errorf("idle connection keys %q; want only that of %s", keys, ts2.URL)
+	}
+}
+
+func TestTransportIdleConnTimeout(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(hostPortHandler)
+	defer ts.Close()
+	tr := &Transport{IdleConnTimeout: 50 * time.Millisecond}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		res, err := c.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		addrs = append(addrs, string(b))
+		if len(tr.IdleConnKeysForTesting()) != 1 {
+			t.Fatalf("request %d: connection not kept idle", i)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for len(tr.IdleConnKeysForTesting()) != 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("request %d: idle connection not closed after IdleConnTimeout", i)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if addrs[0] == addrs[1] {
+		t.Errorf("connection from %s reused after IdleConnTimeout", addrs[0])
+	}
+}
+
+func TestTransportMaxConnsPerHost(t *testing.T) {
+	defer afterTest(t)
+	var mu sync.Mutex
+	addrs := make(map[string]bool)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		mu.Lock()
+		addrs[r.RemoteAddr] = true
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer ts.Close()
+	tr := &Transport{MaxConnsPerHost: 1}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+
+	const n = 5
+	errc := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			res, err := c.Get(ts.URL)
+			if err == nil {
+				res.Body.Close()
+			}
+			errc <- err
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errc; err != nil {
+			t.Error(err)
+		}
+	}
+	if len(addrs) != 1 {
+		t.Errorf("%d connections made; want 1", len(addrs))
+	}
+}
+
+// A request waiting for a connection slot at MaxConnsPerHost gives up
+// when its context is canceled.
+func TestTransportMaxConnsPerHostCancel(t *testing.T) {
+	defer afterTest(t)
+	holding, release := make(chan bool), make(chan bool)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/hold" {
+			holding <- true
+			<-release
+		}
+	}))
+	defer ts.Close()
+	tr := &Transport{MaxConnsPerHost: 1}
+	defer tr.CloseIdleConnections()
+
+	held := make(chan error, 1)
+	go func() {
+		req, _ := NewRequest("GET", ts.URL+"/hold", nil)
+		res, err := tr.RoundTrip(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		held <- err
+	}()
+
+	<-holding
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	req, _ := NewRequest("GET", ts.URL, nil)
+	if _, err := tr.RoundTrip(req.WithContext(ctx)); err != context.Canceled {
+		t.Errorf("waiting request: err = %v; want %v", err, context.Canceled)
+	}
+
+	close(release)
+	if err := <-held; err != nil {
+		t.Fatal(err)
+	}
+	req, _ = NewRequest("GET", ts.URL, nil)
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("request after the cancellation: %v", err)
+	}
+	res.Body.Close()
+}
+
+type dialTestKey struct{}
+
+func TestTransportDialContext(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(hostPortHandler)
+	defer ts.Close()
+	unblock := make(chan bool)
+	defer close(unblock)
+	var mu sync.Mutex
+	var dialed []string
+	tr := &Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			v, _ := ctx.Value(dialTestKey{}).(string)
+			mu.Lock()
+			dialed = append(dialed, v)
+			mu.Unlock()
+			if v == "block" {
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-unblock:
+				}
+			}
+			return net.Dial(network, addr)
+		},
+		Dial: func(network, addr string) (net.Conn, error) {
+			t.Error("Dial called with DialContext set")
+			return nil, errors.New("unexpected Dial")
+		},
+	}
+	defer tr.CloseIdleConnections()
+
+	req, _ := NewRequest("GET", ts.URL, nil)
+	req = req.WithContext(context.WithValue(req.Context(), dialTestKey{}, "value"))
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), dialTestKey{}, "block"))
+	time.AfterFunc(50*time.Millisecond, cancel)
+	req, _ = NewRequest("GET", ts.URL, nil)
+	tr.CloseIdleConnections()
+	if _, err = tr.RoundTrip(req.WithContext(ctx)); err != context.Canceled {
+		t.Errorf("RoundTrip with canceled dial: error %v; want context.Canceled", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dialed) != 2 || dialed[0] != "value" {
+		t.Errorf("dialed with context values %q", dialed)
+	}
+}
+
+func TestTransportDialTLSContext(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, r.Host)
+	}))
+	defer ts.Close()
+	// The "TLS" connection is the plain one to the test server.
+	tr := &Transport{
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if addr != "example.com:443" {
+				t.Errorf("DialTLSContext dialing %q", addr)
+			}
+			return net.Dial(network, ts.Listener.Addr().String())
+		},
+	}
+	defer tr.CloseIdleConnections()
+	res, err := (&Client{Transport: tr}).Get("https://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(b) != "example.com" {
+		t.Errorf("got %q", b)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// CONNECT tunnels, for forward proxies.
+
+package http
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+var errTunnelTarget = errors.New("http: CONNECT target must be host:port")
+
+// A TunnelHandler serves the tunnels clients open with CONNECT
+// requests, given a Server's TunnelHandler.
+type TunnelHandler interface {
+	// ServeTunnel serves the tunnel of the CONNECT request r to
+	// target, a "host:port", over c, the client's connection,
+	// after the server has replied 200 OK. The connection is
+	// closed when ServeTunnel returns.
+	//
+	// The client's address, for access control, is r.RemoteAddr,
+	// which for connections read with ReadProxyHeader is the
+	// source address of the PROXY protocol header.
+	ServeTunnel(c net.Conn, target string, r *Request)
+}
+
+// The TunnelHandlerFunc type is an adapter to allow the use of
+// ordinary functions as tunnel handlers.
+type TunnelHandlerFunc func(c net.Conn, target string, r *Request)
+
+// ServeTunnel calls f(c, target, r).
+func (f TunnelHandlerFunc) ServeTunnel(c net.Conn, target string, r *Request) {
+	f(c, target, r)
+}
+
+// ForwardTunnel, a TunnelHandlerFunc, connects the tunnel to target
+// over TCP and copies between them until both sides are done.
+func ForwardTunnel(c net.Conn, target string, r *Request) {
+	dst, err := net.Dial("tcp", target)
+	if err != nil {
+		return
+	}
+	defer dst.Close()
+	copyTunnel(c, dst)
+}
+
+// copyTunnel copies between the client's connection c of a tunnel and
+// its destination dst until both are done.
+func copyTunnel(c, dst net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		io.Copy(dst, c)
+		closeWrite(dst)
+	}()
+	io.Copy(c, dst)
+	closeWrite(c)
+	wg.Wait()
+}
+
+// closeWrite shuts down the writing side of c, if it can, so that the
+// other side of a tunnel sees EOF while replies may still be read.
+func closeWrite(c net.Conn) {
+	if cw, ok := c.(interface {
+		CloseWrite() error
+	}); ok {
+		cw.CloseWrite()
+	}
+}
+
+// isTunnel reports whether req opens a tunnel to a "host:port"
+// served by the Server's TunnelHandler. CONNECT requests with a path,
+// as net/rpc sends, go to the Handler.
+func (c *conn) isTunnel(req *Request) bool {
+	return req.Method == "CONNECT" && c.server.TunnelHandler != nil &&
+		!strings.HasPrefix(req.RequestURI, "/")
+}
+
+// serveTunnel serves the tunnel of the CONNECT request of w.
+func (c *conn) serveTunnel(w *response) {
+	req := w.req
+	target := req.URL.Host
+	if _, port, err := net.SplitHostPort(target); err != nil || port == "" {
+		w.closeAfterReply = true
+		respondError(w, req, StatusBadRequest, "CONNECT target must be host:port", errTunnelTarget)
+		return
+	}
+	if allow := c.server.AllowTunnel; allow != nil {
+		if err := allow(target, req); err != nil {
+			w.closeAfterReply = true
+			respondError(w, req, StatusForbidden, err.Error(), err)
+			return
+		}
+	}
+	rwc, buf, err := c.hijack()
+	if err != nil {
+		return
+	}
+	defer rwc.Close()
+	buf.WriteString(statusLine(req, StatusOK) + "\r\n")
+	if err := buf.Flush(); err != nil {
+		return
+	}
+	c.server.TunnelHandler.ServeTunnel(&tunnelConn{rwc, buf.Reader}, target, req)
+}
+
+// tunnelConn is the client's connection of a tunnel, read from
+// through the server's buffer, which may hold bytes the client sent
+// right after its CONNECT request.
+type tunnelConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *tunnelConn) Read(p []byte) (int, error) {
+	return c.br.Read(p)
+}
+
+// UnderlyingConn returns the client's connection.
+func (c *tunnelConn) UnderlyingConn() net.Conn {
+	return c.Conn
+}
+
+// CloseWrite shuts down the writing side of the client's connection,
+// if it can.
+func (c *tunnelConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface {
+		CloseWrite() error
+	}); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServerTunnel(t *testing.T) {
+	defer afterTest(t)
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	target := echo.Addr().String()
+
+	ts := httptest.NewUnstartedServer(NotFoundHandler())
+	ts.Config.TunnelHandler = TunnelHandlerFunc(ForwardTunnel)
+	ts.Config.AllowTunnel = func(target string, r *Request) error {
+		if host, _, _ := net.SplitHostPort(r.RemoteAddr); host == "203.0.113.66" {
+			return errors.New("client banned")
+		}
+		return nil
+	}
+	ts.StartProxy()
+	defer ts.Close()
+
+	connect := func(src string, uri string) (net.Conn, *bufio.Reader, string) {
+		tr := ts.ProxyClient(&net.TCPAddr{IP: net.ParseIP(src), Port: 1234}).Transport.(*Transport)
+		c, err := tr.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		// The first bytes through the tunnel follow the request
+		// at once.
+		fmt.Fprintf(c, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\nearly", uri, target)
+		br := bufio.NewReader(c)
+		status, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading CONNECT response: %v", err)
+		}
+		return c, br, status
+	}
+
+	c, br, status := connect("203.0.113.7", target)
+	defer c.Close()
+	if status != "HTTP/1.1 200 OK\r\n" {
+		t.Fatalf("CONNECT status %q; want 200 OK", status)
+	}
+	if line, _ := br.ReadString('\n'); line != "\r\n" {
+		t.Fatalf("CONNECT response has header %q", line)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(br, buf); err != nil || string(buf) != "early" {
+		t.Fatalf("echo %q, %v; want early", buf, err)
+	}
+	io.WriteString(c, "hello")
+	if _, err := io.ReadFull(br, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("echo %q, %v; want hello", buf, err)
+	}
+
+	c2, _, status := connect("203.0.113.66", target)
+	defer c2.Close()
+	if status != "HTTP/1.1 403 Forbidden\r\n" {
+		t.Errorf("banned client got status %q; want 403 Forbidden", status)
+	}
+
+	c3, _, status := connect("203.0.113.7", "/_goRPC_")
+	defer c3.Close()
+	if status != "HTTP/1.1 404 Not Found\r\n" {
+		t.Errorf("CONNECT to a path got status %q; want the Handler's 404", status)
+	}
+
+	c4, _, status := connect("203.0.113.7", "no-port.example")
+	defer c4.Close()
+	if status != "HTTP/1.1 400 Bad Request\r\n" {
+		t.Errorf("CONNECT without a port got status %q; want 400 Bad Request", status)
+	}
+}
+
+func TestServerTunnelErrorResponder(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(NotFoundHandler())
+	ts.Config.TunnelHandler = TunnelHandlerFunc(ForwardTunnel)
+	ts.Config.AllowTunnel = func(target string, r *Request) error {
+		return errors.New("destination denied")
+	}
+	ts.Config.ErrorResponder = func(w ResponseWriter, r *Request, code int, err error) {
+		w.WriteHeader(code)
+		fmt.Fprintf(w, "%d %v", code, err)
+	}
+	ts.Start()
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		target string
+		want   string
+	}{
+		{"denied.example:443", "403 destination denied"},
+		{"no-port.example", "400 http: CONNECT target must be host:port"},
+	} {
+		c, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		fmt.Fprintf(c, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", tt.target, tt.target)
+		res, err := ReadResponse(bufio.NewReader(c), nil)
+		if err != nil {
+			t.Fatalf("CONNECT %s: %v", tt.target, err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		c.Close()
+		if string(body) != tt.want {
+			t.Errorf("CONNECT %s: body %q; want %q", tt.target, body, tt.want)
+		}
+	}
+}
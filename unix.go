@@ -0,0 +1,63 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Unix domain socket listeners.
+
+package http
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// ListenUnix listens on the Unix domain socket at path. A socket file
+// left behind by a previous process is removed first, unless a server
+// is still accepting connections on it. If perm is non-zero, the socket
+// file's permissions are set to perm, limiting which local users can
+// connect. Closing the returned listener removes the socket file.
+func ListenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if l := claimInherited("unix " + path); l != nil {
+		return l, nil
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New("http: " + path + " exists and is not a socket")
+		}
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, errors.New("http: socket " + path + " is in use")
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// ListenAndServeUnix listens on the Unix domain socket at path, as
+// ListenUnix does, and then calls Serve to handle requests on incoming
+// connections. The socket file is removed when serving stops.
+//
+// To accept the PROXY protocol header sent by a local proxy, serve the
+// listener from ListenUnix with ServeWithOptions and ReadProxyHeader
+// as the WrapConn option.
+func (srv *Server) ListenAndServeUnix(path string, perm os.FileMode) error {
+	l, err := srv.listenUnix(path, perm)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	return srv.Serve(l)
+}
@@ -0,0 +1,150 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func getUnix(path, url string) (string, error) {
+	tr := &Transport{Dial: func(string, string) (net.Conn, error) { return net.Dial("unix", path) }}
+	defer tr.CloseIdleConnections()
+	res, err := (&Client{Transport: tr}).Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	return string(b), err
+}
+
+func TestListenAndServeUnix(t *testing.T) {
+	defer afterTest(t)
+	dir, err := ioutil.TempDir("", "unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "http.sock")
+
+	// A socket left behind by a dead server.
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "hello")
+	})}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServeUnix(sock, 0600) }()
+	for i := 0; ; i++ {
+		got, err := getUnix(sock, "http://localhost/")
+		if err == nil {
+			if got != "hello" {
+				t.Errorf("got %q; want hello", got)
+			}
+			break
+		}
+		if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("socket permissions = %v; want 0600", perm)
+	}
+
+	if _, err := ListenUnix(sock, 0); err == nil {
+		t.Error("ListenUnix on a socket in use succeeded")
+	}
+
+	srv.Close()
+	select {
+	case err := <-errc:
+		if err != ErrServerClosed {
+			t.Errorf("ListenAndServeUnix = %v; want ErrServerClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListenAndServeUnix did not return after Close")
+	}
+	if _, err := os.Lstat(sock); !os.IsNotExist(err) {
+		t.Errorf("socket file not removed: %v", err)
+	}
+}
+
+func TestListenUnixNotSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+	writeFile(t, path, []byte("data"))
+	if _, err := ListenUnix(path, 0); err == nil {
+		t.Fatal("ListenUnix over a regular file succeeded")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("regular file removed: %v", err)
+	}
+}
+
+func TestUnixProxyHeader(t *testing.T) {
+	defer afterTest(t)
+	dir, err := ioutil.TempDir("", "unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "http.sock")
+	l, err := ListenUnix(sock, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go new(Server).ServeWithOptions(l, ListenerOptions{
+		WrapConn: ReadProxyHeader,
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			io.WriteString(w, r.RemoteAddr)
+		}),
+	})
+
+	for _, tt := range []struct {
+		header, want string
+	}{
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", "192.0.2.1:56324"},
+		{"PROXY UNKNOWN\r\n", "@"},
+	} {
+		c, err := net.Dial("unix", sock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(c, "%sGET / HTTP/1.0\r\n\r\n", tt.header)
+		res, err := ReadResponse(bufio.NewReader(c), nil)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.header, err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		c.Close()
+		if got := string(b); got != tt.want {
+			t.Errorf("%q: RemoteAddr = %q; want %q", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Zero-downtime binary upgrades.
+
+package http
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The environment variables by which Upgrade tells the new process
+// which listeners it inherits.
+const (
+	upgradeEnvPID       = "HTTP_UPGRADE_PID"       // the parent's pid
+	upgradeEnvListeners = "HTTP_UPGRADE_LISTENERS" // escaped listener keys, comma-separated
+)
+
+// upgradeCommand returns the program and arguments Upgrade starts. It
+// is replaced by tests.
+var upgradeCommand = func() (path string, argv []string, err error) {
+	path, err = os.Executable()
+	return path, os.Args, err
+}
+
+// inherited holds the listeners handed over by the process that
+// started this one with Server.Upgrade, until they are claimed.
+var inherited struct {
+	once  sync.Once
+	mu    sync.Mutex
+	lns   map[string]net.Listener // by key, see Server.listen
+	ready *os.File                // written to and closed when all are claimed
+}
+
+func loadInherited() {
+	pid, keys := os.Getenv(upgradeEnvPID), os.Getenv(upgradeEnvListeners)
+	os.Unsetenv(upgradeEnvPID)
+	os.Unsetenv(upgradeEnvListeners)
+	if pid == "" || pid != strconv.Itoa(os.Getppid()) {
+		return
+	}
+	names := strings.Split(keys, ",")
+	inherited.lns = make(map[string]net.Listener)
+	for i, name := range names {
+		key, _ := url.QueryUnescape(name)
+		f := os.NewFile(uintptr(listenFdsStart+i), key)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			log.Printf("http: inherited listener %s: %v", key, err)
+			continue
+		}
+		inherited.lns[key] = l
+	}
+	inherited.ready = os.NewFile(uintptr(listenFdsStart+len(names)), "upgrade-ready")
+}
+
+// claimInherited returns the inherited listener with the given key, or
+// nil if there is none. Once all inherited listeners are claimed, the
+// parent process is told to stop serving.
+func claimInherited(key string) net.Listener {
+	inherited.once.Do(loadInherited)
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+	l := inherited.lns[key]
+	if l == nil {
+		return nil
+	}
+	delete(inherited.lns, key)
+	if len(inherited.lns) == 0 && inherited.ready != nil {
+		inherited.ready.Write([]byte{1})
+		inherited.ready.Close()
+		inherited.ready = nil
+	}
+	return l
+}
+
+// listen listens on the network address addr, taking over the
+// listener for it inherited from an upgraded process, if any. The
+// listener is recorded so that Upgrade can hand it over in turn.
+func (srv *Server) listen(network, addr string, reusePort bool) (net.Listener, error) {
+	key := network + " " + addr
+	l := claimInherited(key)
+	if l == nil {
+		var err error
+		if reusePort {
+			l, err = ListenReusePort(network, addr)
+		} else {
+			l, err = net.Listen(network, addr)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return srv.upgradableListener(key, l), nil
+}
+
+// listenUnix is like ListenUnix but records the listener for Upgrade.
+func (srv *Server) listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	l, err := ListenUnix(path, perm)
+	if err != nil {
+		return nil, err
+	}
+	return srv.upgradableListener("unix "+path, l), nil
+}
+
+func (srv *Server) upgradableListener(key string, l net.Listener) net.Listener {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.upgradable == nil {
+		srv.upgradable = make(map[string]net.Listener)
+	}
+	srv.upgradable[key] = l
+	return &upgradableListener{l, srv, key}
+}
+
+// upgradableListener is a listener that Upgrade can hand over until
+// it is closed.
+type upgradableListener struct {
+	net.Listener
+	srv *Server
+	key string
+}
+
+func (l *upgradableListener) Close() error {
+	l.srv.mu.Lock()
+	if l.srv.upgradable[l.key] == l.Listener {
+		delete(l.srv.upgradable, l.key)
+	}
+	l.srv.mu.Unlock()
+	return l.Listener.Close()
+}
+
+// Upgrade replaces the running process with a new one without
+// refusing any connections, typically after the program's binary has
+// been replaced and in response to a signal such as SIGHUP.
+//
+// Upgrade starts the program's executable again with the same
+// arguments and environment, handing it the listening sockets opened
+// by srv's ListenAndServe methods and ServeSystemd. When the new
+// process calls the same methods for the same addresses it takes over
+// the sockets instead of binding new ones. Once it has taken over all
+// of them, Upgrade closes srv, as Close does, and waits up to timeout
+// for srv's connections to finish: keep-alive connections idle between
+// requests are closed, including those whose PROXY protocol header was
+// already read, and the others are closed after their current
+// response. Connections still open after timeout are closed.
+//
+// The serving methods return ErrServerClosed as soon as the sockets
+// are handed over; the program should wait for Upgrade to return
+// before exiting. If the new process fails to take over all of the
+// sockets within timeout, it is killed, srv keeps serving and Upgrade
+// returns an error.
+func (srv *Server) Upgrade(timeout time.Duration) error {
+	srv.mu.Lock()
+	keys := make([]string, 0, len(srv.upgradable))
+	for key := range srv.upgradable {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		fl, ok := srv.upgradable[key].(interface {
+			File() (*os.File, error)
+		})
+		if !ok {
+			srv.mu.Unlock()
+			return errors.New("http: listener " + key + " cannot be handed over")
+		}
+		f, err := fl.File()
+		if err != nil {
+			srv.mu.Unlock()
+			return err
+		}
+		files = append(files, f)
+		names[i] = url.QueryEscape(key)
+	}
+	srv.mu.Unlock()
+	if len(files) == 0 {
+		return errors.New("http: Upgrade with no listeners to hand over")
+	}
+
+	path, argv, err := upgradeCommand()
+	if err != nil {
+		return err
+	}
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, upgradeEnvPID+"=") && !strings.HasPrefix(kv, upgradeEnvListeners+"=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env,
+		upgradeEnvPID+"="+strconv.Itoa(os.Getpid()),
+		upgradeEnvListeners+"="+strings.Join(names, ","))
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	attr := &os.ProcAttr{
+		Env:   env,
+		Files: append(append([]*os.File{os.Stdin, os.Stdout, os.Stderr}, files...), w),
+	}
+	p, err := os.StartProcess(path, argv, attr)
+	w.Close()
+	srv.mu.Lock()
+	for _, l := range srv.upgradable {
+		setNonblock(l)
+	}
+	srv.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	r.SetReadDeadline(time.Now().Add(timeout))
+	var b [1]byte
+	if n, _ := r.Read(b[:]); n != 1 {
+		p.Kill()
+		p.Wait()
+		return errors.New("http: upgraded process did not take over the listeners")
+	}
+	p.Release()
+
+	// The new process serves Unix sockets from the same paths.
+	srv.mu.Lock()
+	for _, l := range srv.upgradable {
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	srv.mu.Unlock()
+	srv.Close()
+	srv.drain(timeout)
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestServerUpgrade(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	release := make(chan bool)
+	started := make(chan bool, 1)
+	mux := NewServeMux()
+	mux.HandleFunc("/", func(w ResponseWriter, r *Request) { io.WriteString(w, "old") })
+	mux.HandleFunc("/slow", func(w ResponseWriter, r *Request) {
+		started <- true
+		<-release
+		io.WriteString(w, "old")
+	})
+	srv := &Server{Addr: addr, Handler: mux}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+
+	get := func(c *Client, path string) (string, *Response) {
+		res, err := c.Get("http://" + addr + path)
+		if err != nil {
+			return "", nil
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		return string(b), res
+	}
+	keepAlive := &Client{Transport: &Transport{}}
+	fresh := &Client{Transport: &Transport{DisableKeepAlives: true}}
+	for i := 0; ; i++ {
+		if got, _ := get(keepAlive, "/"); got == "old" {
+			break
+		}
+		if i == 100 {
+			t.Fatal("old server not serving")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	slow := make(chan *Response, 1)
+	go func() {
+		_, res := get(fresh, "/slow")
+		slow <- res
+	}()
+	<-started
+
+	defer SetUpgradeCommandForTesting("-test.run=^TestServerUpgradeChild$")()
+	os.Setenv("GO_WANT_HELPER_PROCESS", "1")
+	os.Setenv("HTTP_UPGRADE_TEST_ADDR", addr)
+	defer os.Unsetenv("GO_WANT_HELPER_PROCESS")
+	defer os.Unsetenv("HTTP_UPGRADE_TEST_ADDR")
+	upgraded := make(chan error, 1)
+	go func() { upgraded <- srv.Upgrade(5 * time.Second) }()
+	defer get(fresh, "/quit")
+
+	// The old server stops accepting; new connections reach the
+	// new process, and none are refused along the way.
+	for i := 0; ; i++ {
+		got, res := get(fresh, "/")
+		if res == nil {
+			t.Fatal("connection refused during upgrade")
+		}
+		if got == "new" {
+			break
+		}
+		if i == 500 {
+			t.Fatal("new process not serving")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := <-errc; err != ErrServerClosed {
+		t.Errorf("ListenAndServe = %v; want ErrServerClosed", err)
+	}
+
+	// The in-flight request completes on the old server.
+	close(release)
+	res := <-slow
+	if res == nil {
+		t.Fatal("in-flight request failed")
+	}
+	if !res.Close {
+		t.Error("in-flight response did not close the connection")
+	}
+	if err := <-upgraded; err != nil {
+		t.Errorf("Upgrade: %v", err)
+	}
+
+	// The idle keep-alive connection was closed, so the next request
+	// goes to the new process.
+	if got, _ := get(keepAlive, "/"); got != "new" {
+		t.Errorf("keep-alive client got %q; want new", got)
+	}
+}
+
+// TestServerUpgradeChild isn't a real test. It's used as a helper
+// process for TestServerUpgrade, standing in for the upgraded binary.
+func TestServerUpgradeChild(*testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	defer os.Exit(0)
+	mux := NewServeMux()
+	mux.HandleFunc("/", func(w ResponseWriter, r *Request) { io.WriteString(w, "new") })
+	mux.HandleFunc("/quit", func(ResponseWriter, *Request) { os.Exit(0) })
+	srv := &Server{Addr: os.Getenv("HTTP_UPGRADE_TEST_ADDR"), Handler: mux}
+	if err := srv.ListenAndServe(); err != nil {
+		panic(err)
+	}
+}
+
+func TestServerUpgradeNoListeners(t *testing.T) {
+	if err := new(Server).Upgrade(time.Second); err == nil {
+		t.Error("Upgrade with no listeners succeeded")
+	}
+}
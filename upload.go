@@ -0,0 +1,486 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Resumable uploads, as the tus protocol, version 1.0.0, describes.
+
+package http
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Errors of UploadStores.
+var (
+	ErrUploadNotFound = errors.New("http: upload not found")
+	ErrUploadOffset   = errors.New("http: upload offset mismatch")
+)
+
+var errUploadTooLarge = errors.New("http: body past the upload's length")
+
+// tusVersion is the version of the tus protocol an UploadHandler
+// speaks.
+const tusVersion = "1.0.0"
+
+// An UploadInfo describes a resumable upload.
+type UploadInfo struct {
+	ID string
+
+	// Length is the size of the file uploaded, and Offset how much
+	// of it was received so far.
+	Length int64
+	Offset int64
+
+	// Metadata is what the client said about the file, such as its
+	// name or type, in the Upload-Metadata header creating it.
+	Metadata map[string]string
+}
+
+// Complete reports whether the whole file was received.
+func (u *UploadInfo) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// An UploadStore keeps the files of the uploads of an UploadHandler.
+// Implementations must be safe for concurrent use by multiple
+// goroutines.
+type UploadStore interface {
+	// Create starts an upload of a file of length bytes with
+	// metadata and returns its ID.
+	Create(length int64, metadata map[string]string) (id string, err error)
+
+	// Info returns the state of the upload id, or ErrUploadNotFound.
+	Info(id string) (*UploadInfo, error)
+
+	// Append adds the bytes of r to the file of the upload id, which
+	// must have received offset bytes so far, or ErrUploadOffset is
+	// returned. It returns the number of bytes kept, which count
+	// towards the upload's offset even if r fails part way, so that
+	// the client may resume from there.
+	Append(id string, offset int64, r io.Reader) (n int64, err error)
+
+	// Delete deletes the upload id and its file.
+	Delete(id string) error
+}
+
+// An UploadHandler receives files with resumable uploads, for files
+// large enough, or connections unreliable enough, that sending them in
+// one request may fail, such as through load balancers closing idle
+// connections after a short time. It speaks the core tus protocol,
+// version 1.0.0, with its creation and termination extensions:
+//
+//   - POST to Path, with an Upload-Length header giving the size of
+//     the file, creates an upload, answering 201 Created with its URL
+//     in the Location header, Path followed by its ID.
+//   - HEAD on that URL reports the bytes received so far in an
+//     Upload-Offset header.
+//   - PATCH to it, with that Upload-Offset and a body of type
+//     "application/offset+octet-stream", appends the body to the file.
+//     The bytes received count even if the request fails part way.
+//     Bodies longer than the rest of the file are refused with 413
+//     Request Entity Too Large, without completing it; those of
+//     unknown length only once read past it, so that the bytes read
+//     before count, as of a request failing part way.
+//   - DELETE on it drops the upload.
+//
+// Requests other than OPTIONS must have a "Tus-Resumable: 1.0.0"
+// header. Requests for paths not under Path are answered with 404 Not
+// Found.
+type UploadHandler struct {
+	// Store keeps the files uploaded.
+	Store UploadStore
+
+	// Path is the path of the uploads, ending in a slash, such as
+	// "/files/".
+	Path string
+
+	// MaxSize, if positive, is the size of the largest file that can
+	// be uploaded.
+	MaxSize int64
+
+	// OnComplete, if non-nil, is called with the upload completed by
+	// r, before r is answered.
+	OnComplete func(r *Request, info *UploadInfo)
+
+	mu      sync.Mutex
+	writing map[string]bool // IDs of the uploads PATCHed
+}
+
+func (h *UploadHandler) ServeHTTP(w ResponseWriter, r *Request) {
+	hdr := w.Header()
+	hdr.Set("Tus-Resumable", tusVersion)
+	if !strings.HasPrefix(r.URL.Path, h.Path) {
+		NotFound(w, r)
+		return
+	}
+	if r.Method == "OPTIONS" {
+		hdr.Set("Tus-Version", tusVersion)
+		hdr.Set("Tus-Extension", "creation,termination")
+		if h.MaxSize > 0 {
+			hdr.Set("Tus-Max-Size", strconv.FormatInt(h.MaxSize, 10))
+		}
+		w.WriteHeader(StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		hdr.Set("Tus-Version", tusVersion)
+		Error(w, "Precondition Failed: unsupported tus version", StatusPreconditionFailed)
+		return
+	}
+	id := r.URL.Path[len(h.Path):]
+	switch {
+	case id == "" && r.Method == "POST":
+		h.create(w, r)
+	case id == "" || strings.Contains(id, "/"):
+		NotFound(w, r)
+	case r.Method == "HEAD":
+		h.head(w, r, id)
+	case r.Method == "PATCH":
+		h.patch(w, r, id)
+	case r.Method == "DELETE":
+		if err := h.Store.Delete(id); err != nil {
+			h.storeError(w, r, err)
+			return
+		}
+		w.WriteHeader(StatusNoContent)
+	default:
+		hdr.Set("Allow", "HEAD, PATCH, DELETE")
+		Error(w, "Method Not Allowed", StatusMethodNotAllowed)
+	}
+}
+
+func (h *UploadHandler) create(w ResponseWriter, r *Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		Error(w, "Bad Request: invalid Upload-Length", StatusBadRequest)
+		return
+	}
+	if h.MaxSize > 0 && length > h.MaxSize {
+		Error(w, "Request Entity Too Large", StatusRequestEntityTooLarge)
+		return
+	}
+	meta, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		Error(w, "Bad Request: invalid Upload-Metadata", StatusBadRequest)
+		return
+	}
+	id, err := h.Store.Create(length, meta)
+	if err != nil {
+		h.storeError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", h.Path+id)
+	w.Header().Set("Upload-Offset", "0")
+	if length == 0 {
+		h.complete(r, &UploadInfo{ID: id, Metadata: meta})
+	}
+	w.WriteHeader(StatusCreated)
+}
+
+func (h *UploadHandler) head(w ResponseWriter, r *Request, id string) {
+	info, err := h.Store.Info(id)
+	if err != nil {
+		h.storeError(w, r, err)
+		return
+	}
+	hdr := w.Header()
+	hdr.Set("Cache-Control", "no-store")
+	hdr.Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	hdr.Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	if len(info.Metadata) > 0 {
+		hdr.Set("Upload-Metadata", formatUploadMetadata(info.Metadata))
+	}
+	w.WriteHeader(StatusOK)
+}
+
+func (h *UploadHandler) patch(w ResponseWriter, r *Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		Error(w, "Unsupported Media Type", StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		Error(w, "Bad Request: invalid Upload-Offset", StatusBadRequest)
+		return
+	}
+	if !h.lock(id) {
+		Error(w, "Conflict: upload in progress", StatusConflict)
+		return
+	}
+	defer h.unlock(id)
+	info, err := h.Store.Info(id)
+	if err != nil {
+		h.storeError(w, r, err)
+		return
+	}
+	if offset != info.Offset {
+		h.storeError(w, r, ErrUploadOffset)
+		return
+	}
+	left := info.Length - offset
+	if r.ContentLength > left {
+		h.storeError(w, r, errUploadTooLarge)
+		return
+	}
+	n, err := h.Store.Append(id, offset, &uploadBodyReader{r: r.Body, n: left})
+	info.Offset += n
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	if err != nil {
+		h.storeError(w, r, err)
+		return
+	}
+	if info.Complete() {
+		h.complete(r, info)
+	}
+	w.WriteHeader(StatusNoContent)
+}
+
+func (h *UploadHandler) complete(r *Request, info *UploadInfo) {
+	if h.OnComplete != nil {
+		h.OnComplete(r, info)
+	}
+}
+
+// lock reserves the upload id for a PATCH request, reporting whether
+// no other one was in progress.
+func (h *UploadHandler) lock(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.writing[id] {
+		return false
+	}
+	if h.writing == nil {
+		h.writing = make(map[string]bool)
+	}
+	h.writing[id] = true
+	return true
+}
+
+func (h *UploadHandler) unlock(id string) {
+	h.mu.Lock()
+	delete(h.writing, id)
+	h.mu.Unlock()
+}
+
+func (h *UploadHandler) storeError(w ResponseWriter, r *Request, err error) {
+	switch err {
+	case ErrUploadNotFound:
+		NotFound(w, r)
+	case ErrUploadOffset:
+		Error(w, "Conflict: "+strings.TrimPrefix(err.Error(), "http: "), StatusConflict)
+	case errUploadTooLarge:
+		Error(w, "Request Entity Too Large: "+strings.TrimPrefix(err.Error(), "http: "), StatusRequestEntityTooLarge)
+	default:
+		respondError(w, r, StatusInternalServerError, "Internal Server Error", err)
+	}
+}
+
+// An uploadBodyReader reads the body of a PATCH request, up to the n
+// bytes left of its upload. A body longer than that fails with
+// errUploadTooLarge, without the bytes of the read finding it is, so
+// that it doesn't complete the upload.
+type uploadBodyReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *uploadBodyReader) Read(p []byte) (int, error) {
+	// Read one byte past the limit to tell whether there is more.
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.n {
+		return 0, errUploadTooLarge
+	}
+	if int64(n) == l.n && err == nil {
+		// The read reaching the end of the upload must reach
+		// that of the body too.
+		var b [1]byte
+		m := 0
+		for m == 0 && err == nil {
+			m, err = l.r.Read(b[:])
+		}
+		if m > 0 {
+			return 0, errUploadTooLarge
+		}
+	}
+	l.n -= int64(n)
+	return n, err
+}
+
+// parseUploadMetadata parses an Upload-Metadata header, a
+// comma-separated list of keys each followed by a space and its value
+// in base64, if it has one.
+func parseUploadMetadata(s string) (map[string]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		f := strings.Fields(pair)
+		if len(f) == 0 || len(f) > 2 {
+			return nil, errors.New("http: invalid Upload-Metadata")
+		}
+		var v []byte
+		if len(f) == 2 {
+			var err error
+			if v, err = base64.StdEncoding.DecodeString(f[1]); err != nil {
+				return nil, err
+			}
+		}
+		meta[f[0]] = string(v)
+	}
+	return meta, nil
+}
+
+func formatUploadMetadata(meta map[string]string) string {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		if v := meta[k]; v != "" {
+			keys[i] += " " + base64.StdEncoding.EncodeToString([]byte(v))
+		}
+	}
+	return strings.Join(keys, ",")
+}
+
+// DirUploadStore implements UploadStore using a directory on the local
+// file system, keeping each file under its ID, with its UploadInfo as
+// JSON beside it in a file with the suffix ".info". The directory is
+// created if it doesn't exist.
+type DirUploadStore string
+
+// validUploadID reports whether id could be one of a DirUploadStore,
+// so that clients can't name other files.
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// Name returns the name of the file of the upload id.
+func (d DirUploadStore) Name(id string) string {
+	return filepath.Join(string(d), id)
+}
+
+// Create implements the Create method of the UploadStore interface.
+func (d DirUploadStore) Create(length int64, metadata map[string]string) (string, error) {
+	if err := os.MkdirAll(string(d), 0700); err != nil {
+		return "", err
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b[:])
+	name := filepath.Join(string(d), id)
+	info, err := json.Marshal(&UploadInfo{ID: id, Length: length, Metadata: metadata})
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(name+".info", info, 0600); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(name, nil, 0600); err != nil {
+		os.Remove(name + ".info")
+		return "", err
+	}
+	return id, nil
+}
+
+// Info implements the Info method of the UploadStore interface.
+func (d DirUploadStore) Info(id string) (*UploadInfo, error) {
+	if !validUploadID(id) {
+		return nil, ErrUploadNotFound
+	}
+	name := filepath.Join(string(d), id)
+	b, err := ioutil.ReadFile(name + ".info")
+	if os.IsNotExist(err) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info := new(UploadInfo)
+	if err := json.Unmarshal(b, info); err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.Offset = fi.Size()
+	return info, nil
+}
+
+// Append implements the Append method of the UploadStore interface.
+func (d DirUploadStore) Append(id string, offset int64, r io.Reader) (int64, error) {
+	if !validUploadID(id) {
+		return 0, ErrUploadNotFound
+	}
+	f, err := os.OpenFile(filepath.Join(string(d), id), os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		return 0, ErrUploadNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	if fi.Size() != offset {
+		f.Close()
+		return 0, ErrUploadOffset
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// Delete implements the Delete method of the UploadStore interface.
+func (d DirUploadStore) Delete(id string) error {
+	if !validUploadID(id) {
+		return ErrUploadNotFound
+	}
+	name := filepath.Join(string(d), id)
+	err := os.Remove(name + ".info")
+	if os.IsNotExist(err) {
+		return ErrUploadNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	. "net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// failingReader returns its data, then an error, like the body of a
+// request whose connection was cut.
+type failingReader struct {
+	data string
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestUploadHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := DirUploadStore(dir)
+	var completed *UploadInfo
+	h := &UploadHandler{
+		Store:   store,
+		Path:    "/files/",
+		MaxSize: 100,
+		OnComplete: func(r *Request, info *UploadInfo) {
+			completed = info
+		},
+	}
+	serve := func(method, path string, body io.Reader, hdrs ...string) *httptest.ResponseRecorder {
+		req, _ := NewRequest(method, "http://example.com"+path, body)
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		for _, kv := range hdrs {
+			i := strings.Index(kv, ": ")
+			req.Header.Set(kv[:i], kv[i+2:])
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("OPTIONS", "/files/", nil)
+	if rec.Code != 204 || rec.Header().Get("Tus-Extension") != "creation,termination" || rec.Header().Get("Tus-Max-Size") != "100" {
+		t.Errorf("OPTIONS: %d %v", rec.Code, rec.Header())
+	}
+	if rec := serve("POST", "/files/", nil, "Upload-Length: 101"); rec.Code != 413 {
+		t.Errorf("POST over MaxSize: %d; want 413", rec.Code)
+	}
+	if rec := serve("POST", "/files/", nil, "Upload-Length: 10", "Tus-Resumable: 0.2.2"); rec.Code != 412 {
+		t.Errorf("POST of another version: %d; want 412", rec.Code)
+	}
+
+	rec = serve("POST", "/files/", nil, "Upload-Length: 11", "Upload-Metadata: filename aGVsbG8udHh0,draft")
+	loc := rec.Header().Get("Location")
+	if rec.Code != 201 || !strings.HasPrefix(loc, "/files/") {
+		t.Fatalf("POST: %d, Location %q", rec.Code, loc)
+	}
+	const ct = "Content-Type: application/offset+octet-stream"
+
+	// A PATCH cut short keeps what arrived.
+	rec = serve("PATCH", loc, &failingReader{"hello"}, ct, "Upload-Offset: 0")
+	if rec.Code != 500 || rec.Header().Get("Upload-Offset") != "5" {
+		t.Errorf("failed PATCH: %d, Upload-Offset %q; want 500, 5", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+	rec = serve("HEAD", loc, nil)
+	if rec.Code != 200 || rec.Header().Get("Upload-Offset") != "5" || rec.Header().Get("Upload-Length") != "11" ||
+		rec.Header().Get("Upload-Metadata") != "draft,filename aGVsbG8udHh0" || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("HEAD: %d %v", rec.Code, rec.Header())
+	}
+	if rec := serve("PATCH", loc, strings.NewReader(" world"), ct, "Upload-Offset: 0"); rec.Code != 409 {
+		t.Errorf("PATCH at the wrong offset: %d; want 409", rec.Code)
+	}
+	if rec := serve("PATCH", loc, strings.NewReader(" world"), "Content-Type: text/plain", "Upload-Offset: 5"); rec.Code != 415 {
+		t.Errorf("PATCH of the wrong type: %d; want 415", rec.Code)
+	}
+	if completed != nil {
+		t.Fatal("OnComplete called before the upload completed")
+	}
+	// Bodies longer than the rest of the file are refused, whether
+	// their length is known or not.
+	for _, body := range []io.Reader{strings.NewReader(" world!!"), struct{ io.Reader }{strings.NewReader(" world!!")}} {
+		if rec := serve("PATCH", loc, body, ct, "Upload-Offset: 5"); rec.Code != 413 {
+			t.Errorf("PATCH past the length: %d; want 413", rec.Code)
+		}
+		if rec := serve("HEAD", loc, nil); rec.Header().Get("Upload-Offset") != "5" {
+			t.Errorf("Upload-Offset after a PATCH past the length: %q; want 5", rec.Header().Get("Upload-Offset"))
+		}
+	}
+	if completed != nil {
+		t.Fatal("OnComplete called for a body past the length")
+	}
+	rec = serve("PATCH", loc, strings.NewReader(" world"), ct, "Upload-Offset: 5")
+	if rec.Code != 204 || rec.Header().Get("Upload-Offset") != "11" {
+		t.Errorf("PATCH: %d, Upload-Offset %q; want 204, 11", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+	if completed == nil || !completed.Complete() || completed.Metadata["filename"] != "hello.txt" {
+		t.Fatalf("OnComplete got %+v", completed)
+	}
+	if b, err := ioutil.ReadFile(store.Name(completed.ID)); err != nil || string(b) != "hello world" {
+		t.Errorf("file uploaded: %q, %v; want %q", b, err, "hello world")
+	}
+
+	if rec := serve("DELETE", loc, nil); rec.Code != 204 {
+		t.Errorf("DELETE: %d; want 204", rec.Code)
+	}
+	for _, path := range []string{loc, "/files/../secret", "/files/" + strings.Repeat("0", 32)} {
+		if rec := serve("HEAD", path, nil); rec.Code != 404 {
+			t.Errorf("HEAD %s: %d; want 404", path, rec.Code)
+		}
+	}
+
+	// A body of unknown length found too long only by its last read
+	// keeps the bytes before, but doesn't complete the upload.
+	completed = nil
+	loc = serve("POST", "/files/", nil, "Upload-Length: 3").Header().Get("Location")
+	rec = serve("PATCH", loc, iotest.OneByteReader(strings.NewReader("abcd")), ct, "Upload-Offset: 0")
+	if rec.Code != 413 || rec.Header().Get("Upload-Offset") != "2" {
+		t.Errorf("PATCH past the length in small reads: %d, Upload-Offset %q; want 413, 2", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+	if completed != nil {
+		t.Error("OnComplete called for a body past the length in small reads")
+	}
+}
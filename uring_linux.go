@@ -0,0 +1,560 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux && uring
+// +build linux,uring
+
+// An experimental backend doing the reads and writes of accepted TCP
+// connections through an io_uring, so that those of many connections
+// reach the kernel in one system call. It is built with the "uring"
+// build tag and used by Servers with IOUring set.
+//
+// One ring is shared by the process. A submitting goroutine queues
+// the reads and writes of all connections in it, a batch at a time,
+// and a completing goroutine waits for their results. Whatever the
+// ring can't do, such as an operation it must cancel because a
+// connection's deadline changed, is done again by the connection
+// itself, through the standard network poller.
+
+package http
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	sysIOUringSetup = 425
+	sysIOUringEnter = 426
+
+	ringEntries   = 1024 // submission queue entries
+	ringCQEntries = 8 * ringEntries
+
+	ioringSetupCQSize = 1 << 3
+
+	ioringFeatSingleMmap = 1 << 0
+	ioringFeatNoDrop     = 1 << 1
+	ioringFeatFastPoll   = 1 << 5
+	ringFeatures         = ioringFeatSingleMmap | ioringFeatNoDrop | ioringFeatFastPoll
+
+	ioringEnterGetEvents = 1 << 0
+
+	ioringOffSQRing = 0
+	ioringOffSQEs   = 0x10000000
+
+	ioringOpAsyncCancel = 14
+	ioringOpLinkTimeout = 15
+	ioringOpSend        = 26
+	ioringOpRecv        = 27
+
+	iosqeIOLink = 1 << 2
+
+	msgNoSignal = 0x4000
+)
+
+// ringParams is struct io_uring_params.
+type ringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFD         uint32
+	resv         [3]uint32
+	sqOff        sqRingOffsets
+	cqOff        cqRingOffsets
+}
+
+// sqRingOffsets is struct io_sqring_offsets.
+type sqRingOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// cqRingOffsets is struct io_cqring_offsets.
+type cqRingOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// ringSQE is struct io_uring_sqe, a submission queue entry.
+type ringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFDIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+// ringCQE is struct io_uring_cqe, a completion queue entry.
+type ringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// kernelTimespec is struct __kernel_timespec.
+type kernelTimespec struct {
+	sec, nsec int64
+}
+
+// A ring is an io_uring.
+type ring struct {
+	fd int
+
+	sqHead, sqTail *uint32
+	sqMask         uint32
+	sqArray        []uint32
+	sqes           []ringSQE
+
+	cqHead, cqTail *uint32
+	cqMask         uint32
+	cqes           []ringCQE
+
+	wake chan bool // signals the submitting goroutine
+
+	mu     sync.Mutex
+	queue  []*ringOp          // to submit
+	ops    map[uint64]*ringOp // awaiting completion, by ID
+	nextID uint64
+	dead   bool // io_uring_enter failed; no more ops are started
+}
+
+// A ringOp is an operation on a ring: its submission queue entries,
+// and the memory the kernel uses until it completes.
+type ringOp struct {
+	id   uint64 // 0 if nothing waits for the result
+	sqes [2]ringSQE
+	n    int // of sqes used
+	buf  []byte
+	ts   kernelTimespec
+	done chan int32
+}
+
+var sharedRing struct {
+	once sync.Once
+	r    *ring
+}
+
+// theRing returns the process's ring, or nil if io_uring isn't
+// available.
+func theRing() *ring {
+	sharedRing.once.Do(func() {
+		r, err := newRing()
+		if err != nil {
+			return
+		}
+		sharedRing.r = r
+		go r.submitLoop()
+		go r.completeLoop()
+	})
+	return sharedRing.r
+}
+
+func ringAvailable() bool {
+	r := theRing()
+	return r != nil && !r.isDead()
+}
+
+func (r *ring) isDead() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.dead
+}
+
+func newRing() (*ring, error) {
+	p := ringParams{flags: ioringSetupCQSize, cqEntries: ringCQEntries}
+	fd, _, errno := syscall.Syscall(sysIOUringSetup, ringEntries, uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("io_uring_setup", errno)
+	}
+	r := &ring{fd: int(fd), wake: make(chan bool, 1), ops: make(map[uint64]*ringOp)}
+	if p.features&ringFeatures != ringFeatures {
+		syscall.Close(r.fd)
+		return nil, os.NewSyscallError("io_uring_setup", syscall.ENOSYS)
+	}
+	size := p.sqOff.array + p.sqEntries*4
+	if cqSize := p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(ringCQE{})); cqSize > size {
+		size = cqSize
+	}
+	mem, err := syscall.Mmap(r.fd, ioringOffSQRing, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		syscall.Close(r.fd)
+		return nil, os.NewSyscallError("mmap", err)
+	}
+	sqeMem, err := syscall.Mmap(r.fd, ioringOffSQEs, int(p.sqEntries)*int(unsafe.Sizeof(ringSQE{})), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		syscall.Munmap(mem)
+		syscall.Close(r.fd)
+		return nil, os.NewSyscallError("mmap", err)
+	}
+	u32 := func(off uint32) *uint32 { return (*uint32)(unsafe.Pointer(&mem[off])) }
+	r.sqHead, r.sqTail, r.sqMask = u32(p.sqOff.head), u32(p.sqOff.tail), *u32(p.sqOff.ringMask)
+	r.sqArray = unsafe.Slice(u32(p.sqOff.array), p.sqEntries)
+	r.sqes = unsafe.Slice((*ringSQE)(unsafe.Pointer(&sqeMem[0])), p.sqEntries)
+	r.cqHead, r.cqTail, r.cqMask = u32(p.cqOff.head), u32(p.cqOff.tail), *u32(p.cqOff.ringMask)
+	r.cqes = unsafe.Slice((*ringCQE)(unsafe.Pointer(&mem[p.cqOff.cqes])), p.cqEntries)
+	return r, nil
+}
+
+func (r *ring) enter(toSubmit, minComplete, flags uint32) (int, syscall.Errno) {
+	n, _, errno := syscall.Syscall6(sysIOUringEnter, uintptr(r.fd), uintptr(toSubmit), uintptr(minComplete), uintptr(flags), 0, 0)
+	return int(n), errno
+}
+
+// start queues op for submission. An op with a done channel is given
+// the ID its completion is sent by. On a dead ring, it fails at once
+// with ECANCELED.
+func (r *ring) start(op *ringOp) {
+	r.mu.Lock()
+	if r.dead {
+		r.mu.Unlock()
+		if op.done != nil {
+			op.done <- -int32(syscall.ECANCELED)
+		}
+		return
+	}
+	if op.done != nil {
+		r.nextID++
+		op.id = r.nextID
+		op.sqes[0].userData = op.id
+		r.ops[op.id] = op
+	}
+	r.queue = append(r.queue, op)
+	r.mu.Unlock()
+	select {
+	case r.wake <- true:
+	default:
+	}
+}
+
+// cancel asks the kernel to cancel the operation with the given ID,
+// which then completes with ECANCELED unless it already completed.
+func (r *ring) cancel(id uint64) {
+	op := &ringOp{n: 1}
+	op.sqes[0] = ringSQE{opcode: ioringOpAsyncCancel, fd: -1, addr: id}
+	r.start(op)
+}
+
+// submitLoop submits the queued ops, all those queued since the
+// last batch in one system call.
+func (r *ring) submitLoop() {
+	for range r.wake {
+		r.mu.Lock()
+		batch := r.queue
+		r.queue = nil
+		r.mu.Unlock()
+		for len(batch) > 0 {
+			batch = r.submit(batch)
+		}
+	}
+}
+
+// submit submits as many of batch as fit in the submission queue,
+// returning the rest.
+func (r *ring) submit(batch []*ringOp) []*ringOp {
+	tail := *r.sqTail
+	head := atomic.LoadUint32(r.sqHead)
+	var n uint32
+	var pos []uint32 // of the first entry of each op queued
+	i := 0
+	for ; i < len(batch); i++ {
+		op := batch[i]
+		if tail-head+uint32(op.n) > uint32(len(r.sqes)) {
+			break
+		}
+		pos = append(pos, tail)
+		for _, e := range op.sqes[:op.n] {
+			j := tail & r.sqMask
+			r.sqes[j] = e
+			r.sqArray[j] = j
+			tail++
+		}
+		n += uint32(op.n)
+	}
+	atomic.StoreUint32(r.sqTail, tail)
+	for n > 0 {
+		m, errno := r.enter(n, 0, 0)
+		switch errno {
+		case 0:
+			n -= uint32(m)
+		case syscall.EINTR, syscall.EAGAIN, syscall.EBUSY:
+			time.Sleep(time.Millisecond)
+		default:
+			r.fail(batch, pos)
+			return nil
+		}
+	}
+	return batch[i:]
+}
+
+// fail marks the ring dead once io_uring_enter has failed submitting
+// batch, whose ops queued first entries at pos. The kernel consumes
+// entries only within io_uring_enter, so those it hasn't yet are
+// withdrawn, by moving the tail back to its head, and their ops
+// failed with ECANCELED, falling back to the network poller along
+// with the rest of batch. Ops whose entries it consumed are left to
+// complete, as the kernel may still use their memory.
+func (r *ring) fail(batch []*ringOp, pos []uint32) {
+	r.mu.Lock()
+	r.dead = true
+	rest := r.queue
+	r.queue = nil
+	r.mu.Unlock()
+	head := atomic.LoadUint32(r.sqHead)
+	atomic.StoreUint32(r.sqTail, head)
+	for i, op := range batch {
+		if i < len(pos) && int32(pos[i]-head) < 0 {
+			continue
+		}
+		r.complete(op.id, -int32(syscall.ECANCELED))
+	}
+	for _, op := range rest {
+		r.complete(op.id, -int32(syscall.ECANCELED))
+	}
+}
+
+// completeLoop waits for completions, sending their results to their
+// ops.
+func (r *ring) completeLoop() {
+	for {
+		switch _, errno := r.enter(0, 1, ioringEnterGetEvents); errno {
+		case 0, syscall.EINTR, syscall.EAGAIN, syscall.EBUSY:
+		default:
+			// Nothing completes any more; the ops in flight
+			// are never released, their memory being the
+			// kernel's.
+			r.mu.Lock()
+			r.dead = true
+			rest := r.queue
+			r.queue = nil
+			r.mu.Unlock()
+			for _, op := range rest {
+				r.complete(op.id, -int32(syscall.ECANCELED))
+			}
+			return
+		}
+		head := *r.cqHead
+		tail := atomic.LoadUint32(r.cqTail)
+		for ; head != tail; head++ {
+			c := r.cqes[head&r.cqMask]
+			if c.userData != 0 {
+				r.complete(c.userData, c.res)
+			}
+		}
+		atomic.StoreUint32(r.cqHead, head)
+	}
+}
+
+func (r *ring) complete(id uint64, res int32) {
+	r.mu.Lock()
+	op := r.ops[id]
+	delete(r.ops, id)
+	r.mu.Unlock()
+	if op != nil {
+		op.done <- res
+	}
+}
+
+// A ringConn is a TCP connection whose reads and writes go through a
+// ring.
+type ringConn struct {
+	*net.TCPConn
+	r  *ring
+	fd int32
+
+	rmu, wmu sync.Mutex     // serialize reads, writes
+	inflight sync.WaitGroup // ops using fd
+
+	mu        sync.Mutex
+	rdeadline time.Time
+	wdeadline time.Time
+	rop, wop  *ringOp // in flight
+	closed    bool
+}
+
+// newRingConn returns c reading and writing through the shared ring, or
+// c itself if it isn't a TCP connection or io_uring isn't available.
+func newRingConn(c net.Conn) net.Conn {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return c
+	}
+	r := theRing()
+	if r == nil || r.isDead() {
+		return c
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return c
+	}
+	fd := -1
+	rc.Control(func(s uintptr) { fd = int(s) })
+	if fd < 0 {
+		return c
+	}
+	return &ringConn{TCPConn: tc, r: r, fd: int32(fd)}
+}
+
+func (c *ringConn) UnderlyingConn() net.Conn { return c.TCPConn }
+
+func (c *ringConn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return c.TCPConn.Read(b)
+	}
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	n, err, ok := c.do(ioringOpRecv, b)
+	if !ok {
+		return c.TCPConn.Read(b)
+	}
+	if err == nil && n == 0 {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (c *ringConn) Write(b []byte) (n int, err error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	for n < len(b) {
+		m, err, ok := c.do(ioringOpSend, b[n:])
+		if !ok {
+			m, err = c.TCPConn.Write(b[n:])
+			return n + m, err
+		}
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// do receives into or sends b through the ring. If ok is false, the
+// ring didn't do it and the caller should use the network poller
+// instead, which also reports a closed connection or a deadline
+// exceeded.
+func (c *ringConn) do(opcode uint8, b []byte) (n int, err error, ok bool) {
+	op := &ringOp{buf: b, done: make(chan int32, 1), n: 1}
+	op.sqes[0] = ringSQE{
+		opcode:  opcode,
+		fd:      c.fd,
+		addr:    uint64(uintptr(unsafe.Pointer(&b[0]))),
+		len:     uint32(len(b)),
+		opFlags: msgNoSignal,
+	}
+	if len(b) > 1<<30 {
+		op.sqes[0].len = 1 << 30
+	}
+	slot, deadline := &c.rop, &c.rdeadline
+	if opcode == ioringOpSend {
+		slot, deadline = &c.wop, &c.wdeadline
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return 0, nil, false
+	}
+	if !deadline.IsZero() {
+		d := time.Until(*deadline)
+		if d <= 0 {
+			c.mu.Unlock()
+			return 0, nil, false
+		}
+		op.ts = kernelTimespec{sec: int64(d / time.Second), nsec: int64(d % time.Second)}
+		op.sqes[0].flags |= iosqeIOLink
+		op.sqes[1] = ringSQE{opcode: ioringOpLinkTimeout, fd: -1, addr: uint64(uintptr(unsafe.Pointer(&op.ts))), len: 1}
+		op.n = 2
+	}
+	*slot = op
+	c.inflight.Add(1)
+	c.r.start(op)
+	c.mu.Unlock()
+
+	res := <-op.done
+	c.mu.Lock()
+	*slot = nil
+	c.mu.Unlock()
+	c.inflight.Done()
+
+	if res >= 0 {
+		return int(res), nil, true
+	}
+	switch errno := syscall.Errno(-res); errno {
+	case syscall.ECANCELED, syscall.EAGAIN, syscall.EINTR:
+		return 0, nil, false
+	default:
+		name, netOp := "recv", "read"
+		if opcode == ioringOpSend {
+			name, netOp = "send", "write"
+		}
+		return 0, &net.OpError{Op: netOp, Net: "tcp", Source: c.LocalAddr(), Addr: c.RemoteAddr(), Err: os.NewSyscallError(name, errno)}, true
+	}
+}
+
+// cancelLocked cancels the ops in flight, whose callers then retry
+// with the network poller, which has the current deadlines. c.mu must
+// be held.
+func (c *ringConn) cancelLocked(read, write bool) {
+	if read && c.rop != nil {
+		c.r.cancel(c.rop.id)
+	}
+	if write && c.wop != nil {
+		c.r.cancel(c.wop.id)
+	}
+}
+
+func (c *ringConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.rdeadline, c.wdeadline = t, t
+	c.cancelLocked(true, true)
+	c.mu.Unlock()
+	return c.TCPConn.SetDeadline(t)
+}
+
+func (c *ringConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.rdeadline = t
+	c.cancelLocked(true, false)
+	c.mu.Unlock()
+	return c.TCPConn.SetReadDeadline(t)
+}
+
+func (c *ringConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.wdeadline = t
+	c.cancelLocked(false, true)
+	c.mu.Unlock()
+	return c.TCPConn.SetWriteDeadline(t)
+}
+
+// Close cancels the ops in flight and waits for them to complete, as
+// the kernel looks up the descriptor only when it receives them, by
+// which time it could otherwise be that of another file.
+func (c *ringConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.cancelLocked(true, true)
+	c.mu.Unlock()
+	c.inflight.Wait()
+	return c.TCPConn.Close()
+}
@@ -0,0 +1,38 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux && uring
+// +build linux,uring
+
+package http_test
+
+import (
+	"fmt"
+	. "net/http"
+	"testing"
+)
+
+func TestServerIOUringConn(t *testing.T) {
+	defer afterTest(t)
+	if !ExportRingAvailable() {
+		t.Skip("io_uring not available")
+	}
+	conns := make(chan string, 1)
+	ts := newRingServer(t, 0, HandlerFunc(func(w ResponseWriter, r *Request) {
+		c, _, err := w.(Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- fmt.Sprintf("%T", c)
+		c.Close()
+	}))
+	defer ts.Close()
+	if res, err := Get(ts.URL); err == nil {
+		res.Body.Close()
+	}
+	if got, want := <-conns, "*http.ringConn"; got != want {
+		t.Errorf("connection type = %s; want %s", got, want)
+	}
+}
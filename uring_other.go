@@ -0,0 +1,14 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux || !uring
+// +build !linux !uring
+
+package http
+
+import "net"
+
+func ringAvailable() bool { return false }
+
+func newRingConn(c net.Conn) net.Conn { return c }
@@ -0,0 +1,119 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newRingServer starts a server with IOUring set, which uses the ring
+// if the test binary is built with the uring tag and io_uring is
+// available, and the network poller otherwise.
+func newRingServer(t *testing.T, readTimeout time.Duration, h Handler) *httptest.Server {
+	ts := httptest.NewUnstartedServer(h)
+	ts.Config.IOUring = true
+	ts.Config.ReadTimeout = readTimeout
+	ts.Start()
+	t.Logf("io_uring available: %v", ExportRingAvailable())
+	return ts
+}
+
+func TestServerIOUring(t *testing.T) {
+	defer afterTest(t)
+	ts := newRingServer(t, 250*time.Millisecond, HandlerFunc(func(w ResponseWriter, r *Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	// Keep-alive requests, with bodies larger than one write.
+	c, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+	for _, size := range []int{0, 10, 1 << 20} {
+		body := strings.Repeat("x", size)
+		req, _ := NewRequest("POST", ts.URL, strings.NewReader(body))
+		if err := req.Write(c); err != nil {
+			t.Fatal(err)
+		}
+		res, err := ReadResponse(br, req)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		got, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil || string(got) != body {
+			t.Fatalf("size %d: got %d bytes, %v; want %d bytes", size, len(got), err, size)
+		}
+	}
+
+	// The read of an idle connection times out.
+	t0 := time.Now()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("idle connection: Read error %v; want EOF", err)
+	}
+	if d := time.Since(t0); d < 200*time.Millisecond {
+		t.Errorf("idle connection closed after %v; want the ReadTimeout of 250ms", d)
+	}
+}
+
+func TestServerIOUringClose(t *testing.T) {
+	defer afterTest(t)
+	ts := newRingServer(t, 0, HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
+
+	c, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	io.WriteString(c, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	br := bufio.NewReader(c)
+	res, err := ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	// Drain closes the connection while its next read is in flight.
+	if err := ts.Config.Drain(50 * time.Millisecond); err != nil {
+		t.Errorf("Drain: %v", err)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("Read error %v after Drain; want EOF", err)
+	}
+}
+
+func TestServerIOUringFile(t *testing.T) {
+	defer afterTest(t)
+	data := bytes.Repeat([]byte("0123456789"), 1<<16)
+	ts := newRingServer(t, 0, HandlerFunc(func(w ResponseWriter, r *Request) {
+		ServeContent(w, r, "f", time.Time{}, bytes.NewReader(data))
+	}))
+	defer ts.Close()
+	res, err := Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("got %d bytes, %v; want %d bytes", len(got), err, len(data))
+	}
+}
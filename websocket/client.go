@@ -0,0 +1,172 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrBadHandshake is returned by Dial when the server does not accept
+// the upgrade; the server's response is returned too.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// A Dialer opens WebSocket connections to servers.
+type Dialer struct {
+	// NetDial optionally dials the server's "host:port". If nil,
+	// net.Dial is used.
+	NetDial func(network, addr string) (net.Conn, error)
+
+	// TLSClientConfig is the TLS configuration for wss URLs. If
+	// nil, the default configuration is used.
+	TLSClientConfig *tls.Config
+
+	// HandshakeTimeout, if positive, limits the time for the
+	// opening handshake.
+	HandshakeTimeout time.Duration
+
+	// Subprotocols lists the subprotocols offered to the server.
+	Subprotocols []string
+
+	// EnableCompression offers per-message compression to the
+	// server.
+	EnableCompression bool
+}
+
+// DefaultDialer is a Dialer with the defaults.
+var DefaultDialer = &Dialer{}
+
+// Dial opens a WebSocket connection to the ws or wss URL urlStr,
+// sending requestHeader, which may set an Origin or cookies, with the
+// handshake. When the server refuses the upgrade, Dial returns its
+// response, whose body the caller may read, and ErrBadHandshake.
+func (d *Dialer) Dial(urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	var port string
+	switch u.Scheme {
+	case "ws":
+		port = "80"
+	case "wss":
+		port = "443"
+	default:
+		return nil, nil, errors.New("websocket: bad scheme " + u.Scheme)
+	}
+	hostPort := u.Host
+	if _, _, err := net.SplitHostPort(hostPort); err != nil {
+		hostPort = net.JoinHostPort(strings.Trim(u.Host, "[]"), port)
+	}
+
+	dial := d.NetDial
+	if dial == nil {
+		dial = net.Dial
+	}
+	conn, err := dial("tcp", hostPort)
+	if err != nil {
+		return nil, nil, err
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			conn.Close()
+		}
+	}()
+	if d.HandshakeTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(d.HandshakeTimeout))
+	}
+	if u.Scheme == "wss" {
+		cfg := new(tls.Config)
+		if d.TLSClientConfig != nil {
+			cfg = d.TLSClientConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(hostPort)
+		}
+		tlsConn := tls.Client(conn, cfg)
+		conn = tlsConn
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	keyBytes := make([]byte, 16)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return nil, nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(keyBytes)
+	hu := *u
+	hu.Scheme = "http"
+	if u.Scheme == "wss" {
+		hu.Scheme = "https"
+	}
+	req, err := http.NewRequest("GET", hu.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, vv := range requestHeader {
+		req.Header[k] = vv
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-Websocket-Key", key)
+	req.Header.Set("Sec-Websocket-Version", "13")
+	if len(d.Subprotocols) > 0 {
+		req.Header.Set("Sec-Websocket-Protocol", strings.Join(d.Subprotocols, ", "))
+	}
+	if d.EnableCompression {
+		req.Header.Set("Sec-Websocket-Extensions", deflateResponse)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols ||
+		!headerHasToken(res.Header, "Upgrade", "websocket") ||
+		!headerHasToken(res.Header, "Connection", "upgrade") {
+		return nil, res, ErrBadHandshake
+	}
+	if res.Header.Get("Sec-Websocket-Accept") != acceptKey(key) {
+		return nil, res, HandshakeError{"bad Sec-WebSocket-Accept"}
+	}
+
+	c := newConn(conn, false, br, nil)
+	c.subprotocol = res.Header.Get("Sec-Websocket-Protocol")
+	if c.subprotocol != "" && !contains(d.Subprotocols, c.subprotocol) {
+		return nil, res, HandshakeError{"server chose an unoffered subprotocol"}
+	}
+	for _, ext := range extensions(res.Header) {
+		if ext[0] != deflateExtension || !d.EnableCompression {
+			return nil, res, HandshakeError{"server chose an unoffered extension"}
+		}
+		c.compress = true
+	}
+	conn.SetDeadline(time.Time{})
+	ok = true
+	return c, res, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, t := range list {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
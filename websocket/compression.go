@@ -0,0 +1,99 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Per-message compression, the permessage-deflate extension of RFC
+// 7692, without context takeover: each message is compressed on its
+// own.
+
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	deflateExtension = "permessage-deflate"
+
+	// deflateResponse is the extension a server accepts and a client
+	// offers.
+	deflateResponse = deflateExtension + "; server_no_context_takeover; client_no_context_takeover"
+)
+
+// deflateTail ends each compressed message, flushed, and is removed
+// from it when sent (RFC 7692 section 7.2.1).
+const deflateTail = "\x00\x00\xff\xff"
+
+func compress(data []byte) []byte {
+	var b bytes.Buffer
+	fw, _ := flate.NewWriter(&b, flate.DefaultCompression)
+	fw.Write(data)
+	fw.Flush()
+	return bytes.TrimSuffix(b.Bytes(), []byte(deflateTail))
+}
+
+// decompress decompresses a message, of at most limit bytes if limit
+// is positive.
+func decompress(data []byte, limit int64) ([]byte, error) {
+	// The tail restores the flush, and a final empty stored block
+	// ends the stream.
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(data), strings.NewReader(deflateTail+"\x01\x00\x00\xff\xff")))
+	defer fr.Close()
+	var r io.Reader = fr
+	if limit > 0 {
+		r = io.LimitReader(fr, limit+1)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, &protocolError{CloseInvalidFramePayloadData, "invalid compressed message"}
+	}
+	if limit > 0 && int64(len(b)) > limit {
+		return nil, ErrReadLimit
+	}
+	return b, nil
+}
+
+// extensions returns the extensions listed in the
+// Sec-WebSocket-Extensions headers of h, each its name and
+// parameters.
+func extensions(h http.Header) [][]string {
+	var exts [][]string
+	for _, v := range h["Sec-Websocket-Extensions"] {
+		for _, ext := range strings.Split(v, ",") {
+			var params []string
+			for _, p := range strings.Split(ext, ";") {
+				if p = strings.TrimSpace(p); p != "" {
+					params = append(params, p)
+				}
+			}
+			if len(params) > 0 {
+				exts = append(exts, params)
+			}
+		}
+	}
+	return exts
+}
+
+// acceptDeflate reports whether a server can accept a client's offer
+// of permessage-deflate with params. Its own window is always the
+// largest, so offers limiting it are declined.
+func acceptDeflate(params []string) bool {
+	for _, p := range params {
+		name := strings.TrimSpace(strings.SplitN(p, "=", 2)[0])
+		switch name {
+		case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+		case "server_max_window_bits":
+			if strings.TrimSpace(strings.Trim(strings.SplitN(p+"=", "=", 2)[1], `"`)) != "15" {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
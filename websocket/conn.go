@@ -0,0 +1,525 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package websocket implements the WebSocket protocol of RFC 6455,
+// with the per-message compression of RFC 7692, for servers
+// upgrading the requests of package http and for clients.
+//
+// A Conn may have one goroutine reading from it and others writing to
+// it concurrently.
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The message types, which are the opcodes of their frames (RFC
+// 6455 section 5.2).
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+const continuationFrame = 0
+
+// Close codes (RFC 6455 section 7.4.1).
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseInternalServerError     = 1011
+)
+
+const (
+	finalBit = 1 << 7
+	rsv1Bit  = 1 << 6 // a compressed message
+	rsv2Bit  = 1 << 5
+	rsv3Bit  = 1 << 4
+	maskBit  = 1 << 7
+
+	maxControlPayload = 125
+)
+
+// A CloseError is returned by ReadMessage when the peer closes the
+// connection, with the code and text of its close message.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	s := "websocket: close " + strconv.Itoa(e.Code)
+	if e.Text != "" {
+		s += ": " + e.Text
+	}
+	return s
+}
+
+var (
+	// ErrCloseSent is returned by writes after a close message
+	// has been sent.
+	ErrCloseSent = errors.New("websocket: close sent")
+
+	// ErrReadLimit is returned by ReadMessage for messages over the
+	// read limit, after which the connection is closed.
+	ErrReadLimit = errors.New("websocket: read limit exceeded")
+)
+
+// A protocolError is a violation of the protocol by the peer, after
+// which the connection is closed with its code.
+type protocolError struct {
+	code int
+	text string
+}
+
+func (e *protocolError) Error() string { return "websocket: " + e.text }
+
+func errProtocol(text string) error {
+	return &protocolError{CloseProtocolError, text}
+}
+
+// FormatCloseMessage returns the payload of a close message with code
+// and text.
+func FormatCloseMessage(code int, text string) []byte {
+	if code == CloseNoStatusReceived {
+		return []byte{}
+	}
+	b := make([]byte, 2+len(text))
+	binary.BigEndian.PutUint16(b, uint16(code))
+	copy(b[2:], text)
+	return b
+}
+
+// A Conn is a WebSocket connection.
+type Conn struct {
+	conn        net.Conn
+	server      bool
+	subprotocol string
+	compress    bool // permessage-deflate was negotiated
+
+	wmu       sync.Mutex // guards bw and closeSent
+	bw        *bufio.Writer
+	closeSent bool
+
+	// Used by the reading goroutine.
+	br          *bufio.Reader
+	readLimit   int64
+	readErr     error
+	pingHandler func(appData string) error
+	pongHandler func(appData string) error
+
+	kmu       sync.Mutex // guards keepAlive, pinging and done
+	keepAlive time.Duration
+	pinging   bool          // pingLoop is running
+	done      chan struct{} // closed by Close
+}
+
+func newConn(conn net.Conn, server bool, br *bufio.Reader, bw *bufio.Writer) *Conn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	if bw == nil {
+		bw = bufio.NewWriter(conn)
+	}
+	return &Conn{conn: conn, server: server, br: br, bw: bw, done: make(chan struct{})}
+}
+
+// Subprotocol returns the subprotocol negotiated in the handshake,
+// if any.
+func (c *Conn) Subprotocol() string { return c.subprotocol }
+
+// Compressed reports whether the peers compress their messages.
+func (c *Conn) Compressed() bool { return c.compress }
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+
+// RemoteAddr returns the remote network address: for connections
+// read with http.ReadProxyHeader, the client's address of the PROXY
+// protocol header.
+func (c *Conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// UnderlyingConn returns the connection the WebSocket protocol is
+// spoken over.
+func (c *Conn) UnderlyingConn() net.Conn { return c.conn }
+
+// SetReadDeadline sets the deadline for reads. After a read has timed
+// out, the connection is broken and all reads fail.
+func (c *Conn) SetReadDeadline(t time.Time) error { return c.conn.SetReadDeadline(t) }
+
+// SetWriteDeadline sets the deadline for writes. After a write has
+// timed out, the connection is broken and all writes fail.
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+
+// SetReadLimit sets the maximum size in bytes of a message read from
+// the peer, after decompression, zero meaning none. The connection is
+// closed for messages over it and ReadMessage returns ErrReadLimit.
+func (c *Conn) SetReadLimit(limit int64) { c.readLimit = limit }
+
+// SetPingHandler sets the handler of the ping messages read by
+// ReadMessage. The default handler replies with a pong message.
+func (c *Conn) SetPingHandler(h func(appData string) error) { c.pingHandler = h }
+
+// SetPongHandler sets the handler of the pong messages read by
+// ReadMessage. By default they are ignored.
+func (c *Conn) SetPongHandler(h func(appData string) error) { c.pongHandler = h }
+
+// Close closes the underlying connection, without sending a close
+// message; see WriteControl for that.
+func (c *Conn) Close() error {
+	c.kmu.Lock()
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+	c.kmu.Unlock()
+	return c.conn.Close()
+}
+
+// KeepAlive has the connection send a ping message every interval,
+// and fail reads if nothing is read from the peer, which replies with
+// pong messages, for twice that. Reading must be in progress for the
+// pongs to be read. An interval of zero or less stops it.
+func (c *Conn) KeepAlive(interval time.Duration) {
+	c.kmu.Lock()
+	defer c.kmu.Unlock()
+	c.keepAlive = interval
+	if interval <= 0 {
+		c.conn.SetReadDeadline(time.Time{})
+		return
+	}
+	c.conn.SetReadDeadline(time.Now().Add(2 * interval))
+	if !c.pinging {
+		c.pinging = true
+		go c.pingLoop()
+	}
+}
+
+func (c *Conn) pingLoop() {
+	for {
+		c.kmu.Lock()
+		interval := c.keepAlive
+		if interval <= 0 {
+			c.pinging = false
+		}
+		c.kmu.Unlock()
+		if interval <= 0 {
+			return
+		}
+		select {
+		case <-c.done:
+			return
+		case <-time.After(interval):
+		}
+		if err := c.WriteControl(PingMessage, nil); err != nil {
+			return
+		}
+	}
+}
+
+// extendKeepAlive extends the read deadline after reading from the
+// peer, if KeepAlive is on.
+func (c *Conn) extendKeepAlive() {
+	c.kmu.Lock()
+	if d := c.keepAlive; d > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(2 * d))
+	}
+	c.kmu.Unlock()
+}
+
+// ReadMessage reads the next text or binary message from the peer,
+// handling the control messages before it. When the peer closes the
+// connection it returns a *CloseError, having replied with a close
+// message. Once it returns an error, it returns the same error.
+func (c *Conn) ReadMessage() (messageType int, p []byte, err error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	messageType, p, err = c.readMessage()
+	if err != nil {
+		if pe, ok := err.(*protocolError); ok {
+			c.WriteControl(CloseMessage, FormatCloseMessage(pe.code, ""))
+			c.conn.Close()
+		} else if err == ErrReadLimit {
+			c.WriteControl(CloseMessage, FormatCloseMessage(CloseMessageTooBig, ""))
+			c.conn.Close()
+		}
+		c.readErr = err
+	}
+	return
+}
+
+type frameHeader struct {
+	fin    bool
+	rsv1   bool
+	opcode int
+	length int64
+	masked bool
+	mask   [4]byte
+}
+
+func (c *Conn) readFrameHeader() (h frameHeader, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return h, err
+	}
+	c.extendKeepAlive()
+	h.fin = hdr[0]&finalBit != 0
+	h.rsv1 = hdr[0]&rsv1Bit != 0
+	h.opcode = int(hdr[0] & 0xf)
+	h.masked = hdr[1]&maskBit != 0
+	h.length = int64(hdr[1] &^ maskBit)
+	var b [8]byte // the extended payload length
+	switch h.length {
+	case 126:
+		if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+			return h, err
+		}
+		h.length = int64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.br, b[:8]); err != nil {
+			return h, err
+		}
+		n := binary.BigEndian.Uint64(b[:8])
+		if n>>63 != 0 {
+			return h, errProtocol("invalid frame length")
+		}
+		h.length = int64(n)
+	}
+	if h.masked {
+		if _, err := io.ReadFull(c.br, h.mask[:]); err != nil {
+			return h, err
+		}
+	}
+
+	switch {
+	case hdr[0]&(rsv2Bit|rsv3Bit) != 0:
+		return h, errProtocol("unexpected reserved bits")
+	case h.masked != c.server:
+		if c.server {
+			return h, errProtocol("client frame not masked")
+		}
+		return h, errProtocol("server frame masked")
+	case h.opcode == CloseMessage || h.opcode == PingMessage || h.opcode == PongMessage:
+		if !h.fin || h.length > maxControlPayload || h.rsv1 {
+			return h, errProtocol("invalid control frame")
+		}
+	case h.opcode == TextMessage || h.opcode == BinaryMessage:
+		if h.rsv1 && !c.compress {
+			return h, errProtocol("unexpected compressed frame")
+		}
+	case h.opcode == continuationFrame:
+		if h.rsv1 {
+			return h, errProtocol("compressed continuation frame")
+		}
+	default:
+		return h, errProtocol("unknown opcode " + strconv.Itoa(h.opcode))
+	}
+	return h, nil
+}
+
+// readPayload appends the payload of the frame with header h to buf.
+func (c *Conn) readPayload(h frameHeader, buf []byte) ([]byte, error) {
+	start := len(buf)
+	b := bytes.NewBuffer(buf)
+	if _, err := io.CopyN(b, c.br, h.length); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	buf = b.Bytes()
+	if h.masked {
+		for i := range buf[start:] {
+			buf[start+i] ^= h.mask[i%4]
+		}
+	}
+	return buf, nil
+}
+
+func (c *Conn) readMessage() (int, []byte, error) {
+	var (
+		messageType int
+		compressed  bool
+		buf         []byte
+	)
+	for {
+		h, err := c.readFrameHeader()
+		if err != nil {
+			return 0, nil, err
+		}
+		if h.opcode >= CloseMessage {
+			payload, err := c.readPayload(h, nil)
+			if err != nil {
+				return 0, nil, err
+			}
+			if err := c.handleControl(h.opcode, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+		if h.opcode == continuationFrame {
+			if messageType == 0 {
+				return 0, nil, errProtocol("unexpected continuation frame")
+			}
+		} else {
+			if messageType != 0 {
+				return 0, nil, errProtocol("expected continuation frame")
+			}
+			messageType, compressed = h.opcode, h.rsv1
+		}
+		if c.readLimit > 0 && int64(len(buf))+h.length > c.readLimit {
+			return 0, nil, ErrReadLimit
+		}
+		if buf, err = c.readPayload(h, buf); err != nil {
+			return 0, nil, err
+		}
+		if h.fin {
+			break
+		}
+	}
+	if compressed {
+		var err error
+		if buf, err = decompress(buf, c.readLimit); err != nil {
+			return 0, nil, err
+		}
+	}
+	if messageType == TextMessage && !utf8.Valid(buf) {
+		return 0, nil, &protocolError{CloseInvalidFramePayloadData, "invalid UTF-8 in text message"}
+	}
+	return messageType, buf, nil
+}
+
+func (c *Conn) handleControl(opcode int, payload []byte) error {
+	switch opcode {
+	case PingMessage:
+		if h := c.pingHandler; h != nil {
+			return h(string(payload))
+		}
+		if err := c.WriteControl(PongMessage, payload); err != nil && err != ErrCloseSent {
+			return err
+		}
+	case PongMessage:
+		if h := c.pongHandler; h != nil {
+			return h(string(payload))
+		}
+	case CloseMessage:
+		code, text := CloseNoStatusReceived, ""
+		switch {
+		case len(payload) == 1:
+			return errProtocol("invalid close payload")
+		case len(payload) >= 2:
+			code, text = int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+			if !validCloseCode(code) || !utf8.ValidString(text) {
+				return errProtocol("invalid close payload")
+			}
+		}
+		c.WriteControl(CloseMessage, FormatCloseMessage(code, ""))
+		return &CloseError{Code: code, Text: text}
+	}
+	return nil
+}
+
+// validCloseCode reports whether code may be sent in a close message.
+func validCloseCode(code int) bool {
+	switch code {
+	case 1000, 1001, 1002, 1003, 1007, 1008, 1009, 1010, 1011:
+		return true
+	}
+	return code >= 3000 && code <= 4999
+}
+
+// WriteMessage writes a message of messageType with payload data. Text
+// and binary messages are compressed if compression was negotiated.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case TextMessage, BinaryMessage:
+	case CloseMessage, PingMessage, PongMessage:
+		return c.WriteControl(messageType, data)
+	default:
+		return errors.New("websocket: invalid message type " + strconv.Itoa(messageType))
+	}
+	b0 := byte(messageType)
+	if c.compress {
+		data = compress(data)
+		b0 |= rsv1Bit
+	}
+	return c.writeFrame(b0, data)
+}
+
+// WriteControl writes a close, ping or pong message with payload data
+// of at most 125 bytes. Once a close message is sent, the peer is
+// expected to close the connection, and writes return ErrCloseSent.
+func (c *Conn) WriteControl(messageType int, data []byte) error {
+	if messageType != CloseMessage && messageType != PingMessage && messageType != PongMessage {
+		return errors.New("websocket: invalid control message type " + strconv.Itoa(messageType))
+	}
+	if len(data) > maxControlPayload {
+		return errors.New("websocket: control message too long")
+	}
+	return c.writeFrame(byte(messageType), data)
+}
+
+func (c *Conn) writeFrame(b0 byte, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if int(b0&0xf) == CloseMessage {
+		c.closeSent = true
+	}
+	var hdr [14]byte
+	hdr[0] = b0 | finalBit
+	n := 2
+	switch l := len(data); {
+	case l <= 125:
+		hdr[1] = byte(l)
+	case l <= 0xffff:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(l))
+		n = 4
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(l))
+		n = 10
+	}
+	if !c.server {
+		// Clients mask their frames with a random key.
+		hdr[1] |= maskBit
+		key := hdr[n : n+4]
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return err
+		}
+		n += 4
+		masked := make([]byte, len(data))
+		for i, b := range data {
+			masked[i] = b ^ key[i%4]
+		}
+		data = masked
+	}
+	c.bw.Write(hdr[:n])
+	c.bw.Write(data)
+	return c.bw.Flush()
+}
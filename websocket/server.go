@@ -0,0 +1,190 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// acceptGUID is appended to the key of a handshake for its accept
+// value (RFC 6455 section 4.2.2).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// statusUpgradeRequired asks clients of other versions of the
+// protocol for version 13 (RFC 6455 section 4.4).
+const statusUpgradeRequired = 426
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// A HandshakeError is returned by Upgrade and Dial when the opening
+// handshake fails.
+type HandshakeError struct {
+	message string
+}
+
+func (e HandshakeError) Error() string { return "websocket: " + e.message }
+
+// An Upgrader upgrades HTTP requests to WebSocket connections.
+type Upgrader struct {
+	// Subprotocols lists the server's subprotocols in order of
+	// preference. The first the client also lists is chosen.
+	Subprotocols []string
+
+	// CheckOrigin, if non-nil, reports whether a request's Origin
+	// is acceptable. If nil, requests with an Origin header are
+	// accepted only if its host is the request's Host, guarding
+	// against cross-site WebSocket hijacking by browsers.
+	CheckOrigin func(r *http.Request) bool
+
+	// EnableCompression accepts the client's offer of per-message
+	// compression, if any.
+	EnableCompression bool
+
+	// PingInterval, if positive, starts KeepAlive with it on each
+	// connection.
+	PingInterval time.Duration
+}
+
+// IsWebSocketUpgrade reports whether r asks to be upgraded to the
+// WebSocket protocol.
+func IsWebSocketUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") &&
+		headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+// headerHasToken reports whether the comma-separated values of
+// headers key of h include token, ignoring case.
+func headerHasToken(h http.Header, key, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(key)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// Upgrade upgrades the request r of the handler serving w to a
+// WebSocket connection, taking over the connection. The response header
+// includes responseHeader, which may set cookies but not the
+// subprotocol, chosen from u.Subprotocols.
+//
+// The ResponseWriter is reached through any middleware able to Unwrap
+// it, as by http.ResponseController. If the handshake fails, Upgrade
+// replies with an HTTP error and returns a HandshakeError.
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	fail := func(code int, message string) (*Conn, error) {
+		text := http.StatusText(code)
+		if code == statusUpgradeRequired {
+			w.Header().Set("Sec-Websocket-Version", "13")
+			text = "Upgrade Required"
+		}
+		http.Error(w, text, code)
+		return nil, HandshakeError{message}
+	}
+	if r.Method != "GET" {
+		return fail(http.StatusMethodNotAllowed, "request method is not GET")
+	}
+	if !IsWebSocketUpgrade(r) {
+		return fail(http.StatusBadRequest, "not a WebSocket upgrade request")
+	}
+	if r.Header.Get("Sec-Websocket-Version") != "13" {
+		return fail(statusUpgradeRequired, "unsupported version")
+	}
+	key := r.Header.Get("Sec-Websocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		return fail(http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		return fail(http.StatusForbidden, "origin not allowed")
+	}
+
+	var subprotocol string
+	if len(u.Subprotocols) > 0 {
+		offered := make(map[string]bool)
+		for _, v := range r.Header["Sec-Websocket-Protocol"] {
+			for _, p := range strings.Split(v, ",") {
+				offered[strings.TrimSpace(p)] = true
+			}
+		}
+		for _, p := range u.Subprotocols {
+			if offered[p] {
+				subprotocol = p
+				break
+			}
+		}
+	}
+	compress := false
+	if u.EnableCompression {
+		for _, ext := range extensions(r.Header) {
+			if ext[0] == deflateExtension && acceptDeflate(ext[1:]) {
+				compress = true
+				break
+			}
+		}
+	}
+
+	rwc, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return fail(http.StatusInternalServerError, "cannot take over the connection: "+err.Error())
+	}
+	// Deadlines set by the server, such as its ReadTimeout, no
+	// longer apply.
+	rwc.SetDeadline(time.Time{})
+
+	h := make(http.Header)
+	for k, vv := range responseHeader {
+		if k != "Sec-Websocket-Protocol" && k != "Sec-Websocket-Extensions" {
+			h[k] = vv
+		}
+	}
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-Websocket-Accept", acceptKey(key))
+	if subprotocol != "" {
+		h.Set("Sec-Websocket-Protocol", subprotocol)
+	}
+	if compress {
+		h.Set("Sec-Websocket-Extensions", deflateResponse)
+	}
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	h.Write(brw)
+	brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		rwc.Close()
+		return nil, err
+	}
+
+	c := newConn(rwc, true, brw.Reader, brw.Writer)
+	c.subprotocol = subprotocol
+	c.compress = compress
+	if u.PingInterval > 0 {
+		c.KeepAlive(u.PingInterval)
+	}
+	return c, nil
+}
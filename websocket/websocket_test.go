@@ -0,0 +1,317 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoServer returns a server upgrading requests with u and echoing
+// the messages it reads until the connection is closed.
+func echoServer(u *Upgrader) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			mt, p, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := c.WriteMessage(mt, p); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func wsURL(ts *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+func TestEcho(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		ts := echoServer(&Upgrader{EnableCompression: true})
+		d := &Dialer{EnableCompression: compress}
+		c, _, err := d.Dial(wsURL(ts), nil)
+		if err != nil {
+			t.Fatalf("compress=%v: Dial: %v", compress, err)
+		}
+		if c.Compressed() != compress {
+			t.Errorf("compress=%v: Compressed = %v", compress, c.Compressed())
+		}
+		msgs := []struct {
+			mt   int
+			data []byte
+		}{
+			{TextMessage, []byte("hello")},
+			{BinaryMessage, []byte{0, 1, 2, 0xff}},
+			{TextMessage, []byte(strings.Repeat("long message ", 10000))},
+			{BinaryMessage, nil},
+		}
+		for _, m := range msgs {
+			if err := c.WriteMessage(m.mt, m.data); err != nil {
+				t.Fatalf("compress=%v: WriteMessage: %v", compress, err)
+			}
+			mt, p, err := c.ReadMessage()
+			if err != nil {
+				t.Fatalf("compress=%v: ReadMessage: %v", compress, err)
+			}
+			if mt != m.mt || !bytes.Equal(p, m.data) {
+				t.Errorf("compress=%v: got type %d, %d bytes; want type %d, %d bytes", compress, mt, len(p), m.mt, len(m.data))
+			}
+		}
+		c.Close()
+		ts.Close()
+	}
+}
+
+// TestFrameLengths sends messages whose frames have 16-bit and 64-bit
+// extended payload lengths, some with the bits of the reserved flags
+// and opcodes set in their first byte.
+func TestFrameLengths(t *testing.T) {
+	ts := echoServer(&Upgrader{})
+	defer ts.Close()
+	c, _, err := DefaultDialer.Dial(wsURL(ts), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, n := range []int{126, 0x1000, 0x2000, 0x4000, 0x7000, 0xffff, 0x10000, 0x401000} {
+		data := bytes.Repeat([]byte{'x'}, n)
+		if err := c.WriteMessage(BinaryMessage, data); err != nil {
+			t.Fatalf("%d bytes: WriteMessage: %v", n, err)
+		}
+		_, p, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("%d bytes: ReadMessage: %v", n, err)
+		}
+		if !bytes.Equal(p, data) {
+			t.Errorf("%d bytes: echoed %d bytes", n, len(p))
+		}
+	}
+}
+
+func TestSubprotocol(t *testing.T) {
+	ts := echoServer(&Upgrader{Subprotocols: []string{"v2", "v1"}})
+	defer ts.Close()
+	d := &Dialer{Subprotocols: []string{"v1", "v2"}}
+	c, _, err := d.Dial(wsURL(ts), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got := c.Subprotocol(); got != "v2" {
+		t.Errorf("Subprotocol = %q; want v2", got)
+	}
+}
+
+func TestPingPong(t *testing.T) {
+	ts := echoServer(&Upgrader{})
+	defer ts.Close()
+	c, _, err := DefaultDialer.Dial(wsURL(ts), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var pong string
+	c.SetPongHandler(func(appData string) error {
+		pong = appData
+		return nil
+	})
+	if err := c.WriteControl(PingMessage, []byte("ping data")); err != nil {
+		t.Fatal(err)
+	}
+	// The pong arrives before the echo of the message after the ping.
+	if err := c.WriteMessage(TextMessage, []byte("after")); err != nil {
+		t.Fatal(err)
+	}
+	if _, p, err := c.ReadMessage(); err != nil || string(p) != "after" {
+		t.Fatalf("ReadMessage = %q, %v", p, err)
+	}
+	if pong != "ping data" {
+		t.Errorf("pong = %q; want %q", pong, "ping data")
+	}
+	if err := c.WriteControl(PingMessage, make([]byte, 126)); err == nil {
+		t.Error("WriteControl of 126 bytes succeeded")
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	pinged := make(chan bool, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := &Upgrader{PingInterval: 20 * time.Millisecond}
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+	c, _, err := DefaultDialer.Dial(wsURL(ts), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetPingHandler(func(appData string) error {
+		select {
+		case pinged <- true:
+		default:
+		}
+		return c.WriteControl(PongMessage, []byte(appData))
+	})
+	go c.ReadMessage()
+	select {
+	case <-pinged:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no ping from the server")
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	done := make(chan error, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := (&Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer c.Close()
+		_, _, err = c.ReadMessage()
+		done <- err
+	}))
+	defer ts.Close()
+	c, _, err := DefaultDialer.Dial(wsURL(ts), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, "bye")); err != nil {
+		t.Fatal(err)
+	}
+	err = <-done
+	ce, ok := err.(*CloseError)
+	if !ok || ce.Code != CloseNormalClosure || ce.Text != "bye" {
+		t.Fatalf("server ReadMessage error = %v; want close 1000 bye", err)
+	}
+	// The server echoes the close.
+	_, _, err = c.ReadMessage()
+	if ce, ok := err.(*CloseError); !ok || ce.Code != CloseNormalClosure {
+		t.Fatalf("client ReadMessage error = %v; want close 1000", err)
+	}
+	if err := c.WriteMessage(TextMessage, []byte("late")); err != ErrCloseSent {
+		t.Errorf("WriteMessage after close = %v; want ErrCloseSent", err)
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	// Incompressible data, so that compressed messages past the limit
+	// are too.
+	data := make([]byte, 100)
+	rand.New(rand.NewSource(1)).Read(data)
+	for _, compress := range []bool{false, true} {
+		done := make(chan error, 1)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, err := (&Upgrader{EnableCompression: true}).Upgrade(w, r, nil)
+			if err != nil {
+				done <- err
+				return
+			}
+			defer c.Close()
+			c.SetReadLimit(10)
+			_, _, err = c.ReadMessage()
+			done <- err
+		}))
+		c, _, err := (&Dialer{EnableCompression: compress}).Dial(wsURL(ts), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.WriteMessage(BinaryMessage, data)
+		if err := <-done; err != ErrReadLimit {
+			t.Errorf("compress=%v: server error = %v; want ErrReadLimit", compress, err)
+		}
+		_, _, err = c.ReadMessage()
+		if ce, ok := err.(*CloseError); !ok || ce.Code != CloseMessageTooBig {
+			t.Errorf("compress=%v: client error = %v; want close %d", compress, err, CloseMessageTooBig)
+		}
+		c.Close()
+		ts.Close()
+	}
+}
+
+func TestHandshakeErrors(t *testing.T) {
+	ts := echoServer(&Upgrader{})
+	defer ts.Close()
+
+	h := http.Header{"Origin": {"http://evil.example"}}
+	_, res, err := DefaultDialer.Dial(wsURL(ts), h)
+	if err != ErrBadHandshake || res == nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-origin Dial = %v, %v; want 403 and ErrBadHandshake", res, err)
+	}
+
+	res, err = http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain GET status = %d; want 400", res.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-Websocket-Version", "8")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != statusUpgradeRequired || res.Header.Get("Sec-Websocket-Version") != "13" {
+		t.Errorf("version 8 status = %d, version %q; want 426, 13", res.StatusCode, res.Header.Get("Sec-Websocket-Version"))
+	}
+}
+
+func TestUpgradeBehindProxy(t *testing.T) {
+	addrs := make(chan string, 1)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := (&Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		addrs <- c.RemoteAddr().String()
+		c.WriteMessage(TextMessage, []byte("hi"))
+	}))
+	ts.StartProxy()
+	defer ts.Close()
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.7"), Port: 4242}
+	d := &Dialer{NetDial: ts.ProxyClient(src).Transport.(*http.Transport).Dial}
+	c, _, err := d.Dial(wsURL(ts), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, p, err := c.ReadMessage(); err != nil || string(p) != "hi" {
+		t.Fatalf("ReadMessage = %q, %v", p, err)
+	}
+	if got := <-addrs; got != src.String() {
+		t.Errorf("RemoteAddr = %q; want %q", got, src)
+	}
+}